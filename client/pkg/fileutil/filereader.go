@@ -21,6 +21,10 @@ import (
 	"os"
 )
 
+// DefaultBufReaderSize is the buffer size used by NewFileBufReader, the
+// default buffer size of bufio.Reader.
+const DefaultBufReaderSize = 4096
+
 // FileReader is a wrapper of io.Reader. It also provides file info.
 type FileReader interface {
 	io.Reader
@@ -46,7 +50,14 @@ type FileBufReader struct {
 }
 
 func NewFileBufReader(fr FileReader) *FileBufReader {
-	bufReader := bufio.NewReader(fr)
+	return NewFileBufReaderSize(fr, DefaultBufReaderSize)
+}
+
+// NewFileBufReaderSize returns a FileBufReader whose buffer has at least the
+// specified size. A larger buffer reduces the number of read syscalls when
+// scanning large files sequentially.
+func NewFileBufReaderSize(fr FileReader, size int) *FileBufReader {
+	bufReader := bufio.NewReaderSize(fr, size)
 	fi, err := fr.FileInfo()
 	if err != nil {
 		// This should never happen.
@@ -15,6 +15,7 @@
 package fileutil
 
 import (
+	"io"
 	"os"
 	"strings"
 	"testing"
@@ -42,3 +43,26 @@ func TestFileBufReader(t *testing.T) {
 	assert.Equal(t, fi.Mode(), fbr.FileInfo().Mode())
 	assert.Equal(t, fi.ModTime(), fbr.FileInfo().ModTime())
 }
+
+func TestFileBufReaderSize(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "wal")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := []byte(strings.Repeat("a", 1024*1024))
+	if _, err = f.Write(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err = f.Seek(0, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	fbr := NewFileBufReaderSize(NewFileReader(f), 256*1024)
+	assert.Equal(t, 256*1024, fbr.Size())
+	assert.Equal(t, int64(len(data)), fbr.FileInfo().Size())
+
+	got := make([]byte, len(data))
+	_, err = io.ReadFull(fbr, got)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
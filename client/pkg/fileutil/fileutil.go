@@ -96,6 +96,17 @@ func DirEmpty(name string) bool {
 	return len(ns) == 0 && err == nil
 }
 
+// FsyncDir fsyncs the directory dir, persisting the creations, renames and
+// removals of its entries.
+func FsyncDir(dir string) error {
+	d, err := OpenDir(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return Fsync(d)
+}
+
 // ZeroToEnd zeros a file starting from SEEK_CUR to its SEEK_END. May temporarily
 // shorten the length of the file.
 func ZeroToEnd(f *os.File) error {
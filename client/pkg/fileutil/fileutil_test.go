@@ -120,6 +120,16 @@ func TestDirEmpty(t *testing.T) {
 	}
 }
 
+func TestFsyncDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := FsyncDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := FsyncDir(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
+
 func TestZeroToEnd(t *testing.T) {
 	f, err := os.CreateTemp(os.TempDir(), "fileutil")
 	if err != nil {
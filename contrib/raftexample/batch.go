@@ -0,0 +1,102 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// batchMarker starts the data of an entry that batches several proposals,
+// each framed by its uvarint length. Proposals must not start with it, which
+// the gob encoded proposals of the kvstore never do.
+const batchMarker = 0x00
+
+var errCorruptBatch = errors.New("raftexample: corrupt proposal batch")
+
+// collectProposals batches prop with the proposals pending on proposeC, up
+// to maxBatchProposals of them within maxSizePerMsg bytes. It waits up to
+// batchInterval for more, or takes only those already sent if it is zero.
+// ok is false once proposeC is closed.
+func (rc *raftNode) collectProposals(prop string) (props []string, ok bool) {
+	props = []string{prop}
+	size := len(prop)
+
+	var timeout <-chan time.Time
+	if rc.batchInterval > 0 {
+		t := time.NewTimer(rc.batchInterval)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for len(props) < rc.maxBatchProposals && size < maxSizePerMsg {
+		if timeout == nil {
+			select {
+			case prop, ok = <-rc.proposeC:
+			default:
+				return props, true
+			}
+		} else {
+			select {
+			case prop, ok = <-rc.proposeC:
+			case <-timeout:
+				return props, true
+			}
+		}
+		if !ok {
+			return props, false
+		}
+		props = append(props, prop)
+		size += len(prop)
+	}
+	return props, true
+}
+
+// encodeProposals returns the entry data for props, which is the proposal
+// itself unless there are several.
+func encodeProposals(props []string) []byte {
+	if len(props) == 1 {
+		return []byte(props[0])
+	}
+	n := 1
+	for _, prop := range props {
+		n += binary.MaxVarintLen64 + len(prop)
+	}
+	data := make([]byte, 1, n)
+	data[0] = batchMarker
+	for _, prop := range props {
+		data = binary.AppendUvarint(data, uint64(len(prop)))
+		data = append(data, prop...)
+	}
+	return data
+}
+
+// decodeProposals returns the proposals of the entry data.
+func decodeProposals(data []byte) ([]string, error) {
+	if len(data) == 0 || data[0] != batchMarker {
+		return []string{string(data)}, nil
+	}
+	var props []string
+	for data = data[1:]; len(data) > 0; {
+		n, l := binary.Uvarint(data)
+		if l <= 0 || n > uint64(len(data)-l) {
+			return nil, errCorruptBatch
+		}
+		data = data[l:]
+		props = append(props, string(data[:n]))
+		data = data[n:]
+	}
+	return props, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// confChangeRequest is a set of membership changes that is applied
+// atomically, through a joint configuration when more than one voter is
+// changed. An empty set of changes leaves the joint configuration entered
+// by an explicit request.
+type confChangeRequest struct {
+	Changes []memberChange `json:"changes"`
+	// Explicit keeps the joint configuration until it is left by an empty
+	// request, instead of leaving it as soon as it is entered.
+	Explicit bool `json:"explicit,omitempty"`
+}
+
+type memberChange struct {
+	// Type is one of "add", "add-learner" and "remove".
+	Type string `json:"type"`
+	ID   uint64 `json:"id"`
+	// URL is the raft URL of an added member, which is known already when
+	// a learner is promoted.
+	URL string `json:"url,omitempty"`
+}
+
+var memberChangeTypes = map[string]raftpb.ConfChangeType{
+	"add":         raftpb.ConfChangeAddNode,
+	"add-learner": raftpb.ConfChangeAddLearnerNode,
+	"remove":      raftpb.ConfChangeRemoveNode,
+}
+
+// confChangeV2 converts req into a ConfChangeV2, whose context carries the
+// URLs of the added members by ID.
+func (req confChangeRequest) confChangeV2() (raftpb.ConfChangeV2, error) {
+	cc := raftpb.ConfChangeV2{Transition: raftpb.ConfChangeTransitionAuto}
+	if req.Explicit {
+		cc.Transition = raftpb.ConfChangeTransitionJointExplicit
+	}
+	urls := make(map[uint64]string)
+	for _, c := range req.Changes {
+		typ, ok := memberChangeTypes[c.Type]
+		if !ok {
+			return cc, fmt.Errorf("unknown member change type %q", c.Type)
+		}
+		if c.ID == 0 {
+			return cc, fmt.Errorf("invalid member ID 0")
+		}
+		cc.Changes = append(cc.Changes, raftpb.ConfChangeSingle{Type: typ, NodeID: c.ID})
+		if typ != raftpb.ConfChangeRemoveNode && c.URL != "" {
+			urls[c.ID] = c.URL
+		}
+	}
+	if len(urls) > 0 {
+		var err error
+		if cc.Context, err = json.Marshal(urls); err != nil {
+			return cc, err
+		}
+	}
+	return cc, nil
+}
+
+// applyPeerChanges updates the transport to the configuration confState
+// reached by applying cc, and returns false if this node is no longer a
+// member. The removed members stay peers while they are in the outgoing
+// configuration of a joint one.
+func (rc *raftNode) applyPeerChanges(cc raftpb.ConfChangeV2, confState raftpb.ConfState) bool {
+	if len(cc.Context) > 0 {
+		var urls map[uint64]string
+		if err := json.Unmarshal(cc.Context, &urls); err != nil {
+			log.Printf("raftexample: ignoring the peer URLs of a conf change (%v)", err)
+		}
+		for id, u := range urls {
+			if id != uint64(rc.id) {
+				rc.addPeer(id, u)
+			}
+		}
+	}
+
+	members := make(map[uint64]bool)
+	for _, ids := range [][]uint64{confState.Voters, confState.VotersOutgoing, confState.Learners, confState.LearnersNext} {
+		for _, id := range ids {
+			members[id] = true
+		}
+	}
+	if !members[uint64(rc.id)] {
+		return false
+	}
+
+	var removed []uint64
+	rc.peerMu.Lock()
+	for id := range rc.peerURLs {
+		if !members[id] {
+			removed = append(removed, id)
+		}
+	}
+	rc.peerMu.Unlock()
+	for _, id := range removed {
+		rc.removePeer(id)
+	}
+	return true
+}
+
+// member is a member of the applied configuration, as reported by GET
+// /members.
+type member struct {
+	ID      uint64 `json:"id"`
+	PeerURL string `json:"peerURL"`
+	Learner bool   `json:"learner,omitempty"`
+}
+
+// members returns the members of the applied configuration, including both
+// sides of a joint one, sorted by ID.
+func (rc *raftNode) members() []member {
+	rc.confMu.Lock()
+	cs := rc.confState
+	rc.confMu.Unlock()
+
+	learners := make(map[uint64]bool)
+	for _, ids := range [][]uint64{cs.Learners, cs.LearnersNext} {
+		for _, id := range ids {
+			learners[id] = true
+		}
+	}
+	seen := make(map[uint64]bool)
+	var ms []member
+	rc.peerMu.Lock()
+	for _, ids := range [][]uint64{cs.Voters, cs.VotersOutgoing, cs.Learners, cs.LearnersNext} {
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			u := rc.peerURLs[id]
+			if id == uint64(rc.id) {
+				u = rc.peers[rc.id-1]
+			}
+			ms = append(ms, member{ID: id, PeerURL: u, Learner: learners[id]})
+		}
+	}
+	rc.peerMu.Unlock()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	return ms
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A registration conflicting with another one for the same ID is retried
+// discoveryRetries times, every discoveryRetryInterval.
+var (
+	discoveryRetries       = 5
+	discoveryRetryInterval = time.Second
+)
+
+// discover registers peerURL as a member with the node serving the HTTP API
+// at seed, and returns the ID the member was assigned and the peer URLs of
+// the members indexed by ID minus one, as newRaftNode takes them. The node
+// then joins the cluster as that member.
+func discover(c *http.Client, seed, peerURL string, learner bool) (int, []string, error) {
+	body, err := json.Marshal(memberRequest{PeerURL: peerURL, Learner: learner})
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := 0; ; i++ {
+		resp, err := c.Post(strings.TrimSuffix(seed, "/")+"/members", "application/json", bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		if resp.StatusCode == http.StatusConflict && i < discoveryRetries {
+			resp.Body.Close()
+			log.Printf("raftexample: member ID taken, retrying discovery")
+			time.Sleep(discoveryRetryInterval)
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(resp.Body)
+			return 0, nil, fmt.Errorf("raftexample: discovery failed with status %s (%s)", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		var mr memberResponse
+		if err := json.NewDecoder(resp.Body).Decode(&mr); err != nil {
+			return 0, nil, err
+		}
+		var peers []string
+		for _, m := range mr.Members {
+			for uint64(len(peers)) < m.ID {
+				peers = append(peers, "")
+			}
+			peers[m.ID-1] = m.PeerURL
+		}
+		return int(mr.ID), peers, nil
+	}
+}
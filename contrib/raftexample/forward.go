@@ -0,0 +1,81 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// leaderHeader holds the HTTP API URL of the leader in the replies to
+	// the writes a follower proposes itself.
+	leaderHeader = "X-Raftexample-Leader"
+	// forwardedHeader marks the writes forwarded by a follower, with its
+	// ID, which are not forwarded again.
+	forwardedHeader = "X-Raftexample-Forwarded-By"
+)
+
+// validateClientURLs checks that the HTTP API URLs of the members are
+// absolute http or https URLs.
+func validateClientURLs(urls []string) error {
+	for _, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil {
+			return fmt.Errorf("raftexample: bad client URL %q (%v)", s, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("raftexample: client URL %q must be an http or https URL", s)
+		}
+	}
+	return nil
+}
+
+// forwardWrite forwards the write r received by a follower to the leader,
+// and reports whether it did. The write is left to the follower if the
+// leader or its URL is unknown, if r was forwarded already, which the
+// leadership may have changed since, or if forwarding is disabled, in which
+// case the reply tells the leader's URL.
+func (h *httpKVAPI) forwardWrite(w http.ResponseWriter, r *http.Request) bool {
+	if len(h.clientURLs) == 0 || r.Header.Get(forwardedHeader) != "" {
+		return false
+	}
+	respC := make(chan nodeStatus, 1)
+	h.statusC <- respC
+	st := <-respC
+	if st.Lead == 0 || st.Lead == st.ID || st.Lead > uint64(len(h.clientURLs)) {
+		return false
+	}
+	leader := h.clientURLs[st.Lead-1]
+	if !h.forwardWrites {
+		w.Header().Set(leaderHeader, leader)
+		return false
+	}
+	u, err := url.Parse(leader)
+	if err != nil {
+		// validated by validateClientURLs
+		log.Panic(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Transport = h.forwardTransport
+	r.Header.Set(forwardedHeader, strconv.FormatUint(st.ID, 10))
+	proxy.ServeHTTP(w, r)
+	writesForwarded.Inc()
+	return true
+}
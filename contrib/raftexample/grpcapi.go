@@ -0,0 +1,160 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"go.etcd.io/etcd/v3/contrib/raftexample/kvpb"
+)
+
+// grpcKVAPI serves the key-value store over gRPC, like httpKVAPI does over
+// HTTP.
+type grpcKVAPI struct {
+	kvpb.UnimplementedKVServer
+
+	store      *kvstore
+	readIndexC chan<- chan<- error
+	// readOnly refuses the writes of a read-only replica.
+	readOnly bool
+}
+
+var errGRPCReadOnly = status.Error(codes.FailedPrecondition, "raftexample: read-only replica refuses writes")
+
+func (g *grpcKVAPI) Put(_ context.Context, req *kvpb.PutRequest) (*kvpb.PutResponse, error) {
+	if g.readOnly {
+		return nil, errGRPCReadOnly
+	}
+	if err := g.store.Propose(req.Key, req.Value); err != nil {
+		return nil, proposeError(err)
+	}
+	// Optimistic, as over HTTP, that raft will commit the update
+	return &kvpb.PutResponse{}, nil
+}
+
+func (g *grpcKVAPI) Get(_ context.Context, req *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	if req.Linearizable {
+		errC := make(chan error, 1)
+		g.readIndexC <- errC
+		if err := <-errC; err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+	}
+	if req.Prefix {
+		var resp kvpb.GetResponse
+		for k, v := range g.store.List(req.Key) {
+			resp.Kvs = append(resp.Kvs, &kvpb.KeyValue{Key: k, Value: v})
+		}
+		sort.Slice(resp.Kvs, func(i, j int) bool { return resp.Kvs[i].Key < resp.Kvs[j].Key })
+		return &resp, nil
+	}
+	v, ok := g.store.Lookup(req.Key)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "raftexample: key %q not found", req.Key)
+	}
+	return &kvpb.GetResponse{Kvs: []*kvpb.KeyValue{{Key: req.Key, Value: v}}}, nil
+}
+
+func (g *grpcKVAPI) Delete(_ context.Context, req *kvpb.DeleteRequest) (*kvpb.DeleteResponse, error) {
+	if g.readOnly {
+		return nil, errGRPCReadOnly
+	}
+	if err := g.store.Delete(req.Key); err != nil {
+		return nil, proposeError(err)
+	}
+	return &kvpb.DeleteResponse{}, nil
+}
+
+// Watch streams the updates applied by the local store, the ones already
+// buffered being sent together. The stream fails if the client falls behind.
+func (g *grpcKVAPI) Watch(req *kvpb.WatchRequest, stream kvpb.KV_WatchServer) error {
+	c, cancel := g.store.Watch(req.Key, req.Prefix)
+	defer cancel()
+	for {
+		select {
+		case u, ok := <-c:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "raftexample: watcher fell behind")
+			}
+			resp := &kvpb.WatchResponse{Events: []*kvpb.Event{watchEvent(u)}}
+			for more := true; more && len(resp.Events) < watchBufSize; {
+				select {
+				case u, ok := <-c:
+					if more = ok; ok {
+						resp.Events = append(resp.Events, watchEvent(u))
+					}
+				default:
+					more = false
+				}
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// proposeError is the status of a write whose proposal failed.
+func proposeError(err error) error {
+	if errors.Is(err, errShuttingDown) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.ResourceExhausted, err.Error())
+}
+
+func watchEvent(u kv) *kvpb.Event {
+	if u.Delete {
+		return &kvpb.Event{Type: kvpb.Event_DELETE, Kv: &kvpb.KeyValue{Key: u.Key}}
+	}
+	return &kvpb.Event{Type: kvpb.Event_PUT, Kv: &kvpb.KeyValue{Key: u.Key, Value: u.Val}}
+}
+
+// serveGRPCKVAPI starts a gRPC key-value server listening on port, over TLS
+// unless tlsInfo is empty.
+func serveGRPCKVAPI(kv *kvstore, port int, readIndexC chan<- chan<- error, readOnly bool, tlsInfo transport.TLSInfo) {
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var opts []grpc.ServerOption
+	if !tlsInfo.Empty() {
+		cfg, err := tlsInfo.ServerConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	srv := grpc.NewServer(opts...)
+	kvpb.RegisterKVServer(srv, &grpcKVAPI{store: kv, readIndexC: readIndexC, readOnly: readOnly})
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
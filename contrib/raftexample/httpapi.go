@@ -15,25 +15,94 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	"go.etcd.io/raft/v3/raftpb"
 )
 
 // Handler for a http based key-value store backed by raft
 type httpKVAPI struct {
 	store       *kvstore
-	confChangeC chan<- raftpb.ConfChange
+	confChangeC chan<- raftpb.ConfChangeI
+	transferC   chan<- leadershipTransfer
+	readIndexC  chan<- chan<- error
+	confStateC  chan<- chan<- raftpb.ConfState
+	statusC     chan<- chan<- nodeStatus
+	// readOnly serves the GETs of a read-only replica only, writes and
+	// membership changes are sent to a member.
+	readOnly bool
+
+	membersC chan<- chan<- []member
+	// clientURLs are the HTTP API URLs of the members, indexed by ID-1. The
+	// writes a follower receives are forwarded to the leader's, over
+	// forwardTransport, if forwardWrites is set, see forwardWrite.
+	clientURLs       []string
+	forwardWrites    bool
+	forwardTransport http.RoundTripper
+	// shutdownC requests a graceful shutdown, see gracefulShutdown.
+	shutdownC chan<- struct{}
+	// membersMu serializes the members added by POST /members, which
+	// assigns them IDs.
+	membersMu sync.Mutex
 }
 
+// The members added by POST /members are waited for until memberAddTimeout,
+// polling every memberPollInterval.
+var (
+	memberAddTimeout   = 5 * time.Second
+	memberPollInterval = 50 * time.Millisecond
+)
+
 func (h *httpKVAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	key := r.RequestURI
 	defer r.Body.Close()
+	if r.URL.Path == "/shutdown" {
+		// a read-only replica may be shut down too
+		h.serveShutdown(w, r)
+		return
+	}
+	if h.readOnly && r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Read-only replica", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/transfer-leadership" {
+		h.serveTransferLeadership(w, r)
+		return
+	}
+	if r.URL.Path == "/conf-change" {
+		h.serveConfChange(w, r)
+		return
+	}
+	if r.URL.Path == "/raft/status" {
+		h.serveStatus(w, r)
+		return
+	}
+	if r.URL.Path == "/members" {
+		h.serveMembers(w, r)
+		return
+	}
+	if r.URL.Path == "/txn" {
+		h.serveTxn(w, r)
+		return
+	}
 	switch r.Method {
 	case http.MethodPut:
+		if h.forwardWrite(w, r) {
+			return
+		}
 		v, err := io.ReadAll(r.Body)
 		if err != nil {
 			log.Printf("Failed to read on PUT (%v)\n", err)
@@ -41,12 +110,49 @@ func (h *httpKVAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		h.store.Propose(key, string(v))
+		if r.URL.Query().Has("prev") {
+			h.serveCompareAndSwap(w, r, string(v))
+			return
+		}
+
+		req, err := parseClientRequest(r)
+		if err != nil {
+			log.Printf("Failed to parse client request on PUT (%v)\n", err)
+			http.Error(w, "Failed on PUT", http.StatusBadRequest)
+			return
+		}
+		if req != (clientRequest{}) {
+			// the key is then the path without the query
+			key = r.URL.Path
+		}
+		if err := h.store.ProposeOnce(req, key, string(v)); err != nil {
+			log.Printf("Failed to propose on PUT (%v)\n", err)
+			http.Error(w, "Failed on PUT", proposeStatus(err))
+			return
+		}
 
 		// Optimistic-- no waiting for ack from raft. Value is not yet
 		// committed so a subsequent GET on the key may return old value
 		w.WriteHeader(http.StatusNoContent)
 	case http.MethodGet:
+		if r.URL.Query().Has("linearizable") {
+			// Wait for the store to apply every write committed before
+			// the GET, the key is then the path without the query.
+			key = r.URL.Path
+			errC := make(chan error, 1)
+			h.readIndexC <- errC
+			if err := <-errC; err != nil {
+				log.Printf("Failed to confirm read index (%v)\n", err)
+				http.Error(w, "Failed to GET", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if r.URL.Query().Has("prefix") {
+			// list the keys starting with the path
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(h.store.List(r.URL.Path))
+			return
+		}
 		if v, ok := h.store.Lookup(key); ok {
 			w.Write([]byte(v))
 		} else {
@@ -60,15 +166,22 @@ func (h *httpKVAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		nodeID, err := strconv.ParseUint(key[1:], 0, 64)
+		nodeID, err := strconv.ParseUint(r.URL.Path[1:], 0, 64)
 		if err != nil {
 			log.Printf("Failed to convert ID for conf change (%v)\n", err)
 			http.Error(w, "Failed on POST", http.StatusBadRequest)
 			return
 		}
 
+		// A learner receives the log but does not vote, so adding one
+		// does not change the quorum. POSTing its ID again without the
+		// learner parameter promotes it to a voter.
+		ccType := raftpb.ConfChangeAddNode
+		if r.URL.Query().Has("learner") {
+			ccType = raftpb.ConfChangeAddLearnerNode
+		}
 		cc := raftpb.ConfChange{
-			Type:    raftpb.ConfChangeAddNode,
+			Type:    ccType,
 			NodeID:  nodeID,
 			Context: url,
 		}
@@ -76,6 +189,27 @@ func (h *httpKVAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// As above, optimistic that raft will apply the conf change
 		w.WriteHeader(http.StatusNoContent)
 	case http.MethodDelete:
+		if r.URL.Query().Has("key") {
+			// a key is deleted rather than a node removed
+			if h.forwardWrite(w, r) {
+				return
+			}
+			req, err := parseClientRequest(r)
+			if err != nil {
+				log.Printf("Failed to parse client request on DELETE (%v)\n", err)
+				http.Error(w, "Failed on DELETE", http.StatusBadRequest)
+				return
+			}
+			if err := h.store.DeleteOnce(req, r.URL.Path); err != nil {
+				log.Printf("Failed to propose on DELETE (%v)\n", err)
+				http.Error(w, "Failed on DELETE", proposeStatus(err))
+				return
+			}
+			// As for PUT, optimistic that raft will commit the deletion
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
 		nodeID, err := strconv.ParseUint(key[1:], 0, 64)
 		if err != nil {
 			log.Printf("Failed to convert ID for conf change (%v)\n", err)
@@ -100,17 +234,334 @@ func (h *httpKVAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseClientRequest parses the client and seq parameters of a write, which
+// identify the request of a client so that its retries are applied once.
+// A write without them identifies no request.
+func parseClientRequest(r *http.Request) (clientRequest, error) {
+	q := r.URL.Query()
+	if !q.Has("client") && !q.Has("seq") {
+		return clientRequest{}, nil
+	}
+	id, err := strconv.ParseUint(q.Get("client"), 0, 64)
+	if err != nil {
+		return clientRequest{}, err
+	}
+	seq, err := strconv.ParseUint(q.Get("seq"), 0, 64)
+	if err != nil {
+		return clientRequest{}, err
+	}
+	if id == 0 || seq == 0 {
+		return clientRequest{}, errors.New("client and seq must be positive")
+	}
+	return clientRequest{ClientID: id, Seq: seq}, nil
+}
+
+// serveTransferLeadership reports the leadership on GET, and on POST asks
+// raft to transfer it to the node given by the target parameter, so that
+// the leader can be drained before maintenance.
+func (h *httpKVAPI) serveTransferLeadership(w http.ResponseWriter, r *http.Request) {
+	var target uint64
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var err error
+		target, err = strconv.ParseUint(r.URL.Query().Get("target"), 0, 64)
+		if err != nil || target == 0 {
+			log.Printf("Failed to convert target ID for leadership transfer (%v)\n", err)
+			http.Error(w, "Failed on POST", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statusC := make(chan leadership, 1)
+	h.transferC <- leadershipTransfer{target: target, statusC: statusC}
+	status := <-statusC
+
+	// Optimistic-- the transfer completes once the target has caught up
+	// and won the election, which a later GET reports.
+	w.Header().Set("Content-Type", "application/json")
+	if target != 0 {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// serveConfChange reports the applied configuration on GET, and on POST
+// proposes a confChangeRequest, which replaces several members atomically.
+func (h *httpKVAPI) serveConfChange(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		respC := make(chan raftpb.ConfState, 1)
+		h.confStateC <- respC
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(<-respC)
+	case http.MethodPost:
+		var req confChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Printf("Failed to decode conf change (%v)\n", err)
+			http.Error(w, "Failed on POST", http.StatusBadRequest)
+			return
+		}
+		cc, err := req.confChangeV2()
+		if err != nil {
+			log.Printf("Failed to convert conf change (%v)\n", err)
+			http.Error(w, "Failed on POST", http.StatusBadRequest)
+			return
+		}
+		h.confChangeC <- cc
+
+		// Optimistic that raft will apply the conf change, a later GET
+		// reports the configuration it led to.
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveStatus reports the raft status of the node on GET.
+func (h *httpKVAPI) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	respC := make(chan nodeStatus, 1)
+	h.statusC <- respC
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(<-respC)
+}
+
+// proposeStatus is the status of a write whose proposal failed.
+func proposeStatus(err error) int {
+	switch {
+	case errors.Is(err, errProposalTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errShuttingDown):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// serveCompareAndSwap sets the key at the path to v if it is set to the
+// prev parameter, and waits until raft applies the write to report whether
+// it did.
+func (h *httpKVAPI) serveCompareAndSwap(w http.ResponseWriter, r *http.Request, v string) {
+	ctx, cancel := context.WithTimeout(r.Context(), resultTimeout)
+	defer cancel()
+	swapped, err := h.store.CompareAndSwap(ctx, r.URL.Path, r.URL.Query().Get("prev"), v)
+	switch {
+	case errors.Is(err, errProposalTooLarge), errors.Is(err, errShuttingDown):
+		log.Printf("Failed to propose on PUT (%v)\n", err)
+		http.Error(w, "Failed on PUT", proposeStatus(err))
+	case err != nil:
+		// the write may still be applied
+		log.Printf("Failed to wait for compare-and-swap (%v)\n", err)
+		http.Error(w, "Failed on PUT", http.StatusServiceUnavailable)
+	case !swapped:
+		http.Error(w, "Value does not match prev", http.StatusPreconditionFailed)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serveTxn applies the JSON list of operations POSTed as a transaction, and
+// replies the results of its gets once raft has applied it.
+func (h *httpKVAPI) serveTxn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.forwardWrite(w, r) {
+		return
+	}
+	var ops []txnOp
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		log.Printf("Failed to decode transaction (%v)\n", err)
+		http.Error(w, "Failed on POST", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), resultTimeout)
+	defer cancel()
+	results, err := h.store.Txn(ctx, ops)
+	switch {
+	case errors.Is(err, errBadTxnOp):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errProposalTooLarge), errors.Is(err, errShuttingDown):
+		log.Printf("Failed to propose transaction (%v)\n", err)
+		http.Error(w, "Failed on POST", proposeStatus(err))
+	case err != nil:
+		// the transaction may still be applied
+		log.Printf("Failed to wait for transaction (%v)\n", err)
+		http.Error(w, "Failed on POST", http.StatusServiceUnavailable)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// serveShutdown requests a graceful shutdown on POST, which completes once
+// the writes in flight are applied.
+func (h *httpKVAPI) serveShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case h.shutdownC <- struct{}{}:
+	default:
+		// a shutdown is requested already
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// memberRequest asks POST /members to add a member with the peer URL.
+type memberRequest struct {
+	PeerURL string `json:"peerURL"`
+	Learner bool   `json:"learner,omitempty"`
+}
+
+// memberResponse is the ID of the member added by POST /members, and the
+// configuration it was added to.
+type memberResponse struct {
+	ID      uint64   `json:"id"`
+	Members []member `json:"members"`
+}
+
+// serveMembers reports the members on GET. On POST, it adds a member
+// with the next free ID, or finds the member with the same peer URL, and
+// reports its ID and the configuration once raft has applied it, so that
+// a node can join without knowing the cluster beforehand, see discover.
+func (h *httpKVAPI) serveMembers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.members())
+	case http.MethodPost:
+		var req memberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PeerURL == "" {
+			log.Printf("Failed to decode member (%v)\n", err)
+			http.Error(w, "Failed on POST", http.StatusBadRequest)
+			return
+		}
+
+		h.membersMu.Lock()
+		defer h.membersMu.Unlock()
+		ms := h.members()
+		id := memberID(ms, req.PeerURL)
+		if id == 0 {
+			for _, m := range ms {
+				id = max(id, m.ID)
+			}
+			id++
+			ccType := raftpb.ConfChangeAddNode
+			if req.Learner {
+				ccType = raftpb.ConfChangeAddLearnerNode
+			}
+			h.confChangeC <- raftpb.ConfChange{Type: ccType, NodeID: id, Context: []byte(req.PeerURL)}
+		}
+
+		deadline := time.Now().Add(memberAddTimeout)
+		for ; !hasMember(ms, id) && time.Now().Before(deadline); ms = h.members() {
+			time.Sleep(memberPollInterval)
+		}
+		switch {
+		case !hasMember(ms, id):
+			// the member may still be added
+			http.Error(w, "Timed out adding the member", http.StatusServiceUnavailable)
+		case memberID(ms, req.PeerURL) != id:
+			// another node added a member with the same ID meanwhile
+			http.Error(w, "Member ID taken", http.StatusConflict)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(memberResponse{ID: id, Members: ms})
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *httpKVAPI) members() []member {
+	respC := make(chan []member, 1)
+	h.membersC <- respC
+	return <-respC
+}
+
+// memberID returns the ID of the member with the peer URL, or zero.
+func memberID(ms []member, peerURL string) uint64 {
+	for _, m := range ms {
+		if m.PeerURL == peerURL {
+			return m.ID
+		}
+	}
+	return 0
+}
+
+func hasMember(ms []member, id uint64) bool {
+	for _, m := range ms {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 // serveHTTPKVAPI starts a key-value server with a GET/PUT API and listens.
-func serveHTTPKVAPI(kv *kvstore, port int, confChangeC chan<- raftpb.ConfChange, errorC <-chan error) {
-	srv := http.Server{
-		Addr: ":" + strconv.Itoa(port),
-		Handler: &httpKVAPI{
-			store:       kv,
-			confChangeC: confChangeC,
-		},
+// httpConfig configures the HTTP key-value API.
+type httpConfig struct {
+	port     int
+	readOnly bool
+	// clientURLs are the HTTP API URLs of the members, indexed by ID-1.
+	clientURLs    []string
+	forwardWrites bool
+	tlsInfo       transport.TLSInfo
+}
+
+func serveHTTPKVAPI(kv *kvstore, cfg httpConfig, chans raftChannels, shutdownC chan<- struct{}, errorC <-chan error) {
+	// the writes are forwarded with the client TLS configuration, the
+	// leader's certificate being signed by the same CA
+	tr, err := transport.NewTransport(cfg.tlsInfo, peerDialTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/", &httpKVAPI{
+		store:       kv,
+		confChangeC: chans.confChangeC,
+		transferC:   chans.transferC,
+		readIndexC:  chans.readIndexC,
+		confStateC:  chans.confStateC,
+		statusC:     chans.statusC,
+		readOnly:    cfg.readOnly,
+		membersC:    chans.membersC,
+		shutdownC:   shutdownC,
+
+		clientURLs:       cfg.clientURLs,
+		forwardWrites:    cfg.forwardWrites,
+		forwardTransport: tr,
+	})
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ln, err = newTLSListener(ln, cfg.tlsInfo); err != nil {
+		log.Fatal(err)
 	}
+	srv := http.Server{Handler: mux}
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
+		if err := srv.Serve(ln); err != nil {
 			log.Fatal(err)
 		}
 	}()
@@ -0,0 +1,182 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// The store is snapshotted in chunks of key-value pairs, so that a snapshot
+// is written and read one chunk at a time instead of as a whole:
+//
+//	snapshot: magic "rxkv" | version uint32 | section | section
+//	section:  chunk... | uint32 0
+//	chunk:    length uint32 | pair... | crc32c of the pairs uint32
+//	pair:     uvarint key length | key | uvarint value length | value
+//
+// The integers are big endian. A pair never spans two chunks. The first
+// section holds the key-value pairs, the second the client sessions, whose
+// client IDs and sequence numbers are written as 8 byte pairs; version 1
+// snapshots have no sessions section. The snapshots taken before this format
+// are JSON objects, see recoverFromSnapshot.
+const (
+	kvSnapshotMagic   = "rxkv"
+	kvSnapshotVersion = 2
+)
+
+// kvSnapshotChunkBytes is the size above which a chunk is written out.
+var kvSnapshotChunkBytes = 64 * 1024
+
+var kvSnapshotCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errSnapshotCorrupt = errors.New("raftexample: corrupt snapshot")
+
+// snapshotWriter writes key-value pairs in the snapshot format.
+type snapshotWriter struct {
+	w          io.Writer
+	chunk      []byte
+	chunkBytes int
+}
+
+// newSnapshotWriter writes the header of a snapshot to w, and returns a
+// writer for its pairs, which are buffered up to chunkBytes.
+func newSnapshotWriter(w io.Writer, chunkBytes int) (*snapshotWriter, error) {
+	hdr := make([]byte, len(kvSnapshotMagic)+4)
+	copy(hdr, kvSnapshotMagic)
+	binary.BigEndian.PutUint32(hdr[len(kvSnapshotMagic):], kvSnapshotVersion)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &snapshotWriter{w: w, chunk: make([]byte, 0, chunkBytes), chunkBytes: chunkBytes}, nil
+}
+
+func (sw *snapshotWriter) write(key, val string) error {
+	sw.chunk = binary.AppendUvarint(sw.chunk, uint64(len(key)))
+	sw.chunk = append(sw.chunk, key...)
+	sw.chunk = binary.AppendUvarint(sw.chunk, uint64(len(val)))
+	sw.chunk = append(sw.chunk, val...)
+	if len(sw.chunk) >= sw.chunkBytes {
+		return sw.flush()
+	}
+	return nil
+}
+
+func (sw *snapshotWriter) flush() error {
+	if len(sw.chunk) == 0 {
+		return nil
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(sw.chunk)))
+	if _, err := sw.w.Write(b[:]); err != nil {
+		return err
+	}
+	if _, err := sw.w.Write(sw.chunk); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(b[:], crc32.Checksum(sw.chunk, kvSnapshotCRCTable))
+	if _, err := sw.w.Write(b[:]); err != nil {
+		return err
+	}
+	sw.chunk = sw.chunk[:0]
+	return nil
+}
+
+// end writes the last chunk and the end of a section.
+func (sw *snapshotWriter) end() error {
+	if err := sw.flush(); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(make([]byte, 4))
+	return err
+}
+
+// snapshotReader reads the key-value pairs of a snapshot, verifying each
+// chunk as it is read.
+type snapshotReader struct {
+	r       io.Reader
+	version uint32
+	buf     []byte
+	chunk   []byte // the pairs of the current chunk not read yet
+}
+
+// newSnapshotReader reads the header of the snapshot in r.
+func newSnapshotReader(r io.Reader) (*snapshotReader, error) {
+	hdr := make([]byte, len(kvSnapshotMagic)+4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("%w: reading header (%v)", errSnapshotCorrupt, err)
+	}
+	if string(hdr[:len(kvSnapshotMagic)]) != kvSnapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic %q", errSnapshotCorrupt, hdr[:len(kvSnapshotMagic)])
+	}
+	v := binary.BigEndian.Uint32(hdr[len(kvSnapshotMagic):])
+	if v == 0 || v > kvSnapshotVersion {
+		return nil, fmt.Errorf("raftexample: unsupported snapshot version %d", v)
+	}
+	return &snapshotReader{r: r, version: v}, nil
+}
+
+// next returns the next pair of the snapshot, or io.EOF at the end of a
+// section.
+func (sr *snapshotReader) next() (key, val string, err error) {
+	for len(sr.chunk) == 0 {
+		if err := sr.readChunk(); err != nil {
+			return "", "", err
+		}
+	}
+	if key, err = sr.readString(); err != nil {
+		return "", "", err
+	}
+	if val, err = sr.readString(); err != nil {
+		return "", "", err
+	}
+	return key, val, nil
+}
+
+func (sr *snapshotReader) readChunk() error {
+	var b [4]byte
+	if _, err := io.ReadFull(sr.r, b[:]); err != nil {
+		return fmt.Errorf("%w: reading chunk length (%v)", errSnapshotCorrupt, err)
+	}
+	n := int(binary.BigEndian.Uint32(b[:]))
+	if n == 0 {
+		return io.EOF
+	}
+	if cap(sr.buf) < n+4 {
+		sr.buf = make([]byte, n+4)
+	}
+	buf := sr.buf[:n+4]
+	if _, err := io.ReadFull(sr.r, buf); err != nil {
+		return fmt.Errorf("%w: reading chunk (%v)", errSnapshotCorrupt, err)
+	}
+	if crc := binary.BigEndian.Uint32(buf[n:]); crc != crc32.Checksum(buf[:n], kvSnapshotCRCTable) {
+		return fmt.Errorf("%w: chunk checksum mismatch", errSnapshotCorrupt)
+	}
+	sr.chunk = buf[:n]
+	return nil
+}
+
+func (sr *snapshotReader) readString() (string, error) {
+	l, n := binary.Uvarint(sr.chunk)
+	if n <= 0 || l > uint64(len(sr.chunk)-n) {
+		return "", fmt.Errorf("%w: truncated pair", errSnapshotCorrupt)
+	}
+	s := string(sr.chunk[n : n+int(l)])
+	sr.chunk = sr.chunk[n+int(l):]
+	return s, nil
+}
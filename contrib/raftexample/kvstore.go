@@ -16,31 +16,125 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"strings"
 	"sync"
+	"time"
 
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/pkg/v3/schedule"
+	"go.etcd.io/etcd/pkg/v3/wait"
 	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
 	"go.etcd.io/raft/v3/raftpb"
 )
 
+// kvConfig configures a kvstore, see defaultKVConfig.
+type kvConfig struct {
+	// maxProposalBytes is the size above which proposals are refused. It is
+	// independent of the raft message size, which only bounds batching.
+	maxProposalBytes int
+	// applyWorkers is the number of committed updates applied concurrently,
+	// the updates to the same key are applied in log order.
+	applyWorkers int
+}
+
+func defaultKVConfig() kvConfig {
+	return kvConfig{maxProposalBytes: 1024 * 1024, applyWorkers: 1}
+}
+
+// errProposalTooLarge is returned by Propose for proposals larger than the
+// maximum proposal size, which fail before reaching raft.
+var errProposalTooLarge = errors.New("raftexample: proposal too large")
+
+// errShuttingDown is returned by Propose once the proposals are stopped for a
+// shutdown.
+var errShuttingDown = errors.New("raftexample: shutting down")
+
+// resultTimeout bounds how long a compare-and-swap or a transaction waits to
+// be applied.
+var resultTimeout = 5 * time.Second
+
 // a key-value store backed by raft
 type kvstore struct {
 	proposeC    chan<- string // channel for proposing updates
 	mu          sync.RWMutex
 	kvStore     map[string]string // current committed key-value pairs
 	snapshotter *snap.Snapshotter
+	// sessions holds the sequence number of the last request applied for
+	// each client, so that the retries of a request are applied once.
+	sessions map[uint64]uint64
+
+	maxProposalBytes int
+	applyWorkers     int
+
+	watchMu  sync.Mutex
+	watchers map[*watcher]struct{}
+
+	// resultWait reports the results of the compare-and-swaps and the
+	// transactions proposed by this store, once applied, to the callers
+	// waiting on their IDs.
+	resultWait wait.Wait
+
+	// proposeMu guards the sends to proposeC, which stop once stopped is
+	// set, so that proposeC can then be closed.
+	proposeMu sync.RWMutex
+	stopped   bool
+}
+
+// watchBufSize bounds the updates buffered for a watcher, whose watch is
+// canceled once it falls further behind.
+const watchBufSize = 128
+
+type watcher struct {
+	key    string
+	prefix bool
+	c      chan kv
 }
 
 type kv struct {
 	Key string
 	Val string
+	// Delete deletes the key instead of setting it to Val.
+	Delete bool
+
+	// CAS sets the key to Val only if it is set to Prev, and reports
+	// whether it did to the proposer waiting on ID.
+	CAS  bool
+	Prev string
+	ID   uint64
+
+	// Txn applies the operations of a transaction as a unit, instead of
+	// the update of Key, and reports their results to the proposer
+	// waiting on ID.
+	Txn []txnOp
+
+	// ClientID and Seq identify the request of a client the update was
+	// proposed for, see clientRequest.
+	ClientID uint64
+	Seq      uint64
 }
 
-func newKVStore(snapshotter *snap.Snapshotter, proposeC chan<- string, commitC <-chan *commit, errorC <-chan error) *kvstore {
-	s := &kvstore{proposeC: proposeC, kvStore: make(map[string]string), snapshotter: snapshotter}
+// clientRequest identifies a request of a client by its sequence number,
+// which the client increments for each new request and keeps for the
+// retries of a request. A request is applied only if its sequence number is
+// above the last one applied for the client. The zero clientRequest
+// identifies no request, its updates are always applied.
+type clientRequest struct {
+	ClientID uint64
+	Seq      uint64
+}
+
+func newKVStore(cfg kvConfig, snapshotter *snap.Snapshotter, proposeC chan<- string, commitC <-chan *commit, errorC <-chan error) *kvstore {
+	s := &kvstore{proposeC: proposeC, kvStore: make(map[string]string), sessions: make(map[uint64]uint64), snapshotter: snapshotter, maxProposalBytes: cfg.maxProposalBytes, applyWorkers: cfg.applyWorkers, resultWait: wait.New()}
 	snapshot, err := s.loadSnapshot()
 	if err != nil {
 		log.Panic(err)
@@ -63,15 +157,103 @@ func (s *kvstore) Lookup(key string) (string, bool) {
 	return v, ok
 }
 
-func (s *kvstore) Propose(k string, v string) {
+// List returns the key-value pairs whose key starts with prefix.
+func (s *kvstore) List(prefix string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	kvs := make(map[string]string)
+	for k, v := range s.kvStore {
+		if strings.HasPrefix(k, prefix) {
+			kvs[k] = v
+		}
+	}
+	return kvs
+}
+
+func (s *kvstore) Propose(k string, v string) error {
+	return s.ProposeOnce(clientRequest{}, k, v)
+}
+
+// ProposeOnce proposes to set k to v for the client request req, which is
+// applied at most once however many times it is proposed.
+func (s *kvstore) ProposeOnce(req clientRequest, k string, v string) error {
+	return s.propose(kv{Key: k, Val: v, ClientID: req.ClientID, Seq: req.Seq})
+}
+
+// Delete proposes the deletion of k.
+func (s *kvstore) Delete(k string) error {
+	return s.DeleteOnce(clientRequest{}, k)
+}
+
+// DeleteOnce proposes the deletion of k for the client request req, like
+// ProposeOnce.
+func (s *kvstore) DeleteOnce(req clientRequest, k string) error {
+	return s.propose(kv{Key: k, Delete: true, ClientID: req.ClientID, Seq: req.Seq})
+}
+
+// CompareAndSwap proposes to set k to v if it is set to prev, and waits
+// until the proposal is applied to report whether it swapped. If ctx is done
+// first, the proposal may still be applied.
+func (s *kvstore) CompareAndSwap(ctx context.Context, k, prev, v string) (bool, error) {
+	swapped, err := s.proposeAndWait(ctx, kv{Key: k, Val: v, CAS: true, Prev: prev})
+	if err != nil {
+		return false, err
+	}
+	return swapped.(bool), nil
+}
+
+// proposeAndWait proposes u under a new ID, and waits until it is applied
+// to return its result.
+func (s *kvstore) proposeAndWait(ctx context.Context, u kv) (any, error) {
+	// the IDs are random, so that the proposals of different stores do
+	// not trigger each other's waiters
+	u.ID = rand.Uint64()
+	resultC := s.resultWait.Register(u.ID)
+	if err := s.propose(u); err != nil {
+		s.resultWait.Trigger(u.ID, nil)
+		return nil, err
+	}
+	select {
+	case result := <-resultC:
+		return result, nil
+	case <-ctx.Done():
+		s.resultWait.Trigger(u.ID, nil)
+		return nil, ctx.Err()
+	}
+}
+
+func (s *kvstore) propose(u kv) error {
 	var buf strings.Builder
-	if err := gob.NewEncoder(&buf).Encode(kv{k, v}); err != nil {
+	if err := gob.NewEncoder(&buf).Encode(u); err != nil {
 		log.Fatal(err)
 	}
+	if buf.Len() > s.maxProposalBytes {
+		proposalsTooLarge.Inc()
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d", errProposalTooLarge, buf.Len(), s.maxProposalBytes)
+	}
+	s.proposeMu.RLock()
+	defer s.proposeMu.RUnlock()
+	if s.stopped {
+		return errShuttingDown
+	}
+	proposalBytes.Observe(float64(buf.Len()))
 	s.proposeC <- buf.String()
+	return nil
+}
+
+// stopProposals refuses the proposals from now on, and waits for the ones
+// being sent to raft.
+func (s *kvstore) stopProposals() {
+	s.proposeMu.Lock()
+	defer s.proposeMu.Unlock()
+	s.stopped = true
 }
 
 func (s *kvstore) readCommits(commitC <-chan *commit, errorC <-chan error) {
+	// updates to disjoint keys are applied concurrently, the whole commit
+	// is applied before applyDoneC is closed.
+	apply := schedule.NewKeyedScheduler(zap.NewExample(), s.applyWorkers)
+	defer apply.Stop()
 	for commit := range commitC {
 		if commit == nil {
 			// signaled to load snapshot
@@ -94,10 +276,44 @@ func (s *kvstore) readCommits(commitC <-chan *commit, errorC <-chan error) {
 			if err := dec.Decode(&dataKv); err != nil {
 				log.Fatalf("raftexample: could not decode message (%v)", err)
 			}
-			s.mu.Lock()
-			s.kvStore[dataKv.Key] = dataKv.Val
-			s.mu.Unlock()
+			if !s.admit(dataKv) {
+				proposalsDuplicate.Inc()
+				continue
+			}
+			if dataKv.Txn != nil {
+				// scheduled after the updates to all its keys, and
+				// applied under the lock so that it is read as a unit
+				apply.Schedule(schedule.NewJob("txn", func(context.Context) {
+					s.mu.Lock()
+					defer s.mu.Unlock()
+					s.resultWait.Trigger(dataKv.ID, s.applyTxn(dataKv.Txn))
+				}), txnKeys(dataKv.Txn)...)
+				continue
+			}
+			apply.Schedule(schedule.NewJob(dataKv.Key, func(context.Context) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				if dataKv.CAS {
+					v, ok := s.kvStore[dataKv.Key]
+					swapped := ok && v == dataKv.Prev
+					// the waiter is registered on the proposing store
+					// only, triggering an unknown ID does nothing
+					defer s.resultWait.Trigger(dataKv.ID, swapped)
+					if !swapped {
+						return
+					}
+				}
+				if dataKv.Delete {
+					delete(s.kvStore, dataKv.Key)
+				} else {
+					s.kvStore[dataKv.Key] = dataKv.Val
+				}
+				// notified under the lock, so that the updates to a key
+				// are watched in the order they are applied
+				s.notify(dataKv)
+			}), dataKv.Key)
 		}
+		apply.WaitFinish()
 		close(commit.applyDoneC)
 	}
 	if err, ok := <-errorC; ok {
@@ -105,10 +321,97 @@ func (s *kvstore) readCommits(commitC <-chan *commit, errorC <-chan error) {
 	}
 }
 
+// admit records the client request of u as applied, and reports whether u
+// is to be applied, that is unless it retries a request applied already. It
+// is called in log order, before the updates are scheduled, so that every
+// store admits the same updates.
+func (s *kvstore) admit(u kv) bool {
+	if u.ClientID == 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u.Seq <= s.sessions[u.ClientID] {
+		return false
+	}
+	s.sessions[u.ClientID] = u.Seq
+	return true
+}
+
+// Watch returns a channel of the updates applied to key, or to the keys
+// starting with key if prefix is set, and a function canceling the watch.
+// The channel is closed once the watch is canceled, or if the watcher falls
+// behind. The updates restored from a snapshot are not watched.
+func (s *kvstore) Watch(key string, prefix bool) (<-chan kv, func()) {
+	w := &watcher{key: key, prefix: prefix, c: make(chan kv, watchBufSize)}
+	s.watchMu.Lock()
+	if s.watchers == nil {
+		s.watchers = make(map[*watcher]struct{})
+	}
+	s.watchers[w] = struct{}{}
+	s.watchMu.Unlock()
+	return w.c, func() {
+		s.watchMu.Lock()
+		defer s.watchMu.Unlock()
+		if _, ok := s.watchers[w]; ok {
+			delete(s.watchers, w)
+			close(w.c)
+		}
+	}
+}
+
+// notify sends u to the watchers of its key, and cancels the watches of the
+// watchers that fell behind rather than blocking the apply.
+func (s *kvstore) notify(u kv) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for w := range s.watchers {
+		if u.Key != w.key && !(w.prefix && strings.HasPrefix(u.Key, w.key)) {
+			continue
+		}
+		select {
+		case w.c <- u:
+		default:
+			delete(s.watchers, w)
+			close(w.c)
+		}
+	}
+}
+
 func (s *kvstore) getSnapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.writeSnapshot(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeSnapshot writes the store to w in the chunked snapshot format, a
+// chunk at a time, rather than encoding all of it before writing.
+func (s *kvstore) writeSnapshot(w io.Writer) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return json.Marshal(s.kvStore)
+	sw, err := newSnapshotWriter(w, kvSnapshotChunkBytes)
+	if err != nil {
+		return err
+	}
+	for k, v := range s.kvStore {
+		if err := sw.write(k, v); err != nil {
+			return err
+		}
+	}
+	if err := sw.end(); err != nil {
+		return err
+	}
+	var id, seq [8]byte
+	for c, n := range s.sessions {
+		binary.BigEndian.PutUint64(id[:], c)
+		binary.BigEndian.PutUint64(seq[:], n)
+		if err := sw.write(string(id[:]), string(seq[:])); err != nil {
+			return err
+		}
+	}
+	return sw.end()
 }
 
 func (s *kvstore) loadSnapshot() (*raftpb.Snapshot, error) {
@@ -123,12 +426,56 @@ func (s *kvstore) loadSnapshot() (*raftpb.Snapshot, error) {
 }
 
 func (s *kvstore) recoverFromSnapshot(snapshot []byte) error {
-	var store map[string]string
-	if err := json.Unmarshal(snapshot, &store); err != nil {
+	if !bytes.HasPrefix(snapshot, []byte(kvSnapshotMagic)) {
+		// taken before the chunked format, as a JSON object
+		var store map[string]string
+		if err := json.Unmarshal(snapshot, &store); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.kvStore = store
+		s.sessions = make(map[uint64]uint64)
+		return nil
+	}
+	return s.readSnapshot(bytes.NewReader(snapshot))
+}
+
+// readSnapshot replaces the store with the snapshot read from r, a chunk at
+// a time.
+func (s *kvstore) readSnapshot(r io.Reader) error {
+	sr, err := newSnapshotReader(r)
+	if err != nil {
 		return err
 	}
+	store := make(map[string]string)
+	for {
+		k, v, err := sr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		store[k] = v
+	}
+	sessions := make(map[uint64]uint64)
+	for sr.version >= 2 {
+		c, n, err := sr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(c) != 8 || len(n) != 8 {
+			return fmt.Errorf("%w: bad session", errSnapshotCorrupt)
+		}
+		sessions[binary.BigEndian.Uint64([]byte(c))] = binary.BigEndian.Uint64([]byte(n))
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.kvStore = store
+	s.sessions = sessions
 	return nil
 }
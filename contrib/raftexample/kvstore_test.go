@@ -15,8 +15,18 @@
 package main
 
 import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
 )
 
 func Test_kvstore_snapshot(t *testing.T) {
@@ -45,3 +55,342 @@ func Test_kvstore_snapshot(t *testing.T) {
 		t.Fatalf("store expected %+v, got %+v", tm, s.kvStore)
 	}
 }
+
+func TestKVStoreSnapshotChunks(t *testing.T) {
+	prevChunkBytes := kvSnapshotChunkBytes
+	kvSnapshotChunkBytes = 64
+	defer func() { kvSnapshotChunkBytes = prevChunkBytes }()
+
+	for _, n := range []int{0, 1, 100} {
+		tm := make(map[string]string)
+		for i := 0; i < n; i++ {
+			tm[fmt.Sprintf("key-%d", i)] = strings.Repeat("v", i)
+		}
+		data, err := (&kvstore{kvStore: tm}).getSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &kvstore{}
+		if err := s.recoverFromSnapshot(data); err != nil {
+			t.Fatalf("%d keys: %v", n, err)
+		}
+		if !reflect.DeepEqual(s.kvStore, tm) {
+			t.Errorf("%d keys: store = %v, want %v", n, s.kvStore, tm)
+		}
+	}
+}
+
+func TestKVStoreSnapshotSessions(t *testing.T) {
+	sessions := map[uint64]uint64{1: 5, 1 << 63: 1}
+	data, err := (&kvstore{kvStore: map[string]string{"foo": "bar"}, sessions: sessions}).getSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &kvstore{}
+	if err := s.recoverFromSnapshot(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.sessions, sessions) {
+		t.Errorf("sessions = %v, want %v", s.sessions, sessions)
+	}
+
+	// version 1 snapshots end after the key-value pairs
+	v1, err := (&kvstore{kvStore: map[string]string{"foo": "bar"}}).getSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 = v1[:len(v1)-4]
+	v1[7] = 1
+	s = &kvstore{}
+	if err := s.recoverFromSnapshot(v1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Lookup("foo"); v != "bar" || len(s.sessions) != 0 {
+		t.Errorf("foo = %q, sessions = %v, want bar and no sessions", v, s.sessions)
+	}
+}
+
+func TestKVStoreSnapshotJSON(t *testing.T) {
+	s := &kvstore{}
+	if err := s.recoverFromSnapshot([]byte(`{"foo":"bar"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Lookup("foo"); v != "bar" {
+		t.Errorf("foo = %q, want bar", v)
+	}
+}
+
+func TestKVStoreSnapshotCorrupt(t *testing.T) {
+	data, err := (&kvstore{kvStore: map[string]string{"foo": "bar"}}).getSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)-10] ^= 1
+	version := append([]byte{}, data...)
+	version[7] = kvSnapshotVersion + 1
+
+	tests := []struct {
+		name string
+		data []byte
+		werr error
+	}{
+		{"flipped", flipped, errSnapshotCorrupt},
+		{"truncated chunk", data[:len(data)-10], errSnapshotCorrupt},
+		{"no end", data[:len(data)-4], errSnapshotCorrupt},
+		{"truncated header", data[:6], errSnapshotCorrupt},
+		{"version", version, nil},
+	}
+	for _, tt := range tests {
+		s := &kvstore{}
+		err := s.recoverFromSnapshot(tt.data)
+		if err == nil || tt.werr != nil && !errors.Is(err, tt.werr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.werr)
+		}
+	}
+}
+
+func TestKVStoreProposeTooLarge(t *testing.T) {
+	proposeC := make(chan string, 1)
+	s := &kvstore{proposeC: proposeC, maxProposalBytes: 256}
+
+	if err := s.Propose("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	<-proposeC
+	if err := s.Propose("foo", string(make([]byte, 256))); !errors.Is(err, errProposalTooLarge) {
+		t.Fatalf("err = %v, want %v", err, errProposalTooLarge)
+	}
+	select {
+	case <-proposeC:
+		t.Fatalf("proposed a proposal over the limit")
+	default:
+	}
+}
+
+func TestKVStoreStopProposals(t *testing.T) {
+	proposeC := make(chan string, 1)
+	s := &kvstore{proposeC: proposeC, maxProposalBytes: defaultKVConfig().maxProposalBytes}
+	s.stopProposals()
+	if err := s.Propose("foo", "bar"); !errors.Is(err, errShuttingDown) {
+		t.Fatalf("err = %v, want %v", err, errShuttingDown)
+	}
+	if len(proposeC) != 0 {
+		t.Error("proposed after the proposals were stopped")
+	}
+}
+
+func TestKVStoreApplyConcurrent(t *testing.T) {
+	cfg := defaultKVConfig()
+	cfg.applyWorkers = 4
+
+	commitC := make(chan *commit)
+	errorC := make(chan error)
+	s := newKVStore(cfg, snap.New(zaptest.NewLogger(t), t.TempDir()), nil, commitC, errorC)
+
+	want := make(map[string]string)
+	var data []string
+	for i := 0; i < 100; i++ {
+		k, v := fmt.Sprintf("k%d", i%7), fmt.Sprint(i)
+		var buf strings.Builder
+		if err := gob.NewEncoder(&buf).Encode(kv{Key: k, Val: v}); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf.String())
+		want[k] = v
+	}
+	applyDoneC := make(chan struct{})
+	commitC <- &commit{data, applyDoneC}
+	<-applyDoneC
+	close(commitC)
+	close(errorC)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !reflect.DeepEqual(s.kvStore, want) {
+		t.Errorf("store = %v, want the last update of each key %v", s.kvStore, want)
+	}
+}
+
+func TestKVStoreDeleteAndList(t *testing.T) {
+	commitC := make(chan *commit)
+	errorC := make(chan error)
+	s := newKVStore(defaultKVConfig(), snap.New(zaptest.NewLogger(t), t.TempDir()), nil, commitC, errorC)
+	defer func() {
+		close(commitC)
+		close(errorC)
+	}()
+
+	var data []string
+	for _, u := range []kv{
+		{Key: "/foo", Val: "1"},
+		{Key: "/fu", Val: "2"},
+		{Key: "/bar", Val: "3"},
+		{Key: "/foo", Delete: true},
+		{Key: "/baz", Delete: true},
+	} {
+		var buf strings.Builder
+		if err := gob.NewEncoder(&buf).Encode(u); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf.String())
+	}
+	applyDoneC := make(chan struct{})
+	commitC <- &commit{data, applyDoneC}
+	<-applyDoneC
+
+	if v, ok := s.Lookup("/foo"); ok {
+		t.Errorf("deleted key /foo = %q", v)
+	}
+	if got, want := s.List("/f"), map[string]string{"/fu": "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(/f) = %v, want %v", got, want)
+	}
+	if got, want := s.List("/"), map[string]string{"/fu": "2", "/bar": "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(/) = %v, want %v", got, want)
+	}
+}
+
+// newCommittingKVStore returns a store whose proposals are committed as soon
+// as they are proposed, without raft.
+func newCommittingKVStore(t *testing.T, cfg kvConfig) *kvstore {
+	proposeC := make(chan string)
+	commitC := make(chan *commit)
+	errorC := make(chan error)
+	s := newKVStore(cfg, snap.New(zaptest.NewLogger(t), t.TempDir()), proposeC, commitC, errorC)
+	go func() {
+		defer close(errorC)
+		defer close(commitC)
+		for data := range proposeC {
+			applyDoneC := make(chan struct{})
+			commitC <- &commit{[]string{data}, applyDoneC}
+			<-applyDoneC
+		}
+	}()
+	t.Cleanup(func() { close(proposeC) })
+	return s
+}
+
+func TestKVStoreClientRequests(t *testing.T) {
+	s := newCommittingKVStore(t, defaultKVConfig())
+	tests := []struct {
+		req  clientRequest
+		val  string
+		wval string
+	}{
+		{clientRequest{ClientID: 1, Seq: 1}, "1", "1"},
+		// a retry
+		{clientRequest{ClientID: 1, Seq: 1}, "2", "1"},
+		{clientRequest{ClientID: 1, Seq: 2}, "3", "3"},
+		// a retry of an older request
+		{clientRequest{ClientID: 1, Seq: 1}, "4", "3"},
+		{clientRequest{ClientID: 2, Seq: 1}, "5", "5"},
+		{clientRequest{}, "6", "6"},
+		{clientRequest{}, "7", "7"},
+	}
+	for i, tt := range tests {
+		if err := s.ProposeOnce(tt.req, "/foo", tt.val); err != nil {
+			t.Fatal(err)
+		}
+		// a later update is applied once the earlier one is
+		if err := s.Propose("/sync", ""); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := s.Lookup("/foo"); v != tt.wval {
+			t.Errorf("#%d: /foo = %q, want %q", i, v, tt.wval)
+		}
+	}
+
+	if err := s.DeleteOnce(clientRequest{ClientID: 2, Seq: 1}, "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Propose("/sync", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Lookup("/foo"); !ok {
+		t.Error("retried deletion applied")
+	}
+}
+
+func TestKVStoreTxn(t *testing.T) {
+	cfg := defaultKVConfig()
+	cfg.applyWorkers = 4
+
+	s := newCommittingKVStore(t, cfg)
+	for _, kv := range []struct{ k, v string }{{"/a", "1"}, {"/b", "2"}} {
+		if err := s.Propose(kv.k, kv.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, err := s.Txn(context.Background(), []txnOp{
+		{Type: txnGet, Key: "/a"},
+		{Type: txnPut, Key: "/a", Value: "3"},
+		{Type: txnGet, Key: "/a"},
+		{Type: txnDelete, Key: "/b"},
+		{Type: txnGet, Key: "/b"},
+		{Type: txnPut, Key: "/c", Value: "4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wresults := []txnResult{{"/a", "1", true}, {"/a", "3", true}, {"/b", "", false}}
+	if !reflect.DeepEqual(results, wresults) {
+		t.Errorf("results = %+v, want %+v", results, wresults)
+	}
+	if kvs := s.List("/"); !reflect.DeepEqual(kvs, map[string]string{"/a": "3", "/c": "4"}) {
+		t.Errorf("store = %v, want /a=3 and /c=4", kvs)
+	}
+
+	for _, ops := range [][]txnOp{nil, {{Type: "cas", Key: "/a"}}} {
+		if _, err := s.Txn(context.Background(), ops); !errors.Is(err, errBadTxnOp) {
+			t.Errorf("Txn(%+v) err = %v, want %v", ops, err, errBadTxnOp)
+		}
+	}
+}
+
+func TestKVStoreCompareAndSwap(t *testing.T) {
+	s := newCommittingKVStore(t, defaultKVConfig())
+	if err := s.Propose("/foo", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key, prev, val string
+		wswapped       bool
+		wval           string
+	}{
+		{"/foo", "1", "2", true, "2"},
+		{"/foo", "1", "3", false, "2"},
+		{"/foo", "2", "3", true, "3"},
+		// a missing key never matches
+		{"/bar", "", "1", false, ""},
+	}
+	for i, tt := range tests {
+		swapped, err := s.CompareAndSwap(context.Background(), tt.key, tt.prev, tt.val)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if swapped != tt.wswapped {
+			t.Errorf("#%d: swapped = %v, want %v", i, swapped, tt.wswapped)
+		}
+		// the swap is applied before it is reported
+		if v, _ := s.Lookup(tt.key); v != tt.wval {
+			t.Errorf("#%d: %s = %q, want %q", i, tt.key, v, tt.wval)
+		}
+	}
+}
+
+func TestKVStoreCompareAndSwapTimeout(t *testing.T) {
+	// the proposal is never committed
+	commitC := make(chan *commit)
+	errorC := make(chan error)
+	s := newKVStore(defaultKVConfig(), snap.New(zaptest.NewLogger(t), t.TempDir()), make(chan string, 1), commitC, errorC)
+	defer func() {
+		close(commitC)
+		close(errorC)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.CompareAndSwap(ctx, "/foo", "1", "2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
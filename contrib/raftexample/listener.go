@@ -15,9 +15,14 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
+	"net/url"
 	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 )
 
 // stoppableListener sets TCP keep-alive timeouts on accepted
@@ -57,3 +62,47 @@ func (ln stoppableListener) Accept() (c net.Conn, err error) {
 		return tc, nil
 	}
 }
+
+// newTLSListener wraps ln to serve TLS with the certificate of info, or
+// returns ln if info is empty.
+func newTLSListener(ln net.Listener, info transport.TLSInfo) (net.Listener, error) {
+	if info.Empty() {
+		return ln, nil
+	}
+	cfg, err := info.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, cfg), nil
+}
+
+// validateTLSInfo checks that info has both a certificate and its key, or
+// neither, in which case it must not have a trusted CA either.
+func validateTLSInfo(info transport.TLSInfo) error {
+	if (info.CertFile == "") != (info.KeyFile == "") {
+		return errors.New("raftexample: a TLS certificate needs its key, and a key its certificate")
+	}
+	if info.Empty() && info.TrustedCAFile != "" {
+		return errors.New("raftexample: a trusted CA needs a TLS certificate and key")
+	}
+	return nil
+}
+
+// validatePeerURLs checks that the peer URLs are https when the peers use
+// TLS, and http otherwise.
+func validatePeerURLs(peers []string, info transport.TLSInfo) error {
+	scheme := "http"
+	if !info.Empty() {
+		scheme = "https"
+	}
+	for _, p := range peers {
+		u, err := url.Parse(p)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != scheme {
+			return fmt.Errorf("raftexample: peer URL %s, want scheme %s", p, scheme)
+		}
+	}
+	return nil
+}
@@ -16,30 +16,260 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"go.etcd.io/etcd/pkg/v3/flags"
 	"go.etcd.io/raft/v3/raftpb"
 )
 
 func main() {
+	rcfg, kcfg := defaultRaftConfig(), defaultKVConfig()
 	cluster := flag.String("cluster", "http://127.0.0.1:9021", "comma separated cluster peers")
 	id := flag.Int("id", 1, "node ID")
 	kvport := flag.Int("port", 9121, "key-value server port")
+	grpcport := flag.Int("grpc-port", 0, "key-value gRPC server port, 0 disables the gRPC server")
 	join := flag.Bool("join", false, "join an existing cluster")
+	asyncStorageWrites := flag.Bool("async-storage-writes", false, "write the raft log off the raft event loop")
+	witness := flag.Bool("witness", false, "vote in elections without keeping the key-value data")
+	readOnly := flag.Bool("read-only", false, "join as a learner that serves stale reads and refuses writes")
+	tickInterval := flags.NewDurationValue(rcfg.tickInterval, time.Millisecond, 0)
+	flag.Var(tickInterval, "tick-interval", "duration of a raft tick, the unit of the election and heartbeat timeouts, at least 1ms")
+	electionTickMin := flag.Int("election-tick-min", rcfg.electionTickMin, "minimum election timeout, in ticks")
+	electionTickMax := flag.Int("election-tick-max", rcfg.electionTickMax, "maximum election timeout, in ticks, at least twice the minimum")
+	preVote := flag.Bool("pre-vote", rcfg.preVote, "check that an election can be won before starting it")
+	maxBatchProposals := flag.Int("batch-proposals", rcfg.maxBatchProposals, "maximum number of proposals submitted to raft as a single entry")
+	batchInterval := flags.NewDurationValue(rcfg.batchInterval, 0, time.Second)
+	flag.Var(batchInterval, "batch-interval", "time to wait for more proposals to batch, at most 1s, 0 batches only those already pending")
+	maxInflightMsgs := flag.Int("max-inflight-msgs", rcfg.maxInflightMsgs, "maximum number of append messages in flight to a follower")
+	pipelineConns := flag.Int("pipeline-conns", rcfg.pipelineConns, "number of messages the transport sends to a peer concurrently over its pipeline, 0 uses the transport default")
+	pipelineBufSize := flag.Int("pipeline-buffer", rcfg.pipelineBufSize, "number of messages the transport buffers for the pipeline to a peer, 0 uses the transport default")
+	streamBufSize := flag.Int("stream-buffer", rcfg.streamBufSize, "number of messages the transport buffers for the streams to a peer, 0 uses the transport default")
+	maxProposalBytes := flags.NewBytesValue(uint64(kcfg.maxProposalBytes))
+	flag.Var(maxProposalBytes, "max-proposal-bytes", "maximum size of a proposal, like 512KiB, larger key-value writes are refused")
+	snapshotCount := flag.Uint64("snapshot-count", rcfg.snapshotCount, "number of applied entries that triggers a snapshot")
+	snapshotBytes := flags.NewBytesValue(rcfg.snapshotBytes)
+	flag.Var(snapshotBytes, "snapshot-bytes", "size of the applied entries that triggers a snapshot, like 64MB, 0 disables the trigger")
+	snapshotInterval := flags.NewDurationValue(rcfg.snapshotInterval, 0, 0)
+	flag.Var(snapshotInterval, "snapshot-interval", "time after the last snapshot that triggers a snapshot once entries are applied, 0 disables the trigger")
+	snapshotCatchUpEntries := flag.Uint64("snapshot-catch-up-entries", rcfg.snapshotCatchUpEntries, "number of entries kept in the log after a snapshot for lagging followers")
+	maxSnapshotCatchUpEntries := flag.Uint64("max-snapshot-catch-up-entries", rcfg.maxSnapshotCatchUpEntries, "number of entries the leader keeps at most after a snapshot for the recently active followers that lack them")
+	snapshotSendRate := flags.NewBytesValue(uint64(rcfg.snapshotSendRate))
+	flag.Var(snapshotSendRate, "snapshot-send-rate", "bandwidth limit of the snapshots streamed to the peers, in bytes per second like 10MB, 0 for no limit")
+	snapshotRecvRate := flags.NewBytesValue(uint64(rcfg.snapshotRecvRate))
+	flag.Var(snapshotRecvRate, "snapshot-recv-rate", "bandwidth limit of the snapshots received from the peers, in bytes per second like 10MB, 0 for no limit")
+	applyWorkers := flag.Int("apply-workers", kcfg.applyWorkers, "number of committed updates to disjoint keys applied concurrently")
+	certFile := flag.String("cert-file", "", "TLS certificate of the key-value HTTP and gRPC servers, empty serves plaintext")
+	keyFile := flag.String("key-file", "", "TLS key of the key-value HTTP and gRPC servers")
+	trustedCAFile := flag.String("trusted-ca-file", "", "CA of the client certificates the key-value servers require, empty requires none")
+	peerCertFile := flag.String("peer-cert-file", "", "TLS certificate of the raft traffic, both served and sent to the peers, whose URLs are then https")
+	peerKeyFile := flag.String("peer-key-file", "", "TLS key of the raft traffic")
+	peerTrustedCAFile := flag.String("peer-trusted-ca-file", "", "CA of the peer certificates, required from the peers and verified on theirs")
+	discovery := flag.String("discovery", "", "HTTP API URL of a member that adds this node to its cluster, and assigns its ID and peers instead of --id and --cluster")
+	peerURL := flag.String("peer-url", "", "raft URL this node registers with --discovery")
+	walDir := flag.String("wal-dir", "", "WAL directory, e.g. on a dedicated low latency disk, empty uses raftexample-<id>")
+	snapDir := flag.String("snap-dir", "", "snapshot directory, empty uses raftexample-<id>-snap")
+	clientCluster := flag.String("client-cluster", "", "comma separated key-value HTTP API URLs of the members, in the order of --cluster, empty leaves the writes to the follower receiving them")
+	forwardWrites := flag.Bool("forward-writes", true, "forward the writes a follower receives to the leader, or else propose them and reply the leader's URL in the "+leaderHeader+" header")
+	flag.String("config-file", "", "YAML or TOML file of flag values, keyed by flag name, which the RAFTEXAMPLE_* environment variables and the command line override")
 	flag.Parse()
+	if err := (flags.Loader{EnvPrefix: "RAFTEXAMPLE", ConfigFileFlag: "config-file"}).Load(flag.CommandLine); err != nil {
+		log.Fatalf("raftexample: %v", err)
+	}
 
-	proposeC := make(chan string)
-	defer close(proposeC)
-	confChangeC := make(chan raftpb.ConfChange)
-	defer close(confChangeC)
+	peers := strings.Split(*cluster, ",")
+
+	clientTLSInfo := transport.TLSInfo{CertFile: *certFile, KeyFile: *keyFile, TrustedCAFile: *trustedCAFile}
+	peerTLSInfo := transport.TLSInfo{CertFile: *peerCertFile, KeyFile: *peerKeyFile, TrustedCAFile: *peerTrustedCAFile}
+	for _, info := range []transport.TLSInfo{clientTLSInfo, peerTLSInfo} {
+		if err := validateTLSInfo(info); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *discovery != "" {
+		if *peerURL == "" {
+			log.Fatal("raftexample: --discovery requires --peer-url")
+		}
+		if err := validatePeerURLs([]string{*peerURL}, peerTLSInfo); err != nil {
+			log.Fatal(err)
+		}
+		tr, err := transport.NewTransport(clientTLSInfo, peerDialTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// a read-only replica registers as a learner
+		if *id, peers, err = discover(&http.Client{Transport: tr}, *discovery, *peerURL, *readOnly); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("raftexample: discovered member ID %d, peers %v", *id, peers)
+		*join = true
+	} else if err := validatePeerURLs(peers, peerTLSInfo); err != nil {
+		log.Fatal(err)
+	}
+
+	rcfg.walDir, rcfg.snapDir = *walDir, *snapDir
+	// after --discovery, which may assign the ID
+	if err := validateDataDirs(rcfg.dataDirs(*id)); err != nil {
+		log.Fatal(err)
+	}
+
+	var clientURLs []string
+	if *clientCluster != "" {
+		clientURLs = strings.Split(*clientCluster, ",")
+		if err := validateClientURLs(clientURLs); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *witness {
+		if err := validateWitness(peers); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *readOnly {
+		if err := validateReadOnly(*join, *witness); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := validateElectionTicks(*electionTickMin, *electionTickMax); err != nil {
+		log.Fatal(err)
+	}
+
+	if *maxInflightMsgs <= 0 {
+		log.Fatal("raftexample: --max-inflight-msgs must be positive")
+	}
+	if *applyWorkers <= 0 {
+		log.Fatal("raftexample: --apply-workers must be positive")
+	}
+
+	var err error
+	if kcfg.maxProposalBytes, err = bytesToInt("max-proposal-bytes", *maxProposalBytes); err != nil {
+		log.Fatal(err)
+	}
+	if rcfg.snapshotSendRate, err = bytesToInt("snapshot-send-rate", *snapshotSendRate); err != nil {
+		log.Fatal(err)
+	}
+	if rcfg.snapshotRecvRate, err = bytesToInt("snapshot-recv-rate", *snapshotRecvRate); err != nil {
+		log.Fatal(err)
+	}
+	kcfg.applyWorkers = *applyWorkers
+
+	rcfg.asyncStorageWrites = *asyncStorageWrites
+	rcfg.tickInterval = tickInterval.Duration()
+	rcfg.electionTickMin = *electionTickMin
+	rcfg.electionTickMax = *electionTickMax
+	rcfg.preVote = *preVote
+	rcfg.witness = *witness
+	rcfg.readOnly = *readOnly
+	rcfg.snapshotCount = *snapshotCount
+	rcfg.snapshotBytes = uint64(*snapshotBytes)
+	rcfg.snapshotInterval = snapshotInterval.Duration()
+	rcfg.snapshotCatchUpEntries = *snapshotCatchUpEntries
+	rcfg.maxSnapshotCatchUpEntries = *maxSnapshotCatchUpEntries
+	rcfg.maxBatchProposals = *maxBatchProposals
+	rcfg.batchInterval = batchInterval.Duration()
+	rcfg.maxInflightMsgs = *maxInflightMsgs
+	rcfg.pipelineConns = *pipelineConns
+	rcfg.pipelineBufSize = *pipelineBufSize
+	rcfg.streamBufSize = *streamBufSize
+	rcfg.peerTLSInfo = peerTLSInfo
+
+	// proposeC is closed by gracefulShutdown
+	chans := raftChannels{
+		proposeC:    make(chan string),
+		confChangeC: make(chan raftpb.ConfChangeI),
+		transferC:   make(chan leadershipTransfer),
+		readIndexC:  make(chan chan<- error),
+		confStateC:  make(chan chan<- raftpb.ConfState),
+		statusC:     make(chan chan<- nodeStatus),
+		membersC:    make(chan chan<- []member),
+		drainC:      make(chan chan<- error),
+	}
+	defer close(chans.confChangeC)
 
 	// raft provides a commit stream for the proposals from the http api
 	var kvs *kvstore
 	getSnapshot := func() ([]byte, error) { return kvs.getSnapshot() }
-	commitC, errorC, snapshotterReady := newRaftNode(*id, strings.Split(*cluster, ","), *join, getSnapshot, proposeC, confChangeC)
+	if *witness {
+		getSnapshot = func() ([]byte, error) { return nil, nil }
+	}
+	commitC, errorC, snapshotterReady := newRaftNode(*id, peers, *join, rcfg, getSnapshot, chans)
+
+	if *witness {
+		// a witness only votes, it keeps no key-value store to serve
+		defer close(chans.proposeC)
+		<-snapshotterReady
+		for c := range commitC {
+			if c != nil {
+				close(c.applyDoneC)
+			}
+		}
+		if err, ok := <-errorC; ok {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	kvs = newKVStore(kcfg, <-snapshotterReady, chans.proposeC, commitC, errorC)
 
-	kvs = newKVStore(<-snapshotterReady, proposeC, commitC, errorC)
+	shutdownC := make(chan struct{}, 1)
+	go gracefulShutdown(kvs, chans.proposeC, chans.drainC, shutdownC)
+
+	if *grpcport != 0 {
+		serveGRPCKVAPI(kvs, *grpcport, chans.readIndexC, *readOnly, clientTLSInfo)
+	}
 
 	// the key-value http handler will propose updates to raft
-	serveHTTPKVAPI(kvs, *kvport, confChangeC, errorC)
+	serveHTTPKVAPI(kvs, httpConfig{
+		port:          *kvport,
+		readOnly:      *readOnly,
+		clientURLs:    clientURLs,
+		forwardWrites: *forwardWrites,
+		tlsInfo:       clientTLSInfo,
+	}, chans, shutdownC, errorC)
+}
+
+// bytesToInt returns the value of the byte-size flag name, which must fit in
+// an int.
+func bytesToInt(name string, b flags.BytesValue) (int, error) {
+	if uint64(b) > math.MaxInt {
+		return 0, fmt.Errorf("raftexample: --%s must be at most %d bytes, got %d", name, math.MaxInt, uint64(b))
+	}
+	return int(b), nil
+}
+
+// gracefulShutdown stops the node on SIGINT, SIGTERM or a request over
+// shutdownC: it refuses new writes, waits until the store has applied the
+// committed entries and the WAL is synced, then stops raft, which closes the
+// WAL and errorC.
+func gracefulShutdown(kvs *kvstore, proposeC chan<- string, drainC chan<- chan<- error, shutdownC <-chan struct{}) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-sigC:
+		log.Printf("raftexample: received %v, shutting down", sig)
+	case <-shutdownC:
+		log.Printf("raftexample: shutdown requested")
+	}
+	// a second signal kills the process
+	signal.Stop(sigC)
+
+	kvs.stopProposals()
+	errC := make(chan error, 1)
+	drainC <- errC
+	if err := <-errC; err != nil {
+		log.Printf("raftexample: failed to drain (%v)", err)
+	}
+	close(proposeC)
 }
@@ -0,0 +1,203 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	proposalBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "raftexample",
+		Subsystem: "proposal",
+		Name:      "bytes",
+		Help:      "The sizes of the proposals sent to raft.",
+		// 64 bytes to 16 MiB
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	})
+	proposalsTooLarge = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "proposal",
+		Name:      "too_large_total",
+		Help:      "The total number of proposals refused for exceeding the maximum proposal size.",
+	})
+	proposalsDuplicate = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "proposal",
+		Name:      "duplicate_total",
+		Help:      "The total number of committed proposals skipped as retries of client requests applied already.",
+	})
+	proposalsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "proposal",
+		Name:      "dropped_total",
+		Help:      "The total number of proposals dropped by raft, e.g. for exceeding the uncommitted log size, or refused while too many committed entries are pending apply.",
+	})
+	writesForwarded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "proposal",
+		Name:      "forwarded_total",
+		Help:      "The total number of writes a follower forwarded to the leader instead of proposing them.",
+	})
+
+	snapshotSentBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "snapshot",
+		Name:      "sent_bytes_total",
+		Help:      "The total number of snapshot data bytes streamed to the peers.",
+	})
+	snapshotReceivedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "snapshot",
+		Name:      "received_bytes_total",
+		Help:      "The total number of snapshot data bytes received from the peers.",
+	})
+
+	applyPendingEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "apply",
+		Name:      "pending_entries",
+		Help:      "The number of committed entries waiting to be applied.",
+	})
+	applyLagEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "apply",
+		Name:      "lag_entries",
+		Help:      "The gap between the committed index and the index applied by the store.",
+	})
+	applyDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "raftexample",
+		Subsystem: "apply",
+		Name:      "duration_seconds",
+		Help:      "The latency distributions of the store applying the committed entries published together.",
+		// 100us to 3.3s
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+	readyDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "ready_duration_seconds",
+		Help:      "The latency distributions of the raft loop handling a Ready, which delays the ticks and messages while it lasts.",
+		// 100us to 3.3s
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+
+	peerMatchIndex = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "peer",
+		Name:      "match_index",
+		Help:      "The highest log index known to be replicated to the peer, while this node is the leader.",
+	},
+		[]string{"To"},
+	)
+	peerInflightMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "peer",
+		Name:      "inflight_messages",
+		Help:      "The number of append messages in flight to the peer, while this node is the leader.",
+	},
+		[]string{"To"},
+	)
+	peerPaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "peer",
+		Name:      "paused",
+		Help:      "Whether or not replication to the peer is paused. 1 if it is, 0 otherwise.",
+	},
+		[]string{"To"},
+	)
+	peerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "peer",
+		Name:      "state",
+		Help:      "The replication state of the peer. 1 for its current state, 0 for the others.",
+	},
+		[]string{"To", "State"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(proposalBytes)
+	prometheus.MustRegister(proposalsTooLarge)
+	prometheus.MustRegister(proposalsDuplicate)
+	prometheus.MustRegister(proposalsDropped)
+	prometheus.MustRegister(writesForwarded)
+	prometheus.MustRegister(snapshotSentBytes)
+	prometheus.MustRegister(snapshotReceivedBytes)
+	prometheus.MustRegister(applyPendingEntries)
+	prometheus.MustRegister(applyLagEntries)
+	prometheus.MustRegister(applyDurationSeconds)
+	prometheus.MustRegister(readyDurationSeconds)
+	prometheus.MustRegister(peerMatchIndex)
+	prometheus.MustRegister(peerInflightMessages)
+	prometheus.MustRegister(peerPaused)
+	prometheus.MustRegister(peerState)
+}
+
+// applyTracker measures how far a state machine lags behind the raft log. A
+// raft loop reports the committed index of each Ready, and the entries it
+// publishes and the state machine applies; the tracker exports the gap
+// between the committed and applied indexes to lag, and the time taken to
+// apply the published entries to duration. A growing gap with a slow apply
+// points at the state machine, rather than the network or the disk, as the
+// bottleneck.
+type applyTracker struct {
+	lag      prometheus.Gauge
+	duration prometheus.Observer
+
+	mu        sync.Mutex
+	committed uint64
+	applied   uint64
+}
+
+func newApplyTracker(lag prometheus.Gauge, duration prometheus.Observer) *applyTracker {
+	return &applyTracker{lag: lag, duration: duration}
+}
+
+// commit records that the log is committed up to index.
+func (t *applyTracker) commit(index uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index > t.committed {
+		t.committed = index
+		t.updateLag()
+	}
+}
+
+// apply records that the state machine applied the entries up to index,
+// which were published at published, or at the zero time if the state
+// machine had nothing to apply.
+func (t *applyTracker) apply(index uint64, published time.Time) {
+	if !published.IsZero() {
+		t.duration.Observe(time.Since(published).Seconds())
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index > t.applied {
+		t.applied = index
+		t.updateLag()
+	}
+}
+
+func (t *applyTracker) updateLag() {
+	if t.committed > t.applied {
+		t.lag.Set(float64(t.committed - t.applied))
+	} else {
+		t.lag.Set(0)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+	"go.etcd.io/raft/v3/tracker"
+)
+
+// progressInterval is how often the peer progress metrics are updated.
+var progressInterval = time.Second
+
+// peerProgress is the replication state of a peer as tracked by the leader.
+type peerProgress struct {
+	ID    uint64 `json:"id"`
+	Match uint64 `json:"match"`
+	Next  uint64 `json:"next"`
+	// State is StateProbe, StateReplicate or StateSnapshot.
+	State           string `json:"state"`
+	PendingSnapshot uint64 `json:"pendingSnapshot,omitempty"`
+	Inflight        int    `json:"inflight"`
+	Paused          bool   `json:"paused"`
+	RecentActive    bool   `json:"recentActive"`
+	IsLearner       bool   `json:"isLearner,omitempty"`
+}
+
+// nodeStatus is the raft status of a node, as reported by GET /raft/status.
+type nodeStatus struct {
+	ID      uint64 `json:"id"`
+	Term    uint64 `json:"term"`
+	Lead    uint64 `json:"leader"`
+	State   string `json:"state"`
+	Commit  uint64 `json:"commit"`
+	Applied uint64 `json:"applied"`
+	// Progress is only reported by the leader.
+	Progress []peerProgress `json:"progress,omitempty"`
+	Snapshot snapshotStatus `json:"snapshot"`
+}
+
+// snapshotStatus is the metadata of the latest snapshot of a node.
+type snapshotStatus struct {
+	Index     uint64           `json:"index"`
+	Term      uint64           `json:"term"`
+	ConfState raftpb.ConfState `json:"confState"`
+}
+
+func newNodeStatus(st raft.Status, snap raftpb.SnapshotMetadata) nodeStatus {
+	return nodeStatus{
+		ID:       st.ID,
+		Term:     st.Term,
+		Lead:     st.Lead,
+		State:    st.RaftState.String(),
+		Commit:   st.Commit,
+		Applied:  st.Applied,
+		Progress: peerProgresses(st),
+		Snapshot: snapshotStatus{Index: snap.Index, Term: snap.Term, ConfState: snap.ConfState},
+	}
+}
+
+// peerProgresses returns the progress of the peers in st, ordered by ID.
+// It is empty unless st is the status of the leader.
+func peerProgresses(st raft.Status) []peerProgress {
+	prs := make([]peerProgress, 0, len(st.Progress))
+	for id, pr := range st.Progress {
+		prs = append(prs, peerProgress{
+			ID:              id,
+			Match:           pr.Match,
+			Next:            pr.Next,
+			State:           pr.State.String(),
+			PendingSnapshot: pr.PendingSnapshot,
+			Inflight:        pr.Inflights.Count(),
+			Paused:          pr.IsPaused(),
+			RecentActive:    pr.RecentActive,
+			IsLearner:       pr.IsLearner,
+		})
+	}
+	sort.Slice(prs, func(i, j int) bool { return prs[i].ID < prs[j].ID })
+	return prs
+}
+
+// serveProgressMetrics periodically updates the peer progress metrics,
+// which are cleared while this node is not the leader.
+func (rc *raftNode) serveProgressMetrics() {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			updateProgressMetrics(peerProgresses(rc.node.Status()))
+		case <-rc.stopc:
+			return
+		}
+	}
+}
+
+func updateProgressMetrics(prs []peerProgress) {
+	peerMatchIndex.Reset()
+	peerInflightMessages.Reset()
+	peerPaused.Reset()
+	peerState.Reset()
+	for _, pr := range prs {
+		to := strconv.FormatUint(pr.ID, 16)
+		peerMatchIndex.WithLabelValues(to).Set(float64(pr.Match))
+		peerInflightMessages.WithLabelValues(to).Set(float64(pr.Inflight))
+		peerPaused.WithLabelValues(to).Set(boolToFloat(pr.Paused))
+		for _, state := range []tracker.StateType{tracker.StateProbe, tracker.StateReplicate, tracker.StateSnapshot} {
+			peerState.WithLabelValues(to, state.String()).Set(boolToFloat(pr.State == state.String()))
+		}
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
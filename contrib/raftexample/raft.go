@@ -15,17 +15,30 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jonboulle/clockwork"
+	"golang.org/x/time/rate"
+
 	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/pkg/v3/wait"
 	"go.etcd.io/etcd/server/v3/etcdserver/api/rafthttp"
 	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
 	stats "go.etcd.io/etcd/server/v3/etcdserver/api/v2stats"
@@ -42,12 +55,33 @@ type commit struct {
 	applyDoneC chan<- struct{}
 }
 
+// leadershipTransfer asks raft to transfer the leadership to target, or only
+// reports the leadership if target is zero. The leadership as seen after the
+// request is sent over statusC.
+type leadershipTransfer struct {
+	target  uint64
+	statusC chan<- leadership
+}
+
+// leadership is the current leader and, while a transfer is in progress on
+// the leader, the node the leadership is being transferred to.
+type leadership struct {
+	Lead       uint64 `json:"lead"`
+	Transferee uint64 `json:"transferee"`
+}
+
 // A key-value stream backed by raft
 type raftNode struct {
-	proposeC    <-chan string            // proposed messages (k,v)
-	confChangeC <-chan raftpb.ConfChange // proposed cluster config changes
-	commitC     chan<- *commit           // entries committed to log (k,v)
-	errorC      chan<- error             // errors from raft session
+	proposeC    <-chan string                  // proposed messages (k,v)
+	confChangeC <-chan raftpb.ConfChangeI      // proposed cluster config changes
+	transferC   <-chan leadershipTransfer      // requested leadership transfers
+	readIndexC  <-chan chan<- error            // linearizable read requests
+	confStateC  <-chan chan<- raftpb.ConfState // requests for the applied conf state
+	statusC     <-chan chan<- nodeStatus       // requests for the raft status
+	membersC    <-chan chan<- []member         // requests for the members
+	drainC      <-chan chan<- error            // requests to drain before a shutdown
+	commitC     chan<- *commit                 // entries committed to log (k,v)
+	errorC      chan<- error                   // errors from raft session
 
 	id          int      // client ID for raft session
 	peers       []string // raft peer URLs
@@ -56,10 +90,20 @@ type raftNode struct {
 	snapdir     string   // path to snapshot directory
 	getSnapshot func() ([]byte, error)
 
+	confMu        sync.Mutex // guards confState, applied off the Ready loop with async storage writes
 	confState     raftpb.ConfState
 	snapshotIndex uint64
 	appliedIndex  uint64
 
+	// applyPending counts the committed entries queued for serveApply,
+	// proposals are refused while it exceeds maxApplyPending.
+	applyPending atomic.Int64
+
+	readStateC chan raft.ReadState // read states of the confirmed read indexes
+	appliedC   chan applied        // published entries, in order
+	applyWait  wait.WaitTime       // triggered once the store has applied an index
+	lagTracker *applyTracker       // exports how far the store lags behind the log
+
 	// raft backing for the commit/error channel
 	node        raft.Node
 	raftStorage *raft.MemoryStorage
@@ -68,7 +112,65 @@ type raftNode struct {
 	snapshotter      *snap.Snapshotter
 	snapshotterReady chan *snap.Snapshotter // signals when snapshotter is ready
 
-	snapCount uint64
+	peerMu   sync.Mutex
+	peerURLs map[uint64]string // raft URLs of the peers, to stream snapshots to
+
+	// maxBatchProposals and batchInterval bound the proposals that are
+	// submitted to raft as a single entry, see collectProposals.
+	maxBatchProposals int
+	batchInterval     time.Duration
+
+	// clock drives the raft ticks, every tickInterval.
+	clock        clockwork.Clock
+	tickInterval time.Duration
+	electionTick int
+	preVote      bool
+
+	// maxInflightMsgs bounds the append messages in flight to a follower,
+	// which the transport pipelines over pipelineConns connections and
+	// buffers in its pipelines and streams, see rafthttp.Transport.
+	maxInflightMsgs int
+	pipelineConns   int
+	pipelineBufSize int
+	streamBufSize   int
+
+	// witness makes this node a tie-breaking member that votes but keeps
+	// no payloads, see witnessEntries.
+	witness bool
+
+	// readOnly makes this node a replica that tails the log as a learner,
+	// it refuses proposals and the leadership.
+	readOnly bool
+
+	// asyncStorageWrites makes raft hand the log appends and the committed
+	// entries to dedicated storage goroutines, see serveStorage.
+	asyncStorageWrites bool
+
+	// A snapshot is triggered once more than snapCount entries, or when
+	// set, snapBytes bytes of entries or snapInterval have been applied
+	// since the last one, see shouldSnapshot.
+	snapCount    uint64
+	snapBytes    uint64
+	snapInterval time.Duration
+	appliedBytes uint64    // bytes of the entries applied since the last snapshot
+	lastSnapshot time.Time // time of the last snapshot
+
+	// snapCatchUpEntries entries are kept in the log after a snapshot, and
+	// on the leader up to maxSnapCatchUpEntries for lagging followers, see
+	// compactIndex.
+	snapCatchUpEntries    uint64
+	maxSnapCatchUpEntries uint64
+
+	// peerTLSInfo secures the raft traffic, and the snapshots streamed
+	// with snapshotClient, between peers whose URLs are then https.
+	peerTLSInfo    transport.TLSInfo
+	snapshotClient *http.Client
+	// snapshotSendLimiter and snapshotRecvLimiter pace the snapshot data
+	// streamed to and from the peers, so that a catch-up does not saturate
+	// the network and delay the heartbeats. They are nil for no limit.
+	snapshotSendLimiter *rate.Limiter
+	snapshotRecvLimiter *rate.Limiter
+
 	transport *rafthttp.Transport
 	stopc     chan struct{} // signals proposal channel closed
 	httpstopc chan struct{} // signals http server to shutdown
@@ -77,38 +179,200 @@ type raftNode struct {
 	logger *zap.Logger
 }
 
-var defaultSnapshotCount uint64 = 10000
+// raftChannels are the channels over which the key-value store, the HTTP
+// API and the shutdown send their requests to a raft node.
+type raftChannels struct {
+	proposeC    chan string             // proposed messages (k,v)
+	confChangeC chan raftpb.ConfChangeI // proposed cluster config changes
+	transferC   chan leadershipTransfer
+	readIndexC  chan chan<- error
+	confStateC  chan chan<- raftpb.ConfState
+	statusC     chan chan<- nodeStatus
+	membersC    chan chan<- []member
+	drainC      chan chan<- error
+}
+
+// raftConfig configures a raft node, see defaultRaftConfig.
+type raftConfig struct {
+	// The WAL and snapshot directories, see dataDirs. The WAL, which is
+	// synced on every write, may be put on a dedicated low latency disk apart
+	// from the snapshots.
+	walDir  string
+	snapDir string
+
+	// A snapshot is triggered after snapshotCount applied entries, or
+	// snapshotBytes applied bytes, or snapshotInterval once entries are
+	// applied. Zero disables the bytes and time triggers.
+	snapshotCount    uint64
+	snapshotBytes    uint64
+	snapshotInterval time.Duration
+	// The entries kept in the log after a snapshot, so that followers lagging
+	// behind by fewer entries catch up without receiving the snapshot.
+	snapshotCatchUpEntries    uint64
+	maxSnapshotCatchUpEntries uint64
+
+	asyncStorageWrites bool
+	witness            bool
+	readOnly           bool
+
+	// peerTLSInfo secures the traffic between the peers, it is empty for
+	// plaintext.
+	peerTLSInfo transport.TLSInfo
+	// The bandwidth limits of the snapshots streamed to and from the peers,
+	// in bytes per second, 0 for no limit.
+	snapshotSendRate int
+	snapshotRecvRate int
+
+	// tickInterval is the duration of a raft tick, the unit of the election
+	// and heartbeat timeouts.
+	tickInterval time.Duration
+	// clock drives the raft ticks, tests may set a fake clock to tick the
+	// nodes deterministically.
+	clock clockwork.Clock
+	// The election timeouts, in ticks, are drawn from the range
+	// [electionTickMin, electionTickMax), see randomElectionTick.
+	electionTickMin int
+	electionTickMax int
+	// preVote makes a node check that it could win an election before
+	// disrupting the cluster with a higher term, e.g. when it restarts or
+	// rejoins after a partition.
+	preVote bool
+
+	maxBatchProposals int
+	batchInterval     time.Duration
+
+	// The transport settings, zero uses the rafthttp defaults.
+	maxInflightMsgs int
+	pipelineConns   int
+	pipelineBufSize int
+	streamBufSize   int
+}
+
+func defaultRaftConfig() raftConfig {
+	return raftConfig{
+		snapshotCount:             10000,
+		snapshotCatchUpEntries:    10000,
+		maxSnapshotCatchUpEntries: 100000,
+		tickInterval:              100 * time.Millisecond,
+		clock:                     clockwork.NewRealClock(),
+		electionTickMin:           10,
+		electionTickMax:           20,
+		preVote:                   true,
+		maxBatchProposals:         64,
+		maxInflightMsgs:           256,
+	}
+}
+
+// maxSizePerMsg bounds the size of the raft messages, and of the proposal
+// batches so that one fits in a message.
+const maxSizePerMsg = 1024 * 1024
+
+// drainTimeout bounds how long a drain waits for the committed entries to be
+// applied.
+var drainTimeout = 10 * time.Second
+
+// readIndexTimeout bounds how long a linearizable read waits for its read
+// index to be confirmed and applied.
+var readIndexTimeout = 5 * time.Second
+
+// maxApplyPending bounds the committed entries that may wait to be applied
+// before proposals are refused, so that a slow state machine does not fall
+// ever further behind the log.
+var maxApplyPending int64 = 5000
+
+var (
+	errReadIndexTimeout = errors.New("raftexample: timed out waiting for read index")
+	errStopped          = errors.New("raftexample: raft node stopped")
+	errApplyBacklog     = errors.New("raftexample: too many committed entries pending apply")
+	errReadOnly         = errors.New("raftexample: read-only replica refuses proposals")
+	errDrainTimeout     = errors.New("raftexample: timed out waiting for the committed entries to be applied")
+)
+
+// peerDialTimeout bounds how long streaming a snapshot waits to connect to
+// a peer.
+const peerDialTimeout = time.Second
+
+// applied is an index published over the commit channel at published, which
+// the store has applied once applyDoneC is closed.
+type applied struct {
+	index      uint64
+	applyDoneC <-chan struct{}
+	published  time.Time
+}
 
 // newRaftNode initiates a raft instance and returns a committed log entry
 // channel and error channel. Proposals for log updates are sent over the
 // provided the proposal channel. All log entries are replayed over the
 // commit channel, followed by a nil message (to indicate the channel is
-// current), then new log entries. To shutdown, close proposeC and read errorC.
-func newRaftNode(id int, peers []string, join bool, getSnapshot func() ([]byte, error), proposeC <-chan string,
-	confChangeC <-chan raftpb.ConfChange) (<-chan *commit, <-chan error, <-chan *snap.Snapshotter) {
+// current), then new log entries. Leadership transfers are requested over
+// transferC, linearizable reads over readIndexC, see serveReads, the
+// applied conf state over confStateC, the raft status over statusC and the
+// members with their URLs over membersC. The URL of a peer may be empty if it
+// is unknown, or not a member. To shutdown, close proposeC and read errorC;
+// a graceful shutdown first drains the node over drainC, see drain.
+func newRaftNode(id int, peers []string, join bool, cfg raftConfig, getSnapshot func() ([]byte, error),
+	chans raftChannels) (<-chan *commit, <-chan error, <-chan *snap.Snapshotter) {
 
 	commitC := make(chan *commit)
 	errorC := make(chan error)
+	waldir, snapdir := cfg.dataDirs(id)
 
 	rc := &raftNode{
-		proposeC:    proposeC,
-		confChangeC: confChangeC,
+		proposeC:    chans.proposeC,
+		confChangeC: chans.confChangeC,
+		transferC:   chans.transferC,
+		readIndexC:  chans.readIndexC,
+		confStateC:  chans.confStateC,
+		statusC:     chans.statusC,
+		membersC:    chans.membersC,
+		drainC:      chans.drainC,
 		commitC:     commitC,
 		errorC:      errorC,
 		id:          id,
 		peers:       peers,
 		join:        join,
-		waldir:      fmt.Sprintf("raftexample-%d", id),
-		snapdir:     fmt.Sprintf("raftexample-%d-snap", id),
+		waldir:      waldir,
+		snapdir:     snapdir,
 		getSnapshot: getSnapshot,
-		snapCount:   defaultSnapshotCount,
+		snapCount:   cfg.snapshotCount,
 		stopc:       make(chan struct{}),
 		httpstopc:   make(chan struct{}),
 		httpdonec:   make(chan struct{}),
 
+		readStateC: make(chan raft.ReadState, 1),
+		appliedC:   make(chan applied, appliedBacklog),
+		applyWait:  wait.NewTimeList(),
+		lagTracker: newApplyTracker(applyLagEntries, applyDurationSeconds),
+
+		asyncStorageWrites: cfg.asyncStorageWrites,
+		witness:            cfg.witness,
+		clock:              cfg.clock,
+		tickInterval:       cfg.tickInterval,
+		electionTick:       randomElectionTick(cfg.electionTickMin, cfg.electionTickMax),
+		preVote:            cfg.preVote,
+		maxBatchProposals:  cfg.maxBatchProposals,
+		batchInterval:      cfg.batchInterval,
+		maxInflightMsgs:    cfg.maxInflightMsgs,
+		pipelineConns:      cfg.pipelineConns,
+		pipelineBufSize:    cfg.pipelineBufSize,
+		streamBufSize:      cfg.streamBufSize,
+		snapBytes:          cfg.snapshotBytes,
+		snapInterval:       cfg.snapshotInterval,
+
+		snapCatchUpEntries:    cfg.snapshotCatchUpEntries,
+		maxSnapCatchUpEntries: cfg.maxSnapshotCatchUpEntries,
+
+		readOnly: cfg.readOnly,
+
+		peerTLSInfo: cfg.peerTLSInfo,
+
+		snapshotSendLimiter: newSnapshotLimiter(cfg.snapshotSendRate),
+		snapshotRecvLimiter: newSnapshotLimiter(cfg.snapshotRecvRate),
+
 		logger: zap.NewExample(),
 
 		snapshotterReady: make(chan *snap.Snapshotter, 1),
+		peerURLs:         make(map[uint64]string),
 		// rest of structure populated after WAL replay
 	}
 	go rc.startRaft()
@@ -156,39 +420,60 @@ func (rc *raftNode) publishEntries(ents []raftpb.Entry) (<-chan struct{}, bool)
 
 	data := make([]string, 0, len(ents))
 	for i := range ents {
+		rc.appliedBytes += uint64(len(ents[i].Data))
 		switch ents[i].Type {
 		case raftpb.EntryNormal:
-			if len(ents[i].Data) == 0 {
-				// ignore empty messages
+			if len(ents[i].Data) == 0 || rc.witness {
+				// ignore empty messages, and all of them on a witness
 				break
 			}
-			s := string(ents[i].Data)
-			data = append(data, s)
+			props, err := decodeProposals(ents[i].Data)
+			if err != nil {
+				log.Fatalf("raftexample: could not decode entry %d (%v)", ents[i].Index, err)
+			}
+			data = append(data, props...)
 		case raftpb.EntryConfChange:
 			var cc raftpb.ConfChange
 			cc.Unmarshal(ents[i].Data)
-			rc.confState = *rc.node.ApplyConfChange(cc)
+			confState := rc.node.ApplyConfChange(cc)
+			rc.confMu.Lock()
+			rc.confState = *confState
+			rc.confMu.Unlock()
 			switch cc.Type {
-			case raftpb.ConfChangeAddNode:
+			case raftpb.ConfChangeAddNode, raftpb.ConfChangeAddLearnerNode:
+				// adding a learner as a voter promotes it, its peer is known already.
 				if len(cc.Context) > 0 {
-					rc.transport.AddPeer(types.ID(cc.NodeID), []string{string(cc.Context)})
+					rc.addPeer(cc.NodeID, string(cc.Context))
 				}
 			case raftpb.ConfChangeRemoveNode:
 				if cc.NodeID == uint64(rc.id) {
 					log.Println("I've been removed from the cluster! Shutting down.")
 					return nil, false
 				}
-				rc.transport.RemovePeer(types.ID(cc.NodeID))
+				rc.removePeer(cc.NodeID)
+			}
+		case raftpb.EntryConfChangeV2:
+			var cc raftpb.ConfChangeV2
+			cc.Unmarshal(ents[i].Data)
+			confState := rc.node.ApplyConfChange(cc)
+			rc.confMu.Lock()
+			rc.confState = *confState
+			rc.confMu.Unlock()
+			if !rc.applyPeerChanges(cc, *confState) {
+				log.Println("I've been removed from the cluster! Shutting down.")
+				return nil, false
 			}
 		}
 	}
 
 	var applyDoneC chan struct{}
+	var published time.Time
 
 	if len(data) > 0 {
 		applyDoneC = make(chan struct{}, 1)
 		select {
 		case rc.commitC <- &commit{data, applyDoneC}:
+			published = time.Now()
 		case <-rc.stopc:
 			return nil, false
 		}
@@ -197,6 +482,12 @@ func (rc *raftNode) publishEntries(ents []raftpb.Entry) (<-chan struct{}, bool)
 	// after commit, update appliedIndex
 	rc.appliedIndex = ents[len(ents)-1].Index
 
+	select {
+	case rc.appliedC <- applied{rc.appliedIndex, applyDoneC, published}:
+	case <-rc.stopc:
+		return nil, false
+	}
+
 	return applyDoneC, true
 }
 
@@ -210,6 +501,13 @@ func (rc *raftNode) loadSnapshot() *raftpb.Snapshot {
 		if err != nil && err != snap.ErrNoSnapshot {
 			log.Fatalf("raftexample: error loading snapshot (%v)", err)
 		}
+		if snapshot != nil {
+			// the snapshots older than the loaded one, and those partially
+			// received before it, are of no use anymore
+			if _, err := rc.snapshotter.CleanupOrphans(walSnaps); err != nil {
+				log.Printf("raftexample: failed to clean up orphaned snapshot files (%v)", err)
+			}
+		}
 		return snapshot
 	}
 	return &raftpb.Snapshot{}
@@ -218,7 +516,7 @@ func (rc *raftNode) loadSnapshot() *raftpb.Snapshot {
 // openWAL returns a WAL ready for reading.
 func (rc *raftNode) openWAL(snapshot *raftpb.Snapshot) *wal.WAL {
 	if !wal.Exist(rc.waldir) {
-		if err := os.Mkdir(rc.waldir, 0750); err != nil {
+		if err := os.MkdirAll(rc.waldir, 0750); err != nil {
 			log.Fatalf("raftexample: cannot create dir for wal (%v)", err)
 		}
 
@@ -266,6 +564,7 @@ func (rc *raftNode) replayWAL() *wal.WAL {
 func (rc *raftNode) writeError(err error) {
 	rc.stopHTTP()
 	close(rc.commitC)
+	rc.wal.Close()
 	rc.errorC <- err
 	close(rc.errorC)
 	rc.node.Stop()
@@ -273,7 +572,7 @@ func (rc *raftNode) writeError(err error) {
 
 func (rc *raftNode) startRaft() {
 	if !fileutil.Exist(rc.snapdir) {
-		if err := os.Mkdir(rc.snapdir, 0750); err != nil {
+		if err := os.MkdirAll(rc.snapdir, 0750); err != nil {
 			log.Fatalf("raftexample: cannot create dir for snapshot (%v)", err)
 		}
 	}
@@ -291,12 +590,14 @@ func (rc *raftNode) startRaft() {
 	}
 	c := &raft.Config{
 		ID:                        uint64(rc.id),
-		ElectionTick:              10,
+		ElectionTick:              rc.electionTick,
 		HeartbeatTick:             1,
 		Storage:                   rc.raftStorage,
-		MaxSizePerMsg:             1024 * 1024,
-		MaxInflightMsgs:           256,
+		MaxSizePerMsg:             maxSizePerMsg,
+		MaxInflightMsgs:           rc.maxInflightMsgs,
 		MaxUncommittedEntriesSize: 1 << 30,
+		AsyncStorageWrites:        rc.asyncStorageWrites,
+		PreVote:                   rc.preVote,
 	}
 
 	if oldwal || rc.join {
@@ -313,12 +614,23 @@ func (rc *raftNode) startRaft() {
 		ServerStats: stats.NewServerStats("", ""),
 		LeaderStats: stats.NewLeaderStats(zap.NewExample(), strconv.Itoa(rc.id)),
 		ErrorC:      make(chan error),
+		TLSInfo:     rc.peerTLSInfo,
+
+		PipelineConns:   rc.pipelineConns,
+		PipelineBufSize: rc.pipelineBufSize,
+		StreamBufSize:   rc.streamBufSize,
+	}
+
+	tr, err := transport.NewTransport(rc.peerTLSInfo, peerDialTimeout)
+	if err != nil {
+		log.Fatalf("raftexample: failed to create snapshot client (%v)", err)
 	}
+	rc.snapshotClient = &http.Client{Transport: tr}
 
 	rc.transport.Start()
 	for i := range rc.peers {
-		if i+1 != rc.id {
-			rc.transport.AddPeer(types.ID(i+1), []string{rc.peers[i]})
+		if i+1 != rc.id && rc.peers[i] != "" {
+			rc.addPeer(uint64(i+1), rc.peers[i])
 		}
 	}
 
@@ -330,6 +642,9 @@ func (rc *raftNode) startRaft() {
 func (rc *raftNode) stop() {
 	rc.stopHTTP()
 	close(rc.commitC)
+	// release the WAL before reporting the stop, so that the node can be
+	// restarted on the same directory.
+	rc.wal.Close()
 	close(rc.errorC)
 	rc.node.Stop()
 }
@@ -353,15 +668,60 @@ func (rc *raftNode) publishSnapshot(snapshotToSave raftpb.Snapshot) {
 	}
 	rc.commitC <- nil // trigger kvstore to load snapshot
 
+	rc.confMu.Lock()
 	rc.confState = snapshotToSave.Metadata.ConfState
+	rc.confMu.Unlock()
 	rc.snapshotIndex = snapshotToSave.Metadata.Index
 	rc.appliedIndex = snapshotToSave.Metadata.Index
+	rc.appliedBytes = 0
+	rc.lastSnapshot = rc.clock.Now()
+
+	select {
+	case rc.appliedC <- applied{index: rc.appliedIndex}:
+	case <-rc.stopc:
+	}
 }
 
-var snapshotCatchUpEntriesN uint64 = 10000
+// shouldSnapshot returns whether enough entries, bytes or time have been
+// applied since the last snapshot to trigger a new one. As it is checked when
+// entries are applied, an idle node does not snapshot.
+func (rc *raftNode) shouldSnapshot() bool {
+	switch {
+	case rc.appliedIndex == rc.snapshotIndex:
+		return false
+	case rc.appliedIndex-rc.snapshotIndex > rc.snapCount:
+		return true
+	case rc.snapBytes > 0 && rc.appliedBytes >= rc.snapBytes:
+		return true
+	default:
+		return rc.snapInterval > 0 && rc.clock.Since(rc.lastSnapshot) >= rc.snapInterval
+	}
+}
+
+// compactIndex returns the index up to which the log is compacted after a
+// snapshot at applied, keeping catchUp entries. On the leader, it keeps the
+// entries that the recently active followers lack as well, up to maxCatchUp,
+// so that a briefly lagging follower is not sent a snapshot.
+func compactIndex(applied, catchUp, maxCatchUp uint64, st raft.Status) uint64 {
+	retain := catchUp
+	if st.RaftState == raft.StateLeader {
+		for id, pr := range st.Progress {
+			if id == st.ID || !pr.RecentActive || pr.Match >= applied {
+				continue
+			}
+			if lag := applied - pr.Match; lag > retain && lag <= maxCatchUp {
+				retain = lag
+			}
+		}
+	}
+	if applied <= retain {
+		return 1
+	}
+	return applied - retain
+}
 
 func (rc *raftNode) maybeTriggerSnapshot(applyDoneC <-chan struct{}) {
-	if rc.appliedIndex-rc.snapshotIndex <= rc.snapCount {
+	if !rc.shouldSnapshot() {
 		return
 	}
 
@@ -387,10 +747,7 @@ func (rc *raftNode) maybeTriggerSnapshot(applyDoneC <-chan struct{}) {
 		panic(err)
 	}
 
-	compactIndex := uint64(1)
-	if rc.appliedIndex > snapshotCatchUpEntriesN {
-		compactIndex = rc.appliedIndex - snapshotCatchUpEntriesN
-	}
+	compactIndex := compactIndex(rc.appliedIndex, rc.snapCatchUpEntries, rc.maxSnapCatchUpEntries, rc.node.Status())
 	if err := rc.raftStorage.Compact(compactIndex); err != nil {
 		if err != raft.ErrCompacted {
 			panic(err)
@@ -400,6 +757,8 @@ func (rc *raftNode) maybeTriggerSnapshot(applyDoneC <-chan struct{}) {
 	}
 
 	rc.snapshotIndex = rc.appliedIndex
+	rc.appliedBytes = 0
+	rc.lastSnapshot = rc.clock.Now()
 }
 
 func (rc *raftNode) serveChannels() {
@@ -410,10 +769,9 @@ func (rc *raftNode) serveChannels() {
 	rc.confState = snap.Metadata.ConfState
 	rc.snapshotIndex = snap.Metadata.Index
 	rc.appliedIndex = snap.Metadata.Index
+	rc.lastSnapshot = rc.clock.Now()
 
-	defer rc.wal.Close()
-
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := rc.clock.NewTicker(rc.tickInterval)
 	defer ticker.Stop()
 
 	// send proposals over raft
@@ -426,32 +784,102 @@ func (rc *raftNode) serveChannels() {
 				if !ok {
 					rc.proposeC = nil
 				} else {
+					props, ok := rc.collectProposals(prop)
+					if !ok {
+						rc.proposeC = nil
+					}
 					// blocks until accepted by raft state machine
-					rc.node.Propose(context.TODO(), []byte(prop))
+					if err := rc.propose(encodeProposals(props)); err != nil {
+						proposalsDropped.Add(float64(len(props)))
+						log.Printf("raftexample: %d proposals dropped (%v)", len(props), err)
+					}
 				}
 
 			case cc, ok := <-rc.confChangeC:
 				if !ok {
 					rc.confChangeC = nil
 				} else {
-					confChangeCount++
-					cc.ID = confChangeCount
+					if v1, ok := cc.(raftpb.ConfChange); ok {
+						confChangeCount++
+						v1.ID = confChangeCount
+						cc = v1
+					}
 					rc.node.ProposeConfChange(context.TODO(), cc)
 				}
+
+			case respC := <-rc.confStateC:
+				rc.confMu.Lock()
+				respC <- rc.confState
+				rc.confMu.Unlock()
+
+			case respC := <-rc.membersC:
+				respC <- rc.members()
+
+			case respC := <-rc.drainC:
+				go func() { respC <- rc.drain() }()
+
+			case respC := <-rc.statusC:
+				snap, err := rc.raftStorage.Snapshot()
+				if err != nil {
+					log.Fatalf("raftexample: error getting snapshot (%v)", err)
+				}
+				respC <- newNodeStatus(rc.node.Status(), snap.Metadata)
+
+			case lt := <-rc.transferC:
+				if lt.target != 0 {
+					// a follower forwards the transfer to the leader
+					rc.node.TransferLeadership(context.TODO(), rc.node.Status().Lead, lt.target)
+				}
+				st := rc.node.Status()
+				lt.statusC <- leadership{Lead: st.Lead, Transferee: st.LeadTransferee}
 			}
 		}
 		// client closed channel; shutdown raft if not already
 		close(rc.stopc)
 	}()
 
+	go rc.serveApplied()
+	go rc.serveReads()
+	go rc.serveProgressMetrics()
+
+	if rc.asyncStorageWrites {
+		rc.serveStorage(ticker)
+		return
+	}
+
+	// the committed entries are published by an apply goroutine, so that a
+	// slow store does not delay ticks and the messages to the other nodes.
+	applyC := make(chan toApply, storageBacklog)
+	donec := make(chan struct{})    // signals the apply goroutine to exit
+	removedc := make(chan struct{}) // signals the commits stopped, e.g. this node has been removed
+	applyDonec := make(chan struct{})
+	go func() {
+		defer close(applyDonec)
+		rc.serveApply(applyC, removedc, donec)
+	}()
+	// the apply goroutine writes the commit channel, which is closed once it
+	// exits.
+	stopApply := func() {
+		close(donec)
+		<-applyDonec
+	}
+
 	// event loop on raft state machine updates
 	for {
 		select {
-		case <-ticker.C:
-			rc.node.Tick()
+		case <-ticker.Chan():
+			rc.tick()
 
 		// store raft entries to wal, then publish over commit channel
 		case rd := <-rc.node.Ready():
+			start := time.Now()
+			if !raft.IsEmptyHardState(rd.HardState) {
+				rc.lagTracker.commit(rd.HardState.Commit)
+			}
+			if rc.witness {
+				rd.Snapshot.Data = nil
+				rd.Entries = witnessEntries(rd.Entries)
+			}
 			// Must save the snapshot file and WAL snapshot entry before saving any other entries
 			// or hardstate to ensure that recovery after a snapshot restore is possible.
 			if !raft.IsEmptySnap(rd.Snapshot) {
@@ -460,33 +888,257 @@ func (rc *raftNode) serveChannels() {
 			rc.wal.Save(rd.HardState, rd.Entries)
 			if !raft.IsEmptySnap(rd.Snapshot) {
 				rc.raftStorage.ApplySnapshot(rd.Snapshot)
-				rc.publishSnapshot(rd.Snapshot)
+				rc.queueApply(applyC, toApply{m: raftpb.Message{Type: raftpb.MsgSnap, Snapshot: &rd.Snapshot}}, removedc)
 			}
 			rc.raftStorage.Append(rd.Entries)
-			rc.transport.Send(rc.processMessages(rd.Messages))
-			applyDoneC, ok := rc.publishEntries(rc.entriesToApply(rd.CommittedEntries))
-			if !ok {
-				rc.stop()
-				return
+			rc.send(rc.processMessages(rd.Messages))
+			rc.sendReadStates(rd.ReadStates)
+			if len(rd.CommittedEntries) > 0 {
+				ap := toApply{m: raftpb.Message{Type: raftpb.MsgStorageApply, Entries: rd.CommittedEntries}}
+				if hasConfChange(rd.CommittedEntries) {
+					// raft must see the conf changes applied before it
+					// counts votes or campaigns in the new configuration.
+					ap.notifyc = make(chan struct{})
+				}
+				rc.queueApply(applyC, ap, removedc)
+				if ap.notifyc != nil {
+					select {
+					case <-ap.notifyc:
+					case <-removedc:
+					case <-rc.stopc:
+					}
+				}
 			}
-			rc.maybeTriggerSnapshot(applyDoneC)
 			rc.node.Advance()
+			readyDurationSeconds.Observe(time.Since(start).Seconds())
+
+		case err := <-rc.transport.ErrorC:
+			stopApply()
+			rc.writeError(err)
+			return
+
+		case <-removedc:
+			stopApply()
+			rc.stop()
+			return
+
+		case <-rc.stopc:
+			stopApply()
+			rc.stop()
+			return
+		}
+	}
+}
+
+// toApply is a snapshot or committed entries queued for serveApply, which
+// closes notifyc, if set, once they are published.
+type toApply struct {
+	m       raftpb.Message
+	notifyc chan struct{}
+}
+
+// queueApply queues ap for serveApply, accounting for its entries. It blocks
+// while the queue is full.
+func (rc *raftNode) queueApply(applyC chan<- toApply, ap toApply, removedc <-chan struct{}) {
+	select {
+	case applyC <- ap:
+		applyPendingEntries.Set(float64(rc.applyPending.Add(int64(len(ap.m.Entries)))))
+	case <-removedc:
+	case <-rc.stopc:
+	}
+}
+
+func hasConfChange(ents []raftpb.Entry) bool {
+	for _, e := range ents {
+		if e.Type == raftpb.EntryConfChange || e.Type == raftpb.EntryConfChangeV2 {
+			return true
+		}
+	}
+	return false
+}
+
+// propose proposes data to raft, unless too many committed entries are
+// pending apply.
+func (rc *raftNode) propose(data []byte) error {
+	if rc.readOnly {
+		return errReadOnly
+	}
+	if n := rc.applyPending.Load(); n > maxApplyPending {
+		return fmt.Errorf("%w: %d entries", errApplyBacklog, n)
+	}
+	return rc.node.Propose(context.TODO(), data)
+}
+
+// storageBacklog is the number of storage messages that may be queued for
+// each storage goroutine before the Ready loop blocks.
+const storageBacklog = 1024
+
+// serveStorage runs the event loop on raft state machine updates with async
+// storage writes. The log appends and the committed entries come as
+// MsgStorageAppend and MsgStorageApply messages, which are handled in order
+// by an append and an apply goroutine, so that fsyncing the WAL does not
+// delay ticks and the messages from the other nodes.
+func (rc *raftNode) serveStorage(ticker clockwork.Ticker) {
+	appendC := make(chan raftpb.Message, storageBacklog)
+	applyC := make(chan toApply, storageBacklog)
+	donec := make(chan struct{})    // signals the storage goroutines to exit
+	removedc := make(chan struct{}) // signals the commits stopped, e.g. this node has been removed
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		rc.serveAppend(appendC, applyC, donec)
+	}()
+	go func() {
+		defer wg.Done()
+		rc.serveApply(applyC, removedc, donec)
+	}()
+	// the storage goroutines write the WAL and the commit channel, which are
+	// closed once they exit.
+	stopStorage := func() {
+		close(donec)
+		wg.Wait()
+	}
+
+	for {
+		select {
+		case <-ticker.Chan():
+			rc.tick()
+
+		case rd := <-rc.node.Ready():
+			start := time.Now()
+			if !raft.IsEmptyHardState(rd.HardState) {
+				rc.lagTracker.commit(rd.HardState.Commit)
+			}
+			var msgs []raftpb.Message
+			for _, m := range rd.Messages {
+				switch m.To {
+				case raft.LocalAppendThread:
+					select {
+					case appendC <- m:
+					case <-removedc:
+					case <-rc.stopc:
+					}
+				case raft.LocalApplyThread:
+					rc.queueApply(applyC, toApply{m: m}, removedc)
+				default:
+					msgs = append(msgs, m)
+				}
+			}
+			rc.send(rc.processMessages(msgs))
+			rc.sendReadStates(rd.ReadStates)
+			readyDurationSeconds.Observe(time.Since(start).Seconds())
 
 		case err := <-rc.transport.ErrorC:
+			stopStorage()
 			rc.writeError(err)
 			return
 
+		case <-removedc:
+			stopStorage()
+			rc.stop()
+			return
+
 		case <-rc.stopc:
+			stopStorage()
 			rc.stop()
 			return
 		}
 	}
 }
 
+// serveAppend saves the entries, hard state and snapshot of the
+// MsgStorageAppend messages to the WAL and raft storage, then delivers
+// their responses.
+func (rc *raftNode) serveAppend(appendC <-chan raftpb.Message, applyC chan<- toApply, donec <-chan struct{}) {
+	for {
+		select {
+		case m := <-appendC:
+			if rc.witness {
+				if m.Snapshot != nil {
+					snap := *m.Snapshot
+					snap.Data = nil
+					m.Snapshot = &snap
+				}
+				m.Entries = witnessEntries(m.Entries)
+			}
+			// Must save the snapshot file and WAL snapshot entry before saving any other entries
+			// or hardstate to ensure that recovery after a snapshot restore is possible.
+			if m.Snapshot != nil {
+				rc.saveSnap(*m.Snapshot)
+			}
+			rc.wal.Save(raftpb.HardState{Term: m.Term, Vote: m.Vote, Commit: m.Commit}, m.Entries)
+			if m.Snapshot != nil {
+				rc.raftStorage.ApplySnapshot(*m.Snapshot)
+				// raft holds back the committed entries after the snapshot
+				// until it is acknowledged, so the apply goroutine publishes
+				// it in order.
+				select {
+				case applyC <- toApply{m: raftpb.Message{Type: raftpb.MsgSnap, Snapshot: m.Snapshot}}:
+				case <-donec:
+					return
+				}
+			}
+			rc.raftStorage.Append(m.Entries)
+			rc.sendResponses(m.Responses)
+
+		case <-donec:
+			return
+		}
+	}
+}
+
+// serveApply publishes the committed entries of the MsgStorageApply
+// messages, and the snapshots, over the commit channel, then delivers their
+// responses.
+func (rc *raftNode) serveApply(applyC <-chan toApply, removedc chan<- struct{}, donec <-chan struct{}) {
+	for {
+		select {
+		case ap := <-applyC:
+			m := ap.m
+			if m.Type == raftpb.MsgSnap {
+				rc.publishSnapshot(*m.Snapshot)
+				continue
+			}
+			applyDoneC, ok := rc.publishEntries(rc.entriesToApply(m.Entries))
+			if !ok {
+				close(removedc)
+				return
+			}
+			applyPendingEntries.Set(float64(rc.applyPending.Add(-int64(len(m.Entries)))))
+			if ap.notifyc != nil {
+				close(ap.notifyc)
+			}
+			rc.maybeTriggerSnapshot(applyDoneC)
+			rc.sendResponses(m.Responses)
+
+		case <-donec:
+			return
+		}
+	}
+}
+
+// sendResponses delivers the responses of a storage message, stepping the
+// ones to this node and sending the others over the transport.
+func (rc *raftNode) sendResponses(ms []raftpb.Message) {
+	var remote []raftpb.Message
+	for _, m := range ms {
+		if m.To == uint64(rc.id) {
+			rc.node.Step(context.TODO(), m)
+		} else {
+			remote = append(remote, m)
+		}
+	}
+	rc.transport.Send(remote)
+}
+
 // When there is a `raftpb.EntryConfChange` after creating the snapshot,
 // then the confState included in the snapshot is out of date. so We need
 // to update the confState before sending a snapshot to a follower.
 func (rc *raftNode) processMessages(ms []raftpb.Message) []raftpb.Message {
+	rc.confMu.Lock()
+	defer rc.confMu.Unlock()
 	for i := 0; i < len(ms); i++ {
 		if ms[i].Type == raftpb.MsgSnap {
 			ms[i].Snapshot.Metadata.ConfState = rc.confState
@@ -495,18 +1147,255 @@ func (rc *raftNode) processMessages(ms []raftpb.Message) []raftpb.Message {
 	return ms
 }
 
+// appliedBacklog is the number of published entries that may be queued for
+// serveApplied before publishing blocks.
+const appliedBacklog = 1024
+
+// serveApplied triggers applyWait with the published indexes, in order, once
+// the store has applied them.
+func (rc *raftNode) serveApplied() {
+	for {
+		select {
+		case a := <-rc.appliedC:
+			if a.applyDoneC != nil {
+				select {
+				case <-a.applyDoneC:
+				case <-rc.stopc:
+					return
+				}
+			}
+			rc.lagTracker.apply(a.index, a.published)
+			rc.applyWait.Trigger(a.index)
+
+		case <-rc.stopc:
+			return
+		}
+	}
+}
+
+// sendReadStates hands the latest read state to serveReads. Only one read
+// index is in flight at a time, so earlier ones are stale.
+func (rc *raftNode) sendReadStates(rss []raft.ReadState) {
+	if len(rss) == 0 {
+		return
+	}
+	select {
+	case rc.readStateC <- rss[len(rss)-1]:
+	default:
+		log.Printf("raftexample: dropped read state %d", rss[len(rss)-1].Index)
+	}
+}
+
+// serveReads serves the linearizable read requests. Each request is answered
+// over its channel once the store has applied the leader's commit index as
+// of the request, so a read that follows it observes every write committed
+// before it was issued. Requests that arrive while a read index is in flight
+// share the next one.
+func (rc *raftNode) serveReads() {
+	var id uint64
+	for {
+		var reqs []chan<- error
+		select {
+		case errC := <-rc.readIndexC:
+			reqs = append(reqs, errC)
+		case <-rc.stopc:
+			return
+		}
+		for more := true; more; {
+			select {
+			case errC := <-rc.readIndexC:
+				reqs = append(reqs, errC)
+			default:
+				more = false
+			}
+		}
+
+		id++
+		err := rc.readIndex(id)
+		for _, errC := range reqs {
+			errC <- err
+		}
+	}
+}
+
+// readIndex confirms a read index with the leader, identified by id, and
+// waits until the store has applied it.
+func (rc *raftNode) readIndex(id uint64) error {
+	// drop the read state of a request that timed out
+	select {
+	case <-rc.readStateC:
+	default:
+	}
+
+	rctx := make([]byte, 8)
+	binary.BigEndian.PutUint64(rctx, id)
+	if err := rc.node.ReadIndex(context.TODO(), rctx); err != nil {
+		return err
+	}
+
+	timeout := time.After(readIndexTimeout)
+	var index uint64
+	for index == 0 {
+		select {
+		case rs := <-rc.readStateC:
+			if bytes.Equal(rs.RequestCtx, rctx) {
+				index = rs.Index
+			}
+		case <-timeout:
+			return errReadIndexTimeout
+		case <-rc.stopc:
+			return errStopped
+		}
+	}
+
+	select {
+	case <-rc.applyWait.Wait(index):
+		return nil
+	case <-timeout:
+		return errReadIndexTimeout
+	case <-rc.stopc:
+		return errStopped
+	}
+}
+
+// drain waits until the store has applied every entry committed before the
+// call, then syncs the WAL, so that the node can be stopped without losing
+// the writes in flight to the store. The proposals are expected to be
+// stopped already.
+func (rc *raftNode) drain() error {
+	commit := rc.node.Status().Commit
+	select {
+	case <-rc.applyWait.Wait(commit):
+	case <-time.After(drainTimeout):
+		return errDrainTimeout
+	case <-rc.stopc:
+		return errStopped
+	}
+	return rc.wal.Sync()
+}
+
+// randomElectionTick returns the election tick of a node whose election
+// timeouts fall in [minTick, maxTick). Raft draws each timeout from
+// [ElectionTick, 2*ElectionTick), so the election tick is drawn from
+// [minTick, maxTick/2] to spread the nodes over the whole range.
+func randomElectionTick(minTick, maxTick int) int {
+	if maxTick/2 <= minTick {
+		return minTick
+	}
+	return minTick + rand.Intn(maxTick/2-minTick+1)
+}
+
+// validateElectionTicks checks the election timeout range, which must leave
+// raft room to randomize the timeouts and exceed the heartbeat interval.
+func validateElectionTicks(minTick, maxTick int) error {
+	if minTick <= 1 {
+		return fmt.Errorf("raftexample: election tick min %d must exceed the heartbeat tick", minTick)
+	}
+	if maxTick < 2*minTick {
+		return fmt.Errorf("raftexample: election tick max %d must be at least twice the min %d", maxTick, minTick)
+	}
+	return nil
+}
+
+// tick advances the raft clock. A witness never ticks, so it never
+// campaigns and cannot become the leader without the payloads to replicate;
+// it still votes for the other members.
+func (rc *raftNode) tick() {
+	if !rc.witness {
+		rc.node.Tick()
+	}
+}
+
+// witnessEntries returns a copy of ents without the payloads of the normal
+// entries, which is all a witness persists of them: the log matching and
+// the votes only depend on the indexes and terms. Conf changes are kept, the
+// witness applies them to follow the membership.
+func witnessEntries(ents []raftpb.Entry) []raftpb.Entry {
+	if len(ents) == 0 {
+		return ents
+	}
+	wents := make([]raftpb.Entry, len(ents))
+	for i, ent := range ents {
+		if ent.Type == raftpb.EntryNormal {
+			ent.Data = nil
+		}
+		wents[i] = ent
+	}
+	return wents
+}
+
+// validateWitness checks that a witness has at least two other members to
+// break the tie between, as it can never lead itself.
+func validateWitness(peers []string) error {
+	if len(peers) < 3 {
+		return fmt.Errorf("raftexample: a witness needs at least 2 other members, got %d", len(peers)-1)
+	}
+	return nil
+}
+
+// dataDirs returns the WAL and snapshot directories of node id, which are
+// walDir and snapDir, or raftexample-<id> and raftexample-<id>-snap in the
+// working directory if they are empty.
+func (cfg raftConfig) dataDirs(id int) (waldir, snapdir string) {
+	waldir, snapdir = cfg.walDir, cfg.snapDir
+	if waldir == "" {
+		waldir = fmt.Sprintf("raftexample-%d", id)
+	}
+	if snapdir == "" {
+		snapdir = fmt.Sprintf("raftexample-%d-snap", id)
+	}
+	return waldir, snapdir
+}
+
+// validateDataDirs checks that the WAL and snapshot directories are apart,
+// neither being in the other: the WAL directory is created by renaming a
+// temporary one over it, which fails once it holds the snapshot directory.
+func validateDataDirs(waldir, snapdir string) error {
+	w, err := filepath.Abs(waldir)
+	if err != nil {
+		return err
+	}
+	s, err := filepath.Abs(snapdir)
+	if err != nil {
+		return err
+	}
+	if w == s || strings.HasPrefix(s, w+string(filepath.Separator)) || strings.HasPrefix(w, s+string(filepath.Separator)) {
+		return fmt.Errorf("raftexample: WAL directory %q and snapshot directory %q must not contain each other", waldir, snapdir)
+	}
+	return nil
+}
+
+// validateReadOnly checks that a read-only replica joins an existing cluster,
+// to which it must have been added as a learner.
+func validateReadOnly(join, witness bool) error {
+	if !join {
+		return errors.New("raftexample: a read-only replica must --join an existing cluster")
+	}
+	if witness {
+		return errors.New("raftexample: a witness cannot be a read-only replica")
+	}
+	return nil
+}
+
 func (rc *raftNode) serveRaft() {
 	url, err := url.Parse(rc.peers[rc.id-1])
 	if err != nil {
 		log.Fatalf("raftexample: Failed parsing URL (%v)", err)
 	}
 
-	ln, err := newStoppableListener(url.Host, rc.httpstopc)
+	sln, err := newStoppableListener(url.Host, rc.httpstopc)
+	if err != nil {
+		log.Fatalf("raftexample: Failed to listen rafthttp (%v)", err)
+	}
+	ln, err := newTLSListener(sln, rc.peerTLSInfo)
 	if err != nil {
 		log.Fatalf("raftexample: Failed to listen rafthttp (%v)", err)
 	}
 
-	err = (&http.Server{Handler: rc.transport.Handler()}).Serve(ln)
+	mux := http.NewServeMux()
+	mux.HandleFunc(snapshotPath, rc.handleSnapshot)
+	mux.Handle("/", rc.transport.Handler())
+	err = (&http.Server{Handler: mux}).Serve(ln)
 	select {
 	case <-rc.httpstopc:
 	default:
@@ -516,6 +1405,15 @@ func (rc *raftNode) serveRaft() {
 }
 
 func (rc *raftNode) Process(ctx context.Context, m raftpb.Message) error {
+	if (rc.witness || rc.readOnly) && m.Type == raftpb.MsgTimeoutNow {
+		// refuse the leadership transferred to a witness or a replica
+		return nil
+	}
+	if m.Type == raftpb.MsgSnap && len(m.Snapshot.Data) == 0 {
+		if err := rc.readSnapshotData(&m); err != nil {
+			return err
+		}
+	}
 	return rc.node.Step(ctx, m)
 }
 func (rc *raftNode) IsIDRemoved(_ uint64) bool   { return false }
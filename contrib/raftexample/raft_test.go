@@ -15,10 +15,20 @@
 package main
 
 import (
+	"errors"
+	"math"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"go.etcd.io/etcd/pkg/v3/flags"
+	"go.etcd.io/raft/v3"
 	"go.etcd.io/raft/v3/raftpb"
+	"go.etcd.io/raft/v3/tracker"
 )
 
 func TestProcessMessages(t *testing.T) {
@@ -128,3 +138,297 @@ func TestProcessMessages(t *testing.T) {
 		})
 	}
 }
+
+func TestWitnessEntries(t *testing.T) {
+	ents := []raftpb.Entry{
+		{Index: 1, Term: 1, Type: raftpb.EntryNormal, Data: []byte("foo")},
+		{Index: 2, Term: 1, Type: raftpb.EntryConfChange, Data: []byte("cc")},
+		{Index: 3, Term: 2, Type: raftpb.EntryNormal},
+	}
+	want := []raftpb.Entry{
+		{Index: 1, Term: 1, Type: raftpb.EntryNormal},
+		{Index: 2, Term: 1, Type: raftpb.EntryConfChange, Data: []byte("cc")},
+		{Index: 3, Term: 2, Type: raftpb.EntryNormal},
+	}
+	if got := witnessEntries(ents); !reflect.DeepEqual(got, want) {
+		t.Errorf("witnessEntries() = %+v, want %+v", got, want)
+	}
+	if string(ents[0].Data) != "foo" {
+		t.Errorf("witnessEntries() modified its input")
+	}
+}
+
+func TestValidateWitness(t *testing.T) {
+	for n, wok := range map[int]bool{1: false, 2: false, 3: true, 5: true} {
+		if err := validateWitness(make([]string, n)); (err == nil) != wok {
+			t.Errorf("validateWitness(%d peers) = %v, want ok %v", n, err, wok)
+		}
+	}
+}
+
+func TestProposalsEncoding(t *testing.T) {
+	tests := [][]string{
+		{"foo"},
+		{"foo", "", "bar"},
+		{string(make([]byte, 300)), "baz"},
+	}
+	for i, props := range tests {
+		data := encodeProposals(props)
+		if len(props) == 1 && string(data) != props[0] {
+			t.Errorf("#%d: a single proposal is encoded as %q", i, data)
+		}
+		got, err := decodeProposals(data)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, props) {
+			t.Errorf("#%d: decoded %q, want %q", i, got, props)
+		}
+	}
+
+	if _, err := decodeProposals([]byte{batchMarker, 5, 'f'}); err != errCorruptBatch {
+		t.Errorf("err = %v, want %v", err, errCorruptBatch)
+	}
+}
+
+func TestPeerProgresses(t *testing.T) {
+	inflights := tracker.NewInflights(4, 0)
+	inflights.Add(11, 100)
+	inflights.Add(12, 100)
+	st := raft.Status{Progress: map[uint64]tracker.Progress{
+		3: {Match: 5, Next: 6, State: tracker.StateSnapshot, PendingSnapshot: 8, Inflights: tracker.NewInflights(4, 0)},
+		1: {Match: 12, Next: 13, State: tracker.StateReplicate, RecentActive: true, Inflights: inflights},
+		2: {Match: 10, Next: 11, State: tracker.StateProbe, MsgAppFlowPaused: true, Inflights: tracker.NewInflights(4, 0), IsLearner: true},
+	}}
+	want := []peerProgress{
+		{ID: 1, Match: 12, Next: 13, State: "StateReplicate", Inflight: 2, RecentActive: true},
+		{ID: 2, Match: 10, Next: 11, State: "StateProbe", Paused: true, IsLearner: true},
+		{ID: 3, Match: 5, Next: 6, State: "StateSnapshot", PendingSnapshot: 8, Paused: true},
+	}
+	if got := peerProgresses(st); !reflect.DeepEqual(got, want) {
+		t.Errorf("peerProgresses() = %+v, want %+v", got, want)
+	}
+	if got := peerProgresses(raft.Status{}); len(got) != 0 {
+		t.Errorf("peerProgresses() of a follower = %+v, want none", got)
+	}
+}
+
+func TestRandomElectionTick(t *testing.T) {
+	tests := []struct {
+		minTick, maxTick int
+		wmin, wmax       int
+	}{
+		{10, 20, 10, 10},
+		{10, 31, 10, 15},
+		{5, 8, 5, 5},
+	}
+	for i, tt := range tests {
+		for n := 0; n < 100; n++ {
+			if et := randomElectionTick(tt.minTick, tt.maxTick); et < tt.wmin || et > tt.wmax {
+				t.Fatalf("#%d: election tick = %d, want in [%d, %d]", i, et, tt.wmin, tt.wmax)
+			}
+		}
+	}
+}
+
+func TestValidateTLSInfo(t *testing.T) {
+	tests := []struct {
+		info transport.TLSInfo
+		wok  bool
+	}{
+		{transport.TLSInfo{}, true},
+		{transport.TLSInfo{CertFile: "cert.pem", KeyFile: "key.pem"}, true},
+		{transport.TLSInfo{CertFile: "cert.pem", KeyFile: "key.pem", TrustedCAFile: "ca.pem"}, true},
+		{transport.TLSInfo{CertFile: "cert.pem"}, false},
+		{transport.TLSInfo{KeyFile: "key.pem"}, false},
+		{transport.TLSInfo{TrustedCAFile: "ca.pem"}, false},
+	}
+	for i, tt := range tests {
+		if err := validateTLSInfo(tt.info); (err == nil) != tt.wok {
+			t.Errorf("#%d: validateTLSInfo(%+v) = %v, want ok %v", i, tt.info, err, tt.wok)
+		}
+	}
+
+	peers := []string{"https://127.0.0.1:12379", "https://127.0.0.1:22379"}
+	if err := validatePeerURLs(peers, transport.TLSInfo{CertFile: "cert.pem", KeyFile: "key.pem"}); err != nil {
+		t.Errorf("https peers with TLS: %v", err)
+	}
+	if err := validatePeerURLs(peers, transport.TLSInfo{}); err == nil {
+		t.Error("https peers without TLS not reported")
+	}
+}
+
+func TestValidateElectionTicks(t *testing.T) {
+	tests := []struct {
+		minTick, maxTick int
+		wok              bool
+	}{
+		{10, 20, true},
+		{10, 30, true},
+		{10, 19, false},
+		{1, 10, false},
+	}
+	for i, tt := range tests {
+		if err := validateElectionTicks(tt.minTick, tt.maxTick); (err == nil) != tt.wok {
+			t.Errorf("#%d: validateElectionTicks(%d, %d) = %v, want ok %v", i, tt.minTick, tt.maxTick, err, tt.wok)
+		}
+	}
+}
+
+func TestBytesToInt(t *testing.T) {
+	tests := []struct {
+		b   uint64
+		wok bool
+	}{
+		{0, true},
+		{1024 * 1024, true},
+		{math.MaxInt, true},
+		{math.MaxInt + 1, false},
+		{math.MaxUint64, false},
+	}
+	for i, tt := range tests {
+		v, err := bytesToInt("max-proposal-bytes", flags.BytesValue(tt.b))
+		if (err == nil) != tt.wok || err == nil && uint64(v) != tt.b {
+			t.Errorf("#%d: bytesToInt(%d) = %d, %v, want ok %v", i, tt.b, v, err, tt.wok)
+		}
+	}
+}
+
+func TestValidateDataDirs(t *testing.T) {
+	tests := []struct {
+		waldir, snapdir string
+		wok             bool
+	}{
+		{"raftexample-1", "raftexample-1-snap", true},
+		{"/wal/1", "/data/snap", true},
+		{"wal", "wal", false},
+		{"wal", "wal/snap", false},
+		{"data/wal", "data", false},
+		{"wal", "./wal/", false},
+	}
+	for i, tt := range tests {
+		if err := validateDataDirs(tt.waldir, tt.snapdir); (err == nil) != tt.wok {
+			t.Errorf("#%d: validateDataDirs(%q, %q) = %v, want ok %v", i, tt.waldir, tt.snapdir, err, tt.wok)
+		}
+	}
+}
+
+func TestProposeApplyBacklog(t *testing.T) {
+	prevMaxApplyPending := maxApplyPending
+	maxApplyPending = 1
+	defer func() { maxApplyPending = prevMaxApplyPending }()
+
+	rc := &raftNode{}
+	rc.applyPending.Store(2)
+	if err := rc.propose([]byte("foo")); !errors.Is(err, errApplyBacklog) {
+		t.Fatalf("err = %v, want %v", err, errApplyBacklog)
+	}
+}
+
+type fakeGauge struct {
+	prometheus.Gauge
+	v float64
+}
+
+func (g *fakeGauge) Set(v float64) { g.v = v }
+
+type fakeObserver []float64
+
+func (o *fakeObserver) Observe(v float64) { *o = append(*o, v) }
+
+func TestApplyTracker(t *testing.T) {
+	lag, duration := &fakeGauge{}, &fakeObserver{}
+	tr := newApplyTracker(lag, duration)
+
+	tests := []struct {
+		committed, applied uint64
+		published          time.Time
+
+		wlag      float64
+		wduration int
+	}{
+		{10, 0, time.Time{}, 10, 0},
+		{0, 4, time.Now(), 6, 1},
+		// the indexes only move forward
+		{8, 2, time.Time{}, 6, 1},
+		{12, 12, time.Now(), 0, 2},
+		// a snapshot may be applied ahead of the known commit index
+		{0, 15, time.Time{}, 0, 2},
+	}
+	for i, tt := range tests {
+		tr.commit(tt.committed)
+		tr.apply(tt.applied, tt.published)
+		if lag.v != tt.wlag {
+			t.Errorf("#%d: lag = %v, want %v", i, lag.v, tt.wlag)
+		}
+		if len(*duration) != tt.wduration {
+			t.Errorf("#%d: %d durations observed, want %d", i, len(*duration), tt.wduration)
+		}
+	}
+}
+
+func TestShouldSnapshot(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	tests := []struct {
+		applied, bytes uint64
+		elapsed        time.Duration
+		snapBytes      uint64
+		snapInterval   time.Duration
+		w              bool
+	}{
+		{applied: 10, w: false},
+		{applied: 11, w: true},
+		// nothing applied since the snapshot
+		{applied: 0, bytes: 100, elapsed: time.Hour, snapBytes: 100, snapInterval: time.Minute, w: false},
+		{applied: 1, bytes: 99, snapBytes: 100, w: false},
+		{applied: 1, bytes: 100, snapBytes: 100, w: true},
+		{applied: 1, bytes: 100, w: false},
+		{applied: 1, elapsed: 59 * time.Second, snapInterval: time.Minute, w: false},
+		{applied: 1, elapsed: time.Minute, snapInterval: time.Minute, w: true},
+		{applied: 1, elapsed: time.Hour, w: false},
+	}
+	for i, tt := range tests {
+		rc := &raftNode{
+			snapCount:     10,
+			snapBytes:     tt.snapBytes,
+			snapInterval:  tt.snapInterval,
+			clock:         clock,
+			snapshotIndex: 5,
+			appliedIndex:  5 + tt.applied,
+			appliedBytes:  tt.bytes,
+			lastSnapshot:  clock.Now().Add(-tt.elapsed),
+		}
+		if got := rc.shouldSnapshot(); got != tt.w {
+			t.Errorf("#%d: shouldSnapshot() = %v, want %v", i, got, tt.w)
+		}
+	}
+}
+
+func TestCompactIndex(t *testing.T) {
+	leader := func(prs map[uint64]tracker.Progress) raft.Status {
+		st := raft.Status{Progress: prs}
+		st.ID = 1
+		st.RaftState = raft.StateLeader
+		return st
+	}
+	tests := []struct {
+		applied uint64
+		st      raft.Status
+		w       uint64
+	}{
+		{100, raft.Status{}, 90},
+		{5, raft.Status{}, 1},
+		// a follower lagging behind the catch-up entries
+		{100, leader(map[uint64]tracker.Progress{1: {Match: 100, RecentActive: true}, 2: {Match: 60, RecentActive: true}, 3: {Match: 95, RecentActive: true}}), 60},
+		// unless it is not recently active, or lags behind the maximum
+		{100, leader(map[uint64]tracker.Progress{1: {Match: 100, RecentActive: true}, 2: {Match: 60}}), 90},
+		{100, leader(map[uint64]tracker.Progress{1: {Match: 100, RecentActive: true}, 2: {Match: 40, RecentActive: true}}), 90},
+		// followers only keep the catch-up entries
+		{100, raft.Status{Progress: map[uint64]tracker.Progress{2: {Match: 60, RecentActive: true}}}, 90},
+	}
+	for i, tt := range tests {
+		if got := compactIndex(tt.applied, 10, 50, tt.st); got != tt.w {
+			t.Errorf("#%d: compactIndex() = %d, want %d", i, got, tt.w)
+		}
+	}
+}
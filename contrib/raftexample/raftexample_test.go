@@ -16,15 +16,35 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"go.etcd.io/etcd/server/v3/storage/wal"
+	"go.etcd.io/etcd/v3/contrib/raftexample/kvpb"
 	"go.etcd.io/raft/v3/raftpb"
 )
 
@@ -41,15 +61,45 @@ type cluster struct {
 	commitC            []<-chan *commit
 	errorC             []<-chan error
 	proposeC           []chan string
-	confChangeC        []chan raftpb.ConfChange
+	confChangeC        []chan raftpb.ConfChangeI
+	transferC          []chan leadershipTransfer
+	readIndexC         []chan chan<- error
+	confStateC         []chan chan<- raftpb.ConfState
+	statusC            []chan chan<- nodeStatus
+	membersC           []chan chan<- []member
+	drainC             []chan chan<- error
 	snapshotTriggeredC []<-chan struct{}
 }
 
-// newCluster creates a cluster of n nodes
-func newCluster(n int) *cluster {
+// channels returns the channels of the i-th node.
+func (clus *cluster) channels(i int) raftChannels {
+	return raftChannels{
+		proposeC:    clus.proposeC[i],
+		confChangeC: clus.confChangeC[i],
+		transferC:   clus.transferC[i],
+		readIndexC:  clus.readIndexC[i],
+		confStateC:  clus.confStateC[i],
+		statusC:     clus.statusC[i],
+		membersC:    clus.membersC[i],
+		drainC:      clus.drainC[i],
+	}
+}
+
+// newCluster creates a cluster of n nodes, of which the given IDs are witnesses
+func newCluster(n int, witnesses ...int) *cluster {
+	return newClusterConfig(defaultRaftConfig(), n, witnesses...)
+}
+
+// newClusterConfig creates a cluster of n nodes configured by cfg, of which
+// the given IDs are witnesses.
+func newClusterConfig(cfg raftConfig, n int, witnesses ...int) *cluster {
+	scheme := "http"
+	if !cfg.peerTLSInfo.Empty() {
+		scheme = "https"
+	}
 	peers := make([]string, n)
 	for i := range peers {
-		peers[i] = fmt.Sprintf("http://127.0.0.1:%d", 10000+i)
+		peers[i] = fmt.Sprintf("%s://127.0.0.1:%d", scheme, 10000+i)
 	}
 
 	clus := &cluster{
@@ -57,18 +107,31 @@ func newCluster(n int) *cluster {
 		commitC:            make([]<-chan *commit, len(peers)),
 		errorC:             make([]<-chan error, len(peers)),
 		proposeC:           make([]chan string, len(peers)),
-		confChangeC:        make([]chan raftpb.ConfChange, len(peers)),
+		confChangeC:        make([]chan raftpb.ConfChangeI, len(peers)),
+		transferC:          make([]chan leadershipTransfer, len(peers)),
+		readIndexC:         make([]chan chan<- error, len(peers)),
+		confStateC:         make([]chan chan<- raftpb.ConfState, len(peers)),
+		statusC:            make([]chan chan<- nodeStatus, len(peers)),
+		membersC:           make([]chan chan<- []member, len(peers)),
+		drainC:             make([]chan chan<- error, len(peers)),
 		snapshotTriggeredC: make([]<-chan struct{}, len(peers)),
 	}
 
 	for i := range clus.peers {
+		cfg.witness = slices.Contains(witnesses, i+1)
 		os.RemoveAll(fmt.Sprintf("raftexample-%d", i+1))
 		os.RemoveAll(fmt.Sprintf("raftexample-%d-snap", i+1))
 		clus.proposeC[i] = make(chan string, 1)
-		clus.confChangeC[i] = make(chan raftpb.ConfChange, 1)
+		clus.confChangeC[i] = make(chan raftpb.ConfChangeI, 1)
+		clus.transferC[i] = make(chan leadershipTransfer)
+		clus.readIndexC[i] = make(chan chan<- error)
+		clus.confStateC[i] = make(chan chan<- raftpb.ConfState)
+		clus.statusC[i] = make(chan chan<- nodeStatus)
+		clus.membersC[i] = make(chan chan<- []member)
+		clus.drainC[i] = make(chan chan<- error)
 		fn, snapshotTriggeredC := getSnapshotFn()
 		clus.snapshotTriggeredC[i] = snapshotTriggeredC
-		clus.commitC[i], clus.errorC[i], _ = newRaftNode(i+1, clus.peers, false, fn, clus.proposeC[i], clus.confChangeC[i])
+		clus.commitC[i], clus.errorC[i], _ = newRaftNode(i+1, clus.peers, false, cfg, fn, clus.channels(i))
 	}
 
 	return clus
@@ -177,14 +240,14 @@ func TestPutAndGetKeyValue(t *testing.T) {
 	proposeC := make(chan string)
 	defer close(proposeC)
 
-	confChangeC := make(chan raftpb.ConfChange)
+	confChangeC := make(chan raftpb.ConfChangeI)
 	defer close(confChangeC)
 
 	var kvs *kvstore
 	getSnapshot := func() ([]byte, error) { return kvs.getSnapshot() }
-	commitC, errorC, snapshotterReady := newRaftNode(1, clusters, false, getSnapshot, proposeC, confChangeC)
+	commitC, errorC, snapshotterReady := newRaftNode(1, clusters, false, defaultRaftConfig(), getSnapshot, raftChannels{proposeC: proposeC, confChangeC: confChangeC})
 
-	kvs = newKVStore(<-snapshotterReady, proposeC, commitC, errorC)
+	kvs = newKVStore(defaultKVConfig(), <-snapshotterReady, proposeC, commitC, errorC)
 
 	srv := httptest.NewServer(&httpKVAPI{
 		store:       kvs,
@@ -251,10 +314,10 @@ func TestAddNewNode(t *testing.T) {
 	proposeC := make(chan string)
 	defer close(proposeC)
 
-	confChangeC := make(chan raftpb.ConfChange)
+	confChangeC := make(chan raftpb.ConfChangeI)
 	defer close(confChangeC)
 
-	newRaftNode(4, append(clus.peers, newNodeURL), true, nil, proposeC, confChangeC)
+	newRaftNode(4, append(clus.peers, newNodeURL), true, defaultRaftConfig(), nil, raftChannels{proposeC: proposeC, confChangeC: confChangeC})
 
 	go func() {
 		proposeC <- "foo"
@@ -265,30 +328,1240 @@ func TestAddNewNode(t *testing.T) {
 	}
 }
 
-func TestSnapshot(t *testing.T) {
-	prevDefaultSnapshotCount := defaultSnapshotCount
-	prevSnapshotCatchUpEntriesN := snapshotCatchUpEntriesN
-	defaultSnapshotCount = 4
-	snapshotCatchUpEntriesN = 4
+// TestDiscovery tests that a node registering with a member is assigned
+// the next ID and the peers of the cluster, and joins it.
+func TestDiscovery(t *testing.T) {
+	clus := newCluster(3)
+	defer clus.closeNoErrors(t)
+
+	os.RemoveAll("raftexample-4")
+	os.RemoveAll("raftexample-4-snap")
 	defer func() {
-		defaultSnapshotCount = prevDefaultSnapshotCount
-		snapshotCatchUpEntriesN = prevSnapshotCatchUpEntriesN
+		os.RemoveAll("raftexample-4")
+		os.RemoveAll("raftexample-4-snap")
+	}()
+
+	srv := httptest.NewServer(&httpKVAPI{confChangeC: clus.confChangeC[0], membersC: clus.membersC[0]})
+	defer srv.Close()
+
+	newNodeURL := "http://127.0.0.1:10004"
+	id, peers, err := discover(srv.Client(), srv.URL, newNodeURL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wpeers := append(clus.peers, newNodeURL); id != 4 || !reflect.DeepEqual(peers, wpeers) {
+		t.Fatalf("discovered ID %d and peers %v, want 4 and %v", id, peers, wpeers)
+	}
+	// registering again, e.g. on restart, finds the same member
+	if id, _, err = discover(srv.Client(), srv.URL, newNodeURL, false); err != nil || id != 4 {
+		t.Fatalf("discovered ID %d (%v) again, want 4", id, err)
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var ms []member
+	if err := json.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 4 || ms[3] != (member{ID: 4, PeerURL: newNodeURL}) {
+		t.Errorf("members = %+v, want 4 with the new node last", ms)
+	}
+
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	newRaftNode(id, peers, true, defaultRaftConfig(), nil, raftChannels{proposeC: proposeC, confChangeC: confChangeC})
+
+	go func() {
+		proposeC <- "foo"
 	}()
 
+	if c, ok := <-clus.commitC[0]; !ok || c.data[0] != "foo" {
+		t.Fatalf("Commit failed")
+	}
+}
+
+// TestAddNewLearner tests adding a learner to the existing cluster and
+// promoting it to a voter.
+func TestAddNewLearner(t *testing.T) {
 	clus := newCluster(3)
 	defer clus.closeNoErrors(t)
 
+	os.RemoveAll("raftexample-4")
+	os.RemoveAll("raftexample-4-snap")
+	defer func() {
+		os.RemoveAll("raftexample-4")
+		os.RemoveAll("raftexample-4-snap")
+	}()
+
+	newNodeURL := "http://127.0.0.1:10004"
+	clus.confChangeC[0] <- raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddLearnerNode,
+		NodeID:  4,
+		Context: []byte(newNodeURL),
+	}
+
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	commitC, _, _ := newRaftNode(4, append(clus.peers, newNodeURL), true, defaultRaftConfig(), nil, raftChannels{proposeC: proposeC, confChangeC: confChangeC})
+
+	// only the commits of the first node are checked, the others are
+	// drained so that every node keeps applying.
+	for _, c := range []<-chan *commit{clus.commitC[1], clus.commitC[2], commitC} {
+		go func(c <-chan *commit) {
+			for range c { //revive:disable-line:empty-block
+			}
+		}(c)
+	}
+
 	go func() {
-		clus.proposeC[0] <- "foo"
+		proposeC <- "foo"
 	}()
 
-	c := <-clus.commitC[0]
+	if c, ok := <-clus.commitC[0]; !ok || c.data[0] != "foo" {
+		t.Fatalf("Commit failed")
+	}
+
+	clus.confChangeC[0] <- raftpb.ConfChange{
+		Type:   raftpb.ConfChangeAddNode,
+		NodeID: 4,
+	}
+
+	go func() {
+		proposeC <- "bar"
+	}()
+
+	if c, ok := <-clus.commitC[0]; !ok || c.data[0] != "bar" {
+		t.Fatalf("Commit failed")
+	}
+}
+
+func TestHTTPAddLearner(t *testing.T) {
+	confChangeC := make(chan raftpb.ConfChangeI, 1)
+	srv := httptest.NewServer(&httpKVAPI{confChangeC: confChangeC})
+	defer srv.Close()
+
+	tests := []struct {
+		path  string
+		wtype raftpb.ConfChangeType
+	}{
+		{"/4?learner", raftpb.ConfChangeAddLearnerNode},
+		{"/4", raftpb.ConfChangeAddNode},
+	}
+	for i, tt := range tests {
+		resp, err := srv.Client().Post(srv.URL+tt.path, "text/plain", bytes.NewBufferString("http://127.0.0.1:10004"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("#%d: status = %d, want %d", i, resp.StatusCode, http.StatusNoContent)
+		}
+		if cc, _ := (<-confChangeC).AsV1(); cc.Type != tt.wtype || cc.NodeID != 4 {
+			t.Errorf("#%d: conf change = %+v, want %v of 4", i, cc, tt.wtype)
+		}
+	}
+}
+
+// TestReadOnlyReplica tests that a read-only replica added as a learner
+// tails the log of the cluster, but refuses to propose.
+func TestReadOnlyReplica(t *testing.T) {
+	clus := newCluster(3)
+	defer clus.closeNoErrors(t)
+
+	os.RemoveAll("raftexample-4")
+	os.RemoveAll("raftexample-4-snap")
+	defer func() {
+		os.RemoveAll("raftexample-4")
+		os.RemoveAll("raftexample-4-snap")
+	}()
+
+	newNodeURL := "http://127.0.0.1:10004"
+	clus.confChangeC[0] <- raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddLearnerNode,
+		NodeID:  4,
+		Context: []byte(newNodeURL),
+	}
+
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	cfg := defaultRaftConfig()
+	cfg.readOnly = true
+	commitC, _, _ := newRaftNode(4, append(clus.peers, newNodeURL), true, cfg, nil, raftChannels{proposeC: proposeC, confChangeC: confChangeC})
+
+	for _, c := range []<-chan *commit{clus.commitC[1], clus.commitC[2]} {
+		go func(c <-chan *commit) {
+			for range c { //revive:disable-line:empty-block
+			}
+		}(c)
+	}
+
+	proposeC <- "bar"
+	clus.proposeC[0] <- "foo"
+	for i, c := range []<-chan *commit{clus.commitC[0], commitC} {
+		if c, ok := <-c; !ok || c.data[0] != "foo" {
+			t.Fatalf("#%d: commit = %+v, want foo", i, c)
+		}
+	}
+}
+
+func TestHTTPReadOnly(t *testing.T) {
+	srv := httptest.NewServer(&httpKVAPI{
+		store:    &kvstore{kvStore: map[string]string{"/foo": "bar"}},
+		readOnly: true,
+	})
+	defer srv.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		wcode  int
+	}{
+		{http.MethodGet, "/foo", http.StatusOK},
+		{http.MethodPut, "/foo", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/4", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/4", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/conf-change", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/transfer-leadership?target=2", http.StatusMethodNotAllowed},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, bytes.NewBufferString("baz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wcode {
+			t.Errorf("#%d: %s %s status = %d, want %d", i, tt.method, tt.path, resp.StatusCode, tt.wcode)
+		}
+	}
+	if v, _ := srv.Config.Handler.(*httpKVAPI).store.Lookup("/foo"); v != "bar" {
+		t.Errorf("value = %q, want bar", v)
+	}
+}
+
+// confState returns the conf state applied by node i.
+func (clus *cluster) confState(i int) raftpb.ConfState {
+	respC := make(chan raftpb.ConfState, 1)
+	clus.confStateC[i] <- respC
+	return <-respC
+}
+
+// waitConfState waits until the conf state applied by node i satisfies cond.
+func (clus *cluster) waitConfState(t *testing.T, i int, cond func(raftpb.ConfState) bool) raftpb.ConfState {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		cs := clus.confState(i)
+		if cond(cs) {
+			return cs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for conf state, have %+v", cs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func inJoint(cs raftpb.ConfState) bool { return len(cs.VotersOutgoing) > 0 }
+
+// TestJointConsensusRestart tests that a node restarting in a joint
+// configuration recovers it from its WAL, and can leave it afterwards.
+func TestJointConsensusRestart(t *testing.T) {
+	clus := newCluster(1)
+	defer clus.closeNoErrors(t)
+	go func(commitC <-chan *commit) {
+		for range commitC { //revive:disable-line:empty-block
+		}
+	}(clus.commitC[0])
+
+	clus.confChangeC[0] <- raftpb.ConfChangeV2{
+		Transition: raftpb.ConfChangeTransitionJointExplicit,
+		Changes:    []raftpb.ConfChangeSingle{{Type: raftpb.ConfChangeAddLearnerNode, NodeID: 2}},
+		Context:    []byte(`{"2":"http://127.0.0.1:10001"}`),
+	}
+	cs := clus.waitConfState(t, 0, inJoint)
+	if !reflect.DeepEqual(cs.Learners, []uint64{2}) {
+		t.Fatalf("learners = %v, want [2]", cs.Learners)
+	}
+
+	// crash the node in the joint configuration, keeping its WAL.
+	close(clus.proposeC[0])
+	if err := <-clus.errorC[0]; err != nil {
+		t.Fatal(err)
+	}
+
+	clus.proposeC[0] = make(chan string, 1)
+	clus.confChangeC[0] = make(chan raftpb.ConfChangeI, 1)
+	clus.transferC[0] = make(chan leadershipTransfer)
+	clus.readIndexC[0] = make(chan chan<- error)
+	clus.confStateC[0] = make(chan chan<- raftpb.ConfState)
+	clus.statusC[0] = make(chan chan<- nodeStatus)
+	clus.membersC[0] = make(chan chan<- []member)
+	clus.drainC[0] = make(chan chan<- error)
+	fn, _ := getSnapshotFn()
+	clus.commitC[0], clus.errorC[0], _ = newRaftNode(1, clus.peers, false, defaultRaftConfig(), fn, clus.channels(0))
+	go func(commitC <-chan *commit) {
+		for range commitC { //revive:disable-line:empty-block
+		}
+	}(clus.commitC[0])
+
+	cs = clus.waitConfState(t, 0, inJoint)
+	if !reflect.DeepEqual(cs.VotersOutgoing, []uint64{1}) || !reflect.DeepEqual(cs.Learners, []uint64{2}) {
+		t.Fatalf("conf state after restart = %+v, want joint with learner 2", cs)
+	}
+
+	clus.confChangeC[0] <- raftpb.ConfChangeV2{}
+	cs = clus.waitConfState(t, 0, func(cs raftpb.ConfState) bool { return !inJoint(cs) })
+	if !reflect.DeepEqual(cs.Voters, []uint64{1}) || !reflect.DeepEqual(cs.Learners, []uint64{2}) {
+		t.Fatalf("conf state after leaving joint = %+v, want voter 1 and learner 2", cs)
+	}
+}
+
+func TestHTTPConfChange(t *testing.T) {
+	confChangeC := make(chan raftpb.ConfChangeI, 1)
+	srv := httptest.NewServer(&httpKVAPI{confChangeC: confChangeC})
+	defer srv.Close()
+
+	body := `{"changes":[{"type":"add","id":4,"url":"http://127.0.0.1:10004"},{"type":"remove","id":3}]}`
+	resp, err := srv.Client().Post(srv.URL+"/conf-change", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	cc := (<-confChangeC).AsV2()
+	wchanges := []raftpb.ConfChangeSingle{
+		{Type: raftpb.ConfChangeAddNode, NodeID: 4},
+		{Type: raftpb.ConfChangeRemoveNode, NodeID: 3},
+	}
+	if cc.Transition != raftpb.ConfChangeTransitionAuto || !reflect.DeepEqual(cc.Changes, wchanges) {
+		t.Errorf("conf change = %+v, want %v", cc, wchanges)
+	}
+	if string(cc.Context) != `{"4":"http://127.0.0.1:10004"}` {
+		t.Errorf("context = %s, want the URL of 4", cc.Context)
+	}
+
+	for i, body := range []string{`{"changes":[{"type":"promote","id":4}]}`, `{"changes":[{"type":"add"}]}`, `[`} {
+		resp, err := srv.Client().Post(srv.URL+"/conf-change", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("#%d: status = %d, want %d", i, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestRaftStatus tests that the leader reports its status with the progress
+// of every peer.
+func TestRaftStatus(t *testing.T) {
+	clus := newCluster(3)
+	defer clus.closeNoErrors(t)
+
+	var lead uint64
+	for lead == 0 {
+		time.Sleep(100 * time.Millisecond)
+		lead = clus.leadership(0, 0).Lead
+	}
+	respC := make(chan nodeStatus, 1)
+	clus.statusC[lead-1] <- respC
+	st := <-respC
+	if st.ID != lead || st.Lead != lead || st.State != "StateLeader" || st.Term == 0 || st.Commit == 0 {
+		t.Errorf("status = %+v, want the status of leader %d", st, lead)
+	}
+	if len(st.Progress) != 3 {
+		t.Errorf("progress = %+v, want the progress of 3 peers", st.Progress)
+	}
+}
+
+func TestHTTPRaftStatus(t *testing.T) {
+	statusC := make(chan chan<- nodeStatus)
+	srv := httptest.NewServer(&httpKVAPI{statusC: statusC})
+	defer srv.Close()
+
+	go func() {
+		for respC := range statusC {
+			respC <- nodeStatus{ID: 1, Term: 2, Lead: 1, State: "StateLeader", Commit: 5, Applied: 4, Snapshot: snapshotStatus{Index: 3, Term: 1}}
+		}
+	}()
+	defer close(statusC)
+
+	resp, err := srv.Client().Get(srv.URL + "/raft/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wbody := `{"id":1,"term":2,"leader":1,"state":"StateLeader","commit":5,"applied":4,"snapshot":{"index":3,"term":1,"confState":{"auto_leave":false}}}`
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != wbody {
+		t.Errorf("GET /raft/status = %d %s, want %d %s", resp.StatusCode, body, http.StatusOK, wbody)
+	}
+
+	resp, err = srv.Client().Post(srv.URL+"/raft/status", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /raft/status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+// leadership requests a leadership transfer to target through node i, or only
+// reports the leadership if target is zero.
+func (clus *cluster) leadership(i int, target uint64) leadership {
+	statusC := make(chan leadership, 1)
+	clus.transferC[i] <- leadershipTransfer{target: target, statusC: statusC}
+	return <-statusC
+}
+
+// TestTransferLeadership tests that a transfer requested through a follower
+// moves the leadership to the target.
+func TestTransferLeadership(t *testing.T) {
+	clus := newCluster(3)
+	defer clus.closeNoErrors(t)
+
+	var lead uint64
+	for lead == 0 {
+		time.Sleep(100 * time.Millisecond)
+		lead = clus.leadership(0, 0).Lead
+	}
+	// transfer to another node, through the third one
+	target := lead%3 + 1
+	clus.leadership(int(6-lead-target)-1, target)
+	for i := 0; clus.leadership(int(target)-1, 0).Lead != target; i++ {
+		if i == 100 {
+			t.Fatalf("leadership not transferred from %d to %d", lead, target)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestHTTPTransferLeadership(t *testing.T) {
+	transferC := make(chan leadershipTransfer)
+	srv := httptest.NewServer(&httpKVAPI{transferC: transferC})
+	defer srv.Close()
+
+	var transferred uint64
+	go func() {
+		for lt := range transferC {
+			if lt.target != 0 {
+				transferred = lt.target
+			}
+			lt.statusC <- leadership{Lead: 1, Transferee: transferred}
+		}
+	}()
+	defer close(transferC)
+
+	tests := []struct {
+		method string
+		path   string
+		wcode  int
+		wbody  string
+	}{
+		{http.MethodGet, "/transfer-leadership", http.StatusOK, `{"lead":1,"transferee":0}`},
+		{http.MethodPost, "/transfer-leadership?target=2", http.StatusAccepted, `{"lead":1,"transferee":2}`},
+		{http.MethodPost, "/transfer-leadership", http.StatusBadRequest, ""},
+		{http.MethodPut, "/transfer-leadership", http.StatusMethodNotAllowed, ""},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.wcode {
+			t.Errorf("#%d: status = %d, want %d", i, resp.StatusCode, tt.wcode)
+		}
+		if tt.wbody != "" && strings.TrimSpace(string(body)) != tt.wbody {
+			t.Errorf("#%d: body = %s, want %s", i, body, tt.wbody)
+		}
+	}
+}
+
+// TestWitness tests that a witness keeps no data and refuses the leadership,
+// while the other nodes commit.
+func TestWitness(t *testing.T) {
+	clus := newCluster(3, 3)
+	defer clus.closeNoErrors(t)
+
+	clus.proposeC[0] <- "foo"
+	for i := 0; i < 2; i++ {
+		c, ok := <-clus.commitC[i]
+		if !ok || c.data[0] != "foo" {
+			t.Fatalf("#%d: Commit failed", i)
+		}
+		close(c.applyDoneC)
+	}
 
+	clus.leadership(0, 3)
 	select {
-	case <-clus.snapshotTriggeredC[0]:
-		t.Fatalf("snapshot triggered before applying done")
-	default:
+	case c := <-clus.commitC[2]:
+		t.Fatalf("witness published %v", c)
+	case <-time.After(2 * time.Second):
+	}
+	if lead := clus.leadership(2, 0).Lead; lead == 0 || lead == 3 {
+		t.Fatalf("lead = %d, want a non-witness", lead)
+	}
+}
+
+// TestPeerTLS tests that the peers replicate the log over TLS.
+func TestPeerTLS(t *testing.T) {
+	info, err := transport.SelfCert(zaptest.NewLogger(t), t.TempDir(), []string{"127.0.0.1"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultRaftConfig()
+	cfg.peerTLSInfo = info
+
+	clus := newClusterConfig(cfg, 3)
+	defer clus.closeNoErrors(t)
+
+	clus.proposeC[0] <- "foo"
+	for i := range clus.peers {
+		c, ok := <-clus.commitC[i]
+		if !ok || c.data[0] != "foo" {
+			t.Fatalf("#%d: Commit failed", i)
+		}
+		close(c.applyDoneC)
+	}
+}
+
+// TestDrain tests that a drain waits until the store has applied the
+// committed entries.
+func TestDrain(t *testing.T) {
+	clus := newCluster(1)
+	defer clus.closeNoErrors(t)
+
+	clus.proposeC[0] <- "foo"
+	c := <-clus.commitC[0]
+	if c.data[0] != "foo" {
+		t.Fatalf("committed %q, want foo", c.data)
+	}
+
+	errC := make(chan error, 1)
+	clus.drainC[0] <- errC
+	select {
+	case err := <-errC:
+		t.Fatalf("drained (%v) before the commit was applied", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(c.applyDoneC)
+	select {
+	case err := <-errC:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not complete once the commit was applied")
+	}
+}
+
+func TestDataDirs(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultRaftConfig()
+	cfg.walDir, cfg.snapDir = filepath.Join(dir, "wal", "1"), filepath.Join(dir, "snap")
+
+	clus := newClusterConfig(cfg, 1)
+	clus.proposeC[0] <- "foo"
+	c := <-clus.commitC[0]
+	close(c.applyDoneC)
+	clus.closeNoErrors(t)
+
+	if !wal.Exist(cfg.walDir) {
+		t.Errorf("no WAL in %s", cfg.walDir)
+	}
+	if !fileutil.Exist(cfg.snapDir) {
+		t.Errorf("snapshot directory %s not created", cfg.snapDir)
+	}
+	if fileutil.Exist("raftexample-1") {
+		t.Error("WAL written to the default directory")
+	}
+}
+
+func TestProposeStatus(t *testing.T) {
+	tests := []struct {
+		err     error
+		wstatus int
+	}{
+		{fmt.Errorf("%w: 2048 bytes", errProposalTooLarge), http.StatusRequestEntityTooLarge},
+		{errShuttingDown, http.StatusServiceUnavailable},
+		{errors.New("unexpected"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if status := proposeStatus(tt.err); status != tt.wstatus {
+			t.Errorf("proposeStatus(%v) = %d, want %d", tt.err, status, tt.wstatus)
+		}
+	}
+}
+
+func TestHTTPShutdown(t *testing.T) {
+	shutdownC := make(chan struct{}, 1)
+	srv := httptest.NewServer(&httpKVAPI{shutdownC: shutdownC, readOnly: true})
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := srv.Client().Post(srv.URL+"/shutdown", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Errorf("#%d: status = %d, want %d", i, resp.StatusCode, http.StatusAccepted)
+		}
+	}
+	select {
+	case <-shutdownC:
+	default:
+		t.Error("shutdown not requested")
+	}
+}
+
+// TestBatchProposals tests that the proposals sent within the batch interval
+// are committed together, in order.
+func TestBatchProposals(t *testing.T) {
+	cfg := defaultRaftConfig()
+	cfg.batchInterval = 500 * time.Millisecond
+
+	clus := newClusterConfig(cfg, 1)
+	defer clus.closeNoErrors(t)
+
+	want := []string{"foo", "bar", "baz"}
+	go func() {
+		for _, prop := range want {
+			clus.proposeC[0] <- prop
+		}
+	}()
+
+	c := <-clus.commitC[0]
+	if !reflect.DeepEqual(c.data, want) {
+		t.Fatalf("committed %q, want %q", c.data, want)
+	}
+	close(c.applyDoneC)
+}
+
+// TestReadIndex tests that a linearizable read on a follower waits until the
+// follower has applied the writes committed before it.
+func TestReadIndex(t *testing.T) {
+	clus := newCluster(3)
+	defer clus.closeNoErrors(t)
+
+	go func() {
+		for c := range clus.commitC[2] {
+			close(c.applyDoneC)
+		}
+	}()
+
+	clus.proposeC[0] <- "foo"
+	close((<-clus.commitC[0]).applyDoneC)
+	c := <-clus.commitC[1]
+
+	errC := make(chan error, 1)
+	clus.readIndexC[1] <- errC
+	select {
+	case err := <-errC:
+		t.Fatalf("read served before applying done (%v)", err)
+	case <-time.After(time.Second):
+	}
+	close(c.applyDoneC)
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPLinearizableGet(t *testing.T) {
+	readIndexC := make(chan chan<- error)
+	srv := httptest.NewServer(&httpKVAPI{
+		store:      &kvstore{kvStore: map[string]string{"/foo": "bar"}},
+		readIndexC: readIndexC,
+	})
+	defer srv.Close()
+
+	tests := []struct {
+		err   error
+		wcode int
+	}{
+		{nil, http.StatusOK},
+		{errReadIndexTimeout, http.StatusServiceUnavailable},
+	}
+	for i, tt := range tests {
+		go func() {
+			errC := <-readIndexC
+			errC <- tt.err
+		}()
+		resp, err := srv.Client().Get(srv.URL + "/foo?linearizable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.wcode {
+			t.Errorf("#%d: status = %d, want %d", i, resp.StatusCode, tt.wcode)
+		}
+		if tt.err == nil && string(body) != "bar" {
+			t.Errorf("#%d: body = %s, want bar", i, body)
+		}
+	}
+}
+
+func TestHTTPCompareAndSwap(t *testing.T) {
+	store := newCommittingKVStore(t, defaultKVConfig())
+	srv := httptest.NewServer(&httpKVAPI{store: store})
+	defer srv.Close()
+
+	put := func(url, body string) int {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	tests := []struct {
+		url, body string
+		wcode     int
+	}{
+		{"/foo", "1", http.StatusNoContent},
+		{"/foo?prev=1", "2", http.StatusNoContent},
+		{"/foo?prev=1", "3", http.StatusPreconditionFailed},
+		{"/bar?prev=", "1", http.StatusPreconditionFailed},
+	}
+	for i, tt := range tests {
+		if code := put(tt.url, tt.body); code != tt.wcode {
+			t.Errorf("#%d: PUT %s status = %d, want %d", i, tt.url, code, tt.wcode)
+		}
+	}
+	if v, _ := store.Lookup("/foo"); v != "2" {
+		t.Errorf("/foo = %q, want 2", v)
+	}
+}
+
+func TestHTTPTxn(t *testing.T) {
+	store := newCommittingKVStore(t, defaultKVConfig())
+	srv := httptest.NewServer(&httpKVAPI{store: store})
+	defer srv.Close()
+
+	tests := []struct {
+		method, body string
+		wcode        int
+		wbody        string
+	}{
+		{http.MethodPost, `[{"type":"put","key":"/foo","value":"1"},{"type":"get","key":"/foo"},{"type":"get","key":"/bar"}]`,
+			http.StatusOK, `[{"key":"/foo","value":"1","found":true},{"key":"/bar","value":"","found":false}]`},
+		{http.MethodPost, `[{"type":"delete","key":"/foo"}]`, http.StatusOK, `[]`},
+		{http.MethodPost, `[{"type":"swap","key":"/foo"}]`, http.StatusBadRequest, ""},
+		{http.MethodPost, `{`, http.StatusBadRequest, ""},
+		{http.MethodPut, `[]`, http.StatusMethodNotAllowed, ""},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+"/txn", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.wcode {
+			t.Errorf("#%d: status = %d, want %d", i, resp.StatusCode, tt.wcode)
+		}
+		if tt.wbody != "" && strings.TrimSpace(string(body)) != tt.wbody {
+			t.Errorf("#%d: body = %s, want %s", i, body, tt.wbody)
+		}
+	}
+	if _, ok := store.Lookup("/foo"); ok {
+		t.Error("/foo not deleted")
+	}
+}
+
+func TestHTTPClientRequests(t *testing.T) {
+	store := newCommittingKVStore(t, defaultKVConfig())
+	srv := httptest.NewServer(&httpKVAPI{store: store})
+	defer srv.Close()
+
+	tests := []struct {
+		method, url, body string
+		wcode             int
+	}{
+		{http.MethodPut, "/foo?client=1&seq=1", "1", http.StatusNoContent},
+		// a retry is accepted, but not applied
+		{http.MethodPut, "/foo?client=1&seq=1", "2", http.StatusNoContent},
+		{http.MethodDelete, "/foo?key&client=1&seq=1", "", http.StatusNoContent},
+		{http.MethodPut, "/foo?client=1", "3", http.StatusBadRequest},
+		{http.MethodPut, "/foo?client=0&seq=2", "3", http.StatusBadRequest},
+		{http.MethodDelete, "/foo?key&client=x&seq=2", "", http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wcode {
+			t.Errorf("#%d: %s %s status = %d, want %d", i, tt.method, tt.url, resp.StatusCode, tt.wcode)
+		}
+	}
+	// the writes above are applied once a later one is proposed
+	if err := store.Propose("/sync", ""); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.Lookup("/foo"); v != "1" {
+		t.Errorf("/foo = %q, want 1", v)
+	}
+}
+
+func TestHTTPForwardWrites(t *testing.T) {
+	leaderStore := newCommittingKVStore(t, defaultKVConfig())
+	leader := httptest.NewServer(&httpKVAPI{store: leaderStore})
+	defer leader.Close()
+
+	proposeC := make(chan string, 1)
+	statusC := make(chan chan<- nodeStatus)
+	go func() {
+		for respC := range statusC {
+			respC <- nodeStatus{ID: 2, Lead: 1}
+		}
+	}()
+	defer close(statusC)
+	h := &httpKVAPI{
+		store:         &kvstore{proposeC: proposeC, maxProposalBytes: defaultKVConfig().maxProposalBytes},
+		statusC:       statusC,
+		clientURLs:    []string{leader.URL, "http://127.0.0.1:0"},
+		forwardWrites: true,
+	}
+	follower := httptest.NewServer(h)
+	defer follower.Close()
+
+	do := func(method, url, body string) *http.Response {
+		req, err := http.NewRequest(method, follower.URL+url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := follower.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	for _, tt := range []struct{ method, url, body string }{
+		{http.MethodPut, "/foo", "1"},
+		{http.MethodPut, "/bar", "2"},
+		{http.MethodDelete, "/bar?key", ""},
+	} {
+		if resp := do(tt.method, tt.url, tt.body); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%s %s status = %d, want %d", tt.method, tt.url, resp.StatusCode, http.StatusNoContent)
+		}
+	}
+	// a compare-and-swap waits for the writes forwarded before it
+	if resp := do(http.MethodPut, "/foo?prev=1", "3"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("compare-and-swap status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if _, ok := leaderStore.Lookup("/bar"); ok {
+		t.Error("forwarded deletion not applied")
+	}
+	select {
+	case <-proposeC:
+		t.Fatal("follower proposed a forwarded write")
+	default:
+	}
+
+	h.forwardWrites = false
+	resp := do(http.MethodPut, "/foo", "4")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if got := resp.Header.Get(leaderHeader); got != leader.URL {
+		t.Errorf("%s = %q, want %q", leaderHeader, got, leader.URL)
+	}
+	<-proposeC
+}
+
+func TestHTTPDeleteAndList(t *testing.T) {
+	proposeC := make(chan string, 1)
+	confChangeC := make(chan raftpb.ConfChangeI, 1)
+	srv := httptest.NewServer(&httpKVAPI{
+		store: &kvstore{
+			proposeC:         proposeC,
+			kvStore:          map[string]string{"/foo": "1", "/fu": "2", "/bar": "3"},
+			maxProposalBytes: defaultKVConfig().maxProposalBytes,
+		},
+		confChangeC: confChangeC,
+	})
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodDelete, srv.URL+"/foo?key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	var u kv
+	if err := gob.NewDecoder(strings.NewReader(<-proposeC)).Decode(&u); err != nil {
+		t.Fatal(err)
+	}
+	if want := (kv{Key: "/foo", Delete: true}); !reflect.DeepEqual(u, want) {
+		t.Errorf("proposed %+v, want %+v", u, want)
+	}
+	select {
+	case cc := <-confChangeC:
+		t.Errorf("key deletion proposed conf change %+v", cc)
+	default:
+	}
+
+	resp, err = srv.Client().Get(srv.URL + "/f?prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var kvs map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&kvs); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"/foo": "1", "/fu": "2"}; !reflect.DeepEqual(kvs, want) {
+		t.Errorf("listed %v, want %v", kvs, want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cfg := defaultRaftConfig()
+	cfg.snapshotCount = 4
+	cfg.snapshotCatchUpEntries = 4
+
+	clus := newClusterConfig(cfg, 3)
+	defer clus.closeNoErrors(t)
+
+	go func() {
+		clus.proposeC[0] <- "foo"
+	}()
+
+	c := <-clus.commitC[0]
+
+	select {
+	case <-clus.snapshotTriggeredC[0]:
+		t.Fatalf("snapshot triggered before applying done")
+	default:
+	}
+	close(c.applyDoneC)
+	<-clus.snapshotTriggeredC[0]
+}
+
+// TestAsyncStorageWrites tests that a cluster with async storage writes
+// commits on every node, and snapshots once all committed entries are applied.
+func TestAsyncStorageWrites(t *testing.T) {
+	cfg := defaultRaftConfig()
+	cfg.asyncStorageWrites = true
+	cfg.snapshotCount = 4
+	cfg.snapshotCatchUpEntries = 4
+
+	clus := newClusterConfig(cfg, 3)
+	defer clus.closeNoErrors(t)
+
+	go func() {
+		clus.proposeC[0] <- "foo"
+	}()
+
+	for i := range clus.peers {
+		c, ok := <-clus.commitC[i]
+		if !ok || c.data[0] != "foo" {
+			t.Fatalf("#%d: Commit failed", i)
+		}
+		select {
+		case <-clus.snapshotTriggeredC[i]:
+			t.Fatalf("#%d: snapshot triggered before applying done", i)
+		default:
+		}
+		close(c.applyDoneC)
+		<-clus.snapshotTriggeredC[i]
+	}
+}
+
+// BenchmarkProposeTransport measures the proposal throughput of a cluster
+// with the default transport settings against smaller and larger ones.
+func BenchmarkProposeTransport(b *testing.B) {
+	tests := []struct {
+		name                                    string
+		inflight, conns, pipelineBuf, streamBuf int
+	}{
+		{"default", 256, 0, 0, 0},
+		{"small", 16, 1, 8, 64},
+		{"large", 1024, 8, 256, 16384},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			cfg := defaultRaftConfig()
+			cfg.snapshotCount = 1 << 62
+			cfg.maxInflightMsgs = tt.inflight
+			cfg.pipelineConns = tt.conns
+			cfg.pipelineBufSize = tt.pipelineBuf
+			cfg.streamBufSize = tt.streamBuf
+
+			clus := newClusterConfig(cfg, 3)
+			defer clus.Close()
+			for _, commitC := range clus.commitC[1:] {
+				go func(commitC <-chan *commit) {
+					for c := range commitC {
+						if c != nil {
+							close(c.applyDoneC)
+						}
+					}
+				}(commitC)
+			}
+
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					clus.proposeC[0] <- "foo"
+				}
+			}()
+			for n := 0; n < b.N; {
+				c := <-clus.commitC[0]
+				if c == nil {
+					continue
+				}
+				n += len(c.data)
+				close(c.applyDoneC)
+			}
+		})
+	}
+}
+
+// TestFakeClock tests that a node driven by a fake clock only elects itself
+// and commits once the test advances the clock past its election timeout.
+func TestFakeClock(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	cfg := defaultRaftConfig()
+	cfg.clock = clock
+
+	clus := newClusterConfig(cfg, 1)
+	defer clus.closeNoErrors(t)
+
+	go func() {
+		clus.proposeC[0] <- "foo"
+	}()
+
+	select {
+	case <-clus.commitC[0]:
+		t.Fatalf("committed without ticking")
+	case <-time.After(time.Duration(cfg.electionTickMax) * cfg.tickInterval):
+	}
+
+	for i := 0; ; i++ {
+		if i > 2*cfg.electionTickMax {
+			t.Fatalf("no commit after %d ticks", i)
+		}
+		clock.Advance(cfg.tickInterval)
+		select {
+		case c, ok := <-clus.commitC[0]:
+			if !ok || c.data[0] != "foo" {
+				t.Fatalf("Commit failed")
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// TestSlowStore tests that the leader keeps its leadership while its store
+// stalls, since the apply does not hold up the heartbeats.
+func TestSlowStore(t *testing.T) {
+	clus := newCluster(3)
+	defer clus.closeNoErrors(t)
+
+	var lead uint64
+	for lead == 0 {
+		time.Sleep(100 * time.Millisecond)
+		lead = clus.leadership(0, 0).Lead
+	}
+	for i := range clus.peers {
+		if i+1 != int(lead) {
+			go func(commitC <-chan *commit) {
+				for range commitC { //revive:disable-line:empty-block
+				}
+			}(clus.commitC[i])
+		}
+	}
+
+	// the leader's store does not read the commits for several election
+	// timeouts.
+	go func() {
+		for _, v := range []string{"foo", "bar", "baz"} {
+			clus.proposeC[lead-1] <- v
+		}
+	}()
+	cfg := defaultRaftConfig()
+	time.Sleep(3 * time.Duration(cfg.electionTickMax) * cfg.tickInterval)
+	for i := range clus.peers {
+		if l := clus.leadership(i, 0).Lead; l != lead {
+			t.Fatalf("#%d: leader = %d, want %d", i, l, lead)
+		}
+	}
+
+	var data []string
+	for len(data) < 3 {
+		c, ok := <-clus.commitC[lead-1]
+		if !ok {
+			t.Fatalf("Commit failed")
+		}
+		if c != nil {
+			data = append(data, c.data...)
+		}
+	}
+	if !reflect.DeepEqual(data, []string{"foo", "bar", "baz"}) {
+		t.Errorf("commits = %v, want foo, bar and baz", data)
+	}
+}
+
+func TestGRPCKVAPI(t *testing.T) {
+	store := newCommittingKVStore(t, defaultKVConfig())
+
+	readIndexC := make(chan chan<- error)
+	go func() {
+		for errC := range readIndexC {
+			errC <- nil
+		}
+	}()
+	defer close(readIndexC)
+
+	newClient := func(readOnly bool) kvpb.KVClient {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := grpc.NewServer()
+		kvpb.RegisterKVServer(srv, &grpcKVAPI{store: store, readIndexC: readIndexC, readOnly: readOnly})
+		go srv.Serve(ln)
+		t.Cleanup(srv.Stop)
+		conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return kvpb.NewKVClient(conn)
+	}
+	cli := newClient(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	watch, err := cli.Watch(ctx, &kvpb.WatchRequest{Key: "/f", Prefix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the watch is registered once the stream is established on the
+	// server, wait for it before writing
+	for {
+		store.watchMu.Lock()
+		n := len(store.watchers)
+		store.watchMu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, kv := range []*kvpb.PutRequest{{Key: "/foo", Value: "1"}, {Key: "/bar", Value: "2"}, {Key: "/fu", Value: "3"}} {
+		if _, err := cli.Put(ctx, kv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cli.Delete(ctx, &kvpb.DeleteRequest{Key: "/foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	for len(events) < 3 {
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ev := range resp.Events {
+			events = append(events, fmt.Sprintf("%s %s=%s", ev.Type, ev.Kv.Key, ev.Kv.Value))
+		}
+	}
+	if want := []string{"PUT /foo=1", "PUT /fu=3", "DELETE /foo="}; !reflect.DeepEqual(events, want) {
+		t.Errorf("watched %q, want %q", events, want)
+	}
+
+	resp, err := cli.Get(ctx, &kvpb.GetRequest{Key: "/fu", Linearizable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 || resp.Kvs[0].Value != "3" {
+		t.Errorf("get /fu = %v, want 3", resp.Kvs)
+	}
+	if _, err := cli.Get(ctx, &kvpb.GetRequest{Key: "/foo"}); status.Code(err) != codes.NotFound {
+		t.Errorf("get deleted key error = %v, want %v", err, codes.NotFound)
+	}
+	resp, err = cli.Get(ctx, &kvpb.GetRequest{Key: "/", Prefix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, kv := range resp.Kvs {
+		keys = append(keys, kv.Key)
+	}
+	if want := []string{"/bar", "/fu"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("get prefix / = %q, want %q", keys, want)
+	}
+
+	roCli := newClient(true)
+	if _, err := roCli.Put(ctx, &kvpb.PutRequest{Key: "/foo", Value: "1"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("read-only put error = %v, want %v", err, codes.FailedPrecondition)
+	}
+	if _, err := roCli.Get(ctx, &kvpb.GetRequest{Key: "/fu"}); err != nil {
+		t.Errorf("read-only get error = %v", err)
 	}
-	close(c.applyDoneC)
-	<-clus.snapshotTriggeredC[0]
 }
@@ -0,0 +1,105 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftsim
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// checker records what the nodes observed, and reports the first violation
+// of the raft invariants.
+type checker struct {
+	// leaders is the leader elected in each term.
+	leaders map[uint64]uint64
+	// committed is the entry committed at each index, and the node that
+	// committed it first.
+	committed map[uint64]committedEntry
+}
+
+type committedEntry struct {
+	id    uint64
+	entry raftpb.Entry
+}
+
+func newChecker() *checker {
+	return &checker{
+		leaders:   make(map[uint64]uint64),
+		committed: make(map[uint64]committedEntry),
+	}
+}
+
+// leader checks election safety when id is leader in term.
+func (c *checker) leader(id, term uint64) error {
+	if lead, ok := c.leaders[term]; ok && lead != id {
+		return fmt.Errorf("election safety: nodes %d and %d are both leaders in term %d", lead, id, term)
+	}
+	c.leaders[term] = id
+	return nil
+}
+
+// commit checks state machine safety when id commits e.
+func (c *checker) commit(id uint64, e raftpb.Entry) error {
+	if ce, ok := c.committed[e.Index]; ok {
+		if !sameEntry(ce.entry, e) {
+			return fmt.Errorf("state machine safety: node %d committed %s at index %d, node %d committed %s",
+				ce.id, describe(ce.entry), e.Index, id, describe(e))
+		}
+		return nil
+	}
+	c.committed[e.Index] = committedEntry{id: id, entry: e}
+	return nil
+}
+
+// logMatching checks that the logs a and b, of nodes ida and idb, are
+// identical up to the last index at which their entries have the same term.
+func logMatching(ida uint64, a []raftpb.Entry, idb uint64, b []raftpb.Entry) error {
+	a, b = overlap(a, b), overlap(b, a)
+	match := -1
+	for i := range a {
+		if a[i].Term == b[i].Term {
+			match = i
+		}
+	}
+	for i := 0; i <= match; i++ {
+		if !sameEntry(a[i], b[i]) {
+			return fmt.Errorf("log matching: nodes %d and %d both hold an entry at index %d, term %d, but differ at index %d: %s != %s",
+				ida, idb, a[match].Index, a[match].Term, a[i].Index, describe(a[i]), describe(b[i]))
+		}
+	}
+	return nil
+}
+
+// overlap returns the entries of a at the indexes b holds too.
+func overlap(a, b []raftpb.Entry) []raftpb.Entry {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+	lo, hi := max(a[0].Index, b[0].Index), min(a[len(a)-1].Index, b[len(b)-1].Index)
+	if lo > hi {
+		return nil
+	}
+	return a[lo-a[0].Index : hi-a[0].Index+1]
+}
+
+func sameEntry(a, b raftpb.Entry) bool {
+	return a.Index == b.Index && a.Term == b.Term && a.Type == b.Type && bytes.Equal(a.Data, b.Data)
+}
+
+func describe(e raftpb.Entry) string {
+	return fmt.Sprintf("%d/%d %s %q", e.Term, e.Index, e.Type, e.Data)
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package raftsim runs a cluster of raft nodes in a deterministic simulation,
+to stress the raft library and its storage under faults in local runs.
+
+The nodes are raft.RawNodes on a MemoryStorage, driven from a single
+goroutine by a virtual clock: Sim.Tick advances every node by one raft tick
+and then delivers the messages due, until the cluster is quiescent. The
+messages go through a virtual Network, on which links can drop messages,
+delay them by a random number of ticks, which also reorders them, or be
+cut by a partition. Nodes can be crashed and restarted from their storage.
+
+All the randomness, including the election timeouts that raft would
+otherwise draw itself, comes from the seed of the simulation, so a failing
+run is reproduced by its seed.
+
+After every tick the simulation checks the safety invariants of raft:
+
+  - election safety: at most one leader is elected in a term;
+  - log matching: if two logs hold an entry with the same index and term,
+    they are identical up to that index;
+  - state machine safety: the nodes commit the same entry at an index.
+
+For example:
+
+	s := raftsim.New(raftsim.Config{Nodes: 3, Seed: 1})
+	s.Network().Delay(0, 0, 0, 3)
+	if err := s.Run(100); err != nil {
+		// an invariant was violated
+	}
+	s.Propose(s.Leader(), []byte("foo"))
+*/
+package raftsim
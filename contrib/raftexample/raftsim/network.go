@@ -0,0 +1,146 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftsim
+
+import (
+	"math/rand"
+	"sort"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// link is a directed link between two nodes, a zero ID stands for any node.
+type link struct {
+	from, to uint64
+}
+
+type delay struct {
+	min, max int
+}
+
+type inflight struct {
+	m   raftpb.Message
+	at  int64  // tick the message is delivered at
+	seq uint64 // order of the messages due at the same tick
+}
+
+// Network is the virtual network of a simulation. By default it delivers
+// every message within the tick it is sent in, in order.
+type Network struct {
+	rand *rand.Rand
+	now  int64
+	seq  uint64
+
+	pending []inflight
+
+	drop  map[link]float64
+	delay map[link]delay
+	// group is the side of the partition each node is on, nil if the
+	// network is not partitioned.
+	group map[uint64]int
+}
+
+func newNetwork(r *rand.Rand) *Network {
+	return &Network{
+		rand:  r,
+		drop:  make(map[link]float64),
+		delay: make(map[link]delay),
+	}
+}
+
+// Drop drops the given fraction of the messages sent from a node to another,
+// a zero ID stands for any node.
+func (n *Network) Drop(from, to uint64, rate float64) {
+	n.drop[link{from, to}] = rate
+}
+
+// Delay delays the messages sent from a node to another by a random number
+// of ticks in [min, max], which reorders the messages sent within max-min
+// ticks. A zero ID stands for any node.
+func (n *Network) Delay(from, to uint64, min, max int) {
+	n.delay[link{from, to}] = delay{min, max}
+}
+
+// Partition cuts the network between the given groups of nodes, the nodes
+// left out of every group are isolated.
+func (n *Network) Partition(groups ...[]uint64) {
+	n.group = make(map[uint64]int)
+	for i, g := range groups {
+		for _, id := range g {
+			n.group[id] = i + 1
+		}
+	}
+}
+
+// Heal removes the partition, the drops and the delays. The messages in
+// flight are still delivered.
+func (n *Network) Heal() {
+	n.group = nil
+	clear(n.drop)
+	clear(n.delay)
+}
+
+// connected reports whether the partition lets from reach to.
+func (n *Network) connected(from, to uint64) bool {
+	if n.group == nil {
+		return true
+	}
+	g := n.group[from]
+	return g != 0 && g == n.group[to]
+}
+
+// lookup returns the setting of the most specific link matching from and to.
+func lookup[T any](m map[link]T, from, to uint64) (T, bool) {
+	for _, l := range []link{{from, to}, {from, 0}, {0, to}, {0, 0}} {
+		if v, ok := m[l]; ok {
+			return v, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+func (n *Network) send(m raftpb.Message) {
+	if !n.connected(m.From, m.To) {
+		return
+	}
+	if rate, ok := lookup(n.drop, m.From, m.To); ok && n.rand.Float64() < rate {
+		return
+	}
+	at := n.now
+	if d, ok := lookup(n.delay, m.From, m.To); ok {
+		at += int64(d.min + n.rand.Intn(d.max-d.min+1))
+	}
+	n.seq++
+	n.pending = append(n.pending, inflight{m: m, at: at, seq: n.seq})
+}
+
+// due removes and returns the messages to deliver by now, in order.
+func (n *Network) due() []raftpb.Message {
+	sort.Slice(n.pending, func(i, j int) bool {
+		a, b := n.pending[i], n.pending[j]
+		return a.at < b.at || a.at == b.at && a.seq < b.seq
+	})
+	i := 0
+	for i < len(n.pending) && n.pending[i].at <= n.now {
+		i++
+	}
+	msgs := make([]raftpb.Message, i)
+	for j := range msgs {
+		msgs[j] = n.pending[j].m
+	}
+	n.pending = append(n.pending[:0], n.pending[i:]...)
+	return msgs
+}
@@ -0,0 +1,327 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftsim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+var errCrashed = errors.New("raftsim: node crashed")
+
+// Config configures a simulation.
+type Config struct {
+	// Nodes is the number of voters, with IDs 1 to Nodes.
+	Nodes int
+	// Seed seeds all the randomness of the simulation.
+	Seed int64
+	// ElectionTick and HeartbeatTick are the election timeout and the
+	// heartbeat interval, in ticks, 10 and 1 if zero. The election timeout
+	// of a node is drawn from [ElectionTick, 2*ElectionTick).
+	ElectionTick  int
+	HeartbeatTick int
+	PreVote       bool
+	// Logger logs the raft nodes, which are silent if nil.
+	Logger raft.Logger
+}
+
+// raftElectionTick keeps raft from campaigning on its own, as it draws its
+// election timeouts from a source the seed does not control. The simulation
+// campaigns the nodes instead.
+const raftElectionTick = 1 << 30
+
+// maxRounds bounds the rounds of deliveries within a tick, so that a cluster
+// that never quiesces is reported instead of hanging the simulation.
+const maxRounds = 10000
+
+// Sim is a deterministic simulation of a raft cluster.
+type Sim struct {
+	cfg   Config
+	rand  *rand.Rand
+	net   *Network
+	nodes []*node
+	check *checker
+}
+
+type node struct {
+	id      uint64
+	storage *raft.MemoryStorage // the persisted state, which survives a crash
+	rn      *raft.RawNode       // nil while crashed
+
+	applied      [][]byte // data of the normal entries applied
+	appliedIndex uint64
+
+	elapsed int // ticks since the node last heard from a leader
+	timeout int // election timeout, in ticks
+}
+
+// New returns a simulation of a new cluster.
+func New(cfg Config) *Sim {
+	if cfg.ElectionTick == 0 {
+		cfg.ElectionTick = 10
+	}
+	if cfg.HeartbeatTick == 0 {
+		cfg.HeartbeatTick = 1
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = &raft.DefaultLogger{Logger: log.New(io.Discard, "", 0)}
+	}
+	r := rand.New(rand.NewSource(cfg.Seed))
+	s := &Sim{
+		cfg:   cfg,
+		rand:  r,
+		net:   newNetwork(r),
+		check: newChecker(),
+	}
+
+	voters := make([]uint64, cfg.Nodes)
+	for i := range voters {
+		voters[i] = uint64(i + 1)
+	}
+	for _, id := range voters {
+		n := &node{id: id, storage: raft.NewMemoryStorage(), appliedIndex: 1}
+		// bootstrap the nodes with the configuration at index 1
+		n.storage.ApplySnapshot(raftpb.Snapshot{Metadata: raftpb.SnapshotMetadata{
+			Index:     1,
+			Term:      1,
+			ConfState: raftpb.ConfState{Voters: voters},
+		}})
+		s.start(n)
+		s.nodes = append(s.nodes, n)
+	}
+	return s
+}
+
+func (s *Sim) start(n *node) {
+	rn, err := raft.NewRawNode(&raft.Config{
+		ID:              n.id,
+		ElectionTick:    raftElectionTick,
+		HeartbeatTick:   s.cfg.HeartbeatTick,
+		Storage:         n.storage,
+		Applied:         n.appliedIndex,
+		MaxSizePerMsg:   1024 * 1024,
+		MaxInflightMsgs: 256,
+		PreVote:         s.cfg.PreVote,
+		Logger:          s.cfg.Logger,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("raftsim: failed to start node %d (%v)", n.id, err))
+	}
+	n.rn = rn
+	s.resetElection(n)
+}
+
+func (s *Sim) resetElection(n *node) {
+	n.elapsed = 0
+	n.timeout = s.cfg.ElectionTick + s.rand.Intn(s.cfg.ElectionTick)
+}
+
+// Network returns the virtual network of the simulation.
+func (s *Sim) Network() *Network { return s.net }
+
+// Now returns the number of ticks elapsed.
+func (s *Sim) Now() int64 { return s.net.now }
+
+// Run ticks n times, and returns the first invariant violated.
+func (s *Sim) Run(n int) error {
+	for i := 0; i < n; i++ {
+		if err := s.Tick(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tick advances the virtual clock by one tick: the running nodes tick, the
+// followers whose election timeout elapsed campaign, and the messages due
+// are delivered until the cluster is quiescent. It returns the first
+// invariant violated.
+func (s *Sim) Tick() error {
+	s.net.now++
+	for _, n := range s.nodes {
+		if n.rn == nil {
+			continue
+		}
+		n.rn.Tick()
+		if n.rn.BasicStatus().RaftState == raft.StateLeader {
+			n.elapsed = 0
+			continue
+		}
+		if n.elapsed++; n.elapsed >= n.timeout {
+			n.rn.Campaign()
+			s.resetElection(n)
+		}
+	}
+	return s.stabilize()
+}
+
+func (s *Sim) stabilize() error {
+	for round := 0; round < maxRounds; round++ {
+		busy := false
+		for _, n := range s.nodes {
+			if n.rn == nil || !n.rn.HasReady() {
+				continue
+			}
+			busy = true
+			if err := s.handleReady(n); err != nil {
+				return err
+			}
+		}
+		msgs := s.net.due()
+		for _, m := range msgs {
+			s.deliver(m)
+		}
+		if !busy && len(msgs) == 0 {
+			return s.checkLogs()
+		}
+	}
+	return fmt.Errorf("raftsim: cluster not quiescent after %d rounds at tick %d", maxRounds, s.net.now)
+}
+
+// handleReady persists, sends and applies a Ready of n, like an application
+// with synchronous storage writes would.
+func (s *Sim) handleReady(n *node) error {
+	rd := n.rn.Ready()
+	if st := n.rn.BasicStatus(); st.RaftState == raft.StateLeader {
+		if err := s.check.leader(n.id, st.Term); err != nil {
+			return err
+		}
+	}
+
+	if !raft.IsEmptySnap(rd.Snapshot) {
+		if err := n.storage.ApplySnapshot(rd.Snapshot); err != nil {
+			return err
+		}
+		n.appliedIndex = rd.Snapshot.Metadata.Index
+	}
+	if !raft.IsEmptyHardState(rd.HardState) {
+		if err := n.storage.SetHardState(rd.HardState); err != nil {
+			return err
+		}
+	}
+	if err := n.storage.Append(rd.Entries); err != nil {
+		return err
+	}
+	for _, m := range rd.Messages {
+		s.net.send(m)
+	}
+	for _, e := range rd.CommittedEntries {
+		if err := s.check.commit(n.id, e); err != nil {
+			return err
+		}
+		if e.Type == raftpb.EntryNormal && len(e.Data) > 0 {
+			n.applied = append(n.applied, e.Data)
+		}
+		n.appliedIndex = e.Index
+	}
+	n.rn.Advance(rd)
+	return nil
+}
+
+func (s *Sim) deliver(m raftpb.Message) {
+	n := s.nodes[m.To-1]
+	if n.rn == nil {
+		// a crashed node loses the messages sent to it
+		return
+	}
+	term := n.rn.BasicStatus().Term
+	switch m.Type {
+	case raftpb.MsgApp, raftpb.MsgHeartbeat, raftpb.MsgSnap:
+		if m.Term >= term {
+			n.elapsed = 0
+		}
+	}
+	n.rn.Step(m)
+	if n.rn.BasicStatus().Term > term {
+		// a node that moved to a new term waits for its leader again, with
+		// a new timeout like raft draws
+		s.resetElection(n)
+	}
+}
+
+// checkLogs checks the log matching of every pair of nodes.
+func (s *Sim) checkLogs() error {
+	logs := make([][]raftpb.Entry, len(s.nodes))
+	for i, n := range s.nodes {
+		first, _ := n.storage.FirstIndex()
+		last, _ := n.storage.LastIndex()
+		if last < first {
+			continue
+		}
+		ents, err := n.storage.Entries(first, last+1, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		logs[i] = ents
+	}
+	for i := range logs {
+		for j := i + 1; j < len(logs); j++ {
+			if err := logMatching(s.nodes[i].id, logs[i], s.nodes[j].id, logs[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Leader returns the running node that is leader in the highest term, or 0
+// if there is none.
+func (s *Sim) Leader() uint64 {
+	var lead, term uint64
+	for _, n := range s.nodes {
+		if n.rn == nil {
+			continue
+		}
+		if st := n.rn.BasicStatus(); st.RaftState == raft.StateLeader && st.Term > term {
+			lead, term = n.id, st.Term
+		}
+	}
+	return lead
+}
+
+// Propose proposes data on node id, which forwards it to its leader on the
+// next tick.
+func (s *Sim) Propose(id uint64, data []byte) error {
+	n := s.nodes[id-1]
+	if n.rn == nil {
+		return errCrashed
+	}
+	return n.rn.Propose(data)
+}
+
+// Crash stops node id, which loses its volatile state and the messages sent
+// to it until it is restarted.
+func (s *Sim) Crash(id uint64) {
+	s.nodes[id-1].rn = nil
+}
+
+// Restart restarts node id from its storage, if it crashed.
+func (s *Sim) Restart(id uint64) {
+	if n := s.nodes[id-1]; n.rn == nil {
+		s.start(n)
+	}
+}
+
+// Applied returns the data of the entries node id applied, in order.
+func (s *Sim) Applied(id uint64) [][]byte {
+	return s.nodes[id-1].applied
+}
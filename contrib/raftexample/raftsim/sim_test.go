@@ -0,0 +1,240 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftsim
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+var seeds = flag.Int("raftsim.seeds", 20, "number of seeds TestRandomFaults simulates")
+
+// waitLeader ticks s until a leader is elected.
+func waitLeader(t *testing.T, s *Sim) uint64 {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if lead := s.Leader(); lead != 0 {
+			return lead
+		}
+		if err := s.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatal("no leader elected")
+	return 0
+}
+
+func TestElection(t *testing.T) {
+	s := New(Config{Nodes: 3, Seed: 1})
+	waitLeader(t, s)
+	if s.Now() >= 20 {
+		t.Errorf("leader elected at tick %d, want before 20", s.Now())
+	}
+}
+
+// TestPartition tests that the majority side of a partition elects a leader
+// and commits, and that the old leader catches up once the partition heals.
+func TestPartition(t *testing.T) {
+	s := New(Config{Nodes: 5, Seed: 1, PreVote: true})
+	lead := waitLeader(t, s)
+
+	var rest []uint64
+	for id := uint64(1); id <= 5; id++ {
+		if id != lead {
+			rest = append(rest, id)
+		}
+	}
+	s.Network().Partition([]uint64{lead}, rest)
+	s.Propose(lead, []byte("lost"))
+	if err := s.Run(50); err != nil {
+		t.Fatal(err)
+	}
+	newLead := s.Leader()
+	if newLead == 0 || newLead == lead {
+		t.Fatalf("leader = %d, want one of %v", newLead, rest)
+	}
+	s.Propose(newLead, []byte("foo"))
+
+	s.Network().Heal()
+	if err := s.Run(50); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 5; id++ {
+		if got := s.Applied(id); !reflect.DeepEqual(got, [][]byte{[]byte("foo")}) {
+			t.Errorf("node %d applied %q, want [foo]", id, got)
+		}
+	}
+}
+
+func TestCrashRestart(t *testing.T) {
+	s := New(Config{Nodes: 3, Seed: 1})
+	lead := waitLeader(t, s)
+	s.Crash(lead)
+	lead = waitLeader(t, s)
+	if err := s.Propose(lead, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 3; id++ {
+		s.Restart(id)
+	}
+	if err := s.Run(50); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 3; id++ {
+		if got := s.Applied(id); !reflect.DeepEqual(got, [][]byte{[]byte("foo")}) {
+			t.Errorf("node %d applied %q, want [foo]", id, got)
+		}
+	}
+}
+
+// randomFaults runs a simulation seeded with seed under random faults, then
+// heals it, and returns the entries each node applied.
+func randomFaults(t *testing.T, seed int64) [][][]byte {
+	const nodes = 5
+	s := New(Config{Nodes: nodes, Seed: seed, PreVote: seed%2 == 0})
+	// the faults are drawn from another source than the simulation's, so
+	// that they do not change with what the nodes draw
+	r := rand.New(rand.NewSource(seed))
+	id := func() uint64 { return uint64(1 + r.Intn(nodes)) }
+
+	props := 0
+	for i := 0; i < 500; i++ {
+		switch p := r.Intn(100); {
+		case p < 30:
+			props++
+			s.Propose(id(), []byte(fmt.Sprintf("%d", props)))
+		case p < 32:
+			perm := r.Perm(nodes)
+			var a, b []uint64
+			for j, k := range perm {
+				if j < nodes/2 {
+					a = append(a, uint64(k+1))
+				} else {
+					b = append(b, uint64(k+1))
+				}
+			}
+			s.Network().Partition(a, b)
+		case p < 34:
+			s.Network().Drop(id(), 0, 0.5)
+		case p < 36:
+			s.Network().Delay(0, id(), 0, 5)
+		case p < 38:
+			s.Crash(id())
+		case p < 42:
+			s.Restart(id())
+		case p < 45:
+			s.Network().Heal()
+		}
+		if err := s.Tick(); err != nil {
+			t.Fatalf("seed %d, tick %d: %v", seed, s.Now(), err)
+		}
+	}
+
+	s.Network().Heal()
+	for id := uint64(1); id <= nodes; id++ {
+		s.Restart(id)
+	}
+	// without pre-vote, a lagging node may disrupt a few elections
+	if err := s.Run(1000); err != nil {
+		t.Fatalf("seed %d, tick %d: %v", seed, s.Now(), err)
+	}
+	applied := make([][][]byte, nodes)
+	for i := range applied {
+		applied[i] = s.Applied(uint64(i + 1))
+	}
+	return applied
+}
+
+// TestRandomFaults tests that the raft invariants hold under random
+// partitions, drops, delays and crashes, and that the nodes applied the same
+// entries once the faults are healed.
+func TestRandomFaults(t *testing.T) {
+	n := *seeds
+	if testing.Short() {
+		n = 3
+	}
+	for seed := int64(1); seed <= int64(n); seed++ {
+		applied := randomFaults(t, seed)
+		for i := 1; i < len(applied); i++ {
+			if !reflect.DeepEqual(applied[i], applied[0]) {
+				t.Errorf("seed %d: node %d applied %q, node 1 applied %q", seed, i+1, applied[i], applied[0])
+			}
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	a, b := randomFaults(t, 7), randomFaults(t, 7)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("runs of the same seed applied %q and %q", a, b)
+	}
+	if len(a[0]) == 0 {
+		t.Error("nothing applied")
+	}
+}
+
+func TestChecker(t *testing.T) {
+	c := newChecker()
+	if err := c.leader(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.leader(2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.leader(2, 2); err == nil {
+		t.Error("two leaders in a term not reported")
+	}
+
+	e := raftpb.Entry{Term: 2, Index: 5, Data: []byte("foo")}
+	if err := c.commit(1, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.commit(2, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.commit(3, raftpb.Entry{Term: 3, Index: 5, Data: []byte("bar")}); err == nil {
+		t.Error("different entries committed at an index not reported")
+	}
+}
+
+func TestLogMatching(t *testing.T) {
+	ents := func(terms ...uint64) []raftpb.Entry {
+		var ents []raftpb.Entry
+		for i, term := range terms {
+			ents = append(ents, raftpb.Entry{Term: term, Index: uint64(i + 2), Data: []byte(fmt.Sprintf("%d", term))})
+		}
+		return ents
+	}
+	tests := []struct {
+		a, b []raftpb.Entry
+		werr bool
+	}{
+		{ents(1, 1, 2), ents(1, 1, 2, 3), false},
+		{ents(1, 1, 2), ents(1, 1, 3), false},
+		{ents(1, 1, 2), ents(1, 3, 2), true},
+		{ents(1, 1)[1:], ents(1, 1, 2), false},
+		{nil, ents(1), false},
+	}
+	for i, tt := range tests {
+		if err := logMatching(1, tt.a, 2, tt.b); (err != nil) != tt.werr {
+			t.Errorf("#%d: err = %v, want error %v", i, err, tt.werr)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// maxSnapshotBurst bounds the bytes a snapshot rate limiter lets through at
+// once, so that the transfers are paced in small steps.
+const maxSnapshotBurst = 64 * 1024
+
+// newSnapshotLimiter returns a limiter of bytesPerSec bytes per second,
+// shared by the snapshot transfers of a node, or nil if bytesPerSec is 0 for
+// no limit.
+func newSnapshotLimiter(bytesPerSec int) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), min(bytesPerSec, maxSnapshotBurst))
+}
+
+// limitedWriter paces the writes to w by l, a nil l does not limit them.
+type limitedWriter struct {
+	ctx context.Context
+	w   io.Writer
+	l   *rate.Limiter
+}
+
+func (lw limitedWriter) Write(p []byte) (int, error) {
+	if lw.l == nil {
+		return lw.w.Write(p)
+	}
+	var n int
+	for len(p) > 0 {
+		b := p[:min(len(p), lw.l.Burst())]
+		if err := lw.l.WaitN(lw.ctx, len(b)); err != nil {
+			return n, err
+		}
+		m, err := lw.w.Write(b)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[len(b):]
+	}
+	return n, nil
+}
+
+// limitedReader paces the reads from r by l, a nil l does not limit them.
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rate.Limiter
+}
+
+func (lr limitedReader) Read(p []byte) (int, error) {
+	if lr.l == nil {
+		return lr.r.Read(p)
+	}
+	n, err := lr.r.Read(p[:min(len(p), lr.l.Burst())])
+	if n > 0 {
+		if werr := lr.l.WaitN(lr.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// snapshotPath is where the raft server receives the snapshot data in chunks.
+const snapshotPath = "/raftexample/snapshot"
+
+var (
+	// snapshotChunkSize is the size of the chunks the snapshot data is
+	// streamed in.
+	snapshotChunkSize = 1024 * 1024
+	// snapshotSendRetries bounds the attempts to stream the data of a
+	// snapshot, each resuming where the previous one was interrupted.
+	snapshotSendRetries   = 5
+	snapshotRetryInterval = time.Second
+)
+
+func (rc *raftNode) addPeer(id uint64, u string) {
+	rc.peerMu.Lock()
+	rc.peerURLs[id] = u
+	rc.peerMu.Unlock()
+	rc.transport.AddPeer(types.ID(id), []string{u})
+}
+
+func (rc *raftNode) removePeer(id uint64) {
+	rc.peerMu.Lock()
+	delete(rc.peerURLs, id)
+	rc.peerMu.Unlock()
+	rc.transport.RemovePeer(types.ID(id))
+}
+
+// send sends ms over the transport, except the snapshots, whose data is
+// streamed separately by sendSnapshot.
+func (rc *raftNode) send(ms []raftpb.Message) {
+	remote := make([]raftpb.Message, 0, len(ms))
+	for _, m := range ms {
+		if m.Type == raftpb.MsgSnap && len(m.Snapshot.Data) > 0 {
+			go rc.sendSnapshot(m)
+		} else {
+			remote = append(remote, m)
+		}
+	}
+	rc.transport.Send(remote)
+}
+
+// sendSnapshot streams the data of the snapshot in m to its recipient in
+// chunks, resuming after interruptions, then sends m without the data, which
+// the recipient reads back from its partial snapshot in Process. The
+// transport reports whether m was delivered to raft.
+func (rc *raftNode) sendSnapshot(m raftpb.Message) {
+	rc.peerMu.Lock()
+	u, ok := rc.peerURLs[m.To]
+	rc.peerMu.Unlock()
+
+	err := fmt.Errorf("raftexample: unknown peer %d", m.To)
+	for i := 0; ok && i < snapshotSendRetries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(snapshotRetryInterval):
+			case <-rc.stopc:
+				return
+			}
+		}
+		progress := func(n, sent, total int) { snapshotSentBytes.Add(float64(n)) }
+		if err = streamSnapshot(rc.snapshotClient, u, m.Snapshot, rc.snapshotSendLimiter, progress); err == nil {
+			break
+		}
+		log.Printf("raftexample: failed to stream snapshot %d to %d (%v)", m.Snapshot.Metadata.Index, m.To, err)
+	}
+	if err != nil {
+		rc.node.ReportSnapshot(m.To, raft.SnapshotFailure)
+		return
+	}
+
+	snapshot := *m.Snapshot
+	snapshot.Data = nil
+	m.Snapshot = &snapshot
+	rc.transport.Send([]raftpb.Message{m})
+}
+
+// streamSnapshot posts the data of snapshot with c to the raft server at u,
+// from where the server's partial snapshot ends, paced by l unless it is nil.
+// Once a chunk of n bytes is written, progress is called with the bytes of
+// data sent so far, including those the server had already, out of total.
+func streamSnapshot(c *http.Client, u string, snapshot *raftpb.Snapshot, l *rate.Limiter, progress func(n, sent, total int)) error {
+	q := url.Values{}
+	q.Set("term", strconv.FormatUint(snapshot.Metadata.Term, 10))
+	q.Set("index", strconv.FormatUint(snapshot.Metadata.Index, 10))
+	resp, err := c.Get(u + snapshotPath + "?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s (%s)", resp.Status, strings.TrimSpace(string(body)))
+	}
+	offset, err := strconv.ParseUint(string(body), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	data := snapshot.Data
+	if offset > uint64(len(data)) {
+		return fmt.Errorf("partial snapshot of %d bytes exceeds the snapshot of %d", offset, len(data))
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w := limitedWriter{ctx: context.Background(), w: pw, l: l}
+		for off := int(offset); off < len(data); off += snapshotChunkSize {
+			chunk := data[off:min(off+snapshotChunkSize, len(data))]
+			if err := snap.WriteChunk(w, uint64(off), chunk); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if progress != nil {
+				progress(len(chunk), off+len(chunk), len(data))
+			}
+		}
+		pw.Close()
+	}()
+	q.Set("size", strconv.Itoa(len(data)))
+	resp, err = c.Post(u+snapshotPath+"?"+q.Encode(), "application/octet-stream", pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %s (%s)", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// handleSnapshot reports on GET how much of a snapshot's data has been
+// received, and on POST receives its chunks into the partial snapshot.
+func (rc *raftNode) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	term, err := strconv.ParseUint(q.Get("term"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid term", http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.ParseUint(q.Get("index"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid index", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		p, err := rc.snapshotter.OpenPartial(term, index)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer p.Close()
+		fmt.Fprint(w, p.Size())
+
+	case http.MethodPost:
+		size, err := strconv.ParseUint(q.Get("size"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+		p, err := rc.snapshotter.OpenPartial(term, index)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer p.Close()
+		body := limitedReader{ctx: r.Context(), r: r.Body, l: rc.snapshotRecvLimiter}
+		for {
+			offset, data, err := snap.ReadChunk(body)
+			if err == io.EOF {
+				break
+			}
+			if err == nil {
+				err = p.Write(offset, data)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			snapshotReceivedBytes.Add(float64(len(data)))
+		}
+		if p.Size() != size {
+			http.Error(w, fmt.Sprintf("received %d of %d bytes", p.Size(), size), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readSnapshotData restores the data of a snapshot that was streamed ahead
+// of m by sendSnapshot.
+func (rc *raftNode) readSnapshotData(m *raftpb.Message) error {
+	data, err := rc.snapshotter.ReadPartial(m.Snapshot.Metadata.Term, m.Snapshot.Metadata.Index)
+	if os.IsNotExist(err) {
+		// the snapshot has no data
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snapshot := *m.Snapshot
+	snapshot.Data = data
+	m.Snapshot = &snapshot
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n *int
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	*cr.n += n
+	return n, err
+}
+
+// TestStreamSnapshotResume tests that a snapshot whose stream is interrupted
+// resumes from the data already received.
+func TestStreamSnapshotResume(t *testing.T) {
+	prevSnapshotChunkSize := snapshotChunkSize
+	snapshotChunkSize = 100
+	defer func() { snapshotChunkSize = prevSnapshotChunkSize }()
+
+	rc := &raftNode{snapshotter: snap.New(zaptest.NewLogger(t), t.TempDir())}
+	posts, received := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posts++
+			if posts == 1 {
+				// drop the stream within the fourth chunk
+				r.Body = io.NopCloser(io.LimitReader(r.Body, 350))
+			}
+			r.Body = io.NopCloser(countingReader{r.Body, &received})
+		}
+		rc.handleSnapshot(w, r)
+	}))
+	defer srv.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	snapshot := &raftpb.Snapshot{Data: data, Metadata: raftpb.SnapshotMetadata{Term: 2, Index: 10}}
+	if err := streamSnapshot(srv.Client(), srv.URL, snapshot, nil, nil); err == nil {
+		t.Fatal("expected the interrupted stream to fail")
+	}
+	received = 0
+	if err := streamSnapshot(srv.Client(), srv.URL, snapshot, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the three chunks received before the interruption are not sent again
+	if wreceived := 7 * (16 + 100); received != wreceived {
+		t.Errorf("resumed stream sent %d bytes, want %d", received, wreceived)
+	}
+
+	m := raftpb.Message{Type: raftpb.MsgSnap, Snapshot: &raftpb.Snapshot{Metadata: snapshot.Metadata}}
+	if err := rc.readSnapshotData(&m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.Snapshot.Data, data) {
+		t.Errorf("read %d bytes of snapshot data, want the %d streamed", len(m.Snapshot.Data), len(data))
+	}
+}
+
+// TestStreamSnapshotRateLimit tests that the snapshot data is paced by the
+// limiters of the sender and the receiver, and that its progress is reported.
+func TestStreamSnapshotRateLimit(t *testing.T) {
+	prevSnapshotChunkSize := snapshotChunkSize
+	snapshotChunkSize = 500
+	defer func() { snapshotChunkSize = prevSnapshotChunkSize }()
+
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	tests := []struct {
+		name       string
+		send, recv int
+	}{
+		{"send", 2000, 0},
+		{"receive", 0, 2000},
+	}
+	for i, tt := range tests {
+		rc := &raftNode{snapshotter: snap.New(zaptest.NewLogger(t), t.TempDir()), snapshotRecvLimiter: newSnapshotLimiter(tt.recv)}
+		srv := httptest.NewServer(http.HandlerFunc(rc.handleSnapshot))
+
+		var sent []int
+		progress := func(n, s, total int) {
+			if total != len(data) {
+				t.Errorf("%s: total = %d, want %d", tt.name, total, len(data))
+			}
+			sent = append(sent, s)
+		}
+		snapshot := &raftpb.Snapshot{Data: data, Metadata: raftpb.SnapshotMetadata{Term: 2, Index: uint64(10 + i)}}
+		start := time.Now()
+		if err := streamSnapshot(srv.Client(), srv.URL, snapshot, newSnapshotLimiter(tt.send), progress); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		// 3000 bytes of data and chunk headers at 2000 bytes per second,
+		// after a burst of 2000 bytes
+		if d := time.Since(start); d < 400*time.Millisecond {
+			t.Errorf("%s: streamed in %v, want at least 400ms", tt.name, d)
+		}
+		if wsent := []int{500, 1000, 1500, 2000, 2500, 3000}; !reflect.DeepEqual(sent, wsent) {
+			t.Errorf("%s: progress = %v, want %v", tt.name, sent, wsent)
+		}
+		srv.Close()
+	}
+}
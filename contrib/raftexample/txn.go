@@ -0,0 +1,104 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// The types of the operations of a transaction.
+const (
+	txnPut    = "put"
+	txnDelete = "delete"
+	txnGet    = "get"
+)
+
+var errBadTxnOp = errors.New("raftexample: bad transaction operation")
+
+// txnOp is an operation of a transaction: it sets Key to Value, deletes Key,
+// or gets it.
+type txnOp struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// txnResult is the result of a get of a transaction.
+type txnResult struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Found bool   `json:"found"`
+}
+
+// validateTxn checks that a transaction has operations, of known types.
+func validateTxn(ops []txnOp) error {
+	if len(ops) == 0 {
+		return fmt.Errorf("%w: no operations", errBadTxnOp)
+	}
+	for i, op := range ops {
+		switch op.Type {
+		case txnPut, txnDelete, txnGet:
+		default:
+			return fmt.Errorf("%w: operation %d has type %q", errBadTxnOp, i, op.Type)
+		}
+	}
+	return nil
+}
+
+// Txn proposes the operations ops as a single update, which the stores
+// apply as a unit, and waits until it is applied to return the results of
+// its gets, in order. A get sees the operations before it in ops. If ctx is
+// done first, the transaction may still be applied.
+func (s *kvstore) Txn(ctx context.Context, ops []txnOp) ([]txnResult, error) {
+	if err := validateTxn(ops); err != nil {
+		return nil, err
+	}
+	results, err := s.proposeAndWait(ctx, kv{Txn: ops})
+	if err != nil {
+		return nil, err
+	}
+	return results.([]txnResult), nil
+}
+
+// applyTxn applies the operations ops, and returns the results of its gets.
+// It is called with s.mu held.
+func (s *kvstore) applyTxn(ops []txnOp) []txnResult {
+	results := []txnResult{}
+	for _, op := range ops {
+		switch op.Type {
+		case txnPut:
+			s.kvStore[op.Key] = op.Value
+			s.notify(kv{Key: op.Key, Val: op.Value})
+		case txnDelete:
+			delete(s.kvStore, op.Key)
+			s.notify(kv{Key: op.Key, Delete: true})
+		case txnGet:
+			v, ok := s.kvStore[op.Key]
+			results = append(results, txnResult{Key: op.Key, Value: v, Found: ok})
+		}
+	}
+	return results
+}
+
+// txnKeys returns the keys the operations ops read or write.
+func txnKeys(ops []txnOp) []string {
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+	return keys
+}
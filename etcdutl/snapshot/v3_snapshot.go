@@ -116,6 +116,16 @@ func (s *v3Manager) Status(dbPath string) (ds Status, err error) {
 	if _, err = os.Stat(dbPath); err != nil {
 		return ds, err
 	}
+	isDelta, err := backend.IsSnapshotDelta(dbPath)
+	if err != nil {
+		return ds, err
+	}
+	if isDelta {
+		if dbPath, err = materializeSnapshotDelta(dbPath); err != nil {
+			return ds, err
+		}
+		defer os.RemoveAll(filepath.Dir(dbPath))
+	}
 
 	db, err := bolt.Open(dbPath, 0400, &bolt.Options{ReadOnly: true})
 	if err != nil {
@@ -175,6 +185,36 @@ func (s *v3Manager) Status(dbPath string) (ds Status, err error) {
 	return ds, nil
 }
 
+// materializeSnapshotDelta restores the snapshot at dbPath, streamed by a
+// server with named databases, to a bbolt database in a temporary directory
+// and returns its path.
+func materializeSnapshotDelta(dbPath string) (string, error) {
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	var r io.Reader = f
+	if hasChecksum(fi.Size()) {
+		r = io.LimitReader(f, fi.Size()-sha256.Size)
+	}
+
+	dir, err := os.MkdirTemp("", "etcdutl-snapshot")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "db")
+	if err = backend.ApplySnapshotDelta(path, r); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return path, nil
+}
+
 // RestoreConfig configures snapshot restore operation.
 type RestoreConfig struct {
 	// SnapshotPath is the path of snapshot file to restore from.
@@ -0,0 +1,81 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"flag"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+)
+
+// byteUnits are the units String formats a BytesValue with, largest first.
+var byteUnits = []struct {
+	name string
+	size uint64
+}{
+	{"EiB", humanize.EiByte},
+	{"EB", humanize.EByte},
+	{"PiB", humanize.PiByte},
+	{"PB", humanize.PByte},
+	{"TiB", humanize.TiByte},
+	{"TB", humanize.TByte},
+	{"GiB", humanize.GiByte},
+	{"GB", humanize.GByte},
+	{"MiB", humanize.MiByte},
+	{"MB", humanize.MByte},
+	{"KiB", humanize.KiByte},
+	{"kB", humanize.KByte},
+}
+
+// BytesValue is a size in bytes, given as a number of bytes with an optional
+// decimal (kB, MB, GB...) or binary (KiB, MiB, GiB...) unit, like 64MB or
+// 1GiB.
+type BytesValue uint64
+
+// NewBytesValue creates a BytesValue instance with the provided value.
+func NewBytesValue(v uint64) *BytesValue {
+	b := BytesValue(v)
+	return &b
+}
+
+// Set parses a command line size.
+// Implements "flag.Value" interface.
+func (b *BytesValue) Set(s string) error {
+	v, err := humanize.ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = BytesValue(v)
+	return nil
+}
+
+// String formats the size with the largest unit dividing it, so that Set
+// parses it back to the same size.
+// Implements "flag.Value" interface.
+func (b *BytesValue) String() string {
+	v := uint64(*b)
+	for _, u := range byteUnits {
+		if v >= u.size && v%u.size == 0 {
+			return strconv.FormatUint(v/u.size, 10) + u.name
+		}
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// BytesFromFlag returns the size in bytes of a flag with the given name.
+func BytesFromFlag(fs *flag.FlagSet, name string) uint64 {
+	return uint64(*fs.Lookup(name).Value.(*BytesValue))
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBytesValue(t *testing.T) {
+	cases := []struct {
+		name           string
+		s              string
+		expectedVal    uint64
+		expectedString string
+		expectError    bool
+	}{
+		{
+			name:           "plain bytes",
+			s:              "1000",
+			expectedVal:    1000,
+			expectedString: "1kB",
+		},
+		{
+			name:           "zero value",
+			s:              "0",
+			expectedVal:    0,
+			expectedString: "0",
+		},
+		{
+			name:           "decimal unit",
+			s:              "64MB",
+			expectedVal:    64 * 1000 * 1000,
+			expectedString: "64MB",
+		},
+		{
+			name:           "binary unit",
+			s:              "1GiB",
+			expectedVal:    1 << 30,
+			expectedString: "1GiB",
+		},
+		{
+			name:           "fraction",
+			s:              "1.5KiB",
+			expectedVal:    1536,
+			expectedString: "1536",
+		},
+		{
+			name:        "negative value",
+			s:           "-1MB",
+			expectError: true,
+		},
+		{
+			name:        "unknown unit",
+			s:           "1XB",
+			expectError: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var val BytesValue
+			err := val.Set(tc.s)
+
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected failure on parsing bytes value from %s", tc.s)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error when parsing %s: %v", tc.s, err)
+			}
+			assert.Equal(t, tc.expectedVal, uint64(val))
+			assert.Equal(t, tc.expectedString, val.String())
+		})
+	}
+}
+
+func TestBytesFromFlag(t *testing.T) {
+	fs := flag.NewFlagSet("etcd", flag.ContinueOnError)
+	fs.Var(NewBytesValue(64*1024*1024), "quota-backend-bytes", "")
+	assert.Equal(t, uint64(64*1024*1024), BytesFromFlag(fs, "quota-backend-bytes"))
+
+	if err := fs.Parse([]string{"--quota-backend-bytes", "8GB"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(8*1000*1000*1000), BytesFromFlag(fs, "quota-backend-bytes"))
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// DurationValue is a duration, like 300ms or 1m30s, refused out of its
+// bounds.
+type DurationValue struct {
+	d   time.Duration
+	min time.Duration
+	// max is no upper bound if 0.
+	max time.Duration
+}
+
+// NewDurationValue creates a DurationValue instance with the provided value,
+// which Set refuses to set below min or, if max is not 0, above max.
+func NewDurationValue(d, min, max time.Duration) *DurationValue {
+	if d < min || (max != 0 && d > max) {
+		panic(fmt.Sprintf("new DurationValue %v out of its bounds [%v, %v]", d, min, max))
+	}
+	return &DurationValue{d: d, min: min, max: max}
+}
+
+// Set parses a command line duration.
+// Implements "flag.Value" interface.
+func (d *DurationValue) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v < d.min {
+		return fmt.Errorf("duration %v is below the minimum %v", v, d.min)
+	}
+	if d.max != 0 && v > d.max {
+		return fmt.Errorf("duration %v is above the maximum %v", v, d.max)
+	}
+	d.d = v
+	return nil
+}
+
+// String implements "flag.Value" interface.
+func (d *DurationValue) String() string { return d.d.String() }
+
+// Duration returns the duration.
+func (d *DurationValue) Duration() time.Duration { return d.d }
+
+// DurationFromFlag returns the duration of a flag with the given name.
+func DurationFromFlag(fs *flag.FlagSet, name string) time.Duration {
+	return fs.Lookup(name).Value.(*DurationValue).Duration()
+}
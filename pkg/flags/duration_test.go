@@ -0,0 +1,107 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDurationValue(t *testing.T) {
+	cases := []struct {
+		name        string
+		s           string
+		min, max    time.Duration
+		expectedVal time.Duration
+		expectError bool
+	}{
+		{
+			name:        "within bounds",
+			s:           "1m30s",
+			min:         time.Second,
+			max:         time.Hour,
+			expectedVal: 90 * time.Second,
+		},
+		{
+			name:        "at the bounds",
+			s:           "1s",
+			min:         time.Second,
+			max:         time.Second,
+			expectedVal: time.Second,
+		},
+		{
+			name:        "no maximum",
+			s:           "1000h",
+			expectedVal: 1000 * time.Hour,
+		},
+		{
+			name:        "below the minimum",
+			s:           "999ms",
+			min:         time.Second,
+			expectError: true,
+		},
+		{
+			name:        "above the maximum",
+			s:           "2h",
+			max:         time.Hour,
+			expectError: true,
+		},
+		{
+			name:        "negative value",
+			s:           "-1s",
+			expectError: true,
+		},
+		{
+			name:        "no unit",
+			s:           "10",
+			expectError: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			val := NewDurationValue(tc.min, tc.min, tc.max)
+			err := val.Set(tc.s)
+
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected failure on parsing duration value from %s", tc.s)
+				}
+				assert.Equal(t, tc.min, val.Duration())
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error when parsing %s: %v", tc.s, err)
+			}
+			assert.Equal(t, tc.expectedVal, val.Duration())
+		})
+	}
+}
+
+func TestDurationFromFlag(t *testing.T) {
+	fs := flag.NewFlagSet("etcd", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(NewDurationValue(100*time.Millisecond, time.Millisecond, time.Second), "heartbeat-interval", "")
+	assert.Equal(t, 100*time.Millisecond, DurationFromFlag(fs, "heartbeat-interval"))
+
+	if err := fs.Parse([]string{"--heartbeat-interval", "250ms"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 250*time.Millisecond, DurationFromFlag(fs, "heartbeat-interval"))
+	assert.Error(t, fs.Parse([]string{"--heartbeat-interval", "2s"}))
+}
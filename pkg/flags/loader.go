@@ -0,0 +1,190 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// Loader sets the flags of a FlagSet that are not set on the command line
+// from environment variables and a config file. A flag takes its value from,
+// in order of precedence:
+//
+//  1. the command line,
+//  2. its environment variable, see FlagToEnv,
+//  3. the config file, where its key is the flag name,
+//  4. its default.
+//
+// Unlike SetFlagsFromEnv, Loader refuses the environment variables with its
+// prefix and the config file keys that name no flag.
+type Loader struct {
+	// EnvPrefix is the prefix of the environment variables of the flags, no
+	// environment variable is read if empty.
+	EnvPrefix string
+	// ConfigFileFlag names the flag holding the path of the config file,
+	// which may be set by its environment variable. No config file is read
+	// if it is empty or the flag is not set. The file is YAML (.yaml, .yml
+	// or .json) or TOML (.toml), after its extension. A list value sets a
+	// flag to its comma separated items.
+	ConfigFileFlag string
+
+	Logger *zap.Logger
+}
+
+// Load sets the flags of fs, which must be parsed, see Loader.
+func (l Loader) Load(fs *flag.FlagSet) error {
+	lg := l.Logger
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if l.EnvPrefix != "" {
+		if err := l.loadEnv(lg, fs, set); err != nil {
+			return err
+		}
+	}
+	if l.ConfigFileFlag == "" {
+		return nil
+	}
+	f := fs.Lookup(l.ConfigFileFlag)
+	if f == nil {
+		return fmt.Errorf("config file flag %q is not defined", l.ConfigFileFlag)
+	}
+	if path := f.Value.String(); path != "" {
+		return l.loadFile(lg, fs, path, set)
+	}
+	return nil
+}
+
+func (l Loader) loadEnv(lg *zap.Logger, fs *flag.FlagSet, set map[string]bool) error {
+	keys := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		keys[FlagToEnv(l.EnvPrefix, f.Name)] = f.Name
+	})
+	var unknown []string
+	for _, env := range os.Environ() {
+		key, val, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, l.EnvPrefix+"_") {
+			continue
+		}
+		name, ok := keys[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		if set[name] {
+			lg.Info("environment variable is shadowed by command-line flag", zap.String("variable-name", key))
+			continue
+		}
+		if err := fs.Set(name, val); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", val, key, err)
+		}
+		set[name] = true
+		lg.Info("recognized and used environment variable", zap.String("variable-name", key), zap.String("variable-value", val))
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unrecognized environment variables: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func (l Loader) loadFile(lg *zap.Logger, fs *flag.FlagSet, path string, set map[string]bool) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(b, &values)
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	default:
+		return fmt.Errorf("unsupported config file extension %q of %s", ext, path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	names := make([]string, 0, len(values))
+	var unknown []string
+	for name := range values {
+		if fs.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+		names = append(names, name)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unrecognized keys in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if set[name] {
+			lg.Info("config file key is shadowed by command-line flag or environment variable", zap.String("key", name))
+			continue
+		}
+		val, err := formatValue(values[name])
+		if err != nil {
+			return fmt.Errorf("invalid value for %s in config file %s: %v", name, path, err)
+		}
+		if err = fs.Set(name, val); err != nil {
+			return fmt.Errorf("invalid value %q for %s in config file %s: %v", val, name, path, err)
+		}
+	}
+	lg.Info("loaded config file", zap.String("path", path))
+	return nil
+}
+
+// formatValue returns the flag value of a config file value.
+func formatValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i := range v {
+			item, err := formatValue(v[i])
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v of type %T", v, v)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+func newLoaderFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config-file", "", "")
+	fs.String("a", "default", "")
+	fs.Int("b", 1, "")
+	fs.Bool("c", false, "")
+	fs.Duration("d", time.Second, "")
+	fs.Var(NewStringsValue(""), "e", "")
+	return fs
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoader(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "a: file\nb: 3\nc: true\nd: 5s\ne: [x, z]\n",
+		"config.toml": "a = \"file\"\nb = 3\nc = true\nd = \"5s\"\ne = [\"x\", \"z\"]\n",
+		"config.json": `{"a": "file", "b": 3, "c": true, "d": "5s", "e": ["x", "z"]}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, name, content)
+			t.Setenv("LOADERTEST_CONFIG_FILE", path)
+			t.Setenv("LOADERTEST_B", "2")
+			t.Setenv("LOADERTEST_A", "env")
+
+			fs := newLoaderFlagSet()
+			if err := fs.Parse([]string{"-a=flag"}); err != nil {
+				t.Fatal(err)
+			}
+			l := Loader{EnvPrefix: "LOADERTEST", ConfigFileFlag: "config-file", Logger: zaptest.NewLogger(t)}
+			if err := l.Load(fs); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]string{
+				// flag > env > file > default
+				"a": "flag",
+				"b": "2",
+				"c": "true",
+				"d": "5s",
+				"e": "x,z",
+			}
+			for name, w := range want {
+				if g := fs.Lookup(name).Value.String(); g != w {
+					t.Errorf("%s = %q, want %q", name, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestLoaderUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		werr string
+	}{
+		{
+			name: "unknown env",
+			env:  map[string]string{"LOADERTEST_UNKNOWN": "1"},
+			werr: "LOADERTEST_UNKNOWN",
+		},
+		{
+			name: "unknown key",
+			file: "a: file\nunknown: 1\nother: 2\n",
+			werr: "other, unknown",
+		},
+		{
+			name: "nested value",
+			file: "a:\n  b: 1\n",
+			werr: "unsupported value",
+		},
+		{
+			name: "bad value",
+			file: "b: x\n",
+			werr: `invalid value "x" for b`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs := newLoaderFlagSet()
+			args := []string{}
+			if tt.file != "" {
+				args = append(args, "-config-file="+writeConfig(t, "config.yml", tt.file))
+			}
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			err := Loader{EnvPrefix: "LOADERTEST", ConfigFileFlag: "config-file"}.Load(fs)
+			if err == nil || !strings.Contains(err.Error(), tt.werr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.werr)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const redactedSecret = "[REDACTED]"
+
+// SecretValue is a secret, like a key, given either literally or as @ and
+// the path of a file holding it, e.g. @/etc/etcd/key, which keeps it out of
+// the process listings. The file is read when the flag is set, and its
+// trailing newlines are not part of the secret. The secret is never
+// returned by String. It is not zeroed automatically: setting the flag
+// again zeroes the previous secret, and the owner of the flag calls Zero
+// once the secret is no longer needed.
+type SecretValue struct {
+	secret []byte
+	// path is the file the secret was read from, empty if given literally.
+	path string
+}
+
+// Set parses a command line secret.
+// Implements "flag.Value" interface.
+func (sv *SecretValue) Set(s string) error {
+	var (
+		secret []byte
+		path   string
+	)
+	if strings.HasPrefix(s, "@") {
+		path = s[1:]
+		if path == "" {
+			return errors.New("secret file path is empty")
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		secret = bytes.TrimRight(b, "\r\n")
+		if len(secret) == 0 {
+			return fmt.Errorf("secret file %s is empty", path)
+		}
+	} else {
+		secret = []byte(s)
+	}
+	sv.Zero()
+	sv.secret, sv.path = secret, path
+	return nil
+}
+
+// String returns the @ and path of the file the secret was read from, or a
+// placeholder if the secret was given literally.
+// Implements "flag.Value" interface.
+func (sv *SecretValue) String() string {
+	switch {
+	case sv.path != "":
+		return "@" + sv.path
+	case len(sv.secret) > 0:
+		return redactedSecret
+	}
+	return ""
+}
+
+// Secret returns the secret, which Zero overwrites.
+func (sv *SecretValue) Secret() []byte { return sv.secret }
+
+// Zero overwrites the secret with zeros and unsets it.
+func (sv *SecretValue) Zero() {
+	clear(sv.secret)
+	sv.secret, sv.path = nil, ""
+}
+
+// SecretFromFlag returns the secret of a flag with the given name.
+func SecretFromFlag(fs *flag.FlagSet, name string) []byte {
+	return fs.Lookup(name).Value.(*SecretValue).Secret()
+}
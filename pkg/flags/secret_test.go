@@ -0,0 +1,121 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretValue(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name           string
+		s              string
+		expectedSecret string
+		expectedString string
+		expectError    bool
+	}{
+		{
+			name:           "literal secret",
+			s:              "literal-secret",
+			expectedSecret: "literal-secret",
+			expectedString: redactedSecret,
+		},
+		{
+			name:           "secret file",
+			s:              "@" + keyFile,
+			expectedSecret: "file-secret",
+			expectedString: "@" + keyFile,
+		},
+		{
+			name:        "empty secret file",
+			s:           "@" + emptyFile,
+			expectError: true,
+		},
+		{
+			name:        "missing secret file",
+			s:           "@" + filepath.Join(dir, "missing"),
+			expectError: true,
+		},
+		{
+			name:        "empty path",
+			s:           "@",
+			expectError: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var val SecretValue
+			err := val.Set(tc.s)
+
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected failure on parsing secret value from %s", tc.s)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error when parsing %s: %v", tc.s, err)
+			}
+			assert.Equal(t, tc.expectedSecret, string(val.Secret()))
+			assert.Equal(t, tc.expectedString, val.String())
+		})
+	}
+}
+
+func TestSecretValueZero(t *testing.T) {
+	var val SecretValue
+	if err := val.Set("first-secret"); err != nil {
+		t.Fatal(err)
+	}
+	first := val.Secret()
+	if err := val.Set("second-secret"); err != nil {
+		t.Fatal(err)
+	}
+	// setting the secret again zeroes the previous one
+	assert.Equal(t, make([]byte, len("first-secret")), first)
+
+	second := val.Secret()
+	val.Zero()
+	assert.Equal(t, make([]byte, len("second-secret")), second)
+	assert.Empty(t, val.Secret())
+	assert.Empty(t, val.String())
+}
+
+func TestSecretFromFlag(t *testing.T) {
+	fs := flag.NewFlagSet("etcd", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(&SecretValue{}, "encryption-key", "")
+	if err := fs.Parse([]string{"--encryption-key", "key"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte("key"), SecretFromFlag(fs, "encryption-key"))
+	assert.Equal(t, redactedSecret, fs.Lookup("encryption-key").Value.String())
+}
@@ -15,6 +15,7 @@
 package flags
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/url"
@@ -33,7 +34,8 @@ type UniqueURLs struct {
 }
 
 // Set parses a command line set of URLs formatted like:
-// http://127.0.0.1:2380,http://10.1.1.2:80
+// http://127.0.0.1:2380,http://10.1.1.2:80,srv://_etcd-server._tcp.example.com
+// The hostnames and the srv:// URLs are kept as given, see Resolve.
 // Implements "flag.Value" interface.
 func (us *UniqueURLs) Set(s string) error {
 	if _, ok := us.Values[s]; ok {
@@ -43,7 +45,7 @@ func (us *UniqueURLs) Set(s string) error {
 		us.Values[s] = struct{}{}
 		return nil
 	}
-	ss, err := types.NewURLs(strings.Split(s, ","))
+	ss, err := parseURLs(strings.Split(s, ","))
 	if err != nil {
 		return err
 	}
@@ -60,6 +62,12 @@ func (us *UniqueURLs) Set(s string) error {
 	return nil
 }
 
+// Resolve returns the URLs with the srv:// URLs and the hostnames expanded
+// as URLsValue.Resolve does.
+func (us *UniqueURLs) Resolve(ctx context.Context) (types.URLs, error) {
+	return resolveURLs(ctx, us.uss)
+}
+
 // String implements "flag.Value" interface.
 func (us *UniqueURLs) String() string {
 	all := make([]string, 0, len(us.Values))
@@ -91,6 +99,12 @@ func UniqueURLsFromFlag(fs *flag.FlagSet, urlsFlagName string) []url.URL {
 	return (*fs.Lookup(urlsFlagName).Value.(*UniqueURLs)).uss
 }
 
+// ResolvedUniqueURLsFromFlag returns a slice from the resolved urls got from
+// the flag, see UniqueURLs.Resolve.
+func ResolvedUniqueURLsFromFlag(ctx context.Context, fs *flag.FlagSet, urlsFlagName string) ([]url.URL, error) {
+	return fs.Lookup(urlsFlagName).Value.(*UniqueURLs).Resolve(ctx)
+}
+
 // UniqueURLsMapFromFlag returns a map from url strings got from the flag.
 func UniqueURLsMapFromFlag(fs *flag.FlagSet, urlsFlagName string) map[string]struct{} {
 	return (*fs.Lookup(urlsFlagName).Value.(*UniqueURLs)).Values
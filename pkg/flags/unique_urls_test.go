@@ -15,7 +15,9 @@
 package flags
 
 import (
+	"context"
 	"flag"
+	"net"
 	"strings"
 	"testing"
 
@@ -112,3 +114,29 @@ func TestUniqueURLsFromFlag(t *testing.T) {
 	}
 	require.Equal(t, u.Values, um)
 }
+
+func TestResolvedUniqueURLsFromFlag(t *testing.T) {
+	defer func() {
+		lookupSRV = net.DefaultResolver.LookupSRV
+		lookupIPAddr = net.DefaultResolver.LookupIPAddr
+	}()
+	lookupSRV = func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{{Target: "10.0.0.3", Port: 2380}}, nil
+	}
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.4")}, {IP: net.ParseIP("fd00::4")}}, nil
+	}
+
+	const name = "test"
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Var(NewUniqueURLsWithExceptions(""), name, "usage")
+	require.NoError(t, fs.Set(name, "http://dual.example.com:2379,srv://_etcd-server._tcp.example.com,http://10.0.0.4:2379"))
+
+	uss, err := ResolvedUniqueURLsFromFlag(context.Background(), fs, name)
+	require.NoError(t, err)
+	var got []string
+	for _, u := range uss {
+		got = append(got, u.String())
+	}
+	require.Equal(t, []string{"http://10.0.0.3:2380", "http://10.0.0.4:2379", "http://[fd00::4]:2379"}, got)
+}
@@ -15,22 +15,37 @@
 package flags
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"go.etcd.io/etcd/client/pkg/v3/types"
 )
 
+// srvScheme is the scheme of the URLs naming a DNS SRV record, like
+// srv://_etcd-server-ssl._tcp.example.com, which Resolve expands to the
+// URLs of its targets.
+const srvScheme = "srv"
+
+var (
+	// indirection for testing
+	lookupSRV    = net.DefaultResolver.LookupSRV
+	lookupIPAddr = net.DefaultResolver.LookupIPAddr
+)
+
 // URLsValue wraps "types.URLs".
 type URLsValue types.URLs
 
 // Set parses a command line set of URLs formatted like:
-// http://127.0.0.1:2380,http://10.1.1.2:80
+// http://127.0.0.1:2380,http://10.1.1.2:80,srv://_etcd-server._tcp.example.com
+// The hostnames and the srv:// URLs are kept as given, see Resolve.
 // Implements "flag.Value" interface.
 func (us *URLsValue) Set(s string) error {
-	ss, err := types.NewURLs(strings.Split(s, ","))
+	ss, err := parseURLs(strings.Split(s, ","))
 	if err != nil {
 		return err
 	}
@@ -38,6 +53,135 @@ func (us *URLsValue) Set(s string) error {
 	return nil
 }
 
+// parseURLs parses the URLs and the srv:// URLs of a flag, sorted.
+func parseURLs(in []string) (types.URLs, error) {
+	var urls, srvs []string
+	for _, in := range in {
+		if strings.HasPrefix(strings.TrimSpace(in), srvScheme+"://") {
+			srvs = append(srvs, in)
+		} else {
+			urls = append(urls, in)
+		}
+	}
+	var ss types.URLs
+	if len(urls) > 0 {
+		var err error
+		if ss, err = types.NewURLs(urls); err != nil {
+			return nil, err
+		}
+	}
+	for _, in := range srvs {
+		u, err := parseSRVURL(strings.TrimSpace(in))
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, *u)
+	}
+	ss.Sort()
+	return ss, nil
+}
+
+// parseSRVURL parses a srv:// URL, whose host is the full name of the
+// record: _service._proto.domain.
+func parseSRVURL(in string) (*url.URL, error) {
+	u, err := url.Parse(in)
+	if err != nil {
+		return nil, err
+	}
+	if u.Port() != "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return nil, fmt.Errorf("SRV URL must only name the record: %s", in)
+	}
+	labels := strings.SplitN(u.Host, ".", 3)
+	if len(labels) < 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") || labels[2] == "" {
+		return nil, fmt.Errorf(`SRV URL does not have the form "srv://_service._proto.domain": %s`, in)
+	}
+	return &url.URL{Scheme: srvScheme, Host: u.Host}, nil
+}
+
+// Resolve returns the URLs with the records of the srv:// URLs expanded to
+// the URLs of their targets, and the hostnames expanded to one URL per
+// address they resolve to, IPv4 and IPv6 alike. The targets of a record are
+// https URLs if its service has the -ssl suffix of the etcd SRV services,
+// http URLs otherwise. The unix URLs, the IP addresses and the empty hosts
+// are kept as they are.
+// Resolve may be called right after the flags are parsed, or later before
+// the URLs are bound to, to pick up the DNS changes in between.
+func (us *URLsValue) Resolve(ctx context.Context) (types.URLs, error) {
+	return resolveURLs(ctx, *us)
+}
+
+func resolveURLs(ctx context.Context, urls []url.URL) (types.URLs, error) {
+	var resolved types.URLs
+	seen := make(map[string]bool)
+	add := func(u url.URL) {
+		if !seen[u.String()] {
+			seen[u.String()] = true
+			resolved = append(resolved, u)
+		}
+	}
+	for _, u := range urls {
+		urls := []url.URL{u}
+		if u.Scheme == srvScheme {
+			var err error
+			if urls, err = resolveSRV(ctx, u.Host); err != nil {
+				return nil, err
+			}
+		}
+		for _, u := range urls {
+			addrs, err := resolveHost(ctx, u)
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range addrs {
+				add(addr)
+			}
+		}
+	}
+	resolved.Sort()
+	return resolved, nil
+}
+
+func resolveSRV(ctx context.Context, name string) ([]url.URL, error) {
+	_, srvs, err := lookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DNS SRV records for %s: %w", name, err)
+	}
+	scheme := "http"
+	if service := strings.SplitN(name, ".", 2)[0]; strings.HasSuffix(service, "-ssl") || strings.Contains(service, "-ssl-") {
+		scheme = "https"
+	}
+	urls := make([]url.URL, len(srvs))
+	for i, srv := range srvs {
+		// SRV records have a trailing dot but URL shouldn't.
+		host := strings.TrimSuffix(srv.Target, ".")
+		urls[i] = url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))}
+	}
+	return urls, nil
+}
+
+func resolveHost(ctx context.Context, u url.URL) ([]url.URL, error) {
+	if u.Scheme == "unix" || u.Scheme == "unixs" {
+		return []url.URL{u}, nil
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return []url.URL{u}, nil
+	}
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %w", host, err)
+	}
+	urls := make([]url.URL, len(addrs))
+	for i, addr := range addrs {
+		urls[i] = u
+		urls[i].Host = net.JoinHostPort(addr.String(), port)
+	}
+	return urls, nil
+}
+
 // String implements "flag.Value" interface.
 func (us *URLsValue) String() string {
 	all := make([]string, len(*us))
@@ -15,6 +15,9 @@
 package flags
 
 import (
+	"context"
+	"errors"
+	"net"
 	"net/url"
 	"reflect"
 	"testing"
@@ -35,6 +38,11 @@ func TestValidateURLsValueBad(t *testing.T) {
 		"file://foo/bar",
 		"http://hello/asdf",
 		"http://10.1.1.1",
+		// bad SRV names
+		"srv://example.com",
+		"srv://_etcd-server.example.com",
+		"srv://_etcd-server._tcp.example.com:2379",
+		"srv://_etcd-server._tcp.example.com/path",
 	}
 	for i, in := range tests {
 		u := URLsValue{}
@@ -63,6 +71,13 @@ func TestNewURLsValue(t *testing.T) {
 				{Scheme: "https", Host: "localhost:2"},
 			},
 		},
+		{
+			s: "srv://_etcd-server._tcp.example.com,http://localhost:1",
+			exp: []url.URL{
+				{Scheme: "http", Host: "localhost:1"},
+				{Scheme: "srv", Host: "_etcd-server._tcp.example.com"},
+			},
+		},
 	}
 	for i := range tests {
 		uu := []url.URL(*NewURLsValue(tests[i].s))
@@ -71,3 +86,72 @@ func TestNewURLsValue(t *testing.T) {
 		}
 	}
 }
+
+func TestURLsValueResolve(t *testing.T) {
+	defer func() {
+		lookupSRV = net.DefaultResolver.LookupSRV
+		lookupIPAddr = net.DefaultResolver.LookupIPAddr
+	}()
+	lookupSRV = func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		switch name {
+		case "_etcd-server-ssl._tcp.example.com":
+			return "", []*net.SRV{{Target: "1.example.com.", Port: 2380}, {Target: "2.example.com.", Port: 2380}}, nil
+		case "_etcd-server._tcp.example.com":
+			return "", []*net.SRV{{Target: "10.0.0.3", Port: 2380}}, nil
+		}
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "1.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("fd00::1")}}, nil
+		case "2.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.2")}}, nil
+		case "dual.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.4")}, {IP: net.ParseIP("fd00::4")}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	tests := []struct {
+		s    string
+		exp  []string
+		werr bool
+	}{
+		{
+			s:   "http://10.0.0.1:2379,http://:2379,unix://tmp/etcd.sock",
+			exp: []string{"http://10.0.0.1:2379", "http://:2379", "unix://tmp/etcd.sock"},
+		},
+		{
+			s:   "https://dual.example.com:2379",
+			exp: []string{"https://10.0.0.4:2379", "https://[fd00::4]:2379"},
+		},
+		{
+			s:   "srv://_etcd-server-ssl._tcp.example.com,https://10.0.0.1:2380",
+			exp: []string{"https://10.0.0.1:2380", "https://10.0.0.2:2380", "https://[fd00::1]:2380"},
+		},
+		{
+			s:   "srv://_etcd-server._tcp.example.com",
+			exp: []string{"http://10.0.0.3:2380"},
+		},
+		{s: "srv://_etcd-server._tcp.unknown.com", werr: true},
+		{s: "http://unknown.com:2379", werr: true},
+	}
+	for i, tt := range tests {
+		us := NewURLsValue(tt.s)
+		resolved, err := us.Resolve(context.Background())
+		if tt.werr {
+			var derr *net.DNSError
+			if !errors.As(err, &derr) {
+				t.Errorf("#%d: err = %v, want a DNS error", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(resolved.StringSlice(), tt.exp) {
+			t.Errorf("#%d: expected %v, got %v", i, tt.exp, resolved.StringSlice())
+		}
+	}
+}
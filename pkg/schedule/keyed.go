@@ -0,0 +1,112 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/client/pkg/v3/verify"
+)
+
+// KeyedScheduler runs jobs concurrently on a bounded number of workers,
+// except that jobs sharing a key run one after another, in the order they
+// were scheduled. Jobs that touch disjoint keys may run in any order.
+type KeyedScheduler struct {
+	lg  *zap.Logger
+	sem chan struct{}
+
+	mu sync.Mutex
+	// tails holds, per key, a channel closed once the last job scheduled
+	// with the key has finished.
+	tails map[string]chan struct{}
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewKeyedScheduler returns a KeyedScheduler that runs up to workers jobs
+// at a time.
+func NewKeyedScheduler(lg *zap.Logger, workers int) *KeyedScheduler {
+	verify.Assert(lg != nil, "the logger should not be nil")
+	verify.Assert(workers > 0, "the number of workers should be positive")
+
+	s := &KeyedScheduler{
+		lg:    lg,
+		sem:   make(chan struct{}, workers),
+		tails: make(map[string]chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Schedule schedules j to run once every job scheduled before it with any of
+// the given keys has finished.
+func (s *KeyedScheduler) Schedule(j Job, keys ...string) {
+	done := make(chan struct{})
+	var deps []chan struct{}
+	s.mu.Lock()
+	for _, k := range keys {
+		if d, ok := s.tails[k]; ok && d != done {
+			deps = append(deps, d)
+		}
+		s.tails[k] = done
+	}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for _, d := range deps {
+			<-d
+		}
+		s.sem <- struct{}{}
+		s.executeJob(j)
+		<-s.sem
+
+		s.mu.Lock()
+		for _, k := range keys {
+			if s.tails[k] == done {
+				delete(s.tails, k)
+			}
+		}
+		s.mu.Unlock()
+		close(done)
+	}()
+}
+
+// WaitFinish waits until all scheduled jobs are finished.
+func (s *KeyedScheduler) WaitFinish() {
+	s.wg.Wait()
+}
+
+// Stop cancels the context of the jobs and waits until they are finished.
+func (s *KeyedScheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *KeyedScheduler) executeJob(todo Job) {
+	defer func() {
+		if err := recover(); err != nil {
+			s.lg.Panic("execute job failed", zap.String("job", todo.Name()), zap.Any("panic", err))
+		}
+	}()
+
+	todo.Do(s.ctx)
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+func TestKeyedScheduleOrder(t *testing.T) {
+	s := NewKeyedScheduler(zaptest.NewLogger(t), 4)
+	defer s.Stop()
+
+	var mu sync.Mutex
+	next := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i%3)
+		i := i / 3
+		s.Schedule(NewJob(key, func(context.Context) {
+			mu.Lock()
+			defer mu.Unlock()
+			if next[key] != i {
+				t.Errorf("%s: job #%d ran after #%d", key, i, next[key]-1)
+			}
+			next[key] = i + 1
+		}), key, key)
+	}
+	s.WaitFinish()
+	if next["k0"] != 34 || next["k1"] != 33 || next["k2"] != 33 {
+		t.Errorf("finished = %v, want 34, 33 and 33", next)
+	}
+}
+
+func TestKeyedScheduleConcurrent(t *testing.T) {
+	s := NewKeyedScheduler(zaptest.NewLogger(t), 2)
+	defer s.Stop()
+
+	// a job on a disjoint key runs while the first one is blocked, a job
+	// sharing its key waits for it.
+	unblock := make(chan struct{})
+	s.Schedule(NewJob("a", func(context.Context) { <-unblock }), "a")
+	ranc := make(chan string, 2)
+	s.Schedule(NewJob("ab", func(context.Context) { ranc <- "ab" }), "a", "b")
+	s.Schedule(NewJob("c", func(context.Context) { ranc <- "c" }), "c")
+
+	select {
+	case r := <-ranc:
+		if r != "c" {
+			t.Fatalf("ran %q before the job it depends on", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job on a disjoint key did not run")
+	}
+	select {
+	case r := <-ranc:
+		t.Fatalf("ran %q before the job it depends on", r)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(unblock)
+	s.WaitFinish()
+	if r := <-ranc; r != "ab" {
+		t.Errorf("ran %q, want ab", r)
+	}
+}
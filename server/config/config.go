@@ -57,6 +57,13 @@ type ServerConfig struct {
 	// follower to catch up.
 	SnapshotCatchUpEntries uint64
 
+	// RaftLogMemoryBudget is the maximum number of bytes of raft log entries
+	// to keep in memory. The log is snapshotted and compacted once its
+	// entries exceed it, keeping fewer than SnapshotCatchUpEntries entries if
+	// needed; the followers lagging further behind catch up from the
+	// snapshot. Zero means no limit.
+	RaftLogMemoryBudget uint64
+
 	MaxSnapFiles uint
 	MaxWALFiles  uint
 
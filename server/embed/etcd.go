@@ -49,6 +49,7 @@ import (
 	"go.etcd.io/etcd/server/v3/etcdserver/api/etcdhttp"
 	"go.etcd.io/etcd/server/v3/etcdserver/api/rafthttp"
 	"go.etcd.io/etcd/server/v3/storage"
+	"go.etcd.io/etcd/server/v3/storage/storagecheck"
 	"go.etcd.io/etcd/server/v3/verify"
 )
 
@@ -174,6 +175,7 @@ func StartEtcd(inCfg *Config) (e *Etcd, err error) {
 		DedicatedWALDir:                          cfg.WalDir,
 		SnapshotCount:                            cfg.SnapshotCount,
 		SnapshotCatchUpEntries:                   cfg.SnapshotCatchUpEntries,
+		RaftLogMemoryBudget:                      cfg.RaftLogMemoryBudget,
 		MaxSnapFiles:                             cfg.MaxSnapFiles,
 		MaxWALFiles:                              cfg.MaxWalFiles,
 		InitialPeerURLsMap:                       urlsmap,
@@ -247,6 +249,12 @@ func StartEtcd(inCfg *Config) (e *Etcd, err error) {
 
 	print(e.cfg.logger, *cfg, srvcfg, memberInitialized)
 
+	if memberInitialized && cfg.ExperimentalInitialStorageCheck {
+		if err = checkStorage(srvcfg); err != nil {
+			return e, err
+		}
+	}
+
 	if e.Server, err = etcdserver.NewServer(srvcfg); err != nil {
 		return e, err
 	}
@@ -331,6 +339,7 @@ func print(lg *zap.Logger, ec Config, sc config.ServerConfig, memberInitialized
 		zap.Uint("max-wals", sc.MaxWALFiles),
 		zap.Uint("max-snapshots", sc.MaxSnapFiles),
 		zap.Uint64("snapshot-catchup-entries", sc.SnapshotCatchUpEntries),
+		zap.Uint64("raft-log-memory-budget", sc.RaftLogMemoryBudget),
 		zap.Strings("initial-advertise-peer-urls", ec.getAdvertisePeerURLs()),
 		zap.Strings("listen-peer-urls", ec.getListenPeerURLs()),
 		zap.Strings("advertise-client-urls", ec.getAdvertiseClientURLs()),
@@ -374,6 +383,38 @@ func print(lg *zap.Logger, ec Config, sc config.ServerConfig, memberInitialized
 	)
 }
 
+// checkStorage checks the storage of an initialized member before it starts,
+// see storagecheck.Check.
+func checkStorage(sc config.ServerConfig) error {
+	r, err := storagecheck.Check(storagecheck.Config{
+		WALDir:      sc.WALDir(),
+		SnapDir:     sc.SnapDir(),
+		BackendPath: sc.BackendPath(),
+		Logger:      sc.Logger,
+	})
+	if err != nil {
+		sc.Logger.Error("failed to check storage", zap.String("data-dir", sc.DataDir), zap.Error(err))
+		return err
+	}
+	for _, v := range r.Violations {
+		sc.Logger.Error("storage check failed",
+			zap.String("data-dir", sc.DataDir),
+			zap.String("check", v.Check),
+			zap.Error(v.Err),
+			zap.String("remediation", v.Remediation),
+		)
+	}
+	if err = r.Err(); err != nil {
+		return err
+	}
+	sc.Logger.Info("storage check passed",
+		zap.String("data-dir", sc.DataDir),
+		zap.Uint64("wal-last-index", r.WALLastIndex),
+		zap.Uint64("consistent-index", r.ConsistentIndex),
+	)
+	return nil
+}
+
 // Config returns the current configuration.
 func (e *Etcd) Config() Config {
 	return e.cfg
@@ -17,9 +17,11 @@
 package etcdmain
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"runtime"
 	"time"
@@ -34,6 +36,9 @@ import (
 	"go.etcd.io/etcd/server/v3/etcdserver/api/rafthttp"
 )
 
+// resolveListenURLsTimeout bounds the DNS lookups of --resolve-listen-urls.
+const resolveListenURLsTimeout = 10 * time.Second
+
 var (
 	fallbackFlagExit  = "exit"
 	fallbackFlagProxy = "proxy"
@@ -65,6 +70,9 @@ type config struct {
 	configFile   string
 	printVersion bool
 	ignored      []string
+	// resolveListenURLs expands the hostnames and the srv:// URLs of the
+	// listen URLs flags before they are bound to, see flags.UniqueURLs.Resolve.
+	resolveListenURLs bool
 }
 
 // configFlags has the set of flags used for command line parsing a Config
@@ -105,6 +113,7 @@ func newConfig() *config {
 	fs.Var(cfg.cf.clusterState, "initial-cluster-state", "Initial cluster state ('new' when bootstrapping a new cluster or 'existing' when adding new members to an existing cluster). After successful initialization (bootstrapping or adding), flag is ignored on restarts.")
 	fs.Var(cfg.cf.v2deprecation, "v2-deprecation", fmt.Sprintf("v2store deprecation stage: %q. ", cfg.cf.v2deprecation.Valids()))
 
+	fs.BoolVar(&cfg.resolveListenURLs, "resolve-listen-urls", false, "Listen on every address the hostnames of the listen URLs resolve to, IPv4 and IPv6 alike, and on the targets of their srv:// URLs.")
 	fs.BoolVar(&cfg.printVersion, "version", false, "Print the version and exit.")
 	// ignored
 	for _, f := range cfg.ignored {
@@ -207,6 +216,11 @@ func (cfg *config) configFromCmdLine() error {
 	cfg.ec.ListenClientHttpUrls = flags.UniqueURLsFromFlag(cfg.cf.flagSet, "listen-client-http-urls")
 	cfg.ec.AdvertiseClientUrls = flags.UniqueURLsFromFlag(cfg.cf.flagSet, "advertise-client-urls")
 	cfg.ec.ListenMetricsUrls = flags.UniqueURLsFromFlag(cfg.cf.flagSet, "listen-metrics-urls")
+	if cfg.resolveListenURLs {
+		if err = cfg.resolveListenURLsFromFlags(); err != nil {
+			return err
+		}
+	}
 
 	cfg.ec.DiscoveryCfg.Endpoints = flags.UniqueStringsFromFlag(cfg.cf.flagSet, "discovery-endpoints")
 
@@ -237,6 +251,26 @@ func (cfg *config) configFromCmdLine() error {
 	return cfg.validate()
 }
 
+// resolveListenURLsFromFlags sets the listen URLs to the resolved URLs of
+// their flags.
+func (cfg *config) resolveListenURLsFromFlags() error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveListenURLsTimeout)
+	defer cancel()
+	for name, urls := range map[string]*[]url.URL{
+		"listen-peer-urls":        &cfg.ec.ListenPeerUrls,
+		"listen-client-urls":      &cfg.ec.ListenClientUrls,
+		"listen-client-http-urls": &cfg.ec.ListenClientHttpUrls,
+		"listen-metrics-urls":     &cfg.ec.ListenMetricsUrls,
+	} {
+		resolved, err := flags.ResolvedUniqueURLsFromFlag(ctx, cfg.cf.flagSet, name)
+		if err != nil {
+			return fmt.Errorf("cannot resolve --%s: %w", name, err)
+		}
+		*urls = resolved
+	}
+	return nil
+}
+
 func (cfg *config) configFromFile(path string) error {
 	eCfg, err := embed.ConfigFromFile(path)
 	if err != nil {
@@ -69,6 +69,8 @@ Member:
     List of URLs to listen on for client grpc traffic and http as long as --listen-client-http-urls is not specified.
   --listen-client-http-urls ''
     List of URLs to listen on for http only client traffic. Enabling this flag removes http services from --listen-client-urls.
+  --resolve-listen-urls 'false'
+    Listen on every address the hostnames of the listen URLs resolve to, IPv4 and IPv6 alike, and on the targets of their srv:// URLs.
   --max-snapshots '` + strconv.Itoa(embed.DefaultMaxSnapshots) + `'
     Maximum number of snapshot files to retain (0 is unlimited).
   --max-wals '` + strconv.Itoa(embed.DefaultMaxWALs) + `'
@@ -269,6 +271,8 @@ Experimental distributed tracing:
 Experimental feature:
   --experimental-initial-corrupt-check 'false'
     Enable to check data corruption before serving any client/peer traffic.
+  --experimental-initial-storage-check 'false'
+    Enable to check the consistency of the WAL, snapshots and backend before starting the server.
   --experimental-corrupt-check-time '0s'
     Duration of time between cluster corruption check passes.
   --experimental-compact-hash-check-enabled 'false'
@@ -305,6 +309,8 @@ Experimental feature:
     Enable to enforce etcd pages (in particular bbolt) to stay in RAM.
   --experimental-snapshot-catchup-entries
     Number of entries for a slow follower to catch up after compacting the raft storage entries.
+  --experimental-raft-log-memory-budget '0'
+    Maximum number of bytes of raft log entries to keep in memory, beyond which the raft log is snapshotted and compacted. 0 means no limit.
   --experimental-stop-grpc-service-on-defrag
     Enable etcd gRPC service to stop serving client requests on defragmentation.
 
@@ -62,9 +62,10 @@ type pipeline struct {
 
 func (p *pipeline) start() {
 	p.stopc = make(chan struct{})
-	p.msgc = make(chan raftpb.Message, pipelineBufSize)
-	p.wg.Add(connPerPipeline)
-	for i := 0; i < connPerPipeline; i++ {
+	p.msgc = make(chan raftpb.Message, p.tr.pipelineBufSize())
+	conns := p.tr.pipelineConns()
+	p.wg.Add(conns)
+	for i := 0; i < conns; i++ {
 		go p.handle()
 	}
 
@@ -102,6 +102,32 @@ func TestPipelineExceedMaximumServing(t *testing.T) {
 	}
 }
 
+// TestPipelineConfiguredServing tests that the pipeline serves as many
+// messages as configured by the transport.
+func TestPipelineConfiguredServing(t *testing.T) {
+	rt := newRoundTripperBlocker()
+	picker := mustNewURLPicker(t, []string{"http://localhost:2380"})
+	tp := &Transport{pipelineRt: rt, PipelineConns: 2, PipelineBufSize: 3}
+	p := startTestPipeline(t, tp, picker)
+	defer func() {
+		rt.unblock()
+		p.stop()
+	}()
+
+	for i := 0; i < 2+3; i++ {
+		select {
+		case p.msgc <- raftpb.Message{}:
+		case <-time.After(time.Second):
+			t.Fatalf("failed to send out message %d", i)
+		}
+	}
+	select {
+	case p.msgc <- raftpb.Message{}:
+		t.Errorf("unexpected message sendout")
+	default:
+	}
+}
+
 // TestPipelineSendFailed tests that when send func meets the post error,
 // it increases fail count in stats.
 func TestPipelineSendFailed(t *testing.T) {
@@ -121,6 +121,8 @@ type streamWriter struct {
 	fs     *stats.FollowerStats
 	r      Raft
 
+	bufSize int // capacity of msgc
+
 	mu      sync.Mutex // guard field working and closer
 	closer  io.Closer
 	working bool
@@ -133,7 +135,7 @@ type streamWriter struct {
 
 // startStreamWriter creates a streamWrite and starts a long running go-routine that accepts
 // messages and writes to the attached outgoing connection.
-func startStreamWriter(lg *zap.Logger, local, id types.ID, status *peerStatus, fs *stats.FollowerStats, r Raft) *streamWriter {
+func startStreamWriter(lg *zap.Logger, local, id types.ID, status *peerStatus, fs *stats.FollowerStats, r Raft, bufSize int) *streamWriter {
 	w := &streamWriter{
 		lg: lg,
 
@@ -143,10 +145,12 @@ func startStreamWriter(lg *zap.Logger, local, id types.ID, status *peerStatus, f
 		status: status,
 		fs:     fs,
 		r:      r,
-		msgc:   make(chan raftpb.Message, streamBufSize),
-		connc:  make(chan *outgoingConn),
-		stopc:  make(chan struct{}),
-		done:   make(chan struct{}),
+
+		bufSize: bufSize,
+		msgc:    make(chan raftpb.Message, bufSize),
+		connc:   make(chan *outgoingConn),
+		stopc:   make(chan struct{}),
+		done:    make(chan struct{}),
 	}
 	go w.run()
 	return w
@@ -205,7 +209,7 @@ func (cw *streamWriter) run() {
 			if err == nil {
 				unflushed += m.Size()
 
-				if len(msgc) == 0 || batched > streamBufSize/2 {
+				if len(msgc) == 0 || batched > cw.bufSize/2 {
 					flusher.Flush()
 					sentBytes.WithLabelValues(cw.peerID.String()).Add(float64(unflushed))
 					unflushed = 0
@@ -331,7 +335,7 @@ func (cw *streamWriter) closeUnlocked() bool {
 	if len(cw.msgc) > 0 {
 		cw.r.ReportUnreachable(uint64(cw.peerID))
 	}
-	cw.msgc = make(chan raftpb.Message, streamBufSize)
+	cw.msgc = make(chan raftpb.Message, cw.bufSize)
 	cw.working = false
 	return true
 }
@@ -102,6 +102,17 @@ type Transport struct {
 	// a distinct rate limiter is created per every peer (default value: 10 events/sec)
 	DialRetryFrequency rate.Limit
 
+	// PipelineConns is the number of messages a pipeline sends to a peer
+	// concurrently (default value: 4).
+	PipelineConns int
+	// PipelineBufSize is the number of messages a pipeline buffers for a
+	// peer, which rides out short network stalls (default value: 64).
+	PipelineBufSize int
+	// StreamBufSize is the number of messages a stream buffers for a peer
+	// before dropping them; up to half of them are written between flushes
+	// (default value: 4096).
+	StreamBufSize int
+
 	TLSInfo transport.TLSInfo // TLS information used when creating connection
 
 	ID          types.ID   // local member ID
@@ -451,3 +462,24 @@ func (t *Transport) ActivePeers() (cnt int) {
 	}
 	return cnt
 }
+
+func (t *Transport) pipelineConns() int {
+	if t.PipelineConns > 0 {
+		return t.PipelineConns
+	}
+	return connPerPipeline
+}
+
+func (t *Transport) pipelineBufSize() int {
+	if t.PipelineBufSize > 0 {
+		return t.PipelineBufSize
+	}
+	return pipelineBufSize
+}
+
+func (t *Transport) streamBufSize() int {
+	if t.StreamBufSize > 0 {
+		return t.StreamBufSize
+	}
+	return streamBufSize
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A compressed snap file holds the marshaled raftpb.Snapshot in chunks, each
+// compressed with zstd and checked by the CRC of its data, so that neither
+// the whole snapshot nor its compressed form is needed at once to verify it:
+//
+//	file:  compressedMagic | chunk... | uint32 0
+//	chunk: data length uint32 | compressed length uint32 | compressed data | crc uint32
+//
+// The integers are big endian. The magic starts with a zero byte, which no
+// snappb.Snapshot of an uncompressed snap file starts with.
+const compressedMagic = "\x00snapzstd"
+
+// compressedChunkSize is the size of the data compressed in a chunk.
+var compressedChunkSize = 1024 * 1024
+
+var ErrTruncatedSnapshot = errors.New("snap: truncated compressed snapshot")
+
+func isCompressed(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(compressedMagic))
+}
+
+// compress frames the snapshot data b in compressed chunks.
+func compress(b []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+	d := []byte(compressedMagic)
+	for len(b) > 0 {
+		data := b[:min(len(b), compressedChunkSize)]
+		b = b[len(data):]
+		hdr := len(d)
+		d = append(d, make([]byte, 8)...)
+		d = enc.EncodeAll(data, d)
+		binary.BigEndian.PutUint32(d[hdr:], uint32(len(data)))
+		binary.BigEndian.PutUint32(d[hdr+4:], uint32(len(d)-hdr-8))
+		d = binary.BigEndian.AppendUint32(d, crc32.Checksum(data, crcTable))
+	}
+	return binary.BigEndian.AppendUint32(d, 0), nil
+}
+
+// decompress returns the snapshot data framed by compress in d, verifying
+// each chunk.
+func decompress(d []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	d = d[len(compressedMagic):]
+	var b []byte
+	for {
+		if len(d) < 4 {
+			return nil, ErrTruncatedSnapshot
+		}
+		n := binary.BigEndian.Uint32(d)
+		if n == 0 {
+			return b, nil
+		}
+		if n > MaxChunkSize {
+			return nil, ErrChunkSize
+		}
+		if len(d) < 8 {
+			return nil, ErrTruncatedSnapshot
+		}
+		zn := uint64(binary.BigEndian.Uint32(d[4:]))
+		d = d[8:]
+		if uint64(len(d)) < zn+4 {
+			return nil, ErrTruncatedSnapshot
+		}
+		data, err := dec.DecodeAll(d[:zn], make([]byte, 0, n))
+		if err != nil {
+			return nil, err
+		}
+		if uint32(len(data)) != n || crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(d[zn:]) {
+			return nil, ErrCRCMismatch
+		}
+		b = append(b, data...)
+		d = d[zn+4:]
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+func TestSaveAndLoadCompressed(t *testing.T) {
+	defer func(n int) { compressedChunkSize = n }(compressedChunkSize)
+	compressedChunkSize = 16
+
+	dir := t.TempDir()
+	ss := New(zaptest.NewLogger(t), dir, WithCompression())
+	snap := *testSnap
+	snap.Data = bytes.Repeat([]byte("some snapshot"), 100)
+	if err := ss.save(&snap); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isCompressed(b) {
+		t.Fatalf("snap file not compressed")
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, &snap) {
+		t.Errorf("snap = %#v, want %#v", g, &snap)
+	}
+}
+
+// TestLoadMixedCompression tests that a Snapshotter reads the snap files
+// whether they are compressed or not.
+func TestLoadMixedCompression(t *testing.T) {
+	dir := t.TempDir()
+	if err := New(zaptest.NewLogger(t), dir).save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	newSnap := *testSnap
+	newSnap.Metadata.Index = 5
+	if err := New(zaptest.NewLogger(t), dir, WithCompression()).save(&newSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ss := range []*Snapshotter{New(zaptest.NewLogger(t), dir), New(zaptest.NewLogger(t), dir, WithCompression())} {
+		for _, want := range []*raftpb.Snapshot{&newSnap, testSnap} {
+			g, err := Read(zaptest.NewLogger(t), filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, want.Metadata.Index)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g, want) {
+				t.Errorf("snap = %#v, want %#v", g, want)
+			}
+		}
+		if g, err := ss.Load(); err != nil || !reflect.DeepEqual(g, &newSnap) {
+			t.Errorf("Load() = %#v, %v, want %#v", g, err, &newSnap)
+		}
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	defer func(n int) { compressedChunkSize = n }(compressedChunkSize)
+	compressedChunkSize = 16
+
+	d, err := compress(bytes.Repeat([]byte("some snapshot"), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := bytes.Clone(d)
+	// in the CRC of the last chunk
+	flipped[len(flipped)-6] ^= 1
+
+	tests := []struct {
+		name string
+		d    []byte
+		werr error
+	}{
+		{"flipped", flipped, ErrCRCMismatch},
+		{"truncated chunk", d[:len(d)-6], ErrTruncatedSnapshot},
+		{"no end", d[:len(d)-4], ErrTruncatedSnapshot},
+		{"no chunk", []byte(compressedMagic), ErrTruncatedSnapshot},
+	}
+	for _, tt := range tests {
+		if _, err := decompress(tt.d); !errors.Is(err, tt.werr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.werr)
+		}
+	}
+}
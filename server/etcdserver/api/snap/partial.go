@@ -0,0 +1,137 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+)
+
+const partialSuffix = ".snap.part"
+
+// chunkHeaderSize is the size of the offset, length and CRC that frame the
+// data of a chunk.
+const chunkHeaderSize = 16
+
+var (
+	ErrChunkOffset = errors.New("snap: chunk does not continue the partial snapshot")
+	ErrChunkSize   = errors.New("snap: chunk larger than the maximum chunk size")
+)
+
+// MaxChunkSize bounds the data of a chunk read by ReadChunk.
+const MaxChunkSize = 64 * 1024 * 1024
+
+// WriteChunk writes data, which starts at offset in the snapshot data, to w
+// as a chunk framed by its offset, length and CRC.
+func WriteChunk(w io.Writer, offset uint64, data []byte) error {
+	var hdr [chunkHeaderSize]byte
+	binary.BigEndian.PutUint64(hdr[0:], offset)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(data)))
+	binary.BigEndian.PutUint32(hdr[12:], crc32.Checksum(data, crcTable))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// ReadChunk reads a chunk written by WriteChunk and verifies its CRC. It
+// returns io.EOF if r ends before the chunk.
+func ReadChunk(r io.Reader) (offset uint64, data []byte, err error) {
+	var hdr [chunkHeaderSize]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	offset = binary.BigEndian.Uint64(hdr[0:])
+	n := binary.BigEndian.Uint32(hdr[8:])
+	if n > MaxChunkSize {
+		return 0, nil, ErrChunkSize
+	}
+	data = make([]byte, n)
+	if _, err = io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(hdr[12:]) {
+		return 0, nil, ErrCRCMismatch
+	}
+	return offset, data, nil
+}
+
+// Partial is the data of a snapshot being received in chunks. The chunks are
+// persisted in the snapshot directory, so that an interrupted transfer can
+// resume from Size.
+type Partial struct {
+	f    *os.File
+	size uint64
+}
+
+// OpenPartial opens the partial data of the snapshot at the given term and
+// index, creating it if it does not exist.
+func (s *Snapshotter) OpenPartial(term, index uint64) (*Partial, error) {
+	f, err := os.OpenFile(s.partialPath(term, index), os.O_WRONLY|os.O_APPEND|os.O_CREATE, fileutil.PrivateFileMode)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Partial{f: f, size: uint64(fi.Size())}, nil
+}
+
+// Size returns the size of the data received so far.
+func (p *Partial) Size() uint64 { return p.size }
+
+// Write appends the data of a chunk starting at offset, which must be the
+// size of the data received so far.
+func (p *Partial) Write(offset uint64, data []byte) error {
+	if offset != p.size {
+		return fmt.Errorf("%w: offset %d, size %d", ErrChunkOffset, offset, p.size)
+	}
+	n, err := p.f.Write(data)
+	p.size += uint64(n)
+	if err != nil {
+		return err
+	}
+	return fileutil.Fsync(p.f)
+}
+
+func (p *Partial) Close() error { return p.f.Close() }
+
+// ReadPartial returns the data received for the snapshot at the given term
+// and index, and removes it.
+func (s *Snapshotter) ReadPartial(term, index uint64) ([]byte, error) {
+	fn := s.partialPath(term, index)
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return data, os.Remove(fn)
+}
+
+func (s *Snapshotter) partialPath(term, index uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016x-%016x%s", term, index, partialSuffix))
+}
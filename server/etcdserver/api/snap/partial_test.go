@@ -0,0 +1,101 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+)
+
+func TestChunk(t *testing.T) {
+	var buf bytes.Buffer
+	for i, data := range [][]byte{[]byte("foo"), {}, []byte("barbaz")} {
+		if err := WriteChunk(&buf, uint64(i), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, wdata := range []string{"foo", "", "barbaz"} {
+		offset, data, err := ReadChunk(&buf)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if offset != uint64(i) || string(data) != wdata {
+			t.Errorf("#%d: chunk = %d %q, want %d %q", i, offset, data, i, wdata)
+		}
+	}
+	if _, _, err := ReadChunk(&buf); err != io.EOF {
+		t.Errorf("err = %v, want %v", err, io.EOF)
+	}
+
+	WriteChunk(&buf, 0, []byte("foo"))
+	b := buf.Bytes()
+	b[len(b)-1] = 'x'
+	if _, _, err := ReadChunk(&buf); err != ErrCRCMismatch {
+		t.Errorf("err = %v, want %v", err, ErrCRCMismatch)
+	}
+
+	WriteChunk(&buf, 0, []byte("foo"))
+	buf.Truncate(buf.Len() - 1)
+	if _, _, err := ReadChunk(&buf); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestPartial(t *testing.T) {
+	ss := New(zaptest.NewLogger(t), t.TempDir())
+
+	p, err := ss.OpenPartial(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Write(0, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Write(0, []byte("foo")); !errors.Is(err, ErrChunkOffset) {
+		t.Fatalf("err = %v, want %v", err, ErrChunkOffset)
+	}
+	p.Close()
+
+	// resume after reopening
+	if p, err = ss.OpenPartial(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if p.Size() != 3 {
+		t.Fatalf("size = %d, want 3", p.Size())
+	}
+	if err = p.Write(3, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	data, err := ss.ReadPartial(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "foobar" {
+		t.Errorf("data = %q, want %q", data, "foobar")
+	}
+	if _, err = ss.ReadPartial(1, 2); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want the partial removed", err)
+	}
+	if _, err = ss.Load(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+}
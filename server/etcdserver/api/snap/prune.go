@@ -0,0 +1,168 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+)
+
+const brokenSuffix = snapSuffix + ".broken"
+
+var ErrKeepSnapshots = errors.New("snap: at least one snapshot must be kept")
+
+// PruneCandidates returns the names of the snap files Prune would remove,
+// all but the newest keep ones.
+func (s *Snapshotter) PruneCandidates(keep int) ([]string, error) {
+	if keep < 1 {
+		return nil, ErrKeepSnapshots
+	}
+	names, err := s.snapNames()
+	if err == ErrNoSnapshot {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(names) <= keep {
+		return nil, nil
+	}
+	return names[keep:], nil
+}
+
+// Prune removes the snap files but the newest keep ones, and returns the
+// names of the removed files.
+func (s *Snapshotter) Prune(keep int) ([]string, error) {
+	names, err := s.PruneCandidates(keep)
+	if err != nil {
+		return nil, err
+	}
+	return s.remove(names)
+}
+
+// Orphans returns the names of the files of the snapshot directory that are
+// older than the newest snapshot in walSnaps which can be read: the older
+// snap files, broken or not, the snapshots partially received before it, and
+// the .snap.db files of the older snapshots. It returns ErrNoSnapshot if no
+// snapshot in walSnaps can be read, in which case no file is an orphan.
+func (s *Snapshotter) Orphans(walSnaps []walpb.Snapshot) ([]string, error) {
+	index, err := s.newestAvailableIndex(walSnaps)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	for _, name := range names {
+		if i, ok := parseSnapIndex(name); ok && i < index {
+			orphans = append(orphans, name)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// CleanupOrphans removes the files returned by Orphans, and returns their
+// names.
+func (s *Snapshotter) CleanupOrphans(walSnaps []walpb.Snapshot) ([]string, error) {
+	names, err := s.Orphans(walSnaps)
+	if err != nil {
+		return nil, err
+	}
+	return s.remove(names)
+}
+
+// newestAvailableIndex returns the index of the newest snapshot in walSnaps
+// which can be read. Unlike LoadNewestAvailable, it does not rename the snap
+// files which cannot be read.
+func (s *Snapshotter) newestAvailableIndex(walSnaps []walpb.Snapshot) (uint64, error) {
+	names, err := s.snapNames()
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		term, index, ok := parseTermIndex(strings.TrimSuffix(name, snapSuffix))
+		if !ok {
+			continue
+		}
+		for _, ws := range walSnaps {
+			if ws.Term == term && ws.Index == index {
+				if _, err := Read(s.lg, filepath.Join(s.dir, name)); err == nil {
+					return index, nil
+				}
+				break
+			}
+		}
+	}
+	return 0, ErrNoSnapshot
+}
+
+// parseSnapIndex returns the snapshot index of a file of the snapshot
+// directory named after it: a snap file, broken or not, a partially received
+// snapshot or a .snap.db file.
+func parseSnapIndex(name string) (uint64, bool) {
+	if strings.HasSuffix(name, ".snap.db") {
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, ".snap.db"), 16, 64)
+		return index, err == nil
+	}
+	for _, suffix := range []string{snapSuffix, brokenSuffix, partialSuffix} {
+		if strings.HasSuffix(name, suffix) {
+			_, index, ok := parseTermIndex(strings.TrimSuffix(name, suffix))
+			return index, ok
+		}
+	}
+	return 0, false
+}
+
+// parseTermIndex parses the term and index a snapshot file is named after.
+func parseTermIndex(s string) (term, index uint64, ok bool) {
+	hexTerm, hexIndex, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	term, err := strconv.ParseUint(hexTerm, 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	index, err = strconv.ParseUint(hexIndex, 16, 64)
+	return term, index, err == nil
+}
+
+func (s *Snapshotter) remove(names []string) ([]string, error) {
+	var removed []string
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		s.lg.Info("removed snapshot file", zap.String("path", filepath.Join(s.dir, name)))
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
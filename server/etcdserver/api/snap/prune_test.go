@@ -0,0 +1,145 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+)
+
+// saveSnaps saves testSnap at each of indexes.
+func saveSnaps(t *testing.T, ss *Snapshotter, indexes ...uint64) {
+	t.Helper()
+	for _, index := range indexes {
+		snap := *testSnap
+		snap.Metadata.Index = index
+		if err := ss.save(&snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	ss := New(zaptest.NewLogger(t), dir)
+	saveSnaps(t, ss, 1, 2, 3, 4)
+
+	if _, err := ss.Prune(0); err != ErrKeepSnapshots {
+		t.Fatalf("Prune(0) err = %v, want %v", err, ErrKeepSnapshots)
+	}
+	wpruned := []string{"0000000000000001-0000000000000002.snap", "0000000000000001-0000000000000001.snap"}
+	candidates, err := ss.PruneCandidates(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(candidates, wpruned) {
+		t.Errorf("candidates = %v, want %v", candidates, wpruned)
+	}
+	if names := dirNames(t, dir); len(names) != 4 {
+		t.Errorf("PruneCandidates removed files, left %v", names)
+	}
+
+	pruned, err := ss.Prune(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pruned, wpruned) {
+		t.Errorf("pruned = %v, want %v", pruned, wpruned)
+	}
+	wnames := []string{"0000000000000001-0000000000000003.snap", "0000000000000001-0000000000000004.snap"}
+	if names := dirNames(t, dir); !reflect.DeepEqual(names, wnames) {
+		t.Errorf("names = %v, want %v", names, wnames)
+	}
+	if pruned, err = ss.Prune(2); err != nil || len(pruned) != 0 {
+		t.Errorf("Prune(2) = %v, %v, want nothing pruned", pruned, err)
+	}
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	dir := t.TempDir()
+	ss := New(zaptest.NewLogger(t), dir)
+	saveSnaps(t, ss, 1, 3, 5)
+	for _, name := range []string{
+		"db",
+		"0000000000000001-0000000000000002.snap.broken",
+		"0000000000000001-0000000000000004.snap.part",
+		"0000000000000001-0000000000000006.snap.part",
+		"0000000000000001.snap.db",
+		"0000000000000003.snap.db",
+		"0000000000000006.snap.db",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the snapshot at index 5 was not recorded in the WAL
+	walSnaps := []walpb.Snapshot{{Index: 0, Term: 0}, {Index: 1, Term: 1}, {Index: 3, Term: 1}}
+	worphans := []string{
+		"0000000000000001-0000000000000001.snap",
+		"0000000000000001-0000000000000002.snap.broken",
+		"0000000000000001.snap.db",
+	}
+
+	orphans, err := ss.Orphans(walSnaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(orphans, worphans) {
+		t.Errorf("orphans = %v, want %v", orphans, worphans)
+	}
+	if names := dirNames(t, dir); len(names) != 10 {
+		t.Errorf("Orphans removed files, left %v", names)
+	}
+
+	removed, err := ss.CleanupOrphans(walSnaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, worphans) {
+		t.Errorf("removed = %v, want %v", removed, worphans)
+	}
+	for _, name := range dirNames(t, dir) {
+		for _, orphan := range worphans {
+			if name == orphan {
+				t.Errorf("orphan %s not removed", name)
+			}
+		}
+	}
+
+	if _, err := ss.Orphans([]walpb.Snapshot{{Index: 7, Term: 1}}); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+}
@@ -53,16 +53,32 @@ var (
 type Snapshotter struct {
 	lg  *zap.Logger
 	dir string
+	// compress writes the snap files compressed, see compress.
+	compress bool
 }
 
-func New(lg *zap.Logger, dir string) *Snapshotter {
+// Option configures a Snapshotter.
+type Option func(*Snapshotter)
+
+// WithCompression makes the Snapshotter write zstd-compressed snap files.
+// The snap files are read whether they are compressed or not, but the older
+// releases cannot read the compressed ones.
+func WithCompression() Option {
+	return func(s *Snapshotter) { s.compress = true }
+}
+
+func New(lg *zap.Logger, dir string, opts ...Option) *Snapshotter {
 	if lg == nil {
 		lg = zap.NewNop()
 	}
-	return &Snapshotter{
+	s := &Snapshotter{
 		lg:  lg,
 		dir: dir,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Snapshotter) SaveSnap(snapshot raftpb.Snapshot) error {
@@ -77,9 +93,15 @@ func (s *Snapshotter) save(snapshot *raftpb.Snapshot) error {
 
 	fname := fmt.Sprintf("%016x-%016x%s", snapshot.Metadata.Term, snapshot.Metadata.Index, snapSuffix)
 	b := pbutil.MustMarshal(snapshot)
-	crc := crc32.Update(0, crcTable, b)
-	snap := snappb.Snapshot{Crc: crc, Data: b}
-	d, err := snap.Marshal()
+	var d []byte
+	var err error
+	if s.compress {
+		d, err = compress(b)
+	} else {
+		crc := crc32.Update(0, crcTable, b)
+		snap := snappb.Snapshot{Crc: crc, Data: b}
+		d, err = snap.Marshal()
+	}
 	if err != nil {
 		return err
 	}
@@ -166,29 +188,41 @@ func Read(lg *zap.Logger, snapname string) (*raftpb.Snapshot, error) {
 		return nil, ErrEmptySnapshot
 	}
 
-	var serializedSnap snappb.Snapshot
-	if err = serializedSnap.Unmarshal(b); err != nil {
-		lg.Warn("failed to unmarshal snappb.Snapshot", zap.String("path", snapname), zap.Error(err))
-		return nil, err
-	}
+	if isCompressed(b) {
+		if b, err = decompress(b); err != nil {
+			lg.Warn("failed to decompress snap file", zap.String("path", snapname), zap.Error(err))
+			return nil, err
+		}
+		if len(b) == 0 {
+			lg.Warn("failed to read empty snapshot data", zap.String("path", snapname))
+			return nil, ErrEmptySnapshot
+		}
+	} else {
+		var serializedSnap snappb.Snapshot
+		if err = serializedSnap.Unmarshal(b); err != nil {
+			lg.Warn("failed to unmarshal snappb.Snapshot", zap.String("path", snapname), zap.Error(err))
+			return nil, err
+		}
 
-	if len(serializedSnap.Data) == 0 || serializedSnap.Crc == 0 {
-		lg.Warn("failed to read empty snapshot data", zap.String("path", snapname))
-		return nil, ErrEmptySnapshot
-	}
+		if len(serializedSnap.Data) == 0 || serializedSnap.Crc == 0 {
+			lg.Warn("failed to read empty snapshot data", zap.String("path", snapname))
+			return nil, ErrEmptySnapshot
+		}
 
-	crc := crc32.Update(0, crcTable, serializedSnap.Data)
-	if crc != serializedSnap.Crc {
-		lg.Warn("snap file is corrupt",
-			zap.String("path", snapname),
-			zap.Uint32("prev-crc", serializedSnap.Crc),
-			zap.Uint32("new-crc", crc),
-		)
-		return nil, ErrCRCMismatch
+		crc := crc32.Update(0, crcTable, serializedSnap.Data)
+		if crc != serializedSnap.Crc {
+			lg.Warn("snap file is corrupt",
+				zap.String("path", snapname),
+				zap.Uint32("prev-crc", serializedSnap.Crc),
+				zap.Uint32("new-crc", crc),
+			)
+			return nil, ErrCRCMismatch
+		}
+		b = serializedSnap.Data
 	}
 
 	var snap raftpb.Snapshot
-	if err = snap.Unmarshal(serializedSnap.Data); err != nil {
+	if err = snap.Unmarshal(b); err != nil {
 		lg.Warn("failed to unmarshal raftpb.Snapshot", zap.String("path", snapname), zap.Error(err))
 		return nil, err
 	}
@@ -226,8 +260,9 @@ func (s *Snapshotter) checkSuffix(names []string) []string {
 			snaps = append(snaps, names[i])
 		} else {
 			// If we find a file which is not a snapshot then check if it's
-			// a valid file. If not throw out a warning.
-			if _, ok := validFiles[names[i]]; !ok {
+			// a valid file or a partially received snapshot. If not throw
+			// out a warning.
+			if _, ok := validFiles[names[i]]; !ok && !strings.HasSuffix(names[i], partialSuffix) {
 				s.lg.Warn("found unexpected non-snap file; skipping", zap.String("path", names[i]))
 			}
 		}
@@ -0,0 +1,155 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/server/v3/storage/wal"
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+)
+
+var ErrSnapshotNameMismatch = errors.New("snap: snapshot metadata does not match its file name")
+
+// SnapshotStatus is the result of the verification of a snap file.
+type SnapshotStatus struct {
+	Name string
+	// Term and Index are read from the snapshot metadata, or parsed from the
+	// file name if the snapshot cannot be read.
+	Term  uint64
+	Index uint64
+	// InWAL reports whether the WAL holds a valid record of the snapshot.
+	InWAL bool
+	// Err is the reason the snapshot cannot be loaded, nil if it can.
+	Err error
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	// Snapshots holds the status of the snap files, newest first.
+	Snapshots []SnapshotStatus
+	// Missing holds the snapshot records of the WAL, newer than Available,
+	// that have no snap file.
+	Missing []walpb.Snapshot
+	// Available is the newest snapshot that is both readable and recorded in
+	// the WAL, the one a node restarts from; nil if there is none.
+	Available *walpb.Snapshot
+}
+
+// OK reports whether every snap file can be read and no snapshot recorded
+// in the WAL after Available is missing.
+func (r *VerifyReport) OK() bool {
+	for _, st := range r.Snapshots {
+		if st.Err != nil {
+			return false
+		}
+	}
+	return len(r.Missing) == 0
+}
+
+// Verify checks the snap files in dir without modifying it: their CRCs,
+// that their snapshots can be decoded, and that their metadata matches both
+// their file names and the snapshot records of the WAL in walDir, as
+// returned by wal.ValidSnapshotEntries. Unlike Load it neither renames the
+// broken files nor removes the orphaned ones.
+func Verify(lg *zap.Logger, dir, walDir string) (*VerifyReport, error) {
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	walSnaps, err := wal.ValidSnapshotEntries(lg, walDir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := readSnapNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	inWAL := func(term, index uint64) bool {
+		for _, ws := range walSnaps {
+			if ws.Term == term && ws.Index == index {
+				return true
+			}
+		}
+		return false
+	}
+	r := &VerifyReport{}
+	for _, name := range names {
+		st := SnapshotStatus{Name: name}
+		term, index, ok := parseTermIndex(strings.TrimSuffix(name, snapSuffix))
+		snap, err := Read(lg, filepath.Join(dir, name))
+		switch {
+		case err != nil:
+			st.Term, st.Index, st.Err = term, index, err
+		case !ok || snap.Metadata.Term != term || snap.Metadata.Index != index:
+			st.Term, st.Index = snap.Metadata.Term, snap.Metadata.Index
+			st.Err = fmt.Errorf("%w: term %d, index %d", ErrSnapshotNameMismatch, st.Term, st.Index)
+		default:
+			st.Term, st.Index = term, index
+		}
+		st.InWAL = inWAL(st.Term, st.Index)
+		if st.Err == nil && st.InWAL && r.Available == nil {
+			r.Available = &walpb.Snapshot{Term: st.Term, Index: st.Index}
+		}
+		r.Snapshots = append(r.Snapshots, st)
+	}
+
+	for _, ws := range walSnaps {
+		// the first record of a WAL is the empty snapshot
+		if ws.Index == 0 || r.Available != nil && ws.Index <= r.Available.Index {
+			continue
+		}
+		found := false
+		for _, st := range r.Snapshots {
+			if st.Term == ws.Term && st.Index == ws.Index {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.Missing = append(r.Missing, walpb.Snapshot{Term: ws.Term, Index: ws.Index})
+		}
+	}
+	return r, nil
+}
+
+// readSnapNames returns the names of the snap files in dir, newest first.
+// Unlike snapNames, it does not clean up the directory.
+func readSnapNames(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var snaps []string
+	for _, name := range names {
+		if strings.HasSuffix(name, snapSuffix) {
+			snaps = append(snaps, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(snaps)))
+	return snaps, nil
+}
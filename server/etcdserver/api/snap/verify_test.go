@@ -0,0 +1,103 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/wal"
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+func TestVerify(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	dir, walDir := t.TempDir(), filepath.Join(t.TempDir(), "wal")
+
+	w, err := wal.Create(lg, walDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []uint64{1, 3, 5, 6, 7} {
+		if err = w.SaveSnapshot(walpb.Snapshot{Term: 1, Index: index, ConfState: &testSnap.Metadata.ConfState}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Save(raftpb.HardState{Term: 1, Commit: 7}, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	ss := New(lg, dir)
+	// the snapshot at index 9 is not recorded in the WAL
+	saveSnaps(t, ss, 1, 3, 4, 5, 9)
+	// corrupt the snapshot at index 3
+	path3 := filepath.Join(dir, "0000000000000001-0000000000000003.snap")
+	b, err := os.ReadFile(path3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 1
+	if err = os.WriteFile(path3, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	// the snapshot at index 4 is named after index 6
+	if err = os.Rename(filepath.Join(dir, "0000000000000001-0000000000000004.snap"), filepath.Join(dir, "0000000000000001-0000000000000006.snap")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Verify(lg, dir, walDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsts := []SnapshotStatus{
+		{Name: "0000000000000001-0000000000000009.snap", Term: 1, Index: 9},
+		{Name: "0000000000000001-0000000000000006.snap", Term: 1, Index: 4},
+		{Name: "0000000000000001-0000000000000005.snap", Term: 1, Index: 5, InWAL: true},
+		{Name: "0000000000000001-0000000000000003.snap", Term: 1, Index: 3, InWAL: true},
+		{Name: "0000000000000001-0000000000000001.snap", Term: 1, Index: 1, InWAL: true},
+	}
+	if len(r.Snapshots) != len(wsts) {
+		t.Fatalf("len(Snapshots) = %d, want %d", len(r.Snapshots), len(wsts))
+	}
+	werrs := []error{nil, ErrSnapshotNameMismatch, nil, ErrCRCMismatch, nil}
+	for i, st := range r.Snapshots {
+		if !errors.Is(st.Err, werrs[i]) || (st.Err == nil) != (werrs[i] == nil) {
+			t.Errorf("#%d: err = %v, want %v", i, st.Err, werrs[i])
+		}
+		st.Err = nil
+		if st != wsts[i] {
+			t.Errorf("#%d: status = %+v, want %+v", i, st, wsts[i])
+		}
+	}
+	if want := (&walpb.Snapshot{Term: 1, Index: 5}); !reflect.DeepEqual(r.Available, want) {
+		t.Errorf("Available = %v, want %v", r.Available, want)
+	}
+	if want := []walpb.Snapshot{{Term: 1, Index: 6}, {Term: 1, Index: 7}}; !reflect.DeepEqual(r.Missing, want) {
+		t.Errorf("Missing = %v, want %v", r.Missing, want)
+	}
+	if r.OK() {
+		t.Errorf("OK() = true, want false")
+	}
+	if names := dirNames(t, dir); len(names) != 5 {
+		t.Errorf("Verify modified the snapshot directory: %v", names)
+	}
+}
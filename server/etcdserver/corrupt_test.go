@@ -444,6 +444,8 @@ type fakeHasher struct {
 	peerHashes             []*peerHashKVResp
 	hashByRevIndex         int
 	hashByRevResponses     []hashByRev
+	hashByRangeIndex       int
+	hashByRangeResponses   []hashByRev
 	linearizableReadNotify error
 	hashes                 []mvcc.KeyValueHash
 
@@ -491,6 +493,16 @@ func (f *fakeHasher) MemberID() types.ID {
 	return 1
 }
 
+func (f *fakeHasher) HashByRange(key, end []byte, rev int64) (hash mvcc.KeyValueHash, revision int64, err error) {
+	f.actions = append(f.actions, fmt.Sprintf("HashByRange(%q, %q, %d)", key, end, rev))
+	if len(f.hashByRangeResponses) == 0 {
+		return mvcc.KeyValueHash{}, 0, nil
+	}
+	hashByRange := f.hashByRangeResponses[f.hashByRangeIndex]
+	f.hashByRangeIndex++
+	return hashByRange.hash, hashByRange.revision, hashByRange.err
+}
+
 func (f *fakeHasher) PeerHashByRev(rev int64) []*peerHashKVResp {
 	f.actions = append(f.actions, fmt.Sprintf("PeerHashByRev(%d)", rev))
 	return f.peerHashes
@@ -1188,7 +1188,62 @@ func (s *EtcdServer) triggerSnapshot(ep *etcdProgress) {
 }
 
 func (s *EtcdServer) shouldSnapshot(ep *etcdProgress) bool {
-	return (s.forceSnapshot && ep.appliedi != ep.snapi) || (ep.appliedi-ep.snapi > s.Cfg.SnapshotCount)
+	return (s.forceSnapshot && ep.appliedi != ep.snapi) || (ep.appliedi-ep.snapi > s.Cfg.SnapshotCount) ||
+		(ep.appliedi != ep.snapi && s.raftLogOverBudget(ep.snapi))
+}
+
+// raftLogOverBudget returns whether the raft log entries after snapi
+// exceed the RaftLogMemoryBudget, so that the log cannot be compacted
+// within it without a newer snapshot.
+func (s *EtcdServer) raftLogOverBudget(snapi uint64) bool {
+	if s.Cfg.RaftLogMemoryBudget == 0 {
+		return false
+	}
+	last, _ := s.r.raftStorage.LastIndex()
+	if last <= snapi {
+		return false
+	}
+	ents, err := s.r.raftStorage.Entries(snapi+1, last+1, s.Cfg.RaftLogMemoryBudget)
+	if err != nil || uint64(len(ents)) == last-snapi {
+		return false
+	}
+	// Entries returns at least one entry even when it alone exceeds the
+	// budget; snapshotting more often cannot bring such an entry within it.
+	return len(ents) > 1 || uint64(ents[0].Size()) <= s.Cfg.RaftLogMemoryBudget
+}
+
+// raftLogCompactIndex returns the index to compact the raft log to after a
+// snapshot at snapi. SnapshotCatchUpEntries entries are kept for the slow
+// followers, fewer if they exceed the RaftLogMemoryBudget; the followers
+// lagging further behind catch up from the snapshot.
+func (s *EtcdServer) raftLogCompactIndex(snapi uint64) uint64 {
+	compacti := uint64(1)
+	if snapi > s.Cfg.SnapshotCatchUpEntries {
+		compacti = snapi - s.Cfg.SnapshotCatchUpEntries
+	}
+	if s.Cfg.RaftLogMemoryBudget == 0 {
+		return compacti
+	}
+
+	first, _ := s.r.raftStorage.FirstIndex()
+	last, _ := s.r.raftStorage.LastIndex()
+	lo := max(compacti+1, first)
+	if lo > last {
+		return compacti
+	}
+	ents, err := s.r.raftStorage.Entries(lo, last+1, math.MaxUint64)
+	if err != nil {
+		return compacti
+	}
+	var size uint64
+	for i := len(ents) - 1; i >= 0; i-- {
+		size += uint64(ents[i].Size())
+		if size > s.Cfg.RaftLogMemoryBudget {
+			// the entries after snapi are not in the snapshot.
+			return min(ents[i].Index, snapi)
+		}
+	}
+	return compacti
 }
 
 func (s *EtcdServer) hasMultipleVotingMembers() bool {
@@ -2160,11 +2215,7 @@ func (s *EtcdServer) snapshot(snapi uint64, confState raftpb.ConfState) {
 		}
 
 		// keep some in memory log entries for slow followers.
-		compacti := uint64(1)
-		if snapi > s.Cfg.SnapshotCatchUpEntries {
-			compacti = snapi - s.Cfg.SnapshotCatchUpEntries
-		}
-
+		compacti := s.raftLogCompactIndex(snapi)
 		err = s.r.raftStorage.Compact(compacti)
 		if err != nil {
 			// the compaction was done asynchronously with the progress of raft.
@@ -654,6 +654,48 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
+func TestRaftLogMemoryBudget(t *testing.T) {
+	rs := raft.NewMemoryStorage()
+	data := make([]byte, 90)
+	var ents []raftpb.Entry
+	for i := uint64(1); i <= 10; i++ {
+		ents = append(ents, raftpb.Entry{Index: i, Term: 1, Data: data})
+	}
+	rs.Append(ents)
+	size := uint64(ents[0].Size())
+
+	tests := []struct {
+		budget   uint64
+		snapi    uint64
+		wcompact uint64
+		wover    bool
+	}{
+		// the catch-up entries are kept without a budget.
+		{0, 8, 6, false},
+		{10 * size, 8, 6, false},
+		// entries 7 and 8 exceed the budget of 3 entries.
+		{3 * size, 8, 7, false},
+		// entries 9 and 10 are not snapshotted, so the log is over budget.
+		{size, 8, 8, true},
+		// a single entry exceeding the budget does not trigger a snapshot.
+		{size / 2, 8, 8, false},
+	}
+	for i, tt := range tests {
+		srv := &EtcdServer{
+			lgMu: new(sync.RWMutex),
+			lg:   zaptest.NewLogger(t),
+			r:    raftNode{raftNodeConfig: raftNodeConfig{raftStorage: rs}},
+			Cfg:  config.ServerConfig{SnapshotCount: 100, SnapshotCatchUpEntries: 2, RaftLogMemoryBudget: tt.budget},
+		}
+		if compacti := srv.raftLogCompactIndex(tt.snapi); compacti != tt.wcompact {
+			t.Errorf("#%d: compact index = %d, want %d", i, compacti, tt.wcompact)
+		}
+		if over := srv.shouldSnapshot(&etcdProgress{appliedi: 10, snapi: tt.snapi}); over != tt.wover {
+			t.Errorf("#%d: should snapshot = %v, want %v", i, over, tt.wover)
+		}
+	}
+}
+
 // TestSnapshotOrdering ensures raft persists snapshot onto disk before
 // snapshot db is applied.
 func TestSnapshotOrdering(t *testing.T) {
@@ -42,14 +42,11 @@ func Put(ctx context.Context, lg *zap.Logger, lessor lease.Lessor, kv mvcc.KV, p
 		)
 		ctx = context.WithValue(ctx, traceutil.TraceKey{}, trace)
 	}
-	leaseID := lease.LeaseID(p.Lease)
-	if leaseID != lease.NoLease {
-		if l := lessor.Lookup(leaseID); l == nil {
-			return nil, nil, lease.ErrLeaseNotFound
-		}
-	}
 	txnWrite := kv.Write(trace)
 	defer txnWrite.End()
+	if err = checkPut(txnWrite, kv, lessor, p); err != nil {
+		return nil, nil, err
+	}
 	resp, err = put(ctx, txnWrite, p)
 	return resp, trace, err
 }
@@ -270,7 +267,7 @@ func Txn(ctx context.Context, lg *zap.Logger, rt *pb.TxnRequest, txnModeWriteWit
 	if isWrite {
 		trace.AddField(traceutil.Field{Key: "read_only", Value: false})
 	}
-	_, err := checkTxn(txnRead, rt, lessor, txnPath)
+	_, err := checkTxn(txnRead, kv, rt, lessor, txnPath)
 	if err != nil {
 		txnRead.End()
 		return nil, nil, err
@@ -404,7 +401,8 @@ func executeTxn(ctx context.Context, lg *zap.Logger, txnWrite mvcc.TxnWrite, rt
 	return txns, nil
 }
 
-func checkPut(rv mvcc.ReadView, lessor lease.Lessor, req *pb.PutRequest) error {
+func checkPut(rv mvcc.ReadView, kv mvcc.KV, lessor lease.Lessor, req *pb.PutRequest) error {
+	val := req.Value
 	if req.IgnoreValue || req.IgnoreLease {
 		// expects previous key-value, error if not exist
 		rr, err := rv.Range(context.TODO(), req.Key, nil, mvcc.RangeOptions{})
@@ -414,6 +412,13 @@ func checkPut(rv mvcc.ReadView, lessor lease.Lessor, req *pb.PutRequest) error {
 		if rr == nil || len(rr.KVs) == 0 {
 			return errors.ErrKeyNotFound
 		}
+		if req.IgnoreValue {
+			val = rr.KVs[0].Value
+		}
+	}
+	// a failed put would leave the earlier requests of a txn applied
+	if err := kv.CheckPutSize(req.Key, val); err != nil {
+		return err
 	}
 	if lease.LeaseID(req.Lease) != lease.NoLease {
 		if l := lessor.Lookup(lease.LeaseID(req.Lease)); l == nil {
@@ -435,7 +440,7 @@ func checkRange(rv mvcc.ReadView, req *pb.RangeRequest) error {
 	return nil
 }
 
-func checkTxn(rv mvcc.ReadView, rt *pb.TxnRequest, lessor lease.Lessor, txnPath []bool) (int, error) {
+func checkTxn(rv mvcc.ReadView, kv mvcc.KV, rt *pb.TxnRequest, lessor lease.Lessor, txnPath []bool) (int, error) {
 	txnCount := 0
 	reqs := rt.Success
 	if !txnPath[0] {
@@ -448,10 +453,10 @@ func checkTxn(rv mvcc.ReadView, rt *pb.TxnRequest, lessor lease.Lessor, txnPath
 		case *pb.RequestOp_RequestRange:
 			err = checkRange(rv, tv.RequestRange)
 		case *pb.RequestOp_RequestPut:
-			err = checkPut(rv, lessor, tv.RequestPut)
+			err = checkPut(rv, kv, lessor, tv.RequestPut)
 		case *pb.RequestOp_RequestDeleteRange:
 		case *pb.RequestOp_RequestTxn:
-			txns, err = checkTxn(rv, tv.RequestTxn, lessor, txnPath[1:])
+			txns, err = checkTxn(rv, kv, tv.RequestTxn, lessor, txnPath[1:])
 			txnCount += txns + 1
 			txnPath = txnPath[txns+1:]
 		default:
@@ -46,6 +46,8 @@ type testSetup struct {
 	compactRevision int64
 	lease           int64
 	key             []byte
+	// maxBytes limits the size of the keys and the values of the store.
+	maxBytes int
 }
 
 var futureRev int64 = 1000
@@ -151,6 +153,31 @@ var putTestCases = []testCase{
 			},
 		},
 	},
+	{
+		name:  "Put with too large key should fail",
+		setup: testSetup{maxBytes: 3},
+		op: &pb.RequestOp{
+			Request: &pb.RequestOp_RequestPut{
+				RequestPut: &pb.PutRequest{
+					Key: []byte("large"),
+				},
+			},
+		},
+		expectError: "mvcc: key exceeds the size limit",
+	},
+	{
+		name:  "Put with too large value should fail",
+		setup: testSetup{maxBytes: 3},
+		op: &pb.RequestOp{
+			Request: &pb.RequestOp_RequestPut{
+				RequestPut: &pb.PutRequest{
+					Key:   []byte("foo"),
+					Value: []byte("large"),
+				},
+			},
+		},
+		expectError: "mvcc: value exceeds the size limit",
+	},
 	{
 		name:  "Put with ignore lease with previous key should succeed ",
 		setup: testSetup{key: []byte("ignore-lease")},
@@ -284,7 +311,7 @@ func setup(t *testing.T, setup testSetup) (mvcc.KV, lease.Lessor) {
 		betesting.Close(t, b)
 	})
 	lessor := &lease.FakeLessor{LeaseSet: map[lease.LeaseID]struct{}{}}
-	s := mvcc.NewStore(zaptest.NewLogger(t), b, lessor, mvcc.StoreConfig{})
+	s := mvcc.NewStore(zaptest.NewLogger(t), b, lessor, mvcc.StoreConfig{MaxKeyBytes: setup.maxBytes, MaxValueBytes: setup.maxBytes})
 	t.Cleanup(func() {
 		s.Close()
 	})
@@ -304,6 +331,21 @@ func setup(t *testing.T, setup testSetup) (mvcc.KV, lease.Lessor) {
 	return s, lessor
 }
 
+func TestWriteTxnTooLargePut(t *testing.T) {
+	s, lessor := setup(t, testSetup{maxBytes: 3})
+	rev := s.Rev()
+
+	txn := &pb.TxnRequest{
+		Success: []*pb.RequestOp{
+			{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: []byte("foo"), Value: []byte("bar")}}},
+			{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: []byte("foo"), Value: []byte("large")}}},
+		},
+	}
+	_, _, err := Txn(context.TODO(), zaptest.NewLogger(t), txn, false, s, lessor)
+	require.ErrorIs(t, err, mvcc.ErrValueTooLarge)
+	assert.Equal(t, rev, s.Rev(), "the txn must not be partially applied")
+}
+
 func TestReadonlyTxnError(t *testing.T) {
 	b, _ := betesting.NewDefaultTmpBackend(t)
 	defer betesting.Close(t, b)
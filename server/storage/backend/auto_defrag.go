@@ -0,0 +1,97 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// AutoDefragConfig configures the defragmentation of the backend once
+// enough of its space is free and the load is low.
+type AutoDefragConfig struct {
+	// CheckInterval is the period between two checks.
+	CheckInterval time.Duration
+	// Jitter is the maximum random delay added to every check, so that the
+	// members of a cluster do not defragment at the same time.
+	Jitter time.Duration
+	// FreeRatio is the ratio of free bytes to the db size above which the
+	// backend is defragmented.
+	FreeRatio float64
+	// MinFreeBytes is the number of free bytes below which the backend is
+	// not defragmented, whatever the free ratio.
+	MinFreeBytes int64
+	// LoadProbe returns the current load, which is compared to MaxLoad. A
+	// nil probe treats the backend as idle.
+	LoadProbe func() float64
+	// MaxLoad is the load above which defragmentation is postponed to the
+	// next check.
+	MaxLoad float64
+}
+
+const (
+	autoDefragDone     = "defragmented"
+	autoDefragLowFree  = "skipped_low_free"
+	autoDefragHighLoad = "skipped_high_load"
+	autoDefragFailed   = "failed"
+)
+
+func (b *backend) runAutoDefrag(cfg AutoDefragConfig) {
+	defer b.autoDefragWg.Done()
+	for {
+		wait := cfg.CheckInterval
+		if cfg.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(cfg.Jitter)))
+		}
+		select {
+		case <-time.After(wait):
+		case <-b.stopc:
+			return
+		}
+		autoDefragChecks.WithLabelValues(b.autoDefragOnce(cfg)).Inc()
+	}
+}
+
+// autoDefragOnce defragments the backend if the configured conditions are
+// met, and returns the outcome of the check.
+func (b *backend) autoDefragOnce(cfg AutoDefragConfig) string {
+	size, sizeInUse := b.Size(), b.SizeInUse()
+	free := size - sizeInUse
+	if size <= 0 || free < cfg.MinFreeBytes || float64(free)/float64(size) < cfg.FreeRatio {
+		return autoDefragLowFree
+	}
+	if cfg.LoadProbe != nil {
+		if load := cfg.LoadProbe(); load > cfg.MaxLoad {
+			b.lg.Debug(
+				"postponed automatic defragmentation",
+				zap.Float64("load", load),
+				zap.Float64("max-load", cfg.MaxLoad),
+			)
+			return autoDefragHighLoad
+		}
+	}
+	b.lg.Info(
+		"starting automatic defragmentation",
+		zap.Int64("db-size-bytes", size),
+		zap.Int64("db-size-in-use-bytes", sizeInUse),
+	)
+	if err := b.Defrag(); err != nil {
+		b.lg.Warn("failed to defragment automatically", zap.Error(err))
+		return autoDefragFailed
+	}
+	return autoDefragDone
+}
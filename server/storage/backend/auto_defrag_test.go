@@ -0,0 +1,84 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestBackendAutoDefrag(t *testing.T) {
+	var load atomic.Int64
+	load.Store(10)
+	var probes atomic.Int64
+
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.BatchInterval, bcfg.BatchLimit = time.Hour, 1000000
+	bcfg.AutoDefrag = &backend.AutoDefragConfig{
+		CheckInterval: 10 * time.Millisecond,
+		Jitter:        5 * time.Millisecond,
+		FreeRatio:     0.3,
+		LoadProbe: func() float64 {
+			probes.Add(1)
+			return float64(load.Load())
+		},
+		MaxLoad: 5,
+	}
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	for i := 0; i < 10000; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%05d", i)), make([]byte, 100))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+
+	tx.Lock()
+	for i := 0; i < 9000; i++ {
+		tx.UnsafeDelete(schema.Test, []byte(fmt.Sprintf("foo_%05d", i)))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+	// the freed pages are accounted for by the following commits, once the
+	// read transactions observing them are rolled back.
+	require.Eventually(t, func() bool {
+		tx.Lock()
+		tx.UnsafePut(schema.Test, []byte("bar"), []byte("baz"))
+		tx.Unlock()
+		b.ForceCommit()
+		return b.SizeInUse()*2 < b.Size()
+	}, time.Second, 5*time.Millisecond)
+	size := b.Size()
+
+	// the load is too high.
+	require.Eventually(t, func() bool { return probes.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, size, b.Size())
+
+	load.Store(0)
+	require.Eventually(t, func() bool { return b.Size() < size }, time.Second, 5*time.Millisecond)
+}
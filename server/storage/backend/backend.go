@@ -15,6 +15,7 @@
 package backend
 
 import (
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -43,6 +44,9 @@ var (
 
 	// minSnapshotWarningTimeout is the minimum threshold to trigger a long running snapshot warning.
 	minSnapshotWarningTimeout = 30 * time.Second
+
+	// ErrDefragUnsupported is returned by Defrag if the storage engine is not bbolt.
+	ErrDefragUnsupported = errors.New("backend: defragmentation is not supported by the storage engine")
 )
 
 type Backend interface {
@@ -73,6 +77,106 @@ type Backend interface {
 	SetTxPostLockInsideApplyHook(func())
 }
 
+// The interfaces below are optional capabilities of a Backend, implemented by
+// the backend returned by New. Users check for them with a type assertion.
+
+// BucketBatchTxer is a Backend providing write txs of a single bucket.
+type BucketBatchTxer interface {
+	// BucketBatchTx returns a write tx of a single bucket, which does not
+	// contend with the BatchTx or with the write txs of other buckets.
+	BucketBatchTx(bucket Bucket) BucketBatchTx
+}
+
+// IncrementalSnapshotter is a Backend providing incremental snapshots.
+type IncrementalSnapshotter interface {
+	// SnapshotSince returns a snapshot of the keys changed since the
+	// snapshot that returned the given marker. The snapshot is full unless
+	// the changes were tracked since the marker, see TrackSnapshotChanges.
+	SnapshotSince(m SnapshotMarker) IncrementalSnapshot
+	// TrackSnapshotChanges registers a consumer of incremental snapshots.
+	// The written keys are recorded from then on, until every consumer
+	// called its returned untrack function.
+	TrackSnapshotChanges() (untrack func())
+}
+
+// BackupWriter is a Backend writing paced backups.
+type BackupWriter interface {
+	// BackupTo writes a consistent copy of the backend into w, paced as
+	// configured by opts.
+	BackupTo(w io.Writer, opts BackupOptions) (int64, error)
+}
+
+// BucketHasher is a Backend hashing single buckets.
+type BucketHasher interface {
+	// HashBucket computes the hash of a single bucket.
+	HashBucket(bucket Bucket, ignores func(bucketName, keyName []byte) bool) (uint32, error)
+	// IncrementalHash returns the hash of a bucket listed in
+	// BackendConfig.HashedBuckets, maintained on writes.
+	IncrementalHash(bucket Bucket) (uint64, error)
+	// VerifyBucketHash checks the content of a bucket listed in
+	// BackendConfig.HashedBuckets against its incremental hash.
+	VerifyBucketHash(bucket Bucket) error
+}
+
+// DBStatser is a Backend reporting the statistics of its storage engine.
+type DBStatser interface {
+	// DBStats returns the freelist, page and transaction statistics of the
+	// storage engine.
+	DBStats() EngineStats
+}
+
+// ReadTxPinner is a Backend pinning views for later read txs.
+type ReadTxPinner interface {
+	// PinReadTx commits the pending writes and pins the resulting view for
+	// ReadTxAt until UnpinReadTx, returning its commit sequence.
+	PinReadTx() int64
+	// ReadTxAt returns a read tx observing the view pinned at commitSeq.
+	ReadTxAt(commitSeq int64) (ReadTx, error)
+	// UnpinReadTx releases a pin taken by PinReadTx.
+	UnpinReadTx(commitSeq int64)
+}
+
+// LongRunningReadTxLister is a Backend listing its long running read txs.
+type LongRunningReadTxLister interface {
+	// LongRunningReadTxs returns the concurrent read transactions open for
+	// longer than threshold. Read transactions are only tracked if
+	// BackendConfig.TrackReadTxs is set.
+	LongRunningReadTxs(threshold time.Duration) []ReadTxInfo
+}
+
+// WarmUpper is a Backend loading its pages into the page cache.
+type WarmUpper interface {
+	// WarmUp loads the pages of the given buckets, or of every bucket if
+	// none is given, into the page cache.
+	WarmUp(buckets ...Bucket) (int, error)
+}
+
+// BatchParamsSetter is a Backend whose batching is tunable at runtime.
+type BatchParamsSetter interface {
+	// SetBatchParams changes the batch interval and limit at runtime.
+	SetBatchParams(interval time.Duration, limit int)
+}
+
+// QuotaChecker is a Backend enforcing a quota.
+type QuotaChecker interface {
+	// QuotaExceeded reports whether the bytes in use, including the writes
+	// not committed yet, exceed the configured quota.
+	QuotaExceeded() bool
+}
+
+var (
+	_ BucketBatchTxer         = (*backend)(nil)
+	_ IncrementalSnapshotter  = (*backend)(nil)
+	_ BackupWriter            = (*backend)(nil)
+	_ BucketHasher            = (*backend)(nil)
+	_ DBStatser               = (*backend)(nil)
+	_ ReadTxPinner            = (*backend)(nil)
+	_ LongRunningReadTxLister = (*backend)(nil)
+	_ WarmUpper               = (*backend)(nil)
+	_ BatchParamsSetter       = (*backend)(nil)
+	_ QuotaChecker            = (*backend)(nil)
+)
+
 type Snapshot interface {
 	// Size gets the size of the snapshot.
 	Size() int64
@@ -102,13 +206,51 @@ type backend struct {
 	openReadTxN int64
 	// mlock prevents backend database file to be swapped
 	mlock bool
+	// snapshotID identifies this backend instance in snapshot markers.
+	snapshotID uint64
+	// pendingBytes is the number of key and value bytes put since the last commit
+	pendingBytes int64
+	// quotaExceeded is 1 while the quota is exceeded
+	quotaExceeded int32
+	quotaBytes    int64
+	// onQuotaExceeded is called when the quota becomes exceeded
+	onQuotaExceeded func(sizeInUse int64)
+	// codec compresses and encrypts the values put into the backend, nil if disabled
+	codec *valueCodec
 
 	mu    sync.RWMutex
 	bopts *bolt.Options
-	db    *bolt.DB
-
-	batchInterval time.Duration
-	batchLimit    int
+	db    KVEngine
+	// defragMu serializes defragmentations.
+	defragMu sync.Mutex
+	// pipelinedDefrag reads and writes in separate goroutines during defrag.
+	pipelinedDefrag bool
+	// warmUpOnOpen warms up the backend after open and defrag.
+	warmUpOnOpen bool
+	// warmUpWg waits for background warm-ups on close.
+	warmUpWg sync.WaitGroup
+	// readTxTracker tracks the concurrent read transactions, nil if disabled.
+	readTxTracker *readTxTracker
+	// autoDefragWg waits for the automatic defragmentation on close.
+	autoDefragWg sync.WaitGroup
+	// readBufferMaxBytes is the size of the read buffer above which the
+	// pending writes are committed. Zero means unlimited.
+	readBufferMaxBytes int
+	// pinMu protects pins, the views pinned by commit sequence.
+	pinMu sync.Mutex
+	pins  map[int64]*readTxPin
+	// pinWg waits for the release of the pinned views on close.
+	pinWg sync.WaitGroup
+	// bucketHashes holds the incremental bucket hashes, nil if disabled.
+	bucketHashes *bucketHashes
+	// blooms holds the bloom filters of the write tx, nil if disabled.
+	blooms bloomFilters
+
+	// batchInterval and batchLimit are used with atomic operations since they
+	// can be changed at runtime. batchInterval is in nanoseconds.
+	batchInterval int64
+	batchLimit    int64
+	batchAutoTune *BatchAutoTuneConfig
 	batchTx       *batchTxBuffered
 
 	readTx *readTx
@@ -136,6 +278,9 @@ type BackendConfig struct {
 	BatchInterval time.Duration
 	// BatchLimit is the maximum puts before flushing the BatchTx.
 	BatchLimit int
+	// BatchAutoTune, if set, tunes the batch limit at runtime from the
+	// commit latency.
+	BatchAutoTune *BatchAutoTuneConfig
 	// BackendFreelistType is the backend boltdb's freelist type.
 	BackendFreelistType bolt.FreelistType
 	// MmapSize is the number of bytes to mmap for the backend.
@@ -146,9 +291,87 @@ type BackendConfig struct {
 	UnsafeNoFsync bool `json:"unsafe-no-fsync"`
 	// Mlock prevents backend database file to be swapped
 	Mlock bool
+	// RangePrefetchKeys is the maximum number of keys read ahead of a range
+	// that misses the read buffer, so that large sequential scans over cold
+	// pages overlap page faults with processing. Zero disables prefetching.
+	RangePrefetchKeys int
+	// QuotaBytes is the number of bytes in use above which QuotaExceeded
+	// reports true. Zero disables the quota.
+	QuotaBytes int64
+	// OnQuotaExceeded is called with the estimated bytes in use each time the
+	// quota becomes exceeded. It is called while holding the batch tx lock, so
+	// it must not use the backend.
+	OnQuotaExceeded func(sizeInUse int64)
+	// Compression enables the transparent compression of values. Nil
+	// disables it.
+	Compression *CompressionPolicy
+	// Encryptor enables the encryption of values at rest. Nil disables it.
+	Encryptor Encryptor
 
 	// Hooks are getting executed during lifecycle of Backend's transactions.
 	Hooks Hooks
+
+	// HashedBuckets are the buckets whose hash is maintained on writes, see
+	// Backend.IncrementalHash. They are hashed in full when the backend is
+	// opened.
+	HashedBuckets []Bucket
+
+	// BloomFilterBuckets are the buckets whose keys are tracked by a bloom
+	// filter, which lets single key ranges of missing keys skip boltdb. The
+	// filters are built when the backend is opened.
+	BloomFilterBuckets []Bucket
+
+	// TrackReadTxs records the creation time and caller of every concurrent
+	// read transaction, see Backend.LongRunningReadTxs.
+	TrackReadTxs bool
+	// ReadTxDeadline is the maximum lifetime of a concurrent read
+	// transaction, after which it is aborted so that it no longer prevents
+	// boltdb from reclaiming pages. Reading from an aborted transaction
+	// panics. Zero disables aborting. It implies TrackReadTxs.
+	ReadTxDeadline time.Duration
+
+	// VerifyOnOpen checks the integrity of the database before serving it:
+	// every bucket is walked, then the bbolt consistency check is run for up
+	// to VerifyTimeout, or without limit if zero. Engines other than bbolt
+	// are only verified by the bucket walk.
+	VerifyOnOpen bool
+	// VerifyBuckets are the critical buckets whose CRC is logged by the
+	// verification, so that it can be compared across members.
+	VerifyBuckets []Bucket
+	VerifyTimeout time.Duration
+
+	// ReadBufferMaxBytes is the number of bytes of uncommitted writes
+	// buffered for reads above which they are committed early, since the
+	// buffer is copied by every concurrent read transaction. Zero means
+	// unlimited.
+	ReadBufferMaxBytes int
+
+	// AutoDefrag defragments the backend in the background once enough of
+	// its space is free and the load is low. Nil disables it.
+	AutoDefrag *AutoDefragConfig
+
+	// WarmUpOnOpen warms up every bucket in the background once the backend
+	// is opened and after every defragmentation, see Backend.WarmUp.
+	WarmUpOnOpen bool
+
+	// PipelinedDefrag makes defragmentation read the database and write its
+	// copy in separate goroutines, which shortens it on fast disks at the
+	// cost of an extra CPU.
+	PipelinedDefrag bool
+
+	// Engine opens the storage engine at the given path. If nil, the backend
+	// is stored in bbolt, configured by the bolt-specific fields above.
+	// Defragmentation is only supported by the bbolt engine.
+	Engine func(path string) (KVEngine, error)
+	// ColdPath is the path of a secondary bbolt file storing ColdBuckets, so
+	// that rarely read buckets do not inflate the mmap of the file at Path.
+	// It is ignored if Engine is set.
+	ColdPath string
+	// ColdBuckets are the buckets stored at ColdPath.
+	ColdBuckets []Bucket
+	// Databases are the bbolt files storing buckets apart from the file at
+	// Path. They are ignored if Engine is set.
+	Databases []NamedDatabase
 }
 
 type BackendConfigOption func(*BackendConfig)
@@ -162,16 +385,52 @@ func DefaultBackendConfig(lg *zap.Logger) BackendConfig {
 	}
 }
 
+// namedDatabases returns the Databases, and the cold database if ColdPath
+// is set.
+func (bcfg *BackendConfig) namedDatabases() []NamedDatabase {
+	named := bcfg.Databases
+	if bcfg.ColdPath != "" {
+		named = append(named[:len(named):len(named)], NamedDatabase{Name: "cold", Path: bcfg.ColdPath, Buckets: bcfg.ColdBuckets})
+	}
+	return named
+}
+
 func New(bcfg BackendConfig) Backend {
 	return newBackend(bcfg)
 }
 
+// Open is like New, but returns the error of opening the engine, or the
+// CorruptionError of the verification requested by BackendConfig.VerifyOnOpen,
+// instead of panicking, so that the caller can restore the database from a
+// snapshot.
+func Open(bcfg BackendConfig) (Backend, error) {
+	b, err := openBackend(bcfg)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 func WithMmapSize(size uint64) BackendConfigOption {
 	return func(bcfg *BackendConfig) {
 		bcfg.MmapSize = size
 	}
 }
 
+// WithCompression enables the transparent compression of values.
+func WithCompression(p *CompressionPolicy) BackendConfigOption {
+	return func(bcfg *BackendConfig) {
+		bcfg.Compression = p
+	}
+}
+
+// WithEncryptor enables the encryption of values at rest.
+func WithEncryptor(enc Encryptor) BackendConfigOption {
+	return func(bcfg *BackendConfig) {
+		bcfg.Encryptor = enc
+	}
+}
+
 func NewDefaultBackend(lg *zap.Logger, path string, opts ...BackendConfigOption) Backend {
 	bcfg := DefaultBackendConfig(lg)
 	bcfg.Path = path
@@ -183,6 +442,14 @@ func NewDefaultBackend(lg *zap.Logger, path string, opts ...BackendConfigOption)
 }
 
 func newBackend(bcfg BackendConfig) *backend {
+	b, err := openBackend(bcfg)
+	if err != nil {
+		bcfg.Logger.Panic("failed to open backend", zap.String("path", bcfg.Path), zap.Error(err))
+	}
+	return b
+}
+
+func openBackend(bcfg BackendConfig) (*backend, error) {
 	bopts := &bolt.Options{}
 	if boltOpenOptions != nil {
 		*bopts = *boltOpenOptions
@@ -194,10 +461,30 @@ func newBackend(bcfg BackendConfig) *backend {
 	bopts.Mlock = bcfg.Mlock
 	bopts.Logger = newBoltLoggerZap(bcfg)
 
-	db, err := bolt.Open(bcfg.Path, 0600, bopts)
+	var db KVEngine
+	var err error
+	if bcfg.Engine == nil {
+		if err = materializeSnapshotDelta(bcfg.Path); err != nil {
+			return nil, fmt.Errorf("failed to restore database %s from its snapshot: %w", bcfg.Path, err)
+		}
+	}
+	if bcfg.Engine != nil {
+		db, err = bcfg.Engine(bcfg.Path)
+	} else if named := bcfg.namedDatabases(); len(named) > 0 {
+		db, err = openMultiEngine(bcfg.Path, named, bopts)
+	} else {
+		db, err = openBoltEngine(bcfg.Path, bopts)
+	}
 	if err != nil {
-		bcfg.Logger.Panic("failed to open database", zap.String("path", bcfg.Path), zap.Error(err))
+		return nil, fmt.Errorf("failed to open database %s: %w", bcfg.Path, err)
 	}
+	if bcfg.VerifyOnOpen {
+		if err = verifyEngine(bcfg.Logger, db, bcfg.VerifyBuckets, bcfg.VerifyTimeout); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	codec := newValueCodec(bcfg.Logger, bcfg.Compression, bcfg.Encryptor)
 
 	// In future, may want to make buffering optional for low-concurrency systems
 	// or dynamically swap between buffered/non-buffered depending on workload.
@@ -205,9 +492,21 @@ func newBackend(bcfg BackendConfig) *backend {
 		bopts: bopts,
 		db:    db,
 
-		batchInterval: bcfg.BatchInterval,
-		batchLimit:    bcfg.BatchLimit,
-		mlock:         bcfg.Mlock,
+		batchInterval: int64(bcfg.BatchInterval),
+		batchLimit:    int64(bcfg.BatchLimit),
+		batchAutoTune: bcfg.BatchAutoTune,
+
+		pipelinedDefrag: bcfg.PipelinedDefrag,
+		warmUpOnOpen:    bcfg.WarmUpOnOpen,
+		mlock:           bcfg.Mlock,
+		snapshotID:      uint64(time.Now().UnixNano()),
+		pins:            make(map[int64]*readTxPin),
+
+		readBufferMaxBytes: bcfg.ReadBufferMaxBytes,
+
+		quotaBytes:      bcfg.QuotaBytes,
+		onQuotaExceeded: bcfg.OnQuotaExceeded,
+		codec:           codec,
 
 		readTx: &readTx{
 			baseReadTx: baseReadTx{
@@ -215,9 +514,11 @@ func newBackend(bcfg BackendConfig) *backend {
 					txBuffer:   txBuffer{make(map[BucketID]*bucketBuffer)},
 					bufVersion: 0,
 				},
-				buckets: make(map[BucketID]*bolt.Bucket),
-				txWg:    new(sync.WaitGroup),
-				txMu:    new(sync.RWMutex),
+				buckets:      make(map[BucketID]EngineBucket),
+				txWg:         new(sync.WaitGroup),
+				txMu:         new(sync.RWMutex),
+				prefetchKeys: int64(bcfg.RangePrefetchKeys),
+				codec:        codec,
 			},
 		},
 		txReadBufferCache: txReadBufferCache{
@@ -232,12 +533,33 @@ func newBackend(bcfg BackendConfig) *backend {
 		lg: bcfg.Logger,
 	}
 
+	if bcfg.TrackReadTxs || bcfg.ReadTxDeadline > 0 {
+		b.readTxTracker = newReadTxTracker(bcfg.Logger, bcfg.ReadTxDeadline)
+	}
+
 	b.batchTx = newBatchTxBuffered(b)
+	if len(bcfg.HashedBuckets) > 0 {
+		b.batchTx.lock()
+		b.initBucketHashes(bcfg.HashedBuckets)
+		b.batchTx.Unlock()
+	}
+	if len(bcfg.BloomFilterBuckets) > 0 {
+		b.batchTx.lock()
+		b.initBloomFilters(bcfg.BloomFilterBuckets)
+		b.batchTx.Unlock()
+	}
 	// We set it after newBatchTxBuffered to skip the 'empty' commit.
 	b.hooks = bcfg.Hooks
 
 	go b.run()
-	return b
+	if cfg := bcfg.AutoDefrag; cfg != nil && cfg.CheckInterval > 0 {
+		b.autoDefragWg.Add(1)
+		go b.runAutoDefrag(*cfg)
+	}
+	if b.warmUpOnOpen {
+		b.warmUpInBackground()
+	}
+	return b, nil
 }
 
 // BatchTx returns the current batch tx in coalescer. The tx can be used for read and
@@ -324,15 +646,22 @@ func (b *backend) ConcurrentReadTx() ReadTx {
 	b.txReadBufferCache.mu.Unlock()
 
 	// concurrentReadTx is not supposed to write to its txReadBuffer
-	return &concurrentReadTx{
+	rt := &concurrentReadTx{
 		baseReadTx: baseReadTx{
-			buf:     *buf,
-			txMu:    b.readTx.txMu,
-			tx:      b.readTx.tx,
-			buckets: b.readTx.buckets,
-			txWg:    b.readTx.txWg,
+			buf:          *buf,
+			txMu:         b.readTx.txMu,
+			tx:           b.readTx.tx,
+			buckets:      b.readTx.buckets,
+			txWg:         b.readTx.txWg,
+			prefetchKeys: b.readTx.prefetchKeys,
+			codec:        b.readTx.codec,
+			blooms:       b.readTx.blooms,
 		},
 	}
+	if b.readTxTracker != nil {
+		b.readTxTracker.track(rt, 1)
+	}
+	return rt
 }
 
 // ForceCommit forces the current batching tx to commit.
@@ -342,7 +671,10 @@ func (b *backend) ForceCommit() {
 
 func (b *backend) Snapshot() Snapshot {
 	b.batchTx.Commit()
+	return b.snapshot()
+}
 
+func (b *backend) snapshot() Snapshot {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	tx, err := b.db.Begin(false)
@@ -389,23 +721,20 @@ func (b *backend) Hash(ignores func(bucketName, keyName []byte) bool) (uint32, e
 
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	err := b.db.View(func(tx *bolt.Tx) error {
-		c := tx.Cursor()
-		for next, _ := c.First(); next != nil; next, _ = c.Next() {
-			b := tx.Bucket(next)
-			if b == nil {
-				return fmt.Errorf("cannot get hash of bucket %s", next)
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	err = tx.ForEachBucket(func(next []byte, bucket EngineBucket) error {
+		h.Write(next)
+		return bucket.ForEach(func(k, v []byte) error {
+			if ignores != nil && !ignores(next, k) {
+				h.Write(k)
+				h.Write(b.codec.decode(v))
 			}
-			h.Write(next)
-			b.ForEach(func(k, v []byte) error {
-				if ignores != nil && !ignores(next, k) {
-					h.Write(k)
-					h.Write(v)
-				}
-				return nil
-			})
-		}
-		return nil
+			return nil
+		})
 	})
 
 	if err != nil {
@@ -423,9 +752,36 @@ func (b *backend) SizeInUse() int64 {
 	return atomic.LoadInt64(&b.sizeInUse)
 }
 
+func (b *backend) QuotaExceeded() bool {
+	return atomic.LoadInt32(&b.quotaExceeded) == 1
+}
+
+// checkQuota updates the quota state from the size in use and the pending
+// writes. It must be called holding the batch tx lock.
+func (b *backend) checkQuota() {
+	if b.quotaBytes <= 0 {
+		return
+	}
+	size := atomic.LoadInt64(&b.sizeInUse) + atomic.LoadInt64(&b.pendingBytes)
+	if size <= b.quotaBytes {
+		atomic.StoreInt32(&b.quotaExceeded, 0)
+		return
+	}
+	if atomic.CompareAndSwapInt32(&b.quotaExceeded, 0, 1) {
+		b.lg.Warn(
+			"backend quota exceeded",
+			zap.Int64("quota-size-bytes", b.quotaBytes),
+			zap.Int64("size-in-use-bytes", size),
+		)
+		if b.onQuotaExceeded != nil {
+			b.onQuotaExceeded(size)
+		}
+	}
+}
+
 func (b *backend) run() {
 	defer close(b.donec)
-	t := time.NewTimer(b.batchInterval)
+	t := time.NewTimer(b.getBatchInterval())
 	defer t.Stop()
 	for {
 		select {
@@ -437,13 +793,19 @@ func (b *backend) run() {
 		if b.batchTx.safePending() != 0 {
 			b.batchTx.Commit()
 		}
-		t.Reset(b.batchInterval)
+		if b.readTxTracker != nil {
+			b.readTxTracker.abortExpired()
+		}
+		t.Reset(b.getBatchInterval())
 	}
 }
 
 func (b *backend) Close() error {
 	close(b.stopc)
 	<-b.donec
+	b.unpinAll()
+	b.autoDefragWg.Wait()
+	b.warmUpWg.Wait()
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	return b.db.Close()
@@ -459,31 +821,45 @@ func (b *backend) Defrag() error {
 }
 
 func (b *backend) defrag() error {
-	now := time.Now()
-	isDefragActive.Set(1)
-	defer isDefragActive.Set(0)
-
-	// TODO: make this non-blocking?
-	// lock batchTx to ensure nobody is using previous tx, and then
-	// close previous ongoing tx.
-	b.batchTx.LockOutsideApply()
-	defer b.batchTx.Unlock()
+	// defragmentations are serialized since they replace the database.
+	b.defragMu.Lock()
+	defer b.defragMu.Unlock()
 
-	// lock database after lock tx to avoid deadlock.
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	var targets []*boltEngine
+	b.mu.RLock()
+	switch e := b.db.(type) {
+	case *boltEngine:
+		targets = []*boltEngine{e}
+	case *multiEngine:
+		targets = e.files()
+	}
+	b.mu.RUnlock()
+	if len(targets) == 0 {
+		return ErrDefragUnsupported
+	}
 
-	// block concurrent read requests while resetting tx
-	b.readTx.Lock()
-	defer b.readTx.Unlock()
+	isDefragActive.Set(1)
+	defer isDefragActive.Set(0)
 
-	b.batchTx.unsafeCommit(true)
+	for _, be := range targets {
+		if err := b.defragFile(be); err != nil {
+			return err
+		}
+	}
+	if b.warmUpOnOpen {
+		// the new db file is cold, warm-up reads once the locks are released
+		b.warmUpInBackground()
+	}
+	return nil
+}
 
-	b.batchTx.tx = nil
+// defragFile defragments the bbolt file of be and replaces it in the backend.
+func (b *backend) defragFile(be *boltEngine) error {
+	now := time.Now()
 
 	// Create a temporary file to ensure we start with a clean slate.
 	// Snapshotter.cleanupSnapdir cleans up any of these that are found during startup.
-	dir := filepath.Dir(b.db.Path())
+	dir := filepath.Dir(be.db.Path())
 	temp, err := os.CreateTemp(dir, "db.tmp.*")
 	if err != nil {
 		return err
@@ -503,7 +879,7 @@ func (b *backend) defrag() error {
 		return err
 	}
 
-	dbp := b.db.Path()
+	dbp := be.db.Path()
 	size1, sizeInUse1 := b.Size(), b.SizeInUse()
 	if b.lg != nil {
 		b.lg.Info(
@@ -515,17 +891,82 @@ func (b *backend) defrag() error {
 			zap.String("current-db-size-in-use", humanize.Bytes(uint64(sizeInUse1))),
 		)
 	}
-	// gofail: var defragBeforeCopy struct{}
-	err = defragdb(b.db, tmpdb, defragLimit)
-	if err != nil {
+
+	// Track the writes made while the database is copied. Pending writes
+	// are committed first so that the copy observes all of them.
+	b.batchTx.LockOutsideApply()
+	b.batchTx.delta = newDefragDelta()
+	b.batchTx.commit(false)
+	b.batchTx.Unlock()
+
+	switched := false
+	defer func() {
+		if switched {
+			return
+		}
+		b.batchTx.LockOutsideApply()
+		b.batchTx.delta = nil
+		b.batchTx.Unlock()
 		tmpdb.Close()
-		if rmErr := os.RemoveAll(tmpdb.Path()); rmErr != nil {
+		if rmErr := os.RemoveAll(tdbp); rmErr != nil {
 			b.lg.Error("failed to remove db.tmp after defragmentation completed", zap.Error(rmErr))
 		}
+	}()
+
+	// gofail: var defragBeforeCopy struct{}
+	// Copy the database in chunks without blocking the backend.
+	var cur defragCursor
+	for done := false; !done; {
+		if done, err = defragCopyChunk(be.db, tmpdb, &cur, defragLimit, b.pipelinedDefrag); err != nil {
+			return err
+		}
+	}
+
+	// Catch up with the writes made during the copy, still without blocking
+	// the backend, until the remaining delta is small.
+	for i := 0; i < defragCatchUpRounds; i++ {
+		b.batchTx.LockOutsideApply()
+		if b.batchTx.delta.len() <= defragLimit {
+			b.batchTx.Unlock()
+			break
+		}
+		b.batchTx.commit(false)
+		delta := b.batchTx.delta
+		b.batchTx.delta = newDefragDelta()
+		b.batchTx.Unlock()
+
+		if err = b.replayDefragDelta(be, delta, tmpdb); err != nil {
+			return err
+		}
+	}
+
+	// lock batchTx to ensure nobody is using previous tx, and then
+	// close previous ongoing tx.
+	b.batchTx.LockOutsideApply()
+	defer b.batchTx.Unlock()
+
+	// replay the remaining delta from the latest committed state.
+	b.batchTx.commit(false)
+	err = b.replayDefragDelta(be, b.batchTx.delta, tmpdb)
+	b.batchTx.delta = nil
+	if err != nil {
 		return err
 	}
+	switched = true
+
+	// lock database after lock tx to avoid deadlock.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// block concurrent read requests while resetting tx
+	b.readTx.Lock()
+	defer b.readTx.Unlock()
+
+	b.batchTx.unsafeCommit(true)
+
+	b.batchTx.tx = nil
 
-	err = b.db.Close()
+	err = be.Close()
 	if err != nil {
 		b.lg.Fatal("failed to close database", zap.Error(err))
 	}
@@ -539,19 +980,25 @@ func (b *backend) defrag() error {
 		b.lg.Fatal("failed to rename tmp database", zap.Error(err))
 	}
 
-	b.db, err = bolt.Open(dbp, 0600, b.bopts)
+	nbe, err := openBoltEngine(dbp, b.bopts)
 	if err != nil {
 		b.lg.Fatal("failed to open database", zap.String("path", dbp), zap.Error(err))
 	}
+	if me, ok := b.db.(*multiEngine); ok {
+		if err = me.replace(be, nbe); err != nil {
+			b.lg.Fatal("failed to write the last commit to the database", zap.String("path", dbp), zap.Error(err))
+		}
+	} else {
+		b.db = nbe
+	}
 	b.batchTx.tx = b.unsafeBegin(true)
 
 	b.readTx.reset()
 	b.readTx.tx = b.unsafeBegin(false)
 
 	size := b.readTx.tx.Size()
-	db := b.readTx.tx.DB()
 	atomic.StoreInt64(&b.size, size)
-	atomic.StoreInt64(&b.sizeInUse, size-(int64(db.Stats().FreePageN)*int64(db.Info().PageSize)))
+	atomic.StoreInt64(&b.sizeInUse, size-b.db.Stats().FreeBytes)
 
 	took := time.Since(now)
 	defragSec.Observe(took.Seconds())
@@ -573,81 +1020,35 @@ func (b *backend) defrag() error {
 	return nil
 }
 
-func defragdb(odb, tmpdb *bolt.DB, limit int) error {
-	// open a tx on tmpdb for writes
-	tmptx, err := tmpdb.Begin(true)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err != nil {
-			tmptx.Rollback()
-		}
-	}()
-
-	// open a tx on old db for read
-	tx, err := odb.Begin(false)
+// replayDefragDelta replays delta into tmpdb from a fresh read transaction,
+// which observes every write of delta since it was committed beforehand.
+func (b *backend) replayDefragDelta(be *boltEngine, delta *defragDelta, tmpdb *bolt.DB) error {
+	tx, err := be.Begin(false)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-
-	c := tx.Cursor()
-
-	count := 0
-	for next, _ := c.First(); next != nil; next, _ = c.Next() {
-		b := tx.Bucket(next)
-		if b == nil {
-			return fmt.Errorf("backend: cannot defrag bucket %s", next)
-		}
-
-		tmpb, berr := tmptx.CreateBucketIfNotExists(next)
-		if berr != nil {
-			return berr
-		}
-		tmpb.FillPercent = 0.9 // for bucket2seq write in for each
-
-		if err = b.ForEach(func(k, v []byte) error {
-			count++
-			if count > limit {
-				err = tmptx.Commit()
-				if err != nil {
-					return err
-				}
-				tmptx, err = tmpdb.Begin(true)
-				if err != nil {
-					return err
-				}
-				tmpb = tmptx.Bucket(next)
-				tmpb.FillPercent = 0.9 // for bucket2seq write in for each
-
-				count = 0
-			}
-			return tmpb.Put(k, v)
-		}); err != nil {
-			return err
-		}
-	}
-
-	return tmptx.Commit()
+	return delta.replay(tx, tmpdb)
 }
 
-func (b *backend) begin(write bool) *bolt.Tx {
+func (b *backend) begin(write bool) EngineTx {
 	b.mu.RLock()
 	tx := b.unsafeBegin(write)
+	stats := b.db.Stats()
 	b.mu.RUnlock()
 
 	size := tx.Size()
-	db := tx.DB()
-	stats := db.Stats()
 	atomic.StoreInt64(&b.size, size)
-	atomic.StoreInt64(&b.sizeInUse, size-(int64(stats.FreePageN)*int64(db.Info().PageSize)))
-	atomic.StoreInt64(&b.openReadTxN, int64(stats.OpenTxN))
+	atomic.StoreInt64(&b.sizeInUse, size-stats.FreeBytes)
+	atomic.StoreInt64(&b.openReadTxN, int64(stats.OpenReadTxN))
+	freePages.Set(float64(stats.FreePageN))
+	pendingPages.Set(float64(stats.PendingPageN))
+	freelistInuseBytes.Set(float64(stats.FreelistInuse))
 
 	return tx
 }
 
-func (b *backend) unsafeBegin(write bool) *bolt.Tx {
+func (b *backend) unsafeBegin(write bool) EngineTx {
 	// gofail: var beforeStartDBTxn struct{}
 	tx, err := b.db.Begin(write)
 	// gofail: var afterStartDBTxn struct{}
@@ -661,16 +1062,31 @@ func (b *backend) OpenReadTxN() int64 {
 	return atomic.LoadInt64(&b.openReadTxN)
 }
 
+func (b *backend) DBStats() EngineStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.Stats()
+}
+
 type snapshot struct {
-	*bolt.Tx
+	EngineTx
 	stopc chan struct{}
 	donec chan struct{}
 }
 
+// Size returns the number of bytes written by WriteTo, which is the size of
+// the database unless the engine streams a snapshot of its own.
+func (s *snapshot) Size() int64 {
+	if ss, ok := s.EngineTx.(interface{ snapshotSize() int64 }); ok {
+		return ss.snapshotSize()
+	}
+	return s.EngineTx.Size()
+}
+
 func (s *snapshot) Close() error {
 	close(s.stopc)
 	<-s.donec
-	return s.Tx.Rollback()
+	return s.EngineTx.Rollback()
 }
 
 func newBoltLoggerZap(bcfg BackendConfig) bolt.Logger {
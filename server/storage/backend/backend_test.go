@@ -15,13 +15,16 @@
 package backend_test
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
 
 	bolt "go.etcd.io/bbolt"
@@ -194,6 +197,92 @@ func TestBackendDefrag(t *testing.T) {
 	b.ForceCommit()
 }
 
+func TestBackendPipelinedDefrag(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.PipelinedDefrag = true
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafeCreateBucket(schema.Key)
+	// an empty bucket must be copied too
+	tx.UnsafeCreateBucket(schema.Lease)
+	for i := 0; i < backend.DefragLimitForTest()+100; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%d", i)), []byte("bar"))
+		tx.UnsafePut(schema.Key, []byte(fmt.Sprintf("foo_%d", i)), []byte("bar"))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+
+	want := dumpBolt(t, backend.DbFromBackendForTest(b))
+	require.NoError(t, b.Defrag())
+	assert.Equal(t, want, dumpBolt(t, backend.DbFromBackendForTest(b)))
+}
+
+// TestBackendDefragConcurrentWrites ensures writes made while the database is
+// being copied are not lost by defrag.
+func TestBackendDefragConcurrentWrites(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, 10*time.Millisecond, 100)
+	defer betesting.Close(t, b)
+
+	const keyN = 3 * 10000
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	for i := 0; i < keyN; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%05d", i)), []byte("bar"))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+
+	want := make(map[string]string)
+	for i := 0; i < keyN; i++ {
+		want[fmt.Sprintf("foo_%05d", i)] = "bar"
+	}
+	stopc, donec := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(donec)
+		for i := 0; ; i++ {
+			select {
+			case <-stopc:
+				return
+			default:
+			}
+			k := fmt.Sprintf("foo_%05d", (i*7919)%keyN)
+			tx := b.BatchTx()
+			tx.Lock()
+			if i%5 == 0 {
+				tx.UnsafeDelete(schema.Test, []byte(k))
+				delete(want, k)
+			} else {
+				v := fmt.Sprintf("v%d", i)
+				tx.UnsafePut(schema.Test, []byte(k), []byte(v))
+				want[k] = v
+			}
+			tx.Unlock()
+		}
+	}()
+
+	err := b.Defrag()
+	close(stopc)
+	<-donec
+	require.NoError(t, err)
+	b.ForceCommit()
+
+	got := make(map[string]string)
+	rtx := b.ReadTx()
+	rtx.RLock()
+	err = rtx.UnsafeForEach(schema.Test, func(k, v []byte) error {
+		got[string(k)] = string(v)
+		return nil
+	})
+	rtx.RUnlock()
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
 // TestBackendWriteback ensures writes are stored to the read txn on write txn unlock.
 func TestBackendWriteback(t *testing.T) {
 	b, _ := betesting.NewDefaultTmpBackend(t)
@@ -348,3 +437,273 @@ func TestBackendWritebackForEach(t *testing.T) {
 		t.Fatalf("expected %q, got %q", seq, partialSeq)
 	}
 }
+
+// TestBackendRangePrefetch ensures ranges served with a prefetch cursor return
+// the same result as ranges served without one, and that the prefetch does not
+// read past the limit of the range or the prefetch bound.
+func TestBackendRangePrefetch(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.RangePrefetchKeys = 100
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Key)
+	for i := 0; i < 1000; i++ {
+		tx.UnsafePut(schema.Key, []byte(fmt.Sprintf("%04d", i)), []byte("bar"))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+
+	tests := []struct {
+		key, end []byte
+		limit    int64
+		wantN    int
+		// maxPrefetch is the most keys the range may read ahead
+		maxPrefetch int64
+	}{
+		{[]byte("0000"), []byte("9999"), 0, 1000, 100},
+		{[]byte("0100"), []byte("0500"), 0, 400, 100},
+		{[]byte("0100"), []byte("9999"), 80, 80, 80},
+		{[]byte("0100"), []byte("0150"), 80, 50, 50},
+		{[]byte("0100"), []byte("9999"), 10, 10, 0},
+		{[]byte("0100"), nil, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		for _, rtx := range []backend.ReadTx{b.ReadTx(), b.ConcurrentReadTx()} {
+			before := backend.RangePrefetchKeysForTest()
+			rtx.RLock()
+			keys, vals := rtx.UnsafeRange(schema.Key, tt.key, tt.end, tt.limit)
+			rtx.RUnlock()
+			assert.Len(t, keys, tt.wantN)
+			assert.Len(t, vals, tt.wantN)
+			assert.Equal(t, tt.key, keys[0])
+			// the prefetch has exited by the time the range returns
+			assert.LessOrEqual(t, backend.RangePrefetchKeysForTest()-before, tt.maxPrefetch)
+		}
+	}
+}
+
+type countingEngine struct {
+	backend.KVEngine
+	begins int
+}
+
+func (e *countingEngine) Begin(writable bool) (backend.EngineTx, error) {
+	e.begins++
+	return e.KVEngine.Begin(writable)
+}
+
+func TestBackendCustomEngine(t *testing.T) {
+	var engine *countingEngine
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.Engine = func(path string) (backend.KVEngine, error) {
+		be, err := backend.OpenBoltEngineForTest(path)
+		if err != nil {
+			return nil, err
+		}
+		engine = &countingEngine{KVEngine: be}
+		return engine, nil
+	}
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	b.ForceCommit()
+
+	rtx := b.ConcurrentReadTx()
+	rtx.RLock()
+	keys, vals := rtx.UnsafeRange(schema.Test, []byte("foo"), nil, 0)
+	rtx.RUnlock()
+	assert.Equal(t, [][]byte{[]byte("foo")}, keys)
+	assert.Equal(t, [][]byte{[]byte("bar")}, vals)
+	assert.Positive(t, engine.begins)
+
+	assert.ErrorIs(t, b.Defrag(), backend.ErrDefragUnsupported)
+}
+
+func TestOpenEngineError(t *testing.T) {
+	errEngine := errors.New("engine failure")
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.Path = filepath.Join(t.TempDir(), "db")
+	bcfg.Engine = func(path string) (backend.KVEngine, error) {
+		return nil, errEngine
+	}
+	b, err := backend.Open(bcfg)
+	require.ErrorIs(t, err, errEngine)
+	assert.Nil(t, b)
+}
+
+func TestBackendQuota(t *testing.T) {
+	var exceeded []int64
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.BatchInterval = time.Hour
+	bcfg.QuotaBytes = 1024 * 1024
+	bcfg.OnQuotaExceeded = func(size int64) { exceeded = append(exceeded, size) }
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+	assert.False(t, b.(backend.QuotaChecker).QuotaExceeded())
+
+	val := make([]byte, 1024)
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	for i := 0; i < 2*1024; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%d", i)), val)
+	}
+	tx.Unlock()
+
+	// the pending writes are accounted before they are committed
+	assert.True(t, b.(backend.QuotaChecker).QuotaExceeded())
+	require.Len(t, exceeded, 1)
+	assert.Greater(t, exceeded[0], bcfg.QuotaBytes)
+
+	b.ForceCommit()
+	assert.True(t, b.(backend.QuotaChecker).QuotaExceeded())
+	assert.Len(t, exceeded, 1)
+}
+
+func TestBackendSetBatchParams(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	b.(backend.BatchParamsSetter).SetBatchParams(0, 2)
+	assert.Equal(t, 2, backend.BatchLimitForTest(b))
+
+	commits := backend.CommitsForTest(b)
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	// reaching the new batch limit commits the batch
+	assert.Equal(t, commits+1, backend.CommitsForTest(b))
+
+	b.(backend.BatchParamsSetter).SetBatchParams(10*time.Millisecond, 0)
+	assert.Equal(t, 2, backend.BatchLimitForTest(b))
+}
+
+func TestBackendBatchAutoTune(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.BatchInterval, bcfg.BatchLimit = time.Hour, 64
+	bcfg.BatchAutoTune = &backend.BatchAutoTuneConfig{
+		// every commit is slower than the target
+		TargetCommitLatency: time.Nanosecond,
+		MinBatchLimit:       16,
+	}
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	for i := 0; i < 4; i++ {
+		tx.Lock()
+		tx.UnsafeCreateBucket(schema.Test)
+		tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+		tx.Unlock()
+		b.ForceCommit()
+	}
+	assert.Equal(t, 16, backend.BatchLimitForTest(b))
+}
+
+func TestBackendWarmUp(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 100000)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafeCreateBucket(schema.Key)
+	for i := 0; i < 25000; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%05d", i)), []byte("bar"))
+	}
+	tx.UnsafePut(schema.Key, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	b.ForceCommit()
+
+	n, err := b.(backend.WarmUpper).WarmUp(schema.Test)
+	require.NoError(t, err)
+	assert.Equal(t, 25000, n)
+
+	n, err = b.(backend.WarmUpper).WarmUp()
+	require.NoError(t, err)
+	assert.Equal(t, 25001, n)
+}
+
+func TestBackendWarmUpOnOpen(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.WarmUpOnOpen = true
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	require.NoError(t, b.Defrag())
+	// close waits for the background warm-ups
+	betesting.Close(t, b)
+}
+
+func TestBackendLongRunningReadTxs(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.TrackReadTxs = true
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	rtx := b.ConcurrentReadTx()
+	time.Sleep(10 * time.Millisecond)
+
+	txs := b.(backend.LongRunningReadTxLister).LongRunningReadTxs(5 * time.Millisecond)
+	require.Len(t, txs, 1)
+	assert.Contains(t, txs[0].Caller, "backend_test.go")
+	assert.Empty(t, b.(backend.LongRunningReadTxLister).LongRunningReadTxs(time.Hour))
+
+	rtx.RUnlock()
+	assert.Empty(t, b.(backend.LongRunningReadTxLister).LongRunningReadTxs(0))
+}
+
+func TestBackendReadTxDeadline(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.BatchInterval = 10 * time.Millisecond
+	bcfg.ReadTxDeadline = 50 * time.Millisecond
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.Unlock()
+	b.ForceCommit()
+
+	rtx := b.ConcurrentReadTx()
+	rtx.RLock()
+	rtx.UnsafeRange(schema.Key, []byte("foo"), nil, 0)
+	require.Eventually(t, func() bool {
+		return len(b.(backend.LongRunningReadTxLister).LongRunningReadTxs(0)) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// the aborted tx no longer blocks commits from rolling back the read tx.
+	b.ForceCommit()
+	assert.Panics(t, func() { rtx.UnsafeRange(schema.Key, []byte("foo"), nil, 0) })
+	// releasing an aborted tx is a no-op.
+	rtx.RUnlock()
+}
+
+func TestBackendDBStats(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	for i := 0; i < 1000; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%d", i)), make([]byte, 100))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+
+	stats := b.(backend.DBStatser).DBStats()
+	assert.Positive(t, stats.ReadTxN)
+	assert.Positive(t, stats.FreelistInuse)
+	assert.Positive(t, stats.TxStats.PageAlloc)
+	assert.Positive(t, stats.TxStats.Write)
+}
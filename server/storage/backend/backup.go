@@ -0,0 +1,104 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// backupChunkSize is the maximum number of bytes written to the backup
+// writer at once, so that rate limiting stays smooth.
+const backupChunkSize = 64 * 1024
+
+// BackupOptions configures Backend.BackupTo.
+type BackupOptions struct {
+	// RateBytes is the maximum number of bytes written per second. Zero
+	// disables rate limiting.
+	RateBytes int
+	// Progress, if set, is called after every chunk written with the number
+	// of bytes written so far and the total size of the backup.
+	Progress func(written, total int64)
+}
+
+// BackupTo writes a consistent copy of the backend into w. The copy is read
+// from a single read transaction, so writes proceed while it is taken, but
+// the pages they free are not reused until the backup is done.
+func (b *backend) BackupTo(w io.Writer, opts BackupOptions) (int64, error) {
+	start := time.Now()
+	snap := b.Snapshot()
+	defer snap.Close()
+
+	bw := &backupWriter{w: w, total: snap.Size(), progress: opts.Progress}
+	if opts.RateBytes > 0 {
+		burst := opts.RateBytes
+		if burst > backupChunkSize {
+			burst = backupChunkSize
+		}
+		bw.limiter = rate.NewLimiter(rate.Limit(opts.RateBytes), burst)
+	}
+	n, err := snap.WriteTo(bw)
+	if err != nil {
+		return n, err
+	}
+	b.lg.Info(
+		"backed up backend",
+		zap.Int64("bytes", n),
+		zap.Int("rate-bytes", opts.RateBytes),
+		zap.Duration("took", time.Since(start)),
+	)
+	return n, nil
+}
+
+type backupWriter struct {
+	w        io.Writer
+	limiter  *rate.Limiter
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (bw *backupWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > backupChunkSize {
+			chunk = chunk[:backupChunkSize]
+		}
+		if bw.limiter != nil {
+			if len(chunk) > bw.limiter.Burst() {
+				chunk = chunk[:bw.limiter.Burst()]
+			}
+			if err := bw.limiter.WaitN(context.Background(), len(chunk)); err != nil {
+				return n, err
+			}
+		}
+		m, err := bw.w.Write(chunk)
+		n += m
+		bw.written += int64(m)
+		if bw.progress != nil {
+			bw.progress(bw.written, bw.total)
+		}
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
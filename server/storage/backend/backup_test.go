@@ -0,0 +1,72 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestBackendBackupTo(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	for i := 0; i < 1024; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%d", i)), make([]byte, 1024))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+
+	snap := b.Snapshot()
+	var want bytes.Buffer
+	_, err := snap.WriteTo(&want)
+	require.NoError(t, err)
+	require.NoError(t, snap.Close())
+
+	var (
+		got             bytes.Buffer
+		written, total  int64
+		progressInvoked int
+	)
+	start := time.Now()
+	n, err := b.(backend.BackupWriter).BackupTo(&got, backend.BackupOptions{
+		// the backup takes about half a second
+		RateBytes: 2 * want.Len(),
+		Progress: func(w, t int64) {
+			written, total = w, t
+			progressInvoked++
+		},
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	assert.Equal(t, int64(want.Len()), n)
+	assert.Equal(t, want.Bytes(), got.Bytes())
+	assert.Greater(t, progressInvoked, 1)
+	assert.Equal(t, n, written)
+	assert.Equal(t, n, total)
+}
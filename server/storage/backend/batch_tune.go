@@ -0,0 +1,94 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BatchAutoTuneConfig configures the tuning of the batch limit from the
+// commit latency. Commits slower than TargetCommitLatency halve the batch
+// limit; commits of a full batch faster than half of it double the limit.
+type BatchAutoTuneConfig struct {
+	// TargetCommitLatency is the commit duration the tuning aims for.
+	TargetCommitLatency time.Duration
+	// MinBatchLimit and MaxBatchLimit bound the tuned batch limit.
+	MinBatchLimit int
+	MaxBatchLimit int
+}
+
+// SetBatchParams changes the batch interval and limit at runtime.
+// Non-positive values leave the corresponding parameter unchanged. A new
+// interval takes effect after the current one expires.
+func (b *backend) SetBatchParams(interval time.Duration, limit int) {
+	if interval > 0 {
+		atomic.StoreInt64(&b.batchInterval, int64(interval))
+	}
+	if limit > 0 {
+		atomic.StoreInt64(&b.batchLimit, int64(limit))
+	}
+	b.lg.Info(
+		"updated backend batch parameters",
+		zap.Duration("batch-interval", b.getBatchInterval()),
+		zap.Int("batch-limit", b.getBatchLimit()),
+	)
+}
+
+func (b *backend) getBatchInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.batchInterval))
+}
+
+func (b *backend) getBatchLimit() int {
+	return int(atomic.LoadInt64(&b.batchLimit))
+}
+
+// tuneBatch adjusts the batch limit after a commit of pending operations
+// that took the given duration. It must be called holding the batch tx lock.
+func (b *backend) tuneBatch(pending int, took time.Duration) {
+	cfg := b.batchAutoTune
+	if cfg == nil || cfg.TargetCommitLatency <= 0 {
+		return
+	}
+	limit := b.getBatchLimit()
+	newLimit := limit
+	switch {
+	case took > cfg.TargetCommitLatency:
+		newLimit = limit / 2
+	case took < cfg.TargetCommitLatency/2 && pending >= limit:
+		newLimit = limit * 2
+	}
+	if cfg.MinBatchLimit > 0 && newLimit < cfg.MinBatchLimit {
+		newLimit = cfg.MinBatchLimit
+	}
+	if cfg.MaxBatchLimit > 0 && newLimit > cfg.MaxBatchLimit {
+		newLimit = cfg.MaxBatchLimit
+	}
+	if newLimit < 1 {
+		newLimit = 1
+	}
+	if newLimit != limit {
+		atomic.StoreInt64(&b.batchLimit, int64(newLimit))
+		b.lg.Debug(
+			"tuned backend batch limit",
+			zap.Int("pending", pending),
+			zap.Duration("commit-duration", took),
+			zap.Int("old-batch-limit", limit),
+			zap.Int("new-batch-limit", newLimit),
+		)
+	}
+}
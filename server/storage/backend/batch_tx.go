@@ -22,9 +22,6 @@ import (
 	"time"
 
 	"go.uber.org/zap"
-
-	bolt "go.etcd.io/bbolt"
-	bolterrors "go.etcd.io/bbolt/errors"
 )
 
 type BucketID int
@@ -71,10 +68,16 @@ type UnsafeWriter interface {
 
 type batchTx struct {
 	sync.Mutex
-	tx      *bolt.Tx
+	tx      EngineTx
 	backend *backend
 
 	pending int
+	// delta records the writes while an online defragmentation is copying
+	// the database, nil otherwise.
+	delta *defragDelta
+	// changes records the writes while consumers of incremental snapshots
+	// are registered, nil otherwise. See TrackSnapshotChanges.
+	changes *snapshotChanges
 }
 
 // Lock is supposed to be called only by the unit test.
@@ -106,7 +109,7 @@ func (t *batchTx) LockOutsideApply() {
 }
 
 func (t *batchTx) Unlock() {
-	if t.pending >= t.backend.batchLimit {
+	if t.pending >= t.backend.getBatchLimit() {
 		t.commit(false)
 	}
 	t.Mutex.Unlock()
@@ -120,18 +123,33 @@ func (t *batchTx) UnsafeCreateBucket(bucket Bucket) {
 			zap.Error(err),
 		)
 	}
+	if t.delta != nil {
+		t.delta.addBucket(bucket.Name())
+	}
+	if t.changes != nil {
+		t.changes.addBucket(bucket.Name(), t.backend.Commits()+1)
+	}
 	t.pending++
 }
 
 func (t *batchTx) UnsafeDeleteBucket(bucket Bucket) {
 	err := t.tx.DeleteBucket(bucket.Name())
-	if err != nil && err != bolterrors.ErrBucketNotFound {
+	if err != nil {
 		t.backend.lg.Fatal(
 			"failed to delete a bucket",
 			zap.Stringer("bucket-name", bucket),
 			zap.Error(err),
 		)
 	}
+	if t.delta != nil {
+		t.delta.resetBucket(bucket.Name())
+	}
+	if t.backend.bucketHashes != nil {
+		t.backend.bucketHashes.reset(bucket.Name())
+	}
+	if t.changes != nil {
+		t.changes.resetBucket(bucket.Name(), t.backend.Commits()+1)
+	}
 	t.pending++
 }
 
@@ -155,18 +173,35 @@ func (t *batchTx) unsafePut(bucketType Bucket, key []byte, value []byte, seq boo
 		)
 	}
 	if seq {
-		// it is useful to increase fill percent when the workloads are mostly append-only.
-		// this can delay the page split and reduce space usage.
-		bucket.FillPercent = 0.9
+		bucket.SetSequential()
 	}
-	if err := bucket.Put(key, value); err != nil {
+	t.unsafeWrite(bucketType, bucket, key, value)
+	t.pending++
+}
+
+// unsafeWrite writes the key into bucket, without counting it as pending.
+func (t *batchTx) unsafeWrite(bucketType Bucket, bucket EngineBucket, key []byte, value []byte) {
+	if h := t.backend.bucketHashes; h != nil && h.tracks(bucketType.Name()) {
+		h.put(bucketType.Name(), key, t.backend.codec.decode(bucket.Get(key)), value)
+	}
+	if err := bucket.Put(key, t.backend.codec.encode(bucketType, value)); err != nil {
 		t.backend.lg.Fatal(
 			"failed to write to a bucket",
 			zap.Stringer("bucket-name", bucketType),
 			zap.Error(err),
 		)
 	}
-	t.pending++
+	if t.delta != nil {
+		t.delta.addKey(bucketType.Name(), key)
+	}
+	if t.backend.blooms != nil {
+		t.backend.blooms.add(bucketType, key)
+	}
+	if t.changes != nil {
+		t.changes.addKey(bucketType.Name(), key, t.backend.Commits()+1)
+	}
+	atomic.AddInt64(&t.backend.pendingBytes, int64(len(key)+len(value)))
+	t.backend.checkQuota()
 }
 
 // UnsafeRange must be called holding the lock on the tx.
@@ -179,10 +214,12 @@ func (t *batchTx) UnsafeRange(bucketType Bucket, key, endKey []byte, limit int64
 			zap.Stack("stack"),
 		)
 	}
-	return unsafeRange(bucket.Cursor(), key, endKey, limit)
+	keys, vals := unsafeRange(bucket.Cursor(), key, endKey, limit)
+	t.backend.codec.decodeAll(vals)
+	return keys, vals
 }
 
-func unsafeRange(c *bolt.Cursor, key, endKey []byte, limit int64) (keys [][]byte, vs [][]byte) {
+func unsafeRange(c EngineCursor, key, endKey []byte, limit int64) (keys [][]byte, vs [][]byte) {
 	if limit <= 0 {
 		limit = math.MaxInt64
 	}
@@ -214,6 +251,9 @@ func (t *batchTx) UnsafeDelete(bucketType Bucket, key []byte) {
 			zap.Stack("stack"),
 		)
 	}
+	if h := t.backend.bucketHashes; h != nil && h.tracks(bucketType.Name()) {
+		h.delete(bucketType.Name(), key, t.backend.codec.decode(bucket.Get(key)))
+	}
 	err := bucket.Delete(key)
 	if err != nil {
 		t.backend.lg.Fatal(
@@ -222,17 +262,28 @@ func (t *batchTx) UnsafeDelete(bucketType Bucket, key []byte) {
 			zap.Error(err),
 		)
 	}
+	if t.delta != nil {
+		t.delta.addKey(bucketType.Name(), key)
+	}
+	if t.changes != nil {
+		t.changes.addKey(bucketType.Name(), key, t.backend.Commits()+1)
+	}
 	t.pending++
 }
 
 // UnsafeForEach must be called holding the lock on the tx.
 func (t *batchTx) UnsafeForEach(bucket Bucket, visitor func(k, v []byte) error) error {
-	return unsafeForEach(t.tx, bucket, visitor)
+	return unsafeForEach(t.tx, bucket, t.backend.codec, visitor)
 }
 
-func unsafeForEach(tx *bolt.Tx, bucket Bucket, visitor func(k, v []byte) error) error {
+func unsafeForEach(tx EngineTx, bucket Bucket, vc *valueCodec, visitor func(k, v []byte) error) error {
 	if b := tx.Bucket(bucket.Name()); b != nil {
-		return b.ForEach(visitor)
+		if vc == nil {
+			return b.ForEach(visitor)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return visitor(k, vc.decode(v))
+		})
 	}
 	return nil
 }
@@ -270,19 +321,38 @@ func (t *batchTx) commit(stop bool) {
 		err := t.tx.Commit()
 		// gofail: var afterCommit struct{}
 
-		rebalanceSec.Observe(t.tx.Stats().RebalanceTime.Seconds())
-		spillSec.Observe(t.tx.Stats().SpillTime.Seconds())
-		writeSec.Observe(t.tx.Stats().WriteTime.Seconds())
-		commitSec.Observe(time.Since(start).Seconds())
+		txStats := t.tx.Stats()
+		rebalanceSec.Observe(txStats.RebalanceTime.Seconds())
+		spillSec.Observe(txStats.SpillTime.Seconds())
+		writeSec.Observe(txStats.WriteTime.Seconds())
+		took := time.Since(start)
+		commitSec.Observe(took.Seconds())
 		atomic.AddInt64(&t.backend.commits, 1)
+		t.backend.tuneBatch(t.pending, took)
 
-		t.pending = 0
 		if err != nil {
 			t.backend.lg.Fatal("failed to commit tx", zap.Error(err))
 		}
+		if h, ok := t.backend.hooks.(PostCommitHooks); ok {
+			h.OnPostCommit(CommitStats{
+				Pending:       t.pending,
+				Bytes:         atomic.LoadInt64(&t.backend.pendingBytes),
+				Duration:      took,
+				RebalanceTime: txStats.RebalanceTime,
+				SpillTime:     txStats.SpillTime,
+				WriteTime:     txStats.WriteTime,
+			})
+		}
+		t.pending = 0
 	}
 	if !stop {
 		t.tx = t.backend.begin(true)
+		if t.backend.blooms != nil {
+			t.backend.rebuildFullBloomFilters(t.tx)
+		}
+		// the committed bytes are now accounted in the size in use.
+		atomic.StoreInt64(&t.backend.pendingBytes, 0)
+		t.backend.checkQuota()
 	}
 }
 
@@ -290,6 +360,11 @@ type batchTxBuffered struct {
 	batchTx
 	buf                     txWriteBuffer
 	pendingDeleteOperations int
+	// seqRuns holds the sequential puts not yet written to the tx.
+	seqRuns map[BucketID]*seqRun
+	// shardsMu protects shards.
+	shardsMu sync.Mutex
+	shards   map[BucketID]*bucketShard
 }
 
 func newBatchTxBuffered(backend *backend) *batchTxBuffered {
@@ -299,6 +374,8 @@ func newBatchTxBuffered(backend *backend) *batchTxBuffered {
 			txBuffer:   txBuffer{make(map[BucketID]*bucketBuffer)},
 			bucket2seq: make(map[BucketID]bool),
 		},
+		seqRuns: make(map[BucketID]*seqRun),
+		shards:  make(map[BucketID]*bucketShard),
 	}
 	tx.Commit()
 	return tx
@@ -310,7 +387,9 @@ func (t *batchTxBuffered) Unlock() {
 		// gofail: var beforeWritebackBuf struct{}
 		t.buf.writeback(&t.backend.readTx.buf)
 		// gofail: var afterWritebackBuf struct{}
+		bufSize := t.backend.readTx.buf.size()
 		t.backend.readTx.Unlock()
+		readBufferBytes.Set(float64(bufSize))
 		// We commit the transaction when the number of pending operations
 		// reaches the configured limit(batchLimit) to prevent it from
 		// becoming excessively large.
@@ -331,7 +410,11 @@ func (t *batchTxBuffered) Unlock() {
 		//
 		// Please also refer to
 		// https://github.com/etcd-io/etcd/pull/17119#issuecomment-1857547158
-		if t.pending >= t.backend.batchLimit || t.pendingDeleteOperations > 0 {
+		//
+		// The read buffer is also committed once it exceeds its size limit,
+		// since it grows with the batch interval.
+		if t.pending >= t.backend.getBatchLimit() || t.pendingDeleteOperations > 0 ||
+			(t.backend.readBufferMaxBytes > 0 && bufSize > t.backend.readBufferMaxBytes) {
 			t.commit(false)
 		}
 	}
@@ -357,7 +440,13 @@ func (t *batchTxBuffered) commit(stop bool) {
 	t.backend.readTx.Unlock()
 }
 
+func (t *batchTxBuffered) safePending() int {
+	return t.batchTx.safePending() + t.shardsPending()
+}
+
 func (t *batchTxBuffered) unsafeCommit(stop bool) {
+	t.drainShards()
+	t.flushSeqRuns()
 	if t.backend.hooks != nil {
 		// gofail: var commitBeforePreCommitHook struct{}
 		t.backend.hooks.OnPreCommitUnsafe(t)
@@ -367,13 +456,14 @@ func (t *batchTxBuffered) unsafeCommit(stop bool) {
 	if t.backend.readTx.tx != nil {
 		// wait all store read transactions using the current boltdb tx to finish,
 		// then close the boltdb tx
-		go func(tx *bolt.Tx, wg *sync.WaitGroup) {
+		go func(tx EngineTx, wg *sync.WaitGroup) {
 			wg.Wait()
 			if err := tx.Rollback(); err != nil {
 				t.backend.lg.Fatal("failed to rollback tx", zap.Error(err))
 			}
 		}(t.backend.readTx.tx, t.backend.readTx.txWg)
 		t.backend.readTx.reset()
+		readBufferBytes.Set(0)
 	}
 
 	t.batchTx.commit(stop)
@@ -381,25 +471,45 @@ func (t *batchTxBuffered) unsafeCommit(stop bool) {
 
 	if !stop {
 		t.backend.readTx.tx = t.backend.begin(false)
+		t.backend.readTx.blooms = t.backend.blooms
 	}
 }
 
 func (t *batchTxBuffered) UnsafePut(bucket Bucket, key []byte, value []byte) {
+	t.drainShard(bucket.ID())
+	t.flushSeqRun(bucket.ID())
 	t.batchTx.UnsafePut(bucket, key, value)
 	t.buf.put(bucket, key, value)
 }
 
 func (t *batchTxBuffered) UnsafeSeqPut(bucket Bucket, key []byte, value []byte) {
-	t.batchTx.UnsafeSeqPut(bucket, key, value)
+	t.drainShard(bucket.ID())
+	t.unsafeSeqPut(bucket, key, value)
 	t.buf.putSeq(bucket, key, value)
 }
 
+func (t *batchTxBuffered) UnsafeRange(bucket Bucket, key, endKey []byte, limit int64) ([][]byte, [][]byte) {
+	t.drainShard(bucket.ID())
+	t.flushSeqRun(bucket.ID())
+	return t.batchTx.UnsafeRange(bucket, key, endKey, limit)
+}
+
+func (t *batchTxBuffered) UnsafeForEach(bucket Bucket, visitor func(k, v []byte) error) error {
+	t.drainShard(bucket.ID())
+	t.flushSeqRun(bucket.ID())
+	return t.batchTx.UnsafeForEach(bucket, visitor)
+}
+
 func (t *batchTxBuffered) UnsafeDelete(bucketType Bucket, key []byte) {
+	t.drainShard(bucketType.ID())
+	t.flushSeqRun(bucketType.ID())
 	t.batchTx.UnsafeDelete(bucketType, key)
 	t.pendingDeleteOperations++
 }
 
 func (t *batchTxBuffered) UnsafeDeleteBucket(bucket Bucket) {
+	t.drainShard(bucket.ID())
+	t.flushSeqRun(bucket.ID())
 	t.batchTx.UnsafeDeleteBucket(bucket)
 	t.pendingDeleteOperations++
 }
@@ -18,10 +18,12 @@ import (
 	"fmt"
 	"math/rand"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zaptest"
 
 	bolt "go.etcd.io/bbolt"
 	"go.etcd.io/etcd/server/v3/storage/backend"
@@ -58,6 +60,42 @@ func TestBatchTxPut(t *testing.T) {
 	}
 }
 
+func TestBatchTxSeqPut(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.UnsafeSeqPut(schema.Key, []byte("k1"), []byte("v1"))
+	tx.UnsafeSeqPut(schema.Key, []byte("k3"), []byte("v3"))
+	tx.Unlock()
+	tx.Lock()
+	// breaks the run of increasing keys
+	tx.UnsafeSeqPut(schema.Key, []byte("k2"), []byte("v2"))
+	tx.UnsafeSeqPut(schema.Key, []byte("k4"), []byte("v4"))
+	tx.Unlock()
+
+	want := [][]byte{[]byte("v1"), []byte("v2"), []byte("v3"), []byte("v4")}
+	// check the puts before and after tx is committed
+	for k := 0; k < 2; k++ {
+		tx.Lock()
+		_, vals := tx.UnsafeRange(schema.Key, []byte("k"), []byte("l"), 0)
+		tx.Unlock()
+		if !reflect.DeepEqual(vals, want) {
+			t.Errorf("vals = %q, want %q", vals, want)
+		}
+		rtx := b.ReadTx()
+		rtx.RLock()
+		_, vals = rtx.UnsafeRange(schema.Key, []byte("k"), []byte("l"), 0)
+		rtx.RUnlock()
+		if !reflect.DeepEqual(vals, want) {
+			t.Errorf("read vals = %q, want %q", vals, want)
+		}
+		tx.Commit()
+	}
+}
+
 func TestBatchTxRange(t *testing.T) {
 	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
 	defer betesting.Close(t, b)
@@ -210,6 +248,92 @@ func TestBatchTxBatchLimitCommit(t *testing.T) {
 	})
 }
 
+func TestBatchTxReadBufferMaxBytes(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.BatchInterval, bcfg.BatchLimit = time.Hour, 10000
+	bcfg.ReadBufferMaxBytes = 1024
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.Unlock()
+	commits := backend.CommitsForTest(b)
+
+	for i := 0; i < 8; i++ {
+		tx.Lock()
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%d", i)), make([]byte, 100))
+		tx.Unlock()
+	}
+	if got := backend.CommitsForTest(b); got != commits {
+		t.Fatalf("commits = %d, want %d below the read buffer limit", got, commits)
+	}
+
+	tx.Lock()
+	tx.UnsafePut(schema.Test, []byte("bar"), make([]byte, 1024))
+	tx.Unlock()
+	if got := backend.CommitsForTest(b); got != commits+1 {
+		t.Fatalf("commits = %d, want %d above the read buffer limit", got, commits+1)
+	}
+}
+
+func TestBucketBatchTx(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.UnsafeCreateBucket(schema.Lease)
+	tx.Unlock()
+	tx.Commit()
+
+	// a writer holding the shard of the lease bucket does not block the
+	// batch tx.
+	shard := b.(backend.BucketBatchTxer).BucketBatchTx(schema.Lease)
+	shard.Lock()
+	shard.UnsafePut([]byte("lease"), []byte("held"))
+	tx.Lock()
+	tx.UnsafePut(schema.Key, []byte("key"), []byte("v"))
+	tx.Unlock()
+	shard.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := b.(backend.BucketBatchTxer).BucketBatchTx(schema.Lease)
+			s.Lock()
+			s.UnsafePut([]byte(fmt.Sprintf("lease_%d", i)), []byte("v"))
+			s.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	rtx := b.ConcurrentReadTx()
+	rtx.RLock()
+	_, vals := rtx.UnsafeRange(schema.Lease, []byte("lease"), nil, 0)
+	rtx.RUnlock()
+	if !reflect.DeepEqual(vals, [][]byte{[]byte("held")}) {
+		t.Fatalf("uncommitted shard value = %q, want %q", vals, "held")
+	}
+
+	b.ForceCommit()
+	n := 0
+	err := backend.DbFromBackendForTest(b).View(func(btx *bolt.Tx) error {
+		n = btx.Bucket(schema.Lease.Name()).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 11 {
+		t.Fatalf("committed lease keys = %d, want 11", n)
+	}
+}
+
 func TestRangeAfterDeleteBucketMatch(t *testing.T) {
 	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
 	defer betesting.Close(t, b)
@@ -0,0 +1,181 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// bloomBitsPerKey and bloomHashes give a false positive rate of about
+	// 1% for a filter holding its capacity.
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+	// bloomMinCapacity is the capacity of the filter of an empty bucket.
+	bloomMinCapacity = 1024
+)
+
+// bloomFilter tells whether a key may be in a bucket. Keys are never removed,
+// so a filter holds every key of its bucket since it was built, and deleted
+// keys only increase the false positive rate until the filter is rebuilt.
+type bloomFilter struct {
+	bucket Bucket
+
+	mu   sync.RWMutex
+	bits []uint64
+	// n is the number of keys added, capacity the number of keys the filter
+	// was sized for.
+	n        int
+	capacity int
+}
+
+func newBloomFilter(bucket Bucket, capacity int) *bloomFilter {
+	if capacity < bloomMinCapacity {
+		capacity = bloomMinCapacity
+	}
+	return &bloomFilter{
+		bucket:   bucket,
+		bits:     make([]uint64, (capacity*bloomBitsPerKey+63)/64),
+		capacity: capacity,
+	}
+}
+
+// bloomHash returns the two hashes combined into the bloomHashes probes of
+// key, see "Less Hashing, Same Performance" by Kirsch and Mitzenmacher.
+func bloomHash(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+func (f *bloomFilter) add(key []byte) {
+	h1, h2 := bloomHash(key)
+	m := uint32(len(f.bits) * 64)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.n++
+}
+
+func (f *bloomFilter) mayContain(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	m := uint32(len(f.bits) * 64)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// full reports whether the filter holds more keys than it was sized for, so
+// that its false positive rate is too high.
+func (f *bloomFilter) full() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.n > 2*f.capacity
+}
+
+// bloomFilters maps the designated buckets to their filter. It is never
+// modified once read txs use it: rebuilding a filter creates a new
+// bloomFilters, since the rebuilt filter no longer holds the keys deleted
+// before the rebuild, which the read txs of older commits still observe.
+type bloomFilters map[BucketID]*bloomFilter
+
+// add adds the key written to bucket to its filter, if any.
+func (bf bloomFilters) add(bucket Bucket, key []byte) {
+	if f, ok := bf[bucket.ID()]; ok {
+		f.add(key)
+	}
+}
+
+// mayContain reports false if key is definitely not in bucket.
+func (bf bloomFilters) mayContain(bucket Bucket, key []byte) bool {
+	f, ok := bf[bucket.ID()]
+	return !ok || f.mayContain(key)
+}
+
+// buildBloomFilter builds the filter of bucket from the content of tx.
+func buildBloomFilter(tx EngineTx, bucket Bucket) (*bloomFilter, error) {
+	b := tx.Bucket(bucket.Name())
+	if b == nil {
+		return newBloomFilter(bucket, 0), nil
+	}
+	var keys [][]byte
+	if err := b.ForEach(func(k, _ []byte) error {
+		keys = append(keys, k)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	f := newBloomFilter(bucket, len(keys))
+	for _, k := range keys {
+		f.add(k)
+	}
+	return f, nil
+}
+
+// initBloomFilters builds the filters of buckets from the content of the
+// database. It must be called holding the batch tx lock.
+func (b *backend) initBloomFilters(buckets []Bucket) {
+	bf := make(bloomFilters, len(buckets))
+	for _, bucket := range buckets {
+		f, err := buildBloomFilter(b.batchTx.tx, bucket)
+		if err != nil {
+			b.lg.Fatal("failed to build bloom filter", zap.Stringer("bucket-name", bucket), zap.Error(err))
+		}
+		bf[bucket.ID()] = f
+	}
+	b.blooms = bf
+	b.readTx.Lock()
+	b.readTx.blooms = bf
+	b.readTx.Unlock()
+}
+
+// rebuildFullBloomFilters rebuilds the filters holding too many keys from
+// the content of the write tx, which must have just begun. It must be called
+// holding the batch tx lock.
+func (b *backend) rebuildFullBloomFilters(tx EngineTx) {
+	var rebuilt bloomFilters
+	for id, f := range b.blooms {
+		if !f.full() {
+			continue
+		}
+		if rebuilt == nil {
+			rebuilt = make(bloomFilters, len(b.blooms))
+			for oid, of := range b.blooms {
+				rebuilt[oid] = of
+			}
+		}
+		nf, err := buildBloomFilter(tx, f.bucket)
+		if err != nil {
+			b.lg.Fatal("failed to rebuild bloom filter", zap.Stringer("bucket-name", f.bucket), zap.Error(err))
+		}
+		rebuilt[id] = nf
+	}
+	if rebuilt != nil {
+		b.blooms = rebuilt
+	}
+}
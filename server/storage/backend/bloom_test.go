@@ -0,0 +1,70 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestBackendBloomFilter(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.BatchInterval, bcfg.BatchLimit = time.Hour, 100000
+	bcfg.BloomFilterBuckets = []backend.Bucket{schema.Test}
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	get := func(key string) [][]byte {
+		rtx := b.ConcurrentReadTx()
+		rtx.RLock()
+		defer rtx.RUnlock()
+		_, vals := rtx.UnsafeRange(schema.Test, []byte(key), nil, 0)
+		return vals
+	}
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	assert.Equal(t, [][]byte{[]byte("bar")}, get("foo"), "buffered write")
+	b.ForceCommit()
+	assert.Equal(t, [][]byte{[]byte("bar")}, get("foo"), "committed write")
+	assert.Empty(t, get("missing"))
+
+	// a key written behind the back of the filter is not found.
+	require.NoError(t, backend.UnsafePutRawForTest(b, schema.Test, []byte("hidden"), []byte("v")))
+	b.ForceCommit()
+	assert.Empty(t, get("hidden"))
+
+	// the filter is rebuilt from the database once it holds too many keys.
+	tx.Lock()
+	for i := 0; i < 4096; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("foo_%d", i)), []byte("bar"))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+	assert.Equal(t, [][]byte{[]byte("v")}, get("hidden"))
+	assert.Equal(t, [][]byte{[]byte("bar")}, get("foo_4095"))
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrBucketNotHashed is returned for a bucket without an incremental
+	// hash, see BackendConfig.HashedBuckets.
+	ErrBucketNotHashed = errors.New("backend: bucket has no incremental hash")
+	// ErrBucketHashMismatch is returned when the content of a bucket does
+	// not match its incremental hash.
+	ErrBucketHashMismatch = errors.New("backend: bucket hash mismatch")
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// bucketHashes holds the incremental hashes of the designated buckets. The
+// hash of a bucket is the sum of the hashes of its key-value pairs, so it
+// does not depend on the order of the writes and is updated in constant
+// time by every write. It is protected by the batch tx lock.
+type bucketHashes struct {
+	sums map[string]uint64
+}
+
+func newBucketHashes(buckets []Bucket) *bucketHashes {
+	h := &bucketHashes{sums: make(map[string]uint64, len(buckets))}
+	for _, bucket := range buckets {
+		h.sums[string(bucket.Name())] = 0
+	}
+	return h
+}
+
+// pairHash returns the hash of a key-value pair. The key length is hashed
+// too, so that moving bytes between the key and the value changes the hash.
+func pairHash(k, v []byte) uint64 {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(k)))
+	h := crc64.Update(0, crc64Table, lenBuf[:n])
+	h = crc64.Update(h, crc64Table, k)
+	return crc64.Update(h, crc64Table, v)
+}
+
+// sumBucket returns the incremental hash of the content of bucket in tx.
+func sumBucket(tx EngineTx, bucket Bucket, vc *valueCodec) (uint64, error) {
+	var sum uint64
+	err := unsafeForEach(tx, bucket, vc, func(k, v []byte) error {
+		sum += pairHash(k, v)
+		return nil
+	})
+	return sum, err
+}
+
+// put updates the hash of bucket for the write of key, which held old.
+func (h *bucketHashes) put(bucket, key, old, value []byte) {
+	sum, ok := h.sums[string(bucket)]
+	if !ok {
+		return
+	}
+	if old != nil {
+		sum -= pairHash(key, old)
+	}
+	h.sums[string(bucket)] = sum + pairHash(key, value)
+}
+
+// delete updates the hash of bucket for the deletion of key, which held old.
+func (h *bucketHashes) delete(bucket, key, old []byte) {
+	if sum, ok := h.sums[string(bucket)]; ok && old != nil {
+		h.sums[string(bucket)] = sum - pairHash(key, old)
+	}
+}
+
+func (h *bucketHashes) reset(bucket []byte) {
+	if _, ok := h.sums[string(bucket)]; ok {
+		h.sums[string(bucket)] = 0
+	}
+}
+
+func (h *bucketHashes) tracks(bucket []byte) bool {
+	_, ok := h.sums[string(bucket)]
+	return ok
+}
+
+// initBucketHashes computes the incremental hashes from the content of the
+// database. It must be called holding the batch tx lock.
+func (b *backend) initBucketHashes(buckets []Bucket) {
+	b.bucketHashes = newBucketHashes(buckets)
+	for _, bucket := range buckets {
+		sum, err := sumBucket(b.batchTx.tx, bucket, b.codec)
+		if err != nil {
+			b.lg.Fatal("failed to hash bucket", zap.Stringer("bucket-name", bucket), zap.Error(err))
+		}
+		b.bucketHashes.sums[string(bucket.Name())] = sum
+	}
+}
+
+// HashBucket computes the hash of a single bucket the same way Hash does for
+// the whole backend. A nil ignores hashes every key.
+func (b *backend) HashBucket(bucket Bucket, ignores func(bucketName, keyName []byte) bool) (uint32, error) {
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+
+	tx, err := b.beginReadTx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	h.Write(bucket.Name())
+	err = unsafeForEach(tx, bucket, b.codec, func(k, v []byte) error {
+		if ignores == nil || !ignores(bucket.Name(), k) {
+			h.Write(k)
+			h.Write(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// IncrementalHash returns the hash of bucket maintained on writes, including
+// the pending ones. It is only available for the buckets listed in
+// BackendConfig.HashedBuckets.
+func (b *backend) IncrementalHash(bucket Bucket) (uint64, error) {
+	b.batchTx.lock()
+	defer b.batchTx.Unlock()
+	if b.bucketHashes == nil || !b.bucketHashes.tracks(bucket.Name()) {
+		return 0, ErrBucketNotHashed
+	}
+	b.batchTx.flushSeqRun(bucket.ID())
+	return b.bucketHashes.sums[string(bucket.Name())], nil
+}
+
+// VerifyBucketHash checks the content of bucket against its incremental
+// hash. Only the given bucket is read, so it is much cheaper than comparing
+// the Hash of the whole backend.
+func (b *backend) VerifyBucketHash(bucket Bucket) error {
+	// commit so that the read tx observes the writes covered by the hash.
+	b.batchTx.lock()
+	if b.bucketHashes == nil || !b.bucketHashes.tracks(bucket.Name()) {
+		b.batchTx.Unlock()
+		return ErrBucketNotHashed
+	}
+	b.batchTx.commit(false)
+	want := b.bucketHashes.sums[string(bucket.Name())]
+	tx, err := b.beginReadTx()
+	b.batchTx.Unlock()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	got, err := sumBucket(tx, bucket, b.codec)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%w: bucket %s has hash %x, expected %x", ErrBucketHashMismatch, bucket, got, want)
+	}
+	return nil
+}
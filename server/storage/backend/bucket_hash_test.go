@@ -0,0 +1,107 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestBackendHashBucket(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.UnsafePut(schema.Test, []byte("baz"), []byte("qux"))
+	tx.Unlock()
+	b.ForceCommit()
+
+	// with a single bucket, the bucket hash matches the backend hash.
+	want, err := b.Hash(func(bucketName, keyName []byte) bool { return false })
+	require.NoError(t, err)
+	got, err := b.(backend.BucketHasher).HashBucket(schema.Test, nil)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	ignored, err := b.(backend.BucketHasher).HashBucket(schema.Test, func(bucketName, keyName []byte) bool { return string(keyName) == "foo" })
+	require.NoError(t, err)
+	assert.NotEqual(t, want, ignored)
+}
+
+func TestBackendIncrementalHash(t *testing.T) {
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.BatchInterval, bcfg.BatchLimit = time.Hour, 10000
+	bcfg.HashedBuckets = []backend.Bucket{schema.Key}
+	b, path := betesting.NewTmpBackendFromCfg(t, bcfg)
+
+	_, err := b.(backend.BucketHasher).IncrementalHash(schema.Test)
+	require.ErrorIs(t, err, backend.ErrBucketNotHashed)
+	require.ErrorIs(t, b.(backend.BucketHasher).VerifyBucketHash(schema.Test), backend.ErrBucketNotHashed)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.UnsafePut(schema.Key, []byte("foo"), []byte("bar"))
+	tx.UnsafePut(schema.Key, []byte("baz"), []byte("qux"))
+	tx.Unlock()
+	require.NoError(t, b.(backend.BucketHasher).VerifyBucketHash(schema.Key))
+	sum, err := b.(backend.BucketHasher).IncrementalHash(schema.Key)
+	require.NoError(t, err)
+
+	// the hash depends on the content only, not on the history of writes.
+	tx.Lock()
+	tx.UnsafePut(schema.Key, []byte("foo"), []byte("changed"))
+	tx.UnsafePut(schema.Key, []byte("new"), []byte("value"))
+	tx.UnsafeDelete(schema.Key, []byte("new"))
+	tx.UnsafeDelete(schema.Key, []byte("missing"))
+	tx.UnsafePut(schema.Key, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	require.NoError(t, b.(backend.BucketHasher).VerifyBucketHash(schema.Key))
+	got, err := b.(backend.BucketHasher).IncrementalHash(schema.Key)
+	require.NoError(t, err)
+	assert.Equal(t, sum, got)
+
+	// corrupt the bucket behind the backend.
+	require.NoError(t, backend.UnsafePutRawForTest(b, schema.Key, []byte("foo"), []byte("corrupted")))
+	require.ErrorIs(t, b.(backend.BucketHasher).VerifyBucketHash(schema.Key), backend.ErrBucketHashMismatch)
+	betesting.Close(t, b)
+
+	// the hash is recomputed from the content on open.
+	bcfg.Path = path
+	b = backend.New(bcfg)
+	defer betesting.Close(t, b)
+	require.NoError(t, b.(backend.BucketHasher).VerifyBucketHash(schema.Key))
+
+	tx = b.BatchTx()
+	tx.Lock()
+	tx.UnsafeDeleteBucket(schema.Key)
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.Unlock()
+	got, err = b.(backend.BucketHasher).IncrementalHash(schema.Key)
+	require.NoError(t, err)
+	assert.Zero(t, got)
+	require.NoError(t, b.(backend.BucketHasher).VerifyBucketHash(schema.Key))
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sync"
+)
+
+// BucketBatchTx writes to a single bucket without holding the lock of the
+// BatchTx, so that writers of different buckets do not contend on it. The
+// writes are visible to reads once Unlock returns, and are merged into the
+// BatchTx on its next commit.
+//
+// A key written through a BucketBatchTx must not be concurrently written
+// through the BatchTx, since the order of the two writes is undefined.
+type BucketBatchTx interface {
+	Lock()
+	Unlock()
+	// UnsafePut must be called holding the lock on the tx.
+	UnsafePut(key []byte, value []byte)
+}
+
+// bucketShard is the BucketBatchTx of a bucket.
+//
+// Lock ordering: mu, then readTx, then pendingMu. The batchTx never
+// acquires mu, so it can drain the shard while a writer holds it.
+type bucketShard struct {
+	mu      sync.Mutex
+	bucket  Bucket
+	backend *backend
+	// buf holds the writes made while mu is held.
+	buf txWriteBuffer
+
+	// pendingMu protects pending.
+	pendingMu sync.Mutex
+	// pending holds the writes not merged into the batchTx yet.
+	pending []kv
+}
+
+func newBucketShard(b *backend, bucket Bucket) *bucketShard {
+	return &bucketShard{
+		bucket:  bucket,
+		backend: b,
+		buf: txWriteBuffer{
+			txBuffer:   txBuffer{make(map[BucketID]*bucketBuffer)},
+			bucket2seq: make(map[BucketID]bool),
+		},
+	}
+}
+
+func (s *bucketShard) Lock() {
+	s.mu.Lock()
+}
+
+func (s *bucketShard) UnsafePut(key []byte, value []byte) {
+	s.buf.put(s.bucket, key, value)
+}
+
+// Unlock publishes the writes made while holding the lock to the read buffer
+// and to the pending writes of the shard. The pending writes are committed
+// once they reach the batch limit.
+func (s *bucketShard) Unlock() {
+	var pending int
+	if wb, ok := s.buf.buckets[s.bucket.ID()]; ok && wb.used > 0 {
+		kvs := make([]kv, wb.used)
+		copy(kvs, wb.buf[:wb.used])
+
+		s.backend.readTx.Lock()
+		s.buf.writeback(&s.backend.readTx.buf)
+		s.pendingMu.Lock()
+		s.pending = append(s.pending, kvs...)
+		pending = len(s.pending)
+		s.pendingMu.Unlock()
+		s.backend.readTx.Unlock()
+	}
+	s.mu.Unlock()
+
+	if pending >= s.backend.getBatchLimit() {
+		s.backend.batchTx.Commit()
+	}
+}
+
+// pendingN returns the number of writes not merged into the batchTx yet.
+func (s *bucketShard) pendingN() int {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	return len(s.pending)
+}
+
+// BucketBatchTx returns the BucketBatchTx of bucket.
+func (b *backend) BucketBatchTx(bucket Bucket) BucketBatchTx {
+	t := b.batchTx
+	t.shardsMu.Lock()
+	defer t.shardsMu.Unlock()
+	s, ok := t.shards[bucket.ID()]
+	if !ok {
+		s = newBucketShard(b, bucket)
+		t.shards[bucket.ID()] = s
+	}
+	return s
+}
+
+// drainShard writes the pending writes of the shard of the bucket to the tx.
+// It must be called holding the lock on the tx.
+func (t *batchTxBuffered) drainShard(id BucketID) {
+	t.shardsMu.Lock()
+	s, ok := t.shards[id]
+	t.shardsMu.Unlock()
+	if !ok {
+		return
+	}
+	s.pendingMu.Lock()
+	kvs := s.pending
+	s.pending = nil
+	s.pendingMu.Unlock()
+	if len(kvs) == 0 {
+		return
+	}
+	t.flushSeqRun(id)
+	for _, p := range kvs {
+		t.batchTx.unsafePut(s.bucket, p.key, p.val, false)
+	}
+}
+
+func (t *batchTxBuffered) drainShards() {
+	t.shardsMu.Lock()
+	ids := make([]BucketID, 0, len(t.shards))
+	for id := range t.shards {
+		ids = append(ids, id)
+	}
+	t.shardsMu.Unlock()
+	for _, id := range ids {
+		t.drainShard(id)
+	}
+}
+
+// shardsPending returns the number of writes of all shards not merged into
+// the batchTx yet.
+func (t *batchTxBuffered) shardsPending() int {
+	t.shardsMu.Lock()
+	defer t.shardsMu.Unlock()
+	n := 0
+	for _, s := range t.shards {
+		n += s.pendingN()
+	}
+	return n
+}
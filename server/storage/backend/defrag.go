@@ -0,0 +1,275 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// defragCatchUpRounds is the maximum number of times the writes made during
+// an online defragmentation are replayed without blocking the backend. Once
+// the delta is small enough, or the rounds are exhausted, the remaining
+// delta is replayed while holding the backend locks.
+const defragCatchUpRounds = 3
+
+// defragDelta records the keys written while an online defragmentation copies
+// the database, so they can be replayed into the copy before it replaces the
+// database. Only the location of a write is recorded; its value is read back
+// from the database when the delta is replayed.
+type defragDelta struct {
+	// keys holds the written keys of every touched bucket.
+	keys map[string]map[string]struct{}
+	// reset holds the buckets that were deleted, which are copied as a
+	// whole when the delta is replayed.
+	reset map[string]struct{}
+	n     int
+}
+
+func newDefragDelta() *defragDelta {
+	return &defragDelta{
+		keys:  make(map[string]map[string]struct{}),
+		reset: make(map[string]struct{}),
+	}
+}
+
+func (d *defragDelta) addBucket(bucket []byte) map[string]struct{} {
+	keys, ok := d.keys[string(bucket)]
+	if !ok {
+		keys = make(map[string]struct{})
+		d.keys[string(bucket)] = keys
+	}
+	return keys
+}
+
+func (d *defragDelta) resetBucket(bucket []byte) {
+	d.addBucket(bucket)
+	d.reset[string(bucket)] = struct{}{}
+}
+
+func (d *defragDelta) addKey(bucket, key []byte) {
+	keys := d.addBucket(bucket)
+	if _, ok := keys[string(key)]; !ok {
+		keys[string(key)] = struct{}{}
+		d.n++
+	}
+}
+
+// len returns the number of distinct keys in the delta.
+func (d *defragDelta) len() int {
+	return d.n
+}
+
+// replay brings every bucket and key of the delta in dst up to date with src.
+func (d *defragDelta) replay(src EngineTx, dst *bolt.DB) error {
+	return dst.Update(func(tx *bolt.Tx) error {
+		for name, keys := range d.keys {
+			sb := src.Bucket([]byte(name))
+			if _, ok := d.reset[name]; ok || sb == nil {
+				if err := tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+					return err
+				}
+				if sb == nil {
+					continue
+				}
+				db, err := tx.CreateBucket([]byte(name))
+				if err != nil {
+					return err
+				}
+				db.FillPercent = 0.9
+				if err = sb.ForEach(db.Put); err != nil {
+					return err
+				}
+				continue
+			}
+
+			db, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for k := range keys {
+				if v := sb.Get([]byte(k)); v != nil {
+					err = db.Put([]byte(k), v)
+				} else {
+					err = db.Delete([]byte(k))
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// defragCursor is the position of an online defragmentation copy: the last
+// copied key and its bucket.
+type defragCursor struct {
+	bucket []byte
+	key    []byte
+}
+
+// defragBatch is a run of key-value pairs of a bucket read from the
+// database being defragmented. The pairs are only valid for the lifetime of
+// the read transaction.
+type defragBatch struct {
+	bucket     []byte
+	keys, vals [][]byte
+}
+
+const (
+	// defragBatchSize is the number of pairs in a defragBatch.
+	defragBatchSize = 1024
+	// defragPipelineDepth is the number of batches buffered between the
+	// reader and the writer of a pipelined copy.
+	defragPipelineDepth = 4
+)
+
+// defragCopyChunk copies up to limit keys from odb into tmpdb, starting after
+// the position of cur, and advances cur. Each chunk is copied from its own
+// read transaction, so writes to odb are not blocked between chunks. It
+// returns true once every bucket has been copied.
+//
+// If pipelined is set, odb is read in a separate goroutine from the one
+// writing into tmpdb, so page faults on the source overlap with the writes.
+func defragCopyChunk(odb, tmpdb *bolt.DB, cur *defragCursor, limit int, pipelined bool) (bool, error) {
+	tx, err := odb.Begin(false)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	tmptx, err := tmpdb.Begin(true)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			tmptx.Rollback()
+		}
+	}()
+
+	w := &defragWriter{tx: tmptx}
+	var done bool
+	if !pipelined {
+		done, err = defragReadChunk(tx, cur, limit, w.write)
+	} else {
+		batches := make(chan defragBatch, defragPipelineDepth)
+		errc := make(chan error, 1)
+		go func() {
+			var werr error
+			for b := range batches {
+				if werr == nil {
+					werr = w.write(b)
+				}
+			}
+			errc <- werr
+		}()
+		done, err = defragReadChunk(tx, cur, limit, func(b defragBatch) error {
+			batches <- b
+			return nil
+		})
+		close(batches)
+		if werr := <-errc; err == nil {
+			err = werr
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	err = tmptx.Commit()
+	return done, err
+}
+
+// defragReadChunk reads up to limit keys from tx starting after the position
+// of cur, passes them to emit in batches and advances cur. Every bucket is
+// emitted at least once, so that empty buckets are copied too.
+func defragReadChunk(tx *bolt.Tx, cur *defragCursor, limit int, emit func(defragBatch) error) (bool, error) {
+	c := tx.Cursor()
+	var next []byte
+	if cur.bucket == nil {
+		next, _ = c.First()
+	} else {
+		next, _ = c.Seek(cur.bucket)
+	}
+
+	count := 0
+	for ; next != nil; next, _ = c.Next() {
+		b := tx.Bucket(next)
+		if b == nil {
+			return false, fmt.Errorf("backend: cannot defrag bucket %s", next)
+		}
+
+		bc := b.Cursor()
+		var k, v []byte
+		if bytes.Equal(next, cur.bucket) && cur.key != nil {
+			k, v = bc.Seek(cur.key)
+			if bytes.Equal(k, cur.key) {
+				k, v = bc.Next()
+			}
+		} else {
+			k, v = bc.First()
+		}
+		batch := defragBatch{bucket: next}
+		for ; k != nil; k, v = bc.Next() {
+			batch.keys = append(batch.keys, k)
+			batch.vals = append(batch.vals, v)
+			count++
+			if count >= limit {
+				cur.bucket = bytes.Clone(next)
+				cur.key = bytes.Clone(k)
+				return false, emit(batch)
+			}
+			if len(batch.keys) == defragBatchSize {
+				if err := emit(batch); err != nil {
+					return false, err
+				}
+				batch = defragBatch{bucket: next}
+			}
+		}
+		if err := emit(batch); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// defragWriter writes defragBatches into the transaction of the new database.
+type defragWriter struct {
+	tx     *bolt.Tx
+	name   []byte
+	bucket *bolt.Bucket
+}
+
+func (w *defragWriter) write(b defragBatch) error {
+	if w.bucket == nil || !bytes.Equal(w.name, b.bucket) {
+		bucket, err := w.tx.CreateBucketIfNotExists(b.bucket)
+		if err != nil {
+			return err
+		}
+		bucket.FillPercent = 0.9 // for bucket2seq write in for each
+		w.name, w.bucket = b.bucket, bucket
+	}
+	for i := range b.keys {
+		if err := w.bucket.Put(b.keys[i], b.vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"io"
+	"time"
+)
+
+// KVEngine is the ordered key-value storage engine the backend persists its
+// buckets to. bbolt is the default engine; alternative engines can be wired
+// in through BackendConfig.Engine.
+//
+// The backend batches writes into a single writable transaction and serves
+// reads from a read-only transaction that is renewed on every commit, so an
+// engine must allow one writable transaction to run concurrently with any
+// number of read-only ones.
+type KVEngine interface {
+	// Begin starts a new transaction.
+	Begin(writable bool) (EngineTx, error)
+	// Path returns the path of the database.
+	Path() string
+	// Stats returns the current engine statistics.
+	Stats() EngineStats
+	// Close closes the database. All transactions must be closed first.
+	Close() error
+}
+
+// EngineStats reports space and transaction usage of a KVEngine.
+type EngineStats struct {
+	// FreeBytes is the number of allocated bytes that are not in use.
+	FreeBytes int64
+	// OpenReadTxN is the number of currently open read transactions.
+	OpenReadTxN int
+	// ReadTxN is the total number of started read transactions.
+	ReadTxN int
+
+	// FreePageN is the number of free pages on the freelist.
+	FreePageN int
+	// PendingPageN is the number of pages freed by committed transactions
+	// that cannot be reused until the read transactions observing them end.
+	PendingPageN int
+	// FreelistInuse is the number of bytes used by the freelist.
+	FreelistInuse int
+
+	// TxStats accumulates the statistics of every committed transaction.
+	TxStats EngineTxStats
+}
+
+// EngineTx is a transaction of a KVEngine.
+// A read-only transaction may be used by several goroutines at the same
+// time as long as they do not open buckets concurrently.
+type EngineTx interface {
+	// Bucket returns the bucket with the given name, or nil if it does not exist.
+	Bucket(name []byte) EngineBucket
+	CreateBucketIfNotExists(name []byte) (EngineBucket, error)
+	// DeleteBucket deletes the bucket with the given name. Deleting a bucket
+	// that does not exist is not an error.
+	DeleteBucket(name []byte) error
+	// ForEachBucket calls fn for every bucket in key order.
+	ForEachBucket(fn func(name []byte, b EngineBucket) error) error
+	// Size returns the size of the database as seen by this transaction.
+	Size() int64
+	// WriteTo writes a consistent copy of the database to w.
+	WriteTo(w io.Writer) (int64, error)
+	// Stats returns the statistics of the transaction, valid after Commit.
+	Stats() EngineTxStats
+	Commit() error
+	Rollback() error
+}
+
+// EngineTxStats reports where the time of a commit was spent. Engines that
+// do not track a phase leave it zero.
+type EngineTxStats struct {
+	RebalanceTime time.Duration
+	SpillTime     time.Duration
+	WriteTime     time.Duration
+
+	// PageCount and PageAlloc are the number and bytes of page allocations.
+	PageCount int64
+	PageAlloc int64
+	// Rebalance, Split and Spill are the number of node operations.
+	Rebalance int64
+	Split     int64
+	Spill     int64
+	// Write is the number of writes performed.
+	Write int64
+}
+
+func (s EngineTxStats) add(o EngineTxStats) EngineTxStats {
+	return EngineTxStats{
+		RebalanceTime: s.RebalanceTime + o.RebalanceTime,
+		SpillTime:     s.SpillTime + o.SpillTime,
+		WriteTime:     s.WriteTime + o.WriteTime,
+		PageCount:     s.PageCount + o.PageCount,
+		PageAlloc:     s.PageAlloc + o.PageAlloc,
+		Rebalance:     s.Rebalance + o.Rebalance,
+		Split:         s.Split + o.Split,
+		Spill:         s.Spill + o.Spill,
+		Write:         s.Write + o.Write,
+	}
+}
+
+// EngineBucket is an ordered collection of key-value pairs.
+// Keys and values returned by a bucket are only valid for the lifetime of
+// the transaction.
+type EngineBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Cursor() EngineCursor
+	ForEach(fn func(k, v []byte) error) error
+	// SetSequential hints that keys are written in increasing order, so the
+	// engine can lay them out densely.
+	SetSequential()
+}
+
+// EngineCursor iterates over the keys of a bucket in order.
+// Every method returns a nil key once the cursor is exhausted.
+type EngineCursor interface {
+	First() (key, value []byte)
+	Seek(seek []byte) (key, value []byte)
+	Next() (key, value []byte)
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// boltEngine is the default KVEngine, backed by bbolt.
+type boltEngine struct {
+	db *bolt.DB
+}
+
+func openBoltEngine(path string, opts *bolt.Options) (*boltEngine, error) {
+	db, err := bolt.Open(path, 0600, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &boltEngine{db: db}, nil
+}
+
+func (e *boltEngine) Begin(writable bool) (EngineTx, error) {
+	tx, err := e.db.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &boltTx{tx}, nil
+}
+
+func (e *boltEngine) Path() string { return e.db.Path() }
+
+func (e *boltEngine) Stats() EngineStats {
+	stats := e.db.Stats()
+	return EngineStats{
+		FreeBytes:     int64(stats.FreePageN) * int64(e.db.Info().PageSize),
+		OpenReadTxN:   stats.OpenTxN,
+		ReadTxN:       stats.TxN,
+		FreePageN:     stats.FreePageN,
+		PendingPageN:  stats.PendingPageN,
+		FreelistInuse: stats.FreelistInuse,
+		TxStats:       boltTxStats(&stats.TxStats),
+	}
+}
+
+func (e *boltEngine) Close() error { return e.db.Close() }
+
+type boltTx struct {
+	*bolt.Tx
+}
+
+func (tx *boltTx) Bucket(name []byte) EngineBucket {
+	b := tx.Tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return &boltBucket{b}
+}
+
+func (tx *boltTx) CreateBucketIfNotExists(name []byte) (EngineBucket, error) {
+	b, err := tx.Tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return &boltBucket{b}, nil
+}
+
+func (tx *boltTx) DeleteBucket(name []byte) error {
+	err := tx.Tx.DeleteBucket(name)
+	if errors.Is(err, bolterrors.ErrBucketNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (tx *boltTx) ForEachBucket(fn func(name []byte, b EngineBucket) error) error {
+	return tx.Tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return fn(name, &boltBucket{b})
+	})
+}
+
+func (tx *boltTx) WriteTo(w io.Writer) (int64, error) { return tx.Tx.WriteTo(w) }
+
+func (tx *boltTx) Stats() EngineTxStats {
+	stats := tx.Tx.Stats()
+	return boltTxStats(&stats)
+}
+
+func boltTxStats(stats *bolt.TxStats) EngineTxStats {
+	return EngineTxStats{
+		RebalanceTime: stats.GetRebalanceTime(),
+		SpillTime:     stats.GetSpillTime(),
+		WriteTime:     stats.GetWriteTime(),
+		PageCount:     stats.GetPageCount(),
+		PageAlloc:     stats.GetPageAlloc(),
+		Rebalance:     stats.GetRebalance(),
+		Split:         stats.GetSplit(),
+		Spill:         stats.GetSpill(),
+		Write:         stats.GetWrite(),
+	}
+}
+
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func (b *boltBucket) Cursor() EngineCursor { return b.Bucket.Cursor() }
+
+func (b *boltBucket) SetSequential() {
+	// it is useful to increase fill percent when the workloads are mostly append-only.
+	// this can delay the page split and reduce space usage.
+	b.FillPercent = 0.9
+}
@@ -14,12 +14,21 @@
 
 package backend
 
-import bolt "go.etcd.io/bbolt"
+import (
+	dto "github.com/prometheus/client_model/go"
+	bolt "go.etcd.io/bbolt"
+)
 
 func DbFromBackendForTest(b Backend) *bolt.DB {
-	return b.(*backend).db
+	return b.(*backend).db.(*boltEngine).db
 }
 
+func OpenBoltEngineForTest(path string) (KVEngine, error) {
+	return openBoltEngine(path, &bolt.Options{})
+}
+
+var MultiCommitBucketForTest = multiCommitBucket
+
 func DefragLimitForTest() int {
 	return defragLimit
 }
@@ -27,3 +36,23 @@ func DefragLimitForTest() int {
 func CommitsForTest(b Backend) int64 {
 	return b.(*backend).Commits()
 }
+
+func BatchLimitForTest(b Backend) int {
+	return b.(*backend).getBatchLimit()
+}
+
+// UnsafePutRawForTest writes to the pending batch tx behind the back of the
+// backend, to simulate a corruption.
+func UnsafePutRawForTest(b Backend, bucket Bucket, key, value []byte) error {
+	be := b.(*backend)
+	be.batchTx.lock()
+	defer be.batchTx.Unlock()
+	be.batchTx.pending++
+	return be.batchTx.tx.Bucket(bucket.Name()).Put(key, value)
+}
+
+func RangePrefetchKeysForTest() int64 {
+	m := &dto.Metric{}
+	rangePrefetchKeys.Write(m)
+	return int64(m.GetCounter().GetValue())
+}
@@ -14,8 +14,27 @@
 
 package backend
 
+import "time"
+
 type HookFunc func(tx UnsafeReadWriter)
 
+type PostCommitHookFunc func(stats CommitStats)
+
+// CommitStats summarizes a committed batch transaction.
+type CommitStats struct {
+	// Pending is the number of operations committed.
+	Pending int
+	// Bytes is the number of key and value bytes put by the transaction.
+	Bytes int64
+	// Duration is the time the commit took.
+	Duration time.Duration
+	// RebalanceTime, SpillTime and WriteTime break down the commit as
+	// reported by the storage engine.
+	RebalanceTime time.Duration
+	SpillTime     time.Duration
+	WriteTime     time.Duration
+}
+
 // Hooks allow to add additional logic executed during transaction lifetime.
 type Hooks interface {
 	// OnPreCommitUnsafe is executed before Commit of transactions.
@@ -23,14 +42,35 @@ type Hooks interface {
 	OnPreCommitUnsafe(tx UnsafeReadWriter)
 }
 
+// PostCommitHooks are Hooks also executed after Commit of transactions.
+type PostCommitHooks interface {
+	Hooks
+	// OnPostCommit is executed after Commit of transactions, while the
+	// batch transaction is still locked, so it must not use the backend.
+	OnPostCommit(stats CommitStats)
+}
+
 type hooks struct {
 	onPreCommitUnsafe HookFunc
+	onPostCommit      PostCommitHookFunc
 }
 
 func (h hooks) OnPreCommitUnsafe(tx UnsafeReadWriter) {
-	h.onPreCommitUnsafe(tx)
+	if h.onPreCommitUnsafe != nil {
+		h.onPreCommitUnsafe(tx)
+	}
+}
+
+func (h hooks) OnPostCommit(stats CommitStats) {
+	if h.onPostCommit != nil {
+		h.onPostCommit(stats)
+	}
 }
 
 func NewHooks(onPreCommitUnsafe HookFunc) Hooks {
 	return hooks{onPreCommitUnsafe: onPreCommitUnsafe}
 }
+
+func NewHooksWithPostCommit(onPreCommitUnsafe HookFunc, onPostCommit PostCommitHookFunc) PostCommitHooks {
+	return hooks{onPreCommitUnsafe: onPreCommitUnsafe, onPostCommit: onPostCommit}
+}
@@ -47,6 +47,33 @@ func TestBackendPreCommitHook(t *testing.T) {
 	assert.Equal(t, ">ccc", getCommitsKey(t, be), "expected 3 explicit commits")
 }
 
+func TestBackendPostCommitHook(t *testing.T) {
+	var stats []backend.CommitStats
+	cfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	cfg.BatchInterval = time.Hour
+	cfg.Hooks = backend.NewHooksWithPostCommit(nil, func(s backend.CommitStats) {
+		stats = append(stats, s)
+	})
+	be, _ := betesting.NewTmpBackendFromCfg(t, cfg)
+	defer betesting.Close(t, be)
+
+	tx := be.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(bucket)
+	tx.UnsafePut(bucket, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	tx.Commit()
+
+	assert.Len(t, stats, 1)
+	assert.Equal(t, 2, stats[0].Pending)
+	assert.Equal(t, int64(len("foo")+len("bar")), stats[0].Bytes)
+	assert.Positive(t, stats[0].Duration)
+
+	// Empty commit.
+	tx.Commit()
+	assert.Len(t, stats, 1)
+}
+
 func TestBackendAutoCommitLimitHook(t *testing.T) {
 	cfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
 	cfg.BatchLimit = 3
@@ -84,6 +84,62 @@ var (
 		Buckets: prometheus.ExponentialBuckets(.01, 2, 17),
 	})
 
+	rangePrefetchKeys = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "disk",
+		Name:      "backend_range_prefetch_keys_total",
+		Help:      "The total number of keys read ahead of ranges served by bboltdb backend.",
+	})
+
+	readTxAborted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "disk",
+		Name:      "backend_read_tx_aborted_total",
+		Help:      "The total number of concurrent read transactions aborted after exceeding their deadline.",
+	})
+
+	freePages = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "disk",
+		Name:      "backend_free_pages",
+		Help:      "The number of free pages on the freelist of bboltdb backend.",
+	})
+
+	pendingPages = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "disk",
+		Name:      "backend_pending_pages",
+		Help:      "The number of freed pages of bboltdb backend that are still observed by open read transactions.",
+	})
+
+	freelistInuseBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "disk",
+		Name:      "backend_freelist_inuse_bytes",
+		Help:      "The number of bytes used by the freelist of bboltdb backend.",
+	})
+
+	rangeBloomSkips = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "disk",
+		Name:      "backend_range_bloom_skips_total",
+		Help:      "The total number of single key ranges of missing keys answered by a bloom filter without reading bboltdb backend.",
+	})
+
+	readBufferBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "disk",
+		Name:      "backend_read_buffer_bytes",
+		Help:      "The number of bytes of the uncommitted writes buffered for reads by bboltdb backend.",
+	})
+
+	autoDefragChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "disk",
+		Name:      "backend_auto_defrag_checks_total",
+		Help:      "The total number of automatic defragmentation checks by outcome.",
+	}, []string{"outcome"})
+
 	isDefragActive = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "etcd",
 		Subsystem: "disk",
@@ -99,5 +155,13 @@ func init() {
 	prometheus.MustRegister(writeSec)
 	prometheus.MustRegister(defragSec)
 	prometheus.MustRegister(snapshotTransferSec)
+	prometheus.MustRegister(rangePrefetchKeys)
+	prometheus.MustRegister(readTxAborted)
+	prometheus.MustRegister(freePages)
+	prometheus.MustRegister(pendingPages)
+	prometheus.MustRegister(freelistInuseBytes)
+	prometheus.MustRegister(readBufferBytes)
+	prometheus.MustRegister(rangeBloomSkips)
+	prometheus.MustRegister(autoDefragChecks)
 	prometheus.MustRegister(isDefragActive)
 }
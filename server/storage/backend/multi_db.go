@@ -0,0 +1,358 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// NamedDatabase stores a set of buckets in a bbolt file of its own, so that
+// their fsyncs and mmap do not interfere with the buckets of the main file.
+type NamedDatabase struct {
+	// Name identifies the database in logs.
+	Name string
+	// Path is the path of the bbolt file.
+	Path string
+	// Buckets are the buckets stored in the database. A bucket must belong
+	// to a single database.
+	Buckets []Bucket
+}
+
+// ErrDatabasesMismatch is returned when opening a backend whose named
+// databases are not at the commit of its main file, e.g. after a crash in
+// the middle of a commit.
+var ErrDatabasesMismatch = errors.New("backend: named database does not match the main database")
+
+var (
+	// multiCommitBucket holds, in every file of a multiEngine, the sequence
+	// number of the last commit that wrote the file. It is not listed by
+	// ForEachBucket.
+	multiCommitBucket = []byte("multi_db_commit")
+	multiCommitKey    = []byte("seq")
+)
+
+// multiEngine routes every bucket to the bbolt file it belongs to: the main
+// file, or one of the named databases. Transactions span every file.
+type multiEngine struct {
+	main *boltEngine
+	dbs  []*boltEngine
+	// route maps a bucket name to the index of its database in dbs.
+	route map[string]int
+	// seq is the sequence number of the last commit. It is written to
+	// every file and only changed by the single writable tx.
+	seq uint64
+}
+
+// openMultiEngine opens the main file at path and the named databases.
+// Buckets of a named database found in the main file, e.g. after restoring
+// a snapshot or adding a named database to an existing one, are moved to
+// the named database.
+func openMultiEngine(path string, named []NamedDatabase, opts *bolt.Options) (*multiEngine, error) {
+	main, err := openBoltEngine(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	e := &multiEngine{main: main, route: make(map[string]int)}
+	for i, nd := range named {
+		db, err := openBoltEngine(nd.Path, opts)
+		if err != nil {
+			e.Close()
+			return nil, err
+		}
+		e.dbs = append(e.dbs, db)
+		for _, bucket := range nd.Buckets {
+			e.route[string(bucket.Name())] = i
+			if err = moveBucket(main.db, db.db, bucket.Name()); err != nil {
+				e.Close()
+				return nil, err
+			}
+		}
+	}
+	if err = e.checkCommits(named); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// checkCommits checks that every named database is at the commit of the main
+// file. Files without a commit, e.g. a main file restored from a snapshot or
+// a named database added to an existing backend, are not checked.
+func (e *multiEngine) checkCommits(named []NamedDatabase) error {
+	seq, ok, err := readCommitSeq(e.main.db)
+	if err != nil || !ok {
+		return err
+	}
+	e.seq = seq
+	for i, db := range e.dbs {
+		dseq, ok, err := readCommitSeq(db.db)
+		if err != nil {
+			return err
+		}
+		if ok && dseq != seq {
+			return fmt.Errorf("%w: %s is at commit %d, the main file %s at commit %d; restore the member from a snapshot",
+				ErrDatabasesMismatch, named[i].Path, dseq, e.main.Path(), seq)
+		}
+	}
+	return nil
+}
+
+func readCommitSeq(db *bolt.DB) (seq uint64, ok bool, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(multiCommitBucket); b != nil {
+			if v := b.Get(multiCommitKey); len(v) == 8 {
+				seq, ok = binary.BigEndian.Uint64(v), true
+			}
+		}
+		return nil
+	})
+	return seq, ok, err
+}
+
+func putCommitSeq(tx EngineTx, seq uint64) error {
+	b, err := tx.CreateBucketIfNotExists(multiCommitBucket)
+	if err != nil {
+		return err
+	}
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], seq)
+	return b.Put(multiCommitKey, v[:])
+}
+
+// moveBucket moves the bucket with the given name from src to dst, replacing
+// the bucket of dst. The bucket is written to dst before it is deleted from
+// src, so an interrupted move is completed on the next open.
+func moveBucket(src, dst *bolt.DB, name []byte) error {
+	stx, err := src.Begin(false)
+	if err != nil {
+		return err
+	}
+	sb := stx.Bucket(name)
+	if sb == nil {
+		return stx.Rollback()
+	}
+	err = dst.Update(func(dtx *bolt.Tx) error {
+		if err := dtx.DeleteBucket(name); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+			return err
+		}
+		db, err := dtx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		db.FillPercent = 0.9
+		return sb.ForEach(db.Put)
+	})
+	stx.Rollback()
+	if err != nil {
+		return err
+	}
+	return src.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket(name) })
+}
+
+// files returns the main file followed by the named databases.
+func (e *multiEngine) files() []*boltEngine {
+	return append([]*boltEngine{e.main}, e.dbs...)
+}
+
+// replace replaces the file old, reopened as db, and writes the last commit
+// into db, which may have been copied from old before that commit.
+func (e *multiEngine) replace(old, db *boltEngine) error {
+	if e.main == old {
+		e.main = db
+	}
+	for i := range e.dbs {
+		if e.dbs[i] == old {
+			e.dbs[i] = db
+		}
+	}
+	if e.seq == 0 {
+		return nil
+	}
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return putCommitSeq(&boltTx{tx}, e.seq)
+	})
+}
+
+func (e *multiEngine) Begin(writable bool) (EngineTx, error) {
+	main, err := e.main.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	tx := &multiTx{engine: e, main: main}
+	for _, db := range e.dbs {
+		dtx, err := db.Begin(writable)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		tx.txs = append(tx.txs, dtx)
+	}
+	return tx, nil
+}
+
+// Path returns the path of the main file.
+func (e *multiEngine) Path() string { return e.main.Path() }
+
+func (e *multiEngine) Stats() EngineStats {
+	stats := e.main.Stats()
+	for _, db := range e.dbs {
+		s := db.Stats()
+		stats.FreeBytes += s.FreeBytes
+		stats.FreePageN += s.FreePageN
+		stats.PendingPageN += s.PendingPageN
+		stats.FreelistInuse += s.FreelistInuse
+		stats.TxStats = stats.TxStats.add(s.TxStats)
+	}
+	return stats
+}
+
+func (e *multiEngine) Close() error {
+	var errs []error
+	for _, db := range e.files() {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type multiTx struct {
+	engine *multiEngine
+	main   EngineTx
+	txs    []EngineTx
+}
+
+func (tx *multiTx) route(name []byte) EngineTx {
+	if i, ok := tx.engine.route[string(name)]; ok {
+		return tx.txs[i]
+	}
+	return tx.main
+}
+
+func (tx *multiTx) Bucket(name []byte) EngineBucket {
+	return tx.route(name).Bucket(name)
+}
+
+func (tx *multiTx) CreateBucketIfNotExists(name []byte) (EngineBucket, error) {
+	return tx.route(name).CreateBucketIfNotExists(name)
+}
+
+func (tx *multiTx) DeleteBucket(name []byte) error {
+	return tx.route(name).DeleteBucket(name)
+}
+
+// ForEachBucket calls fn for the buckets of every file, merged in key order.
+func (tx *multiTx) ForEachBucket(fn func(name []byte, b EngineBucket) error) error {
+	type namedBucket struct {
+		name   []byte
+		bucket EngineBucket
+	}
+	var buckets []namedBucket
+	for _, t := range append([]EngineTx{tx.main}, tx.txs...) {
+		if err := t.ForEachBucket(func(name []byte, b EngineBucket) error {
+			if !bytes.Equal(name, multiCommitBucket) {
+				buckets = append(buckets, namedBucket{name, b})
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return bytes.Compare(buckets[i].name, buckets[j].name) < 0 })
+	for _, nb := range buckets {
+		if err := fn(nb.name, nb.bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *multiTx) Size() int64 {
+	size := tx.main.Size()
+	for _, t := range tx.txs {
+		size += t.Size()
+	}
+	return size
+}
+
+// WriteTo streams every bucket of every file as a full snapshot delta, see
+// ApplySnapshotDelta, so that the files are not merged into a temporary
+// database on every snapshot. The stream is turned back into a bbolt
+// database when the backend is opened from it.
+func (tx *multiTx) WriteTo(w io.Writer) (int64, error) {
+	return writeFullSnapshotDelta(w, tx)
+}
+
+// snapshotSize returns the number of bytes written by WriteTo.
+func (tx *multiTx) snapshotSize() int64 {
+	n, _ := writeFullSnapshotDelta(io.Discard, tx)
+	return n
+}
+
+func (tx *multiTx) Stats() EngineTxStats {
+	stats := tx.main.Stats()
+	for _, t := range tx.txs {
+		stats = stats.add(t.Stats())
+	}
+	return stats
+}
+
+// Commit commits the named databases concurrently, so that their fsyncs
+// overlap, then the main file. Every file records the sequence number of the
+// commit, so that a crash leaving the named databases ahead of the main file
+// is detected when the backend is opened, see ErrDatabasesMismatch.
+func (tx *multiTx) Commit() error {
+	seq := tx.engine.seq + 1
+	for _, t := range append([]EngineTx{tx.main}, tx.txs...) {
+		if err := putCommitSeq(t, seq); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	errs := make([]error, len(tx.txs))
+	var wg sync.WaitGroup
+	for i, t := range tx.txs {
+		wg.Add(1)
+		go func(i int, t EngineTx) {
+			defer wg.Done()
+			errs[i] = t.Commit()
+		}(i, t)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		tx.main.Rollback()
+		return err
+	}
+	if err := tx.main.Commit(); err != nil {
+		return err
+	}
+	tx.engine.seq = seq
+	return nil
+}
+
+func (tx *multiTx) Rollback() error {
+	errs := []error{tx.main.Rollback()}
+	for _, t := range tx.txs {
+		errs = append(errs, t.Rollback())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestBackendColdPath(t *testing.T) {
+	dir := t.TempDir()
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.Path, bcfg.BatchInterval, bcfg.BatchLimit = filepath.Join(dir, "db"), time.Hour, 10000
+	bcfg.ColdPath = filepath.Join(dir, "cold.db")
+	bcfg.ColdBuckets = []backend.Bucket{schema.Test}
+	b := backend.New(bcfg)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.UnsafePut(schema.Test, []byte("old"), []byte("history"))
+	tx.UnsafePut(schema.Key, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	b.ForceCommit()
+	require.NoError(t, b.Defrag())
+
+	rtx := b.ReadTx()
+	rtx.RLock()
+	_, vals := rtx.UnsafeRange(schema.Test, []byte("old"), nil, 0)
+	rtx.RUnlock()
+	assert.Equal(t, [][]byte{[]byte("history")}, vals)
+
+	snapPath, mergedPath := filepath.Join(dir, "snap.db"), filepath.Join(dir, "merged.db")
+	writeSnapshot(t, b, snapPath)
+	writeSnapshot(t, b, mergedPath)
+	betesting.Close(t, b)
+
+	// every bucket is routed to its file.
+	hot, cold := dumpDatabaseFile(t, bcfg.Path), dumpDatabaseFile(t, bcfg.ColdPath)
+	assert.Equal(t, map[string]map[string]string{"key": {"foo": "bar"}}, hot)
+	assert.Equal(t, map[string]map[string]string{"test": {"old": "history"}}, cold)
+
+	// the snapshot streams both files, and is restored to a single bbolt
+	// database when a backend is opened from it.
+	isDelta, err := backend.IsSnapshotDelta(snapPath)
+	require.NoError(t, err)
+	assert.True(t, isDelta)
+	betesting.Close(t, backend.NewDefaultBackend(zaptest.NewLogger(t), mergedPath))
+	assert.Equal(t, map[string]map[string]string{"key": {"foo": "bar"}, "test": {"old": "history"}}, dumpBoltFile(t, mergedPath))
+
+	// restoring the snapshot with tiering moves the cold buckets out.
+	bcfg.Path, bcfg.ColdPath = snapPath, filepath.Join(dir, "snap-cold.db")
+	b = backend.New(bcfg)
+	betesting.Close(t, b)
+	assert.Equal(t, hot, dumpDatabaseFile(t, bcfg.Path))
+	assert.Equal(t, cold, dumpDatabaseFile(t, bcfg.ColdPath))
+}
+
+func TestBackendNamedDatabases(t *testing.T) {
+	dir := t.TempDir()
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.Path, bcfg.BatchInterval, bcfg.BatchLimit = filepath.Join(dir, "db"), time.Hour, 10000
+	bcfg.Databases = []backend.NamedDatabase{
+		{Name: "lease", Path: filepath.Join(dir, "lease.db"), Buckets: []backend.Bucket{schema.Lease}},
+		{Name: "auth", Path: filepath.Join(dir, "auth.db"), Buckets: []backend.Bucket{schema.Auth, schema.AuthUsers}},
+	}
+	b := backend.New(bcfg)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	for _, bucket := range []backend.Bucket{schema.Key, schema.Lease, schema.Auth, schema.AuthUsers} {
+		tx.UnsafeCreateBucket(bucket)
+		tx.UnsafePut(bucket, []byte("k"), []byte(bucket.String()))
+	}
+	tx.Unlock()
+	b.ForceCommit()
+
+	hash, err := b.Hash(func(bucketName, keyName []byte) bool { return false })
+	require.NoError(t, err)
+	require.NoError(t, b.Defrag())
+	defragHash, err := b.Hash(func(bucketName, keyName []byte) bool { return false })
+	require.NoError(t, err)
+	assert.Equal(t, hash, defragHash)
+	betesting.Close(t, b)
+
+	assert.Equal(t, map[string]map[string]string{"key": {"k": "key"}}, dumpDatabaseFile(t, bcfg.Path))
+	assert.Equal(t, map[string]map[string]string{"lease": {"k": "lease"}}, dumpDatabaseFile(t, bcfg.Databases[0].Path))
+	assert.Equal(t, map[string]map[string]string{"auth": {"k": "auth"}, "authUsers": {"k": "authUsers"}}, dumpDatabaseFile(t, bcfg.Databases[1].Path))
+
+	// the files are at the same commit after the defragmentation.
+	b, err = backend.Open(bcfg)
+	require.NoError(t, err)
+	betesting.Close(t, b)
+}
+
+// TestBackendNamedDatabasesTornCommit ensures a named database committed
+// without the main file is detected on open.
+func TestBackendNamedDatabasesTornCommit(t *testing.T) {
+	dir := t.TempDir()
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.Path, bcfg.BatchInterval, bcfg.BatchLimit = filepath.Join(dir, "db"), time.Hour, 10000
+	bcfg.Databases = []backend.NamedDatabase{
+		{Name: "lease", Path: filepath.Join(dir, "lease.db"), Buckets: []backend.Bucket{schema.Lease}},
+	}
+	b := backend.New(bcfg)
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Lease)
+	tx.UnsafePut(schema.Lease, []byte("k"), []byte("v1"))
+	tx.Unlock()
+	betesting.Close(t, b)
+
+	// commit the named database only, as a crash before the main file
+	// commit would.
+	db, err := bolt.Open(bcfg.Databases[0].Path, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(backend.MultiCommitBucketForTest)
+		seq := binary.BigEndian.Uint64(b.Get([]byte("seq")))
+		if err := tx.Bucket(schema.Lease.Name()).Put([]byte("k"), []byte("v2")); err != nil {
+			return err
+		}
+		return b.Put([]byte("seq"), binary.BigEndian.AppendUint64(nil, seq+1))
+	}))
+	require.NoError(t, db.Close())
+
+	_, err = backend.Open(bcfg)
+	require.ErrorIs(t, err, backend.ErrDatabasesMismatch)
+}
+
+func writeSnapshot(t *testing.T, b backend.Backend, path string) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	snap := b.Snapshot()
+	defer snap.Close()
+	n, err := snap.WriteTo(f)
+	require.NoError(t, err)
+	assert.Equal(t, snap.Size(), n)
+}
+
+// dumpDatabaseFile dumps the file of a backend with named databases, without
+// the commit bucket.
+func dumpDatabaseFile(t *testing.T, path string) map[string]map[string]string {
+	m := dumpBoltFile(t, path)
+	delete(m, string(backend.MultiCommitBucketForTest))
+	return m
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ErrReadTxNotPinned is returned by ReadTxAt for a commit sequence that is
+// not pinned.
+var ErrReadTxNotPinned = errors.New("backend: no read transaction pinned at commit sequence")
+
+// readTxPin keeps the boltdb read tx of a commit alive for the read txs
+// created by ReadTxAt. Since the pending writes are committed before a
+// commit is pinned, the pinned view has no txReadBuffer.
+type readTxPin struct {
+	refs    int
+	txMu    *sync.RWMutex
+	tx      EngineTx
+	buckets map[BucketID]EngineBucket
+	txWg    *sync.WaitGroup
+	blooms  bloomFilters
+}
+
+// PinReadTx commits the pending writes and pins the resulting state, so that
+// a long scan can create read txs observing the same view with ReadTxAt
+// across batch commits. It returns the commit sequence of the view, which
+// must be released with UnpinReadTx. A pinned view prevents boltdb from
+// reusing the pages it observes, and defragmentation waits for its release.
+func (b *backend) PinReadTx() int64 {
+	b.batchTx.lock()
+	defer b.batchTx.Unlock()
+	b.batchTx.commit(false)
+	seq := b.Commits()
+
+	b.pinMu.Lock()
+	defer b.pinMu.Unlock()
+	pin, ok := b.pins[seq]
+	if !ok {
+		tx, err := b.beginReadTx()
+		if err != nil {
+			b.lg.Fatal("failed to begin tx", zap.Error(err))
+		}
+		pin = &readTxPin{
+			txMu:    new(sync.RWMutex),
+			tx:      tx,
+			buckets: make(map[BucketID]EngineBucket),
+			txWg:    new(sync.WaitGroup),
+			blooms:  b.blooms,
+		}
+		b.pins[seq] = pin
+	}
+	pin.refs++
+	return seq
+}
+
+// ReadTxAt returns a read tx observing the view pinned at the commit
+// sequence returned by PinReadTx. The tx must be released with RUnlock.
+func (b *backend) ReadTxAt(commitSeq int64) (ReadTx, error) {
+	b.pinMu.Lock()
+	defer b.pinMu.Unlock()
+	pin, ok := b.pins[commitSeq]
+	if !ok {
+		return nil, ErrReadTxNotPinned
+	}
+	pin.txWg.Add(1)
+	return &concurrentReadTx{
+		baseReadTx: baseReadTx{
+			buf: txReadBuffer{
+				txBuffer: txBuffer{make(map[BucketID]*bucketBuffer)},
+			},
+			txMu:         pin.txMu,
+			tx:           pin.tx,
+			buckets:      pin.buckets,
+			txWg:         pin.txWg,
+			prefetchKeys: b.readTx.prefetchKeys,
+			codec:        b.codec,
+			blooms:       pin.blooms,
+		},
+	}, nil
+}
+
+// UnpinReadTx releases a pin taken by PinReadTx. The view is released once
+// every pin of the commit sequence is released and the read txs created on
+// it are done.
+func (b *backend) UnpinReadTx(commitSeq int64) {
+	b.pinMu.Lock()
+	defer b.pinMu.Unlock()
+	pin, ok := b.pins[commitSeq]
+	if !ok {
+		return
+	}
+	if pin.refs--; pin.refs > 0 {
+		return
+	}
+	delete(b.pins, commitSeq)
+	b.releasePin(pin)
+}
+
+func (b *backend) releasePin(pin *readTxPin) {
+	b.pinWg.Add(1)
+	go func() {
+		defer b.pinWg.Done()
+		pin.txWg.Wait()
+		if err := pin.tx.Rollback(); err != nil {
+			b.lg.Fatal("failed to rollback tx", zap.Error(err))
+		}
+	}()
+}
+
+// unpinAll releases every pin and waits for their read txs to be done.
+func (b *backend) unpinAll() {
+	b.pinMu.Lock()
+	for seq, pin := range b.pins {
+		delete(b.pins, seq)
+		b.releasePin(pin)
+	}
+	b.pinMu.Unlock()
+	b.pinWg.Wait()
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestBackendReadTxAt(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	put := func(v string) {
+		tx := b.BatchTx()
+		tx.Lock()
+		tx.UnsafePut(schema.Key, []byte("foo"), []byte(v))
+		tx.Unlock()
+	}
+	get := func(seq int64) string {
+		rtx, err := b.(backend.ReadTxPinner).ReadTxAt(seq)
+		require.NoError(t, err)
+		rtx.RLock()
+		defer rtx.RUnlock()
+		_, vals := rtx.UnsafeRange(schema.Key, []byte("foo"), nil, 0)
+		require.Len(t, vals, 1)
+		return string(vals[0])
+	}
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.Unlock()
+	put("v1")
+
+	// the pending write is committed before pinning.
+	seq := b.(backend.ReadTxPinner).PinReadTx()
+	assert.Equal(t, "v1", get(seq))
+	assert.Equal(t, seq, b.(backend.ReadTxPinner).PinReadTx())
+
+	for _, v := range []string{"v2", "v3"} {
+		put(v)
+		b.ForceCommit()
+	}
+	assert.Equal(t, "v1", get(seq))
+
+	seq2 := b.(backend.ReadTxPinner).PinReadTx()
+	assert.Greater(t, seq2, seq)
+	assert.Equal(t, "v3", get(seq2))
+	b.(backend.ReadTxPinner).UnpinReadTx(seq2)
+
+	// the view stays pinned until every pin is released.
+	b.(backend.ReadTxPinner).UnpinReadTx(seq)
+	assert.Equal(t, "v1", get(seq))
+	b.(backend.ReadTxPinner).UnpinReadTx(seq)
+	_, err := b.(backend.ReadTxPinner).ReadTxAt(seq)
+	require.ErrorIs(t, err, backend.ErrReadTxNotPinned)
+
+	// close releases the remaining pins.
+	b.(backend.ReadTxPinner).PinReadTx()
+}
@@ -15,12 +15,18 @@
 package backend
 
 import (
+	"bytes"
+	"fmt"
 	"math"
 	"sync"
-
-	bolt "go.etcd.io/bbolt"
+	"sync/atomic"
+	"time"
 )
 
+// minPrefetchKeys is the smallest remaining range limit for which a prefetch
+// cursor is started; shorter ranges are not worth the extra goroutine.
+const minPrefetchKeys = 64
+
 // IsSafeRangeBucket is a hack to avoid inadvertently reading duplicate keys;
 // overwrites on a bucket should only fetch with limit=1, but IsSafeRangeBucket
 // is known to never overwrite any key so range is safe.
@@ -45,10 +51,17 @@ type baseReadTx struct {
 	// TODO: group and encapsulate {txMu, tx, buckets, txWg}, as they share the same lifecycle.
 	// txMu protects accesses to buckets and tx on Range requests.
 	txMu    *sync.RWMutex
-	tx      *bolt.Tx
-	buckets map[BucketID]*bolt.Bucket
+	tx      EngineTx
+	buckets map[BucketID]EngineBucket
 	// txWg protects tx from being rolled back at the end of a batch interval until all reads using this tx are done.
 	txWg *sync.WaitGroup
+	// prefetchKeys is the maximum number of keys read ahead of a range
+	// served from boltdb. Zero disables prefetching.
+	prefetchKeys int64
+	// codec decodes the values read from boltdb.
+	codec *valueCodec
+	// blooms are the bloom filters matching tx, nil if disabled.
+	blooms bloomFilters
 }
 
 func (baseReadTx *baseReadTx) UnsafeForEach(bucket Bucket, visitor func(k, v []byte) error) error {
@@ -67,7 +80,7 @@ func (baseReadTx *baseReadTx) UnsafeForEach(bucket Bucket, visitor func(k, v []b
 		return err
 	}
 	baseReadTx.txMu.Lock()
-	err := unsafeForEach(baseReadTx.tx, bucket, visitNoDup)
+	err := unsafeForEach(baseReadTx.tx, bucket, baseReadTx.codec, visitNoDup)
 	baseReadTx.txMu.Unlock()
 	if err != nil {
 		return err
@@ -90,6 +103,10 @@ func (baseReadTx *baseReadTx) UnsafeRange(bucketType Bucket, key, endKey []byte,
 	if int64(len(keys)) == limit {
 		return keys, vals
 	}
+	if endKey == nil && !baseReadTx.blooms.mayContain(bucketType, key) {
+		rangeBloomSkips.Inc()
+		return keys, vals
+	}
 
 	// find/cache bucket
 	bn := bucketType.ID()
@@ -115,12 +132,69 @@ func (baseReadTx *baseReadTx) UnsafeRange(bucketType Bucket, key, endKey []byte,
 		baseReadTx.txMu.Lock()
 	}
 	c := bucket.Cursor()
+	var pc EngineCursor
+	n := baseReadTx.prefetchN(endKey, limit-int64(len(keys)))
+	if n > 0 {
+		pc = bucket.Cursor()
+	}
 	baseReadTx.txMu.Unlock()
 
+	if pc != nil {
+		stop := baseReadTx.prefetch(pc, key, endKey, n)
+		defer stop()
+	}
 	k2, v2 := unsafeRange(c, key, endKey, limit-int64(len(keys)))
+	baseReadTx.codec.decodeAll(v2)
 	return append(k2, keys...), append(v2, vals...)
 }
 
+// prefetchN returns the number of keys to read ahead for a range with the
+// given end key and remaining limit, or 0 if the range should not prefetch.
+// A limit of zero or less is no limit.
+func (baseReadTx *baseReadTx) prefetchN(endKey []byte, limit int64) int64 {
+	if baseReadTx.prefetchKeys <= 0 || len(endKey) == 0 {
+		return 0
+	}
+	if limit <= 0 {
+		return baseReadTx.prefetchKeys
+	}
+	if limit < minPrefetchKeys {
+		return 0
+	}
+	return min(limit, baseReadTx.prefetchKeys)
+}
+
+// prefetch walks c over at most n keys of [key, endKey) in a separate
+// goroutine, touching every value so that the leaf pages the range is about
+// to read are faulted in concurrently instead of one page at a time. The
+// returned function stops the walk and waits for it to exit; the range calls
+// it before returning, so the prefetch cursor is never used after the range
+// and the boltdb tx cannot be rolled back under it.
+func (baseReadTx *baseReadTx) prefetch(c EngineCursor, key, endKey []byte, n int64) func() {
+	var stopped atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var sink byte
+		var i int64
+		for ck, cv := c.Seek(key); ck != nil && i < n && bytes.Compare(ck, endKey) < 0; ck, cv = c.Next() {
+			if stopped.Load() {
+				break
+			}
+			if len(cv) > 0 {
+				sink ^= cv[len(cv)-1]
+			}
+			i++
+		}
+		rangePrefetchKeys.Add(float64(i))
+		_ = sink
+	}()
+	return func() {
+		stopped.Store(true)
+		<-done
+	}
+}
+
 type readTx struct {
 	baseReadTx
 }
@@ -132,13 +206,21 @@ func (rt *readTx) RUnlock() { rt.mu.RUnlock() }
 
 func (rt *readTx) reset() {
 	rt.buf.reset()
-	rt.buckets = make(map[BucketID]*bolt.Bucket)
+	rt.buckets = make(map[BucketID]EngineBucket)
 	rt.tx = nil
 	rt.txWg = new(sync.WaitGroup)
 }
 
 type concurrentReadTx struct {
 	baseReadTx
+
+	// tracker is set if read transactions are tracked.
+	tracker *readTxTracker
+	info    ReadTxInfo
+	// abortMu protects aborted and the reads from an abort.
+	abortMu sync.RWMutex
+	aborted bool
+	release sync.Once
 }
 
 func (rt *concurrentReadTx) Lock()   {}
@@ -148,4 +230,59 @@ func (rt *concurrentReadTx) Unlock() {}
 func (rt *concurrentReadTx) RLock() {}
 
 // RUnlock signals the end of concurrentReadTx.
-func (rt *concurrentReadTx) RUnlock() { rt.txWg.Done() }
+func (rt *concurrentReadTx) RUnlock() {
+	if rt.tracker == nil {
+		rt.txWg.Done()
+		return
+	}
+	rt.release.Do(rt.done)
+}
+
+func (rt *concurrentReadTx) done() {
+	rt.tracker.untrack(rt)
+	rt.txWg.Done()
+}
+
+// abort releases the boltdb tx held by rt, so that it can be rolled back.
+// Reading from rt afterwards panics. It returns false if rt is already
+// aborted or done.
+func (rt *concurrentReadTx) abort() bool {
+	rt.abortMu.Lock()
+	defer rt.abortMu.Unlock()
+	if rt.aborted {
+		return false
+	}
+	rt.aborted = true
+	released := false
+	rt.release.Do(func() {
+		rt.done()
+		released = true
+	})
+	return released
+}
+
+// checkAborted must be called holding abortMu.
+func (rt *concurrentReadTx) checkAborted() {
+	if rt.aborted {
+		panic(fmt.Sprintf("backend: read from a read transaction created at %s by %s that was aborted after exceeding its deadline",
+			rt.info.Created.Format(time.RFC3339), rt.info.Caller))
+	}
+}
+
+func (rt *concurrentReadTx) UnsafeRange(bucket Bucket, key, endKey []byte, limit int64) ([][]byte, [][]byte) {
+	if rt.tracker != nil {
+		rt.abortMu.RLock()
+		defer rt.abortMu.RUnlock()
+		rt.checkAborted()
+	}
+	return rt.baseReadTx.UnsafeRange(bucket, key, endKey, limit)
+}
+
+func (rt *concurrentReadTx) UnsafeForEach(bucket Bucket, visitor func(k, v []byte) error) error {
+	if rt.tracker != nil {
+		rt.abortMu.RLock()
+		defer rt.abortMu.RUnlock()
+		rt.checkAborted()
+	}
+	return rt.baseReadTx.UnsafeForEach(bucket, visitor)
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ReadTxInfo describes an open concurrent read transaction.
+type ReadTxInfo struct {
+	// Created is the time the transaction was created.
+	Created time.Time
+	// Caller is the location of the code that created the transaction.
+	Caller string
+}
+
+// readTxTracker keeps track of the open concurrent read transactions, since
+// a leaked transaction prevents boltdb from reclaiming the pages it observes.
+type readTxTracker struct {
+	// deadline is the maximum lifetime of a read transaction, after which it
+	// is aborted. Zero disables aborting.
+	deadline time.Duration
+	lg       *zap.Logger
+
+	mu  sync.Mutex
+	txs map[*concurrentReadTx]struct{}
+}
+
+func newReadTxTracker(lg *zap.Logger, deadline time.Duration) *readTxTracker {
+	return &readTxTracker{
+		deadline: deadline,
+		lg:       lg,
+		txs:      make(map[*concurrentReadTx]struct{}),
+	}
+}
+
+// track records rt, which was created by the caller skip frames above.
+func (t *readTxTracker) track(rt *concurrentReadTx, skip int) {
+	rt.tracker = t
+	rt.info.Created = time.Now()
+	if _, file, line, ok := runtime.Caller(skip + 1); ok {
+		rt.info.Caller = fmt.Sprintf("%s:%d", file, line)
+	}
+	t.mu.Lock()
+	t.txs[rt] = struct{}{}
+	t.mu.Unlock()
+}
+
+func (t *readTxTracker) untrack(rt *concurrentReadTx) {
+	t.mu.Lock()
+	delete(t.txs, rt)
+	t.mu.Unlock()
+}
+
+// longRunning returns the transactions open for longer than threshold,
+// oldest first.
+func (t *readTxTracker) longRunning(threshold time.Duration) []*concurrentReadTx {
+	now := time.Now()
+	var txs []*concurrentReadTx
+	t.mu.Lock()
+	for rt := range t.txs {
+		if now.Sub(rt.info.Created) > threshold {
+			txs = append(txs, rt)
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(txs, func(i, j int) bool { return txs[i].info.Created.Before(txs[j].info.Created) })
+	return txs
+}
+
+// abortExpired aborts the transactions open for longer than the deadline.
+func (t *readTxTracker) abortExpired() {
+	if t.deadline <= 0 {
+		return
+	}
+	for _, rt := range t.longRunning(t.deadline) {
+		if rt.abort() {
+			readTxAborted.Inc()
+			t.lg.Warn(
+				"aborted long running read transaction",
+				zap.Time("created", rt.info.Created),
+				zap.String("caller", rt.info.Caller),
+				zap.Duration("deadline", t.deadline),
+			)
+		}
+	}
+}
+
+// LongRunningReadTxs returns the concurrent read transactions open for
+// longer than threshold, oldest first. It returns nil unless read
+// transaction tracking is enabled.
+func (b *backend) LongRunningReadTxs(threshold time.Duration) []ReadTxInfo {
+	if b.readTxTracker == nil {
+		return nil
+	}
+	var infos []ReadTxInfo
+	for _, rt := range b.readTxTracker.longRunning(threshold) {
+		infos = append(infos, rt.info)
+	}
+	return infos
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ReadOnlyBackend is a backend opened without write access, for inspecting
+// or backing up a copy of a member's db file.
+type ReadOnlyBackend interface {
+	// ReadTx returns a read transaction observing the db as it was opened.
+	ReadTx() ReadTx
+	// ConcurrentReadTx returns a non-blocking read transaction.
+	ConcurrentReadTx() ReadTx
+	Snapshot() Snapshot
+	Hash(ignores func(bucketName, keyName []byte) bool) (uint32, error)
+	// Size returns the size of the db file.
+	Size() int64
+	Close() error
+}
+
+type readOnlyBackend struct {
+	b *backend
+}
+
+// OpenReadOnly opens the bbolt db at path in read-only mode. The file is
+// locked shared, so it cannot be opened while a backend has it open for
+// writing; opening blocks until that backend is closed. Only the options
+// affecting reads, such as WithCompression and WithEncryptor, apply.
+func OpenReadOnly(lg *zap.Logger, path string, opts ...BackendConfigOption) (ReadOnlyBackend, error) {
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	bcfg := DefaultBackendConfig(lg)
+	bcfg.Path = path
+	for _, opt := range opts {
+		opt(&bcfg)
+	}
+	codec := newValueCodec(lg, bcfg.Compression, bcfg.Encryptor)
+
+	bopts := &bolt.Options{}
+	if boltOpenOptions != nil {
+		*bopts = *boltOpenOptions
+	}
+	bopts.ReadOnly = true
+	bopts.Logger = newBoltLoggerZap(bcfg)
+
+	db, err := openBoltEngine(path, bopts)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin(false)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b := &backend{
+		bopts: bopts,
+		db:    db,
+		readTx: &readTx{
+			baseReadTx: baseReadTx{
+				buf: txReadBuffer{
+					txBuffer: txBuffer{make(map[BucketID]*bucketBuffer)},
+				},
+				buckets: make(map[BucketID]EngineBucket),
+				txWg:    new(sync.WaitGroup),
+				txMu:    new(sync.RWMutex),
+				tx:      tx,
+				codec:   codec,
+			},
+		},
+		codec: codec,
+		lg:    lg,
+	}
+	atomic.StoreInt64(&b.size, tx.Size())
+	return &readOnlyBackend{b: b}, nil
+}
+
+func (rb *readOnlyBackend) ReadTx() ReadTx { return rb.b.ReadTx() }
+
+func (rb *readOnlyBackend) ConcurrentReadTx() ReadTx { return rb.b.ConcurrentReadTx() }
+
+func (rb *readOnlyBackend) Snapshot() Snapshot { return rb.b.snapshot() }
+
+func (rb *readOnlyBackend) Hash(ignores func(bucketName, keyName []byte) bool) (uint32, error) {
+	return rb.b.Hash(ignores)
+}
+
+func (rb *readOnlyBackend) Size() int64 { return rb.b.Size() }
+
+func (rb *readOnlyBackend) Close() error {
+	b := rb.b
+	b.readTx.Lock()
+	b.readTx.txWg.Wait()
+	err := b.readTx.tx.Rollback()
+	b.readTx.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cerr := b.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	b, path := betesting.NewTmpBackend(t, time.Hour, 10000)
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	b.ForceCommit()
+	wantHash, err := b.Hash(nil)
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	rb, err := backend.OpenReadOnly(zaptest.NewLogger(t), path)
+	require.NoError(t, err)
+
+	for _, rtx := range []backend.ReadTx{rb.ReadTx(), rb.ConcurrentReadTx()} {
+		rtx.RLock()
+		keys, vals := rtx.UnsafeRange(schema.Test, []byte("foo"), nil, 0)
+		rtx.RUnlock()
+		assert.Equal(t, [][]byte{[]byte("foo")}, keys)
+		assert.Equal(t, [][]byte{[]byte("bar")}, vals)
+	}
+
+	gotHash, err := rb.Hash(nil)
+	require.NoError(t, err)
+	assert.Equal(t, wantHash, gotHash)
+
+	snap := rb.Snapshot()
+	var buf bytes.Buffer
+	_, err = snap.WriteTo(&buf)
+	require.NoError(t, err)
+	require.NoError(t, snap.Close())
+	assert.Equal(t, int64(buf.Len()), rb.Size())
+
+	require.NoError(t, rb.Close())
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+)
+
+var (
+	// ErrSnapshotMissingHash is returned by RestoreFrom if the snapshot has
+	// no integrity hash and RestoreOptions.SkipHashCheck is not set.
+	ErrSnapshotMissingHash = errors.New("backend: snapshot missing integrity hash")
+	// ErrSnapshotHashMismatch is returned by RestoreFrom if the snapshot does
+	// not match its integrity hash.
+	ErrSnapshotHashMismatch = errors.New("backend: snapshot integrity hash mismatch")
+)
+
+// RestoreOptions configures RestoreFrom.
+type RestoreOptions struct {
+	// SkipHashCheck accepts snapshots without an integrity hash, such as
+	// copies of a db file. A hash that is present is still checked.
+	SkipHashCheck bool
+	// Verify walks the restored database before swapping it in, see
+	// BackendConfig.VerifyOnOpen.
+	Verify bool
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
+}
+
+// RestoreFrom writes the snapshot read from r to path, replacing the file at
+// path, if any, atomically. The snapshot is a bbolt file optionally followed
+// by its sha256 hash, as sent to a learner or saved by `etcdctl snapshot
+// save`; the hash is checked and stripped.
+//
+// The snapshot is written to a temporary file in the directory of path,
+// which is synced before it is renamed to path. The directory is synced
+// after the rename, so the new file survives a crash once RestoreFrom
+// returns. path must not be open by a backend.
+func RestoreFrom(r io.Reader, path string, opts RestoreOptions) (int64, error) {
+	lg := opts.Logger
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	start := time.Now()
+	dir := filepath.Dir(path)
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".restore-*")
+	if err != nil {
+		return 0, err
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	hw := &hashTrailerWriter{w: f, h: sha256.New()}
+	var n int64
+	n, err = io.Copy(hw, r)
+	if err == nil {
+		err = hw.check(opts.SkipHashCheck)
+	}
+	if err == nil {
+		err = fileutil.Fsync(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	size := hw.written
+
+	if opts.Verify {
+		if err = verifyFile(lg, tmp); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		return 0, err
+	}
+	if err = fileutil.FsyncDir(dir); err != nil {
+		return 0, err
+	}
+
+	lg.Info(
+		"restored backend from snapshot",
+		zap.String("path", path),
+		zap.Int64("read-bytes", n),
+		zap.Int64("size", size),
+		zap.Bool("hash-checked", hw.hasHash),
+		zap.Duration("took", time.Since(start)),
+	)
+	return size, nil
+}
+
+func verifyFile(lg *zap.Logger, path string) error {
+	db, err := openBoltEngine(path, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("backend: cannot open restored snapshot: %w", err)
+	}
+	defer db.Close()
+	return verifyEngine(lg, db, nil, 0)
+}
+
+// hashTrailerWriter writes a snapshot to w, holding back the last
+// sha256.Size bytes, which are the integrity hash of the snapshot if it has
+// one, and hashing the bytes it writes.
+type hashTrailerWriter struct {
+	w       io.Writer
+	h       hash.Hash
+	tail    []byte
+	written int64
+	hasHash bool
+}
+
+func (hw *hashTrailerWriter) Write(p []byte) (int, error) {
+	buf := append(hw.tail, p...)
+	if len(buf) <= sha256.Size {
+		hw.tail = buf
+		return len(p), nil
+	}
+	out := buf[:len(buf)-sha256.Size]
+	if _, err := hw.w.Write(out); err != nil {
+		return 0, err
+	}
+	hw.h.Write(out)
+	hw.written += int64(len(out))
+	hw.tail = append([]byte(nil), buf[len(out):]...)
+	return len(p), nil
+}
+
+// check checks the held back bytes against the hash of the written bytes.
+// A bbolt file is a whole number of pages, so a snapshot carries a hash iff
+// its size is a multiple of 512 plus sha256.Size. Without a hash, the held
+// back bytes are written as part of the file.
+func (hw *hashTrailerWriter) check(skipHashCheck bool) error {
+	hw.hasHash = len(hw.tail) == sha256.Size && hw.written%512 == 0
+	if !hw.hasHash {
+		if !skipHashCheck {
+			return ErrSnapshotMissingHash
+		}
+		if _, err := hw.w.Write(hw.tail); err != nil {
+			return err
+		}
+		hw.written += int64(len(hw.tail))
+		return nil
+	}
+	if !bytes.Equal(hw.h.Sum(nil), hw.tail) {
+		return ErrSnapshotHashMismatch
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestRestoreFrom(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+	b.ForceCommit()
+	var db bytes.Buffer
+	_, err := b.(backend.BackupWriter).BackupTo(&db, backend.BackupOptions{})
+	require.NoError(t, err)
+	betesting.Close(t, b)
+
+	sum := sha256.Sum256(db.Bytes())
+	withHash := append(bytes.Clone(db.Bytes()), sum[:]...)
+	corrupted := bytes.Clone(withHash)
+	corrupted[len(db.Bytes())/2] ^= 0xff
+
+	tcs := []struct {
+		name    string
+		snap    []byte
+		opts    backend.RestoreOptions
+		wantErr error
+	}{
+		{name: "hash", snap: withHash, opts: backend.RestoreOptions{Verify: true}},
+		{name: "missing hash", snap: db.Bytes(), wantErr: backend.ErrSnapshotMissingHash},
+		{name: "skip hash check", snap: db.Bytes(), opts: backend.RestoreOptions{SkipHashCheck: true}},
+		{name: "hash mismatch", snap: corrupted, opts: backend.RestoreOptions{SkipHashCheck: true}, wantErr: backend.ErrSnapshotHashMismatch},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "db")
+			require.NoError(t, os.WriteFile(path, []byte("old"), 0600))
+
+			tc.opts.Logger = zaptest.NewLogger(t)
+			n, err := backend.RestoreFrom(bytes.NewReader(tc.snap), path, tc.opts)
+			entries, rerr := os.ReadDir(dir)
+			require.NoError(t, rerr)
+			assert.Len(t, entries, 1, "temporary file left behind")
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				got, rerr := os.ReadFile(path)
+				require.NoError(t, rerr)
+				assert.Equal(t, []byte("old"), got)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(db.Len()), n)
+
+			bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+			bcfg.Path = path
+			rb := backend.New(bcfg)
+			defer betesting.Close(t, rb)
+			rtx := rb.ReadTx()
+			rtx.RLock()
+			_, vals := rtx.UnsafeRange(schema.Test, []byte("foo"), nil, 0)
+			rtx.RUnlock()
+			assert.Equal(t, [][]byte{[]byte("bar")}, vals)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+
+	"go.uber.org/zap"
+)
+
+// seqRun is a run of monotonically increasing keys put into a bucket with
+// UnsafeSeqPut, which is written to the tx in a single pass once the run
+// ends. Reads of the tx are served from the txReadBuffer in the meantime.
+type seqRun struct {
+	bucket Bucket
+	kvs    []kv
+}
+
+// unsafeSeqPut extends the run of bucket with key. A key that does not
+// extend the run ends it and is written right away, without the sequential
+// hint since the keys of the bucket are not monotonic.
+func (t *batchTxBuffered) unsafeSeqPut(bucket Bucket, key []byte, value []byte) {
+	run, ok := t.seqRuns[bucket.ID()]
+	if ok && bytes.Compare(key, run.kvs[len(run.kvs)-1].key) <= 0 {
+		t.flushSeqRun(bucket.ID())
+		t.batchTx.UnsafePut(bucket, key, value)
+		return
+	}
+	if !ok {
+		if t.tx.Bucket(bucket.Name()) == nil {
+			t.backend.lg.Fatal(
+				"failed to find a bucket",
+				zap.Stringer("bucket-name", bucket),
+				zap.Stack("stack"),
+			)
+		}
+		run = &seqRun{bucket: bucket}
+		t.seqRuns[bucket.ID()] = run
+	}
+	run.kvs = append(run.kvs, kv{key: key, val: value})
+	t.pending++
+}
+
+// flushSeqRun writes the pending run of the bucket to the tx. The bucket is
+// only hinted as sequential if the run is appended after its last key, so
+// that runs inserted in the middle of a bucket do not leave dense pages to
+// be split by later writes.
+func (t *batchTxBuffered) flushSeqRun(id BucketID) {
+	run, ok := t.seqRuns[id]
+	if !ok {
+		return
+	}
+	delete(t.seqRuns, id)
+	bucket := t.tx.Bucket(run.bucket.Name())
+	if bucket == nil {
+		t.backend.lg.Fatal(
+			"failed to find a bucket",
+			zap.Stringer("bucket-name", run.bucket),
+			zap.Stack("stack"),
+		)
+	}
+	if k, _ := bucket.Cursor().Seek(run.kvs[0].key); k == nil {
+		bucket.SetSequential()
+	}
+	for _, p := range run.kvs {
+		t.unsafeWrite(run.bucket, bucket, p.key, p.val)
+	}
+}
+
+func (t *batchTxBuffered) flushSeqRuns() {
+	for id := range t.seqRuns {
+		t.flushSeqRun(id)
+	}
+}
@@ -0,0 +1,588 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+const (
+	snapshotDeltaVersion byte = 2
+
+	// deltaOpEnd starts the trailer of a stream: the number of records
+	// written before it and the CRC-32C of the stream up to the checksum.
+	deltaOpEnd          byte = 0
+	deltaOpPutBucket    byte = 1
+	deltaOpDeleteBucket byte = 2
+	deltaOpUpdateBucket byte = 3
+
+	// the key records of a bucket op are ended by deltaKeyEnd.
+	deltaKeyEnd    byte = 0
+	deltaKeyPut    byte = 1
+	deltaKeyDelete byte = 2
+
+	// snapshotDeltaAlign is the size full snapshot deltas are padded to a
+	// multiple of with zeros after their trailer.
+	snapshotDeltaAlign = 512
+)
+
+// ErrInvalidSnapshotDelta is returned by ApplySnapshotDelta on a malformed stream.
+var ErrInvalidSnapshotDelta = errors.New("backend: invalid snapshot delta")
+
+// SnapshotMarker identifies the state of the backend captured by an
+// incremental snapshot. The zero marker requests a full snapshot.
+//
+// Markers are only meaningful to the backend instance that issued them; a
+// marker from a previous process yields a full snapshot.
+type SnapshotMarker struct {
+	// ID identifies the backend instance that issued the marker.
+	ID uint64
+	// Commit is the number of batch commits covered by the snapshot.
+	Commit int64
+}
+
+// IncrementalSnapshot streams the keys changed since a previous marker.
+// Buckets deleted since the marker are written as a whole, or as deletions
+// if they no longer exist. The stream is applied to a copy of the database
+// with ApplySnapshotDelta.
+type IncrementalSnapshot interface {
+	// Marker returns the marker of this snapshot, to be passed to the next
+	// SnapshotSince call.
+	Marker() SnapshotMarker
+	// Full reports whether the snapshot holds every bucket of the backend,
+	// in which case buckets absent from it are dropped when it is applied.
+	Full() bool
+	// WriteTo streams the snapshot into the given writer.
+	WriteTo(w io.Writer) (n int64, err error)
+	// Close closes the snapshot.
+	Close() error
+}
+
+// snapshotChanges records the keys written while consumers of incremental
+// snapshots are registered. Like defragDelta, only the location of a write
+// is recorded; its value is read back when a snapshot is written.
+type snapshotChanges struct {
+	// start is the number of commits when the recording started, markers
+	// of earlier commits yield full snapshots.
+	start     int64
+	consumers int
+	buckets   map[string]*bucketChanges
+}
+
+// bucketChanges holds the number of the commit carrying the latest write of
+// a bucket and of each of its written keys.
+type bucketChanges struct {
+	commit int64
+	// reset is the commit of the latest deletion of the bucket, after which
+	// the bucket is written as a whole.
+	reset int64
+	keys  map[string]int64
+}
+
+func newSnapshotChanges(start int64) *snapshotChanges {
+	return &snapshotChanges{start: start, buckets: make(map[string]*bucketChanges)}
+}
+
+func (c *snapshotChanges) addBucket(bucket []byte, commit int64) *bucketChanges {
+	bc, ok := c.buckets[string(bucket)]
+	if !ok {
+		bc = &bucketChanges{keys: make(map[string]int64)}
+		c.buckets[string(bucket)] = bc
+	}
+	bc.commit = commit
+	return bc
+}
+
+func (c *snapshotChanges) resetBucket(bucket []byte, commit int64) {
+	bc := c.addBucket(bucket, commit)
+	bc.reset = commit
+	bc.keys = make(map[string]int64)
+}
+
+func (c *snapshotChanges) addKey(bucket, key []byte, commit int64) {
+	c.addBucket(bucket, commit).keys[string(key)] = commit
+}
+
+// bucketDelta is a bucket changed since a marker, with its changed keys in
+// order, or reset if it is written as a whole.
+type bucketDelta struct {
+	name  string
+	reset bool
+	keys  []string
+}
+
+// since returns the buckets changed after the given commit, in order.
+func (c *snapshotChanges) since(commit int64) []bucketDelta {
+	var deltas []bucketDelta
+	for name, bc := range c.buckets {
+		if bc.commit <= commit {
+			continue
+		}
+		d := bucketDelta{name: name, reset: bc.reset > commit}
+		if !d.reset {
+			for k, kc := range bc.keys {
+				if kc > commit {
+					d.keys = append(d.keys, k)
+				}
+			}
+			sort.Strings(d.keys)
+		}
+		deltas = append(deltas, d)
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].name < deltas[j].name })
+	return deltas
+}
+
+func (b *backend) TrackSnapshotChanges() func() {
+	b.batchTx.lock()
+	if b.batchTx.changes == nil {
+		// commit the untracked writes, so that they are covered by any
+		// marker at or after the start of the recording.
+		b.batchTx.commit(false)
+		b.batchTx.changes = newSnapshotChanges(b.Commits())
+	}
+	b.batchTx.changes.consumers++
+	b.batchTx.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.batchTx.lock()
+			if b.batchTx.changes.consumers--; b.batchTx.changes.consumers == 0 {
+				b.batchTx.changes = nil
+			}
+			b.batchTx.Unlock()
+		})
+	}
+}
+
+func (b *backend) SnapshotSince(m SnapshotMarker) IncrementalSnapshot {
+	// commit so that the read tx observes every write recorded so far.
+	b.batchTx.lock()
+	b.batchTx.commit(false)
+	marker := SnapshotMarker{ID: b.snapshotID, Commit: b.Commits()}
+	changes := b.batchTx.changes
+	full := changes == nil || m.ID != b.snapshotID || m.Commit < changes.start || m.Commit > marker.Commit
+	var deltas []bucketDelta
+	if !full {
+		deltas = changes.since(m.Commit)
+	}
+	b.mu.RLock()
+	tx, err := b.db.Begin(false)
+	b.mu.RUnlock()
+	b.batchTx.Unlock()
+	if err != nil {
+		b.lg.Fatal("failed to begin tx", zap.Error(err))
+	}
+
+	if full {
+		if err = tx.ForEachBucket(func(name []byte, _ EngineBucket) error {
+			deltas = append(deltas, bucketDelta{name: string(name), reset: true})
+			return nil
+		}); err != nil {
+			b.lg.Fatal("failed to list buckets", zap.Error(err))
+		}
+	}
+	return &incrementalSnapshot{tx: tx, marker: marker, full: full, deltas: deltas}
+}
+
+type incrementalSnapshot struct {
+	tx     EngineTx
+	marker SnapshotMarker
+	full   bool
+	deltas []bucketDelta
+}
+
+func (s *incrementalSnapshot) Marker() SnapshotMarker { return s.marker }
+
+func (s *incrementalSnapshot) Full() bool { return s.full }
+
+func (s *incrementalSnapshot) WriteTo(w io.Writer) (int64, error) {
+	return writeSnapshotDelta(w, s.tx, s.deltas, s.full)
+}
+
+// writeSnapshotDelta writes the deltas of the buckets of tx into w.
+func writeSnapshotDelta(w io.Writer, tx EngineTx, deltas []bucketDelta, full bool) (int64, error) {
+	cw := &countingWriter{w: w}
+	dw := &deltaWriter{w: bufio.NewWriter(cw), h: crc32.New(crc32.MakeTable(crc32.Castagnoli))}
+	flags := byte(0)
+	if full {
+		flags = 1
+	}
+	dw.write([]byte{snapshotDeltaVersion, flags})
+	for _, d := range deltas {
+		bucket := tx.Bucket([]byte(d.name))
+		switch {
+		case bucket == nil:
+			dw.writeRecord(deltaOpDeleteBucket, []byte(d.name))
+			continue
+		case d.reset:
+			dw.writeRecord(deltaOpPutBucket, []byte(d.name))
+			if err := bucket.ForEach(func(k, v []byte) error {
+				dw.writeRecord(deltaKeyPut, k)
+				dw.writeBytes(v)
+				return dw.err
+			}); err != nil {
+				return cw.n, err
+			}
+		default:
+			dw.writeRecord(deltaOpUpdateBucket, []byte(d.name))
+			for _, k := range d.keys {
+				if v := bucket.Get([]byte(k)); v != nil {
+					dw.writeRecord(deltaKeyPut, []byte(k))
+					dw.writeBytes(v)
+				} else {
+					dw.writeRecord(deltaKeyDelete, []byte(k))
+				}
+			}
+		}
+		dw.write([]byte{deltaKeyEnd})
+	}
+	dw.writeTrailer()
+	if full {
+		// pad like a bbolt file, whose size is a multiple of the page size,
+		// so that a checksum appended to the snapshot is found by its size.
+		if pad := (cw.n + int64(dw.w.Buffered())) % snapshotDeltaAlign; pad != 0 && dw.err == nil {
+			_, dw.err = dw.w.Write(make([]byte, snapshotDeltaAlign-pad))
+		}
+	}
+	if dw.err != nil {
+		return cw.n, dw.err
+	}
+	err := dw.w.Flush()
+	return cw.n, err
+}
+
+// writeFullSnapshotDelta writes every bucket of tx into w.
+func writeFullSnapshotDelta(w io.Writer, tx EngineTx) (int64, error) {
+	var deltas []bucketDelta
+	if err := tx.ForEachBucket(func(name []byte, _ EngineBucket) error {
+		deltas = append(deltas, bucketDelta{name: string(name), reset: true})
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return writeSnapshotDelta(w, tx, deltas, true)
+}
+
+func (s *incrementalSnapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+// ApplySnapshotDelta applies a stream written by IncrementalSnapshot.WriteTo
+// to the bbolt database at path, in a single transaction that is only
+// committed once the trailer of the stream is verified. The database must
+// not be opened by a backend.
+func ApplySnapshotDelta(path string, r io.Reader) error {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	dr := &deltaReader{r: bufio.NewReader(r), h: crc32.New(crc32.MakeTable(crc32.Castagnoli))}
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(dr, header); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshotDelta, err)
+	}
+	if header[0] != snapshotDeltaVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrInvalidSnapshotDelta, header[0])
+	}
+	full := header[1]&1 != 0
+
+	return db.Update(func(tx *bolt.Tx) error {
+		if full {
+			var names [][]byte
+			if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				names = append(names, name)
+				return nil
+			}); err != nil {
+				return err
+			}
+			for _, name := range names {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+		for {
+			op, name, err := dr.readRecord(bolt.MaxKeySize)
+			if err != nil {
+				return err
+			}
+			var bucket *bolt.Bucket
+			switch op {
+			case deltaOpEnd:
+				return dr.verifyTrailer()
+			case deltaOpDeleteBucket:
+				if err = tx.DeleteBucket(name); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+					return err
+				}
+				continue
+			case deltaOpPutBucket:
+				if err = tx.DeleteBucket(name); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+					return err
+				}
+				if bucket, err = tx.CreateBucket(name); err != nil {
+					return err
+				}
+				bucket.FillPercent = 0.9
+			case deltaOpUpdateBucket:
+				if bucket, err = tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("%w: unknown op %d", ErrInvalidSnapshotDelta, op)
+			}
+			if err = applyDeltaKeys(dr, bucket); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// applyDeltaKeys applies the key records of a bucket op to bucket.
+func applyDeltaKeys(dr *deltaReader, bucket *bolt.Bucket) error {
+	for {
+		kind, err := dr.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshotDelta, err)
+		}
+		if kind == deltaKeyEnd {
+			return nil
+		}
+		dr.n++
+		k, err := dr.readBytes(bolt.MaxKeySize)
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case deltaKeyPut:
+			v, err := dr.readBytes(bolt.MaxValueSize)
+			if err != nil {
+				return err
+			}
+			err = bucket.Put(k, v)
+		case deltaKeyDelete:
+			err = bucket.Delete(k)
+		default:
+			return fmt.Errorf("%w: unknown key record %d", ErrInvalidSnapshotDelta, kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// IsSnapshotDelta reports whether the file at path holds a full snapshot
+// delta rather than a bbolt database, as the snapshots of a backend with
+// named databases do.
+func IsSnapshotDelta(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	// a bbolt file starts with the id of its first page, 0.
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(f, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return header[0] == snapshotDeltaVersion && header[1]&1 != 0, nil
+}
+
+// materializeSnapshotDelta replaces the full snapshot delta at path, if any,
+// by the bbolt database it holds.
+func materializeSnapshotDelta(path string) error {
+	ok, err := IsSnapshotDelta(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil || !ok {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Snapshotter.cleanupSnapdir cleans up any db.tmp files left behind.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "db.tmp.*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err = ApplySnapshotDelta(tmp.Name(), f); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// deltaWriter writes the records of a snapshot delta, counting them and
+// hashing the stream for its trailer. The first error is kept in err.
+type deltaWriter struct {
+	w   *bufio.Writer
+	h   hash.Hash32
+	n   uint64
+	err error
+}
+
+func (w *deltaWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	w.h.Write(b)
+	_, w.err = w.w.Write(b)
+}
+
+func (w *deltaWriter) writeBytes(b []byte) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	w.write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(b)))])
+	w.write(b)
+}
+
+// writeRecord writes a bucket op or a key record with its bucket name or key.
+func (w *deltaWriter) writeRecord(op byte, b []byte) {
+	w.n++
+	w.write([]byte{op})
+	w.writeBytes(b)
+}
+
+func (w *deltaWriter) writeTrailer() {
+	w.write([]byte{deltaOpEnd})
+	var lenBuf [binary.MaxVarintLen64]byte
+	w.write(lenBuf[:binary.PutUvarint(lenBuf[:], w.n)])
+	if w.err == nil {
+		_, w.err = w.w.Write(w.h.Sum(nil))
+	}
+}
+
+// deltaReader reads the records of a snapshot delta, counting them and
+// hashing the stream to verify its trailer.
+type deltaReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+	n uint64
+}
+
+func (r *deltaReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+func (r *deltaReader) ReadByte() (byte, error) {
+	c, err := r.r.ReadByte()
+	if err == nil {
+		r.h.Write([]byte{c})
+	}
+	return c, err
+}
+
+// readBytes reads a length-prefixed byte slice of at most limit bytes. The
+// slice grows as its bytes are read, so a corrupt length does not allocate
+// more than the stream holds.
+func (r *deltaReader) readBytes(limit int) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshotDelta, err)
+	}
+	if n > uint64(limit) {
+		return nil, fmt.Errorf("%w: length %d exceeds %d", ErrInvalidSnapshotDelta, n, limit)
+	}
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshotDelta, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readRecord reads a bucket op with its bucket name, or the start of the
+// trailer.
+func (r *deltaReader) readRecord(limit int) (byte, []byte, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidSnapshotDelta, err)
+	}
+	if op == deltaOpEnd {
+		return op, nil, nil
+	}
+	r.n++
+	name, err := r.readBytes(limit)
+	return op, name, err
+}
+
+// verifyTrailer checks the record count and the checksum following
+// deltaOpEnd against the stream read so far, and that the stream ends there.
+func (r *deltaReader) verifyTrailer() error {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshotDelta, err)
+	}
+	if n != r.n {
+		return fmt.Errorf("%w: %d records, trailer expects %d", ErrInvalidSnapshotDelta, r.n, n)
+	}
+	want := r.h.Sum(nil)
+	sum := make([]byte, len(want))
+	if _, err = io.ReadFull(r.r, sum); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshotDelta, err)
+	}
+	if !bytes.Equal(sum, want) {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshotDelta)
+	}
+	for pad := 0; ; pad++ {
+		c, err := r.r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshotDelta, err)
+		}
+		if c != 0 || pad >= snapshotDeltaAlign-1 {
+			return fmt.Errorf("%w: data after the trailer", ErrInvalidSnapshotDelta)
+		}
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestSnapshotSince(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.UnsafePut(schema.Key, []byte("key"), []byte("value"))
+	tx.Unlock()
+
+	untrack := b.(backend.IncrementalSnapshotter).TrackSnapshotChanges()
+	defer untrack()
+	dst := filepath.Join(t.TempDir(), "backup.db")
+
+	snap := b.(backend.IncrementalSnapshotter).SnapshotSince(backend.SnapshotMarker{})
+	assert.True(t, snap.Full())
+	applySnapshotDelta(t, snap, dst)
+	marker := snap.Marker()
+	assert.Equal(t, dumpBolt(t, backend.DbFromBackendForTest(b)), dumpBoltFile(t, dst))
+
+	// nothing changed since the marker
+	snap = b.(backend.IncrementalSnapshotter).SnapshotSince(marker)
+	assert.False(t, snap.Full())
+	applySnapshotDelta(t, snap, dst)
+	assert.Equal(t, dumpBolt(t, backend.DbFromBackendForTest(b)), dumpBoltFile(t, dst))
+
+	tx.Lock()
+	for i := 0; i < 100; i++ {
+		tx.UnsafePut(schema.Test, []byte(fmt.Sprintf("key-%03d", i)), bytes.Repeat([]byte("v"), 100))
+	}
+	tx.Unlock()
+	snap = b.(backend.IncrementalSnapshotter).SnapshotSince(marker)
+	marker = snap.Marker()
+	applySnapshotDelta(t, snap, dst)
+
+	tx.Lock()
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("baz"))
+	tx.UnsafeDelete(schema.Test, []byte("missing"))
+	tx.UnsafeDeleteBucket(schema.Key)
+	tx.Unlock()
+
+	snap = b.(backend.IncrementalSnapshotter).SnapshotSince(marker)
+	assert.False(t, snap.Full())
+	var buf bytes.Buffer
+	_, err := snap.WriteTo(&buf)
+	require.NoError(t, err)
+	require.NoError(t, snap.Close())
+	// only the changed keys are sent, not the whole bucket
+	assert.Less(t, buf.Len(), 100)
+	assert.NotContains(t, buf.String(), "key-000")
+	require.NoError(t, backend.ApplySnapshotDelta(dst, &buf))
+	assert.Equal(t, dumpBolt(t, backend.DbFromBackendForTest(b)), dumpBoltFile(t, dst))
+
+	// a marker of another backend instance yields a full snapshot
+	snap = b.(backend.IncrementalSnapshotter).SnapshotSince(backend.SnapshotMarker{ID: marker.ID + 1, Commit: marker.Commit})
+	assert.True(t, snap.Full())
+	require.NoError(t, snap.Close())
+}
+
+func TestSnapshotSinceUntracked(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+
+	// without a registered consumer, the changes are not recorded
+	snap := b.(backend.IncrementalSnapshotter).SnapshotSince(backend.SnapshotMarker{})
+	marker := snap.Marker()
+	require.NoError(t, snap.Close())
+	snap = b.(backend.IncrementalSnapshotter).SnapshotSince(marker)
+	assert.True(t, snap.Full())
+	require.NoError(t, snap.Close())
+
+	// markers issued before the registration stay valid if nothing was
+	// written since
+	untrack := b.(backend.IncrementalSnapshotter).TrackSnapshotChanges()
+	snap = b.(backend.IncrementalSnapshotter).SnapshotSince(marker)
+	assert.False(t, snap.Full())
+	require.NoError(t, snap.Close())
+
+	tx.Lock()
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("baz"))
+	tx.Unlock()
+	untrack()
+	untrack()
+	snap = b.(backend.IncrementalSnapshotter).SnapshotSince(marker)
+	assert.True(t, snap.Full())
+	require.NoError(t, snap.Close())
+}
+
+func TestApplySnapshotDeltaInvalid(t *testing.T) {
+	b, _ := betesting.NewTmpBackend(t, time.Hour, 10000)
+	defer betesting.Close(t, b)
+
+	untrack := b.(backend.IncrementalSnapshotter).TrackSnapshotChanges()
+	defer untrack()
+	snap := b.(backend.IncrementalSnapshotter).SnapshotSince(backend.SnapshotMarker{})
+	marker := snap.Marker()
+	require.NoError(t, snap.Close())
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("foo"), []byte("bar"))
+	tx.Unlock()
+
+	snap = b.(backend.IncrementalSnapshotter).SnapshotSince(marker)
+	require.False(t, snap.Full())
+	var buf bytes.Buffer
+	_, err := snap.WriteTo(&buf)
+	require.NoError(t, err)
+	require.NoError(t, snap.Close())
+	stream := buf.Bytes()
+
+	corrupt := bytes.Clone(stream)
+	corrupt[len(corrupt)-6] ^= 1
+	tests := map[string][]byte{
+		"truncated":      stream[:len(stream)-5],
+		"corrupt":        corrupt,
+		"trailing data":  append(bytes.Clone(stream), 1),
+		"huge length":    {2, 0, 3, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		"missing record": {2, 0, 0, 1, 0, 0, 0, 0},
+	}
+	for name, stream := range tests {
+		t.Run(name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "backup.db")
+			db, err := bolt.Open(dst, 0600, nil)
+			require.NoError(t, err)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("old"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("k"), []byte("v"))
+			}))
+			require.NoError(t, db.Close())
+			want := dumpBoltFile(t, dst)
+
+			err = backend.ApplySnapshotDelta(dst, bytes.NewReader(stream))
+			require.ErrorIs(t, err, backend.ErrInvalidSnapshotDelta)
+			assert.Equal(t, want, dumpBoltFile(t, dst), "an invalid stream must not be applied")
+		})
+	}
+}
+
+func applySnapshotDelta(t *testing.T, snap backend.IncrementalSnapshot, path string) {
+	var buf bytes.Buffer
+	_, err := snap.WriteTo(&buf)
+	require.NoError(t, err)
+	require.NoError(t, snap.Close())
+	require.NoError(t, backend.ApplySnapshotDelta(path, &buf))
+}
+
+func dumpBoltFile(t *testing.T, path string) map[string]map[string]string {
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	return dumpBolt(t, db)
+}
+
+func dumpBolt(t *testing.T, db *bolt.DB) map[string]map[string]string {
+	m := make(map[string]map[string]string)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			kvs := make(map[string]string)
+			m[string(name)] = kvs
+			return b.ForEach(func(k, v []byte) error {
+				kvs[string(k)] = string(v)
+				return nil
+			})
+		})
+	})
+	require.NoError(t, err)
+	return m
+}
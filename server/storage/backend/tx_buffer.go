@@ -37,9 +37,19 @@ func (txb *txBuffer) reset() {
 			delete(txb.buckets, k)
 		}
 		v.used = 0
+		v.size = 0
 	}
 }
 
+// size returns the number of bytes of the buffered keys and values.
+func (txb *txBuffer) size() int {
+	size := 0
+	for _, v := range txb.buckets {
+		size += v.size
+	}
+	return size
+}
+
 // txWriteBuffer buffers writes of pending updates that have not yet committed.
 type txWriteBuffer struct {
 	txBuffer
@@ -159,6 +169,8 @@ type bucketBuffer struct {
 	buf []kv
 	// used tracks number of elements in use so buf can be reused without reallocation.
 	used int
+	// size is the number of bytes of the keys and values in use.
+	size int
 }
 
 func newBucketBuffer() *bucketBuffer {
@@ -203,6 +215,7 @@ func (bb *bucketBuffer) ForEach(visitor func(k, v []byte) error) error {
 func (bb *bucketBuffer) add(k, v []byte) {
 	bb.buf[bb.used].key, bb.buf[bb.used].val = k, v
 	bb.used++
+	bb.size += len(k) + len(v)
 	if bb.used == len(bb.buf) {
 		buf := make([]kv, (3*len(bb.buf))/2)
 		copy(buf, bb.buf)
@@ -234,6 +247,8 @@ func (bb *bucketBuffer) dedupe() {
 	for ridx := 1; ridx < bb.used; ridx++ {
 		if !bytes.Equal(bb.buf[ridx].key, bb.buf[widx].key) {
 			widx++
+		} else {
+			bb.size -= len(bb.buf[widx].key) + len(bb.buf[widx].val)
 		}
 		bb.buf[widx] = bb.buf[ridx]
 	}
@@ -252,6 +267,7 @@ func (bb *bucketBuffer) CopyUsed() *bucketBuffer {
 	bbCopy := bucketBuffer{
 		buf:  make([]kv, bb.used),
 		used: bb.used,
+		size: bb.size,
 	}
 	copy(bbCopy.buf, bb.buf[:bb.used])
 	return &bbCopy
@@ -133,6 +133,11 @@ func TestDedupe(t *testing.T) {
 			}
 			bb.dedupe()
 			assert.Equal(t, bb.used, len(tt.expectedKeys))
+			size := 0
+			for i := range tt.expectedKeys {
+				size += len(tt.expectedKeys[i]) + len(tt.expectedVals[i])
+			}
+			assert.Equal(t, size, bb.size)
 			for i := 0; i < bb.used; i++ {
 				assert.Equal(t, bb.buf[i].key, []byte(tt.expectedKeys[i]))
 				assert.Equal(t, bb.buf[i].val, []byte(tt.expectedVals[i]))
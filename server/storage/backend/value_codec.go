@@ -0,0 +1,196 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"go.uber.org/zap"
+)
+
+// Compressor compresses values stored in the backend, e.g. with zstd.
+type Compressor interface {
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// CompressionPolicy configures the transparent compression of values.
+//
+// Compressed values are stored with a magic prefix, so compressed and
+// uncompressed values coexist in a bucket and compression can be enabled on
+// an existing backend. Once enabled, the Compressor must stay configured for
+// as long as compressed values may remain; set Buckets to a bucket that is
+// never written to stop compressing new values.
+type CompressionPolicy struct {
+	Compressor Compressor
+	// MinValueSize is the size below which values are stored uncompressed.
+	MinValueSize int
+	// Buckets are the buckets whose values are compressed. All buckets are
+	// compressed if empty.
+	Buckets []Bucket
+}
+
+// Encryptor encrypts values stored in the backend.
+//
+// Every value is encrypted with the key identified by KeyID at the time it
+// is written, and the key id is stored along with the value, so keys can be
+// rotated by changing KeyID as long as Decrypt still accepts the previous
+// ids. Keys are not encrypted since the backend relies on their order.
+type Encryptor interface {
+	// KeyID returns the id of the key used to encrypt new values.
+	KeyID() uint32
+	Encrypt(keyID uint32, plaintext []byte) ([]byte, error)
+	Decrypt(keyID uint32, ciphertext []byte) ([]byte, error)
+}
+
+// valuePrefix starts every value written with a magic prefix. Values of
+// etcd buckets never start with it, since a protobuf message can not start
+// with a zero byte and the other buckets hold fixed-size integers or names.
+var valuePrefix = []byte{0x00, 'e', 'z'}
+
+const (
+	// valueRaw marks an uncompressed value starting with valuePrefix.
+	valueRaw byte = 0
+	// valueCompressed marks a compressed value.
+	valueCompressed byte = 1
+	// valueEncrypted marks an encrypted value, followed by the key id.
+	valueEncrypted byte = 2
+
+	valueHeaderSize = 4
+	keyIDSize       = 4
+)
+
+// valueCodec applies a CompressionPolicy and an Encryptor to the values put
+// into and read from the backend. Values are compressed before they are
+// encrypted. A nil valueCodec leaves values unchanged.
+type valueCodec struct {
+	c       Compressor
+	minSize int
+	buckets map[BucketID]struct{}
+	enc     Encryptor
+	lg      *zap.Logger
+}
+
+func newValueCodec(lg *zap.Logger, p *CompressionPolicy, enc Encryptor) *valueCodec {
+	if (p == nil || p.Compressor == nil) && enc == nil {
+		return nil
+	}
+	vc := &valueCodec{enc: enc, lg: lg}
+	if p != nil && p.Compressor != nil {
+		vc.c, vc.minSize = p.Compressor, p.MinValueSize
+		if len(p.Buckets) > 0 {
+			vc.buckets = make(map[BucketID]struct{}, len(p.Buckets))
+			for _, b := range p.Buckets {
+				vc.buckets[b.ID()] = struct{}{}
+			}
+		}
+	}
+	return vc
+}
+
+// encode returns the value to store for v in the given bucket.
+func (vc *valueCodec) encode(bucket Bucket, v []byte) []byte {
+	if vc == nil {
+		return v
+	}
+	v = vc.compress(bucket, v)
+	if vc.enc == nil {
+		return v
+	}
+	keyID := vc.enc.KeyID()
+	ev, err := vc.enc.Encrypt(keyID, v)
+	if err != nil {
+		vc.lg.Fatal("failed to encrypt value", zap.Stringer("bucket-name", bucket), zap.Uint32("key-id", keyID), zap.Error(err))
+	}
+	b := make([]byte, 0, valueHeaderSize+keyIDSize+len(ev))
+	b = append(b, valuePrefix...)
+	b = append(b, valueEncrypted)
+	b = binary.BigEndian.AppendUint32(b, keyID)
+	return append(b, ev...)
+}
+
+func (vc *valueCodec) compress(bucket Bucket, v []byte) []byte {
+	if vc.c != nil && vc.compresses(bucket) && len(v) >= vc.minSize {
+		cv, err := vc.c.Compress(v)
+		if err != nil {
+			vc.lg.Warn("failed to compress value", zap.Stringer("bucket-name", bucket), zap.Error(err))
+		} else if len(cv)+valueHeaderSize < len(v) {
+			return withValueHeader(valueCompressed, cv)
+		}
+	}
+	if bytes.HasPrefix(v, valuePrefix) {
+		return withValueHeader(valueRaw, v)
+	}
+	return v
+}
+
+// decode returns the value stored as v.
+func (vc *valueCodec) decode(v []byte) []byte {
+	if vc == nil || len(v) < valueHeaderSize || !bytes.HasPrefix(v, valuePrefix) {
+		return v
+	}
+	switch v[len(valuePrefix)] {
+	case valueRaw:
+		return v[valueHeaderSize:]
+	case valueCompressed:
+		if vc.c == nil {
+			vc.lg.Fatal("found a compressed value but no compressor is configured")
+		}
+		dv, err := vc.c.Decompress(v[valueHeaderSize:])
+		if err != nil {
+			vc.lg.Fatal("failed to decompress value", zap.Error(err))
+		}
+		return dv
+	case valueEncrypted:
+		if vc.enc == nil {
+			vc.lg.Fatal("found an encrypted value but no encryptor is configured")
+		}
+		if len(v) < valueHeaderSize+keyIDSize {
+			vc.lg.Fatal("found a truncated encrypted value", zap.Int("size", len(v)))
+		}
+		keyID := binary.BigEndian.Uint32(v[valueHeaderSize:])
+		dv, err := vc.enc.Decrypt(keyID, v[valueHeaderSize+keyIDSize:])
+		if err != nil {
+			vc.lg.Fatal("failed to decrypt value", zap.Uint32("key-id", keyID), zap.Error(err))
+		}
+		return vc.decode(dv)
+	}
+	return v
+}
+
+func (vc *valueCodec) decodeAll(vs [][]byte) {
+	if vc == nil {
+		return
+	}
+	for i := range vs {
+		vs[i] = vc.decode(vs[i])
+	}
+}
+
+func (vc *valueCodec) compresses(bucket Bucket) bool {
+	if vc.buckets == nil {
+		return true
+	}
+	_, ok := vc.buckets[bucket.ID()]
+	return ok
+}
+
+func withValueHeader(typ byte, v []byte) []byte {
+	b := make([]byte, 0, valueHeaderSize+len(v))
+	b = append(b, valuePrefix...)
+	b = append(b, typ)
+	return append(b, v...)
+}
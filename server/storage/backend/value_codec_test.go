@@ -0,0 +1,222 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func (flateCompressor) Decompress(src []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(src)))
+}
+
+func TestBackendCompression(t *testing.T) {
+	values := map[string][]byte{
+		"large":  bytes.Repeat([]byte("compressible"), 100),
+		"small":  []byte("bar"),
+		"prefix": append([]byte{0x00, 'e', 'z', 0x01}, bytes.Repeat([]byte{'x'}, 10)...),
+	}
+	write := func(b backend.Backend) {
+		tx := b.BatchTx()
+		tx.Lock()
+		tx.UnsafeCreateBucket(schema.Test)
+		for k, v := range values {
+			tx.UnsafePut(schema.Test, []byte(k), v)
+		}
+		tx.Unlock()
+		b.ForceCommit()
+	}
+
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.Compression = &backend.CompressionPolicy{
+		Compressor:   flateCompressor{},
+		MinValueSize: 64,
+		Buckets:      []backend.Bucket{schema.Test},
+	}
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+	write(b)
+
+	err := backend.DbFromBackendForTest(b).View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(schema.Test.Name()).Get([]byte("large"))
+		assert.Less(t, len(stored), len(values["large"]))
+		return nil
+	})
+	require.NoError(t, err)
+
+	for k, v := range values {
+		for _, rtx := range []backend.ReadTx{b.ReadTx(), b.ConcurrentReadTx()} {
+			rtx.RLock()
+			_, vals := rtx.UnsafeRange(schema.Test, []byte(k), nil, 0)
+			rtx.RUnlock()
+			require.Len(t, vals, 1)
+			assert.Equal(t, v, vals[0], "key %q", k)
+		}
+		tx := b.BatchTx()
+		tx.Lock()
+		_, vals := tx.UnsafeRange(schema.Test, []byte(k), nil, 0)
+		tx.Unlock()
+		require.Len(t, vals, 1)
+		assert.Equal(t, v, vals[0], "key %q", k)
+	}
+	got := make(map[string][]byte)
+	rtx := b.ReadTx()
+	rtx.RLock()
+	err = rtx.UnsafeForEach(schema.Test, func(k, v []byte) error {
+		got[string(k)] = bytes.Clone(v)
+		return nil
+	})
+	rtx.RUnlock()
+	require.NoError(t, err)
+	assert.Equal(t, values, got)
+
+	// the hash does not depend on compression
+	plain, _ := betesting.NewDefaultTmpBackend(t)
+	defer betesting.Close(t, plain)
+	write(plain)
+	wantHash, err := plain.Hash(nil)
+	require.NoError(t, err)
+	gotHash, err := b.Hash(nil)
+	require.NoError(t, err)
+	assert.Equal(t, wantHash, gotHash)
+}
+
+type gcmEncryptor struct {
+	keyID uint32
+	keys  map[uint32][]byte
+}
+
+func (e *gcmEncryptor) KeyID() uint32 { return e.keyID }
+
+func (e *gcmEncryptor) aead(keyID uint32) (cipher.AEAD, error) {
+	key, ok := e.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %d", keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *gcmEncryptor) Encrypt(keyID uint32, plaintext []byte) ([]byte, error) {
+	aead, err := e.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (e *gcmEncryptor) Decrypt(keyID uint32, ciphertext []byte) ([]byte, error) {
+	aead, err := e.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+}
+
+func TestBackendEncryption(t *testing.T) {
+	enc := &gcmEncryptor{
+		keyID: 1,
+		keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte{1}, 32),
+			2: bytes.Repeat([]byte{2}, 32),
+		},
+	}
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.Encryptor = enc
+	bcfg.Compression = &backend.CompressionPolicy{Compressor: flateCompressor{}, MinValueSize: 64}
+	b, _ := betesting.NewTmpBackendFromCfg(t, bcfg)
+	defer betesting.Close(t, b)
+
+	values := map[string][]byte{
+		"old":   []byte("secret-1"),
+		"new":   []byte("secret-2"),
+		"large": bytes.Repeat([]byte("secret-3"), 100),
+	}
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Test)
+	tx.UnsafePut(schema.Test, []byte("old"), values["old"])
+	tx.Unlock()
+	b.ForceCommit()
+
+	// rotate the key, values written with the previous key stay readable
+	enc.keyID = 2
+	tx.Lock()
+	tx.UnsafePut(schema.Test, []byte("new"), values["new"])
+	tx.UnsafePut(schema.Test, []byte("large"), values["large"])
+	tx.Unlock()
+	b.ForceCommit()
+
+	err := backend.DbFromBackendForTest(b).View(func(tx *bolt.Tx) error {
+		return tx.Bucket(schema.Test.Name()).ForEach(func(k, v []byte) error {
+			assert.NotContains(t, string(v), "secret", "key %q", k)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+
+	got := make(map[string][]byte)
+	rtx := b.ReadTx()
+	rtx.RLock()
+	err = rtx.UnsafeForEach(schema.Test, func(k, v []byte) error {
+		got[string(k)] = bytes.Clone(v)
+		return nil
+	})
+	rtx.RUnlock()
+	require.NoError(t, err)
+	assert.Equal(t, values, got)
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CorruptionError is returned by Open when the verification of the database
+// requested by BackendConfig.VerifyOnOpen finds it corrupted.
+type CorruptionError struct {
+	// Buckets lists the buckets that could not be walked.
+	Buckets []string
+	// Errs holds the errors found in the database, by bucket walk first.
+	Errs []error
+}
+
+func (e *CorruptionError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	if len(e.Buckets) == 0 {
+		return fmt.Sprintf("backend: database corrupted: %s", strings.Join(msgs, "; "))
+	}
+	return fmt.Sprintf("backend: database corrupted in buckets %s: %s",
+		strings.Join(e.Buckets, ", "), strings.Join(msgs, "; "))
+}
+
+func (e *CorruptionError) Unwrap() []error { return e.Errs }
+
+// verifyEngine walks every bucket, logging the CRC of the critical ones,
+// then, if no bucket is corrupted, runs the consistency check of the bbolt
+// files of db for up to timeout. A check that times out is abandoned without
+// failing the verification; it keeps running in the background until it
+// completes.
+func verifyEngine(lg *zap.Logger, db KVEngine, critical []Bucket, timeout time.Duration) error {
+	start := time.Now()
+	tx, err := db.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var names [][]byte
+	if err = tx.ForEachBucket(func(name []byte, _ EngineBucket) error {
+		names = append(names, bytes.Clone(name))
+		return nil
+	}); err != nil {
+		return err
+	}
+	isCritical := make(map[string]bool, len(critical))
+	for _, bucket := range critical {
+		isCritical[string(bucket.Name())] = true
+	}
+
+	cerr := &CorruptionError{}
+	for _, name := range names {
+		crc, n, err := walkBucket(tx, name)
+		if err != nil {
+			cerr.Buckets = append(cerr.Buckets, string(name))
+			cerr.Errs = append(cerr.Errs, fmt.Errorf("bucket %s: %w", name, err))
+			continue
+		}
+		if isCritical[string(name)] {
+			lg.Info(
+				"verified bucket",
+				zap.ByteString("bucket-name", name),
+				zap.Int("keys", n),
+				zap.Uint32("crc", crc),
+			)
+		}
+	}
+
+	// the consistency check of bbolt panics in its own goroutine on the
+	// pages that fail the walk, so it only runs once the walk passes.
+	var dbs []*bolt.DB
+	switch e := db.(type) {
+	case *boltEngine:
+		dbs = []*bolt.DB{e.db}
+	case *multiEngine:
+		for _, be := range e.files() {
+			dbs = append(dbs, be.db)
+		}
+	}
+	for _, bdb := range dbs {
+		if len(cerr.Errs) > 0 {
+			break
+		}
+		errs, done := checkBolt(bdb, timeout)
+		if !done {
+			lg.Warn(
+				"abandoned database consistency check after timeout",
+				zap.String("path", bdb.Path()),
+				zap.Duration("timeout", timeout),
+			)
+		}
+		cerr.Errs = append(cerr.Errs, errs...)
+	}
+
+	if len(cerr.Errs) > 0 {
+		return cerr
+	}
+	lg.Info(
+		"verified database",
+		zap.String("path", db.Path()),
+		zap.Int("buckets", len(names)),
+		zap.Duration("took", time.Since(start)),
+	)
+	return nil
+}
+
+// walkBucket reads every key of the bucket, checking that the keys are in
+// order, and returns the CRC of its content and its number of keys.
+// Corrupted pages make bbolt panic, so panics are
+// reported as errors.
+func walkBucket(tx EngineTx, name []byte) (crc uint32, n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	b := tx.Bucket(name)
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	var prev []byte
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if prev != nil && bytes.Compare(prev, k) >= 0 {
+			return 0, n, fmt.Errorf("key %x is not after key %x", k, prev)
+		}
+		prev = k
+		h.Write(k)
+		h.Write(v)
+		n++
+	}
+	return h.Sum32(), n, nil
+}
+
+// checkBolt runs the consistency check of db for up to timeout, or without
+// limit if timeout is zero. It returns the errors found and whether the
+// check completed.
+func checkBolt(db *bolt.DB, timeout time.Duration) ([]error, bool) {
+	errc := make(chan error)
+	go func() {
+		defer close(errc)
+		tx, err := db.Begin(false)
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer tx.Rollback()
+		for err := range tx.Check() {
+			errc <- err
+		}
+	}()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	var errs []error
+	for {
+		select {
+		case err, ok := <-errc:
+			if !ok {
+				return errs, true
+			}
+			errs = append(errs, err)
+		case <-timer:
+			// drain the remaining errors so the check can complete.
+			go func() {
+				for range errc {
+				}
+			}()
+			return errs, false
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestBackendVerifyOnOpen(t *testing.T) {
+	b, path := betesting.NewTmpBackend(t, time.Hour, 10000)
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Key)
+	tx.UnsafeCreateBucket(schema.Meta)
+	for i := 0; i < 1000; i++ {
+		tx.UnsafePut(schema.Key, []byte(fmt.Sprintf("foo_%04d", i)), make([]byte, 100))
+	}
+	tx.UnsafePut(schema.Meta, []byte("consistent_index"), []byte("1"))
+	tx.Unlock()
+	betesting.Close(t, b)
+
+	bcfg := backend.DefaultBackendConfig(zaptest.NewLogger(t))
+	bcfg.Path = path
+	bcfg.VerifyOnOpen = true
+	bcfg.VerifyBuckets = []backend.Bucket{schema.Key}
+	bcfg.VerifyTimeout = 10 * time.Second
+	b, err := backend.Open(bcfg)
+	require.NoError(t, err)
+	betesting.Close(t, b)
+
+	corruptBucketRoot(t, path, schema.Key)
+	_, err = backend.Open(bcfg)
+	var cerr *backend.CorruptionError
+	require.ErrorAs(t, err, &cerr)
+	assert.Equal(t, []string{"key"}, cerr.Buckets)
+}
+
+// corruptBucketRoot overwrites the root page of the bucket with garbage.
+func corruptBucketRoot(t *testing.T, path string, bucket backend.Bucket) {
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	var root, pageSize int64
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		root = int64(tx.Bucket(bucket.Name()).Root())
+		pageSize = int64(db.Info().PageSize)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	garbage := make([]byte, pageSize)
+	for i := range garbage {
+		garbage[i] = 0xff
+	}
+	_, err = f.WriteAt(garbage, root*pageSize)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
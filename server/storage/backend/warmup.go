@@ -0,0 +1,133 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// warmUpChunkSize is the number of keys touched per read transaction by
+// WarmUp, so that it never holds a read transaction for long.
+const warmUpChunkSize = 10000
+
+// WarmUp reads the given buckets, or every bucket if none is given, in key
+// order so that their pages are loaded into the page cache sequentially
+// instead of by the random reads following a restart. It returns the number
+// of keys read. WarmUp stops early when the backend is closed.
+func (b *backend) WarmUp(buckets ...Bucket) (int, error) {
+	start := time.Now()
+	var names [][]byte
+	if len(buckets) == 0 {
+		tx, err := b.beginReadTx()
+		if err != nil {
+			return 0, err
+		}
+		err = tx.ForEachBucket(func(name []byte, _ EngineBucket) error {
+			names = append(names, bytes.Clone(name))
+			return nil
+		})
+		tx.Rollback()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		for _, bucket := range buckets {
+			names = append(names, bucket.Name())
+		}
+	}
+
+	total := 0
+	for _, name := range names {
+		var after []byte
+		for {
+			select {
+			case <-b.stopc:
+				return total, nil
+			default:
+			}
+			n, last, err := b.warmUpChunk(name, after)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			if last == nil {
+				break
+			}
+			after = last
+		}
+	}
+	b.lg.Info(
+		"warmed up backend",
+		zap.Int("buckets", len(names)),
+		zap.Int("keys", total),
+		zap.Duration("took", time.Since(start)),
+	)
+	return total, nil
+}
+
+// warmUpChunk reads up to warmUpChunkSize keys of the bucket following the
+// key after. It returns the last key read, or nil once the bucket is done.
+func (b *backend) warmUpChunk(name, after []byte) (int, []byte, error) {
+	tx, err := b.beginReadTx()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	bucket := tx.Bucket(name)
+	if bucket == nil {
+		return 0, nil, nil
+	}
+
+	c := bucket.Cursor()
+	var k, v []byte
+	if after == nil {
+		k, v = c.First()
+	} else if k, v = c.Seek(after); bytes.Equal(k, after) {
+		k, v = c.Next()
+	}
+	var sink byte
+	n := 0
+	for ; k != nil; k, v = c.Next() {
+		// touch the value, which may be stored on an overflow page
+		if len(v) > 0 {
+			sink ^= v[len(v)-1]
+		}
+		n++
+		if n == warmUpChunkSize {
+			return n, bytes.Clone(k), nil
+		}
+	}
+	_ = sink
+	return n, nil, nil
+}
+
+func (b *backend) warmUpInBackground() {
+	b.warmUpWg.Add(1)
+	go func() {
+		defer b.warmUpWg.Done()
+		if _, err := b.WarmUp(); err != nil {
+			b.lg.Warn("failed to warm up backend", zap.Error(err))
+		}
+	}()
+}
+
+func (b *backend) beginReadTx() (EngineTx, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.Begin(false)
+}
@@ -97,6 +97,13 @@ type HashStorage interface {
 	// HashByRev computes the hash of all MVCC revisions up to a given revision.
 	HashByRev(rev int64) (hash KeyValueHash, currentRev int64, err error)
 
+	// HashByRange computes the hash of the keys from key(included) to
+	// end(excluded) at a given revision, from their key-value pairs at the
+	// revision, so that members can check the consistency of a range. If
+	// end is nil, the hash is computed over key. If rev is 0, the current
+	// revision is used.
+	HashByRange(key, end []byte, rev int64) (hash KeyValueHash, currentRev int64, err error)
+
 	// Store adds hash value in local cache, allowing it to be returned by HashByRev.
 	Store(valueHash KeyValueHash)
 
@@ -139,6 +146,10 @@ func (s *hashStorage) HashByRev(rev int64) (KeyValueHash, int64, error) {
 	return s.store.hashByRev(rev)
 }
 
+func (s *hashStorage) HashByRange(key, end []byte, rev int64) (KeyValueHash, int64, error) {
+	return s.store.hashByRange(key, end, rev)
+}
+
 func (s *hashStorage) Store(hash KeyValueHash) {
 	s.lg.Info("storing new hash",
 		zap.Uint32("hash", hash.Hash),
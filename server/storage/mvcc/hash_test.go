@@ -132,6 +132,49 @@ func testHashByRev(t *testing.T, s *store, rev int64) KeyValueHash {
 
 // TestCompactionHash tests compaction hash
 // TODO: Change this to fuzz test
+func TestHashByRange(t *testing.T) {
+	defer func(n int) { rangeStreamChunkKeys = n }(rangeStreamChunkKeys)
+	rangeStreamChunkKeys = 2
+
+	newStore := func() *store {
+		b, _ := betesting.NewDefaultTmpBackend(t)
+		s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+		t.Cleanup(func() { cleanup(s, b) })
+		return s
+	}
+	hash := func(s *store, rev int64) uint32 {
+		t.Helper()
+		h, _, err := s.HashStorage().HashByRange([]byte("a/"), []byte("a0"), rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.Hash
+	}
+
+	s0, s1 := newStore(), newStore()
+	for _, s := range []*store{s0, s1} {
+		for i := 0; i < 5; i++ {
+			s.Put([]byte(fmt.Sprintf("a/%d", i)), []byte("v"), lease.NoLease)
+		}
+	}
+	assert.Equal(t, hash(s0, 0), hash(s1, 0))
+	h := hash(s0, 0)
+
+	// writes out of the range do not change its hash.
+	s0.Put([]byte("b"), []byte("v"), lease.NoLease)
+	assert.Equal(t, h, hash(s0, 0))
+	s1.Put([]byte("a/1"), []byte("v1"), lease.NoLease)
+	assert.NotEqual(t, h, hash(s1, 0))
+	assert.Equal(t, h, hash(s1, 6))
+
+	s0.DeleteRange([]byte("a/4"), nil)
+	assert.NotEqual(t, h, hash(s0, 0))
+	assert.Equal(t, h, hash(s0, 7))
+
+	_, _, err := s0.HashStorage().HashByRange([]byte("a/"), []byte("a0"), 100)
+	assert.ErrorIs(t, err, ErrFutureRev)
+}
+
 func TestCompactionHash(t *testing.T) {
 	b, _ := betesting.NewDefaultTmpBackend(t)
 	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
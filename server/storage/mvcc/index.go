@@ -24,45 +24,148 @@ import (
 type index interface {
 	Get(key []byte, atRev int64) (rev, created Revision, ver int64, err error)
 	Range(key, end []byte, atRev int64) ([][]byte, []Revision)
+	RangeLimit(key, end []byte, atRev int64, limit int) ([][]byte, []Revision)
 	Revisions(key, end []byte, atRev int64, limit int) ([]Revision, int)
+	History(key []byte, startRev, endRev int64, limit int) ([]Revision, bool)
 	CountRevisions(key, end []byte, atRev int64) int
 	Put(key []byte, rev Revision)
+	// GetBatch and PutBatch get and put the revisions of several keys at
+	// once.
+	GetBatch(keys [][]byte, atRev int64) []keyState
+	PutBatch(keys [][]byte, revs []Revision)
 	Tombstone(key []byte, rev Revision) error
-	Compact(rev int64) map[Revision]struct{}
-	Keep(rev int64) map[Revision]struct{}
+	// Compact compacts the revisions at rev but the revisions retained by
+	// r, and returns the revisions available after the compaction.
+	Compact(rev int64, r *retention) map[Revision]struct{}
+	Keep(rev int64, r *retention) map[Revision]struct{}
+	// CompactionEstimate counts the revisions and the tombstones Compact
+	// would remove, and every revision.
+	CompactionEstimate(rev int64, r *retention) (revs, tombstones, total int)
 	Equal(b index) bool
 
 	Insert(ki *keyIndex)
 	KeyIndex(ki *keyIndex) *keyIndex
+	// Ascend calls f for every keyIndex in key order until f returns false.
+	// The keyIndexes must not be modified by f.
+	Ascend(f func(ki *keyIndex) bool)
+
+	// Stats returns the statistics of the index.
+	Stats() indexStats
+	// Recount recounts the statistics of the index after its keyIndexes
+	// were modified directly, as restoring the index does.
+	Recount()
 }
 
+// indexStats are the statistics of the keyIndexes of an index, kept up to
+// date by puts, tombstones and compactions.
+type indexStats struct {
+	keys        int
+	generations int
+	revisions   int
+	// maxRevisions is the most revisions of a key. It only decreases with
+	// compactions.
+	maxRevisions int
+	// depth is the depth of the btree of the keys, estimated from the keys
+	// and the degree of the tree.
+	depth int
+}
+
+const treeIndexDegree = 32
+
 type treeIndex struct {
 	sync.RWMutex
 	tree *btree.BTreeG[*keyIndex]
 	lg   *zap.Logger
+
+	stats indexStats
+	// putMaxRevisions is the most revisions of the keys put since the last
+	// compaction started, which are not counted by the compaction.
+	putMaxRevisions int
 }
 
 func newTreeIndex(lg *zap.Logger) index {
 	return &treeIndex{
-		tree: btree.NewG(32, func(aki *keyIndex, bki *keyIndex) bool {
+		tree: btree.NewG(treeIndexDegree, func(aki *keyIndex, bki *keyIndex) bool {
 			return aki.Less(bki)
 		}),
 		lg: lg,
 	}
 }
 
+// keyState is the state of a key at a revision, as Get returns it.
+type keyState struct {
+	modified, created Revision
+	ver               int64
+	err               error
+}
+
 func (ti *treeIndex) Put(key []byte, rev Revision) {
-	keyi := &keyIndex{key: key}
+	ti.Lock()
+	defer ti.Unlock()
+	ti.unsafePut(key, rev)
+}
 
+func (ti *treeIndex) PutBatch(keys [][]byte, revs []Revision) {
 	ti.Lock()
 	defer ti.Unlock()
+	for i, key := range keys {
+		ti.unsafePut(key, revs[i])
+	}
+}
+
+func (ti *treeIndex) unsafePut(key []byte, rev Revision) {
+	keyi := &keyIndex{key: key}
 	okeyi, ok := ti.tree.Get(keyi)
 	if !ok {
 		keyi.put(ti.lg, rev.Main, rev.Sub)
 		ti.tree.ReplaceOrInsert(keyi)
+		ti.stats.keys++
+		ti.count(keyi, 0, 0)
 		return
 	}
+	gens, revs := okeyi.counts()
 	okeyi.put(ti.lg, rev.Main, rev.Sub)
+	ti.count(okeyi, gens, revs)
+}
+
+// count updates the statistics with the generations and the revisions ki
+// has more than gens and revs. The tree must be locked.
+func (ti *treeIndex) count(ki *keyIndex, gens, revs int) {
+	ngens, nrevs := ki.counts()
+	ti.stats.generations += ngens - gens
+	ti.stats.revisions += nrevs - revs
+	ti.stats.maxRevisions = max(ti.stats.maxRevisions, nrevs)
+	ti.putMaxRevisions = max(ti.putMaxRevisions, nrevs)
+}
+
+func (ti *treeIndex) Stats() indexStats {
+	ti.RLock()
+	defer ti.RUnlock()
+	st := ti.stats
+	st.depth = btreeDepth(st.keys, treeIndexDegree)
+	return st
+}
+
+// btreeDepth returns the depth of a btree of the given degree holding n
+// items in half full nodes, the least the nodes but the root hold.
+func btreeDepth(n, degree int) int {
+	depth := 0
+	// a tree of depth d with half full nodes holds (degree^d - 1) items.
+	for capacity := 0; capacity < n; capacity = capacity*degree + degree - 1 {
+		depth++
+	}
+	return depth
+}
+
+func (ti *treeIndex) Recount() {
+	ti.Lock()
+	defer ti.Unlock()
+	ti.stats = indexStats{}
+	ti.tree.Ascend(func(ki *keyIndex) bool {
+		ti.stats.keys++
+		ti.count(ki, 0, 0)
+		return true
+	})
 }
 
 func (ti *treeIndex) Get(key []byte, atRev int64) (modified, created Revision, ver int64, err error) {
@@ -71,6 +174,17 @@ func (ti *treeIndex) Get(key []byte, atRev int64) (modified, created Revision, v
 	return ti.unsafeGet(key, atRev)
 }
 
+func (ti *treeIndex) GetBatch(keys [][]byte, atRev int64) []keyState {
+	ti.RLock()
+	defer ti.RUnlock()
+	states := make([]keyState, len(keys))
+	for i, key := range keys {
+		st := &states[i]
+		st.modified, st.created, st.ver, st.err = ti.unsafeGet(key, atRev)
+	}
+	return states
+}
+
 func (ti *treeIndex) unsafeGet(key []byte, atRev int64) (modified, created Revision, ver int64, err error) {
 	keyi := &keyIndex{key: key}
 	if keyi = ti.keyIndex(keyi); keyi == nil {
@@ -132,6 +246,29 @@ func (ti *treeIndex) Revisions(key, end []byte, atRev int64, limit int) (revs []
 	return revs, total
 }
 
+// History returns the revisions of key in [startRev, endRev), up to limit
+// if limit > 0, and whether the limit left out later revisions. Only the
+// last revision of the key within a main revision is returned.
+func (ti *treeIndex) History(key []byte, startRev, endRev int64, limit int) (revs []Revision, more bool) {
+	ti.RLock()
+	defer ti.RUnlock()
+
+	ki := ti.keyIndex(&keyIndex{key: key})
+	if ki == nil {
+		return nil, false
+	}
+	for _, rev := range ki.since(ti.lg, startRev) {
+		if rev.Main >= endRev {
+			break
+		}
+		if limit > 0 && len(revs) == limit {
+			return revs, true
+		}
+		revs = append(revs, rev)
+	}
+	return revs, false
+}
+
 // CountRevisions returns the number of revisions
 // from key(included) to end(excluded) at the given rev.
 func (ti *treeIndex) CountRevisions(key, end []byte, atRev int64) int {
@@ -147,6 +284,14 @@ func (ti *treeIndex) CountRevisions(key, end []byte, atRev int64) int {
 	}
 	total := 0
 	ti.unsafeVisit(key, end, func(ki *keyIndex) bool {
+		if atRev >= ki.modified.Main {
+			// the key is unchanged since atRev, it exists if its last
+			// generation is not ended by a tombstone.
+			if !ki.generations[len(ki.generations)-1].isEmpty() {
+				total++
+			}
+			return true
+		}
 		if _, _, _, err := ki.get(ti.lg, atRev); err == nil {
 			total++
 		}
@@ -156,6 +301,13 @@ func (ti *treeIndex) CountRevisions(key, end []byte, atRev int64) int {
 }
 
 func (ti *treeIndex) Range(key, end []byte, atRev int64) (keys [][]byte, revs []Revision) {
+	return ti.RangeLimit(key, end, atRev, 0)
+}
+
+// RangeLimit returns up to limit keys from key(included) to end(excluded)
+// at the given rev, and their revisions. There is no limit if limit <= 0.
+// Unlike Revisions, it stops visiting the keys at the limit.
+func (ti *treeIndex) RangeLimit(key, end []byte, atRev int64, limit int) (keys [][]byte, revs []Revision) {
 	ti.RLock()
 	defer ti.RUnlock()
 
@@ -171,7 +323,7 @@ func (ti *treeIndex) Range(key, end []byte, atRev int64) (keys [][]byte, revs []
 			revs = append(revs, rev)
 			keys = append(keys, ki.key)
 		}
-		return true
+		return limit <= 0 || len(revs) < limit
 	})
 	return keys, revs
 }
@@ -186,45 +338,101 @@ func (ti *treeIndex) Tombstone(key []byte, rev Revision) error {
 		return ErrRevisionNotFound
 	}
 
+	gens, revs := ki.counts()
+	defer ti.count(ki, gens, revs)
 	return ki.tombstone(ti.lg, rev.Main, rev.Sub)
 }
 
-func (ti *treeIndex) Compact(rev int64) map[Revision]struct{} {
+func (ti *treeIndex) Compact(rev int64, r *retention) map[Revision]struct{} {
 	available := make(map[Revision]struct{})
 	ti.lg.Info("compact tree index", zap.Int64("revision", rev))
 	ti.Lock()
 	clone := ti.tree.Clone()
+	ti.putMaxRevisions = 0
 	ti.Unlock()
 
+	maxRevisions := 0
 	clone.Ascend(func(keyi *keyIndex) bool {
 		// Lock is needed here to prevent modification to the keyIndex while
 		// compaction is going on or revision added to empty before deletion
 		ti.Lock()
-		keyi.compact(ti.lg, rev, available)
+		gens, revs := keyi.counts()
+		atRev := r.compactRev(keyi, rev)
+		keyi.compact(ti.lg, atRev, available)
+		if atRev < rev {
+			keyi.retain(atRev, rev, available)
+		}
+		ngens, nrevs := keyi.counts()
+		ti.stats.generations += ngens - gens
+		ti.stats.revisions += nrevs - revs
+		maxRevisions = max(maxRevisions, nrevs)
 		if keyi.isEmpty() {
 			_, ok := ti.tree.Delete(keyi)
 			if !ok {
 				ti.lg.Panic("failed to delete during compaction")
 			}
+			ti.stats.keys--
+			ti.stats.generations -= ngens
+			ti.stats.revisions -= nrevs
 		}
 		ti.Unlock()
 		return true
 	})
+	ti.Lock()
+	ti.stats.maxRevisions = max(maxRevisions, ti.putMaxRevisions)
+	ti.Unlock()
 	return available
 }
 
 // Keep finds all revisions to be kept for a Compaction at the given rev.
-func (ti *treeIndex) Keep(rev int64) map[Revision]struct{} {
+func (ti *treeIndex) Keep(rev int64, r *retention) map[Revision]struct{} {
 	available := make(map[Revision]struct{})
 	ti.RLock()
 	defer ti.RUnlock()
 	ti.tree.Ascend(func(keyi *keyIndex) bool {
-		keyi.keep(rev, available)
+		atRev := r.compactRev(keyi, rev)
+		keyi.keep(atRev, available)
+		if atRev < rev {
+			keyi.retain(atRev, rev, available)
+		}
 		return true
 	})
 	return available
 }
 
+func (ti *treeIndex) CompactionEstimate(rev int64, r *retention) (revs, tombstones, total int) {
+	ti.RLock()
+	defer ti.RUnlock()
+	ti.tree.Ascend(func(keyi *keyIndex) bool {
+		available := make(map[Revision]struct{})
+		atRev := r.compactRev(keyi, rev)
+		keyi.keep(atRev, available)
+		if atRev < rev {
+			keyi.retain(atRev, rev, available)
+		}
+		for gi := range keyi.generations {
+			g := &keyi.generations[gi]
+			for i, kr := range g.revisions() {
+				total++
+				if kr.Main > rev {
+					continue
+				}
+				if _, ok := available[kr]; ok {
+					continue
+				}
+				revs++
+				// the last revision of a generation but the last one is a
+				// tombstone.
+				if i == g.len()-1 && gi != len(keyi.generations)-1 {
+					tombstones++
+				}
+			}
+		}
+		return true
+	})
+	return revs, tombstones, total
+}
+
 func (ti *treeIndex) Equal(bi index) bool {
 	b := bi.(*treeIndex)
 
@@ -249,5 +457,18 @@ func (ti *treeIndex) Equal(bi index) bool {
 func (ti *treeIndex) Insert(ki *keyIndex) {
 	ti.Lock()
 	defer ti.Unlock()
-	ti.tree.ReplaceOrInsert(ki)
+	if old, ok := ti.tree.ReplaceOrInsert(ki); ok {
+		gens, revs := old.counts()
+		ti.stats.generations -= gens
+		ti.stats.revisions -= revs
+	} else {
+		ti.stats.keys++
+	}
+	ti.count(ki, 0, 0)
+}
+
+func (ti *treeIndex) Ascend(f func(ki *keyIndex) bool) {
+	ti.RLock()
+	defer ti.RUnlock()
+	ti.tree.Ascend(f)
 }
@@ -15,6 +15,7 @@
 package mvcc
 
 import (
+	"runtime"
 	"testing"
 
 	"go.uber.org/zap"
@@ -37,7 +38,7 @@ func benchmarkIndexCompact(b *testing.B, size int) {
 	}
 	b.ResetTimer()
 	for i := 1; i < b.N; i++ {
-		kvindex.Compact(int64(i))
+		kvindex.Compact(int64(i), nil)
 	}
 }
 
@@ -67,3 +68,24 @@ func BenchmarkIndexGet(b *testing.B) {
 		kvindex.Get(keys[i], int64(i))
 	}
 }
+
+// BenchmarkIndexPutHotKey reports the memory the index takes per revision of
+// a frequently updated key.
+func BenchmarkIndexPutHotKey(b *testing.B) {
+	log := zap.NewNop()
+	kvindex := newTreeIndex(log)
+	key := []byte("foo")
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 1; i <= b.N; i++ {
+		kvindex.Put(key, Revision{Main: int64(i)})
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "heap-B/rev")
+	runtime.KeepAlive(kvindex)
+}
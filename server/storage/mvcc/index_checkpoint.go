@@ -0,0 +1,279 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/server/v3/lease"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+// The key index checkpoint is stored in the schema.KeyIndex bucket: the
+// keyIndex of every key under checkpointKeyPrefix followed by the key, and
+// the revision the checkpoint covers under checkpointRevKey. The revision is
+// written last, in the same batch as the last keyIndexes, so a checkpoint
+// interrupted by a crash is ignored.
+const (
+	checkpointKeyPrefix byte = 'k'
+	checkpointFormat    byte = 1
+)
+
+var checkpointRevKey = []byte("r")
+
+var errCorruptCheckpoint = errors.New("mvcc: corrupt key index checkpoint")
+
+type checkpointEntry struct {
+	key, value []byte
+}
+
+// encodeKeyIndex encodes ki and the lease attached to its key.
+func encodeKeyIndex(ki *keyIndex, lid lease.LeaseID) []byte {
+	b := []byte{checkpointFormat}
+	b = binary.AppendVarint(b, int64(lid))
+	b = appendRevision(b, ki.modified)
+	b = binary.AppendUvarint(b, uint64(len(ki.generations)))
+	for _, g := range ki.generations {
+		b = binary.AppendVarint(b, g.ver)
+		b = appendRevision(b, g.created)
+		b = binary.AppendUvarint(b, uint64(g.len()))
+		for _, rev := range g.revisions() {
+			b = appendRevision(b, rev)
+		}
+	}
+	return b
+}
+
+func appendRevision(b []byte, rev Revision) []byte {
+	b = binary.AppendVarint(b, rev.Main)
+	return binary.AppendVarint(b, rev.Sub)
+}
+
+// decodeKeyIndex decodes the keyIndex of key encoded by encodeKeyIndex.
+func decodeKeyIndex(key, b []byte) (*keyIndex, lease.LeaseID, error) {
+	if len(b) == 0 || b[0] != checkpointFormat {
+		return nil, lease.NoLease, errCorruptCheckpoint
+	}
+	d := checkpointDecoder{b: b[1:]}
+	lid := lease.LeaseID(d.varint())
+	ki := &keyIndex{key: key, modified: d.revision()}
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		g := generation{ver: d.varint(), created: d.revision()}
+		m := d.uvarint()
+		for j := uint64(0); j < m && d.err == nil; j++ {
+			g.append(d.revision())
+		}
+		ki.generations = append(ki.generations, g)
+	}
+	if d.err != nil || len(d.b) != 0 || len(ki.generations) == 0 {
+		return nil, lease.NoLease, errCorruptCheckpoint
+	}
+	return ki, lid, nil
+}
+
+type checkpointDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *checkpointDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errCorruptCheckpoint
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *checkpointDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errCorruptCheckpoint
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *checkpointDecoder) revision() Revision {
+	return Revision{Main: d.varint(), Sub: d.varint()}
+}
+
+func (s *store) runIndexCheckpoints(stopc <-chan struct{}) {
+	defer s.checkpointWg.Done()
+	t := time.NewTicker(s.cfg.IndexCheckpointInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-stopc:
+			return
+		}
+		if err := s.checkpointIndex(stopc); err != nil {
+			s.lg.Warn("failed to checkpoint key index", zap.Error(err))
+		}
+	}
+}
+
+// checkpointIndex writes the key index to the backend along with the
+// revision it covers. The keyIndexes are copied while holding the store lock,
+// so that they match the current revision and the lease attachments, then
+// encoded and written in chunks of restoreChunkKeys keys without blocking
+// txns. It gives up if stopc is closed, by Close or Restore.
+func (s *store) checkpointIndex(stopc <-chan struct{}) error {
+	start := time.Now()
+	s.mu.Lock()
+	select {
+	case <-stopc:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	b := s.b
+	s.revMu.RLock()
+	rev := s.currentRev
+	s.revMu.RUnlock()
+	type leasedKeyIndex struct {
+		ki  *keyIndex
+		lid lease.LeaseID
+	}
+	var kis []leasedKeyIndex
+	s.kvindex.Ascend(func(ki *keyIndex) bool {
+		lid := lease.NoLease
+		if s.le != nil {
+			lid = s.le.GetLease(lease.LeaseItem{Key: string(ki.key)})
+		}
+		kis = append(kis, leasedKeyIndex{ki: ki.snapshot(), lid: lid})
+		return true
+	})
+	s.mu.Unlock()
+	copyTook := time.Since(start)
+
+	entries := make([]checkpointEntry, len(kis))
+	for i, k := range kis {
+		key := append([]byte{checkpointKeyPrefix}, k.ki.key...)
+		entries[i] = checkpointEntry{key: key, value: encodeKeyIndex(k.ki, k.lid)}
+	}
+	kis = nil
+
+	for i := 0; i == 0 || i < len(entries); i += restoreChunkKeys {
+		end := i + restoreChunkKeys
+		if end > len(entries) {
+			end = len(entries)
+		}
+		s.mu.RLock()
+		select {
+		case <-stopc:
+			s.mu.RUnlock()
+			return nil
+		default:
+		}
+		tx := b.BatchTx()
+		tx.LockOutsideApply()
+		if i == 0 {
+			tx.UnsafeCreateBucket(schema.KeyIndex)
+			tx.UnsafeDeleteBucket(schema.KeyIndex)
+			tx.UnsafeCreateBucket(schema.KeyIndex)
+		}
+		for _, e := range entries[i:end] {
+			tx.UnsafePut(schema.KeyIndex, e.key, e.value)
+		}
+		if end == len(entries) {
+			rbytes := NewRevBytes()
+			tx.UnsafePut(schema.KeyIndex, checkpointRevKey, RevToBytes(Revision{Main: rev}, rbytes))
+		}
+		tx.Unlock()
+		s.mu.RUnlock()
+	}
+
+	s.lg.Info(
+		"checkpointed key index",
+		zap.Int64("revision", rev),
+		zap.Int("keys", len(entries)),
+		zap.Duration("copy-took", copyTook),
+		zap.Duration("took", time.Since(start)),
+	)
+	return nil
+}
+
+// loadIndexCheckpoint inserts the keyIndexes of the checkpoint into the key
+// index and returns the revision they cover, along with the leases attached
+// to their keys. It returns false, leaving the key index untouched, if there
+// is no complete checkpoint or if it is older than minRev, since compactions
+// up to minRev may have removed revisions the checkpoint does not cover.
+func (s *store) loadIndexCheckpoint(tx backend.UnsafeReader, minRev int64) (int64, map[string]lease.LeaseID, bool) {
+	_, vs := tx.UnsafeRange(schema.KeyIndex, checkpointRevKey, nil, 0)
+	if len(vs) == 0 {
+		return 0, nil, false
+	}
+	rev := BytesToRev(vs[0]).Main
+	if rev < minRev {
+		s.lg.Info(
+			"ignored key index checkpoint older than compaction",
+			zap.Int64("checkpoint-revision", rev),
+			zap.Int64("compact-revision", minRev),
+		)
+		return 0, nil, false
+	}
+
+	var kis []*keyIndex
+	keyToLease := make(map[string]lease.LeaseID)
+	err := tx.UnsafeForEach(schema.KeyIndex, func(k, v []byte) error {
+		if len(k) == 0 || k[0] != checkpointKeyPrefix {
+			return nil
+		}
+		key := string(k[1:])
+		ki, lid, err := decodeKeyIndex([]byte(key), v)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", key, err)
+		}
+		kis = append(kis, ki)
+		if lid != lease.NoLease && !ki.generations[len(ki.generations)-1].isEmpty() {
+			keyToLease[key] = lid
+		}
+		return nil
+	})
+	if err != nil {
+		s.lg.Warn("failed to load key index checkpoint", zap.Error(err))
+		return 0, nil, false
+	}
+
+	for _, ki := range kis {
+		if !ki.generations[len(ki.generations)-1].isEmpty() {
+			keysGauge.Inc()
+		}
+		s.kvindex.Insert(ki)
+	}
+	s.lg.Info(
+		"loaded key index checkpoint",
+		zap.Int64("checkpoint-revision", rev),
+		zap.Int("keys", len(kis)),
+	)
+	return rev, keyToLease, true
+}
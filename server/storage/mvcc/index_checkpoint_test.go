@@ -0,0 +1,145 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/pkg/v3/traceutil"
+	"go.etcd.io/etcd/server/v3/lease"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func TestKeyIndexCheckpointEncoding(t *testing.T) {
+	ki := &keyIndex{
+		key:      []byte("foo"),
+		modified: Revision{Main: 16},
+		generations: []generation{
+			{created: Revision{Main: 2}, ver: 3, revs: []Revision{{Main: 2}, {Main: 4, Sub: 1}, {Main: 6}}},
+			{created: Revision{Main: 8}, ver: 2, revs: []Revision{{Main: 8}, {Main: 16}}},
+			{},
+		},
+	}
+	got, lid, err := decodeKeyIndex(ki.key, encodeKeyIndex(ki, 7))
+	require.NoError(t, err)
+	assert.Equal(t, lease.LeaseID(7), lid)
+	assert.True(t, ki.equal(got), "got %v, want %v", got, ki)
+
+	enc := encodeKeyIndex(ki, 7)
+	_, _, err = decodeKeyIndex(ki.key, enc[:len(enc)-1])
+	require.ErrorIs(t, err, errCorruptCheckpoint)
+}
+
+// checkpointLessor records the lease attachments.
+type checkpointLessor struct {
+	lease.FakeLessor
+	leases map[string]lease.LeaseID
+}
+
+func (le *checkpointLessor) Attach(id lease.LeaseID, items []lease.LeaseItem) error {
+	for _, it := range items {
+		le.leases[it.Key] = id
+	}
+	return nil
+}
+
+func (le *checkpointLessor) Detach(id lease.LeaseID, items []lease.LeaseItem) error {
+	for _, it := range items {
+		delete(le.leases, it.Key)
+	}
+	return nil
+}
+
+func (le *checkpointLessor) GetLease(item lease.LeaseItem) lease.LeaseID {
+	return le.leases[item.Key]
+}
+
+func TestRestoreFromIndexCheckpoint(t *testing.T) {
+	oldChunk := restoreChunkKeys
+	restoreChunkKeys = 3
+	defer func() { restoreChunkKeys = oldChunk }()
+
+	tests := []struct {
+		name string
+		// compact after the checkpoint, which makes it unusable.
+		compact        bool
+		wantCheckpoint bool
+	}{
+		{name: "checkpoint", wantCheckpoint: true},
+		{name: "compacted after checkpoint", compact: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := betesting.NewDefaultTmpBackend(t)
+			defer betesting.Close(t, b)
+			cfg := StoreConfig{IndexCheckpointInterval: time.Hour}
+
+			s := NewStore(zaptest.NewLogger(t), b, &checkpointLessor{leases: map[string]lease.LeaseID{}}, cfg)
+			for i := 0; i < 10; i++ {
+				s.Put([]byte(fmt.Sprintf("foo-%d", i)), []byte("bar"), lease.NoLease)
+			}
+			s.Put([]byte("leased"), []byte("bar"), 1)
+			s.DeleteRange([]byte("foo-1"), nil)
+			s.Put([]byte("foo-2"), []byte("baz"), lease.NoLease)
+			_, err := s.Compact(traceutil.TODO(), 3)
+			require.NoError(t, err)
+			require.NoError(t, s.checkpointIndex(s.stopc))
+			checkpointRev := s.Rev()
+
+			s.Put([]byte("foo-1"), []byte("bar"), lease.NoLease)
+			s.DeleteRange([]byte("foo-3"), nil)
+			s.DeleteRange([]byte("leased"), nil)
+			s.Put([]byte("leased-2"), []byte("bar"), 2)
+			s.Put([]byte("foo-4"), []byte("baz"), lease.NoLease)
+			if tt.compact {
+				ch, err := s.Compact(traceutil.TODO(), s.Rev()-1)
+				require.NoError(t, err)
+				<-ch
+			}
+			s.Commit()
+			require.NoError(t, s.Close())
+
+			tx := b.ReadTx()
+			tx.RLock()
+			_, vs := tx.UnsafeRange(schema.KeyIndex, checkpointRevKey, nil, 0)
+			compactRev, _ := UnsafeReadFinishedCompact(tx)
+			lg := zaptest.NewLogger(t)
+			_, _, ok := (&store{lg: lg, kvindex: newTreeIndex(lg)}).loadIndexCheckpoint(tx, compactRev)
+			tx.RUnlock()
+			require.Len(t, vs, 1)
+			assert.Equal(t, checkpointRev, BytesToRev(vs[0]).Main)
+			assert.Equal(t, tt.wantCheckpoint, ok)
+
+			fromCheckpoint := &checkpointLessor{leases: map[string]lease.LeaseID{}}
+			s = NewStore(zaptest.NewLogger(t), b, fromCheckpoint, cfg)
+			defer s.Close()
+			full := &checkpointLessor{leases: map[string]lease.LeaseID{}}
+			want := NewStore(zaptest.NewLogger(t), b, full, StoreConfig{})
+			defer want.Close()
+
+			assert.True(t, s.kvindex.Equal(want.kvindex), "index restored from checkpoint differs")
+			assert.Equal(t, want.Rev(), s.Rev())
+			assert.Equal(t, map[string]lease.LeaseID{"leased-2": 2}, fromCheckpoint.leases)
+			assert.Equal(t, full.leases, fromCheckpoint.leases)
+		})
+	}
+}
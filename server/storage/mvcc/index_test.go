@@ -139,6 +139,51 @@ func TestIndexTombstone(t *testing.T) {
 	}
 }
 
+func TestIndexStats(t *testing.T) {
+	ti := newTreeIndex(zaptest.NewLogger(t))
+	check := func(step string, wst indexStats) {
+		t.Helper()
+		if st := ti.Stats(); st != wst {
+			t.Errorf("%s: stats = %+v, want %+v", step, st, wst)
+		}
+		ti.Recount()
+		if st := ti.Stats(); st != wst {
+			t.Errorf("%s: recounted stats = %+v, want %+v", step, st, wst)
+		}
+	}
+
+	ti.Put([]byte("foo"), Revision{Main: 1})
+	ti.Tombstone([]byte("foo"), Revision{Main: 2})
+	ti.Put([]byte("foo"), Revision{Main: 3})
+	ti.Put([]byte("bar"), Revision{Main: 4})
+	check("put", indexStats{keys: 2, generations: 3, revisions: 4, maxRevisions: 3, depth: 1})
+
+	ti.Compact(3, nil)
+	check("compact", indexStats{keys: 2, generations: 2, revisions: 2, maxRevisions: 1, depth: 1})
+
+	ti.Tombstone([]byte("bar"), Revision{Main: 5})
+	ti.Compact(6, nil)
+	check("compact deleted", indexStats{keys: 1, generations: 1, revisions: 1, maxRevisions: 1, depth: 1})
+}
+
+func TestBtreeDepth(t *testing.T) {
+	tests := []struct {
+		n, wdepth int
+	}{
+		{0, 0},
+		{1, 1},
+		{31, 1},
+		{32, 2},
+		{1023, 2},
+		{1024, 3},
+	}
+	for i, tt := range tests {
+		if depth := btreeDepth(tt.n, 32); depth != tt.wdepth {
+			t.Errorf("#%d: depth of %d items = %d, want %d", i, tt.n, depth, tt.wdepth)
+		}
+	}
+}
+
 func TestIndexRevision(t *testing.T) {
 	allKeys := [][]byte{[]byte("foo"), []byte("foo1"), []byte("foo2"), []byte("foo2"), []byte("foo1"), []byte("foo")}
 	allRevs := []Revision{Revision{Main: 1}, Revision{Main: 2}, Revision{Main: 3}, Revision{Main: 4}, Revision{Main: 5}, Revision{Main: 6}}
@@ -265,8 +310,8 @@ func TestIndexCompactAndKeep(t *testing.T) {
 		}
 	}
 	for i := int64(1); i < maxRev; i++ {
-		am := ti.Compact(i)
-		keep := ti.Keep(i)
+		am := ti.Compact(i, nil)
+		keep := ti.Keep(i, nil)
 		if !(reflect.DeepEqual(am, keep)) {
 			t.Errorf("#%d: compact keep %v != Keep keep %v", i, am, keep)
 		}
@@ -297,8 +342,8 @@ func TestIndexCompactAndKeep(t *testing.T) {
 				ti.Put(tt.key, tt.rev)
 			}
 		}
-		am := ti.Compact(i)
-		keep := ti.Keep(i)
+		am := ti.Compact(i, nil)
+		keep := ti.Keep(i, nil)
 		if !(reflect.DeepEqual(am, keep)) {
 			t.Errorf("#%d: compact keep %v != Keep keep %v", i, am, keep)
 		}
@@ -16,6 +16,7 @@ package mvcc
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 
@@ -77,6 +78,20 @@ type keyIndex struct {
 	generations []generation
 }
 
+// snapshot returns a copy of ki that the later changes to ki leave untouched.
+// The copy shares the revisions of ki, which are only appended to or trimmed.
+func (ki *keyIndex) snapshot() *keyIndex {
+	gens := make([]generation, len(ki.generations))
+	for i, g := range ki.generations {
+		if g.packed != nil {
+			p := *g.packed
+			g.packed = &p
+		}
+		gens[i] = g
+	}
+	return &keyIndex{key: ki.key, modified: ki.modified, generations: gens}
+}
+
 // put puts a revision to the keyIndex.
 func (ki *keyIndex) put(lg *zap.Logger, main int64, sub int64) {
 	rev := Revision{Main: main, Sub: sub}
@@ -94,11 +109,11 @@ func (ki *keyIndex) put(lg *zap.Logger, main int64, sub int64) {
 		ki.generations = append(ki.generations, generation{})
 	}
 	g := &ki.generations[len(ki.generations)-1]
-	if len(g.revs) == 0 { // create a new key
+	if g.isEmpty() { // create a new key
 		keysGauge.Inc()
 		g.created = rev
 	}
-	g.revs = append(g.revs, rev)
+	g.append(rev)
 	g.ver++
 	ki.modified = rev
 }
@@ -152,7 +167,7 @@ func (ki *keyIndex) get(lg *zap.Logger, atRev int64) (modified, created Revision
 
 	n := g.walk(func(rev Revision) bool { return rev.Main > atRev })
 	if n != -1 {
-		return g.revs[n], g.created, g.ver - int64(len(g.revs)-n-1), nil
+		return g.at(n), g.created, g.ver - int64(g.len()-n-1), nil
 	}
 
 	return Revision{}, Revision{}, 0, ErrRevisionNotFound
@@ -184,7 +199,7 @@ func (ki *keyIndex) since(lg *zap.Logger, rev int64) []Revision {
 	var revs []Revision
 	var last int64
 	for ; gi < len(ki.generations); gi++ {
-		for _, r := range ki.generations[gi].revs {
+		for _, r := range ki.generations[gi].revisions() {
 			if since.GreaterThan(r) {
 				continue
 			}
@@ -219,11 +234,11 @@ func (ki *keyIndex) compact(lg *zap.Logger, atRev int64, available map[Revision]
 	if !g.isEmpty() {
 		// remove the previous contents.
 		if revIndex != -1 {
-			g.revs = g.revs[revIndex:]
+			g.trim(revIndex)
 		}
 		// remove any tombstone
-		if len(g.revs) == 1 && genIdx != len(ki.generations)-1 {
-			delete(available, g.revs[0])
+		if g.len() == 1 && genIdx != len(ki.generations)-1 {
+			delete(available, g.at(0))
 			genIdx++
 		}
 	}
@@ -242,8 +257,8 @@ func (ki *keyIndex) keep(atRev int64, available map[Revision]struct{}) {
 	g := &ki.generations[genIdx]
 	if !g.isEmpty() {
 		// remove any tombstone
-		if revIndex == len(g.revs)-1 && genIdx != len(ki.generations)-1 {
-			delete(available, g.revs[revIndex])
+		if revIndex == g.len()-1 && genIdx != len(ki.generations)-1 {
+			delete(available, g.at(revIndex))
 		}
 	}
 }
@@ -262,7 +277,7 @@ func (ki *keyIndex) doCompact(atRev int64, available map[Revision]struct{}) (gen
 	genIdx, g := 0, &ki.generations[0]
 	// find first generation includes atRev or created after atRev
 	for genIdx < len(ki.generations)-1 {
-		if tomb := g.revs[len(g.revs)-1].Main; tomb > atRev {
+		if tomb := g.last().Main; tomb > atRev {
 			break
 		}
 		genIdx++
@@ -278,6 +293,14 @@ func (ki *keyIndex) isEmpty() bool {
 	return len(ki.generations) == 1 && ki.generations[0].isEmpty()
 }
 
+// counts returns the number of generations and revisions of ki.
+func (ki *keyIndex) counts() (gens, revs int) {
+	for gi := range ki.generations {
+		revs += ki.generations[gi].len()
+	}
+	return len(ki.generations), revs
+}
+
 // findGeneration finds out the generation of the keyIndex that the
 // given rev belongs to. If the given rev is at the gap of two generations,
 // which means that the key does not exist at the given rev, it returns nil.
@@ -286,17 +309,17 @@ func (ki *keyIndex) findGeneration(rev int64) *generation {
 	cg := lastg
 
 	for cg >= 0 {
-		if len(ki.generations[cg].revs) == 0 {
+		if ki.generations[cg].isEmpty() {
 			cg--
 			continue
 		}
 		g := ki.generations[cg]
 		if cg != lastg {
-			if tomb := g.revs[len(g.revs)-1].Main; tomb <= rev {
+			if tomb := g.last().Main; tomb <= rev {
 				return nil
 			}
 		}
-		if g.revs[0].Main <= rev {
+		if g.first().Main <= rev {
 			return &ki.generations[cg]
 		}
 		cg--
@@ -336,14 +359,90 @@ func (ki *keyIndex) String() string {
 }
 
 // generation contains multiple revisions of a key.
+//
+// The revisions of a frequently updated key make up most of the memory of
+// the index, so once a generation holds 2*generationPackBatch revisions, its
+// oldest generationPackBatch revisions are packed as varint deltas, which
+// usually take a few bytes instead of the 16 bytes of a Revision. Only the
+// reads of the packed revisions, which are older than the latest
+// generationPackBatch revisions, decode them.
 type generation struct {
 	ver     int64
 	created Revision // when the generation is created (put in first revision).
-	revs    []Revision
+	// packed holds the oldest revisions, before revs, if any.
+	packed *packedRevisions
+	revs   []Revision
 }
 
+const generationPackBatch = 64
+
 func (g *generation) isEmpty() bool { return g == nil || len(g.revs) == 0 }
 
+// len returns the number of revisions of the generation.
+func (g *generation) len() int {
+	if g.packed == nil {
+		return len(g.revs)
+	}
+	return g.packed.n + len(g.revs)
+}
+
+// at returns the i-th revision of the generation.
+func (g *generation) at(i int) Revision {
+	if g.packed == nil {
+		return g.revs[i]
+	}
+	if i >= g.packed.n {
+		return g.revs[i-g.packed.n]
+	}
+	return g.packed.revisions()[i]
+}
+
+func (g *generation) first() Revision {
+	if g.packed == nil {
+		return g.revs[0]
+	}
+	return g.packed.first()
+}
+
+// last returns the last revision of the generation, which is never packed.
+func (g *generation) last() Revision { return g.revs[len(g.revs)-1] }
+
+func (g *generation) append(rev Revision) {
+	g.revs = append(g.revs, rev)
+	if len(g.revs) < 2*generationPackBatch {
+		return
+	}
+	if g.packed == nil {
+		g.packed = &packedRevisions{}
+	}
+	g.packed.append(g.revs[:generationPackBatch])
+	g.revs = append([]Revision(nil), g.revs[generationPackBatch:]...)
+}
+
+// trim removes the first n revisions of the generation.
+func (g *generation) trim(n int) {
+	if g.packed == nil {
+		g.revs = g.revs[n:]
+		return
+	}
+	if n >= g.packed.n {
+		g.revs = g.revs[n-g.packed.n:]
+		g.packed = nil
+		return
+	}
+	revs := g.packed.revisions()[n:]
+	g.packed = &packedRevisions{}
+	g.packed.append(revs)
+}
+
+// revisions returns the revisions of the generation in ascending order.
+func (g *generation) revisions() []Revision {
+	if g.packed == nil {
+		return g.revs
+	}
+	return append(g.packed.revisions(), g.revs...)
+}
+
 // walk walks through the revisions in the generation in descending order.
 // It passes the revision to the given function.
 // walk returns until: 1. it finishes walking all pairs 2. the function returns false.
@@ -353,6 +452,17 @@ func (g *generation) walk(f func(rev Revision) bool) int {
 	l := len(g.revs)
 	for i := range g.revs {
 		ok := f(g.revs[l-i-1])
+		if !ok {
+			return g.len() - i - 1
+		}
+	}
+	if g.packed == nil {
+		return -1
+	}
+	packed := g.packed.revisions()
+	l = len(packed)
+	for i := range packed {
+		ok := f(packed[l-i-1])
 		if !ok {
 			return l - i - 1
 		}
@@ -361,22 +471,61 @@ func (g *generation) walk(f func(rev Revision) bool) int {
 }
 
 func (g *generation) String() string {
-	return fmt.Sprintf("g: created[%d] ver[%d], revs %#v\n", g.created, g.ver, g.revs)
+	return fmt.Sprintf("g: created[%d] ver[%d], revs %#v\n", g.created, g.ver, g.revisions())
 }
 
 func (g generation) equal(b generation) bool {
 	if g.ver != b.ver {
 		return false
 	}
-	if len(g.revs) != len(b.revs) {
+	if g.len() != b.len() {
 		return false
 	}
 
-	for i := range g.revs {
-		ar, br := g.revs[i], b.revs[i]
-		if ar != br {
+	brevs := b.revisions()
+	for i, ar := range g.revisions() {
+		if ar != brevs[i] {
 			return false
 		}
 	}
 	return true
 }
+
+// packedRevisions holds ascending revisions as the uvarint delta of every
+// main revision from the previous one followed by the uvarint sub revision.
+type packedRevisions struct {
+	b []byte
+	// n is the number of revisions and lastMain the main revision of the
+	// last one, from which the next appended revision is delta encoded.
+	n        int
+	lastMain int64
+}
+
+func (p *packedRevisions) append(revs []Revision) {
+	for _, rev := range revs {
+		p.b = binary.AppendUvarint(p.b, uint64(rev.Main-p.lastMain))
+		p.b = binary.AppendUvarint(p.b, uint64(rev.Sub))
+		p.lastMain = rev.Main
+	}
+	p.n += len(revs)
+}
+
+func (p *packedRevisions) first() Revision {
+	main, n := binary.Uvarint(p.b)
+	sub, _ := binary.Uvarint(p.b[n:])
+	return Revision{Main: int64(main), Sub: int64(sub)}
+}
+
+func (p *packedRevisions) revisions() []Revision {
+	revs := make([]Revision, 0, p.n)
+	var main int64
+	for b := p.b; len(b) != 0; {
+		delta, n := binary.Uvarint(b)
+		b = b[n:]
+		sub, n := binary.Uvarint(b)
+		b = b[n:]
+		main += int64(delta)
+		revs = append(revs, Revision{Main: main, Sub: int64(sub)})
+	}
+	return revs
+}
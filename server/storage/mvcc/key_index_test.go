@@ -15,6 +15,7 @@
 package mvcc
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -530,12 +531,17 @@ func cloneKeyIndex(ki *keyIndex) *keyIndex {
 }
 
 func cloneGeneration(g *generation) *generation {
-	if g.revs == nil {
-		return &generation{g.ver, g.created, nil}
+	c := &generation{ver: g.ver, created: g.created}
+	if g.packed != nil {
+		packed := *g.packed
+		packed.b = append([]byte(nil), g.packed.b...)
+		c.packed = &packed
 	}
-	tmp := make([]Revision, len(g.revs))
-	copy(tmp, g.revs)
-	return &generation{g.ver, g.created, tmp}
+	if g.revs != nil {
+		c.revs = make([]Revision, len(g.revs))
+		copy(c.revs, g.revs)
+	}
+	return c
 }
 
 // TestKeyIndexCompactOnFurtherRev tests that compact on version that
@@ -708,3 +714,79 @@ func newTestKeyIndex(lg *zap.Logger) *keyIndex {
 	ki.tombstone(lg, 16, 0)
 	return ki
 }
+
+func TestKeyIndexPackedGeneration(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	ki := &keyIndex{key: []byte("foo")}
+	n := int64(10 * generationPackBatch)
+	for i := int64(1); i <= n; i++ {
+		ki.put(lg, i, i%3)
+	}
+	g := &ki.generations[0]
+	if g.packed == nil || g.len() != int(n) {
+		t.Fatalf("packed = %v, len = %d, want packed revisions and len %d", g.packed, g.len(), n)
+	}
+	if len(g.revs) >= 2*generationPackBatch {
+		t.Errorf("len(revs) = %d, want < %d", len(g.revs), 2*generationPackBatch)
+	}
+
+	for _, rev := range []int64{1, 2, generationPackBatch, n / 2, n - 1, n} {
+		mod, created, ver, err := ki.get(lg, rev)
+		if err != nil {
+			t.Fatalf("get(%d) error = %v", rev, err)
+		}
+		if wmod := (Revision{Main: rev, Sub: rev % 3}); mod != wmod || created != (Revision{Main: 1, Sub: 1}) || ver != rev {
+			t.Errorf("get(%d) = %v, %v, %d, want %v, {1 1}, %d", rev, mod, created, ver, wmod, rev)
+		}
+	}
+	if revs := ki.since(lg, n/2); len(revs) != int(n/2+1) || revs[0].Main != n/2 || revs[len(revs)-1].Main != n {
+		t.Errorf("since(%d) = %d revisions from %v, want %d from %d", n/2, len(revs), revs[0], n/2+1, n/2)
+	}
+
+	cki, _, err := decodeKeyIndex(ki.key, encodeKeyIndex(ki, 0))
+	if err != nil || !cki.equal(ki) {
+		t.Errorf("decoded key index = %v, %v, want %v", cki, err, ki)
+	}
+
+	am := make(map[Revision]struct{})
+	ki.compact(lg, n/3, am)
+	wrev := Revision{Main: n / 3, Sub: n / 3 % 3}
+	if _, ok := am[wrev]; !ok || len(am) != 1 {
+		t.Errorf("available = %v, want %v", am, wrev)
+	}
+	if g.len() != int(n-n/3+1) || g.first() != wrev {
+		t.Errorf("compacted len = %d, first = %v, want %d, %v", g.len(), g.first(), n-n/3+1, wrev)
+	}
+	if _, _, _, err = ki.get(lg, n/3-1); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("get(%d) error = %v, want %v", n/3-1, err, ErrRevisionNotFound)
+	}
+	if mod, _, ver, _ := ki.get(lg, n/2); mod.Main != n/2 || ver != n/2 {
+		t.Errorf("get(%d) after compaction = %v, %d, want %d, %d", n/2, mod, ver, n/2, n/2)
+	}
+}
+
+func TestKeyIndexSnapshot(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	ki := &keyIndex{key: []byte("foo")}
+	n := int64(3 * generationPackBatch)
+	for i := int64(1); i <= n; i++ {
+		ki.put(lg, i, 0)
+	}
+	want := encodeKeyIndex(ki, 0)
+	snap := ki.snapshot()
+
+	// packs more revisions, appends to the packed revisions and the
+	// generations, then trims them.
+	for i := n + 1; i <= 3*n; i++ {
+		ki.put(lg, i, 0)
+	}
+	if err := ki.tombstone(lg, 3*n+1, 0); err != nil {
+		t.Fatal(err)
+	}
+	ki.put(lg, 3*n+2, 0)
+	ki.compact(lg, 2*n, make(map[Revision]struct{}))
+
+	if got := encodeKeyIndex(snap, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot = %v, want it unchanged by the later changes to the key index", snap)
+	}
+}
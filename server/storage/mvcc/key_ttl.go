@@ -0,0 +1,209 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/pkg/v3/traceutil"
+	"go.etcd.io/etcd/server/v3/lease"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+var ErrKeyTTLDisabled = errors.New("mvcc: key TTLs are disabled")
+
+// The deadlines of the keys put with a TTL are stored in the schema.KeyTTL
+// bucket, in the same batch tx as the writes setting and clearing them:
+// keyDeadlinePrefix followed by the key maps to the 8 byte big endian
+// deadline in unix nanoseconds, and keyExpiryPrefix followed by the deadline
+// and the key orders the keys by deadline for the expirer.
+const (
+	keyDeadlinePrefix byte = 'k'
+	keyExpiryPrefix   byte = 'x'
+)
+
+// keyExpiryBatch is the number of expired keys deleted at once.
+var keyExpiryBatch = 1000
+
+func keyDeadlineKey(key []byte) []byte {
+	return append([]byte{keyDeadlinePrefix}, key...)
+}
+
+func keyExpiryKey(deadline int64, key []byte) []byte {
+	b := make([]byte, 9, 9+len(key))
+	b[0] = keyExpiryPrefix
+	binary.BigEndian.PutUint64(b[1:], uint64(deadline))
+	return append(b, key...)
+}
+
+func (s *store) keyTTLEnabled() bool { return s.cfg.KeyTTLCheckInterval > 0 }
+
+func (tw *storeTxnWrite) PutTTL(key, value []byte, ttl time.Duration) (int64, error) {
+	if !tw.s.keyTTLEnabled() {
+		return tw.beginRev, ErrKeyTTLDisabled
+	}
+	if err := tw.s.CheckPutSize(key, value); err != nil {
+		return tw.beginRev, err
+	}
+	tw.put(key, value, lease.NoLease)
+	if ttl > 0 {
+		deadline := time.Now().Add(ttl).UnixNano()
+		d := make([]byte, 8)
+		binary.BigEndian.PutUint64(d, uint64(deadline))
+		tw.tx.UnsafePut(schema.KeyTTL, keyDeadlineKey(key), d)
+		tw.tx.UnsafePut(schema.KeyTTL, keyExpiryKey(deadline, key), []byte{})
+	}
+	return tw.beginRev + 1, nil
+}
+
+// clearKeyDeadline removes the deadline of key, if any, when it is put or
+// deleted.
+func (tw *storeTxnWrite) clearKeyDeadline(key []byte) {
+	if !tw.s.keyTTLEnabled() {
+		return
+	}
+	dk := keyDeadlineKey(key)
+	_, vs := tw.tx.UnsafeRange(schema.KeyTTL, dk, nil, 0)
+	if len(vs) != 1 {
+		return
+	}
+	tw.tx.UnsafeDelete(schema.KeyTTL, dk)
+	tw.tx.UnsafeDelete(schema.KeyTTL, keyExpiryKey(int64(binary.BigEndian.Uint64(vs[0])), key))
+}
+
+func (s *store) createKeyTTLBucket() {
+	if !s.keyTTLEnabled() {
+		return
+	}
+	tx := s.b.BatchTx()
+	tx.LockOutsideApply()
+	tx.UnsafeCreateBucket(schema.KeyTTL)
+	tx.Unlock()
+}
+
+// setKeyExpiryDeleter sets the function opening the txns the expired keys
+// are deleted in, so that a watchable store notifies its watchers of the
+// deletions.
+func (s *store) setKeyExpiryDeleter(f func() TxnWrite) {
+	s.keyExpiryMu.Lock()
+	defer s.keyExpiryMu.Unlock()
+	s.keyExpiryDeleter = f
+}
+
+func (s *store) startKeyExpirer() {
+	if s.keyTTLEnabled() {
+		s.keyExpiryWg.Add(1)
+		go s.runKeyExpirer(s.stopc)
+	}
+}
+
+func (s *store) runKeyExpirer(stopc <-chan struct{}) {
+	defer s.keyExpiryWg.Done()
+	t := time.NewTicker(s.cfg.KeyTTLCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-stopc:
+			return
+		}
+		for more := true; more; {
+			more = s.expireKeys(stopc, time.Now())
+		}
+	}
+}
+
+// expiredKeys returns up to limit keys whose deadline is not after now.
+func (s *store) expiredKeys(now time.Time, limit int) [][]byte {
+	tx := s.b.ReadTx()
+	tx.RLock()
+	defer tx.RUnlock()
+	end := keyExpiryKey(now.UnixNano()+1, nil)
+	ks, _ := tx.UnsafeRange(schema.KeyTTL, []byte{keyExpiryPrefix}, end, int64(limit))
+	keys := make([][]byte, len(ks))
+	for i, k := range ks {
+		keys[i] = bytes.Clone(k[9:])
+	}
+	return keys
+}
+
+// expireKeys deletes the keys whose deadline is not after now, at most
+// keyExpiryBatch of them, or passes them to StoreConfig.KeyExpirer if set.
+// It returns whether more keys may have expired.
+func (s *store) expireKeys(stopc <-chan struct{}, now time.Time) bool {
+	keys := s.expiredKeys(now, keyExpiryBatch)
+	if len(keys) == 0 {
+		return false
+	}
+	if s.cfg.KeyExpirer != nil {
+		// the keys are expired again until KeyExpirer deletes them.
+		s.cfg.KeyExpirer(keys)
+		return false
+	}
+
+	s.keyExpiryMu.Lock()
+	deleter := s.keyExpiryDeleter
+	s.keyExpiryMu.Unlock()
+	tw := deleter()
+	defer tw.End()
+	select {
+	case <-stopc:
+		// the store was closed or restored from another backend.
+		return false
+	default:
+	}
+	deleted := 0
+	for _, key := range keys {
+		// the key may have been put again since it was found expired.
+		if d, ok := s.unsafeKeyDeadline(key); ok && d <= now.UnixNano() {
+			n, _ := tw.DeleteRange(key, nil)
+			if n == 0 {
+				// the key was already deleted, only its deadline is left.
+				s.unsafeClearKeyDeadline(key, d)
+			}
+			deleted++
+		}
+	}
+	s.lg.Debug("expired keys", zap.Int("keys", deleted))
+	return len(keys) == keyExpiryBatch
+}
+
+// unsafeKeyDeadline returns the deadline of key. It must be called in a
+// write txn.
+func (s *store) unsafeKeyDeadline(key []byte) (int64, bool) {
+	_, vs := s.b.BatchTx().UnsafeRange(schema.KeyTTL, keyDeadlineKey(key), nil, 0)
+	if len(vs) != 1 {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(vs[0])), true
+}
+
+func (s *store) unsafeClearKeyDeadline(key []byte, deadline int64) {
+	tx := s.b.BatchTx()
+	tx.UnsafeDelete(schema.KeyTTL, keyDeadlineKey(key))
+	tx.UnsafeDelete(schema.KeyTTL, keyExpiryKey(deadline, key))
+}
+
+// PutTTL puts key with a TTL in a write txn of its own.
+func (wv *writeView) PutTTL(key, value []byte, ttl time.Duration) (int64, error) {
+	tw := wv.kv.Write(traceutil.TODO())
+	defer tw.End()
+	return tw.PutTTL(key, value, ttl)
+}
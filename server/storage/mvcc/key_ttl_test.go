@@ -0,0 +1,127 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/lease"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+)
+
+func TestKeyTTLExpiresWatchedKey(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := newWatchableStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{KeyTTLCheckInterval: 10 * time.Millisecond})
+	defer cleanup(s, b)
+
+	w := s.NewWatchStream()
+	defer w.Close()
+	w.Watch(0, []byte("foo"), nil, 0)
+
+	if _, err := s.PutTTL([]byte("foo"), []byte("bar"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	var evs []mvccpb.Event
+	for len(evs) < 2 {
+		select {
+		case resp := <-w.Chan():
+			evs = append(evs, resp.Events...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got events %v, want the put and the expiry of foo", evs)
+		}
+	}
+	if evs[0].Type != mvccpb.PUT || evs[1].Type != mvccpb.DELETE || string(evs[1].Kv.Key) != "foo" {
+		t.Errorf("events = %v, want PUT and DELETE of foo", evs)
+	}
+	r, err := s.Range(context.TODO(), []byte("foo"), nil, RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.KVs) != 0 {
+		t.Errorf("range = %v, want the expired key deleted", r.KVs)
+	}
+}
+
+func TestKeyTTLClearedByPut(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{KeyTTLCheckInterval: time.Hour})
+	defer cleanup(s, b)
+
+	s.PutTTL([]byte("a"), []byte("v"), time.Minute)
+	s.PutTTL([]byte("b"), []byte("v"), time.Minute)
+	s.PutTTL([]byte("c"), []byte("v"), time.Minute)
+	s.Put([]byte("a"), []byte("v2"), lease.NoLease)
+	s.PutTTL([]byte("b"), []byte("v2"), 0)
+	s.DeleteRange([]byte("c"), nil)
+
+	if keys := s.expiredKeys(time.Now().Add(time.Hour), keyExpiryBatch); len(keys) != 0 {
+		t.Errorf("expired keys = %q, want none", keys)
+	}
+	s.expireKeys(s.stopc, time.Now().Add(time.Hour))
+	r, err := s.Range(context.TODO(), []byte("a"), []byte("c"), RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.KVs) != 2 {
+		t.Errorf("range = %v, want a and b", r.KVs)
+	}
+}
+
+func TestKeyTTLExpirer(t *testing.T) {
+	var expired [][]byte
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{
+		KeyTTLCheckInterval: time.Hour,
+		KeyExpirer:          func(keys [][]byte) { expired = append(expired, keys...) },
+	})
+	defer cleanup(s, b)
+
+	s.PutTTL([]byte("b"), []byte("v"), 2*time.Minute)
+	s.PutTTL([]byte("a"), []byte("v"), time.Minute)
+	s.PutTTL([]byte("c"), []byte("v"), time.Hour)
+
+	s.expireKeys(s.stopc, time.Now().Add(3*time.Minute))
+	if len(expired) != 2 || string(expired[0]) != "a" || string(expired[1]) != "b" {
+		t.Errorf("expired keys = %q, want [a b]", expired)
+	}
+	// the expirer deletes the keys itself.
+	r, err := s.Range(context.TODO(), []byte("a"), []byte("d"), RangeOptions{Count: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count != 3 {
+		t.Errorf("count = %d, want 3", r.Count)
+	}
+}
+
+func TestKeyTTLDisabled(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	defer cleanup(s, b)
+
+	if _, err := s.PutTTL([]byte("foo"), []byte("bar"), time.Minute); !errors.Is(err, ErrKeyTTLDisabled) {
+		t.Errorf("error = %v, want %v", err, ErrKeyTTLDisabled)
+	}
+	if rev := s.Rev(); rev != 1 {
+		t.Errorf("rev = %d, want 1", rev)
+	}
+}
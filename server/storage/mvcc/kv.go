@@ -16,6 +16,7 @@ package mvcc
 
 import (
 	"context"
+	"time"
 
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/pkg/v3/traceutil"
@@ -27,6 +28,11 @@ type RangeOptions struct {
 	Limit int64
 	Rev   int64
 	Count bool
+	// ApproxCount lets a Count of every key or of the keys with a prefix
+	// ending with '/' be read from the prefix counters of the store in O(1),
+	// see StoreConfig.PrefixCountDepth. The counters include the writes of
+	// the txns in progress. Other ranges are counted from the index.
+	ApproxCount bool
 }
 
 type RangeResult struct {
@@ -35,6 +41,16 @@ type RangeResult struct {
 	Count int
 }
 
+type HistoryResult struct {
+	// Events are the changes of the key, oldest first. The ModRevision of a
+	// DELETE event is the revision of the deletion.
+	Events []mvccpb.Event
+	// More is set if the limit left out later changes.
+	More bool
+	// Rev is the current revision of the KV when the operation is executed.
+	Rev int64
+}
+
 type ReadView interface {
 	// FirstRev returns the first KV revision at the time of opening the txn.
 	// After a compaction, the first revision increases to the compaction
@@ -53,6 +69,36 @@ type ReadView interface {
 	// Limit limits the number of keys returned.
 	// If the required rev is compacted, ErrCompacted will be returned.
 	Range(ctx context.Context, key, end []byte, ro RangeOptions) (r *RangeResult, err error)
+
+	// RangeStream calls fn with the keys in the range at ro.Rev, in the order
+	// of the keys, as Range gets them. The keys are read from the index and
+	// the backend in chunks, so that ranges of any size take bounded memory.
+	// Limit limits the number of keys streamed, Count is ignored. RangeStream
+	// stops at the first error returned by fn and returns it. The range is
+	// read in a single txn, which blocks compactions until fn returns for the
+	// last key.
+	RangeStream(ctx context.Context, key, end []byte, ro RangeOptions, fn func(kv mvccpb.KeyValue) error) error
+
+	// History gets the changes of the key with revisions in [startRev, endRev).
+	// If startRev <= 0, history starts at the first revision.
+	// If endRev <= 0, history ends at the current revision.
+	// Limit limits the number of changes returned, there is no limit if limit <= 0.
+	// If the required startRev is compacted, ErrCompacted will be returned,
+	// unless the revision of the key at startRev was retained by the
+	// RetentionPolicies of the store.
+	History(key []byte, startRev, endRev int64, limit int) (*HistoryResult, error)
+
+	// RangeByIndex gets the keys indexed under term by the secondary index,
+	// in the order of the keys. Only the current revision is served, ro.Rev
+	// must be <= 0 or the current revision. Limit limits the number of keys
+	// returned. If the index is not registered, ErrUnknownIndex will be
+	// returned.
+	RangeByIndex(ctx context.Context, index string, term []byte, ro RangeOptions) (*RangeResult, error)
+
+	// RangeByLease gets the keys attached to the lease, in the order of the
+	// keys. Only the current revision is served, ro.Rev must be <= 0 or the
+	// current revision. Limit limits the number of keys returned.
+	RangeByLease(ctx context.Context, id lease.LeaseID, ro RangeOptions) (*RangeResult, error)
 }
 
 // TxnRead represents a read-only transaction with operations that will not
@@ -78,15 +124,41 @@ type WriteView interface {
 	// id.
 	// A put also increases the rev of the store, and generates one event in the event history.
 	// The returned rev is the current revision of the KV when the operation is executed.
+	// The size limits of the store are not enforced; callers check them with
+	// KV.CheckPutSize beforehand.
 	Put(key, value []byte, lease lease.LeaseID) (rev int64)
+
+	// PutTTL puts the given key, value into the store as Put does without
+	// a lease, and deletes the key once ttl has passed unless the key is put
+	// or deleted before. A ttl <= 0 puts the key without a TTL. If key TTLs
+	// are disabled, ErrKeyTTLDisabled will be returned. The size limits of
+	// Put apply.
+	PutTTL(key, value []byte, ttl time.Duration) (rev int64, err error)
+
+	// Undelete puts the deleted key back with its value at atRev, without a
+	// lease, as a new revision. If atRev <= 0, the value before the last
+	// deletion of the key is put back. If the key exists,
+	// ErrKeyNotDeleted will be returned; if the key did not exist at atRev,
+	// ErrRevisionNotFound; if atRev is compacted, ErrCompacted. The size
+	// limits of Put apply.
+	Undelete(key []byte, atRev int64) (rev int64, err error)
 }
 
 // TxnWrite represents a transaction that can modify the store.
+// Its reads observe its own writes: once the txn has changes, reads at the
+// current revision are served at the revision the txn writes to, from the
+// key index and the backend batch tx the changes were applied to.
 type TxnWrite interface {
 	TxnRead
 	WriteView
 	// Changes gets the changes made since opening the write txn.
 	Changes() []mvccpb.KeyValue
+	// PutBatch puts the Key, Value and Lease of every kvs in order, as Put
+	// does, and returns the revision of the txn. Batches larger than the
+	// size limit of the store are rejected as a whole with
+	// ErrPutBatchTooLarge, as are batches with a key or a value exceeding
+	// the size limits of Put.
+	PutBatch(kvs []mvccpb.KeyValue) (rev int64, err error)
 }
 
 // txnReadWrite coerces a read txn to a write, panicking on any write operation.
@@ -96,7 +168,16 @@ func (trw *txnReadWrite) DeleteRange(key, end []byte) (n, rev int64) { panic("un
 func (trw *txnReadWrite) Put(key, value []byte, lease lease.LeaseID) (rev int64) {
 	panic("unexpected Put")
 }
+func (trw *txnReadWrite) PutTTL(key, value []byte, ttl time.Duration) (rev int64, err error) {
+	panic("unexpected PutTTL")
+}
+func (trw *txnReadWrite) Undelete(key []byte, atRev int64) (rev int64, err error) {
+	panic("unexpected Undelete")
+}
 func (trw *txnReadWrite) Changes() []mvccpb.KeyValue { return nil }
+func (trw *txnReadWrite) PutBatch(kvs []mvccpb.KeyValue) (rev int64, err error) {
+	panic("unexpected PutBatch")
+}
 
 func NewReadOnlyTxnWrite(txn TxnRead) TxnWrite { return &txnReadWrite{txn} }
 
@@ -119,12 +200,42 @@ type KV interface {
 	// Write creates a write transaction.
 	Write(trace *traceutil.Trace) TxnWrite
 
+	// At creates a read transaction pinned to rev, or to the current revision
+	// if rev <= 0, that stays open across later writes and compactions
+	// without blocking them, until End is called. Compactions retain the
+	// revisions of the keys at rev for it, and its reads are served from the
+	// backend as of its creation, so that it suits long scans and backups.
+	// Only rev is served, reads at earlier revisions return ErrCompacted.
+	// If rev is compacted, ErrCompacted will be returned. The txn must be
+	// ended before the KV is restored or closed.
+	At(rev int64) (TxnRead, error)
+
 	// HashStorage returns HashStorage interface for KV storage.
 	HashStorage() HashStorage
 
 	// Compact frees all superseded keys with revisions less than rev.
 	Compact(trace *traceutil.Trace, rev int64) (<-chan struct{}, error)
 
+	// CompactStatus returns the progress of the running or last compaction.
+	CompactStatus() CompactionStatus
+
+	// CompactionEstimate estimates the revisions a compaction at rev would
+	// remove and the backend space it would reclaim.
+	CompactionEstimate(rev int64) (CompactionEstimate, error)
+
+	// PrefixStats returns the counters of the puts and the deletions of the
+	// keys with prefix, as of the last ended write txn, so that changes
+	// under the prefix since a revision are detected without a range. If
+	// the prefix is not registered in StoreConfig.StatsPrefixes,
+	// ErrUnknownPrefix will be returned.
+	PrefixStats(prefix []byte) (PrefixStats, error)
+
+	// CheckPutSize returns ErrKeyTooLarge or ErrValueTooLarge if a put of
+	// key and value would exceed StoreConfig.MaxKeyBytes or
+	// StoreConfig.MaxValueBytes, so that a put is refused before a write
+	// txn starts.
+	CheckPutSize(key, value []byte) error
+
 	// Commit commits outstanding txns into the underlying backend.
 	Commit()
 
@@ -143,5 +254,5 @@ type WatchableKV interface {
 type Watchable interface {
 	// NewWatchStream returns a WatchStream that can be used to
 	// watch events happened or happening on the KV.
-	NewWatchStream() WatchStream
+	NewWatchStream(opts ...WatchStreamOption) WatchStream
 }
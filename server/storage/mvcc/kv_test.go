@@ -16,6 +16,7 @@ package mvcc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
@@ -209,6 +210,66 @@ func testKVRangeBadRev(t *testing.T, f rangeFunc) {
 	}
 }
 
+func TestKVHistory(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	defer cleanup(s, b)
+
+	s.Put([]byte("foo"), []byte("bar"), lease.NoLease)  // 2
+	s.Put([]byte("foo1"), []byte("bar"), lease.NoLease) // 3
+	s.Put([]byte("foo"), []byte("bar1"), lease.NoLease) // 4
+	s.DeleteRange([]byte("foo"), nil)                   // 5
+	s.Put([]byte("foo"), []byte("bar2"), lease.NoLease) // 6
+
+	put := func(value string, create, mod, ver int64) mvccpb.Event {
+		return mvccpb.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte("foo"), Value: []byte(value), CreateRevision: create, ModRevision: mod, Version: ver}}
+	}
+	del := mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte("foo"), ModRevision: 5}}
+	all := []mvccpb.Event{put("bar", 2, 2, 1), put("bar1", 2, 4, 2), del, put("bar2", 6, 6, 1)}
+
+	tests := []struct {
+		startRev, endRev int64
+		limit            int
+		wevs             []mvccpb.Event
+		wmore            bool
+	}{
+		{0, 0, 0, all, false},
+		{3, 0, 0, all[1:], false},
+		{0, 5, 0, all[:2], false},
+		{0, 0, 3, all[:3], true},
+		{5, 6, 1, all[2:3], false},
+		{7, 0, 0, nil, false},
+	}
+	for i, tt := range tests {
+		r, err := s.History([]byte("foo"), tt.startRev, tt.endRev, tt.limit)
+		if tt.startRev > 6 {
+			if err != ErrFutureRev {
+				t.Errorf("#%d: error = %v, want %v", i, err, ErrFutureRev)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d: error = %v", i, err)
+		}
+		if !reflect.DeepEqual(r.Events, tt.wevs) {
+			t.Errorf("#%d: events = %+v, want %+v", i, r.Events, tt.wevs)
+		}
+		if r.More != tt.wmore {
+			t.Errorf("#%d: more = %v, want %v", i, r.More, tt.wmore)
+		}
+		if r.Rev != 6 {
+			t.Errorf("#%d: rev = %d, want 6", i, r.Rev)
+		}
+	}
+
+	if _, err := s.Compact(traceutil.TODO(), 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.History([]byte("foo"), 3, 0, 0); err != ErrCompacted {
+		t.Errorf("error = %v, want %v", err, ErrCompacted)
+	}
+}
+
 func TestKVRangeLimit(t *testing.T)    { testKVRangeLimit(t, normalRangeFunc) }
 func TestKVTxnRangeLimit(t *testing.T) { testKVRangeLimit(t, txnRangeFunc) }
 
@@ -549,6 +610,208 @@ func TestKVTxnOperationInSequence(t *testing.T) {
 	}
 }
 
+// TestKVTxnReadsOwnWrites tests that the reads of a write txn observe the
+// writes of the txn on several keys, before the txn ends.
+func TestKVTxnReadsOwnWrites(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	defer cleanup(s, b)
+
+	s.Put([]byte("foo"), []byte("bar"), lease.NoLease)
+	s.Put([]byte("foo1"), []byte("bar"), lease.NoLease)
+
+	txn := s.Write(traceutil.TODO())
+	defer txn.End()
+	txn.Put([]byte("foo2"), []byte("bar2"), lease.NoLease)
+	txn.Put([]byte("foo"), []byte("bar1"), lease.NoLease)
+	txn.DeleteRange([]byte("foo1"), nil)
+
+	r, err := txn.Range(context.TODO(), []byte("foo"), []byte("fop"), RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wkvs := []mvccpb.KeyValue{
+		{Key: []byte("foo"), Value: []byte("bar1"), CreateRevision: 2, ModRevision: 4, Version: 2},
+		{Key: []byte("foo2"), Value: []byte("bar2"), CreateRevision: 4, ModRevision: 4, Version: 1},
+	}
+	if !reflect.DeepEqual(r.KVs, wkvs) || r.Count != 2 || r.Rev != 4 {
+		t.Errorf("range = %+v, want kvs %+v, count 2 at rev 4", r, wkvs)
+	}
+	r, err = txn.Range(context.TODO(), []byte("foo"), []byte("fop"), RangeOptions{Count: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count != 2 {
+		t.Errorf("count = %d, want 2", r.Count)
+	}
+	// the revision before the txn is still readable.
+	r, err = txn.Range(context.TODO(), []byte("foo"), []byte("fop"), RangeOptions{Rev: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.KVs) != 2 || string(r.KVs[1].Key) != "foo1" {
+		t.Errorf("range at rev 3 = %+v, want foo and foo1", r.KVs)
+	}
+}
+
+func TestKVRangeStream(t *testing.T) {
+	defer func(n int) { rangeStreamChunkKeys = n }(rangeStreamChunkKeys)
+	rangeStreamChunkKeys = 2
+
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	defer cleanup(s, b)
+
+	kvs := put3TestKVs(s)
+	s.Put([]byte("foo3"), []byte("bar3"), 4)
+	s.Put([]byte("foo4"), []byte("bar4"), 5)
+	r, err := s.Range(context.TODO(), []byte("foo"), []byte("fop"), RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := r.KVs
+
+	errStop := errors.New("stop")
+	tests := []struct {
+		key, end []byte
+		ro       RangeOptions
+		stopAt   int
+		wkvs     []mvccpb.KeyValue
+		werr     error
+	}{
+		{[]byte("foo"), []byte("fop"), RangeOptions{}, -1, all, nil},
+		{[]byte("foo"), []byte{}, RangeOptions{}, -1, all, nil},
+		{[]byte("foo1"), nil, RangeOptions{}, -1, all[1:2], nil},
+		{[]byte("foo"), []byte("fop"), RangeOptions{Limit: 3}, -1, all[:3], nil},
+		{[]byte("foo"), []byte("fop"), RangeOptions{Rev: 4}, -1, kvs, nil},
+		{[]byte("foo"), []byte("fop"), RangeOptions{}, 2, all[:3], errStop},
+		{[]byte("foo"), []byte("fop"), RangeOptions{Rev: 7}, -1, nil, ErrFutureRev},
+	}
+	for i, tt := range tests {
+		var got []mvccpb.KeyValue
+		err := s.RangeStream(context.TODO(), tt.key, tt.end, tt.ro, func(kv mvccpb.KeyValue) error {
+			got = append(got, kv)
+			if len(got)-1 == tt.stopAt {
+				return errStop
+			}
+			return nil
+		})
+		if !errors.Is(err, tt.werr) {
+			t.Errorf("#%d: error = %v, want %v", i, err, tt.werr)
+		}
+		if !reflect.DeepEqual(got, tt.wkvs) {
+			t.Errorf("#%d: kvs = %+v, want %+v", i, got, tt.wkvs)
+		}
+	}
+
+	// a write txn streams its own writes.
+	txn := s.Write(traceutil.TODO())
+	defer txn.End()
+	txn.DeleteRange([]byte("foo1"), nil)
+	var keys []string
+	if err = txn.RangeStream(context.TODO(), []byte("foo"), []byte("fop"), RangeOptions{}, func(kv mvccpb.KeyValue) error {
+		keys = append(keys, string(kv.Key))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if wkeys := []string{"foo", "foo2", "foo3", "foo4"}; !reflect.DeepEqual(keys, wkeys) {
+		t.Errorf("keys in txn = %v, want %v", keys, wkeys)
+	}
+}
+
+func TestKVPutBatch(t *testing.T) {
+	newStore := func(cfg StoreConfig) *store {
+		b, _ := betesting.NewDefaultTmpBackend(t)
+		s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, cfg)
+		t.Cleanup(func() { cleanup(s, b) })
+		s.Put([]byte("foo"), []byte("bar"), lease.NoLease)
+		return s
+	}
+	batch := []mvccpb.KeyValue{
+		{Key: []byte("foo"), Value: []byte("bar1")},
+		{Key: []byte("foo1"), Value: []byte("bar1"), Lease: 1},
+		{Key: []byte("foo"), Value: []byte("bar2"), Lease: 2},
+	}
+
+	// a batch puts the same as its puts in turn.
+	s0, s1 := newStore(StoreConfig{}), newStore(StoreConfig{})
+	txn := s0.Write(traceutil.TODO())
+	rev, err := txn.PutBatch(batch)
+	if err != nil || rev != 3 {
+		t.Fatalf("put batch = %d, %v, want 3, nil", rev, err)
+	}
+	changes := txn.Changes()
+	txn.End()
+	txn = s1.Write(traceutil.TODO())
+	for _, kv := range batch {
+		txn.Put(kv.Key, kv.Value, lease.LeaseID(kv.Lease))
+	}
+	wchanges := txn.Changes()
+	txn.End()
+	if !reflect.DeepEqual(changes, wchanges) {
+		t.Errorf("changes = %+v, want %+v", changes, wchanges)
+	}
+	r0, _ := s0.Range(context.TODO(), []byte("foo"), []byte("fop"), RangeOptions{})
+	r1, _ := s1.Range(context.TODO(), []byte("foo"), []byte("fop"), RangeOptions{})
+	if !reflect.DeepEqual(r0, r1) {
+		t.Errorf("range = %+v, want %+v", r0, r1)
+	}
+
+	s2 := newStore(StoreConfig{MaxPutBatchBytes: 16})
+	txn = s2.Write(traceutil.TODO())
+	if _, err = txn.PutBatch(batch); !errors.Is(err, ErrPutBatchTooLarge) {
+		t.Errorf("error = %v, want %v", err, ErrPutBatchTooLarge)
+	}
+	if _, err = txn.PutBatch(batch[:1]); err != nil {
+		t.Errorf("error = %v, want nil", err)
+	}
+	txn.End()
+	if r, _ := s2.Range(context.TODO(), []byte("foo"), []byte("fop"), RangeOptions{}); r.Rev != 3 || len(r.KVs) != 1 || string(r.KVs[0].Value) != "bar1" {
+		t.Errorf("range = %+v, want only the batch within the limit put", r)
+	}
+}
+
+func TestKVPutSizeLimits(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{MaxKeyBytes: 3, MaxValueBytes: 4})
+	defer cleanup(s, b)
+
+	tests := []struct {
+		key, value string
+		werr       error
+	}{
+		{"foo", "bar", nil},
+		{"fooo", "bar", ErrKeyTooLarge},
+		{"foo", "barrr", ErrValueTooLarge},
+		{"foo", "barr", nil},
+	}
+	for i, tt := range tests {
+		if err := s.CheckPutSize([]byte(tt.key), []byte(tt.value)); !errors.Is(err, tt.werr) {
+			t.Errorf("#%d: error = %v, want %v", i, err, tt.werr)
+		}
+	}
+	s.Put([]byte("foo"), []byte("barr"), lease.NoLease)
+
+	// a batch with a value exceeding the limit is rejected as a whole.
+	txn := s.Write(traceutil.TODO())
+	_, err := txn.PutBatch([]mvccpb.KeyValue{
+		{Key: []byte("bar"), Value: []byte("v")},
+		{Key: []byte("baz"), Value: []byte("toolarge")},
+	})
+	txn.End()
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("put batch error = %v, want %v", err, ErrValueTooLarge)
+	}
+	r, err := s.Range(context.TODO(), []byte("a"), []byte("z"), RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rev != 2 || len(r.KVs) != 1 || string(r.KVs[0].Value) != "barr" {
+		t.Errorf("range = %+v, want only foo put at 2", r)
+	}
+}
+
 func TestKVCompactReserveLastValue(t *testing.T) {
 	b, _ := betesting.NewDefaultTmpBackend(t)
 	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
@@ -17,6 +17,7 @@ package mvcc
 import (
 	"context"
 
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/pkg/v3/traceutil"
 	"go.etcd.io/etcd/server/v3/lease"
 )
@@ -41,6 +42,30 @@ func (rv *readView) Range(ctx context.Context, key, end []byte, ro RangeOptions)
 	return tr.Range(ctx, key, end, ro)
 }
 
+func (rv *readView) RangeStream(ctx context.Context, key, end []byte, ro RangeOptions, fn func(kv mvccpb.KeyValue) error) error {
+	tr := rv.kv.Read(ConcurrentReadTxMode, traceutil.TODO())
+	defer tr.End()
+	return tr.RangeStream(ctx, key, end, ro, fn)
+}
+
+func (rv *readView) History(key []byte, startRev, endRev int64, limit int) (*HistoryResult, error) {
+	tr := rv.kv.Read(ConcurrentReadTxMode, traceutil.TODO())
+	defer tr.End()
+	return tr.History(key, startRev, endRev, limit)
+}
+
+func (rv *readView) RangeByIndex(ctx context.Context, index string, term []byte, ro RangeOptions) (*RangeResult, error) {
+	tr := rv.kv.Read(ConcurrentReadTxMode, traceutil.TODO())
+	defer tr.End()
+	return tr.RangeByIndex(ctx, index, term, ro)
+}
+
+func (rv *readView) RangeByLease(ctx context.Context, id lease.LeaseID, ro RangeOptions) (*RangeResult, error) {
+	tr := rv.kv.Read(ConcurrentReadTxMode, traceutil.TODO())
+	defer tr.End()
+	return tr.RangeByLease(ctx, id, ro)
+}
+
 type writeView struct{ kv KV }
 
 func (wv *writeView) DeleteRange(key, end []byte) (n, rev int64) {
@@ -36,6 +36,14 @@ import (
 var (
 	ErrCompacted = errors.New("mvcc: required revision has been compacted")
 	ErrFutureRev = errors.New("mvcc: required revision is a future revision")
+	// ErrPutBatchTooLarge is returned by PutBatch for batches larger than
+	// StoreConfig.MaxPutBatchBytes.
+	ErrPutBatchTooLarge = errors.New("mvcc: put batch exceeds the size limit")
+	// ErrKeyTooLarge and ErrValueTooLarge are returned by the puts of keys
+	// and values larger than StoreConfig.MaxKeyBytes and
+	// StoreConfig.MaxValueBytes.
+	ErrKeyTooLarge   = errors.New("mvcc: key exceeds the size limit")
+	ErrValueTooLarge = errors.New("mvcc: value exceeds the size limit")
 )
 
 var restoreChunkKeys = 10000 // non-const for testing
@@ -45,6 +53,72 @@ var minimumBatchInterval = 10 * time.Millisecond
 type StoreConfig struct {
 	CompactionBatchLimit    int
 	CompactionSleepInterval time.Duration
+	// CompactionKeysPerSecond and CompactionBytesPerSecond limit the rate
+	// at which compaction scans the revisions and their values, by pausing
+	// longer than CompactionSleepInterval between batches. Zero means no
+	// limit.
+	CompactionKeysPerSecond  int
+	CompactionBytesPerSecond int
+	// CompactionBackoffLatency makes compaction back off, doubling the
+	// pause between batches up to maxCompactionBackoff, while committing a
+	// batch takes longer, which hints that foreground traffic contends for
+	// the backend. Zero disables backing off.
+	CompactionBackoffLatency time.Duration
+	// IndexCheckpointInterval is the interval between checkpoints of the key
+	// index into the backend, which let the store load the checkpoint and
+	// replay only the later revisions when it is restored instead of
+	// scanning every revision. Zero disables checkpoints.
+	IndexCheckpointInterval time.Duration
+	// PrefixCountDepth is the number of '/' delimited levels of the key
+	// prefixes whose live keys are counted for RangeOptions.ApproxCount.
+	// Zero disables the counters.
+	PrefixCountDepth int
+	// SecondaryIndexes are the secondary indexes maintained by the store,
+	// with distinct names. The indexes registered after keys were written
+	// are built when the store is created or restored, and the entries of
+	// the indexes no longer registered are removed.
+	SecondaryIndexes []SecondaryIndex
+	// RetentionPolicies retain revisions of keys that compactions would
+	// otherwise remove.
+	RetentionPolicies []RetentionPolicy
+	// MaxPutBatchBytes limits the size of the keys and values of a
+	// PutBatch. Zero means no limit.
+	MaxPutBatchBytes int
+	// MaxKeyBytes and MaxValueBytes limit the size of the keys and the
+	// values put. Zero means no limit.
+	MaxKeyBytes   int
+	MaxValueBytes int
+	// LeaseIndex maintains an index of the keys attached to each lease in
+	// the backend, serving RangeByLease. It is checked against the restored
+	// keys and brought up to date when the store is restored.
+	LeaseIndex bool
+	// KeyTTLCheckInterval is the interval at which the keys put with a TTL
+	// are checked for expiry and deleted once expired. Zero disables key
+	// TTLs.
+	KeyTTLCheckInterval time.Duration
+	// KeyExpirer, if set, is passed the expired keys instead of the store
+	// deleting them, e.g. to delete them through consensus, as the
+	// deadlines of the keys are from the clock of each member. The keys are
+	// passed again at every check until they are deleted or put again.
+	KeyExpirer func(keys [][]byte)
+	// StatsPrefixes are the prefixes whose keys' puts and deletions are
+	// counted in the write txns, see KV.PrefixStats. The empty prefix
+	// counts the writes of every key.
+	StatsPrefixes [][]byte
+	// ConsistentIndexer, if set, is called in every write txn to save the
+	// index of the entries applied by the embedder of the store with the
+	// changes of the txn.
+	ConsistentIndexer ConsistentIndexer
+}
+
+// ConsistentIndexer saves the index of the entries of a replicated log applied
+// to the store, atomically with the changes applying them, so that each entry
+// is applied exactly once after a restart.
+type ConsistentIndexer interface {
+	// UnsafeSave saves the index of the applied entries to tx. It is called
+	// holding the lock on tx at the end of every write txn, before the txn
+	// may be committed to the backend.
+	UnsafeSave(tx backend.UnsafeReadWriter)
 }
 
 type store struct {
@@ -58,6 +132,8 @@ type store struct {
 
 	b       backend.Backend
 	kvindex index
+	// prefixCounts counts the live keys by prefix, nil if disabled.
+	prefixCounts *prefixCounter
 
 	le lease.Lessor
 
@@ -70,9 +146,30 @@ type store struct {
 	// compactMainRev is the main revision of the last compaction.
 	compactMainRev int64
 
+	// compactStatusMu protects compactStatus.
+	compactStatusMu sync.Mutex
+	compactStatus   CompactionStatus
+
+	// revisionTimeSampled is the time the wall time of a revision was last
+	// sampled at. Protected by the batch tx lock.
+	revisionTimeSampled time.Time
+
 	fifoSched schedule.Scheduler
 
 	stopc chan struct{}
+	// checkpointWg waits for the key index checkpoints to stop.
+	checkpointWg sync.WaitGroup
+
+	// pinMu protects pins, the number of open txns of At by revision.
+	pinMu sync.Mutex
+	pins  map[int64]int
+
+	// keyExpiryMu protects keyExpiryDeleter, which opens the txns deleting
+	// the expired keys.
+	keyExpiryMu      sync.Mutex
+	keyExpiryDeleter func() TxnWrite
+	// keyExpiryWg waits for the key expirer to stop.
+	keyExpiryWg sync.WaitGroup
 
 	lg     *zap.Logger
 	hashes HashStorage
@@ -106,12 +203,16 @@ func NewStore(lg *zap.Logger, b backend.Backend, le lease.Lessor, cfg StoreConfi
 
 		lg: lg,
 	}
+	if cfg.PrefixCountDepth > 0 {
+		s.prefixCounts = newPrefixCounter(cfg.PrefixCountDepth)
+	}
 	s.hashes = newHashStorage(lg, s)
 	s.ReadView = &readView{s}
 	s.WriteView = &writeView{s}
 	if s.le != nil {
 		s.le.SetRangeDeleter(func() lease.TxnDelete { return s.Write(traceutil.TODO()) })
 	}
+	s.keyExpiryDeleter = func() TxnWrite { return s.Write(traceutil.TODO()) }
 
 	tx := s.b.BatchTx()
 	tx.LockOutsideApply()
@@ -126,10 +227,19 @@ func NewStore(lg *zap.Logger, b backend.Backend, le lease.Lessor, cfg StoreConfi
 		// TODO: return the error instead of panic here?
 		panic("failed to recover store from backend")
 	}
+	s.startIndexCheckpoints()
+	s.startKeyExpirer()
 
 	return s
 }
 
+func (s *store) startIndexCheckpoints() {
+	if s.cfg.IndexCheckpointInterval > 0 {
+		s.checkpointWg.Add(1)
+		go s.runIndexCheckpoints(s.stopc)
+	}
+}
+
 func (s *store) compactBarrier(ctx context.Context, ch chan struct{}) {
 	if ctx == nil || ctx.Err() != nil {
 		select {
@@ -179,17 +289,62 @@ func (s *store) hashByRev(rev int64) (hash KeyValueHash, currentRev int64, err e
 	if rev == 0 {
 		rev = currentRev
 	}
-	keep := s.kvindex.Keep(rev)
-
 	tx := s.b.ReadTx()
 	tx.RLock()
 	defer tx.RUnlock()
+	keep := s.kvindex.Keep(rev, s.unsafeRetention(tx, time.Now()))
 	s.mu.RUnlock()
 	hash, err = unsafeHashByRev(tx, compactRev, rev, keep)
 	hashRevSec.Observe(time.Since(start).Seconds())
 	return hash, currentRev, err
 }
 
+func (s *store) hashByRange(key, end []byte, rev int64) (hash KeyValueHash, currentRev int64, err error) {
+	start := time.Now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.revMu.RLock()
+	compactRev, currentRev := s.compactMainRev, s.currentRev
+	s.revMu.RUnlock()
+
+	if rev > 0 && rev < compactRev {
+		return KeyValueHash{}, 0, ErrCompacted
+	} else if rev > 0 && rev > currentRev {
+		return KeyValueHash{}, currentRev, ErrFutureRev
+	}
+	if rev == 0 {
+		rev = currentRev
+	}
+
+	tx := s.b.ReadTx()
+	tx.RLock()
+	defer tx.RUnlock()
+	h := newKVHasher(compactRev, rev, nil)
+	revBytes := NewRevBytes()
+	for {
+		keys, revs := s.kvindex.RangeLimit(key, end, rev, rangeStreamChunkKeys)
+		for _, r := range revs {
+			revBytes = RevToBytes(r, revBytes)
+			ks, vs := tx.UnsafeRange(schema.Key, revBytes, nil, 0)
+			if len(vs) != 1 {
+				s.lg.Fatal(
+					"hash by range failed to find revision pair",
+					zap.Int64("revision-main", r.Main),
+					zap.Int64("revision-sub", r.Sub),
+				)
+			}
+			h.WriteKeyValue(ks[0], vs[0])
+		}
+		if end == nil || len(revs) < rangeStreamChunkKeys {
+			break
+		}
+		key = append(append([]byte{}, keys[len(keys)-1]...), 0)
+	}
+	hashRevSec.Observe(time.Since(start).Seconds())
+	return h.Hash(), currentRev, nil
+}
+
 func (s *store) updateCompactRev(rev int64) (<-chan struct{}, int64, error) {
 	s.revMu.Lock()
 	if rev <= s.compactMainRev {
@@ -306,11 +461,17 @@ func (s *store) Restore(b backend.Backend) error {
 	s.fifoSched = schedule.NewFIFOScheduler(s.lg)
 	s.stopc = make(chan struct{})
 
-	return s.restore()
+	if err := s.restore(); err != nil {
+		return err
+	}
+	s.startIndexCheckpoints()
+	s.startKeyExpirer()
+	return nil
 }
 
 func (s *store) restore() error {
 	s.setupMetricsReporter()
+	s.revisionTimeSampled = time.Time{}
 
 	min, max := NewRevBytes(), NewRevBytes()
 	min = RevToBytes(Revision{Main: 1}, min)
@@ -335,8 +496,23 @@ func (s *store) restore() error {
 		s.revMu.Unlock()
 	}
 	scheduledCompact, _ := UnsafeReadScheduledCompact(tx)
-	// index keys concurrently as they're loaded in from tx
 	keysGauge.Set(0)
+	checkpointRev := int64(0)
+	if s.cfg.IndexCheckpointInterval > 0 {
+		minRev := s.compactMainRev
+		if scheduledCompact > minRev {
+			minRev = scheduledCompact
+		}
+		if rev, leases, ok := s.loadIndexCheckpoint(tx, minRev); ok {
+			if s.compactMainRev > 0 {
+				s.kvindex.Compact(s.compactMainRev, s.unsafeRetention(tx, time.Now()))
+			}
+			// only the revisions after the checkpoint are left to restore.
+			checkpointRev, keyToLease = rev, leases
+			min = RevToBytes(Revision{Main: rev + 1}, min)
+		}
+	}
+	// index keys concurrently as they're loaded in from tx
 	rkvc, revc := restoreIntoIndex(s.lg, s.kvindex)
 	for {
 		keys, vals := tx.UnsafeRange(schema.Key, min, max, int64(restoreChunkKeys))
@@ -360,6 +536,9 @@ func (s *store) restore() error {
 	{
 		s.revMu.Lock()
 		s.currentRev = <-revc
+		if s.currentRev < checkpointRev {
+			s.currentRev = checkpointRev
+		}
 
 		// keys in the range [compacted revision -N, compaction] might all be deleted due to compaction.
 		// the correct revision should be set to compaction revision in the case, not the largest revision
@@ -380,6 +559,11 @@ func (s *store) restore() error {
 		}
 		s.revMu.Unlock()
 	}
+	// the restore modified the keyIndexes in the index directly.
+	s.kvindex.Recount()
+	if s.prefixCounts != nil {
+		s.rebuildPrefixCounts()
+	}
 
 	if scheduledCompact <= s.compactMainRev {
 		scheduledCompact = 0
@@ -401,6 +585,11 @@ func (s *store) restore() error {
 	}
 	tx.RUnlock()
 
+	s.syncLeaseIndex(keyToLease)
+	s.createKeyTTLBucket()
+	s.syncPrefixStats()
+	s.syncSecondaryIndexes()
+
 	s.lg.Info("kvstore restored", zap.Int64("current-rev", s.currentRev))
 
 	if scheduledCompact != 0 {
@@ -501,6 +690,8 @@ func restoreChunk(lg *zap.Logger, kvc chan<- revKeyValue, keys, vals [][]byte, k
 func (s *store) Close() error {
 	close(s.stopc)
 	s.fifoSched.Stop()
+	s.checkpointWg.Wait()
+	s.keyExpiryWg.Wait()
 	return nil
 }
 
@@ -529,6 +720,10 @@ func (s *store) setupMetricsReporter() {
 		return float64(s.compactMainRev)
 	}
 	reportCompactRevMu.Unlock()
+	idx := s.kvindex
+	reportIndexStatsMu.Lock()
+	reportIndexStats = idx.Stats
+	reportIndexStatsMu.Unlock()
 }
 
 func (s *store) HashStorage() HashStorage {
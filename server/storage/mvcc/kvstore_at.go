@@ -0,0 +1,97 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"sync"
+
+	"go.etcd.io/etcd/pkg/v3/traceutil"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+)
+
+// pinnedTxnRead is a read txn pinned to a revision by At. Unlike the txns of
+// Read, it does not hold the store lock, so that it does not block writes and
+// compactions while it is open; compactions retain the revisions of the keys
+// at its revision instead.
+type pinnedTxnRead struct {
+	storeTxnCommon
+	tx backend.ReadTx
+
+	endOnce sync.Once
+}
+
+func (s *store) At(rev int64) (TxnRead, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.revMu.RLock()
+	defer s.revMu.RUnlock()
+	if rev > s.currentRev {
+		return nil, ErrFutureRev
+	}
+	if rev <= 0 {
+		rev = s.currentRev
+	}
+	if rev < s.compactMainRev {
+		return nil, ErrCompacted
+	}
+	// the revision is pinned before a later compaction may be scheduled.
+	s.pin(rev)
+
+	tx := s.b.ConcurrentReadTx()
+	tx.RLock() // RLock is no-op. concurrentReadTx does not need to be locked after it is created.
+	tr := &pinnedTxnRead{
+		storeTxnCommon: storeTxnCommon{s: s, tx: tx, firstRev: rev, rev: rev, trace: traceutil.TODO(), pinned: true},
+		tx:             tx,
+	}
+	return newMetricsTxnRead(tr), nil
+}
+
+func (tr *pinnedTxnRead) End() {
+	tr.endOnce.Do(func() {
+		tr.tx.RUnlock() // RUnlock signals the end of concurrentReadTx.
+		tr.s.unpin(tr.rev)
+	})
+}
+
+func (s *store) pin(rev int64) {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	if s.pins == nil {
+		s.pins = make(map[int64]int)
+	}
+	s.pins[rev]++
+}
+
+func (s *store) unpin(rev int64) {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	if s.pins[rev]--; s.pins[rev] == 0 {
+		delete(s.pins, rev)
+	}
+}
+
+// pinnedRev returns the lowest revision pinned by the open txns of At, 0 if
+// there is none.
+func (s *store) pinnedRev() int64 {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	var rev int64
+	for r := range s.pins {
+		if rev == 0 || r < rev {
+			rev = r
+		}
+	}
+	return rev
+}
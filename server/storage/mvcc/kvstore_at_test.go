@@ -0,0 +1,104 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/lease"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+)
+
+func TestStoreAt(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	defer cleanup(s, b)
+
+	s.Put([]byte("foo"), []byte("v1"), lease.NoLease)
+	s.Put([]byte("bar"), []byte("v1"), lease.NoLease)
+	tr, err := s.At(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Rev() != 3 || tr.FirstRev() != 3 {
+		t.Errorf("rev = %d, first rev = %d, want 3", tr.Rev(), tr.FirstRev())
+	}
+
+	s.Put([]byte("foo"), []byte("v2"), lease.NoLease)
+	s.DeleteRange([]byte("bar"), nil)
+	s.Put([]byte("baz"), []byte("v1"), lease.NoLease)
+	compactAndWait(t, s, 6)
+
+	r, err := tr.Range(context.TODO(), []byte("a"), []byte("z"), RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.KVs) != 2 || string(r.KVs[0].Key) != "bar" || string(r.KVs[1].Value) != "v1" || r.Rev != 3 {
+		t.Errorf("range = %v at %d, want bar and foo at 3", r.KVs, r.Rev)
+	}
+	if _, err = tr.Range(context.TODO(), []byte("foo"), nil, RangeOptions{Rev: 2}); !errors.Is(err, ErrCompacted) {
+		t.Errorf("range at 2 error = %v, want %v", err, ErrCompacted)
+	}
+	if _, err = s.Range(context.TODO(), []byte("foo"), nil, RangeOptions{Rev: 3}); !errors.Is(err, ErrCompacted) {
+		t.Errorf("store range at 3 error = %v, want %v", err, ErrCompacted)
+	}
+	tr.End()
+	tr.End()
+
+	if s.pinnedRev() != 0 {
+		t.Errorf("pinned revision = %d, want 0 after end", s.pinnedRev())
+	}
+	if _, err = s.At(3); !errors.Is(err, ErrCompacted) {
+		t.Errorf("at 3 error = %v, want %v", err, ErrCompacted)
+	}
+	if _, err = s.At(7); !errors.Is(err, ErrFutureRev) {
+		t.Errorf("at 7 error = %v, want %v", err, ErrFutureRev)
+	}
+}
+
+func TestStoreAtCompactionHash(t *testing.T) {
+	var hashes []KeyValueHash
+	for _, pin := range []bool{false, true} {
+		b, _ := betesting.NewDefaultTmpBackend(t)
+		s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+
+		var tr TxnRead
+		for i := 0; i < 4; i++ {
+			s.Put([]byte("foo"), []byte("bar"), lease.NoLease)
+			if i == 0 && pin {
+				var err error
+				if tr, err = s.At(0); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		// the second compaction hashes the revision retained by the first.
+		compactAndWait(t, s, 3)
+		compactAndWait(t, s, 5)
+		hs := s.HashStorage().Hashes()
+		hashes = append(hashes, hs[len(hs)-1])
+		if tr != nil {
+			tr.End()
+		}
+		cleanup(s, b)
+	}
+	if hashes[0] != hashes[1] {
+		t.Errorf("compaction hash with a pinned revision = %+v, want %+v", hashes[1], hashes[0])
+	}
+}
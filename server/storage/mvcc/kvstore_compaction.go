@@ -27,7 +27,22 @@ import (
 
 func (s *store) scheduleCompaction(compactMainRev, prevCompactRev int64) (KeyValueHash, error) {
 	totalStart := time.Now()
-	keep := s.kvindex.Compact(compactMainRev)
+	tx := s.b.BatchTx()
+	tx.LockOutsideApply()
+	r := s.unsafeRetention(tx, totalStart)
+	s.pruneRevisionTimes(tx, totalStart)
+	tx.Unlock()
+	// the revisions retained for the txns of At are local to this member, so
+	// they are left out of the hash, to match the hash of the other members.
+	var hashKeep map[Revision]struct{}
+	pinnedRev := s.pinnedRev()
+	if pinnedRev > 0 && pinnedRev < compactMainRev {
+		hashKeep = s.kvindex.Keep(compactMainRev, r)
+	}
+	keep := s.kvindex.Compact(compactMainRev, r.pin(pinnedRev))
+	if hashKeep == nil {
+		hashKeep = keep
+	}
 	indexCompactionPauseMs.Observe(float64(time.Since(totalStart) / time.Millisecond))
 
 	totalStart = time.Now()
@@ -40,10 +55,13 @@ func (s *store) scheduleCompaction(compactMainRev, prevCompactRev int64) (KeyVal
 	binary.BigEndian.PutUint64(end, uint64(compactMainRev+1))
 
 	batchNum := s.cfg.CompactionBatchLimit
-	batchTicker := time.NewTicker(s.cfg.CompactionSleepInterval)
-	defer batchTicker.Stop()
-	h := newKVHasher(prevCompactRev, compactMainRev, keep)
+	pacer := newCompactionPacer(s.cfg)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	h := newKVHasher(prevCompactRev, compactMainRev, hashKeep)
 	last := make([]byte, 8+1+8)
+	s.startCompactStatus(compactMainRev, prevCompactRev, totalStart)
 	for {
 		var rev Revision
 
@@ -52,13 +70,16 @@ func (s *store) scheduleCompaction(compactMainRev, prevCompactRev int64) (KeyVal
 		tx := s.b.BatchTx()
 		tx.LockOutsideApply()
 		keys, values := tx.UnsafeRange(schema.Key, last, end, int64(batchNum))
+		deleted, size := 0, 0
 		for i := range keys {
 			rev = BytesToRev(keys[i])
 			if _, ok := keep[rev]; !ok {
 				tx.UnsafeDelete(schema.Key, keys[i])
 				keyCompactions++
+				deleted++
 			}
 			h.WriteKeyValue(keys[i], values[i])
+			size += len(keys[i]) + len(values[i])
 		}
 
 		if len(keys) < batchNum {
@@ -66,6 +87,7 @@ func (s *store) scheduleCompaction(compactMainRev, prevCompactRev int64) (KeyVal
 			UnsafeSetFinishedCompact(tx, compactMainRev)
 			tx.Unlock()
 			// gofail: var compactAfterSetFinishedCompact struct{}
+			s.finishCompactStatus(len(keys), deleted, size)
 			hash := h.Hash()
 			size, sizeInUse := s.b.Size(), s.b.SizeInUse()
 			s.lg.Info(
@@ -86,14 +108,206 @@ func (s *store) scheduleCompaction(compactMainRev, prevCompactRev int64) (KeyVal
 		last = RevToBytes(Revision{Main: rev.Main, Sub: rev.Sub + 1}, last)
 		// Immediately commit the compaction deletes instead of letting them accumulate in the write buffer
 		// gofail: var compactBeforeCommitBatch struct{}
+		commitStart := time.Now()
 		s.b.ForceCommit()
+		commitTook := time.Since(commitStart)
 		// gofail: var compactAfterCommitBatch struct{}
-		dbCompactionPauseMs.Observe(float64(time.Since(start) / time.Millisecond))
+		took := time.Since(start)
+		dbCompactionPauseMs.Observe(float64(took / time.Millisecond))
 
+		pause := pacer.pause(len(keys), size, took, commitTook)
+		s.updateCompactStatus(len(keys), deleted, size, rev.Main, pause)
+		timer.Reset(maxDuration(pause, 0))
 		select {
-		case <-batchTicker.C:
+		case <-timer.C:
 		case <-s.stopc:
+			s.stopCompactStatus()
 			return KeyValueHash{}, fmt.Errorf("interrupted due to stop signal")
 		}
 	}
 }
+
+// maxCompactionBackoff caps the pause between compaction batches while
+// committing them is slow.
+var maxCompactionBackoff = time.Second
+
+// compactionPacer computes the pause between compaction batches, so that
+// compaction runs at the configured rates and backs off while the backend is
+// slow to commit.
+type compactionPacer struct {
+	minPause       time.Duration
+	keysPerSec     int
+	bytesPerSec    int
+	backoffLatency time.Duration
+	// backoff is the current pause due to slow commits.
+	backoff time.Duration
+}
+
+func newCompactionPacer(cfg StoreConfig) *compactionPacer {
+	return &compactionPacer{
+		minPause:       cfg.CompactionSleepInterval,
+		keysPerSec:     cfg.CompactionKeysPerSecond,
+		bytesPerSec:    cfg.CompactionBytesPerSecond,
+		backoffLatency: cfg.CompactionBackoffLatency,
+	}
+}
+
+// pause returns how long to wait before the next batch, given the keys and
+// bytes of the batch, how long it took and how long its commit took.
+func (p *compactionPacer) pause(keys, bytes int, took, commitTook time.Duration) time.Duration {
+	interval := p.minPause
+	if p.keysPerSec > 0 {
+		interval = maxDuration(interval, time.Duration(keys)*time.Second/time.Duration(p.keysPerSec))
+	}
+	if p.bytesPerSec > 0 {
+		interval = maxDuration(interval, time.Duration(bytes)*time.Second/time.Duration(p.bytesPerSec))
+	}
+	if p.backoffLatency > 0 {
+		if commitTook > p.backoffLatency {
+			p.backoff = maxDuration(2*p.backoff, p.minPause)
+			if p.backoff > maxCompactionBackoff {
+				p.backoff = maxCompactionBackoff
+			}
+		} else {
+			p.backoff /= 2
+		}
+		interval = maxDuration(interval, p.backoff)
+	}
+	return interval - took
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// CompactionStatus reports the progress of the running or last compaction.
+type CompactionStatus struct {
+	// Revision is the revision compacted to, PrevRevision the revision of
+	// the previous compaction.
+	Revision     int64
+	PrevRevision int64
+	// Running is false once the compaction has finished or was interrupted.
+	Running bool
+	// Finished is true if the compaction has finished.
+	Finished bool
+	Started  time.Time
+	// ScannedKeys and ScannedBytes are the revisions scanned so far and the
+	// size of their keys and values, DeletedKeys the revisions deleted.
+	ScannedKeys  int64
+	ScannedBytes int64
+	DeletedKeys  int64
+	// ScannedRevision is the main revision of the last scanned revision.
+	ScannedRevision int64
+	// RemainingRevisions is the number of main revisions left to scan, and
+	// EstimatedRemaining the time it is estimated to take at the rate of
+	// the revisions scanned so far.
+	RemainingRevisions int64
+	EstimatedRemaining time.Duration
+	// Paused is the total time paused between batches.
+	Paused time.Duration
+}
+
+// CompactStatus returns the progress of the running or last compaction.
+func (s *store) CompactStatus() CompactionStatus {
+	s.compactStatusMu.Lock()
+	defer s.compactStatusMu.Unlock()
+	return s.compactStatus
+}
+
+func (s *store) startCompactStatus(rev, prevRev int64, start time.Time) {
+	s.compactStatusMu.Lock()
+	defer s.compactStatusMu.Unlock()
+	s.compactStatus = CompactionStatus{
+		Revision:           rev,
+		PrevRevision:       prevRev,
+		Running:            true,
+		Started:            start,
+		RemainingRevisions: rev - prevRev,
+	}
+}
+
+// updateCompactStatus accounts for a batch of the running compaction, which
+// has scanned the revisions up to the main revision scannedRev.
+func (s *store) updateCompactStatus(scanned, deleted, size int, scannedRev int64, pause time.Duration) {
+	s.compactStatusMu.Lock()
+	defer s.compactStatusMu.Unlock()
+	st := &s.compactStatus
+	st.ScannedKeys += int64(scanned)
+	st.DeletedKeys += int64(deleted)
+	st.ScannedBytes += int64(size)
+	if pause > 0 {
+		st.Paused += pause
+	}
+	st.ScannedRevision = scannedRev
+	// The revisions kept by the previous compaction are scanned first, and
+	// there are usually far fewer of them than later revisions.
+	if done := scannedRev - st.PrevRevision; done > 0 {
+		st.RemainingRevisions = st.Revision - scannedRev
+		elapsed := time.Since(st.Started)
+		st.EstimatedRemaining = time.Duration(float64(elapsed) * float64(st.RemainingRevisions) / float64(done))
+	}
+}
+
+// finishCompactStatus accounts for the last batch of the running compaction.
+func (s *store) finishCompactStatus(scanned, deleted, size int) {
+	s.updateCompactStatus(scanned, deleted, size, 0, 0)
+	s.compactStatusMu.Lock()
+	defer s.compactStatusMu.Unlock()
+	st := &s.compactStatus
+	st.ScannedRevision = st.Revision
+	st.RemainingRevisions = 0
+	st.EstimatedRemaining = 0
+	st.Running = false
+	st.Finished = true
+}
+
+func (s *store) stopCompactStatus() {
+	s.compactStatusMu.Lock()
+	defer s.compactStatusMu.Unlock()
+	s.compactStatus.Running = false
+	s.compactStatus.EstimatedRemaining = 0
+}
+
+// CompactionEstimate estimates what a compaction would remove.
+type CompactionEstimate struct {
+	// Revision is the revision of the estimated compaction.
+	Revision int64
+	// Revisions is the number of revisions the compaction would remove, of
+	// which Tombstones are deletions of keys.
+	Revisions  int
+	Tombstones int
+	// Bytes approximates the size of the removed revisions in the backend,
+	// from the average size of every revision in use.
+	Bytes int64
+}
+
+// CompactionEstimate estimates what a compaction at rev would remove, from
+// the key index.
+func (s *store) CompactionEstimate(rev int64) (CompactionEstimate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.revMu.RLock()
+	compactRev, currentRev := s.compactMainRev, s.currentRev
+	s.revMu.RUnlock()
+	if rev <= compactRev {
+		return CompactionEstimate{}, ErrCompacted
+	}
+	if rev > currentRev {
+		return CompactionEstimate{}, ErrFutureRev
+	}
+
+	tx := s.b.ReadTx()
+	tx.RLock()
+	r := s.unsafeRetention(tx, time.Now())
+	tx.RUnlock()
+	est := CompactionEstimate{Revision: rev}
+	var total int
+	est.Revisions, est.Tombstones, total = s.kvindex.CompactionEstimate(rev, r)
+	if total > 0 {
+		est.Bytes = s.b.SizeInUse() * int64(est.Revisions) / int64(total)
+	}
+	return est, nil
+}
@@ -148,3 +148,159 @@ func TestCompactAllAndRestore(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCompactionPacer(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        StoreConfig
+		keys       int
+		bytes      int
+		took       time.Duration
+		commitTook time.Duration
+		wpause     time.Duration
+	}{
+		{
+			name:   "sleep interval",
+			cfg:    StoreConfig{CompactionSleepInterval: 10 * time.Millisecond},
+			keys:   1000,
+			took:   4 * time.Millisecond,
+			wpause: 6 * time.Millisecond,
+		},
+		{
+			name:   "keys per second",
+			cfg:    StoreConfig{CompactionSleepInterval: 10 * time.Millisecond, CompactionKeysPerSecond: 10000},
+			keys:   1000,
+			took:   20 * time.Millisecond,
+			wpause: 80 * time.Millisecond,
+		},
+		{
+			name:   "bytes per second",
+			cfg:    StoreConfig{CompactionSleepInterval: 10 * time.Millisecond, CompactionKeysPerSecond: 10000, CompactionBytesPerSecond: 1 << 20},
+			keys:   1000,
+			bytes:  1 << 19,
+			wpause: 500 * time.Millisecond,
+		},
+		{
+			name:   "slow batch",
+			cfg:    StoreConfig{CompactionSleepInterval: 10 * time.Millisecond},
+			keys:   1000,
+			took:   50 * time.Millisecond,
+			wpause: -40 * time.Millisecond,
+		},
+		{
+			name:       "slow commit",
+			cfg:        StoreConfig{CompactionSleepInterval: 10 * time.Millisecond, CompactionBackoffLatency: 5 * time.Millisecond},
+			keys:       1000,
+			took:       8 * time.Millisecond,
+			commitTook: 6 * time.Millisecond,
+			wpause:     2 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newCompactionPacer(tt.cfg)
+			if pause := p.pause(tt.keys, tt.bytes, tt.took, tt.commitTook); pause != tt.wpause {
+				t.Errorf("pause = %v, want %v", pause, tt.wpause)
+			}
+		})
+	}
+}
+
+func TestCompactionPacerBackoff(t *testing.T) {
+	p := newCompactionPacer(StoreConfig{CompactionSleepInterval: 10 * time.Millisecond, CompactionBackoffLatency: 5 * time.Millisecond})
+	var pauses []time.Duration
+	for i := 0; i < 9; i++ {
+		pauses = append(pauses, p.pause(1, 0, 0, 6*time.Millisecond))
+	}
+	for i := 0; i < 2; i++ {
+		pauses = append(pauses, p.pause(1, 0, 0, time.Millisecond))
+	}
+	wpauses := []time.Duration{
+		10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond,
+		80 * time.Millisecond, 160 * time.Millisecond, 320 * time.Millisecond,
+		640 * time.Millisecond, time.Second, time.Second,
+		500 * time.Millisecond, 250 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(pauses, wpauses) {
+		t.Errorf("pauses = %v, want %v", pauses, wpauses)
+	}
+}
+
+func TestCompactStatus(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{CompactionBatchLimit: 10})
+	defer cleanup(s, b)
+
+	if st := s.CompactStatus(); st.Running || st.Finished {
+		t.Fatalf("status before compaction = %+v, want none", st)
+	}
+
+	for i := 0; i < 25; i++ {
+		s.Put([]byte("foo"), []byte("bar"), lease.NoLease)
+	}
+	s.Put([]byte("baz"), []byte("bar"), lease.NoLease)
+	rev := s.Rev()
+	done, err := s.Compact(traceutil.TODO(), rev-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for compaction to finish")
+	}
+
+	st := s.CompactStatus()
+	if st.Running || !st.Finished {
+		t.Errorf("running, finished = %v, %v, want false, true", st.Running, st.Finished)
+	}
+	if st.Revision != rev-1 || st.ScannedRevision != rev-1 || st.RemainingRevisions != 0 {
+		t.Errorf("revision, scanned, remaining = %d, %d, %d, want %d, %d, 0", st.Revision, st.ScannedRevision, st.RemainingRevisions, rev-1, rev-1)
+	}
+	// the revisions up to rev-1 are scanned, all but the last put of foo are deleted.
+	if st.ScannedKeys != 25 || st.DeletedKeys != 24 {
+		t.Errorf("scanned, deleted = %d, %d, want 25, 24", st.ScannedKeys, st.DeletedKeys)
+	}
+	if st.ScannedBytes == 0 {
+		t.Errorf("scanned bytes = 0, want > 0")
+	}
+}
+
+func TestCompactionEstimate(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	defer cleanup(s, b)
+
+	s.Put([]byte("a"), []byte("bar"), lease.NoLease)
+	s.Put([]byte("a"), []byte("bar"), lease.NoLease)
+	s.DeleteRange([]byte("a"), nil)
+	s.Put([]byte("b"), []byte("bar"), lease.NoLease)
+	s.Put([]byte("b"), []byte("bar"), lease.NoLease)
+
+	est, err := s.CompactionEstimate(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every revision of a and the first put of b, which is kept as b at 5.
+	if est.Revision != 5 || est.Revisions != 3 || est.Tombstones != 1 || est.Bytes <= 0 {
+		t.Errorf("estimate = %+v, want 3 revisions with 1 tombstone and some bytes at 5", est)
+	}
+	if est, err = s.CompactionEstimate(4); err != nil || est.Revisions != 3 || est.Tombstones != 1 {
+		t.Errorf("estimate at 4 = %+v, %v, want 3 revisions with 1 tombstone", est, err)
+	}
+
+	done, err := s.Compact(traceutil.TODO(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if st := s.CompactStatus(); st.DeletedKeys != int64(3) {
+		t.Errorf("deleted = %d, want the 3 estimated revisions", st.DeletedKeys)
+	}
+	if _, err = s.CompactionEstimate(5); err != ErrCompacted {
+		t.Errorf("error = %v, want %v", err, ErrCompacted)
+	}
+	if _, err = s.CompactionEstimate(7); err != ErrFutureRev {
+		t.Errorf("error = %v, want %v", err, ErrFutureRev)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+func (tr *storeTxnCommon) History(key []byte, startRev, endRev int64, limit int) (*HistoryResult, error) {
+	return tr.historyKey(key, startRev, endRev, limit, tr.Rev())
+}
+
+func (tw *storeTxnWrite) History(key []byte, startRev, endRev int64, limit int) (*HistoryResult, error) {
+	rev := tw.beginRev
+	if len(tw.changes) > 0 {
+		rev++
+	}
+	return tw.historyKey(key, startRev, endRev, limit, rev)
+}
+
+func (tr *storeTxnCommon) historyKey(key []byte, startRev, endRev int64, limit int, curRev int64) (*HistoryResult, error) {
+	if startRev > curRev {
+		return &HistoryResult{Rev: curRev}, ErrFutureRev
+	}
+	if startRev <= 0 {
+		startRev = 1
+	}
+	if tr.compacted(startRev) && !tr.retained(key, startRev) {
+		return &HistoryResult{Rev: 0}, ErrCompacted
+	}
+	if endRev <= 0 || endRev > curRev {
+		endRev = curRev + 1
+	}
+	revs, more := tr.s.kvindex.History(key, startRev, endRev, limit)
+	tr.trace.Step("history of key from in-memory index tree")
+
+	evs := make([]mvccpb.Event, len(revs))
+	min, max := NewRevBytes(), NewRevBytes()
+	for i, rev := range revs {
+		// the key of a tombstone is the revision followed by a mark, so the
+		// revision is looked up as a range up to the next revision.
+		min = RevToBytes(rev, min)
+		max = RevToBytes(Revision{Main: rev.Main, Sub: rev.Sub + 1}, max)
+		keys, vals := tr.tx.UnsafeRange(schema.Key, min, max, 1)
+		if len(vals) != 1 {
+			tr.s.lg.Fatal(
+				"history failed to find revision pair",
+				zap.Int64("revision-main", rev.Main),
+				zap.Int64("revision-sub", rev.Sub),
+				zap.Int64("revision-current", curRev),
+			)
+		}
+		kv := &mvccpb.KeyValue{}
+		if err := kv.Unmarshal(vals[0]); err != nil {
+			tr.s.lg.Fatal(
+				"failed to unmarshal mvccpb.KeyValue",
+				zap.Error(err),
+			)
+		}
+		evs[i] = mvccpb.Event{Type: mvccpb.PUT, Kv: kv}
+		if isTombstone(keys[0]) {
+			evs[i].Type = mvccpb.DELETE
+			kv.ModRevision = rev.Main
+		}
+	}
+	tr.trace.Step("history of key from bolt db")
+	return &HistoryResult{Events: evs, More: more, Rev: curRev}, nil
+}
+
+// retained returns whether the compactions retained the revisions of key
+// since startRev, the revision of the key at startRev included.
+func (tr *storeTxnCommon) retained(key []byte, startRev int64) bool {
+	if len(tr.s.cfg.RetentionPolicies) == 0 {
+		return false
+	}
+	_, _, _, err := tr.s.kvindex.Get(key, startRev)
+	return err == nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+// rangeStreamChunkKeys is the number of keys RangeStream reads at once.
+var rangeStreamChunkKeys = 1000 // non-const for testing
+
+func (tr *storeTxnCommon) RangeStream(ctx context.Context, key, end []byte, ro RangeOptions, fn func(kv mvccpb.KeyValue) error) error {
+	return tr.rangeStream(ctx, key, end, tr.Rev(), ro, fn)
+}
+
+func (tw *storeTxnWrite) RangeStream(ctx context.Context, key, end []byte, ro RangeOptions, fn func(kv mvccpb.KeyValue) error) error {
+	rev := tw.beginRev
+	if len(tw.changes) > 0 {
+		rev++
+	}
+	return tw.rangeStream(ctx, key, end, rev, ro, fn)
+}
+
+func (tr *storeTxnCommon) rangeStream(ctx context.Context, key, end []byte, curRev int64, ro RangeOptions, fn func(kv mvccpb.KeyValue) error) error {
+	rev := ro.Rev
+	if rev > curRev {
+		return ErrFutureRev
+	}
+	if rev <= 0 {
+		rev = curRev
+	}
+	if tr.compacted(rev) {
+		return ErrCompacted
+	}
+
+	remaining := int(ro.Limit)
+	revBytes := NewRevBytes()
+	for {
+		n := rangeStreamChunkKeys
+		if remaining > 0 && remaining < n {
+			n = remaining
+		}
+		keys, revs := tr.s.kvindex.RangeLimit(key, end, rev, n)
+		for i, revpair := range revs {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("rangeStream: context cancelled: %w", ctx.Err())
+			default:
+			}
+			revBytes = RevToBytes(revpair, revBytes)
+			_, vs := tr.tx.UnsafeRange(schema.Key, revBytes, nil, 0)
+			if len(vs) != 1 {
+				tr.s.lg.Fatal(
+					"range stream failed to find revision pair",
+					zap.Int64("revision-main", revpair.Main),
+					zap.Int64("revision-sub", revpair.Sub),
+					zap.Int64("revision-current", curRev),
+					zap.Int64("range-option-rev", ro.Rev),
+					zap.Binary("key", keys[i]),
+				)
+			}
+			var kv mvccpb.KeyValue
+			if err := kv.Unmarshal(vs[0]); err != nil {
+				tr.s.lg.Fatal(
+					"failed to unmarshal mvccpb.KeyValue",
+					zap.Error(err),
+				)
+			}
+			if err := fn(kv); err != nil {
+				return err
+			}
+		}
+		if remaining > 0 {
+			if remaining -= len(revs); remaining == 0 {
+				return nil
+			}
+		}
+		if end == nil || len(revs) < n {
+			return nil
+		}
+		// the next chunk begins right after the last key of this one.
+		key = append(append([]byte{}, keys[len(keys)-1]...), 0)
+	}
+}
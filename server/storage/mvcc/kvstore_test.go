@@ -729,6 +729,44 @@ func TestTxnPut(t *testing.T) {
 	}
 }
 
+type fakeConsistentIndexer struct{ index uint64 }
+
+func (ci *fakeConsistentIndexer) UnsafeSave(tx backend.UnsafeReadWriter) {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, ci.index)
+	tx.UnsafePut(schema.Meta, schema.MetaConsistentIndexKeyName, v)
+}
+
+func TestStoreConsistentIndexer(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	ci := &fakeConsistentIndexer{}
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{ConsistentIndexer: ci})
+	defer cleanup(s, b)
+
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket(schema.Meta)
+	tx.Unlock()
+
+	for i := uint64(1); i <= 3; i++ {
+		txn := s.Write(traceutil.TODO())
+		ci.index = i
+		if i != 2 {
+			txn.Put([]byte("foo"), []byte("bar"), lease.NoLease)
+		}
+		txn.End()
+		s.Commit()
+
+		rtx := b.ReadTx()
+		rtx.RLock()
+		_, vs := rtx.UnsafeRange(schema.Meta, schema.MetaConsistentIndexKeyName, nil, 0)
+		rtx.RUnlock()
+		if len(vs) != 1 || binary.BigEndian.Uint64(vs[0]) != i {
+			t.Errorf("#%d: saved consistent index = %x, want %d", i, vs, i)
+		}
+	}
+}
+
 // TestConcurrentReadNotBlockingWrite ensures Read does not blocking Write after its creation
 func TestConcurrentReadNotBlockingWrite(t *testing.T) {
 	b, _ := betesting.NewDefaultTmpBackend(t)
@@ -1030,6 +1068,10 @@ func (i *fakeIndex) Revisions(key, end []byte, atRev int64, limit int) ([]Revisi
 	return rev, len(rev)
 }
 
+func (i *fakeIndex) History(key []byte, startRev, endRev int64, limit int) ([]Revision, bool) {
+	return nil, false
+}
+
 func (i *fakeIndex) CountRevisions(key, end []byte, atRev int64) int {
 	_, rev := i.Range(key, end, atRev)
 	return len(rev)
@@ -1045,9 +1087,27 @@ func (i *fakeIndex) Range(key, end []byte, atRev int64) ([][]byte, []Revision) {
 	r := <-i.indexRangeRespc
 	return r.keys, r.revs
 }
+func (i *fakeIndex) RangeLimit(key, end []byte, atRev int64, limit int) ([][]byte, []Revision) {
+	i.Recorder.Record(testutil.Action{Name: "rangeLimit", Params: []any{key, end, atRev, limit}})
+	r := <-i.indexRangeRespc
+	return r.keys, r.revs
+}
 func (i *fakeIndex) Put(key []byte, rev Revision) {
 	i.Recorder.Record(testutil.Action{Name: "put", Params: []any{key, rev}})
 }
+func (i *fakeIndex) GetBatch(keys [][]byte, atRev int64) []keyState {
+	states := make([]keyState, len(keys))
+	for j, key := range keys {
+		st := &states[j]
+		st.modified, st.created, st.ver, st.err = i.Get(key, atRev)
+	}
+	return states
+}
+func (i *fakeIndex) PutBatch(keys [][]byte, revs []Revision) {
+	for j, key := range keys {
+		i.Put(key, revs[j])
+	}
+}
 func (i *fakeIndex) Tombstone(key []byte, rev Revision) error {
 	i.Recorder.Record(testutil.Action{Name: "tombstone", Params: []any{key, rev}})
 	return nil
@@ -1057,14 +1117,17 @@ func (i *fakeIndex) RangeSince(key, end []byte, rev int64) []Revision {
 	r := <-i.indexRangeEventsRespc
 	return r.revs
 }
-func (i *fakeIndex) Compact(rev int64) map[Revision]struct{} {
+func (i *fakeIndex) Compact(rev int64, r *retention) map[Revision]struct{} {
 	i.Recorder.Record(testutil.Action{Name: "compact", Params: []any{rev}})
 	return <-i.indexCompactRespc
 }
-func (i *fakeIndex) Keep(rev int64) map[Revision]struct{} {
+func (i *fakeIndex) Keep(rev int64, r *retention) map[Revision]struct{} {
 	i.Recorder.Record(testutil.Action{Name: "keep", Params: []any{rev}})
 	return <-i.indexCompactRespc
 }
+func (i *fakeIndex) CompactionEstimate(rev int64, r *retention) (revs, tombstones, total int) {
+	return 0, 0, 0
+}
 func (i *fakeIndex) Equal(b index) bool { return false }
 
 func (i *fakeIndex) Insert(ki *keyIndex) {
@@ -1076,6 +1139,12 @@ func (i *fakeIndex) KeyIndex(ki *keyIndex) *keyIndex {
 	return nil
 }
 
+func (i *fakeIndex) Ascend(f func(ki *keyIndex) bool) {}
+
+func (i *fakeIndex) Stats() indexStats { return indexStats{} }
+
+func (i *fakeIndex) Recount() {}
+
 func createBytesSlice(bytesN, sliceN int) [][]byte {
 	var rs [][]byte
 	for len(rs) != sliceN {
@@ -40,6 +40,10 @@ type storeTxnCommon struct {
 	rev      int64
 
 	trace *traceutil.Trace
+
+	// pinned is set if the txn is pinned to rev by At, so that only rev is
+	// served.
+	pinned bool
 }
 
 func (s *store) Read(mode ReadTxMode, trace *traceutil.Trace) TxnRead {
@@ -59,7 +63,16 @@ func (s *store) Read(mode ReadTxMode, trace *traceutil.Trace) TxnRead {
 	tx.RLock() // RLock is no-op. concurrentReadTx does not need to be locked after it is created.
 	firstRev, rev := s.compactMainRev, s.currentRev
 	s.revMu.RUnlock()
-	return newMetricsTxnRead(&storeTxnRead{storeTxnCommon{s, tx, firstRev, rev, trace}, tx})
+	return newMetricsTxnRead(&storeTxnRead{storeTxnCommon{s: s, tx: tx, firstRev: firstRev, rev: rev, trace: trace}, tx})
+}
+
+// compacted returns whether rev is compacted. The revisions before the
+// revision of a pinned txn may be compacted at any time.
+func (tr *storeTxnCommon) compacted(rev int64) bool {
+	if tr.pinned {
+		return rev < tr.rev
+	}
+	return rev < tr.s.compactMainRev
 }
 
 func (tr *storeTxnCommon) FirstRev() int64 { return tr.firstRev }
@@ -77,10 +90,16 @@ func (tr *storeTxnCommon) rangeKeys(ctx context.Context, key, end []byte, curRev
 	if rev <= 0 {
 		rev = curRev
 	}
-	if rev < tr.s.compactMainRev {
+	if tr.compacted(rev) {
 		return &RangeResult{KVs: nil, Count: -1, Rev: 0}, ErrCompacted
 	}
 	if ro.Count {
+		if ro.ApproxCount && rev == curRev && !tr.pinned && tr.s.prefixCounts != nil {
+			if total, ok := tr.s.prefixCounts.count(key, end); ok {
+				tr.trace.Step("count keys from prefix counters")
+				return &RangeResult{KVs: nil, Count: total, Rev: curRev}, nil
+			}
+		}
 		total := tr.s.kvindex.CountRevisions(key, end, rev)
 		tr.trace.Step("count revisions from in-memory index tree")
 		return &RangeResult{KVs: nil, Count: total, Rev: curRev}, nil
@@ -149,7 +168,7 @@ func (s *store) Write(trace *traceutil.Trace) TxnWrite {
 	tx := s.b.BatchTx()
 	tx.LockInsideApply()
 	tw := &storeTxnWrite{
-		storeTxnCommon: storeTxnCommon{s, tx, 0, 0, trace},
+		storeTxnCommon: storeTxnCommon{s: s, tx: tx, trace: trace},
 		tx:             tx,
 		beginRev:       s.currentRev,
 		changes:        make([]mvccpb.KeyValue, 0, 4),
@@ -179,12 +198,26 @@ func (tw *storeTxnWrite) Put(key, value []byte, lease lease.LeaseID) int64 {
 	return tw.beginRev + 1
 }
 
+func (s *store) CheckPutSize(key, value []byte) error {
+	if s.cfg.MaxKeyBytes > 0 && len(key) > s.cfg.MaxKeyBytes {
+		return ErrKeyTooLarge
+	}
+	if s.cfg.MaxValueBytes > 0 && len(value) > s.cfg.MaxValueBytes {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
 func (tw *storeTxnWrite) End() {
 	// only update index if the txn modifies the mvcc state.
 	if len(tw.changes) != 0 {
 		// hold revMu lock to prevent new read txns from opening until writeback.
 		tw.s.revMu.Lock()
 		tw.s.currentRev++
+		tw.s.recordRevisionTime(tw.tx, tw.s.currentRev)
+	}
+	if ci := tw.s.cfg.ConsistentIndexer; ci != nil {
+		ci.UnsafeSave(tw.tx)
 	}
 	tw.tx.Unlock()
 	if len(tw.changes) != 0 {
@@ -194,14 +227,70 @@ func (tw *storeTxnWrite) End() {
 }
 
 func (tw *storeTxnWrite) put(key, value []byte, leaseID lease.LeaseID) {
+	var prev keyState
+	prev.modified, prev.created, prev.ver, prev.err = tw.s.kvindex.Get(key, tw.beginRev+1)
+	tw.s.kvindex.Put(key, tw.putKeyValue(key, value, leaseID, prev))
+}
+
+// PutBatch puts the key-value pairs of kvs, attached to their Lease, at
+// sequential sub revisions, getting and putting their revisions in the
+// index at once. If the size of the keys and values exceeds
+// StoreConfig.MaxPutBatchBytes, nothing is put and ErrPutBatchTooLarge is
+// returned.
+func (tw *storeTxnWrite) PutBatch(kvs []mvccpb.KeyValue) (int64, error) {
+	if limit := tw.s.cfg.MaxPutBatchBytes; limit > 0 {
+		size := 0
+		for _, kv := range kvs {
+			size += len(kv.Key) + len(kv.Value)
+		}
+		if size > limit {
+			return tw.beginRev, ErrPutBatchTooLarge
+		}
+	}
+	for _, kv := range kvs {
+		if err := tw.s.CheckPutSize(kv.Key, kv.Value); err != nil {
+			return tw.beginRev, err
+		}
+	}
+	if len(kvs) == 0 {
+		return tw.beginRev, nil
+	}
+	rev := tw.beginRev + 1
+	keys := make([][]byte, len(kvs))
+	for i := range kvs {
+		keys[i] = kvs[i].Key
+	}
+	prevs := tw.s.kvindex.GetBatch(keys, rev)
+	// the keys put more than once are put over their previous put in the
+	// batch, which is not in the index yet.
+	batched := make(map[string]keyState)
+	revs := make([]Revision, len(kvs))
+	for i, kv := range kvs {
+		prev, ok := batched[string(kv.Key)]
+		if !ok {
+			prev = prevs[i]
+		}
+		revs[i] = tw.putKeyValue(kv.Key, kv.Value, lease.LeaseID(kv.Lease), prev)
+		put := tw.changes[len(tw.changes)-1]
+		batched[string(kv.Key)] = keyState{modified: revs[i], created: Revision{Main: put.CreateRevision}, ver: put.Version}
+	}
+	tw.s.kvindex.PutBatch(keys, revs)
+	return rev, nil
+}
+
+// putKeyValue puts key in the backend over its previous state prev, and
+// returns the revision to put it at in the index.
+func (tw *storeTxnWrite) putKeyValue(key, value []byte, leaseID lease.LeaseID, prev keyState) Revision {
 	rev := tw.beginRev + 1
 	c := rev
 	oldLease := lease.NoLease
 
 	// if the key exists before, use its previous created and
 	// get its previous leaseID
-	_, created, ver, err := tw.s.kvindex.Get(key, rev)
-	if err == nil {
+	prevRev, created, ver := prev.modified, prev.created, prev.ver
+	existed := prev.err == nil
+	if existed {
+		tw.clearKeyDeadline(key)
 		c = created.Main
 		oldLease = tw.s.le.GetLease(lease.LeaseItem{Key: string(key)})
 		tw.trace.Step("get key's previous created_revision and leaseID")
@@ -230,14 +319,28 @@ func (tw *storeTxnWrite) put(key, value []byte, leaseID lease.LeaseID) {
 
 	tw.trace.Step("marshal mvccpb.KeyValue")
 	tw.tx.UnsafeSeqPut(schema.Key, ibytes, d)
-	tw.s.kvindex.Put(key, idxRev)
+	if len(tw.s.cfg.SecondaryIndexes) > 0 {
+		var prevValue []byte
+		if existed {
+			prevValue = tw.valueAt(prevRev)
+		}
+		if value == nil {
+			value = []byte{}
+		}
+		tw.updateSecondaryIndexes(key, prevValue, value)
+	}
+	if ver == 1 && tw.s.prefixCounts != nil {
+		tw.s.prefixCounts.add(key, 1)
+	}
+	tw.countPrefixStats(key, false)
 	tw.changes = append(tw.changes, kv)
 	tw.trace.Step("store kv pair into bolt db")
 
 	if oldLease == leaseID {
 		tw.trace.Step("attach lease to kv pair")
-		return
+		return idxRev
 	}
+	tw.updateLeaseIndex(key, oldLease, leaseID)
 
 	if oldLease != lease.NoLease {
 		if tw.s.le == nil {
@@ -261,6 +364,7 @@ func (tw *storeTxnWrite) put(key, value []byte, leaseID lease.LeaseID) {
 		}
 	}
 	tw.trace.Step("attach lease to kv pair")
+	return idxRev
 }
 
 func (tw *storeTxnWrite) deleteRange(key, end []byte) int64 {
@@ -293,6 +397,12 @@ func (tw *storeTxnWrite) delete(key []byte) {
 		)
 	}
 
+	if len(tw.s.cfg.SecondaryIndexes) > 0 {
+		prevRev, _, _, err := tw.s.kvindex.Get(key, idxRev.Main)
+		if err == nil {
+			tw.updateSecondaryIndexes(key, tw.valueAt(prevRev), nil)
+		}
+	}
 	tw.tx.UnsafeSeqPut(schema.Key, ibytes, d)
 	err = tw.s.kvindex.Tombstone(key, idxRev.Revision)
 	if err != nil {
@@ -302,12 +412,18 @@ func (tw *storeTxnWrite) delete(key []byte) {
 			zap.Error(err),
 		)
 	}
+	if tw.s.prefixCounts != nil {
+		tw.s.prefixCounts.add(key, -1)
+	}
+	tw.countPrefixStats(key, true)
 	tw.changes = append(tw.changes, kv)
+	tw.clearKeyDeadline(key)
 
 	item := lease.LeaseItem{Key: string(key)}
 	leaseID := tw.s.le.GetLease(item)
 
 	if leaseID != lease.NoLease {
+		tw.updateLeaseIndex(key, leaseID, lease.NoLease)
 		err = tw.s.le.Detach(leaseID, []lease.LeaseItem{item})
 		if err != nil {
 			tw.storeTxnCommon.s.lg.Error(
@@ -0,0 +1,66 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"errors"
+
+	"go.etcd.io/etcd/pkg/v3/traceutil"
+	"go.etcd.io/etcd/server/v3/lease"
+)
+
+var ErrKeyNotDeleted = errors.New("mvcc: key is not deleted")
+
+func (tw *storeTxnWrite) Undelete(key []byte, atRev int64) (int64, error) {
+	rev := tw.beginRev
+	if len(tw.changes) > 0 {
+		rev++
+	}
+	if atRev > rev {
+		return tw.beginRev, ErrFutureRev
+	}
+	if _, _, _, err := tw.s.kvindex.Get(key, rev); err == nil {
+		return tw.beginRev, ErrKeyNotDeleted
+	}
+	if atRev <= 0 {
+		// the last revision of a deleted key is its tombstone.
+		revs, _ := tw.s.kvindex.History(key, 1, rev+1, 0)
+		if len(revs) == 0 {
+			return tw.beginRev, ErrRevisionNotFound
+		}
+		atRev = revs[len(revs)-1].Main - 1
+	}
+	if atRev < tw.s.compactMainRev {
+		return tw.beginRev, ErrCompacted
+	}
+	modified, _, _, err := tw.s.kvindex.Get(key, atRev)
+	if err != nil {
+		return tw.beginRev, err
+	}
+	value := tw.valueAt(modified)
+	// the limits may have been lowered since the value was put.
+	if err = tw.s.CheckPutSize(key, value); err != nil {
+		return tw.beginRev, err
+	}
+	tw.put(key, value, lease.NoLease)
+	return tw.beginRev + 1, nil
+}
+
+// Undelete undeletes key in a write txn of its own.
+func (wv *writeView) Undelete(key []byte, atRev int64) (int64, error) {
+	tw := wv.kv.Write(traceutil.TODO())
+	defer tw.End()
+	return tw.Undelete(key, atRev)
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/lease"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+)
+
+func TestStoreUndelete(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	defer cleanup(s, b)
+
+	s.Put([]byte("foo"), []byte("v1"), lease.NoLease)
+	s.Put([]byte("foo"), []byte("v2"), lease.NoLease)
+	s.DeleteRange([]byte("foo"), nil)
+
+	tests := []struct {
+		atRev  int64
+		wrev   int64
+		wvalue string
+		werr   error
+	}{
+		{0, 5, "v2", nil},
+		{0, 5, "", ErrKeyNotDeleted},
+		{2, 7, "v1", nil},
+		{10, 8, "", ErrFutureRev},
+		{1, 8, "", ErrRevisionNotFound},
+	}
+	for i, tt := range tests {
+		if i > 1 {
+			s.DeleteRange([]byte("foo"), nil)
+		}
+		rev, err := s.Undelete([]byte("foo"), tt.atRev)
+		if !errors.Is(err, tt.werr) {
+			t.Fatalf("#%d: error = %v, want %v", i, err, tt.werr)
+		}
+		if rev != tt.wrev {
+			t.Errorf("#%d: rev = %d, want %d", i, rev, tt.wrev)
+		}
+		if err != nil {
+			continue
+		}
+		r, err := s.Range(context.TODO(), []byte("foo"), nil, RangeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.KVs) != 1 || string(r.KVs[0].Value) != tt.wvalue || r.KVs[0].CreateRevision != tt.wrev || r.KVs[0].Version != 1 {
+			t.Errorf("#%d: range = %+v, want %s created at %d", i, r.KVs, tt.wvalue, tt.wrev)
+		}
+	}
+
+	if _, err := s.Undelete([]byte("bar"), 0); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("undelete of a missing key error = %v, want %v", err, ErrRevisionNotFound)
+	}
+	compactAndWait(t, s, 8)
+	if _, err := s.Undelete([]byte("foo"), 3); !errors.Is(err, ErrCompacted) {
+		t.Errorf("undelete at a compacted revision error = %v, want %v", err, ErrCompacted)
+	}
+}
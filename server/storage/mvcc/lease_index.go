@@ -0,0 +1,146 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/lease"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+// ErrLeaseIndexDisabled is returned by RangeByLease if the store does not
+// maintain the lease index, see StoreConfig.LeaseIndex.
+var ErrLeaseIndexDisabled = errors.New("mvcc: lease index is disabled")
+
+// The lease index maps the leases to the current keys attached to them in the
+// schema.LeaseKey bucket, in the same batch tx as the writes attaching and
+// detaching the keys: a key attached to a lease is stored under the 8 byte
+// big endian lease ID followed by the key. The index is checked against the
+// leases of the restored keys when the store is restored, so that the index
+// of a backend written while it was not maintained is brought up to date.
+
+func leaseIndexPrefix(id lease.LeaseID) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+func leaseIndexKey(id lease.LeaseID, key []byte) []byte {
+	return append(leaseIndexPrefix(id), key...)
+}
+
+// updateLeaseIndex moves key from the keys of oldLease to the keys of
+// newLease.
+func (tw *storeTxnWrite) updateLeaseIndex(key []byte, oldLease, newLease lease.LeaseID) {
+	if !tw.s.cfg.LeaseIndex {
+		return
+	}
+	if oldLease != lease.NoLease {
+		tw.tx.UnsafeDelete(schema.LeaseKey, leaseIndexKey(oldLease, key))
+	}
+	if newLease != lease.NoLease {
+		tw.tx.UnsafePut(schema.LeaseKey, leaseIndexKey(newLease, key), []byte{})
+	}
+}
+
+func (tr *storeTxnCommon) RangeByLease(ctx context.Context, id lease.LeaseID, ro RangeOptions) (*RangeResult, error) {
+	return tr.rangeByLease(ctx, id, tr.Rev(), ro)
+}
+
+func (tw *storeTxnWrite) RangeByLease(ctx context.Context, id lease.LeaseID, ro RangeOptions) (*RangeResult, error) {
+	rev := tw.beginRev
+	if len(tw.changes) > 0 {
+		rev++
+	}
+	return tw.rangeByLease(ctx, id, rev, ro)
+}
+
+func (tr *storeTxnCommon) rangeByLease(ctx context.Context, id lease.LeaseID, curRev int64, ro RangeOptions) (*RangeResult, error) {
+	if ro.Rev > 0 && ro.Rev != curRev {
+		return &RangeResult{Rev: curRev}, ErrIndexRev
+	}
+	if !tr.s.cfg.LeaseIndex {
+		return &RangeResult{Rev: curRev}, ErrLeaseIndexDisabled
+	}
+	if id == lease.NoLease {
+		return &RangeResult{Rev: curRev}, nil
+	}
+	prefix := leaseIndexPrefix(id)
+	keys, _ := tr.tx.UnsafeRange(schema.LeaseKey, prefix, prefixRangeEnd(prefix), 0)
+	tr.trace.Step("range keys from lease index")
+	if ro.Count {
+		return &RangeResult{Rev: curRev, Count: len(keys)}, nil
+	}
+	limit := int(ro.Limit)
+	if limit <= 0 || limit > len(keys) {
+		limit = len(keys)
+	}
+	kvs := make([]mvccpb.KeyValue, 0, limit)
+	for _, k := range keys[:limit] {
+		r, err := tr.rangeKeys(ctx, k[len(prefix):], nil, curRev, RangeOptions{})
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, r.KVs...)
+	}
+	return &RangeResult{KVs: kvs, Rev: curRev, Count: len(keys)}, nil
+}
+
+// syncLeaseIndex brings the lease index in line with the leases of the
+// restored keys, removing the stale entries and adding the missing ones. It
+// must be called holding the store lock.
+func (s *store) syncLeaseIndex(keyToLease map[string]lease.LeaseID) {
+	if !s.cfg.LeaseIndex {
+		return
+	}
+	tx := s.b.BatchTx()
+	tx.LockOutsideApply()
+	defer tx.Unlock()
+	tx.UnsafeCreateBucket(schema.LeaseKey)
+
+	var stale [][]byte
+	indexed := make(map[string]struct{}, len(keyToLease))
+	tx.UnsafeForEach(schema.LeaseKey, func(k, _ []byte) error {
+		if len(k) > 8 {
+			key := string(k[8:])
+			if id, ok := keyToLease[key]; ok && binary.BigEndian.Uint64(k) == uint64(id) {
+				indexed[key] = struct{}{}
+				return nil
+			}
+		}
+		stale = append(stale, bytes.Clone(k))
+		return nil
+	})
+	for _, k := range stale {
+		tx.UnsafeDelete(schema.LeaseKey, k)
+	}
+	added := 0
+	for key, id := range keyToLease {
+		if _, ok := indexed[key]; !ok {
+			tx.UnsafePut(schema.LeaseKey, leaseIndexKey(id, []byte(key)), []byte{})
+			added++
+		}
+	}
+	if len(stale) > 0 || added > 0 {
+		s.lg.Info("synced lease index", zap.Int("removed-keys", len(stale)), zap.Int("added-keys", added))
+	}
+}
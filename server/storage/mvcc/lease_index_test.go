@@ -0,0 +1,127 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/pkg/v3/traceutil"
+	"go.etcd.io/etcd/server/v3/lease"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+)
+
+func leaseKeys(t *testing.T, rv ReadView, id lease.LeaseID) []string {
+	t.Helper()
+	r, err := rv.RangeByLease(context.TODO(), id, RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, kv := range r.KVs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func TestStoreRangeByLease(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	le := &checkpointLessor{leases: make(map[string]lease.LeaseID)}
+	s := NewStore(zaptest.NewLogger(t), b, le, StoreConfig{LeaseIndex: true})
+	defer cleanup(s, b)
+
+	s.Put([]byte("a"), []byte("v"), 1)
+	s.Put([]byte("b"), []byte("v"), 1)
+	s.Put([]byte("c"), []byte("v"), 2)
+	s.Put([]byte("d"), []byte("v"), lease.NoLease)
+	s.Put([]byte("a"), []byte("v"), 2)
+	s.Put([]byte("c"), []byte("v"), 2)
+	s.DeleteRange([]byte("b"), nil)
+
+	tests := []struct {
+		id    lease.LeaseID
+		wkeys []string
+	}{
+		{1, nil},
+		{2, []string{"a", "c"}},
+		{3, nil},
+		{lease.NoLease, nil},
+	}
+	for _, tt := range tests {
+		if keys := leaseKeys(t, s, tt.id); !reflect.DeepEqual(keys, tt.wkeys) {
+			t.Errorf("keys of lease %d = %v, want %v", tt.id, keys, tt.wkeys)
+		}
+	}
+
+	// a write txn reads its own writes.
+	tw := s.Write(traceutil.TODO())
+	tw.Put([]byte("e"), []byte("v"), 1)
+	tw.Put([]byte("c"), []byte("v"), 1)
+	if keys := leaseKeys(t, tw, 1); !reflect.DeepEqual(keys, []string{"c", "e"}) {
+		t.Errorf("keys of lease 1 in txn = %v, want [c e]", keys)
+	}
+	tw.End()
+
+	r, err := s.RangeByLease(context.TODO(), 1, RangeOptions{Count: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count != 2 || len(r.KVs) != 0 {
+		t.Errorf("count = %d with %d kvs, want 2 without kvs", r.Count, len(r.KVs))
+	}
+	if _, err = s.RangeByLease(context.TODO(), 1, RangeOptions{Rev: 2}); !errors.Is(err, ErrIndexRev) {
+		t.Errorf("error = %v, want %v", err, ErrIndexRev)
+	}
+}
+
+func TestStoreLeaseIndexDisabled(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	defer cleanup(s, b)
+
+	if _, err := s.RangeByLease(context.TODO(), 1, RangeOptions{}); !errors.Is(err, ErrLeaseIndexDisabled) {
+		t.Errorf("error = %v, want %v", err, ErrLeaseIndexDisabled)
+	}
+}
+
+func TestStoreSyncLeaseIndex(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	le := &checkpointLessor{leases: make(map[string]lease.LeaseID)}
+	s := NewStore(zaptest.NewLogger(t), b, le, StoreConfig{LeaseIndex: true})
+	s.Put([]byte("a"), []byte("v"), 1)
+	s.Put([]byte("b"), []byte("v"), 1)
+	s.Close()
+
+	// the index is not maintained while it is disabled.
+	s = NewStore(zaptest.NewLogger(t), b, le, StoreConfig{})
+	s.Put([]byte("b"), []byte("v"), 2)
+	s.Put([]byte("c"), []byte("v"), 1)
+	s.DeleteRange([]byte("a"), nil)
+	s.Close()
+
+	le = &checkpointLessor{leases: make(map[string]lease.LeaseID)}
+	s = NewStore(zaptest.NewLogger(t), b, le, StoreConfig{LeaseIndex: true})
+	defer cleanup(s, b)
+	if keys := leaseKeys(t, s, 1); !reflect.DeepEqual(keys, []string{"c"}) {
+		t.Errorf("keys of lease 1 = %v, want [c]", keys)
+	}
+	if keys := leaseKeys(t, s, 2); !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Errorf("keys of lease 2 = %v, want [b]", keys)
+	}
+}
@@ -258,6 +258,54 @@ var (
 	reportCompactRevMu sync.RWMutex
 	reportCompactRev   = func() float64 { return 0 }
 
+	indexKeys = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "mvcc",
+		Name:      "index_keys_total",
+		Help:      "Total number of keys in the key index, including the deleted keys not compacted yet.",
+	},
+		func() float64 { return float64(reportedIndexStats().keys) },
+	)
+
+	indexGenerations = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "mvcc",
+		Name:      "index_generations_total",
+		Help:      "Total number of generations of the keys in the key index.",
+	},
+		func() float64 { return float64(reportedIndexStats().generations) },
+	)
+
+	indexRevisions = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "mvcc",
+		Name:      "index_revisions_total",
+		Help:      "Total number of revisions of the keys in the key index.",
+	},
+		func() float64 { return float64(reportedIndexStats().revisions) },
+	)
+
+	indexMaxRevisionsPerKey = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "mvcc",
+		Name:      "index_max_revisions_per_key",
+		Help:      "The most revisions of a key in the key index, as of the last compaction or later puts.",
+	},
+		func() float64 { return float64(reportedIndexStats().maxRevisions) },
+	)
+
+	indexTreeDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "etcd_debugging",
+		Subsystem: "mvcc",
+		Name:      "index_tree_depth",
+		Help:      "The estimated depth of the btree of the key index.",
+	},
+		func() float64 { return float64(reportedIndexStats().depth) },
+	)
+	// overridden by mvcc initialization
+	reportIndexStatsMu sync.RWMutex
+	reportIndexStats   = func() indexStats { return indexStats{} }
+
 	totalPutSizeGauge = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Namespace: "etcd_debugging",
@@ -291,6 +339,17 @@ func init() {
 	prometheus.MustRegister(currentRev)
 	prometheus.MustRegister(compactRev)
 	prometheus.MustRegister(totalPutSizeGauge)
+	prometheus.MustRegister(indexKeys)
+	prometheus.MustRegister(indexGenerations)
+	prometheus.MustRegister(indexRevisions)
+	prometheus.MustRegister(indexMaxRevisionsPerKey)
+	prometheus.MustRegister(indexTreeDepth)
+}
+
+func reportedIndexStats() indexStats {
+	reportIndexStatsMu.RLock()
+	defer reportIndexStatsMu.RUnlock()
+	return reportIndexStats()
 }
 
 // ReportEventReceived reports that an event is received.
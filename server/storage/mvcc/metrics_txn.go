@@ -16,7 +16,9 @@ package mvcc
 
 import (
 	"context"
+	"time"
 
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/server/v3/lease"
 )
 
@@ -53,6 +55,31 @@ func (tw *metricsTxnWrite) Put(key, value []byte, lease lease.LeaseID) (rev int6
 	return tw.TxnWrite.Put(key, value, lease)
 }
 
+func (tw *metricsTxnWrite) PutTTL(key, value []byte, ttl time.Duration) (int64, error) {
+	tw.puts++
+	tw.putSize += int64(len(key) + len(value))
+	return tw.TxnWrite.PutTTL(key, value, ttl)
+}
+
+func (tw *metricsTxnWrite) Undelete(key []byte, atRev int64) (int64, error) {
+	rev, err := tw.TxnWrite.Undelete(key, atRev)
+	if err == nil {
+		tw.puts++
+	}
+	return rev, err
+}
+
+func (tw *metricsTxnWrite) PutBatch(kvs []mvccpb.KeyValue) (int64, error) {
+	rev, err := tw.TxnWrite.PutBatch(kvs)
+	if err == nil {
+		for _, kv := range kvs {
+			tw.puts++
+			tw.putSize += int64(len(kv.Key) + len(kv.Value))
+		}
+	}
+	return rev, err
+}
+
 func (tw *metricsTxnWrite) End() {
 	defer tw.TxnWrite.End()
 	if sum := tw.ranges + tw.puts + tw.deletes; sum > 1 {
@@ -0,0 +1,125 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+	"sync"
+)
+
+// prefixDelimiter separates the levels of the prefixes counted by
+// prefixCounter.
+const prefixDelimiter = '/'
+
+// prefixCounter counts the live keys under every prefix of a key ending with
+// prefixDelimiter, up to depth delimiters, along with the live keys in total.
+// The counters are updated as the writes of a txn are applied, before the
+// txn ends, so they are approximate for concurrent readers.
+type prefixCounter struct {
+	depth int
+
+	mu     sync.RWMutex
+	total  int64
+	counts map[string]int64
+}
+
+func newPrefixCounter(depth int) *prefixCounter {
+	return &prefixCounter{depth: depth, counts: make(map[string]int64)}
+}
+
+// add adds delta to the counters of the prefixes of key.
+func (pc *prefixCounter) add(key []byte, delta int64) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.total += delta
+	n := 0
+	for i := 0; i < len(key) && n < pc.depth; i++ {
+		if key[i] != prefixDelimiter {
+			continue
+		}
+		n++
+		prefix := string(key[:i+1])
+		if c := pc.counts[prefix] + delta; c > 0 {
+			pc.counts[prefix] = c
+		} else {
+			delete(pc.counts, prefix)
+		}
+	}
+}
+
+// count returns the number of live keys in the range [key, end), if it is
+// the range of a counted prefix or of every key.
+func (pc *prefixCounter) count(key, end []byte) (int, bool) {
+	if !pc.tracks(key, end) {
+		return 0, false
+	}
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	if isRangeOfAllKeys(key, end) {
+		return int(pc.total), true
+	}
+	return int(pc.counts[string(key)]), true
+}
+
+// isRangeOfAllKeys reports whether [key, end) is the range of every key,
+// which is requested with an empty, non-nil end.
+func isRangeOfAllKeys(key, end []byte) bool {
+	return end != nil && len(end) == 0 && (len(key) == 0 || bytes.Equal(key, []byte{0}))
+}
+
+// tracks reports whether the range [key, end) is the range of a counted
+// prefix or of every key.
+func (pc *prefixCounter) tracks(key, end []byte) bool {
+	if isRangeOfAllKeys(key, end) {
+		return true
+	}
+	if len(key) == 0 || key[len(key)-1] != prefixDelimiter || bytes.Count(key, []byte{prefixDelimiter}) > pc.depth {
+		return false
+	}
+	return bytes.Equal(end, prefixRangeEnd(key))
+}
+
+func (pc *prefixCounter) reset() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.total = 0
+	pc.counts = make(map[string]int64)
+}
+
+// prefixRangeEnd returns the end of the range of the keys with the prefix,
+// the same way clientv3.GetPrefixRangeEnd does.
+func prefixRangeEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// rebuildPrefixCounts counts the keys live at the current revision of the
+// key index. It must be called holding the store lock.
+func (s *store) rebuildPrefixCounts() {
+	s.prefixCounts.reset()
+	s.kvindex.Ascend(func(ki *keyIndex) bool {
+		if !ki.generations[len(ki.generations)-1].isEmpty() {
+			s.prefixCounts.add(ki.key, 1)
+		}
+		return true
+	})
+}
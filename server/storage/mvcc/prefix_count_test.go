@@ -0,0 +1,92 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/lease"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+)
+
+func TestPrefixCounterTracks(t *testing.T) {
+	pc := newPrefixCounter(2)
+	tests := []struct {
+		key, end []byte
+		want     bool
+	}{
+		{key: []byte{0}, end: []byte{}, want: true},
+		{key: []byte{0}, end: nil},
+		{key: []byte("/"), end: []byte("0"), want: true},
+		{key: []byte("/a/"), end: []byte("/a0"), want: true},
+		{key: []byte("/a/b/"), end: []byte("/a/b0")},
+		{key: []byte("/a"), end: []byte("/b")},
+		{key: []byte("/a/"), end: []byte("/b/")},
+		{key: []byte("/a/"), end: nil},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, pc.tracks(tt.key, tt.end), "range [%q, %q)", tt.key, tt.end)
+	}
+}
+
+func TestStoreApproxCount(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	defer betesting.Close(t, b)
+	cfg := StoreConfig{PrefixCountDepth: 2}
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, cfg)
+
+	for i := 0; i < 10; i++ {
+		s.Put([]byte(fmt.Sprintf("/a/%d", i)), []byte("v"), lease.NoLease)
+		s.Put([]byte(fmt.Sprintf("/b/c/%d", i)), []byte("v"), lease.NoLease)
+	}
+	s.Put([]byte("/a/1"), []byte("v2"), lease.NoLease)
+	s.DeleteRange([]byte("/a/2"), nil)
+	s.DeleteRange([]byte("/a/3"), []byte("/a/5"))
+	s.Put([]byte("/a/3"), []byte("v"), lease.NoLease)
+	s.Put([]byte("other"), []byte("v"), lease.NoLease)
+
+	ranges := [][2][]byte{
+		{{0}, {}},
+		{[]byte("/"), []byte("0")},
+		{[]byte("/a/"), []byte("/a0")},
+		{[]byte("/b/"), []byte("/b0")},
+		{[]byte("/b/c/"), []byte("/b/c0")},
+		{[]byte("/z/"), []byte("/z0")},
+	}
+	check := func(s *store) {
+		for _, r := range ranges {
+			want, err := s.Range(context.TODO(), r[0], r[1], RangeOptions{Count: true})
+			require.NoError(t, err)
+			got, err := s.Range(context.TODO(), r[0], r[1], RangeOptions{Count: true, ApproxCount: true})
+			require.NoError(t, err)
+			assert.Equal(t, want.Count, got.Count, "range [%q, %q)", r[0], r[1])
+		}
+	}
+	check(s)
+	s.Close()
+
+	s = NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, cfg)
+	defer s.Close()
+	check(s)
+	r, err := s.Range(context.TODO(), []byte("/a/"), []byte("/a0"), RangeOptions{Count: true, ApproxCount: true})
+	require.NoError(t, err)
+	assert.Equal(t, 8, r.Count)
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+var ErrUnknownPrefix = errors.New("mvcc: prefix is not registered for stats")
+
+// PrefixStats are the counters of the writes of the keys with a prefix
+// registered in StoreConfig.StatsPrefixes, since it was registered.
+type PrefixStats struct {
+	// Puts is the number of puts of the keys.
+	Puts int64
+	// Deletes is the number of deletions of the keys.
+	Deletes int64
+	// ModRevision is the revision of the last put or deletion of the keys,
+	// 0 if none.
+	ModRevision int64
+}
+
+// prefixStatsKey returns the key of the stats of prefix in the
+// schema.PrefixStats bucket. The prefix is preceded by a mark, as bolt does
+// not allow the empty key of the empty prefix.
+func prefixStatsKey(prefix []byte) []byte {
+	return append([]byte{'p'}, prefix...)
+}
+
+func (st PrefixStats) marshal() []byte {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint64(b, uint64(st.Puts))
+	binary.BigEndian.PutUint64(b[8:], uint64(st.Deletes))
+	binary.BigEndian.PutUint64(b[16:], uint64(st.ModRevision))
+	return b
+}
+
+func unmarshalPrefixStats(b []byte) PrefixStats {
+	return PrefixStats{
+		Puts:        int64(binary.BigEndian.Uint64(b)),
+		Deletes:     int64(binary.BigEndian.Uint64(b[8:])),
+		ModRevision: int64(binary.BigEndian.Uint64(b[16:])),
+	}
+}
+
+// countPrefixStats counts a put, or a deletion if deleted, of key in the
+// stats of the registered prefixes of key.
+func (tw *storeTxnWrite) countPrefixStats(key []byte, deleted bool) {
+	for _, prefix := range tw.s.cfg.StatsPrefixes {
+		if !bytes.HasPrefix(key, prefix) {
+			continue
+		}
+		k := prefixStatsKey(prefix)
+		var st PrefixStats
+		if _, vs := tw.tx.UnsafeRange(schema.PrefixStats, k, nil, 0); len(vs) == 1 {
+			st = unmarshalPrefixStats(vs[0])
+		}
+		if deleted {
+			st.Deletes++
+		} else {
+			st.Puts++
+		}
+		st.ModRevision = tw.beginRev + 1
+		tw.tx.UnsafePut(schema.PrefixStats, k, st.marshal())
+	}
+}
+
+// syncPrefixStats creates the bucket of the stats of the registered
+// prefixes, and removes the stats of the prefixes no longer registered.
+func (s *store) syncPrefixStats() {
+	tx := s.b.BatchTx()
+	tx.LockOutsideApply()
+	defer tx.Unlock()
+	if len(s.cfg.StatsPrefixes) > 0 {
+		tx.UnsafeCreateBucket(schema.PrefixStats)
+	}
+	registered := make(map[string]struct{}, len(s.cfg.StatsPrefixes))
+	for _, prefix := range s.cfg.StatsPrefixes {
+		registered[string(prefixStatsKey(prefix))] = struct{}{}
+	}
+	var stale [][]byte
+	tx.UnsafeForEach(schema.PrefixStats, func(k, _ []byte) error {
+		if _, ok := registered[string(k)]; !ok {
+			stale = append(stale, bytes.Clone(k))
+		}
+		return nil
+	})
+	for _, k := range stale {
+		tx.UnsafeDelete(schema.PrefixStats, k)
+	}
+	if len(stale) > 0 {
+		s.lg.Info("removed stats of unregistered prefixes", zap.Int("prefixes", len(stale)))
+	}
+}
+
+func (s *store) PrefixStats(prefix []byte) (PrefixStats, error) {
+	registered := false
+	for _, p := range s.cfg.StatsPrefixes {
+		if bytes.Equal(p, prefix) {
+			registered = true
+			break
+		}
+	}
+	if !registered {
+		return PrefixStats{}, ErrUnknownPrefix
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tx := s.b.ReadTx()
+	tx.RLock()
+	defer tx.RUnlock()
+	_, vs := tx.UnsafeRange(schema.PrefixStats, prefixStatsKey(prefix), nil, 0)
+	if len(vs) != 1 {
+		return PrefixStats{}, nil
+	}
+	return unmarshalPrefixStats(vs[0]), nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/lease"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+)
+
+func TestStorePrefixStats(t *testing.T) {
+	b, tmpPath := betesting.NewDefaultTmpBackend(t)
+	cfg := StoreConfig{StatsPrefixes: [][]byte{[]byte(""), []byte("foo/"), []byte("bar/")}}
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, cfg)
+
+	s.Put([]byte("foo/a"), []byte("v"), lease.NoLease)
+	s.Put([]byte("foo/b"), []byte("v"), lease.NoLease)
+	s.Put([]byte("baz"), []byte("v"), lease.NoLease)
+	s.DeleteRange([]byte("foo/"), []byte("foo0"))
+	s.DeleteRange([]byte("foo/"), []byte("foo0"))
+
+	tests := []struct {
+		prefix string
+		wst    PrefixStats
+		werr   error
+	}{
+		{"", PrefixStats{Puts: 3, Deletes: 2, ModRevision: 5}, nil},
+		{"foo/", PrefixStats{Puts: 2, Deletes: 2, ModRevision: 5}, nil},
+		{"bar/", PrefixStats{}, nil},
+		{"baz", PrefixStats{}, ErrUnknownPrefix},
+	}
+	for i, tt := range tests {
+		st, err := s.PrefixStats([]byte(tt.prefix))
+		if !errors.Is(err, tt.werr) {
+			t.Errorf("#%d: error = %v, want %v", i, err, tt.werr)
+		}
+		if st != tt.wst {
+			t.Errorf("#%d: stats of %q = %+v, want %+v", i, tt.prefix, st, tt.wst)
+		}
+	}
+	s.Close()
+	b.Close()
+
+	// the stats are kept across restarts, for the prefixes still registered.
+	b = backend.NewDefaultBackend(zaptest.NewLogger(t), tmpPath)
+	s = NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{StatsPrefixes: [][]byte{[]byte("foo/")}})
+	defer cleanup(s, b)
+	if st, err := s.PrefixStats([]byte("foo/")); err != nil || st != tests[1].wst {
+		t.Errorf("stats of foo/ after restart = %+v, %v, want %+v", st, err, tests[1].wst)
+	}
+	s.Put([]byte("foo/c"), []byte("v"), lease.NoLease)
+	if st, _ := s.PrefixStats([]byte("foo/")); st != (PrefixStats{Puts: 3, Deletes: 2, ModRevision: 6}) {
+		t.Errorf("stats of foo/ = %+v, want 3 puts at 6", st)
+	}
+}
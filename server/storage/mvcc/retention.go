@@ -0,0 +1,248 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+// RetentionPolicy retains revisions of keys that compactions would otherwise
+// remove. The retained revisions of a key, and every later revision of the
+// key, are still served by History.
+type RetentionPolicy struct {
+	// Prefix selects the keys the policy applies to, every key if empty.
+	// Only the policy with the longest prefix of a key applies to it, so
+	// that policies of longer prefixes override the policies of shorter ones.
+	Prefix []byte
+	// KeepVersions retains the last KeepVersions revisions of every key,
+	// including the deletions of the key. Zero retains none.
+	KeepVersions int
+	// KeepAge retains the revisions written less than KeepAge ago. Zero
+	// retains none.
+	//
+	// The wall time of the revisions is sampled every revisionTimeInterval
+	// into the schema.RevisionTime bucket by each member, from its own clock,
+	// so that the members may retain different revisions.
+	KeepAge time.Duration
+}
+
+// revisionTimeInterval is the interval between the samples of the wall time
+// of the revisions.
+var revisionTimeInterval = time.Second
+
+// revisionTime is a sample of the wall time of the revisions: every revision
+// before rev was written before time.
+type revisionTime struct {
+	rev  int64
+	time time.Time
+}
+
+// recordRevisionTime samples the time rev is written at, if the store has
+// policies retaining revisions by age. tx must be locked.
+func (s *store) recordRevisionTime(tx backend.UnsafeWriter, rev int64) {
+	if !s.retainsByAge() {
+		return
+	}
+	now := time.Now()
+	if now.Sub(s.revisionTimeSampled) < revisionTimeInterval {
+		return
+	}
+	if s.revisionTimeSampled.IsZero() {
+		tx.UnsafeCreateBucket(schema.RevisionTime)
+	}
+	s.revisionTimeSampled = now
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(rev))
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(now.UnixNano()))
+	tx.UnsafePut(schema.RevisionTime, key, value)
+}
+
+func (s *store) retainsByAge() bool {
+	for _, p := range s.cfg.RetentionPolicies {
+		if p.KeepAge > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func unsafeReadRevisionTimes(tx backend.UnsafeReader) []revisionTime {
+	var rts []revisionTime
+	tx.UnsafeForEach(schema.RevisionTime, func(k, v []byte) error {
+		rts = append(rts, revisionTime{
+			rev:  int64(binary.BigEndian.Uint64(k)),
+			time: time.Unix(0, int64(binary.BigEndian.Uint64(v))),
+		})
+		return nil
+	})
+	return rts
+}
+
+// ageRev returns the revision before which every revision was written at
+// least age before now, from the samples rts in revision order.
+func ageRev(rts []revisionTime, now time.Time, age time.Duration) int64 {
+	rev, cutoff := int64(0), now.Add(-age)
+	for _, rt := range rts {
+		if rt.time.After(cutoff) {
+			break
+		}
+		rev = rt.rev
+	}
+	return rev
+}
+
+// retention is the retention of the revisions of the keys by the policies
+// of a store at a point in time. A nil retention retains nothing.
+type retention struct {
+	policies []RetentionPolicy
+	// ageRevs are the revisions before which the revisions are old enough
+	// to be removed by each policy.
+	ageRevs []int64
+	// pinnedRev, if set, retains the revisions of the keys at pinnedRev.
+	pinnedRev int64
+}
+
+// unsafeRetention returns the retention of the policies of the store at now,
+// nil if the store has no policies.
+func (s *store) unsafeRetention(tx backend.UnsafeReader, now time.Time) *retention {
+	if len(s.cfg.RetentionPolicies) == 0 {
+		return nil
+	}
+	r := &retention{policies: s.cfg.RetentionPolicies, ageRevs: make([]int64, len(s.cfg.RetentionPolicies))}
+	var rts []revisionTime
+	if s.retainsByAge() {
+		rts = unsafeReadRevisionTimes(tx)
+	}
+	for i, p := range r.policies {
+		if p.KeepAge > 0 {
+			r.ageRevs[i] = ageRev(rts, now, p.KeepAge)
+		}
+	}
+	return r
+}
+
+// pin returns r retaining the revisions of the keys at rev as well, if
+// rev > 0.
+func (r *retention) pin(rev int64) *retention {
+	if rev <= 0 {
+		return r
+	}
+	pr := &retention{pinnedRev: rev}
+	if r != nil {
+		pr.policies, pr.ageRevs = r.policies, r.ageRevs
+	}
+	return pr
+}
+
+// policy returns the policy applying to key, if any.
+func (r *retention) policy(key []byte) (int, bool) {
+	pi := -1
+	for i, p := range r.policies {
+		if bytes.HasPrefix(key, p.Prefix) && (pi == -1 || len(p.Prefix) > len(r.policies[pi].Prefix)) {
+			pi = i
+		}
+	}
+	return pi, pi != -1
+}
+
+// compactRev returns the revision ki is compacted at by a compaction at rev,
+// so that the revisions retained by its policy are kept.
+func (r *retention) compactRev(ki *keyIndex, rev int64) int64 {
+	if r == nil {
+		return rev
+	}
+	if r.pinnedRev > 0 {
+		rev = min(rev, r.pinnedRev)
+	}
+	pi, ok := r.policy(ki.key)
+	if !ok {
+		return rev
+	}
+	p := r.policies[pi]
+	if p.KeepVersions > 0 {
+		if last, ok := ki.lastRevision(p.KeepVersions); ok && last.Main < rev {
+			rev = last.Main
+		} else if !ok {
+			rev = 0
+		}
+	}
+	// compacting before ageRevs keeps every revision from ageRevs on.
+	if ar := r.ageRevs[pi] - 1; p.KeepAge > 0 && ar < rev {
+		rev = max(ar, 0)
+	}
+	return rev
+}
+
+// lastRevision returns the n-th last revision of ki, false if ki has fewer
+// revisions.
+func (ki *keyIndex) lastRevision(n int) (Revision, bool) {
+	for gi := len(ki.generations) - 1; gi >= 0; gi-- {
+		g := &ki.generations[gi]
+		l := g.len()
+		if n <= l {
+			return g.at(l - n), true
+		}
+		n -= l
+	}
+	return Revision{}, false
+}
+
+// retain adds the revisions of ki in (atRev, rev] to available.
+func (ki *keyIndex) retain(atRev, rev int64, available map[Revision]struct{}) {
+	for gi := range ki.generations {
+		for _, r := range ki.generations[gi].revisions() {
+			if r.Main > atRev && r.Main <= rev {
+				available[r] = struct{}{}
+			}
+		}
+	}
+}
+
+// pruneRevisionTimes removes the samples older than the revisions retained
+// by age at now, but the last one, which the next compaction still needs.
+func (s *store) pruneRevisionTimes(tx backend.UnsafeReadWriter, now time.Time) {
+	if !s.retainsByAge() {
+		return
+	}
+	var maxAge time.Duration
+	for _, p := range s.cfg.RetentionPolicies {
+		if p.KeepAge > maxAge {
+			maxAge = p.KeepAge
+		}
+	}
+	rts := unsafeReadRevisionTimes(tx)
+	rev := ageRev(rts, now, maxAge)
+	pruned := 0
+	for _, rt := range rts {
+		if rt.rev >= rev {
+			break
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(rt.rev))
+		tx.UnsafeDelete(schema.RevisionTime, key)
+		pruned++
+	}
+	if pruned > 0 {
+		s.lg.Info("pruned revision times", zap.Int("samples", pruned), zap.Int64("before-revision", rev))
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/pkg/v3/traceutil"
+	"go.etcd.io/etcd/server/v3/lease"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+)
+
+func historyRevs(t *testing.T, s *store, key string, startRev int64) ([]int64, error) {
+	t.Helper()
+	r, err := s.History([]byte(key), startRev, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	var revs []int64
+	for _, ev := range r.Events {
+		revs = append(revs, ev.Kv.ModRevision)
+	}
+	return revs, nil
+}
+
+func compactAndWait(t *testing.T, s *store, rev int64) {
+	t.Helper()
+	done, err := s.Compact(traceutil.TODO(), rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestStoreRetentionKeepVersions(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{
+		RetentionPolicies: []RetentionPolicy{
+			{KeepVersions: 2},
+			{Prefix: []byte("b/"), KeepVersions: 3},
+			{Prefix: []byte("b/tmp/")},
+		},
+	})
+	defer cleanup(s, b)
+
+	for i := 0; i < 4; i++ {
+		s.Put([]byte("a"), []byte("v"), lease.NoLease)
+		s.Put([]byte("b/x"), []byte("v"), lease.NoLease)
+		s.Put([]byte("b/tmp/x"), []byte("v"), lease.NoLease)
+	}
+	// a: 2 5 8 11, b/x: 3 6 9 12, b/tmp/x: 4 7 10 13
+	compactAndWait(t, s, 13)
+
+	tests := []struct {
+		key      string
+		startRev int64
+		wrevs    []int64
+		werr     error
+	}{
+		{"a", 8, []int64{8, 11}, nil},
+		{"a", 7, nil, ErrCompacted},
+		{"b/x", 6, []int64{6, 9, 12}, nil},
+		{"b/x", 5, nil, ErrCompacted},
+		{"b/tmp/x", 12, nil, ErrCompacted},
+		{"b/tmp/x", 13, []int64{13}, nil},
+	}
+	for i, tt := range tests {
+		revs, err := historyRevs(t, s, tt.key, tt.startRev)
+		if !errors.Is(err, tt.werr) {
+			t.Errorf("#%d: history of %s from %d error = %v, want %v", i, tt.key, tt.startRev, err, tt.werr)
+			continue
+		}
+		if len(revs) != len(tt.wrevs) {
+			t.Errorf("#%d: history of %s from %d = %v, want %v", i, tt.key, tt.startRev, revs, tt.wrevs)
+			continue
+		}
+		for j := range revs {
+			if revs[j] != tt.wrevs[j] {
+				t.Errorf("#%d: history of %s from %d = %v, want %v", i, tt.key, tt.startRev, revs, tt.wrevs)
+				break
+			}
+		}
+	}
+}
+
+func TestStoreRetentionKeepAge(t *testing.T) {
+	defer func(d time.Duration) { revisionTimeInterval = d }(revisionTimeInterval)
+	revisionTimeInterval = 0
+
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{
+		RetentionPolicies: []RetentionPolicy{{KeepAge: time.Hour}},
+	})
+	defer cleanup(s, b)
+
+	for i := 0; i < 3; i++ {
+		s.Put([]byte("a"), []byte("v"), lease.NoLease)
+	}
+	compactAndWait(t, s, 4)
+	if revs, err := historyRevs(t, s, "a", 2); err != nil || len(revs) != 3 {
+		t.Errorf("history from 2 = %v, %v, want the 3 recent revisions", revs, err)
+	}
+
+	tx := s.b.ReadTx()
+	tx.RLock()
+	r := s.unsafeRetention(tx, time.Now().Add(2*time.Hour))
+	tx.RUnlock()
+	if r.ageRevs[0] != 4 {
+		t.Errorf("age revision = %d, want 4", r.ageRevs[0])
+	}
+}
+
+func TestAgeRev(t *testing.T) {
+	now := time.Now()
+	rts := []revisionTime{
+		{rev: 10, time: now.Add(-3 * time.Hour)},
+		{rev: 20, time: now.Add(-2 * time.Hour)},
+		{rev: 30, time: now.Add(-time.Hour)},
+	}
+	tests := []struct {
+		age  time.Duration
+		wrev int64
+	}{
+		{4 * time.Hour, 0},
+		{3 * time.Hour, 10},
+		{150 * time.Minute, 10},
+		{90 * time.Minute, 20},
+		{time.Minute, 30},
+	}
+	for i, tt := range tests {
+		if rev := ageRev(rts, now, tt.age); rev != tt.wrev {
+			t.Errorf("#%d: age revision = %d, want %d", i, rev, tt.wrev)
+		}
+	}
+}
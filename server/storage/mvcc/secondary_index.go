@@ -0,0 +1,267 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+var (
+	ErrUnknownIndex = errors.New("mvcc: unknown secondary index")
+	ErrIndexRev     = errors.New("mvcc: index only serves the current revision")
+)
+
+// IndexExtractor returns the terms a key-value pair is indexed under. It
+// must be deterministic and must not modify or retain key and value.
+type IndexExtractor func(key, value []byte) [][]byte
+
+// SecondaryIndex indexes the current keys by the terms extracted from them,
+// for RangeByIndex.
+type SecondaryIndex struct {
+	Name    string
+	Extract IndexExtractor
+}
+
+// The entries of the secondary indexes are stored in the
+// schema.SecondaryIndex bucket, in the same batch tx as the writes they
+// index: a key indexed under a term is stored under indexEntryPrefix
+// followed by the length prefixed index name and term, and the key. An
+// index whose entries are complete is marked by indexBuiltPrefix followed by
+// its name, so that the indexes registered after the keys were written are
+// built when the store is restored.
+const (
+	indexEntryPrefix byte = 'e'
+	indexBuiltPrefix byte = 'n'
+)
+
+func indexTermPrefix(index string, term []byte) []byte {
+	b := []byte{indexEntryPrefix}
+	b = binary.AppendUvarint(b, uint64(len(index)))
+	b = append(b, index...)
+	b = binary.AppendUvarint(b, uint64(len(term)))
+	return append(b, term...)
+}
+
+func indexEntryKey(index string, term, key []byte) []byte {
+	return append(indexTermPrefix(index, term), key...)
+}
+
+func indexBuiltKey(index string) []byte {
+	return append([]byte{indexBuiltPrefix}, index...)
+}
+
+func (s *store) secondaryIndex(name string) (SecondaryIndex, bool) {
+	for _, si := range s.cfg.SecondaryIndexes {
+		if si.Name == name {
+			return si, true
+		}
+	}
+	return SecondaryIndex{}, false
+}
+
+// indexTerms returns the distinct terms of the key-value pair in si, none if
+// value is nil.
+func indexTerms(si SecondaryIndex, key, value []byte) [][]byte {
+	if value == nil {
+		return nil
+	}
+	var terms [][]byte
+	for _, t := range si.Extract(key, value) {
+		dup := false
+		for _, o := range terms {
+			if bytes.Equal(o, t) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+func containsTerm(terms [][]byte, term []byte) bool {
+	for _, t := range terms {
+		if bytes.Equal(t, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateSecondaryIndexes replaces the entries of key for its previous value,
+// nil if it did not exist, with the entries for its new value, nil if it is
+// deleted.
+func (tw *storeTxnWrite) updateSecondaryIndexes(key, prevValue, value []byte) {
+	for _, si := range tw.s.cfg.SecondaryIndexes {
+		prevTerms, terms := indexTerms(si, key, prevValue), indexTerms(si, key, value)
+		for _, t := range prevTerms {
+			if !containsTerm(terms, t) {
+				tw.tx.UnsafeDelete(schema.SecondaryIndex, indexEntryKey(si.Name, t, key))
+			}
+		}
+		for _, t := range terms {
+			if !containsTerm(prevTerms, t) {
+				tw.tx.UnsafePut(schema.SecondaryIndex, indexEntryKey(si.Name, t, key), []byte{})
+			}
+		}
+	}
+}
+
+// valueAt returns the value of the key-value pair written at rev.
+func (tw *storeTxnWrite) valueAt(rev Revision) []byte {
+	ibytes := RevToBytes(rev, NewRevBytes())
+	_, vs := tw.tx.UnsafeRange(schema.Key, ibytes, nil, 0)
+	if len(vs) != 1 {
+		tw.s.lg.Fatal(
+			"failed to find revision pair of the previous value",
+			zap.Int64("revision-main", rev.Main),
+			zap.Int64("revision-sub", rev.Sub),
+		)
+	}
+	var kv mvccpb.KeyValue
+	if err := kv.Unmarshal(vs[0]); err != nil {
+		tw.s.lg.Fatal("failed to unmarshal mvccpb.KeyValue", zap.Error(err))
+	}
+	if kv.Value == nil {
+		// a nil value means the key did not exist.
+		return []byte{}
+	}
+	return kv.Value
+}
+
+func (tr *storeTxnCommon) RangeByIndex(ctx context.Context, index string, term []byte, ro RangeOptions) (*RangeResult, error) {
+	return tr.rangeByIndex(ctx, index, term, tr.Rev(), ro)
+}
+
+func (tw *storeTxnWrite) RangeByIndex(ctx context.Context, index string, term []byte, ro RangeOptions) (*RangeResult, error) {
+	rev := tw.beginRev
+	if len(tw.changes) > 0 {
+		rev++
+	}
+	return tw.rangeByIndex(ctx, index, term, rev, ro)
+}
+
+func (tr *storeTxnCommon) rangeByIndex(ctx context.Context, index string, term []byte, curRev int64, ro RangeOptions) (*RangeResult, error) {
+	if _, ok := tr.s.secondaryIndex(index); !ok {
+		return &RangeResult{Rev: curRev}, ErrUnknownIndex
+	}
+	if ro.Rev > 0 && ro.Rev != curRev {
+		return &RangeResult{Rev: curRev}, ErrIndexRev
+	}
+	prefix := indexTermPrefix(index, term)
+	keys, _ := tr.tx.UnsafeRange(schema.SecondaryIndex, prefix, prefixRangeEnd(prefix), 0)
+	tr.trace.Step("range keys from secondary index")
+	if ro.Count {
+		return &RangeResult{Rev: curRev, Count: len(keys)}, nil
+	}
+	limit := int(ro.Limit)
+	if limit <= 0 || limit > len(keys) {
+		limit = len(keys)
+	}
+	kvs := make([]mvccpb.KeyValue, 0, limit)
+	for _, k := range keys[:limit] {
+		r, err := tr.rangeKeys(ctx, k[len(prefix):], nil, curRev, RangeOptions{})
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, r.KVs...)
+	}
+	return &RangeResult{KVs: kvs, Rev: curRev, Count: len(keys)}, nil
+}
+
+// syncSecondaryIndexes removes the entries of the indexes no longer
+// registered, and builds the registered indexes that are not, from the
+// current keys. It must be called holding the store lock.
+func (s *store) syncSecondaryIndexes() {
+	tx := s.b.BatchTx()
+	tx.LockOutsideApply()
+	defer tx.Unlock()
+	if len(s.cfg.SecondaryIndexes) > 0 {
+		tx.UnsafeCreateBucket(schema.SecondaryIndex)
+	}
+
+	built := make(map[string]bool)
+	err := tx.UnsafeForEach(schema.SecondaryIndex, func(k, _ []byte) error {
+		if len(k) > 0 && k[0] == indexBuiltPrefix {
+			built[string(k[1:])] = true
+		}
+		return nil
+	})
+	if err != nil {
+		s.lg.Fatal("failed to read secondary indexes", zap.Error(err))
+	}
+	for name := range built {
+		if _, ok := s.secondaryIndex(name); ok {
+			continue
+		}
+		prefix := indexTermPrefix(name, nil)
+		prefix = prefix[:len(prefix)-1]
+		keys, _ := tx.UnsafeRange(schema.SecondaryIndex, prefix, prefixRangeEnd(prefix), 0)
+		for _, k := range keys {
+			tx.UnsafeDelete(schema.SecondaryIndex, k)
+		}
+		tx.UnsafeDelete(schema.SecondaryIndex, indexBuiltKey(name))
+		s.lg.Info("removed unregistered secondary index", zap.String("index", name), zap.Int("entries", len(keys)))
+	}
+
+	for _, si := range s.cfg.SecondaryIndexes {
+		if built[si.Name] {
+			continue
+		}
+		n := s.buildSecondaryIndex(tx, si)
+		tx.UnsafePut(schema.SecondaryIndex, indexBuiltKey(si.Name), []byte{})
+		s.lg.Info("built secondary index", zap.String("index", si.Name), zap.Int("entries", n))
+	}
+}
+
+func (s *store) buildSecondaryIndex(tx backend.UnsafeReadWriter, si SecondaryIndex) int {
+	n := 0
+	ibytes := NewRevBytes()
+	s.kvindex.Ascend(func(ki *keyIndex) bool {
+		if ki.generations[len(ki.generations)-1].isEmpty() {
+			return true
+		}
+		ibytes = RevToBytes(ki.modified, ibytes)
+		_, vs := tx.UnsafeRange(schema.Key, ibytes, nil, 0)
+		if len(vs) != 1 {
+			s.lg.Fatal(
+				"failed to find revision pair of a key",
+				zap.Int64("revision-main", ki.modified.Main),
+				zap.Int64("revision-sub", ki.modified.Sub),
+			)
+		}
+		var kv mvccpb.KeyValue
+		if err := kv.Unmarshal(vs[0]); err != nil {
+			s.lg.Fatal("failed to unmarshal mvccpb.KeyValue", zap.Error(err))
+		}
+		for _, t := range indexTerms(si, kv.Key, kv.Value) {
+			tx.UnsafePut(schema.SecondaryIndex, indexEntryKey(si.Name, t, kv.Key), []byte{})
+			n++
+		}
+		return true
+	})
+	return n
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/pkg/v3/traceutil"
+	"go.etcd.io/etcd/server/v3/lease"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+)
+
+// tagsIndex indexes the keys by the comma separated tags of their value.
+var tagsIndex = SecondaryIndex{
+	Name: "tags",
+	Extract: func(_, value []byte) [][]byte {
+		if len(value) == 0 {
+			return nil
+		}
+		return bytes.Split(value, []byte(","))
+	},
+}
+
+func indexedKeys(t *testing.T, rv ReadView, term string) []string {
+	t.Helper()
+	r, err := rv.RangeByIndex(context.TODO(), tagsIndex.Name, []byte(term), RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, kv := range r.KVs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func TestStoreRangeByIndex(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{SecondaryIndexes: []SecondaryIndex{tagsIndex}})
+	defer cleanup(s, b)
+
+	s.Put([]byte("a"), []byte("red,blue"), lease.NoLease)
+	s.Put([]byte("b"), []byte("blue"), lease.NoLease)
+	s.Put([]byte("c"), []byte("red,red"), lease.NoLease)
+	s.Put([]byte("d"), nil, lease.NoLease)
+
+	tests := []struct {
+		term  string
+		wkeys []string
+	}{
+		{"red", []string{"a", "c"}},
+		{"blue", []string{"a", "b"}},
+		{"green", nil},
+	}
+	for _, tt := range tests {
+		if keys := indexedKeys(t, s, tt.term); !reflect.DeepEqual(keys, tt.wkeys) {
+			t.Errorf("keys of %q = %v, want %v", tt.term, keys, tt.wkeys)
+		}
+	}
+
+	s.Put([]byte("a"), []byte("green"), lease.NoLease)
+	s.DeleteRange([]byte("b"), nil)
+	if keys := indexedKeys(t, s, "blue"); keys != nil {
+		t.Errorf("keys of blue = %v, want none", keys)
+	}
+	if keys := indexedKeys(t, s, "green"); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("keys of green = %v, want [a]", keys)
+	}
+
+	// a write txn reads its own writes.
+	tw := s.Write(traceutil.TODO())
+	tw.Put([]byte("e"), []byte("red"), lease.NoLease)
+	if keys := indexedKeys(t, tw, "red"); !reflect.DeepEqual(keys, []string{"c", "e"}) {
+		t.Errorf("keys of red in txn = %v, want [c e]", keys)
+	}
+	tw.End()
+
+	r, err := s.RangeByIndex(context.TODO(), tagsIndex.Name, []byte("red"), RangeOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.KVs) != 1 || string(r.KVs[0].Value) != "red,red" || r.Count != 2 || r.Rev != s.Rev() {
+		t.Errorf("limited range = %+v, want c of 2 at %d", r, s.Rev())
+	}
+	r, err = s.RangeByIndex(context.TODO(), tagsIndex.Name, []byte("red"), RangeOptions{Count: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.KVs) != 0 || r.Count != 2 {
+		t.Errorf("count = %d with %d kvs, want 2 without kvs", r.Count, len(r.KVs))
+	}
+
+	if _, err = s.RangeByIndex(context.TODO(), "size", []byte("red"), RangeOptions{}); !errors.Is(err, ErrUnknownIndex) {
+		t.Errorf("error = %v, want %v", err, ErrUnknownIndex)
+	}
+	if _, err = s.RangeByIndex(context.TODO(), tagsIndex.Name, []byte("red"), RangeOptions{Rev: 2}); !errors.Is(err, ErrIndexRev) {
+		t.Errorf("error = %v, want %v", err, ErrIndexRev)
+	}
+}
+
+func TestStoreSyncSecondaryIndexes(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	s.Put([]byte("a"), []byte("red"), lease.NoLease)
+	s.Put([]byte("b"), []byte("red,blue"), lease.NoLease)
+	s.Put([]byte("a"), []byte("blue"), lease.NoLease)
+	s.Put([]byte("c"), []byte("red"), lease.NoLease)
+	s.DeleteRange([]byte("c"), nil)
+	s.Close()
+
+	// an index registered after the keys were written is built.
+	s = NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{SecondaryIndexes: []SecondaryIndex{tagsIndex}})
+	if keys := indexedKeys(t, s, "blue"); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("keys of blue = %v, want [a b]", keys)
+	}
+	if keys := indexedKeys(t, s, "red"); !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Errorf("keys of red = %v, want [b]", keys)
+	}
+	s.Close()
+
+	// the entries of an index no longer registered are removed, since the
+	// index is not maintained.
+	s = NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{})
+	s.Put([]byte("d"), []byte("blue"), lease.NoLease)
+	s.Close()
+	tx := b.ReadTx()
+	tx.RLock()
+	keys, _ := tx.UnsafeRange(schema.SecondaryIndex, []byte{0}, []byte{0xff}, 0)
+	tx.RUnlock()
+	if len(keys) != 0 {
+		t.Errorf("entries of unregistered index = %q, want none", keys)
+	}
+
+	s = NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{SecondaryIndexes: []SecondaryIndex{tagsIndex}})
+	defer cleanup(s, b)
+	if keys := indexedKeys(t, s, "blue"); !reflect.DeepEqual(keys, []string{"a", "b", "d"}) {
+		t.Errorf("keys of blue = %v, want [a b d]", keys)
+	}
+}
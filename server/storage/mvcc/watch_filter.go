@@ -0,0 +1,115 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// WatchFilter selects the events of the watchers of a watch stream. Unlike a
+// FilterFunc, which filters the events as they are sent, it is evaluated
+// before the events are batched for a watcher, so the events it drops take
+// neither memory nor a revision of the batch of an unsynced watcher.
+type WatchFilter struct {
+	// ExcludePrefixes drops the events of the keys with any of the prefixes.
+	ExcludePrefixes [][]byte
+	// ValueChangedOnly drops the PUT events that do not change the value of
+	// an existing key, such as lease or version only updates.
+	ValueChangedOnly bool
+	// MinModRevision drops the events with a lower mod revision.
+	MinModRevision int64
+	// Predicate drops the events it returns false for, for example to
+	// select keys by the labels in their value. It must not modify the
+	// event and must be safe to call concurrently.
+	Predicate func(ev mvccpb.Event) bool
+}
+
+// prevValueFunc returns the value of the key of kv before its mod revision,
+// or false if the key did not exist or the value was compacted.
+type prevValueFunc func(kv *mvccpb.KeyValue) ([]byte, bool)
+
+// accept reports whether the event passes the filter. valueChanged is only
+// called if the filter needs it.
+func (f *WatchFilter) accept(ev mvccpb.Event, valueChanged func() bool) bool {
+	if ev.Kv.ModRevision < f.MinModRevision {
+		return false
+	}
+	for _, p := range f.ExcludePrefixes {
+		if bytes.HasPrefix(ev.Kv.Key, p) {
+			return false
+		}
+	}
+	if f.ValueChangedOnly && ev.Type == mvccpb.PUT && !valueChanged() {
+		return false
+	}
+	return f.Predicate == nil || f.Predicate(ev)
+}
+
+// acceptEvent reports whether the event passes the filters of the watcher.
+func (w *watcher) acceptEvent(ev mvccpb.Event, valueChanged func() bool) bool {
+	for i := range w.filters {
+		if !w.filters[i].accept(ev, valueChanged) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *watcher) needsValueChanges() bool {
+	for i := range w.filters {
+		if w.filters[i].ValueChangedOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// valueChanges tells whether the PUT events of a revision-ordered batch of
+// events change the value of their key. The value before an event is the
+// value of the last event of the key in the batch, if any, or is looked up
+// with prev.
+type valueChanges struct {
+	prev prevValueFunc
+	// last holds the value of the keys after the events seen so far.
+	last map[string][]byte
+}
+
+func newValueChanges(prev prevValueFunc) *valueChanges {
+	return &valueChanges{prev: prev, last: make(map[string][]byte)}
+}
+
+// changed reports whether the event changes the value of its key. It must be
+// called before the event is seen.
+func (vc *valueChanges) changed(ev mvccpb.Event) bool {
+	if ev.Kv.Version == 1 {
+		return true
+	}
+	old, ok := vc.last[string(ev.Kv.Key)]
+	if !ok && vc.prev != nil {
+		old, ok = vc.prev(ev.Kv)
+	}
+	return !ok || !bytes.Equal(old, ev.Kv.Value)
+}
+
+// see records the value of the key after the event.
+func (vc *valueChanges) see(ev mvccpb.Event) {
+	if ev.Type == mvccpb.DELETE {
+		delete(vc.last, string(ev.Kv.Key))
+		return
+	}
+	vc.last[string(ev.Kv.Key)] = ev.Kv.Value
+}
@@ -42,9 +42,10 @@ var (
 func ChanBufLen() int { return chanBufLen }
 
 type watchable interface {
-	watch(key, end []byte, startRev int64, id WatchID, ch chan<- WatchResponse, fcs ...FilterFunc) (*watcher, cancelFunc)
+	watch(key, end []byte, startRev int64, id WatchID, ch chan<- WatchResponse, filters []WatchFilter, limiter *watchStreamLimiter, fcs ...FilterFunc) (*watcher, cancelFunc)
 	progress(w *watcher)
 	progressAll(watchers map[WatchID]*watcher) bool
+	setProgressInterval(w *watcher, interval time.Duration)
 	rev() int64
 }
 
@@ -66,6 +67,9 @@ type watchableStore struct {
 	// The key of the map is the key that the watcher watches on.
 	synced watcherGroup
 
+	// progressWatchers are the watchers with a progress interval.
+	progressWatchers map[*watcher]struct{}
+
 	stopc chan struct{}
 	wg    sync.WaitGroup
 }
@@ -88,6 +92,8 @@ func newWatchableStore(lg *zap.Logger, b backend.Backend, le lease.Lessor, cfg S
 		unsynced: newWatcherGroup(),
 		synced:   newWatcherGroup(),
 		stopc:    make(chan struct{}),
+
+		progressWatchers: make(map[*watcher]struct{}),
 	}
 	s.store.ReadView = &readView{s}
 	s.store.WriteView = &writeView{s}
@@ -95,6 +101,8 @@ func newWatchableStore(lg *zap.Logger, b backend.Backend, le lease.Lessor, cfg S
 		// use this store as the deleter so revokes trigger watch events
 		s.le.SetRangeDeleter(func() lease.TxnDelete { return s.Write(traceutil.TODO()) })
 	}
+	// use this store as the deleter so key expiries trigger watch events
+	s.store.setKeyExpiryDeleter(func() TxnWrite { return s.Write(traceutil.TODO()) })
 	s.wg.Add(2)
 	go s.syncWatchersLoop()
 	go s.syncVictimsLoop()
@@ -107,24 +115,35 @@ func (s *watchableStore) Close() error {
 	return s.store.Close()
 }
 
-func (s *watchableStore) NewWatchStream() WatchStream {
+func (s *watchableStore) NewWatchStream(opts ...WatchStreamOption) WatchStream {
+	var cfg WatchStreamConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	watchStreamGauge.Inc()
-	return &watchStream{
+	ws := &watchStream{
 		watchable: s,
 		ch:        make(chan WatchResponse, chanBufLen),
+		filters:   cfg.Filters,
 		cancels:   make(map[WatchID]cancelFunc),
 		watchers:  make(map[WatchID]*watcher),
 	}
+	if cfg.MaxEventsPerSecond > 0 || cfg.CoalesceInterval > 0 {
+		ws.limiter = newWatchStreamLimiter(cfg, ws.ch)
+	}
+	return ws
 }
 
-func (s *watchableStore) watch(key, end []byte, startRev int64, id WatchID, ch chan<- WatchResponse, fcs ...FilterFunc) (*watcher, cancelFunc) {
+func (s *watchableStore) watch(key, end []byte, startRev int64, id WatchID, ch chan<- WatchResponse, filters []WatchFilter, limiter *watchStreamLimiter, fcs ...FilterFunc) (*watcher, cancelFunc) {
 	wa := &watcher{
-		key:    key,
-		end:    end,
-		minRev: startRev,
-		id:     id,
-		ch:     ch,
-		fcs:    fcs,
+		key:     key,
+		end:     end,
+		minRev:  startRev,
+		id:      id,
+		ch:      ch,
+		limiter: limiter,
+		filters: filters,
+		fcs:     fcs,
 	}
 
 	s.mu.Lock()
@@ -191,6 +210,7 @@ func (s *watchableStore) cancelWatcher(wa *watcher) {
 		time.Sleep(time.Millisecond)
 	}
 
+	delete(s.progressWatchers, wa)
 	wa.ch = nil
 	s.mu.Unlock()
 }
@@ -229,6 +249,7 @@ func (s *watchableStore) syncWatchersLoop() {
 		if lastUnsyncedWatchers > 0 {
 			unsyncedWatchers = s.syncWatchers()
 		}
+		s.notifyProgress(time.Now())
 		syncDuration := time.Since(st)
 
 		delayTicker.Reset(waitDuration)
@@ -364,13 +385,13 @@ func (s *watchableStore) syncWatchers() int {
 	tx.RLock()
 	revs, vs := tx.UnsafeRange(schema.Key, minBytes, maxBytes, 0)
 	evs := kvsToEvents(s.store.lg, wg, revs, vs)
-	// Must unlock after kvsToEvents, because vs (come from boltdb memory) is not deep copy.
+	wb := newWatcherBatch(wg, evs, s.prevValueFromTx(tx))
+	// Must unlock after kvsToEvents and newWatcherBatch, because vs (come from boltdb memory) is not deep copy.
 	// We can only unlock after Unmarshal, which will do deep copy.
 	// Otherwise we will trigger SIGSEGV during boltdb re-mmap.
 	tx.RUnlock()
 
 	victims := make(watcherBatch)
-	wb := newWatcherBatch(wg, evs)
 	for w := range wg.watchers {
 		if w.minRev < compactionRev {
 			// Skip the watcher that failed to send compacted watch response due to w.ch is full.
@@ -442,11 +463,31 @@ func kvsToEvents(lg *zap.Logger, wg *watcherGroup, revs, vals [][]byte) (evs []m
 	return evs
 }
 
+// prevValueFromTx looks up the values before the events read from tx with
+// the key index.
+func (s *watchableStore) prevValueFromTx(tx backend.UnsafeReader) prevValueFunc {
+	return func(kv *mvccpb.KeyValue) ([]byte, bool) {
+		rev, _, _, err := s.store.kvindex.Get(kv.Key, kv.ModRevision-1)
+		if err != nil {
+			return nil, false
+		}
+		_, vs := tx.UnsafeRange(schema.Key, RevToBytes(rev, NewRevBytes()), nil, 0)
+		if len(vs) != 1 {
+			return nil, false
+		}
+		var pkv mvccpb.KeyValue
+		if err := pkv.Unmarshal(vs[0]); err != nil {
+			return nil, false
+		}
+		return pkv.Value, true
+	}
+}
+
 // notify notifies the fact that given event at the given rev just happened to
 // watchers that watch on the key of the event.
-func (s *watchableStore) notify(rev int64, evs []mvccpb.Event) {
+func (s *watchableStore) notify(rev int64, evs []mvccpb.Event, prev prevValueFunc) {
 	victim := make(watcherBatch)
-	for w, eb := range newWatcherBatch(&s.synced, evs) {
+	for w, eb := range newWatcherBatch(&s.synced, evs, prev) {
 		if eb.revs != 1 {
 			s.store.lg.Panic(
 				"unexpected multiple revisions in watch notification",
@@ -514,6 +555,46 @@ func (s *watchableStore) progressIfSync(watchers map[WatchID]*watcher, responseW
 	return true
 }
 
+func (s *watchableStore) setProgressInterval(w *watcher, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.ch == nil {
+		// already canceled
+		return
+	}
+	w.progressInterval = interval
+	if interval <= 0 {
+		delete(s.progressWatchers, w)
+		return
+	}
+	w.progressNext = time.Now().Add(interval)
+	s.progressWatchers[w] = struct{}{}
+}
+
+// notifyProgress sends a progress notification to the synced watchers whose
+// progress interval has passed since the last one.
+func (s *watchableStore) notifyProgress(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.progressWatchers) == 0 {
+		return
+	}
+	rev := s.rev()
+	for w := range s.progressWatchers {
+		if now.Before(w.progressNext) {
+			continue
+		}
+		if _, ok := s.synced.watchers[w]; !ok {
+			// unsynced watchers are sent their progress once synced.
+			continue
+		}
+		// retry at the next sync if the watcher's channel is full.
+		if w.send(WatchResponse{WatchID: w.id, Revision: rev}) {
+			w.progressNext = now.Add(w.progressInterval)
+		}
+	}
+}
+
 type watcher struct {
 	// the watcher key
 	key []byte
@@ -539,10 +620,20 @@ type watcher struct {
 	minRev int64
 	id     WatchID
 
-	fcs []FilterFunc
+	// filters are the filters of the watch stream of the watcher.
+	filters []WatchFilter
+	fcs     []FilterFunc
 	// a chan to send out the watch response.
 	// The chan might be shared with other watchers.
 	ch chan<- WatchResponse
+	// limiter, if set, holds the watch responses to send them on ch.
+	limiter *watchStreamLimiter
+
+	// progressInterval is the interval between the progress notifications
+	// sent to the watcher while it is synced, zero if none.
+	progressInterval time.Duration
+	// progressNext is the time the next progress notification is due.
+	progressNext time.Time
 }
 
 func (w *watcher) send(wr WatchResponse) bool {
@@ -569,6 +660,9 @@ func (w *watcher) send(wr WatchResponse) bool {
 	if !progressEvent && len(wr.Events) == 0 {
 		return true
 	}
+	if w.limiter != nil {
+		return w.limiter.send(wr)
+	}
 	select {
 	case w.ch <- wr:
 		return true
@@ -15,6 +15,8 @@
 package mvcc
 
 import (
+	"context"
+
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/pkg/v3/traceutil"
 )
@@ -41,11 +43,21 @@ func (tw *watchableStoreTxnWrite) End() {
 	// end write txn under watchable store lock so the updates are visible
 	// when asynchronous event posting checks the current store revision
 	tw.s.mu.Lock()
-	tw.s.notify(rev, evs)
+	tw.s.notify(rev, evs, tw.prevValue)
 	tw.TxnWrite.End()
 	tw.s.mu.Unlock()
 }
 
+// prevValue looks up the values before the events of the txn, which are
+// still to be committed, as of the revision before the txn.
+func (tw *watchableStoreTxnWrite) prevValue(kv *mvccpb.KeyValue) ([]byte, bool) {
+	r, err := tw.TxnWrite.Range(context.TODO(), kv.Key, nil, RangeOptions{Rev: tw.Rev()})
+	if err != nil || len(r.KVs) == 0 {
+		return nil, false
+	}
+	return r.KVs[0].Value, true
+}
+
 type watchableStoreTxnWrite struct {
 	TxnWrite
 	s *watchableStore
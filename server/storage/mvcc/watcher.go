@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"errors"
 	"sync"
+	"time"
 
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -65,6 +66,14 @@ type WatchStream interface {
 	// true.
 	RequestProgressAll() bool
 
+	// SetProgressInterval makes the watcher with given ID be sent a progress
+	// notification every interval while it is synced, so that the revision
+	// the watcher can resume from advances even if its keys do not change.
+	// The notifications are checked for with the syncing of the watchers,
+	// every 100ms. A zero interval stops the notifications. If the watcher
+	// does not exist, an error will be returned.
+	SetProgressInterval(id WatchID, interval time.Duration) error
+
 	// Cancel cancels a watcher by giving its ID. If watcher does not exist, an error will be
 	// returned.
 	Cancel(id WatchID) error
@@ -99,6 +108,11 @@ type WatchResponse struct {
 type watchStream struct {
 	watchable watchable
 	ch        chan WatchResponse
+	// filters are applied to the events of every watcher of the stream.
+	filters []WatchFilter
+	// limiter, if set, holds the responses to send them on ch at the
+	// configured rate or interval.
+	limiter *watchStreamLimiter
 
 	mu sync.Mutex // guards fields below it
 	// nextID is the ID pre-allocated for next new watcher in this stream
@@ -132,7 +146,7 @@ func (ws *watchStream) Watch(id WatchID, key, end []byte, startRev int64, fcs ..
 		return -1, ErrWatcherDuplicateID
 	}
 
-	w, c := ws.watchable.watch(key, end, startRev, id, ws.ch, fcs...)
+	w, c := ws.watchable.watch(key, end, startRev, id, ws.ch, ws.filters, ws.limiter, fcs...)
 
 	ws.cancels[id] = c
 	ws.watchers[id] = w
@@ -176,6 +190,9 @@ func (ws *watchStream) Close() {
 		cancel()
 	}
 	ws.closed = true
+	if ws.limiter != nil {
+		ws.limiter.stop()
+	}
 	close(ws.ch)
 	watchStreamGauge.Dec()
 }
@@ -196,6 +213,17 @@ func (ws *watchStream) RequestProgress(id WatchID) {
 	ws.watchable.progress(w)
 }
 
+func (ws *watchStream) SetProgressInterval(id WatchID, interval time.Duration) error {
+	ws.mu.Lock()
+	w, ok := ws.watchers[id]
+	ws.mu.Unlock()
+	if !ok {
+		return ErrWatcherNotExist
+	}
+	ws.watchable.setProgressInterval(w, interval)
+	return nil
+}
+
 func (ws *watchStream) RequestProgressAll() bool {
 	ws.mu.Lock()
 	defer ws.mu.Unlock()
@@ -76,21 +76,40 @@ func (wb watcherBatch) add(w *watcher, ev mvccpb.Event) {
 	eb.add(ev)
 }
 
-// newWatcherBatch maps watchers to their matched events. It enables quick
-// events look up by watcher.
-func newWatcherBatch(wg *watcherGroup, evs []mvccpb.Event) watcherBatch {
+// newWatcherBatch maps watchers to their matched events that pass their
+// filters. It enables quick events look up by watcher. prev looks up the
+// values before the events for the filters selecting value changes.
+func newWatcherBatch(wg *watcherGroup, evs []mvccpb.Event, prev prevValueFunc) watcherBatch {
 	if len(wg.watchers) == 0 {
 		return nil
 	}
 
+	var vc *valueChanges
+	for w := range wg.watchers {
+		if w.needsValueChanges() {
+			vc = newValueChanges(prev)
+			break
+		}
+	}
+
 	wb := make(watcherBatch)
 	for _, ev := range evs {
+		changed, known := false, false
+		valueChanged := func() bool {
+			if !known {
+				changed, known = vc.changed(ev), true
+			}
+			return changed
+		}
 		for w := range wg.watcherSetByKey(string(ev.Kv.Key)) {
-			if ev.Kv.ModRevision >= w.minRev {
-				// don't double notify
+			// don't double notify
+			if ev.Kv.ModRevision >= w.minRev && w.acceptEvent(ev, valueChanged) {
 				wb.add(w, ev)
 			}
 		}
+		if vc != nil {
+			vc.see(ev)
+		}
 	}
 	return wb
 }
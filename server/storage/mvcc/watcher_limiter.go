@@ -0,0 +1,255 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// WatchStreamConfig configures a watch stream.
+type WatchStreamConfig struct {
+	// Filters select the events of every watcher of the stream.
+	Filters []WatchFilter
+	// MaxEventsPerSecond limits the rate at which the events are sent on the
+	// stream. Zero means no limit.
+	MaxEventsPerSecond int
+	// CoalesceInterval makes the stream send the events of its watchers
+	// every interval, with only the latest event of every key since the
+	// last send, instead of every event as it happens.
+	CoalesceInterval time.Duration
+}
+
+type WatchStreamOption func(*WatchStreamConfig)
+
+// WithWatchFilters adds filters selecting the events of every watcher of
+// the stream.
+func WithWatchFilters(filters ...WatchFilter) WatchStreamOption {
+	return func(cfg *WatchStreamConfig) {
+		cfg.Filters = append(cfg.Filters, filters...)
+	}
+}
+
+// WithMaxEventsPerSecond limits the rate at which the events are sent on the
+// stream.
+func WithMaxEventsPerSecond(n int) WatchStreamOption {
+	return func(cfg *WatchStreamConfig) {
+		cfg.MaxEventsPerSecond = n
+	}
+}
+
+// WithCoalesceInterval makes the stream send only the latest event of every
+// key every interval.
+func WithCoalesceInterval(d time.Duration) WatchStreamOption {
+	return func(cfg *WatchStreamConfig) {
+		cfg.CoalesceInterval = d
+	}
+}
+
+// maxPendingWatchResponses is the number of responses a stream limiter holds
+// before its watchers are considered slow.
+var maxPendingWatchResponses = chanBufLen
+
+// watchStreamLimiter holds the responses of the watchers of a stream to send
+// them at the configured rate or interval. Coalescing bounds the responses
+// held to the watched keys, so that the watchers of hot keys stay synced
+// however slow the stream is consumed.
+type watchStreamLimiter struct {
+	ch       chan<- WatchResponse
+	limiter  *rate.Limiter
+	interval time.Duration
+	coalesce bool
+
+	mu      sync.Mutex
+	pending []WatchResponse
+	// coalescing maps watchers to the index in pending of the response
+	// their events are coalesced into.
+	coalescing map[WatchID]int
+
+	notifyc chan struct{}
+	stopc   chan struct{}
+	donec   chan struct{}
+}
+
+func newWatchStreamLimiter(cfg WatchStreamConfig, ch chan<- WatchResponse) *watchStreamLimiter {
+	sl := &watchStreamLimiter{
+		ch:         ch,
+		interval:   cfg.CoalesceInterval,
+		coalesce:   cfg.CoalesceInterval > 0,
+		coalescing: make(map[WatchID]int),
+		notifyc:    make(chan struct{}, 1),
+		stopc:      make(chan struct{}),
+		donec:      make(chan struct{}),
+	}
+	if cfg.MaxEventsPerSecond > 0 {
+		sl.limiter = rate.NewLimiter(rate.Limit(cfg.MaxEventsPerSecond), cfg.MaxEventsPerSecond)
+	}
+	go sl.run()
+	return sl
+}
+
+// send holds wr to send it later. It returns false if too many responses are
+// held, the same way a watcher fails to send on a full channel.
+func (sl *watchStreamLimiter) send(wr WatchResponse) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.coalesce && len(wr.Events) != 0 && wr.CompactRevision == 0 {
+		if i, ok := sl.coalescing[wr.WatchID]; ok {
+			sl.pending[i] = coalesceWatchResponse(sl.pending[i], wr)
+			return true
+		}
+	}
+	if len(sl.pending) >= maxPendingWatchResponses {
+		return false
+	}
+	sl.pending = append(sl.pending, wr)
+	if !sl.coalesce {
+		select {
+		case sl.notifyc <- struct{}{}:
+		default:
+		}
+		return true
+	}
+	switch {
+	case len(wr.Events) != 0 && wr.CompactRevision == 0:
+		sl.coalescing[wr.WatchID] = len(sl.pending) - 1
+	case wr.WatchID == clientv3.InvalidWatchID:
+		// a progress notification of all the watchers must follow their
+		// events.
+		sl.coalescing = make(map[WatchID]int)
+	default:
+		delete(sl.coalescing, wr.WatchID)
+	}
+	return true
+}
+
+// coalesceWatchResponse merges the events of wr into held, keeping only the
+// latest event of every key.
+func coalesceWatchResponse(held, wr WatchResponse) WatchResponse {
+	evs := make([]mvccpb.Event, 0, len(held.Events)+len(wr.Events))
+	for _, ev := range held.Events {
+		superseded := false
+		for _, nev := range wr.Events {
+			if bytes.Equal(ev.Kv.Key, nev.Kv.Key) {
+				superseded = true
+				break
+			}
+		}
+		if !superseded {
+			evs = append(evs, ev)
+		}
+	}
+	for i, ev := range wr.Events {
+		superseded := false
+		for _, nev := range wr.Events[i+1:] {
+			if bytes.Equal(ev.Kv.Key, nev.Kv.Key) {
+				superseded = true
+				break
+			}
+		}
+		if !superseded {
+			evs = append(evs, ev)
+		}
+	}
+	held.Events = evs
+	held.Revision = wr.Revision
+	return held
+}
+
+func (sl *watchStreamLimiter) take() []WatchResponse {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	pending := sl.pending
+	sl.pending = nil
+	sl.coalescing = make(map[WatchID]int)
+	return pending
+}
+
+func (sl *watchStreamLimiter) run() {
+	defer close(sl.donec)
+	var tickc <-chan time.Time
+	if sl.coalesce {
+		t := time.NewTicker(sl.interval)
+		defer t.Stop()
+		tickc = t.C
+	}
+	for {
+		select {
+		case <-tickc:
+		case <-sl.notifyc:
+		case <-sl.stopc:
+			return
+		}
+		for _, wr := range sl.take() {
+			if !sl.wait(len(wr.Events)) {
+				return
+			}
+			select {
+			case sl.ch <- wr:
+			case <-sl.stopc:
+				return
+			}
+		}
+	}
+}
+
+// wait waits until n events may be sent. It returns false if the limiter is
+// stopped.
+func (sl *watchStreamLimiter) wait(n int) bool {
+	delay := sl.reserve(time.Now(), n)
+	if delay == 0 {
+		return true
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-sl.stopc:
+		return false
+	}
+}
+
+// reserve reserves n events at now and returns how long to wait before
+// sending them.
+func (sl *watchStreamLimiter) reserve(now time.Time, n int) time.Duration {
+	if sl.limiter == nil {
+		return 0
+	}
+	// a response may hold more events than the burst, which are reserved
+	// in turn so that the last reservation covers them all.
+	var delay time.Duration
+	for n > 0 {
+		m := n
+		if b := sl.limiter.Burst(); m > b {
+			m = b
+		}
+		delay = sl.limiter.ReserveN(now, m).DelayFrom(now)
+		n -= m
+	}
+	return delay
+}
+
+// stop stops sending the held responses, which are dropped.
+func (sl *watchStreamLimiter) stop() {
+	close(sl.stopc)
+	<-sl.donec
+}
@@ -16,6 +16,7 @@ package mvcc
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
@@ -26,6 +27,7 @@ import (
 
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/pkg/v3/traceutil"
 	"go.etcd.io/etcd/server/v3/lease"
 	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
 )
@@ -343,6 +345,68 @@ func TestWatcherRequestProgress(t *testing.T) {
 	}
 }
 
+func TestWatcherProgressInterval(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+
+	// manually create watchableStore instead of newWatchableStore
+	// so that the progress notifications are only sent by notifyProgress.
+	s := &watchableStore{
+		store:            NewStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{}),
+		unsynced:         newWatcherGroup(),
+		synced:           newWatcherGroup(),
+		stopc:            make(chan struct{}),
+		progressWatchers: make(map[*watcher]struct{}),
+	}
+
+	defer cleanup(s, b)
+
+	s.Put([]byte("foo"), []byte("bar"), lease.NoLease)
+
+	w := s.NewWatchStream()
+
+	if err := w.SetProgressInterval(WatchID(1000), time.Second); !errors.Is(err, ErrWatcherNotExist) {
+		t.Fatalf("error = %v, want %v", err, ErrWatcherNotExist)
+	}
+
+	unsyncedID, _ := w.Watch(0, []byte("foo"), nil, 1)
+	syncedID, _ := w.Watch(0, []byte("bar"), nil, 0)
+	for _, id := range []WatchID{unsyncedID, syncedID} {
+		if err := w.SetProgressInterval(id, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	s.notifyProgress(now)
+	s.notifyProgress(now.Add(2 * time.Minute))
+	wrs := WatchResponse{WatchID: syncedID, Revision: 2}
+	select {
+	case resp := <-w.Chan():
+		if !reflect.DeepEqual(resp, wrs) {
+			t.Fatalf("got %+v, expect %+v", resp, wrs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive progress")
+	}
+	// the next notification is due an interval after the last one.
+	s.notifyProgress(now.Add(2*time.Minute + time.Second))
+	// notifications stop with a zero interval.
+	w.SetProgressInterval(syncedID, 0)
+	s.notifyProgress(now.Add(time.Hour))
+	select {
+	case resp := <-w.Chan():
+		t.Fatalf("unexpected %+v", resp)
+	default:
+	}
+	if len(s.progressWatchers) != 1 {
+		t.Errorf("progress watchers = %d, want 1", len(s.progressWatchers))
+	}
+	w.Close()
+	if len(s.progressWatchers) != 0 {
+		t.Errorf("progress watchers = %d, want 0 after close", len(s.progressWatchers))
+	}
+}
+
 func TestWatcherRequestProgressAll(t *testing.T) {
 	b, _ := betesting.NewDefaultTmpBackend(t)
 
@@ -428,3 +492,143 @@ func TestWatcherWatchWithFilter(t *testing.T) {
 		t.Fatal("failed to receive delete request")
 	}
 }
+
+func TestWatchStreamFilters(t *testing.T) {
+	filter := WatchFilter{
+		ExcludePrefixes:  [][]byte{[]byte("/a/skip/")},
+		ValueChangedOnly: true,
+		MinModRevision:   3,
+		Predicate: func(ev mvccpb.Event) bool {
+			return !bytes.Equal(ev.Kv.Value, []byte("drop"))
+		},
+	}
+	type event struct {
+		typ mvccpb.Event_EventType
+		key string
+		rev int64
+	}
+	wevs := []event{
+		{mvccpb.PUT, "/a/x", 5},
+		{mvccpb.PUT, "/a/z", 6},
+		{mvccpb.DELETE, "/a/x", 7},
+		{mvccpb.PUT, "/a/end", 9},
+	}
+	write := func(s WatchableKV) {
+		// rev 2 is before MinModRevision, rev 3 does not change the value.
+		s.Put([]byte("/a/x"), []byte("v1"), lease.NoLease)
+		s.Put([]byte("/a/x"), []byte("v1"), lease.NoLease)
+		s.Put([]byte("/a/skip/y"), []byte("v1"), lease.NoLease)
+		s.Put([]byte("/a/x"), []byte("v2"), lease.NoLease)
+		// the second put of the txn does not change the value.
+		txn := s.Write(traceutil.TODO())
+		txn.Put([]byte("/a/z"), []byte("label=on"), lease.NoLease)
+		txn.Put([]byte("/a/z"), []byte("label=on"), lease.NoLease)
+		txn.End()
+		s.DeleteRange([]byte("/a/x"), nil)
+		s.Put([]byte("/a/w"), []byte("drop"), lease.NoLease)
+		s.Put([]byte("/a/end"), []byte("v1"), lease.NoLease)
+	}
+
+	for _, synced := range []bool{true, false} {
+		t.Run(fmt.Sprintf("synced=%v", synced), func(t *testing.T) {
+			b, _ := betesting.NewDefaultTmpBackend(t)
+			s := WatchableKV(newWatchableStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{}))
+			defer cleanup(s, b)
+
+			w := s.NewWatchStream(WithWatchFilters(filter))
+			defer w.Close()
+			if synced {
+				w.Watch(0, []byte("/a/"), []byte("/a0"), 0)
+				write(s)
+			} else {
+				write(s)
+				w.Watch(0, []byte("/a/"), []byte("/a0"), 1)
+			}
+
+			var evs []event
+			for len(evs) == 0 || evs[len(evs)-1].key != "/a/end" {
+				select {
+				case resp := <-w.Chan():
+					for _, ev := range resp.Events {
+						evs = append(evs, event{ev.Type, string(ev.Kv.Key), ev.Kv.ModRevision})
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timed out waiting for events, got %v", evs)
+				}
+			}
+			if !reflect.DeepEqual(evs, wevs) {
+				t.Errorf("events = %v, want %v", evs, wevs)
+			}
+		})
+	}
+}
+
+func TestWatchStreamLimiterCoalesce(t *testing.T) {
+	ch := make(chan WatchResponse, 1)
+	sl := newWatchStreamLimiter(WatchStreamConfig{CoalesceInterval: time.Hour}, ch)
+	defer sl.stop()
+
+	put := func(key, value string, rev int64) mvccpb.Event {
+		return mvccpb.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: rev}}
+	}
+	sl.send(WatchResponse{WatchID: 1, Events: []mvccpb.Event{put("foo", "v1", 2)}, Revision: 2})
+	sl.send(WatchResponse{WatchID: 2, Events: []mvccpb.Event{put("foo", "v1", 2)}, Revision: 2})
+	sl.send(WatchResponse{WatchID: 1, Events: []mvccpb.Event{put("bar", "v1", 3), put("foo", "v2", 3), put("foo", "v3", 3)}, Revision: 3})
+	// a progress notification is sent after the events it covers.
+	sl.send(WatchResponse{WatchID: 1, Revision: 3})
+	sl.send(WatchResponse{WatchID: 1, Events: []mvccpb.Event{put("foo", "v4", 4)}, Revision: 4})
+	sl.send(WatchResponse{WatchID: 1, Events: []mvccpb.Event{put("foo", "v5", 5)}, Revision: 5})
+
+	wrs := []WatchResponse{
+		{WatchID: 1, Events: []mvccpb.Event{put("bar", "v1", 3), put("foo", "v3", 3)}, Revision: 3},
+		{WatchID: 2, Events: []mvccpb.Event{put("foo", "v1", 2)}, Revision: 2},
+		{WatchID: 1, Revision: 3},
+		{WatchID: 1, Events: []mvccpb.Event{put("foo", "v5", 5)}, Revision: 5},
+	}
+	if got := sl.take(); !reflect.DeepEqual(got, wrs) {
+		t.Errorf("responses = %+v, want %+v", got, wrs)
+	}
+}
+
+func TestWatchStreamLimiterRate(t *testing.T) {
+	ch := make(chan WatchResponse, 1)
+	sl := newWatchStreamLimiter(WatchStreamConfig{MaxEventsPerSecond: 10}, ch)
+	defer sl.stop()
+
+	now := time.Now()
+	if d := sl.reserve(now, 4); d != 0 {
+		t.Errorf("delay of 4 events = %v, want 0", d)
+	}
+	// 6 events are left in the burst, the 19 others take 1.9s.
+	if d := sl.reserve(now, 25); d != 1900*time.Millisecond {
+		t.Errorf("delay of 25 events = %v, want 1.9s", d)
+	}
+}
+
+func TestWatchStreamCoalesce(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	s := WatchableKV(newWatchableStore(zaptest.NewLogger(t), b, &lease.FakeLessor{}, StoreConfig{}))
+	defer cleanup(s, b)
+
+	w := s.NewWatchStream(WithCoalesceInterval(10*time.Millisecond), WithMaxEventsPerSecond(1000))
+	defer w.Close()
+	w.Watch(0, []byte("foo"), nil, 0)
+
+	for i := 0; i < 100; i++ {
+		s.Put([]byte("foo"), []byte(fmt.Sprintf("v%d", i)), lease.NoLease)
+	}
+	for {
+		select {
+		case resp := <-w.Chan():
+			// the events of a key are coalesced into its latest event.
+			if len(resp.Events) != 1 {
+				t.Fatalf("received %d events of foo, want 1", len(resp.Events))
+			}
+			if string(resp.Events[0].Kv.Value) == "v99" {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the last value")
+		}
+	}
+}
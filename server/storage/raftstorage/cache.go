@@ -0,0 +1,80 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstorage
+
+import (
+	"container/list"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// entryCache is an LRU cache of raft log entries by index, bounded by the
+// total size of the entries.
+type entryCache struct {
+	maxBytes int
+	bytes    int
+	// ll holds the entries, the most recently used first.
+	ll      *list.List
+	entries map[uint64]*list.Element
+}
+
+func newEntryCache(maxBytes int) *entryCache {
+	return &entryCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  make(map[uint64]*list.Element),
+	}
+}
+
+func (c *entryCache) get(i uint64) (raftpb.Entry, bool) {
+	e, ok := c.entries[i]
+	if !ok {
+		return raftpb.Entry{}, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(raftpb.Entry), true
+}
+
+// add caches ent, evicting the least recently used entries to stay within
+// the size bound. Entries larger than the bound are not cached.
+func (c *entryCache) add(ent raftpb.Entry) {
+	size := ent.Size()
+	if size > c.maxBytes {
+		return
+	}
+	if e, ok := c.entries[ent.Index]; ok {
+		c.remove(e)
+	}
+	c.entries[ent.Index] = c.ll.PushFront(ent)
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+// removeRange removes the cached entries in the range [lo, hi].
+func (c *entryCache) removeRange(lo, hi uint64) {
+	for i, e := range c.entries {
+		if i >= lo && i <= hi {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *entryCache) remove(e *list.Element) {
+	ent := c.ll.Remove(e).(raftpb.Entry)
+	delete(c.entries, ent.Index)
+	c.bytes -= ent.Size()
+}
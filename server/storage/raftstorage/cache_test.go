@@ -0,0 +1,51 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstorage
+
+import (
+	"testing"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+func TestEntryCache(t *testing.T) {
+	ent := func(i uint64) raftpb.Entry { return raftpb.Entry{Index: i, Term: 1, Data: []byte("data")} }
+	e := ent(1)
+	size := e.Size()
+	c := newEntryCache(3 * size)
+
+	for i := uint64(1); i <= 3; i++ {
+		c.add(ent(i))
+	}
+	// 1 becomes the most recently used, so 2 is evicted by 4.
+	c.get(1)
+	c.add(ent(4))
+	for i, wok := range map[uint64]bool{1: true, 2: false, 3: true, 4: true} {
+		if _, ok := c.get(i); ok != wok {
+			t.Errorf("cached %d = %v, want %v", i, ok, wok)
+		}
+	}
+
+	c.removeRange(3, 10)
+	if _, ok := c.get(4); ok || c.bytes != size {
+		t.Errorf("cache holds %d bytes, want only entry 1 of %d bytes", c.bytes, size)
+	}
+
+	// entries larger than the cache are not cached.
+	c.add(raftpb.Entry{Index: 5, Data: make([]byte, 3*size)})
+	if _, ok := c.get(5); ok {
+		t.Errorf("cached an entry larger than the cache")
+	}
+}
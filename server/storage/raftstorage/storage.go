@@ -0,0 +1,385 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package raftstorage implements a raft.Storage keeping the raft log on disk.
+package raftstorage
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+var (
+	hardStateKeyName = []byte("hardState")
+	snapshotKeyName  = []byte("snapshot")
+	// offsetKeyName holds the index and the term of the last entry
+	// compacted or covered by the snapshot, like the dummy entry of
+	// raft.MemoryStorage.
+	offsetKeyName    = []byte("offset")
+	lastIndexKeyName = []byte("lastIndex")
+)
+
+// entryReadBatch is the number of entries read from the backend at a time
+// on cache misses.
+const entryReadBatch = 64
+
+// OnDiskStorage is a raft.Storage keeping the raft log entries, the hard
+// state and the snapshot in a backend, rather than in memory as
+// raft.MemoryStorage does. Only the most recently used entries are kept in
+// memory, in a cache bounded by their size. Its methods are the same as
+// raft.MemoryStorage's, the writes being committed to the backend before
+// they return.
+type OnDiskStorage struct {
+	lg *zap.Logger
+	be backend.Backend
+
+	mu        sync.Mutex
+	hardState raftpb.HardState
+	// snapMeta is the metadata of the snapshot, which is read from the
+	// backend with its data only when asked for.
+	snapMeta   raftpb.SnapshotMetadata
+	offset     uint64
+	offsetTerm uint64
+	lastIndex  uint64
+	cache      *entryCache
+}
+
+// NewOnDiskStorage returns the raft storage kept in be, caching up to
+// cacheBytes of entries. An empty backend holds an empty raft log.
+func NewOnDiskStorage(lg *zap.Logger, be backend.Backend, cacheBytes int) *OnDiskStorage {
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	s := &OnDiskStorage{lg: lg, be: be, cache: newEntryCache(cacheBytes)}
+
+	tx := be.BatchTx()
+	tx.LockOutsideApply()
+	tx.UnsafeCreateBucket(schema.RaftEntry)
+	tx.UnsafeCreateBucket(schema.RaftState)
+	if _, vs := tx.UnsafeRange(schema.RaftState, hardStateKeyName, nil, 0); len(vs) == 1 {
+		s.mustUnmarshal(&s.hardState, vs[0])
+	}
+	if _, vs := tx.UnsafeRange(schema.RaftState, snapshotKeyName, nil, 0); len(vs) == 1 {
+		var snap raftpb.Snapshot
+		s.mustUnmarshal(&snap, vs[0])
+		s.snapMeta = snap.Metadata
+	}
+	if _, vs := tx.UnsafeRange(schema.RaftState, offsetKeyName, nil, 0); len(vs) == 1 {
+		s.offset = binary.BigEndian.Uint64(vs[0])
+		s.offsetTerm = binary.BigEndian.Uint64(vs[0][8:])
+	}
+	s.lastIndex = s.offset
+	if _, vs := tx.UnsafeRange(schema.RaftState, lastIndexKeyName, nil, 0); len(vs) == 1 {
+		s.lastIndex = binary.BigEndian.Uint64(vs[0])
+	}
+	tx.Unlock()
+	be.ForceCommit()
+	return s
+}
+
+func (s *OnDiskStorage) InitialState() (raftpb.HardState, raftpb.ConfState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hardState, s.snapMeta.ConfState, nil
+}
+
+// SetHardState saves the current HardState.
+func (s *OnDiskStorage) SetHardState(st raftpb.HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write(func(tx backend.UnsafeWriter) {
+		tx.UnsafePut(schema.RaftState, hardStateKeyName, s.mustMarshal(&st))
+	})
+	s.hardState = st
+	return nil
+}
+
+func (s *OnDiskStorage) Entries(lo, hi, maxSize uint64) ([]raftpb.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lo <= s.offset {
+		return nil, raft.ErrCompacted
+	}
+	if hi > s.lastIndex+1 {
+		s.lg.Panic("entries' hi is out of bound", zap.Uint64("hi", hi), zap.Uint64("last-index", s.lastIndex))
+	}
+	// only contains the dummy entry.
+	if s.lastIndex == s.offset {
+		return nil, raft.ErrUnavailable
+	}
+
+	var ents []raftpb.Entry
+	var size uint64
+	for i := lo; i < hi; {
+		var batch []raftpb.Entry
+		if ent, ok := s.cache.get(i); ok {
+			batch = []raftpb.Entry{ent}
+		} else {
+			batch = s.readEntries(i, min(hi, i+entryReadBatch))
+		}
+		for _, ent := range batch {
+			size += uint64(ent.Size())
+			// at least one entry is returned, even if larger than maxSize.
+			if len(ents) > 0 && size > maxSize {
+				return ents, nil
+			}
+			ents = append(ents, ent)
+		}
+		i += uint64(len(batch))
+	}
+	return ents, nil
+}
+
+// readEntries reads the entries in [lo, hi) from the backend, and caches
+// them.
+func (s *OnDiskStorage) readEntries(lo, hi uint64) []raftpb.Entry {
+	tx := s.be.BatchTx()
+	tx.LockOutsideApply()
+	defer tx.Unlock()
+	_, vs := tx.UnsafeRange(schema.RaftEntry, entryKey(lo), entryKey(hi), 0)
+	if uint64(len(vs)) != hi-lo {
+		s.lg.Panic("missing raft log entries", zap.Uint64("lo", lo), zap.Uint64("hi", hi), zap.Int("found", len(vs)))
+	}
+	// the values are only valid in the tx, the entries are copied out.
+	ents := make([]raftpb.Entry, len(vs))
+	for i, v := range vs {
+		s.mustUnmarshal(&ents[i], v)
+		s.cache.add(ents[i])
+	}
+	return ents
+}
+
+func (s *OnDiskStorage) Term(i uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.term(i)
+}
+
+func (s *OnDiskStorage) term(i uint64) (uint64, error) {
+	switch {
+	case i < s.offset:
+		return 0, raft.ErrCompacted
+	case i == s.offset:
+		return s.offsetTerm, nil
+	case i > s.lastIndex:
+		return 0, raft.ErrUnavailable
+	}
+	if ent, ok := s.cache.get(i); ok {
+		return ent.Term, nil
+	}
+	return s.readEntries(i, i+1)[0].Term, nil
+}
+
+func (s *OnDiskStorage) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastIndex, nil
+}
+
+func (s *OnDiskStorage) FirstIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset + 1, nil
+}
+
+func (s *OnDiskStorage) Snapshot() (raftpb.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var snap raftpb.Snapshot
+	tx := s.be.BatchTx()
+	tx.LockOutsideApply()
+	defer tx.Unlock()
+	_, vs := tx.UnsafeRange(schema.RaftState, snapshotKeyName, nil, 0)
+	if len(vs) == 1 {
+		s.mustUnmarshal(&snap, vs[0])
+	}
+	return snap, nil
+}
+
+// ApplySnapshot overwrites the contents of the storage with the given
+// snapshot.
+func (s *OnDiskStorage) ApplySnapshot(snap raftpb.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapMeta.Index >= snap.Metadata.Index {
+		return raft.ErrSnapOutOfDate
+	}
+
+	s.write(func(tx backend.UnsafeWriter) {
+		s.unsafeDeleteEntries(tx, s.offset+1, s.lastIndex)
+		tx.UnsafePut(schema.RaftState, snapshotKeyName, s.mustMarshal(&snap))
+		s.unsafeSetOffset(tx, snap.Metadata.Index, snap.Metadata.Term)
+		s.unsafeSetLastIndex(tx, snap.Metadata.Index)
+	})
+	s.snapMeta = snap.Metadata
+	return nil
+}
+
+// CreateSnapshot makes a snapshot which can be retrieved with Snapshot()
+// and can be used to reconstruct the state at that point. If any
+// configuration changes have been made since the last compaction, the
+// result of the last ApplyConfChange must be passed in.
+func (s *OnDiskStorage) CreateSnapshot(i uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i <= s.snapMeta.Index {
+		return raftpb.Snapshot{}, raft.ErrSnapOutOfDate
+	}
+	if i > s.lastIndex {
+		s.lg.Panic("snapshot is out of bound", zap.Uint64("index", i), zap.Uint64("last-index", s.lastIndex))
+	}
+
+	term, err := s.term(i)
+	if err != nil {
+		return raftpb.Snapshot{}, err
+	}
+	snap := raftpb.Snapshot{Data: data, Metadata: s.snapMeta}
+	snap.Metadata.Index = i
+	snap.Metadata.Term = term
+	if cs != nil {
+		snap.Metadata.ConfState = *cs
+	}
+	s.write(func(tx backend.UnsafeWriter) {
+		tx.UnsafePut(schema.RaftState, snapshotKeyName, s.mustMarshal(&snap))
+	})
+	s.snapMeta = snap.Metadata
+	return snap, nil
+}
+
+// Compact discards all log entries prior to compactIndex.
+func (s *OnDiskStorage) Compact(compactIndex uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if compactIndex <= s.offset {
+		return raft.ErrCompacted
+	}
+	if compactIndex > s.lastIndex {
+		s.lg.Panic("compact is out of bound", zap.Uint64("index", compactIndex), zap.Uint64("last-index", s.lastIndex))
+	}
+
+	term, err := s.term(compactIndex)
+	if err != nil {
+		return err
+	}
+	s.write(func(tx backend.UnsafeWriter) {
+		s.unsafeDeleteEntries(tx, s.offset+1, compactIndex)
+		s.unsafeSetOffset(tx, compactIndex, term)
+	})
+	return nil
+}
+
+// Append appends the new entries to storage, replacing the conflicting
+// entries after the first of them.
+func (s *OnDiskStorage) Append(entries []raftpb.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := s.offset + 1
+	last := entries[0].Index + uint64(len(entries)) - 1
+
+	// shortcut if there is no new entry.
+	if last < first {
+		return nil
+	}
+	// truncate compacted entries
+	if first > entries[0].Index {
+		entries = entries[first-entries[0].Index:]
+	}
+	if entries[0].Index > s.lastIndex+1 {
+		s.lg.Panic("missing log entry", zap.Uint64("last-index", s.lastIndex), zap.Uint64("append-at", entries[0].Index))
+	}
+
+	s.write(func(tx backend.UnsafeWriter) {
+		s.unsafeDeleteEntries(tx, entries[0].Index, s.lastIndex)
+		for i := range entries {
+			tx.UnsafeSeqPut(schema.RaftEntry, entryKey(entries[i].Index), s.mustMarshal(&entries[i]))
+		}
+		s.unsafeSetLastIndex(tx, last)
+	})
+	for _, ent := range entries {
+		s.cache.add(ent)
+	}
+	return nil
+}
+
+// write runs f in a batch tx, and commits it to the backend.
+func (s *OnDiskStorage) write(f func(tx backend.UnsafeWriter)) {
+	tx := s.be.BatchTx()
+	tx.LockOutsideApply()
+	f(tx)
+	tx.Unlock()
+	s.be.ForceCommit()
+}
+
+// unsafeDeleteEntries deletes the entries in [lo, hi] from the backend and
+// the cache.
+func (s *OnDiskStorage) unsafeDeleteEntries(tx backend.UnsafeWriter, lo, hi uint64) {
+	for i := lo; i <= hi; i++ {
+		tx.UnsafeDelete(schema.RaftEntry, entryKey(i))
+	}
+	s.cache.removeRange(lo, hi)
+}
+
+func (s *OnDiskStorage) unsafeSetOffset(tx backend.UnsafeWriter, index, term uint64) {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, index)
+	binary.BigEndian.PutUint64(b[8:], term)
+	tx.UnsafePut(schema.RaftState, offsetKeyName, b)
+	s.offset, s.offsetTerm = index, term
+}
+
+func (s *OnDiskStorage) unsafeSetLastIndex(tx backend.UnsafeWriter, index uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, index)
+	tx.UnsafePut(schema.RaftState, lastIndexKeyName, b)
+	s.lastIndex = index
+}
+
+type marshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type unmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+func (s *OnDiskStorage) mustMarshal(m marshaler) []byte {
+	b, err := m.Marshal()
+	if err != nil {
+		s.lg.Panic("failed to marshal raft storage", zap.Error(err))
+	}
+	return b
+}
+
+func (s *OnDiskStorage) mustUnmarshal(m unmarshaler, b []byte) {
+	if err := m.Unmarshal(b); err != nil {
+		s.lg.Panic("failed to unmarshal raft storage", zap.Error(err))
+	}
+}
+
+func entryKey(index uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, index)
+	return b
+}
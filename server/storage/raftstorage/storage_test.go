@@ -0,0 +1,308 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstorage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	betesting "go.etcd.io/etcd/server/v3/storage/backend/testing"
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// newTestStorage returns a storage holding ents after a snapshot at the
+// first of them, like a raft.MemoryStorage.
+func newTestStorage(t *testing.T, cacheBytes int, ents []raftpb.Entry) (*OnDiskStorage, backend.Backend) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	t.Cleanup(func() { betesting.Close(t, b) })
+	s := NewOnDiskStorage(zaptest.NewLogger(t), b, cacheBytes)
+	if err := s.ApplySnapshot(raftpb.Snapshot{Metadata: raftpb.SnapshotMetadata{Index: ents[0].Index, Term: ents[0].Term}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(ents[1:]); err != nil {
+		t.Fatal(err)
+	}
+	return s, b
+}
+
+func TestOnDiskStorageEntries(t *testing.T) {
+	ents := []raftpb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 6}}
+	size := uint64(ents[1].Size())
+	tests := []struct {
+		lo, hi, maxsize uint64
+		werr            error
+		wentries        []raftpb.Entry
+	}{
+		{2, 6, ^uint64(0), raft.ErrCompacted, nil},
+		{3, 4, ^uint64(0), raft.ErrCompacted, nil},
+		{4, 5, ^uint64(0), nil, ents[1:2]},
+		{4, 7, ^uint64(0), nil, ents[1:4]},
+		// even if maxsize is zero, the first entry should be returned
+		{4, 7, 0, nil, ents[1:2]},
+		{4, 7, 2 * size, nil, ents[1:3]},
+		{4, 7, 2*size + 1, nil, ents[1:3]},
+		{4, 7, 3 * size, nil, ents[1:4]},
+	}
+	for _, cacheBytes := range []int{0, 1024} {
+		s, _ := newTestStorage(t, cacheBytes, ents)
+		for i, tt := range tests {
+			entries, err := s.Entries(tt.lo, tt.hi, tt.maxsize)
+			if !errors.Is(err, tt.werr) {
+				t.Errorf("#%d: cache %d: err = %v, want %v", i, cacheBytes, err, tt.werr)
+			}
+			if !reflect.DeepEqual(entries, tt.wentries) {
+				t.Errorf("#%d: cache %d: entries = %v, want %v", i, cacheBytes, entries, tt.wentries)
+			}
+		}
+	}
+}
+
+func TestOnDiskStorageTerm(t *testing.T) {
+	ents := []raftpb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}
+	s, _ := newTestStorage(t, 0, ents)
+	tests := []struct {
+		i     uint64
+		werr  error
+		wterm uint64
+	}{
+		{2, raft.ErrCompacted, 0},
+		{3, nil, 3},
+		{4, nil, 4},
+		{5, nil, 5},
+		{6, raft.ErrUnavailable, 0},
+	}
+	for i, tt := range tests {
+		term, err := s.Term(tt.i)
+		if !errors.Is(err, tt.werr) || term != tt.wterm {
+			t.Errorf("#%d: term = %d, %v, want %d, %v", i, term, err, tt.wterm, tt.werr)
+		}
+	}
+}
+
+func TestOnDiskStorageAppend(t *testing.T) {
+	ents := []raftpb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}
+	tests := []struct {
+		entries  []raftpb.Entry
+		wentries []raftpb.Entry
+	}{
+		{
+			[]raftpb.Entry{{Index: 1, Term: 1}, {Index: 2, Term: 2}},
+			[]raftpb.Entry{{Index: 4, Term: 4}, {Index: 5, Term: 5}},
+		},
+		{
+			[]raftpb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 6}, {Index: 5, Term: 6}},
+			[]raftpb.Entry{{Index: 4, Term: 6}, {Index: 5, Term: 6}},
+		},
+		{
+			[]raftpb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 5}},
+			[]raftpb.Entry{{Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 5}},
+		},
+		// truncate incoming entries, truncate the existing entries and append
+		{
+			[]raftpb.Entry{{Index: 2, Term: 3}, {Index: 3, Term: 3}, {Index: 4, Term: 5}},
+			[]raftpb.Entry{{Index: 4, Term: 5}},
+		},
+		// truncate the existing entries and append
+		{
+			[]raftpb.Entry{{Index: 4, Term: 5}},
+			[]raftpb.Entry{{Index: 4, Term: 5}},
+		},
+		// direct append
+		{
+			[]raftpb.Entry{{Index: 6, Term: 5}},
+			[]raftpb.Entry{{Index: 4, Term: 4}, {Index: 5, Term: 5}, {Index: 6, Term: 5}},
+		},
+	}
+	for i, tt := range tests {
+		s, _ := newTestStorage(t, 1024, ents)
+		if err := s.Append(tt.entries); err != nil {
+			t.Fatalf("#%d: err = %v", i, err)
+		}
+		last, _ := s.LastIndex()
+		entries, err := s.Entries(4, last+1, ^uint64(0))
+		if err != nil {
+			t.Fatalf("#%d: err = %v", i, err)
+		}
+		if !reflect.DeepEqual(entries, tt.wentries) {
+			t.Errorf("#%d: entries = %v, want %v", i, entries, tt.wentries)
+		}
+	}
+}
+
+func TestOnDiskStorageCompact(t *testing.T) {
+	ents := []raftpb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}
+	tests := []struct {
+		i      uint64
+		werr   error
+		wfirst uint64
+		wterm  uint64
+	}{
+		{2, raft.ErrCompacted, 4, 3},
+		{3, raft.ErrCompacted, 4, 3},
+		{4, nil, 5, 4},
+		{5, nil, 6, 5},
+	}
+	for i, tt := range tests {
+		s, _ := newTestStorage(t, 1024, ents)
+		if err := s.Compact(tt.i); !errors.Is(err, tt.werr) {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		first, _ := s.FirstIndex()
+		term, _ := s.Term(first - 1)
+		if first != tt.wfirst || term != tt.wterm {
+			t.Errorf("#%d: first index = %d at term %d, want %d at term %d", i, first, term, tt.wfirst, tt.wterm)
+		}
+		if _, err := s.Entries(first-1, first, ^uint64(0)); !errors.Is(err, raft.ErrCompacted) {
+			t.Errorf("#%d: entries before the first index err = %v, want %v", i, err, raft.ErrCompacted)
+		}
+	}
+}
+
+func TestOnDiskStorageSnapshot(t *testing.T) {
+	ents := []raftpb.Entry{{Index: 3, Term: 3}, {Index: 4, Term: 4}, {Index: 5, Term: 5}}
+	cs := &raftpb.ConfState{Voters: []uint64{1, 2, 3}}
+	data := []byte("data")
+
+	s, _ := newTestStorage(t, 0, ents)
+	if _, err := s.CreateSnapshot(3, cs, data); !errors.Is(err, raft.ErrSnapOutOfDate) {
+		t.Errorf("create snapshot err = %v, want %v", err, raft.ErrSnapOutOfDate)
+	}
+	snap, err := s.CreateSnapshot(4, cs, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsnap := raftpb.Snapshot{Data: data, Metadata: raftpb.SnapshotMetadata{Index: 4, Term: 4, ConfState: *cs}}
+	if !reflect.DeepEqual(snap, wsnap) {
+		t.Errorf("snapshot = %+v, want %+v", snap, wsnap)
+	}
+	if snap, _ = s.Snapshot(); !reflect.DeepEqual(snap, wsnap) {
+		t.Errorf("snapshot = %+v, want %+v", snap, wsnap)
+	}
+
+	// applying a snapshot discards the log.
+	if err = s.ApplySnapshot(wsnap); !errors.Is(err, raft.ErrSnapOutOfDate) {
+		t.Errorf("apply snapshot err = %v, want %v", err, raft.ErrSnapOutOfDate)
+	}
+	wsnap.Metadata.Index, wsnap.Metadata.Term = 10, 6
+	if err = s.ApplySnapshot(wsnap); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := s.FirstIndex()
+	last, _ := s.LastIndex()
+	if first != 11 || last != 10 {
+		t.Errorf("first, last index = %d, %d, want 11, 10", first, last)
+	}
+	if _, err = s.Term(5); !errors.Is(err, raft.ErrCompacted) {
+		t.Errorf("term of a discarded entry err = %v, want %v", err, raft.ErrCompacted)
+	}
+}
+
+func TestOnDiskStorageRestart(t *testing.T) {
+	b, tmpPath := betesting.NewDefaultTmpBackend(t)
+	s := NewOnDiskStorage(zaptest.NewLogger(t), b, 1024)
+	hs := raftpb.HardState{Term: 5, Vote: 1, Commit: 4}
+	ents := []raftpb.Entry{{Index: 1, Term: 1}, {Index: 2, Term: 2, Data: []byte("foo")}, {Index: 3, Term: 5}, {Index: 4, Term: 5}}
+	s.Append(ents)
+	s.SetHardState(hs)
+	s.CreateSnapshot(2, &raftpb.ConfState{Voters: []uint64{1}}, []byte("data"))
+	s.Compact(2)
+	betesting.Close(t, b)
+
+	b = backend.NewDefaultBackend(zaptest.NewLogger(t), tmpPath)
+	defer betesting.Close(t, b)
+	s = NewOnDiskStorage(zaptest.NewLogger(t), b, 1024)
+	st, cs, _ := s.InitialState()
+	if !reflect.DeepEqual(st, hs) || !reflect.DeepEqual(cs, raftpb.ConfState{Voters: []uint64{1}}) {
+		t.Errorf("initial state = %+v, %+v, want %+v and voter 1", st, cs, hs)
+	}
+	first, _ := s.FirstIndex()
+	last, _ := s.LastIndex()
+	if first != 3 || last != 4 {
+		t.Errorf("first, last index = %d, %d, want 3, 4", first, last)
+	}
+	if term, _ := s.Term(2); term != 2 {
+		t.Errorf("term of the compacted index = %d, want 2", term)
+	}
+	if entries, _ := s.Entries(3, 5, ^uint64(0)); !reflect.DeepEqual(entries, ents[2:]) {
+		t.Errorf("entries = %v, want %v", entries, ents[2:])
+	}
+}
+
+// TestOnDiskStorageRaft tests that raft runs on the storage as on a
+// raft.MemoryStorage.
+func TestOnDiskStorageRaft(t *testing.T) {
+	b, _ := betesting.NewDefaultTmpBackend(t)
+	defer betesting.Close(t, b)
+	s := NewOnDiskStorage(zaptest.NewLogger(t), b, 1024)
+
+	rn, err := raft.NewRawNode(&raft.Config{
+		ID:              1,
+		ElectionTick:    10,
+		HeartbeatTick:   1,
+		Storage:         s,
+		MaxSizePerMsg:   1024 * 1024,
+		MaxInflightMsgs: 256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rn.Bootstrap([]raft.Peer{{ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	campaigned, proposed := false, false
+	var committed [][]byte
+	for len(committed) < 1 {
+		if !rn.HasReady() {
+			switch {
+			case !campaigned:
+				// campaign once the bootstrap conf changes are applied.
+				rn.Campaign()
+				campaigned = true
+			case !proposed && rn.Status().RaftState == raft.StateLeader:
+				rn.Propose([]byte("foo"))
+				proposed = true
+			default:
+				t.Fatalf("no progress, status %+v", rn.Status())
+			}
+			continue
+		}
+		rd := rn.Ready()
+		if !raft.IsEmptyHardState(rd.HardState) {
+			s.SetHardState(rd.HardState)
+		}
+		s.Append(rd.Entries)
+		for _, ent := range rd.CommittedEntries {
+			switch ent.Type {
+			case raftpb.EntryNormal:
+				if len(ent.Data) > 0 {
+					committed = append(committed, ent.Data)
+				}
+			case raftpb.EntryConfChange:
+				var cc raftpb.ConfChange
+				cc.Unmarshal(ent.Data)
+				rn.ApplyConfChange(cc)
+			}
+		}
+		rn.Advance(rd)
+	}
+	if string(committed[0]) != "foo" {
+		t.Errorf("committed = %q, want foo", committed)
+	}
+}
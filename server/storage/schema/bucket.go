@@ -27,6 +27,16 @@ var (
 	leaseBucketName = []byte("lease")
 	alarmBucketName = []byte("alarm")
 
+	keyIndexBucketName       = []byte("keyIndex")
+	secondaryIndexBucketName = []byte("secondaryIndex")
+	leaseKeyBucketName       = []byte("leaseKeys")
+	revisionTimeBucketName   = []byte("revisionTime")
+	keyTTLBucketName         = []byte("keyTTL")
+	prefixStatsBucketName    = []byte("prefixStats")
+
+	raftEntryBucketName = []byte("raftEntry")
+	raftStateBucketName = []byte("raftState")
+
 	clusterBucketName = []byte("cluster")
 
 	membersBucketName        = []byte("members")
@@ -46,6 +56,30 @@ var (
 	Alarm   = backend.Bucket(bucket{id: 4, name: alarmBucketName, safeRangeBucket: false})
 	Cluster = backend.Bucket(bucket{id: 5, name: clusterBucketName, safeRangeBucket: false})
 
+	// KeyIndex holds the checkpoint of the mvcc key index. It is local to
+	// each member.
+	KeyIndex = backend.Bucket(bucket{id: 6, name: keyIndexBucketName, safeRangeBucket: false})
+	// SecondaryIndex holds the terms of the secondary indexes of the mvcc
+	// store, derived from the current keys.
+	SecondaryIndex = backend.Bucket(bucket{id: 7, name: secondaryIndexBucketName, safeRangeBucket: true})
+	// LeaseKey indexes the current keys of the mvcc store by their lease.
+	LeaseKey = backend.Bucket(bucket{id: 8, name: leaseKeyBucketName, safeRangeBucket: true})
+	// RevisionTime maps the mvcc revisions to the wall time they were
+	// written at, sampled by each member.
+	RevisionTime = backend.Bucket(bucket{id: 9, name: revisionTimeBucketName, safeRangeBucket: true})
+	// KeyTTL holds the expiry deadlines of the mvcc keys put with a TTL,
+	// from the clock of each member.
+	KeyTTL = backend.Bucket(bucket{id: 12, name: keyTTLBucketName, safeRangeBucket: true})
+	// PrefixStats holds the counters of the writes of the mvcc keys by the
+	// prefixes registered with the store.
+	PrefixStats = backend.Bucket(bucket{id: 13, name: prefixStatsBucketName, safeRangeBucket: false})
+
+	// RaftEntry and RaftState hold the raft log entries and the raft state
+	// of an on-disk raft storage. They are kept in a backend of their own,
+	// not in the etcd db.
+	RaftEntry = backend.Bucket(bucket{id: 14, name: raftEntryBucketName, safeRangeBucket: false})
+	RaftState = backend.Bucket(bucket{id: 15, name: raftStateBucketName, safeRangeBucket: false})
+
 	Members        = backend.Bucket(bucket{id: 10, name: membersBucketName, safeRangeBucket: false})
 	MembersRemoved = backend.Bucket(bucket{id: 11, name: membersRemovedBucketName, safeRangeBucket: false})
 
@@ -91,6 +125,17 @@ func DefaultIgnores(bucket, key []byte) bool {
 	// consistent index & term might be changed due to v2 internal sync, which
 	// is not controllable by the user.
 	// storage version might change after wal snapshot and is not controller by user.
+	// the key index checkpoint is taken by each member on its own schedule,
+	// and the secondary indexes are registered by each member on its own.
+	// the lease index is built by each member when it is upgraded.
+	// the revision times and the key deadlines are from the clock of each
+	// member, and the prefix stats are kept for the prefixes each member
+	// registers.
+	if bytes.Equal(bucket, KeyIndex.Name()) || bytes.Equal(bucket, SecondaryIndex.Name()) || bytes.Equal(bucket, LeaseKey.Name()) ||
+		bytes.Equal(bucket, RevisionTime.Name()) || bytes.Equal(bucket, KeyTTL.Name()) ||
+		bytes.Equal(bucket, PrefixStats.Name()) {
+		return true
+	}
 	return bytes.Equal(bucket, Meta.Name()) &&
 		(bytes.Equal(key, MetaTermKeyName) || bytes.Equal(key, MetaConsistentIndexKeyName) || bytes.Equal(key, MetaStorageVersionName))
 }
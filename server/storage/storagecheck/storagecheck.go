@@ -0,0 +1,170 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storagecheck checks the invariants that hold between the WAL, the
+// snapshots and the backend of a member.
+package storagecheck
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+	"go.etcd.io/etcd/server/v3/storage/wal"
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+)
+
+const (
+	CheckSnapshotFile  = "snapshot-file"
+	CheckSnapshotInWAL = "snapshot-in-wal"
+	CheckWALIndex      = "wal-index"
+	CheckBackendIndex  = "backend-index"
+
+	remediationRestore = "restore the member from a backup, or remove it from the cluster and add it back with an empty data directory"
+)
+
+type Config struct {
+	// WALDir, SnapDir and BackendPath locate the storage of the member.
+	// The backend is not checked if BackendPath does not exist.
+	WALDir      string
+	SnapDir     string
+	BackendPath string
+
+	Logger *zap.Logger
+}
+
+// Violation is an invariant that does not hold.
+type Violation struct {
+	// Check names the invariant.
+	Check string
+	Err   error
+	// Remediation tells the operator how to recover from the violation.
+	Remediation string
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("storagecheck: %s: %v (remediation: %s)", v.Check, v.Err, v.Remediation)
+}
+
+func (v Violation) Unwrap() error { return v.Err }
+
+// Report is the result of Check.
+type Report struct {
+	// Snapshots is the verification of the snap files.
+	Snapshots *snap.VerifyReport
+	// WALLastIndex is the index of the last entry in the WAL, or of the
+	// snapshot the WAL is read from if it has no later entry.
+	WALLastIndex uint64
+	// ConsistentIndex is the consistent index of the backend, 0 if the
+	// backend was not checked.
+	ConsistentIndex uint64
+	Violations      []Violation
+}
+
+// Err returns the violations joined in one error, nil if there is none.
+func (r *Report) Err() error {
+	errs := make([]error, len(r.Violations))
+	for i, v := range r.Violations {
+		errs[i] = v
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Report) violate(check string, err error, remediation string) {
+	r.Violations = append(r.Violations, Violation{Check: check, Err: err, Remediation: remediation})
+}
+
+// Check checks the storage of a member without modifying it:
+//   - the snap files can be read and match the snapshot records of the WAL,
+//   - the WAL last index is at least the index of the newest snapshot,
+//   - the backend consistent index is at most the WAL last index.
+//
+// The storage must not be in use. The violations of the invariants are
+// reported in the returned Report, the error is only returned if the checks
+// could not be run.
+func Check(cfg Config) (*Report, error) {
+	lg := cfg.Logger
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	r := &Report{}
+
+	snaps, err := snap.Verify(lg, cfg.SnapDir, cfg.WALDir)
+	if err != nil {
+		return nil, err
+	}
+	r.Snapshots = snaps
+	for _, st := range snaps.Snapshots {
+		if st.Err != nil {
+			r.violate(CheckSnapshotFile, fmt.Errorf("%s: %w", st.Name, st.Err),
+				fmt.Sprintf("move %s out of %s; the member restarts from an older snapshot, or %s", st.Name, cfg.SnapDir, remediationRestore))
+		}
+	}
+	for _, ws := range snaps.Missing {
+		r.violate(CheckSnapshotInWAL, fmt.Errorf("no snap file for the WAL snapshot record at term %d, index %d", ws.Term, ws.Index),
+			remediationRestore)
+	}
+
+	start := walpb.Snapshot{}
+	if snaps.Available != nil {
+		start = *snaps.Available
+	}
+	if r.WALLastIndex, err = walLastIndex(lg, cfg.WALDir, start); err != nil {
+		r.violate(CheckWALIndex, fmt.Errorf("cannot read the WAL from the snapshot at index %d: %w", start.Index, err),
+			remediationRestore)
+		return r, nil
+	}
+	for _, st := range snaps.Snapshots {
+		if st.Err == nil && st.Index > r.WALLastIndex {
+			r.violate(CheckWALIndex, fmt.Errorf("the WAL last index %d is behind the snapshot %s", r.WALLastIndex, st.Name),
+				fmt.Sprintf("if the member crashed while saving the snapshot, remove %s; otherwise make sure the WAL directory %s belongs to the member, or %s", st.Name, cfg.WALDir, remediationRestore))
+			break
+		}
+	}
+
+	if !fileutil.Exist(cfg.BackendPath) {
+		return r, nil
+	}
+	be := backend.NewDefaultBackend(lg, cfg.BackendPath)
+	defer be.Close()
+	r.ConsistentIndex, _ = schema.ReadConsistentIndex(be.ReadTx())
+	if r.ConsistentIndex > r.WALLastIndex {
+		r.violate(CheckBackendIndex, fmt.Errorf("the backend consistent index %d is ahead of the WAL last index %d", r.ConsistentIndex, r.WALLastIndex),
+			fmt.Sprintf("make sure the backend %s belongs to the member, or %s", cfg.BackendPath, remediationRestore))
+	}
+	return r, nil
+}
+
+// walLastIndex returns the index of the last entry of the WAL in walDir
+// after snapshot, or the snapshot index if there is none.
+func walLastIndex(lg *zap.Logger, walDir string, snapshot walpb.Snapshot) (uint64, error) {
+	w, err := wal.OpenForRead(lg, walDir, snapshot)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+	_, _, ents, err := w.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(ents) == 0 {
+		return snapshot.Index, nil
+	}
+	return ents[len(ents)-1].Index, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagecheck
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+	"go.etcd.io/etcd/server/v3/storage/wal"
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+var confState = raftpb.ConfState{Voters: []uint64{1}}
+
+// newStorage creates the storage of a member with a snapshot at index 5 and
+// the entries up to lastIndex in its WAL.
+func newStorage(t *testing.T, lastIndex uint64) Config {
+	t.Helper()
+	lg := zaptest.NewLogger(t)
+	dir := t.TempDir()
+	cfg := Config{
+		WALDir:      filepath.Join(dir, "wal"),
+		SnapDir:     filepath.Join(dir, "snap"),
+		BackendPath: filepath.Join(dir, "snap", "db"),
+		Logger:      lg,
+	}
+	if err := os.Mkdir(cfg.SnapDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := wal.Create(lg, cfg.WALDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var ents []raftpb.Entry
+	for i := uint64(1); i <= lastIndex; i++ {
+		ents = append(ents, raftpb.Entry{Term: 1, Index: i})
+	}
+	if err = w.Save(raftpb.HardState{Term: 1, Commit: lastIndex}, ents); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.SaveSnapshot(walpb.Snapshot{Term: 1, Index: 5, ConfState: &confState}); err != nil {
+		t.Fatal(err)
+	}
+	saveSnap(t, cfg, 5)
+	return cfg
+}
+
+func saveSnap(t *testing.T, cfg Config, index uint64) {
+	t.Helper()
+	err := snap.New(cfg.Logger, cfg.SnapDir).SaveSnap(raftpb.Snapshot{
+		Data:     []byte("data"),
+		Metadata: raftpb.SnapshotMetadata{Term: 1, Index: index, ConfState: confState},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setConsistentIndex(t *testing.T, cfg Config, index uint64) {
+	t.Helper()
+	be := backend.NewDefaultBackend(cfg.Logger, cfg.BackendPath)
+	defer be.Close()
+	tx := be.BatchTx()
+	tx.LockOutsideApply()
+	tx.UnsafeCreateBucket(schema.Meta)
+	schema.UnsafeUpdateConsistentIndex(tx, index, 1)
+	tx.Unlock()
+	be.ForceCommit()
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, cfg Config)
+		wcheck string
+	}{
+		{
+			name:   "consistent",
+			modify: func(t *testing.T, cfg Config) { setConsistentIndex(t, cfg, 8) },
+		},
+		{
+			name:   "backend ahead of the WAL",
+			modify: func(t *testing.T, cfg Config) { setConsistentIndex(t, cfg, 12) },
+			wcheck: CheckBackendIndex,
+		},
+		{
+			name:   "snapshot ahead of the WAL",
+			modify: func(t *testing.T, cfg Config) { saveSnap(t, cfg, 12) },
+			wcheck: CheckWALIndex,
+		},
+		{
+			name: "corrupt snapshot",
+			modify: func(t *testing.T, cfg Config) {
+				name := filepath.Join(cfg.SnapDir, "0000000000000001-0000000000000005.snap")
+				if err := os.WriteFile(name, []byte("corrupt"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wcheck: CheckSnapshotFile,
+		},
+		{
+			name: "missing snapshot",
+			modify: func(t *testing.T, cfg Config) {
+				if err := os.Remove(filepath.Join(cfg.SnapDir, "0000000000000001-0000000000000005.snap")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wcheck: CheckSnapshotInWAL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newStorage(t, 10)
+			tt.modify(t, cfg)
+
+			r, err := Check(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.WALLastIndex != 10 {
+				t.Errorf("WALLastIndex = %d, want 10", r.WALLastIndex)
+			}
+			if tt.wcheck == "" {
+				if err = r.Err(); err != nil {
+					t.Errorf("err = %v, want nil", err)
+				}
+				return
+			}
+			if len(r.Violations) != 1 || r.Violations[0].Check != tt.wcheck {
+				t.Fatalf("violations = %v, want one of %s", r.Violations, tt.wcheck)
+			}
+			var v Violation
+			if !errors.As(r.Err(), &v) || v.Remediation == "" {
+				t.Errorf("err = %v, want a violation with a remediation", r.Err())
+			}
+		})
+	}
+}
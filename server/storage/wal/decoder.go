@@ -55,9 +55,13 @@ type decoder struct {
 }
 
 func NewDecoderAdvanced(continueOnCrcError bool, r ...fileutil.FileReader) Decoder {
+	return newDecoder(fileutil.DefaultBufReaderSize, continueOnCrcError, r...)
+}
+
+func newDecoder(bufSize int, continueOnCrcError bool, r ...fileutil.FileReader) Decoder {
 	readers := make([]*fileutil.FileBufReader, len(r))
 	for i := range r {
-		readers[i] = fileutil.NewFileBufReader(r[i])
+		readers[i] = fileutil.NewFileBufReaderSize(r[i], bufSize)
 	}
 	return &decoder{
 		brs:                readers,
@@ -25,6 +25,7 @@ import (
 	"go.etcd.io/etcd/pkg/v3/crc"
 	"go.etcd.io/etcd/pkg/v3/ioutil"
 	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+	"go.etcd.io/raft/v3/raftpb"
 )
 
 // walPageBytes is the alignment for flushing records to the backing Writer.
@@ -39,6 +40,8 @@ type encoder struct {
 	crc       hash.Hash32
 	buf       []byte
 	uint64buf []byte
+	// entBuf is the scratch buffer entries are marshaled into by encodeEntries.
+	entBuf []byte
 }
 
 func newEncoder(w io.Writer, prevCrc uint32, pageOffset int) *encoder {
@@ -90,6 +93,59 @@ func (e *encoder) encode(rec *walpb.Record) error {
 	return write(e.bw, e.uint64buf, data, lenField)
 }
 
+// encodeEntries encodes ents as consecutive entry records. All the frames
+// are marshaled into one contiguous buffer under a single lock acquisition
+// and handed to the page writer with one write, which avoids the per-entry
+// locking and allocations of calling encode for every entry.
+// The produced bytes are identical to encoding the entries one by one.
+func (e *encoder) encodeEntries(ents []raftpb.Entry) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	batch := e.buf[:0]
+	for i := range ents {
+		esz := ents[i].Size()
+		if esz > len(e.entBuf) {
+			e.entBuf = make([]byte, esz)
+		}
+		n, err := ents[i].MarshalTo(e.entBuf)
+		if err != nil {
+			return err
+		}
+		rec := walpb.Record{Type: EntryType, Data: e.entBuf[:n]}
+		e.crc.Write(rec.Data)
+		rec.Crc = e.crc.Sum32()
+
+		rsz := rec.Size()
+		lenField, padBytes := encodeFrameSize(rsz)
+		off := len(batch)
+		batch = growBytes(batch, frameSizeBytes+rsz+padBytes)
+		binary.LittleEndian.PutUint64(batch[off:], lenField)
+		if _, err = rec.MarshalTo(batch[off+frameSizeBytes:]); err != nil {
+			return err
+		}
+		clear(batch[off+frameSizeBytes+rsz:])
+	}
+	// keep the grown buffer for the next batches
+	e.buf = batch[:cap(batch)]
+
+	start := time.Now()
+	n, err := e.bw.Write(batch)
+	walWriteSec.Observe(time.Since(start).Seconds())
+	walWriteBytes.Add(float64(n))
+	return err
+}
+
+// growBytes extends b by n bytes, reallocating if the capacity is exceeded.
+func growBytes(b []byte, n int) []byte {
+	if len(b)+n <= cap(b) {
+		return b[:len(b)+n]
+	}
+	nb := make([]byte, len(b)+n, 2*cap(b)+n)
+	copy(nb, b)
+	return nb
+}
+
 func encodeFrameSize(dataBytes int) (lenField uint64, padBytes int) {
 	lenField = uint64(dataBytes)
 	// force 8 byte alignment so length never gets a torn write
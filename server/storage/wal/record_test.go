@@ -24,7 +24,9 @@ import (
 	"testing"
 
 	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/pkg/v3/pbutil"
 	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+	"go.etcd.io/raft/v3/raftpb"
 )
 
 var (
@@ -105,3 +107,59 @@ func createFileWithData(t *testing.T, bf *bytes.Buffer) (*os.File, error) {
 	f.Seek(0, 0)
 	return f, nil
 }
+
+func TestEncodeEntries(t *testing.T) {
+	ents := []raftpb.Entry{
+		{Index: 1, Term: 1},
+		{Index: 2, Term: 1, Data: []byte("a")},
+		{Index: 3, Term: 2, Data: bytes.Repeat([]byte("b"), 1000)},
+		{Index: 4, Term: 2, Data: bytes.Repeat([]byte("c"), 2*1024*1024)},
+		{Index: 5, Term: 2, Data: []byte("1234567")},
+	}
+
+	want := new(bytes.Buffer)
+	we := newEncoder(want, 0xdeadbeef, 0)
+	for i := range ents {
+		rec := &walpb.Record{Type: EntryType, Data: pbutil.MustMarshal(&ents[i])}
+		if err := we.encode(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := we.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(bytes.Buffer)
+	ge := newEncoder(got, 0xdeadbeef, 0)
+	if err := ge.encodeEntries(ents); err != nil {
+		t.Fatal(err)
+	}
+	if err := ge.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		t.Fatalf("batched encoding differs from per-entry encoding")
+	}
+	if we.crc.Sum32() != ge.crc.Sum32() {
+		t.Fatalf("crc = %x, want %x", ge.crc.Sum32(), we.crc.Sum32())
+	}
+}
+
+// TestEncodeEntriesReusesBuffer ensures the buffer grown for a large batch is
+// kept for the next ones.
+func TestEncodeEntriesReusesBuffer(t *testing.T) {
+	ents := []raftpb.Entry{{Index: 1, Term: 1, Data: bytes.Repeat([]byte("a"), 2*1024*1024)}}
+	e := newEncoder(io.Discard, 0, 0)
+	if err := e.encodeEntries(ents); err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		if err := e.encodeEntries(ents); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("allocs = %v, want 0", allocs)
+	}
+}
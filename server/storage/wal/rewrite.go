@@ -0,0 +1,342 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+)
+
+// RewriteStats summarizes the records dropped by Rewrite.
+type RewriteStats struct {
+	Segments        int
+	DroppedState    int
+	DroppedMetadata int
+	BytesBefore     int64
+	BytesAfter      int64
+}
+
+// segment is the decoded content of a single WAL file. The leading crc
+// record is not kept since it is regenerated when the segment is written.
+type segment struct {
+	name string
+	recs []*walpb.Record
+}
+
+// Rewrite compacts the WAL in the given directory by dropping superseded
+// state records and duplicate metadata records from every segment. Only the
+// last state record and the first metadata record of each segment are kept;
+// entry and snapshot records are preserved in order. The crc chain is
+// recomputed over the remaining records.
+//
+// Rewrite must only be called on a WAL that is not opened by anyone else.
+// A WAL with a torn tail must be repaired first.
+func Rewrite(lg *zap.Logger, dirpath string) (RewriteStats, error) {
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	var stats RewriteStats
+	segs, err := readSegments(lg, dirpath)
+	if err != nil {
+		return stats, err
+	}
+	stats.Segments = len(segs)
+
+	for i := range segs {
+		var (
+			metadata  []byte
+			lastState = -1
+		)
+		for j, rec := range segs[i].recs {
+			if rec.Type == StateType {
+				lastState = j
+			}
+		}
+		recs := make([]*walpb.Record, 0, len(segs[i].recs))
+		for j, rec := range segs[i].recs {
+			switch rec.Type {
+			case MetadataType:
+				if metadata != nil {
+					if !bytes.Equal(metadata, rec.Data) {
+						return stats, ErrMetadataConflict
+					}
+					stats.DroppedMetadata++
+					continue
+				}
+				metadata = rec.Data
+			case StateType:
+				if j != lastState {
+					stats.DroppedState++
+					continue
+				}
+			}
+			recs = append(recs, rec)
+		}
+		segs[i].recs = recs
+	}
+
+	if stats.BytesBefore, stats.BytesAfter, err = replaceSegments(lg, dirpath, segs); err != nil {
+		return stats, err
+	}
+
+	lg.Info(
+		"rewrote WAL",
+		zap.String("dir-path", dirpath),
+		zap.Int("segments", stats.Segments),
+		zap.Int("dropped-state-records", stats.DroppedState),
+		zap.Int("dropped-metadata-records", stats.DroppedMetadata),
+		zap.Int64("bytes-before", stats.BytesBefore),
+		zap.Int64("bytes-after", stats.BytesAfter),
+	)
+	return stats, nil
+}
+
+// replaceSegments replaces the WAL in dirpath with segs and returns the size
+// of the WAL files before and after the replacement.
+func replaceSegments(lg *zap.Logger, dirpath string, segs []segment) (before, after int64, err error) {
+	if before, err = dirSize(dirpath); err != nil {
+		return 0, 0, err
+	}
+	if err = writeSegments(lg, dirpath, segs); err != nil {
+		return before, 0, err
+	}
+	after, err = dirSize(dirpath)
+	return before, after, err
+}
+
+// readSegments decodes every WAL file in dirpath, validating the crc chain
+// across segment boundaries. Files are locked while being read so that a WAL
+// opened for writing elsewhere is detected.
+func readSegments(lg *zap.Logger, dirpath string) ([]segment, error) {
+	names, err := readWALNames(lg, dirpath)
+	if err != nil {
+		return nil, err
+	}
+	if !isValidSeq(lg, names) {
+		return nil, fmt.Errorf("wal: file sequence numbers do not increase continuously in %q", dirpath)
+	}
+
+	segs := make([]segment, 0, len(names))
+	var prevCrc uint32
+	for _, name := range names {
+		p := filepath.Join(dirpath, name)
+		l, err := fileutil.TryLockFile(p, os.O_RDWR, fileutil.PrivateFileMode)
+		if err != nil {
+			return nil, fmt.Errorf("wal: failed to lock %q: %w", p, err)
+		}
+		seg, crc, err := readSegment(name, l.File, prevCrc)
+		l.Close()
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, seg)
+		prevCrc = crc
+	}
+	return segs, nil
+}
+
+func readSegment(name string, f *os.File, prevCrc uint32) (segment, uint32, error) {
+	seg := segment{name: name}
+	decoder := NewDecoder(fileutil.NewFileReader(f))
+	decoder.UpdateCRC(prevCrc)
+	for {
+		rec := &walpb.Record{}
+		err := decoder.Decode(rec)
+		if errors.Is(err, io.EOF) {
+			return seg, decoder.LastCRC(), nil
+		}
+		if err != nil {
+			return seg, 0, fmt.Errorf("wal: failed to decode %q: %w", name, err)
+		}
+		if rec.Type == CrcType {
+			crc := decoder.LastCRC()
+			if crc != 0 && rec.Validate(crc) != nil {
+				return seg, 0, ErrCRCMismatch
+			}
+			decoder.UpdateCRC(rec.Crc)
+			continue
+		}
+		seg.recs = append(seg.recs, rec)
+	}
+}
+
+// writeSegments writes segs into a temporary directory, chaining the crc of
+// each segment into the next one, and then atomically replaces dirpath with
+// it. The last segment is preallocated so that the WAL can be appended to
+// after it is opened again. The entries of dirpath other than the segments
+// are copied along.
+func writeSegments(lg *zap.Logger, dirpath string, segs []segment) error {
+	tmpdirpath := filepath.Clean(dirpath) + ".rewrite.tmp"
+	if err := os.RemoveAll(tmpdirpath); err != nil {
+		return err
+	}
+	if err := fileutil.CreateDirAll(lg, tmpdirpath); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdirpath)
+
+	var prevCrc uint32
+	for i, seg := range segs {
+		crc, err := writeSegment(filepath.Join(tmpdirpath, seg.name), seg.recs, prevCrc, i == len(segs)-1)
+		if err != nil {
+			return err
+		}
+		prevCrc = crc
+	}
+	if err := copyNonSegments(dirpath, tmpdirpath); err != nil {
+		return err
+	}
+	if err := fileutil.FsyncDir(tmpdirpath); err != nil {
+		return err
+	}
+
+	olddirpath := filepath.Clean(dirpath) + ".rewrite.old"
+	if err := os.RemoveAll(olddirpath); err != nil {
+		return err
+	}
+	if err := os.Rename(dirpath, olddirpath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpdirpath, dirpath); err != nil {
+		// best effort to put the original WAL back in place
+		if rerr := os.Rename(olddirpath, dirpath); rerr != nil {
+			lg.Error("failed to restore WAL directory", zap.String("path", olddirpath), zap.Error(rerr))
+		}
+		return err
+	}
+	// sync the parent directory to persist the renames
+	if err := fileutil.FsyncDir(filepath.Dir(filepath.Clean(dirpath))); err != nil {
+		return err
+	}
+	return os.RemoveAll(olddirpath)
+}
+
+// copyNonSegments copies the entries of dirpath that are not WAL segments
+// into tmpdirpath. The files are hard linked, or copied where links are not
+// supported.
+func copyNonSegments(dirpath, tmpdirpath string) error {
+	names, err := fileutil.ReadDir(dirpath)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, _, err = parseWALName(name); err == nil {
+			continue
+		}
+		src := filepath.Join(dirpath, name)
+		err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(tmpdirpath, name, rel)
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			switch {
+			case d.IsDir():
+				return os.Mkdir(dst, info.Mode().Perm())
+			case d.Type().IsRegular():
+				if os.Link(p, dst) == nil {
+					return nil
+				}
+				return copyFile(p, dst, info.Mode().Perm())
+			default:
+				return fmt.Errorf("wal: cannot copy %q of mode %v", p, info.Mode())
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+	return fileutil.Fsync(w)
+}
+
+func writeSegment(p string, recs []*walpb.Record, prevCrc uint32, preallocate bool) (uint32, error) {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileutil.PrivateFileMode)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	enc := newEncoder(f, prevCrc, 0)
+	if err = enc.encode(&walpb.Record{Type: CrcType, Crc: prevCrc}); err != nil {
+		return 0, err
+	}
+	for _, rec := range recs {
+		if err = enc.encode(&walpb.Record{Type: rec.Type, Data: rec.Data}); err != nil {
+			return 0, err
+		}
+	}
+	if err = enc.flush(); err != nil {
+		return 0, err
+	}
+	if preallocate {
+		if err = fileutil.Preallocate(f, SegmentSizeBytes, true); err != nil {
+			return 0, err
+		}
+	}
+	if err = fileutil.Fsync(f); err != nil {
+		return 0, err
+	}
+	return enc.crc.Sum32(), nil
+}
+
+func dirSize(dirpath string) (int64, error) {
+	names, err := readWALNames(zap.NewNop(), dirpath)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, name := range names {
+		fi, err := os.Stat(filepath.Join(dirpath, name))
+		if err != nil {
+			return 0, err
+		}
+		size += fi.Size()
+	}
+	return size, nil
+}
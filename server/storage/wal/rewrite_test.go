@@ -0,0 +1,131 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+func TestRewrite(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	p := t.TempDir()
+
+	restoreLater := SegmentSizeBytes
+	SegmentSizeBytes = 4 * 1024
+	defer func() { SegmentSizeBytes = restoreLater }()
+
+	w, err := Create(lg, p, []byte("metadata"))
+	require.NoError(t, err)
+	data := make([]byte, 200)
+	for i := uint64(1); i <= 50; i++ {
+		st := raftpb.HardState{Term: 1, Commit: i}
+		require.NoError(t, w.Save(st, []raftpb.Entry{{Index: i, Term: 1, Data: data}}))
+	}
+	require.NoError(t, w.Close())
+
+	wantMeta, wantState, wantEnts := readAllWAL(t, p)
+
+	stats, err := Rewrite(lg, p)
+	require.NoError(t, err)
+	assert.Greater(t, stats.Segments, 1)
+	assert.Greater(t, stats.DroppedState, 0)
+	assert.Less(t, stats.BytesAfter, stats.BytesBefore)
+
+	segs, err := readSegments(lg, p)
+	require.NoError(t, err)
+	for _, seg := range segs {
+		var states int
+		for _, rec := range seg.recs {
+			if rec.Type == StateType {
+				states++
+			}
+		}
+		assert.LessOrEqual(t, states, 1, "segment %s", seg.name)
+	}
+
+	gotMeta, gotState, gotEnts := readAllWAL(t, p)
+	assert.Equal(t, wantMeta, gotMeta)
+	assert.Equal(t, wantState, gotState)
+	assert.Equal(t, wantEnts, gotEnts)
+
+	// the rewritten WAL must remain appendable
+	w, err = Open(lg, p, walpb.Snapshot{})
+	require.NoError(t, err)
+	_, _, _, err = w.ReadAll()
+	require.NoError(t, err)
+	require.NoError(t, w.Save(raftpb.HardState{Term: 1, Commit: 51}, []raftpb.Entry{{Index: 51, Term: 1}}))
+	require.NoError(t, w.Close())
+
+	_, gotState, gotEnts = readAllWAL(t, p)
+	assert.Equal(t, uint64(51), gotState.Commit)
+	assert.Len(t, gotEnts, 51)
+}
+
+// TestRewriteKeepsOtherEntries ensures the entries of the WAL directory
+// other than the segments survive a rewrite.
+func TestRewriteKeepsOtherEntries(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	p := t.TempDir()
+
+	w, err := Create(lg, p, []byte("metadata"))
+	require.NoError(t, err)
+	require.NoError(t, w.Save(raftpb.HardState{Term: 1, Commit: 1}, []raftpb.Entry{{Index: 1, Term: 1}}))
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(p, "marker"), []byte("marker"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(p, "sub", "dir"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(p, "sub", "dir", "file"), []byte("file"), 0600))
+
+	_, err = Rewrite(lg, p)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(p, "marker"))
+	require.NoError(t, err)
+	assert.Equal(t, "marker", string(b))
+	b, err = os.ReadFile(filepath.Join(p, "sub", "dir", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "file", string(b))
+	_, _, ents := readAllWAL(t, p)
+	assert.Len(t, ents, 1)
+}
+
+func TestRewriteLockedWAL(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	p := t.TempDir()
+
+	w, err := Create(lg, p, nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	_, err = Rewrite(lg, p)
+	require.Error(t, err)
+}
+
+func readAllWAL(t *testing.T, p string) ([]byte, raftpb.HardState, []raftpb.Entry) {
+	w, err := OpenForRead(zaptest.NewLogger(t), p, walpb.Snapshot{})
+	require.NoError(t, err)
+	defer w.Close()
+	metadata, state, ents, err := w.ReadAll()
+	require.NoError(t, err)
+	return metadata, state, ents
+}
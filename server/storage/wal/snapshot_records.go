@@ -0,0 +1,195 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"go.uber.org/zap"
+
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/pkg/v3/pbutil"
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+)
+
+// SnapshotRecord is a snapshot record together with its location in the WAL.
+type SnapshotRecord struct {
+	Snapshot walpb.Snapshot
+	// File is the name of the WAL file holding the record.
+	File string
+	// Offset is the file offset of the frame holding the record.
+	Offset int64
+}
+
+// SnapshotRecords returns every snapshot record of the WAL in the given
+// directory, in the order they were written. Unlike ValidSnapshotEntries it
+// does not filter out records ahead of the committed hardstate.
+// The WAL files are opened in read mode, so it does not conflict with a WAL
+// opened for writing elsewhere.
+func SnapshotRecords(lg *zap.Logger, walDir string) ([]SnapshotRecord, error) {
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	names, err := readWALNames(lg, walDir)
+	if err != nil {
+		return nil, err
+	}
+	rs, _, closer, err := openWALFiles(lg, walDir, names, 0, false, newReadOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	var (
+		recs    []SnapshotRecord
+		prevCrc uint32
+	)
+	for i, r := range rs {
+		decoder := NewDecoder(r)
+		decoder.UpdateCRC(prevCrc)
+		rec := &walpb.Record{}
+		for {
+			off := decoder.LastOffset()
+			if err = decoder.Decode(rec); err != nil {
+				break
+			}
+			switch rec.Type {
+			case CrcType:
+				crc := decoder.LastCRC()
+				// current crc of decoder must match the crc of the record.
+				// do no need to match 0 crc, since the decoder is a new one at this case.
+				if crc != 0 && rec.Validate(crc) != nil {
+					return nil, ErrCRCMismatch
+				}
+				decoder.UpdateCRC(rec.Crc)
+			case SnapshotType:
+				var snap walpb.Snapshot
+				pbutil.MustUnmarshal(&snap, rec.Data)
+				recs = append(recs, SnapshotRecord{Snapshot: snap, File: names[i], Offset: off})
+			}
+		}
+		// the last file may end with a partially written record
+		if !errors.Is(err, io.EOF) && !(i == len(rs)-1 && errors.Is(err, io.ErrUnexpectedEOF)) {
+			return nil, err
+		}
+		prevCrc = decoder.LastCRC()
+	}
+	return recs, nil
+}
+
+// PurgeObsoleteSnapshotRecords rewrites the WAL in the given directory,
+// dropping every snapshot record older than the newest keep ones, ordered by
+// snapshot index. keep is at least 1. The records of the snapshots still
+// saved in snapDir are never dropped, since the member falls back to them
+// when loading a newer snapshot file fails. It returns the number of dropped
+// records; the WAL is left untouched if there is nothing to drop.
+//
+// Like Rewrite, it must only be called on a WAL that is not opened elsewhere.
+func PurgeObsoleteSnapshotRecords(lg *zap.Logger, walDir, snapDir string, keep int) (int, error) {
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	if keep < 1 {
+		keep = 1
+	}
+	live, err := snapFileSnapshots(snapDir)
+	if err != nil {
+		return 0, err
+	}
+	segs, err := readSegments(lg, walDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var indexes []uint64
+	for _, seg := range segs {
+		for _, rec := range seg.recs {
+			if rec.Type == SnapshotType {
+				indexes = append(indexes, mustUnmarshalSnapshot(rec.Data).Index)
+			}
+		}
+	}
+	if len(indexes) <= keep {
+		return 0, nil
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] > indexes[j] })
+	minIndex := indexes[keep-1]
+
+	dropped := 0
+	for i := range segs {
+		recs := segs[i].recs[:0]
+		for _, rec := range segs[i].recs {
+			if rec.Type == SnapshotType {
+				snap := mustUnmarshalSnapshot(rec.Data)
+				if _, ok := live[snapshotKey{snap.Term, snap.Index}]; !ok && snap.Index < minIndex {
+					dropped++
+					continue
+				}
+			}
+			recs = append(recs, rec)
+		}
+		segs[i].recs = recs
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	before, after, err := replaceSegments(lg, walDir, segs)
+	if err != nil {
+		return 0, err
+	}
+	lg.Info(
+		"purged obsolete WAL snapshot records",
+		zap.String("dir-path", walDir),
+		zap.Int("dropped-records", dropped),
+		zap.Uint64("min-kept-index", minIndex),
+		zap.Int("snap-files", len(live)),
+		zap.Int64("bytes-before", before),
+		zap.Int64("bytes-after", after),
+	)
+	return dropped, nil
+}
+
+func mustUnmarshalSnapshot(d []byte) walpb.Snapshot {
+	var snap walpb.Snapshot
+	pbutil.MustUnmarshal(&snap, d)
+	return snap
+}
+
+type snapshotKey struct {
+	term, index uint64
+}
+
+// snapFileSnapshots returns the term and index of every snapshot file saved
+// in snapDir, named "%016x-%016x.snap" after them.
+func snapFileSnapshots(snapDir string) (map[snapshotKey]struct{}, error) {
+	names, err := fileutil.ReadDir(snapDir, fileutil.WithExt(".snap"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	live := make(map[snapshotKey]struct{}, len(names))
+	for _, name := range names {
+		var k snapshotKey
+		if _, err := fmt.Sscanf(name, "%016x-%016x.snap", &k.term, &k.index); err != nil {
+			continue
+		}
+		live[k] = struct{}{}
+	}
+	return live, nil
+}
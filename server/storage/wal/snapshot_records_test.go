@@ -0,0 +1,119 @@
+// Copyright 2024 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+func TestSnapshotRecordsAndPurge(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	p, snapDir := t.TempDir(), t.TempDir()
+
+	restoreLater := SegmentSizeBytes
+	SegmentSizeBytes = 2 * 1024
+	defer func() { SegmentSizeBytes = restoreLater }()
+
+	w, err := Create(lg, p, nil)
+	require.NoError(t, err)
+	data := make([]byte, 200)
+	for i := uint64(1); i <= 30; i++ {
+		require.NoError(t, w.Save(raftpb.HardState{Term: 1, Commit: i}, []raftpb.Entry{{Index: i, Term: 1, Data: data}}))
+		if i%5 == 0 {
+			require.NoError(t, w.SaveSnapshot(walpb.Snapshot{Index: i, Term: 1, ConfState: &confState}))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	recs, err := SnapshotRecords(lg, p)
+	require.NoError(t, err)
+	require.Len(t, recs, 7)
+	for i, rec := range recs {
+		assert.Equal(t, uint64(i*5), rec.Snapshot.Index)
+		if i > 0 && rec.File == recs[i-1].File {
+			assert.Greater(t, rec.Offset, recs[i-1].Offset)
+		}
+	}
+	assert.NotEqual(t, recs[0].File, recs[len(recs)-1].File)
+
+	dropped, err := PurgeObsoleteSnapshotRecords(lg, p, snapDir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 5, dropped)
+
+	recs, err = SnapshotRecords(lg, p)
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+	assert.Equal(t, uint64(25), recs[0].Snapshot.Index)
+	assert.Equal(t, uint64(30), recs[1].Snapshot.Index)
+
+	// nothing left to purge
+	dropped, err = PurgeObsoleteSnapshotRecords(lg, p, snapDir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+
+	w, err = Open(lg, p, walpb.Snapshot{Index: 25, Term: 1})
+	require.NoError(t, err)
+	defer w.Close()
+	_, st, ents, err := w.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(30), st.Commit)
+	require.Len(t, ents, 5)
+	assert.Equal(t, uint64(26), ents[0].Index)
+}
+
+func TestPurgeObsoleteSnapshotRecordsKeepsSnapFiles(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	p, snapDir := t.TempDir(), t.TempDir()
+
+	w, err := Create(lg, p, nil)
+	require.NoError(t, err)
+	for i := uint64(1); i <= 30; i++ {
+		require.NoError(t, w.Save(raftpb.HardState{Term: 1, Commit: i}, []raftpb.Entry{{Index: i, Term: 1}}))
+		if i%5 == 0 {
+			require.NoError(t, w.SaveSnapshot(walpb.Snapshot{Index: i, Term: 1, ConfState: &confState}))
+		}
+	}
+	require.NoError(t, w.Close())
+	// the snapshot file at index 10 is still referenced
+	require.NoError(t, os.WriteFile(filepath.Join(snapDir, fmt.Sprintf("%016x-%016x.snap", 1, 10)), nil, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(snapDir, fmt.Sprintf("%016x-%016x.snap.broken", 1, 15)), nil, 0o600))
+
+	dropped, err := PurgeObsoleteSnapshotRecords(lg, p, snapDir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 4, dropped)
+
+	recs, err := SnapshotRecords(lg, p)
+	require.NoError(t, err)
+	require.Len(t, recs, 3)
+	assert.Equal(t, uint64(10), recs[0].Snapshot.Index)
+	assert.Equal(t, uint64(25), recs[1].Snapshot.Index)
+
+	w, err = Open(lg, p, walpb.Snapshot{Index: 10, Term: 1})
+	require.NoError(t, err)
+	defer w.Close()
+	_, _, ents, err := w.ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, ents, 20)
+}
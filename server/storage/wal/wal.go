@@ -24,6 +24,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -90,6 +91,10 @@ type WAL struct {
 	enti    uint64   // index of the last entry saved to the wal
 	encoder *encoder // encoder to encode records
 
+	// syncedi is the index of the last entry known to be synced to disk.
+	// It is accessed atomically so that it can be read without holding mu.
+	syncedi atomic.Uint64
+
 	locks []*fileutil.LockedFile // the locked files the WAL holds (the name is increasing)
 	fp    *filePipeline
 }
@@ -315,8 +320,9 @@ func (w *WAL) renameWALUnlock(tmpdirpath string) (*WAL, error) {
 // The returned WAL is ready to read and the first record will be the one after
 // the given snap. The WAL cannot be appended to before reading out all of its
 // previous records.
-func Open(lg *zap.Logger, dirpath string, snap walpb.Snapshot) (*WAL, error) {
-	w, err := openAtIndex(lg, dirpath, snap, true)
+// The given ReadOptions only affect how the existing records are read.
+func Open(lg *zap.Logger, dirpath string, snap walpb.Snapshot, opts ...ReadOption) (*WAL, error) {
+	w, err := openAtIndex(lg, dirpath, snap, true, newReadOptions(opts...))
 	if err != nil {
 		return nil, fmt.Errorf("openAtIndex failed: %w", err)
 	}
@@ -328,11 +334,11 @@ func Open(lg *zap.Logger, dirpath string, snap walpb.Snapshot) (*WAL, error) {
 
 // OpenForRead only opens the wal files for read.
 // Write on a read only wal panics.
-func OpenForRead(lg *zap.Logger, dirpath string, snap walpb.Snapshot) (*WAL, error) {
-	return openAtIndex(lg, dirpath, snap, false)
+func OpenForRead(lg *zap.Logger, dirpath string, snap walpb.Snapshot, opts ...ReadOption) (*WAL, error) {
+	return openAtIndex(lg, dirpath, snap, false, newReadOptions(opts...))
 }
 
-func openAtIndex(lg *zap.Logger, dirpath string, snap walpb.Snapshot, write bool) (*WAL, error) {
+func openAtIndex(lg *zap.Logger, dirpath string, snap walpb.Snapshot, write bool, ro readOptions) (*WAL, error) {
 	if lg == nil {
 		lg = zap.NewNop()
 	}
@@ -341,7 +347,7 @@ func openAtIndex(lg *zap.Logger, dirpath string, snap walpb.Snapshot, write bool
 		return nil, fmt.Errorf("[openAtIndex] selectWALFiles failed: %w", err)
 	}
 
-	rs, ls, closer, err := openWALFiles(lg, dirpath, names, nameIndex, write)
+	rs, ls, closer, err := openWALFiles(lg, dirpath, names, nameIndex, write, ro)
 	if err != nil {
 		return nil, fmt.Errorf("[openAtIndex] openWALFiles failed: %w", err)
 	}
@@ -351,7 +357,7 @@ func openAtIndex(lg *zap.Logger, dirpath string, snap walpb.Snapshot, write bool
 		lg:        lg,
 		dir:       dirpath,
 		start:     snap,
-		decoder:   NewDecoder(rs...),
+		decoder:   newDecoder(ro.bufferSizeBytes, false, rs...),
 		readClose: closer,
 		locks:     ls,
 	}
@@ -359,7 +365,7 @@ func openAtIndex(lg *zap.Logger, dirpath string, snap walpb.Snapshot, write bool
 	if write {
 		// write reuses the file descriptors from read; don't close so
 		// WAL can append without dropping the file lock
-		w.readClose = nil
+		w.readClose = unmapAll(lg, rs)
 		if _, _, err := parseWALName(filepath.Base(w.tail().Name())); err != nil {
 			closer()
 			return nil, fmt.Errorf("[openAtIndex] parseWALName failed: %w", err)
@@ -388,11 +394,11 @@ func selectWALFiles(lg *zap.Logger, dirpath string, snap walpb.Snapshot) ([]stri
 	return names, nameIndex, nil
 }
 
-func openWALFiles(lg *zap.Logger, dirpath string, names []string, nameIndex int, write bool) ([]fileutil.FileReader, []*fileutil.LockedFile, func() error, error) {
+func openWALFiles(lg *zap.Logger, dirpath string, names []string, nameIndex int, write bool, ro readOptions) ([]fileutil.FileReader, []*fileutil.LockedFile, func() error, error) {
 	rcs := make([]io.ReadCloser, 0)
 	rs := make([]fileutil.FileReader, 0)
 	ls := make([]*fileutil.LockedFile, 0)
-	for _, name := range names[nameIndex:] {
+	for i, name := range names[nameIndex:] {
 		p := filepath.Join(dirpath, name)
 		var f *os.File
 		if write {
@@ -414,11 +420,23 @@ func openWALFiles(lg *zap.Logger, dirpath string, names []string, nameIndex int,
 			rcs = append(rcs, rf)
 			f = rf
 		}
-		fileReader := fileutil.NewFileReader(f)
+		var fileReader fileutil.FileReader = fileutil.NewFileReader(f)
+		// only sealed segments are mapped; the tail may still grow
+		if ro.mmap && nameIndex+i < len(names)-1 {
+			if m := newMmapReader(lg, f); m != nil {
+				fileReader = m
+			}
+		}
 		rs = append(rs, fileReader)
 	}
 
-	closer := func() error { return closeAll(lg, rcs...) }
+	unmap := unmapAll(lg, rs)
+	closer := func() error {
+		if unmap != nil {
+			unmap()
+		}
+		return closeAll(lg, rcs...)
+	}
 
 	return rs, ls, closer, nil
 }
@@ -557,6 +575,8 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 		}
 	}
 	w.decoder = nil
+	// records read back from disk are considered synced
+	w.syncedi.Store(w.enti)
 
 	return metadata, state, ents, err
 }
@@ -576,7 +596,7 @@ func ValidSnapshotEntries(lg *zap.Logger, walDir string) ([]walpb.Snapshot, erro
 
 	// open wal files in read mode, so that there is no conflict
 	// when the same WAL is opened elsewhere in write mode
-	rs, _, closer, err := openWALFiles(lg, walDir, names, 0, false)
+	rs, _, closer, err := openWALFiles(lg, walDir, names, 0, false, newReadOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -650,7 +670,7 @@ func Verify(lg *zap.Logger, walDir string, snap walpb.Snapshot) (*raftpb.HardSta
 
 	// open wal files in read mode, so that there is no conflict
 	// when the same WAL is opened elsewhere in write mode
-	rs, _, closer, err := openWALFiles(lg, walDir, names, nameIndex, false)
+	rs, _, closer, err := openWALFiles(lg, walDir, names, nameIndex, false, newReadOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -798,6 +818,7 @@ func (w *WAL) cut() error {
 }
 
 func (w *WAL) sync() error {
+	enti := w.enti
 	if w.encoder != nil {
 		if err := w.encoder.flush(); err != nil {
 			return err
@@ -805,11 +826,15 @@ func (w *WAL) sync() error {
 	}
 
 	if w.unsafeNoSync {
+		w.syncedi.Store(enti)
 		return nil
 	}
 
 	start := time.Now()
 	err := fileutil.Fdatasync(w.tail().File)
+	if err == nil {
+		w.syncedi.Store(enti)
+	}
 
 	took := time.Since(start)
 	if took > warnSyncDuration {
@@ -825,9 +850,21 @@ func (w *WAL) sync() error {
 }
 
 func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.sync()
 }
 
+// LastSyncedIndex returns the index of the last entry whose record has been
+// fdatasync'd to disk. Entries saved with Save are not durable until this
+// index reaches them, which allows callers that persist raft entries
+// asynchronously to acknowledge persistence precisely.
+// If fsync is disabled with SetUnsafeNoFsync, flushed entries are reported
+// as synced.
+func (w *WAL) LastSyncedIndex() uint64 {
+	return w.syncedi.Load()
+}
+
 // ReleaseLockTo releases the locks, which has smaller index than the given index
 // except the largest one among them.
 // For example, if WAL is holding lock 1,2,3,4,5,6, ReleaseLockTo(4) will release
@@ -885,6 +922,11 @@ func (w *WAL) Close() error {
 		w.fp = nil
 	}
 
+	if w.readClose != nil {
+		w.readClose()
+		w.readClose = nil
+	}
+
 	if w.tail() != nil {
 		if err := w.sync(); err != nil {
 			return err
@@ -903,16 +945,37 @@ func (w *WAL) Close() error {
 }
 
 func (w *WAL) saveEntry(e *raftpb.Entry) error {
-	// TODO: add MustMarshalTo to reduce one allocation.
-	b := pbutil.MustMarshal(e)
-	rec := &walpb.Record{Type: EntryType, Data: b}
-	if err := w.encoder.encode(rec); err != nil {
+	// marshal through the encoder's scratch buffer rather than allocating
+	// the entry data for every record.
+	if err := w.encoder.encodeEntries([]raftpb.Entry{*e}); err != nil {
 		return err
 	}
+	w.unsyncFrom(e.Index)
 	w.enti = e.Index
 	return nil
 }
 
+// saveEntries saves ents through the batched encoder path.
+func (w *WAL) saveEntries(ents []raftpb.Entry) error {
+	if len(ents) == 0 {
+		return nil
+	}
+	if err := w.encoder.encodeEntries(ents); err != nil {
+		return err
+	}
+	w.unsyncFrom(ents[0].Index)
+	w.enti = ents[len(ents)-1].Index
+	return nil
+}
+
+// unsyncFrom lowers syncedi below index when the entries from index on
+// overwrite a conflicting tail of the log that was already synced.
+func (w *WAL) unsyncFrom(index uint64) {
+	if index <= w.syncedi.Load() {
+		w.syncedi.Store(index - 1)
+	}
+}
+
 func (w *WAL) saveState(s *raftpb.HardState) error {
 	if raft.IsEmptyHardState(*s) {
 		return nil
@@ -934,11 +997,8 @@ func (w *WAL) Save(st raftpb.HardState, ents []raftpb.Entry) error {
 
 	mustSync := raft.MustSync(st, w.state, len(ents))
 
-	// TODO(xiangli): no more reference operator
-	for i := range ents {
-		if err := w.saveEntry(&ents[i]); err != nil {
-			return err
-		}
+	if err := w.saveEntries(ents); err != nil {
+		return err
 	}
 	if err := w.saveState(&st); err != nil {
 		return err
@@ -1005,6 +1065,21 @@ func (w *WAL) seq() uint64 {
 	return seq
 }
 
+// unmapAll returns a function releasing the memory mappings among rs, or nil
+// if none of them is mapped.
+func unmapAll(lg *zap.Logger, rs []fileutil.FileReader) func() error {
+	var mms []io.ReadCloser
+	for _, r := range rs {
+		if m, ok := r.(*mmapReader); ok {
+			mms = append(mms, m)
+		}
+	}
+	if len(mms) == 0 {
+		return nil
+	}
+	return func() error { return closeAll(lg, mms...) }
+}
+
 func closeAll(lg *zap.Logger, rcs ...io.ReadCloser) error {
 	stringArr := make([]string, 0)
 	for _, f := range rcs {
@@ -48,6 +48,7 @@ func benchmarkWriteEntry(b *testing.B, size int, batch int) {
 	e := &raftpb.Entry{Data: data}
 
 	b.ResetTimer()
+	b.ReportAllocs()
 	n := 0
 	b.SetBytes(int64(e.Size()))
 	for i := 0; i < b.N; i++ {
@@ -62,3 +63,36 @@ func benchmarkWriteEntry(b *testing.B, size int, batch int) {
 		}
 	}
 }
+
+func BenchmarkSave100EntryBatch100(b *testing.B)   { benchmarkSaveEntries(b, 100, 100) }
+func BenchmarkSave1000EntryBatch100(b *testing.B)  { benchmarkSaveEntries(b, 1000, 100) }
+func BenchmarkSave1000EntryBatch1000(b *testing.B) { benchmarkSaveEntries(b, 1000, 1000) }
+
+func benchmarkSaveEntries(b *testing.B, size int, batch int) {
+	p := b.TempDir()
+
+	w, err := Create(zaptest.NewLogger(b), p, []byte("somedata"))
+	if err != nil {
+		b.Fatalf("err = %v, want nil", err)
+	}
+	w.SetUnsafeNoFsync()
+	data := make([]byte, size)
+	ents := make([]raftpb.Entry, batch)
+	for i := range ents {
+		ents[i] = raftpb.Entry{Term: 1, Data: data}
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.SetBytes(int64(ents[0].Size() * batch))
+	index := uint64(0)
+	for i := 0; i < b.N; i++ {
+		for j := range ents {
+			index++
+			ents[j].Index = index
+		}
+		if err := w.saveEntries(ents); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
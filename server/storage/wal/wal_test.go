@@ -1145,3 +1145,99 @@ func TestLastRecordLengthExceedFileEnd(t *testing.T) {
 	// environment, but only once.
 	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 }
+
+func TestOpenWithReadOptions(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	p := t.TempDir()
+
+	restoreLater := SegmentSizeBytes
+	SegmentSizeBytes = 4 * 1024
+	defer func() { SegmentSizeBytes = restoreLater }()
+
+	w, err := Create(lg, p, []byte("metadata"))
+	require.NoError(t, err)
+	data := make([]byte, 300)
+	for i := uint64(1); i <= 40; i++ {
+		require.NoError(t, w.Save(raftpb.HardState{Term: 1, Commit: i}, []raftpb.Entry{{Index: i, Term: 1, Data: data}}))
+	}
+	require.NoError(t, w.Close())
+
+	names, err := readWALNames(lg, p)
+	require.NoError(t, err)
+	require.Greater(t, len(names), 2)
+
+	w, err = OpenForRead(lg, p, walpb.Snapshot{})
+	require.NoError(t, err)
+	wantMeta, wantState, wantEnts, err := w.ReadAll()
+	require.NoError(t, err)
+	w.Close()
+
+	tests := []struct {
+		name string
+		opts []ReadOption
+	}{
+		{"buffer", []ReadOption{WithReadBufferSize(1024 * 1024)}},
+		{"mmap", []ReadOption{WithMmapRead()}},
+		{"mmap-buffer", []ReadOption{WithMmapRead(), WithReadBufferSize(64 * 1024)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := OpenForRead(lg, p, walpb.Snapshot{}, tt.opts...)
+			require.NoError(t, err)
+			meta, state, ents, err := w.ReadAll()
+			require.NoError(t, err)
+			w.Close()
+			assert.Equal(t, wantMeta, meta)
+			assert.Equal(t, wantState, state)
+			assert.Equal(t, wantEnts, ents)
+
+			// replay in write mode and make sure the WAL remains appendable
+			w, err = Open(lg, p, walpb.Snapshot{}, tt.opts...)
+			require.NoError(t, err)
+			_, state, ents, err = w.ReadAll()
+			require.NoError(t, err)
+			assert.Equal(t, wantState, state)
+			assert.Equal(t, wantEnts, ents)
+			require.NoError(t, w.Save(raftpb.HardState{Term: 1, Commit: wantState.Commit}, nil))
+			require.NoError(t, w.Close())
+		})
+	}
+}
+
+func TestLastSyncedIndex(t *testing.T) {
+	lg := zaptest.NewLogger(t)
+	p := t.TempDir()
+
+	w, err := Create(lg, p, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), w.LastSyncedIndex())
+
+	require.NoError(t, w.Save(raftpb.HardState{Term: 1, Commit: 1}, []raftpb.Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1}, {Index: 3, Term: 1}}))
+	assert.Equal(t, uint64(3), w.LastSyncedIndex())
+
+	// entries appended without a sync are not reported as synced
+	w.mu.Lock()
+	require.NoError(t, w.saveEntries([]raftpb.Entry{{Index: 4, Term: 1}, {Index: 5, Term: 1}}))
+	w.mu.Unlock()
+	assert.Equal(t, uint64(3), w.LastSyncedIndex())
+
+	require.NoError(t, w.Sync())
+	assert.Equal(t, uint64(5), w.LastSyncedIndex())
+
+	// entries overwriting a synced tail are not synced until the next sync
+	w.mu.Lock()
+	require.NoError(t, w.saveEntries([]raftpb.Entry{{Index: 4, Term: 2}}))
+	w.mu.Unlock()
+	assert.Equal(t, uint64(3), w.LastSyncedIndex())
+
+	require.NoError(t, w.Sync())
+	assert.Equal(t, uint64(4), w.LastSyncedIndex())
+	require.NoError(t, w.Close())
+
+	w, err = Open(lg, p, walpb.Snapshot{})
+	require.NoError(t, err)
+	defer w.Close()
+	_, _, _, err = w.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), w.LastSyncedIndex())
+}